@@ -693,6 +693,8 @@ ${STORAGE_ACCOUNTS_YAML}
       patterns:
         - "*.yaml"
         - "*.yml"
+        - "*.toml"
+        - "*.ini"
 
     database:
       path: "/data/toggle-vault.db"
@@ -812,13 +814,13 @@ spec:
               memory: "256Mi"
           livenessProbe:
             httpGet:
-              path: /api/health
+              path: /api/v1/health
               port: 8080
             initialDelaySeconds: 10
             periodSeconds: 30
           readinessProbe:
             httpGet:
-              path: /api/health
+              path: /api/v1/health
               port: 8080
             initialDelaySeconds: 5
             periodSeconds: 10
@@ -924,7 +926,7 @@ print_summary() {
     echo ""
     echo "----------------------------------------------"
     echo "Application URL:    http://${EXTERNAL_IP}:8080"
-    echo "Health Check:       http://${EXTERNAL_IP}:8080/api/health"
+    echo "Health Check:       http://${EXTERNAL_IP}:8080/api/v1/health"
     echo "----------------------------------------------"
     echo ""
     echo "Useful Commands:"
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+// healthcheck asks the server running on this host whether it is ready, for
+// container health checks in images without curl. It returns the exit code:
+// 0 if the server is ready, 1 otherwise.
+func healthcheck(args []string) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to the server's configuration file")
+	lenient := flags.Bool("lenient", false, "Ignore unknown keys in the configuration file")
+	var settings settingFlags
+	flags.Var(&settings, "set", "Override a setting, as key=value (repeatable)")
+	timeout := flags.Duration("timeout", 2*time.Second, "How long to wait for the server")
+	flags.Parse(args)
+
+	var cfg *config.Config
+	var err error
+	if *lenient {
+		cfg, _, err = config.LoadLenient(*configPath, settings...)
+	} else {
+		cfg, err = config.Load(*configPath, settings...)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, url := readyzTarget(cfg.Server)
+	client.Timeout = *timeout
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not ready: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Not ready: %s returned %s\n", url, resp.Status)
+		return 1
+	}
+	return 0
+}
+
+// readyzTarget returns a client and the URL of the readiness endpoint of the
+// server configured by cfg, reached over loopback or its Unix socket
+func readyzTarget(cfg config.ServerConfig) (*http.Client, string) {
+	path := cfg.BasePath + "/readyz"
+	if cfg.Socket != "" {
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", cfg.Socket)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://localhost" + path
+	}
+
+	host := cfg.Host
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		// Listening on all addresses includes loopback
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return &http.Client{}, "http://" + net.JoinHostPort(host, strconv.Itoa(cfg.Port)) + path
+}
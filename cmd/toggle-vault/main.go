@@ -2,35 +2,127 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	// reports.timezone works without a time zone database in the image
+	_ "time/tzdata"
 
 	"github.com/toggle-vault/internal/api"
+	"github.com/toggle-vault/internal/approval"
 	"github.com/toggle-vault/internal/blob"
 	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/discovery"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/impact"
+	"github.com/toggle-vault/internal/integrity"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/notify"
+	"github.com/toggle-vault/internal/owners"
+	"github.com/toggle-vault/internal/replica"
+	runtimesettings "github.com/toggle-vault/internal/settings"
 	"github.com/toggle-vault/internal/store"
 	"github.com/toggle-vault/internal/syncer"
+	"github.com/toggle-vault/internal/version"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "config.yaml", "Path to configuration file")
+	dryRun := flag.Bool("dry-run", false, "List and diff blobs without recording versions (overrides sync.dry_run)")
+	lenient := flag.Bool("lenient", false, "Ignore unknown keys in the configuration file instead of failing")
+	var settings settingFlags
+	flag.Var(&settings, "set", "Override a setting, as key=value such as sync.interval=10s (repeatable)")
+	serviceCommand := flag.String("service", "", "Manage the Windows service: install, uninstall, start or stop")
+	showVersion := flag.Bool("version", false, "Print the version and exit")
 	flag.Parse()
 
+	if *showVersion {
+		info := version.Get()
+		fmt.Printf("toggle-vault %s %s %s\n", info, info.GoVersion, info.Platform)
+		return
+	}
+
+	if *serviceCommand != "" {
+		// The service runs with the same flags
+		var runFlags []string
+		if *dryRun {
+			runFlags = append(runFlags, "-dry-run")
+		}
+		if *lenient {
+			runFlags = append(runFlags, "-lenient")
+		}
+		for _, setting := range settings {
+			runFlags = append(runFlags, "-set", setting)
+		}
+		if err := controlService(*serviceCommand, *configPath, runFlags); err != nil {
+			log.Fatalf("Failed to %s service: %v", *serviceCommand, err)
+		}
+		return
+	}
+
+	// Started by the Windows service manager, which stops the service
+	// instead of sending signals
+	isService, err := runService(*configPath, func(ctx context.Context) {
+		run(ctx, *configPath, settings, *dryRun, *lenient)
+	})
+	if err != nil {
+		log.Fatalf("Failed to run as a service: %v", err)
+	}
+	if isService {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	run(ctx, *configPath, settings, *dryRun, *lenient)
+}
+
+// run starts toggle-vault and serves the API until ctx is canceled
+func run(ctx context.Context, configPath string, settings []string, dryRun, lenient bool) {
 	// Load configuration
-	cfg, err := config.Load(*configPath)
+	cfg, err := loadConfig(configPath, settings, lenient)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if dryRun {
+		cfg.Sync.DryRun = true
+	}
 
-	log.Printf("Toggle Vault starting...")
+	log.Printf("Toggle Vault %s starting...", version.Get())
 	log.Printf("Storage Account: %s, Container: %s", cfg.Azure.StorageAccount, cfg.Azure.Container)
 
+	// Initialize Azure Blob client
+	blobClient, err := blob.NewClient(cfg.Azure)
+	if err != nil {
+		log.Fatalf("Failed to initialize Azure Blob client: %v", err)
+	}
+
+	log.Printf("Azure Blob client initialized")
+
+	// A database lost with its disk comes back from the replica
+	if cfg.Database.Replica != "" {
+		restored, err := replica.Restore(ctx, blobClient, cfg.Database.Replica, cfg.Database.Path)
+		if err != nil {
+			log.Fatalf("Failed to restore database from %s: %v", cfg.Database.Replica, err)
+		}
+		if restored {
+			log.Printf("Restored database from %s", cfg.Database.Replica)
+		}
+	}
+
 	// Initialize SQLite store
 	db, err := store.NewSQLiteStore(cfg.Database.Path)
 	if err != nil {
@@ -40,51 +132,270 @@ func main() {
 
 	log.Printf("Database initialized at %s", cfg.Database.Path)
 
-	// Initialize Azure Blob client
-	blobClient, err := blob.NewClient(cfg.Azure)
+	for _, name := range cfg.ChangeTypes {
+		store.RegisterChangeType(store.ChangeType(name))
+	}
+
+	if cfg.Database.Replica != "" {
+		replicator := replica.New(db, blobClient, cfg.Database.Replica, cfg.Database.ReplicaInterval)
+		replicator.Start(ctx)
+		defer replicator.Stop()
+		log.Printf("Replicating database to %s every %s", cfg.Database.Replica, cfg.Database.ReplicaInterval)
+	}
+
+	if cfg.Database.AppendOnly {
+		if err := db.EnableAppendOnly(); err != nil {
+			log.Fatalf("Failed to make database append-only: %v", err)
+		}
+	}
+	if db.AppendOnly() {
+		log.Printf("Append-only mode: recorded history cannot be deleted or changed")
+	}
+
+	// Move history recorded under the old names of renamed storage accounts
+	for from, to := range cfg.Azure.AccountAliases {
+		rename, err := db.RenameStorageAccount(from, to)
+		if err != nil {
+			log.Fatalf("Failed to apply account alias: %v", err)
+		}
+		if rename.Files > 0 {
+			log.Printf("Moved %d files from storage account %s to %s", rename.Files, from, to)
+		}
+		for _, path := range rename.Conflicts {
+			log.Printf("Warning: %s was not moved, %s already has a file at its path", path, to)
+		}
+	}
+
+	// Sign new versions so changes to the database can be detected
+	signer, err := integrity.LoadSigner(context.Background(), cfg.Integrity, cfg.Azure.AuthConfig)
 	if err != nil {
-		log.Fatalf("Failed to initialize Azure Blob client: %v", err)
+		log.Fatalf("Failed to load signing key: %v", err)
+	}
+	if signer != nil {
+		db.SetSigner(signer)
+		log.Printf("Signing versions with %s", signer)
 	}
 
-	log.Printf("Azure Blob client initialized")
+	// Event broker shared by the syncer and the API's live event stream
+	broker := events.NewBroker()
+
+	// Compiled-in plugins plus any external command hooks from the config
+	hookRegistry := hooks.Builtin()
+	for _, hookCfg := range cfg.Hooks {
+		hookRegistry.Add(hooks.NewCommandHook(hookCfg))
+	}
+	if hookRegistry.Len() > 0 {
+		log.Printf("Loaded %d version hooks", hookRegistry.Len())
+	}
+	for i, group := range cfg.Sync.PatternGroups {
+		for _, name := range group.Hooks {
+			if !hookRegistry.Has(name) {
+				log.Fatalf("Invalid configuration: sync.pattern_groups[%d]: no hook named %q", i, name)
+			}
+		}
+	}
+
+	// Decrypt SOPS and age files to compare them, if a key is configured
+	decrypter, err := encryption.Load(context.Background(), cfg.Encryption, cfg.Azure.AuthConfig)
+	if err != nil {
+		log.Fatalf("Failed to load decryption key: %v", err)
+	}
+	if decrypter != nil {
+		log.Printf("Decrypting encrypted files with the key from %s", decrypter)
+	}
 
 	// Initialize syncer
-	syncService := syncer.New(blobClient, db, cfg.Sync)
+	syncService := syncer.New(blobClient, db, cfg.Sync, broker, hookRegistry)
+	syncService.SetDecrypter(decrypter)
 
-	// Create context for graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
+	// Stops the background services when run returns
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	// Start syncer in background
-	go syncService.Start(ctx)
+	// Switch optional behaviors from the settings file as it changes
+	var settingsWatcher *runtimesettings.Watcher
+	if cfg.SettingsFile != "" {
+		settingsWatcher = runtimesettings.New(cfg.SettingsFile, db)
+		settingsWatcher.Start(ctx, broker)
+		log.Printf("Watching settings file %s", cfg.SettingsFile)
+	}
+
+	// Deliver change notifications to configured notifiers
+	dispatcher, err := notify.NewDispatcher(cfg.Notifiers)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifiers: %v", err)
+	}
+	if settingsWatcher != nil {
+		dispatcher.SetEnabled(func() bool { return settingsWatcher.Get().Notifications })
+	}
+	dispatcher.Add(notify.NewInboxNotifier(db), notify.Filter{})
+	dispatcher.SetPatternGroups(cfg.Sync.PatternGroups)
+	ownerResolver := owners.New(cfg.Owners, db)
+	if ownerResolver.Enabled() {
+		dispatcher.SetOwners(ownerResolver)
+	}
+	if cfg.Email.Enabled() {
+		emailNotifier := notify.NewEmailNotifier(cfg.Email, db)
+		emailFilter := notify.Filter{
+			Events: []string{string(events.EventChange), string(events.EventValidationFailed), string(events.EventProposal)},
+		}
+		dispatcher.Add(emailNotifier, emailFilter)
+		if cfg.Owners.Notify {
+			dispatcher.Add(notify.NewOwnerNotifier(emailNotifier, ownerResolver), emailFilter)
+			log.Printf("Notifying file owners by e-mail")
+		}
+		emailNotifier.StartDigests(ctx)
+		log.Printf("E-mail notifications enabled via %s:%d", cfg.Email.SMTPHost, cfg.Email.SMTPPort)
+	}
+	syncService.SetNotified(func(change store.ChangeEvent) bool {
+		return dispatcher.Notifies(notify.Notification{Event: events.EventChange, BlobPath: change.BlobPath, Change: &change})
+	})
+	if dispatcher.Len() > 0 {
+		dispatcher.Start(ctx, broker)
+		log.Printf("Started %d notifiers", dispatcher.Len())
+	}
+
+	// Measure the configured metrics around each change
+	if cfg.Impact.Enabled() {
+		measurer, err := impact.New(cfg.Impact, cfg.Azure.AuthConfig, db)
+		if err != nil {
+			log.Fatalf("Failed to initialize impact measuring: %v", err)
+		}
+		measurer.Start(ctx, broker)
+		log.Printf("Measuring %d metrics around changes from %s", len(cfg.Impact.Metrics), cfg.Impact.URL)
+	}
+
+	// Register storage accounts found through Azure Resource Manager before
+	// the first sync, then keep looking for new ones. Until a discovery
+	// succeeds, the files of accounts it would register aren't taken for
+	// deleted.
+	if len(cfg.Azure.Discovery) > 0 {
+		discoverer, err := discovery.New(cfg.Azure, blobClient)
+		if err != nil {
+			log.Fatalf("Failed to initialize storage account discovery: %v", err)
+		}
+		syncService.SetDiscoveryPending(true)
+		discoverer.OnSuccess(func() { syncService.SetDiscoveryPending(false) })
+		added, err := discoverer.Discover(ctx)
+		if err != nil {
+			log.Printf("Error discovering storage accounts: %v", err)
+		}
+		discoverer.Start(ctx)
+		log.Printf("Discovered %d storage accounts; checking again every %s", added, cfg.Azure.DiscoveryInterval)
+	}
+
+	// Start syncer in background, paused if starting in maintenance mode
+	syncService.SetPaused(cfg.Maintenance.Enabled)
+	syncStopped := make(chan struct{})
+	go func() {
+		defer close(syncStopped)
+		syncService.Start(ctx)
+	}()
 	log.Printf("Syncer started with interval %s", cfg.Sync.Interval)
+	if cfg.Sync.DryRun {
+		log.Printf("Dry-run mode: changes are recorded to the dry-run report only (GET /api/sync/dry-run)")
+	}
+
+	// Edits and restores that need a second user's approval
+	approvals := approval.New(cfg.Approvals, db, syncService, broker)
+	if cfg.Approvals.Required {
+		log.Printf("Approval required for edits and restores")
+	}
+	if cfg.Approvals.GitHub.Enabled() {
+		approvals.StartPolling(ctx)
+		log.Printf("Proposals reviewed as pull requests on %s", cfg.Approvals.GitHub.Repo)
+	}
+
+	// Long API operations run as background jobs
+	jobRunner := jobs.New(cfg.Jobs, db)
+	jobRunner.Start(ctx)
 
 	// Initialize and start API server
-	server := api.NewServer(cfg.Server, db, blobClient)
+	server := api.NewServer(cfg, db, blobClient, broker, syncService, approvals, jobRunner, settingsWatcher, signer, decrypter)
 
-	// Setup graceful shutdown
+	// Setup graceful shutdown: open requests, the sync cycle and running jobs
+	// get until the timeout to finish before the database is closed, and
+	// the callbacks of finished jobs up to jobs.CallbackTimeout more
+	shutdownDone := make(chan struct{})
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-
-		log.Println("Shutdown signal received, stopping services...")
-		cancel()
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("Shutdown requested, stopping services...")
 
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer shutdownCancel()
+		jobsCtx, jobsCancel := context.WithTimeout(context.Background(), 10*time.Second+jobs.CallbackTimeout)
+		defer jobsCancel()
 
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Printf("Error during server shutdown: %v", err)
 		}
+		waitStopped(shutdownCtx, "syncer", syncStopped)
+		waitStopped(jobsCtx, "job runner", jobRunner.Stopped())
 	}()
 
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Starting web server on http://%s", addr)
+	listener, addr, err := server.Listen()
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("Starting web server on %s", addr)
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if adminAddr, err := server.ServeAdmin(); err != nil {
+		log.Fatalf("Failed to listen on the admin address: %v", err)
+	} else if adminAddr != "" {
+		log.Printf("Serving admin endpoints on %s", adminAddr)
+	}
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
+	// Serve returns as soon as the shutdown begins
+	<-shutdownDone
 
 	log.Println("Toggle Vault stopped")
 }
+
+// waitStopped waits for a background service to return once its context is
+// cancelled, giving up when ctx expires
+func waitStopped(ctx context.Context, name string, stopped <-chan struct{}) {
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("Error during shutdown: %s did not stop in time", name)
+	}
+}
+
+// loadConfig loads the configuration file with settings overridden by the
+// environment and the -set flags. Unknown keys fail the load unless lenient
+// is set, in which case they are logged and ignored.
+func loadConfig(path string, settings []string, lenient bool) (*config.Config, error) {
+	if !lenient {
+		cfg, err := config.Load(path, settings...)
+		var unknown *config.UnknownKeysError
+		if errors.As(err, &unknown) {
+			return nil, fmt.Errorf("%w\nfix or remove them, or run with -lenient to ignore them", err)
+		}
+		return cfg, err
+	}
+
+	cfg, unknown, err := config.LoadLenient(path, settings...)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range unknown {
+		log.Printf("Warning: ignoring config %s", key)
+	}
+	return cfg, nil
+}
+
+// settingFlags collects the values of a repeated -set flag
+type settingFlags []string
+
+func (f *settingFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *settingFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
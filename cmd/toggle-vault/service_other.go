@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// controlService manages the Windows service, which only exists on Windows
+func controlService(command, configPath string, runFlags []string) error {
+	return errors.New("Windows services are only supported on Windows; use a systemd unit or container instead")
+}
+
+// runService reports that the process isn't a Windows service
+func runService(configPath string, run func(ctx context.Context)) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,236 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name toggle-vault is installed under and logs to the
+// event log as
+const serviceName = "toggle-vault"
+
+// controlService installs, uninstalls, starts or stops the Windows service
+func controlService(command, configPath string, runFlags []string) error {
+	switch command {
+	case "install":
+		return installService(configPath, runFlags)
+	case "uninstall":
+		return uninstallService()
+	case "start":
+		return startService()
+	case "stop":
+		return stopService()
+	default:
+		return fmt.Errorf("unknown command %q, expected install, uninstall, start or stop", command)
+	}
+}
+
+// installService registers the running executable as a service that starts
+// with Windows and runs with configPath and runFlags, and registers its event
+// log source
+func installService(configPath string, runFlags []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find executable: %w", err)
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+	args := append([]string{"-config", configPath}, runFlags...)
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Toggle Vault",
+		Description: "Records the version history of configuration files in Azure Blob Storage",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+	return nil
+}
+
+// uninstallService removes the service and its event log source
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		return fmt.Errorf("failed to remove event log source: %w", err)
+	}
+	return nil
+}
+
+// startService asks the service manager to start the service
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	return s.Start()
+}
+
+// stopService stops the service and waits for it to shut down
+func stopService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service did not stop within %s", stopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service: %w", err)
+		}
+	}
+	return nil
+}
+
+// stopTimeout is how long the service gets to shut down, a little more than
+// the server's own shutdown timeout
+const stopTimeout = 15 * time.Second
+
+// runService runs the server under the service manager if it started this
+// process, and reports whether it did. Log output goes to the event log, and
+// relative paths in the config are resolved against its directory, since
+// services start in the system directory.
+func runService(configPath string, run func(ctx context.Context)) (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		defer elog.Close()
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{elog})
+	}
+	if err := os.Chdir(filepath.Dir(configPath)); err != nil {
+		return true, fmt.Errorf("failed to change to config directory: %w", err)
+	}
+
+	return true, svc.Run(serviceName, &service{run: run})
+}
+
+// service adapts run to the service manager's start and stop requests
+type service struct {
+	run func(ctx context.Context)
+}
+
+// Execute runs the server until the service manager stops the service or
+// Windows shuts down
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			// The server stopped without being asked to
+			return false, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(stopTimeout / time.Millisecond)}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// eventLogWriter writes log output to the event log, as errors for lines
+// reporting errors and information otherwise
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var err error
+	switch {
+	case strings.HasPrefix(msg, "Error"), strings.HasPrefix(msg, "Failed"):
+		err = w.elog.Error(1, msg)
+	case strings.HasPrefix(msg, "Warning"):
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
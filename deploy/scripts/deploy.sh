@@ -225,7 +225,7 @@ print_summary() {
     echo ""
     echo "----------------------------------------------"
     echo "Application URL:     http://$EXTERNAL_IP:8080"
-    echo "Health Check:        http://$EXTERNAL_IP:8080/api/health"
+    echo "Health Check:        http://$EXTERNAL_IP:8080/api/v1/health"
     echo "----------------------------------------------"
     echo ""
     echo "To check the status:"
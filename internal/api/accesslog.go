@@ -0,0 +1,117 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/toggle-vault/internal/config"
+)
+
+// redacted replaces secret values in logged URLs
+const redacted = "REDACTED"
+
+// secretParams are query parameters whose values are never logged: the
+// signature of SAS URLs and the usual names of tokens and keys
+var secretParams = map[string]bool{
+	"sig":          true,
+	"signature":    true,
+	"code":         true,
+	"key":          true,
+	"apikey":       true,
+	"api_key":      true,
+	"access_token": true,
+	"token":        true,
+	"password":     true,
+	"secret":       true,
+}
+
+// accessLog logs one line per request with who made it, the route it
+// matched, its status, response size and latency. Secrets in the URL are
+// redacted. Users are those identify worked out. It returns nil when the
+// access log is off.
+func accessLog(format string) func(http.Handler) http.Handler {
+	var handler slog.Handler
+	switch format {
+	case config.AccessLogOff:
+		return nil
+	case config.AccessLogJSON:
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	}
+	logger := slog.New(handler)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("method", r.Method),
+				slog.String("url", redactURL(r.URL)),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("user", requestUser(r)),
+				slog.String("remote", r.RemoteAddr),
+				slog.String("request_id", middleware.GetReqID(r.Context())),
+			)
+		})
+	}
+}
+
+// redactURL returns u as a string with the values of secret query
+// parameters, and the view tokens of shared links, replaced
+func redactURL(u *url.URL) string {
+	path := u.Path
+	if rest, ok := strings.CutPrefix(path, "/share/"); ok {
+		path = "/share/" + redacted
+		if i := strings.Index(rest, "/"); i >= 0 {
+			path += rest[i:]
+		}
+	}
+	if u.RawQuery == "" {
+		return path
+	}
+	query := u.Query()
+	for name, values := range query {
+		if !isSecretParam(name) {
+			continue
+		}
+		for i := range values {
+			values[i] = redacted
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
+// isSecretParam reports whether a query parameter carries a secret
+func isSecretParam(name string) bool {
+	name = strings.ToLower(name)
+	return secretParams[name] || strings.Contains(name, "token") ||
+		strings.Contains(name, "secret") || strings.Contains(name, "password")
+}
+
+// requestUser returns the caller identify worked out, or "" for anonymous
+// requests
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/syncer"
+)
+
+// runtimeStats is the response of the runtime diagnostics endpoint
+type runtimeStats struct {
+	Goroutines int           `json:"goroutines"`
+	Memory     memoryStats   `json:"memory"`
+	Syncer     syncer.Status `json:"syncer"`
+}
+
+// memoryStats is a subset of runtime.MemStats, in bytes unless noted
+type memoryStats struct {
+	HeapAlloc    uint64    `json:"heap_alloc"`
+	HeapInuse    uint64    `json:"heap_inuse"`
+	HeapObjects  uint64    `json:"heap_objects"`
+	TotalAlloc   uint64    `json:"total_alloc"`
+	Sys          uint64    `json:"sys"`
+	NumGC        uint32    `json:"num_gc"`
+	LastGC       time.Time `json:"last_gc"`
+	PauseTotalNs uint64    `json:"pause_total_ns"`
+}
+
+// requireAdmin only lets requests carrying the admin bearer token through.
+// Admin endpoints are hidden entirely when no token is configured, and on
+// the main address when there is a separate admin address.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" || (s.admin != nil && !onAdminListener(r)) {
+			http.NotFound(w, r)
+			return
+		}
+		if !s.authorizeAdmin(w, r) {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorizeAdmin reports whether the request carries the admin bearer token,
+// responding with 401 if it doesn't
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.admin != nil && !onAdminListener(r) {
+		respondError(w, http.StatusForbidden, "Admin requests are only accepted on the admin address")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || s.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondError(w, http.StatusUnauthorized, "Admin token required")
+		return false
+	}
+	return true
+}
+
+// handleRuntimeStats reports goroutine, memory and syncer statistics
+func (s *Server) handleRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := runtimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		Memory: memoryStats{
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			NumGC:        m.NumGC,
+			LastGC:       time.Unix(0, int64(m.LastGC)),
+			PauseTotalNs: m.PauseTotalNs,
+		},
+	}
+	if s.syncer != nil {
+		stats.Syncer = s.syncer.Status()
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	const token = "admin-token-0123456789"
+
+	tests := []struct {
+		name          string
+		adminToken    string
+		adminAddress  bool
+		onAdminListen bool
+		authorization string
+		wantStatus    int
+	}{
+		{
+			name:          "no token configured",
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:       "missing token",
+			adminToken: token,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong token",
+			adminToken:    token,
+			authorization: "Bearer wrong",
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "token without the Bearer scheme",
+			adminToken:    token,
+			authorization: token,
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "token",
+			adminToken:    token,
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "token on the main address with an admin address",
+			adminToken:    token,
+			adminAddress:  true,
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "token on the admin address",
+			adminToken:    token,
+			adminAddress:  true,
+			onAdminListen: true,
+			authorization: "Bearer " + token,
+			wantStatus:    http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{adminToken: tt.adminToken}
+			if tt.adminAddress {
+				s.admin = &http.Server{}
+			}
+			handler := s.requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/runtime", nil)
+			if tt.onAdminListen {
+				req = req.WithContext(context.WithValue(req.Context(), adminListenerKey{}, true))
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+// defaultActivityLimit is the number of changes an application's activity
+// returns unless a limit is given
+const defaultActivityLimit = 50
+
+// appSummary is an application with the number of tracked files it covers
+type appSummary struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Paths       []string   `json:"paths"`
+	FileCount   int        `json:"file_count"`
+	LastChange  *time.Time `json:"last_change,omitempty"`
+}
+
+// appActivity is the files of an application and their recent changes
+type appActivity struct {
+	Name        string                       `json:"name"`
+	Description string                       `json:"description,omitempty"`
+	Paths       []string                     `json:"paths"`
+	Files       []store.FileWithVersionCount `json:"files"`
+	Changes     []store.ChangeEvent          `json:"changes"`
+}
+
+// handleListApps returns the configured applications
+func (s *Server) handleListApps(w http.ResponseWriter, r *http.Request) {
+	files, err := s.store.ListFiles()
+	if err != nil {
+		log.Printf("Error listing files: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list files")
+		return
+	}
+
+	apps := make([]appSummary, 0, len(s.cfg.Applications))
+	for i := range s.cfg.Applications {
+		app := &s.cfg.Applications[i]
+		summary := appSummary{Name: app.Name, Description: app.Description, Paths: app.Paths}
+		for _, f := range appFiles(app, files) {
+			summary.FileCount++
+			if summary.LastChange == nil || f.LatestChange.After(*summary.LastChange) {
+				latest := f.LatestChange
+				summary.LastChange = &latest
+			}
+		}
+		apps = append(apps, summary)
+	}
+
+	respondJSON(w, http.StatusOK, apps)
+}
+
+// handleAppActivity returns the files of an application and their changes,
+// newest first. It accepts the change_type, since, until and limit filters
+// of the search endpoint.
+func (s *Server) handleAppActivity(w http.ResponseWriter, r *http.Request) {
+	app := s.findApp(chi.URLParam(r, "name"))
+	if app == nil {
+		respondError(w, http.StatusNotFound, "Application not found")
+		return
+	}
+
+	query := store.SearchQuery{
+		PathPrefixes: app.Paths,
+		Limit:        defaultActivityLimit,
+	}
+	if !parseChangeFilters(w, r.URL.Query(), &query) {
+		return
+	}
+
+	files, err := s.store.ListFiles()
+	if err != nil {
+		log.Printf("Error listing files: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list files")
+		return
+	}
+
+	if err := s.labelFiles(files); err != nil {
+		log.Printf("Error listing file labels: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list files")
+		return
+	}
+	s.ownFiles(files)
+
+	changes, err := s.store.SearchChanges(query)
+	if err != nil {
+		log.Printf("Error getting activity of application %s: %v", app.Name, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get application activity")
+		return
+	}
+
+	activity := appActivity{
+		Name:        app.Name,
+		Description: app.Description,
+		Paths:       app.Paths,
+		Files:       appFiles(app, files),
+		Changes:     changes,
+	}
+	if activity.Files == nil {
+		activity.Files = []store.FileWithVersionCount{}
+	}
+	if activity.Changes == nil {
+		activity.Changes = []store.ChangeEvent{}
+	}
+
+	respondJSON(w, http.StatusOK, activity)
+}
+
+// findApp returns the application with the given name, or nil
+func (s *Server) findApp(name string) *config.ApplicationConfig {
+	for i := range s.cfg.Applications {
+		if s.cfg.Applications[i].Name == name {
+			return &s.cfg.Applications[i]
+		}
+	}
+	return nil
+}
+
+// appFiles returns the files that belong to an application
+func appFiles(app *config.ApplicationConfig, files []store.FileWithVersionCount) []store.FileWithVersionCount {
+	var matched []store.FileWithVersionCount
+	for _, f := range files {
+		if app.Matches(f.BlobPath) {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// handleArchive archives a file: its history is kept and stays browsable,
+// but sync cycles skip its blob and no longer record changes or a deletion
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, true)
+}
+
+// handleUnarchive resumes syncing an archived file. The next sync cycle
+// records any change made to its blob while it was archived.
+func (s *Server) handleUnarchive(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, false)
+}
+
+// setArchived archives or unarchives the file in the URL and responds with
+// the updated file. Archiving stops change tracking, so who did it is
+// recorded on the file and in the audit log.
+func (s *Server) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+	if file.IsArchived == archived {
+		if archived {
+			respondError(w, http.StatusConflict, "File is already archived")
+		} else {
+			respondError(w, http.StatusConflict, "File is not archived")
+		}
+		return
+	}
+
+	user := s.currentUser(r)
+	now := time.Now()
+	if err := s.store.SetFileArchived(file.BlobPath, archived, user, now); err != nil {
+		log.Printf("Error setting archived state of %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusInternalServerError, "Failed to update file")
+		return
+	}
+	if archived {
+		log.Printf("Audit: %s archived by %q", file.BlobPath, user)
+		file.ArchivedBy, file.ArchivedAt = user, &now
+	} else {
+		log.Printf("Audit: %s unarchived by %q", file.BlobPath, user)
+		file.ArchivedBy, file.ArchivedAt = "", nil
+	}
+
+	file.IsArchived = archived
+	respondJSON(w, http.StatusOK, file)
+}
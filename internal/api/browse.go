@@ -0,0 +1,87 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// browseFolder is a folder directly under the browsed prefix. Its files are
+// those whose blob path starts with Prefix.
+type browseFolder struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// FileCount is the number of files anywhere below the folder
+	FileCount    int       `json:"file_count"`
+	LatestChange time.Time `json:"latest_change"`
+}
+
+// browseListing is the immediate children of a prefix
+type browseListing struct {
+	Prefix  string                       `json:"prefix"`
+	Folders []browseFolder               `json:"folders"`
+	Files   []store.FileWithVersionCount `json:"files"`
+}
+
+// handleBrowse lists the folders and files directly under a prefix, as in a
+// filesystem. Folders are derived from the blob paths, so the empty prefix
+// lists the storage accounts and account/ lists its containers.
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	files, err := s.store.ListFiles()
+	if err != nil {
+		log.Printf("Error listing files: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list files")
+		return
+	}
+
+	listing := browseListing{
+		Prefix:  prefix,
+		Folders: []browseFolder{},
+		Files:   []store.FileWithVersionCount{},
+	}
+	folders := make(map[string]*browseFolder)
+	for _, f := range files {
+		rest, ok := strings.CutPrefix(f.BlobPath, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+
+		name, _, isFolder := strings.Cut(rest, "/")
+		if !isFolder {
+			listing.Files = append(listing.Files, f)
+			continue
+		}
+		folder, ok := folders[name]
+		if !ok {
+			folder = &browseFolder{Name: name, Prefix: prefix + name + "/"}
+			folders[name] = folder
+		}
+		folder.FileCount++
+		if f.LatestChange.After(folder.LatestChange) {
+			folder.LatestChange = f.LatestChange
+		}
+	}
+
+	// The listing doesn't exist, rather than being empty, if nothing is under it
+	if prefix != "" && len(folders) == 0 && len(listing.Files) == 0 {
+		respondError(w, http.StatusNotFound, "No files under "+prefix)
+		return
+	}
+
+	for _, folder := range folders {
+		listing.Folders = append(listing.Folders, *folder)
+	}
+	sort.Slice(listing.Folders, func(i, j int) bool { return listing.Folders[i].Name < listing.Folders[j].Name })
+	sort.Slice(listing.Files, func(i, j int) bool { return listing.Files[i].BlobPath < listing.Files[j].BlobPath })
+
+	respondJSON(w, http.StatusOK, listing)
+}
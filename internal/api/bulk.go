@@ -0,0 +1,228 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+// maxBulkPaths caps the paths of all operations of a bulk request
+const maxBulkPaths = 10000
+
+// Bulk operations
+const (
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkLabel     = "label"
+	bulkTag       = "tag"
+	bulkResync    = "resync"
+)
+
+// tagPattern restricts version tags to names that are safe in query
+// parameters, like label keys
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+// bulkOperation is one operation of a bulk request, applied to every path
+type bulkOperation struct {
+	Op    string   `json:"op"`
+	Paths []string `json:"paths"`
+	// Labels are set by label operations, in addition to the labels already
+	// set on each file
+	Labels map[string]string `json:"labels,omitempty"`
+	// Tag is added by tag operations to the latest version of each file
+	Tag string `json:"tag,omitempty"`
+}
+
+// bulkResult reports what an operation did
+type bulkResult struct {
+	Op    string `json:"op"`
+	Files int    `json:"files"`
+	// Synced is the outcome of each path of a resync operation
+	Synced []bulkSync `json:"synced,omitempty"`
+}
+
+// bulkSync is the outcome of resyncing one file
+type bulkSync struct {
+	Path string `json:"path"`
+	// VersionID is the version recorded, or 0 if nothing changed
+	VersionID int64  `json:"version_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// handleBulk applies a list of operations to many files at once. Every
+// operation is validated before any is applied, and the archive, unarchive,
+// label and tag operations are applied in one transaction. Resyncs fetch blobs,
+// so they run after that, on the files as the transaction left them, and
+// each one succeeds or fails on its own. With async=true the operations are
+// validated, then applied as a job.
+func (s *Server) handleBulk(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operations []bulkOperation `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Operations) == 0 {
+		respondError(w, http.StatusBadRequest, "operations are required")
+		return
+	}
+
+	var total int
+	for i, op := range req.Operations {
+		name := "operations[" + strconv.Itoa(i) + "]"
+		switch op.Op {
+		case bulkArchive, bulkUnarchive, bulkResync:
+		case bulkLabel:
+			if len(op.Labels) == 0 {
+				respondError(w, http.StatusBadRequest, name+": labels are required")
+				return
+			}
+			for key, value := range op.Labels {
+				if err := config.ValidateLabel(key, value); err != nil {
+					respondError(w, http.StatusBadRequest, name+": "+err.Error())
+					return
+				}
+			}
+		case bulkTag:
+			if !tagPattern.MatchString(op.Tag) {
+				respondError(w, http.StatusBadRequest, name+": invalid tag (letters, digits, '.', '_', '-' and '/', up to 63 characters)")
+				return
+			}
+		default:
+			respondError(w, http.StatusBadRequest, name+": op must be archive, unarchive, label, tag or resync")
+			return
+		}
+		if len(op.Paths) == 0 {
+			respondError(w, http.StatusBadRequest, name+": paths are required")
+			return
+		}
+		if op.Op == bulkResync && s.syncer == nil {
+			respondError(w, http.StatusServiceUnavailable, "Syncing is not available")
+			return
+		}
+		total += len(op.Paths)
+	}
+	if total > maxBulkPaths {
+		respondError(w, http.StatusBadRequest, "A bulk request can name at most "+strconv.Itoa(maxBulkPaths)+" paths")
+		return
+	}
+
+	// Look every file up first, so an unknown path fails the whole request
+	files := make(map[string]*store.File)
+	for _, op := range req.Operations {
+		for _, path := range op.Paths {
+			if _, ok := files[path]; ok {
+				continue
+			}
+			file, err := s.store.GetFile(path)
+			if err != nil {
+				log.Printf("Error getting file %s: %v", path, err)
+				respondError(w, http.StatusInternalServerError, "Failed to get file")
+				return
+			}
+			if file == nil {
+				respondError(w, http.StatusNotFound, "File not found: "+path)
+				return
+			}
+			files[path] = file
+		}
+	}
+
+	user := s.currentUser(r)
+	if s.runAsync(r) {
+		s.submitJob(w, r, jobBulk, true, func(ctx context.Context, progress jobs.Progress) (*jobs.Result, error) {
+			results, err := s.applyBulk(ctx, req.Operations, files, user, progress)
+			if err != nil {
+				return nil, err
+			}
+			return &jobs.Result{Value: map[string]interface{}{"results": results}}, nil
+		})
+		return
+	}
+
+	results, err := s.applyBulk(r.Context(), req.Operations, files, user, nil)
+	if err != nil {
+		log.Printf("Error applying bulk operations: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to apply operations")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// applyBulk applies validated operations to the files they name as user,
+// who is recorded as having archived and tagged them, reporting the progress
+// of resyncs to progress if it isn't nil
+func (s *Server) applyBulk(ctx context.Context, operations []bulkOperation, files map[string]*store.File, user string, progress jobs.Progress) ([]bulkResult, error) {
+	now := time.Now()
+	var updates []store.FileUpdate
+	var resyncs int
+	for _, op := range operations {
+		for _, path := range op.Paths {
+			update := store.FileUpdate{FileID: files[path].ID}
+			switch op.Op {
+			case bulkArchive, bulkUnarchive:
+				archived := op.Op == bulkArchive
+				update.Archived = &archived
+				update.ArchivedBy, update.ArchivedAt = user, now
+				// Resyncs see the state the last of these operations leaves
+				files[path].IsArchived = archived
+			case bulkLabel:
+				update.Labels = op.Labels
+			case bulkTag:
+				update.Tag, update.TaggedBy, update.TaggedAt = op.Tag, user, now
+			default:
+				resyncs++
+				continue
+			}
+			updates = append(updates, update)
+		}
+	}
+	if len(updates) > 0 {
+		if err := s.store.UpdateFiles(updates); err != nil {
+			return nil, err
+		}
+		log.Printf("Audit: bulk operations applied to %d files by %q", len(updates), user)
+	}
+
+	results := make([]bulkResult, len(operations))
+	var synced int
+	for i, op := range operations {
+		results[i] = bulkResult{Op: op.Op, Files: len(op.Paths)}
+		if op.Op != bulkResync {
+			continue
+		}
+
+		results[i].Synced = make([]bulkSync, len(op.Paths))
+		for j, path := range op.Paths {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			result := bulkSync{Path: path}
+			version, err := s.syncer.SyncFile(ctx, files[path])
+			if err != nil {
+				log.Printf("Error syncing %s: %v", path, err)
+				result.Error = err.Error()
+			} else if version != nil {
+				result.VersionID = version.ID
+			}
+			results[i].Synced[j] = result
+
+			synced++
+			if progress != nil {
+				progress(synced, resyncs)
+			}
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestBulkTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		body       string
+		wantStatus int
+		wantTagged bool
+	}{
+		{
+			name:       "tag and archive",
+			user:       "alice",
+			body:       `{"operations": [{"op": "tag", "paths": ["account/container/app.yaml"], "tag": "release-42"}, {"op": "archive", "paths": ["account/container/app.yaml"]}]}`,
+			wantStatus: http.StatusOK,
+			wantTagged: true,
+		},
+		{
+			name:       "no identity",
+			body:       `{"operations": [{"op": "tag", "paths": ["account/container/app.yaml"], "tag": "release-42"}]}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid tag",
+			user:       "alice",
+			body:       `{"operations": [{"op": "tag", "paths": ["account/container/app.yaml"], "tag": "release 42"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			ids := createTestVersions(t, st, "account/container/app.yaml", "a: 1\n", "a: 2\n")
+			latest := ids[len(ids)-1]
+
+			s := &Server{store: st}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/bulk", strings.NewReader(tt.body))
+			if tt.user != "" {
+				req = req.WithContext(context.WithValue(req.Context(), userContextKey{}, tt.user))
+			}
+			rec := httptest.NewRecorder()
+			s.requireUser(http.HandlerFunc(s.handleBulk)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			file, err := st.GetFile("account/container/app.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := st.GetVersionTags(file.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tagged := len(tags[latest]) == 1 && tags[latest][0] == "release-42"; tagged != tt.wantTagged {
+				t.Errorf("tags = %v, want release-42 on version %d: %v", tags, latest, tt.wantTagged)
+			}
+			if len(tags[ids[0]]) != 0 {
+				t.Errorf("earlier version tagged %v", tags[ids[0]])
+			}
+			if !tt.wantTagged {
+				return
+			}
+
+			if file.ArchivedBy != tt.user {
+				t.Errorf("archived by %q, want %q", file.ArchivedBy, tt.user)
+			}
+			changes, err := st.SearchChanges(store.SearchQuery{Tag: "release-42"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 1 || changes[0].VersionID != latest {
+				t.Errorf("changes tagged release-42 = %v, want version %d", changes, latest)
+			}
+		})
+	}
+}
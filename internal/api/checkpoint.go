@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/syncer"
+)
+
+// handleCheckpoint captures a version of a file right now even if its
+// content is unchanged, such as to mark a known-good state before a risky
+// operation. The comment in the body labels the checkpoint.
+func (s *Server) handleCheckpoint(w http.ResponseWriter, r *http.Request) {
+	comment, ok := parseOptionalComment(w, r)
+	if !ok {
+		return
+	}
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+	if file.IsDeleted {
+		respondError(w, http.StatusConflict, "File is deleted")
+		return
+	}
+	if s.syncer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Syncing is not available")
+		return
+	}
+
+	version, err := s.syncer.Checkpoint(r.Context(), file, s.currentUser(r), comment)
+	var rejected *hooks.RejectedError
+	switch {
+	case errors.Is(err, syncer.ErrFileArchived), errors.Is(err, syncer.ErrMetadataOnly), errors.Is(err, syncer.ErrDryRun),
+		errors.Is(err, syncer.ErrSyncPaused):
+		respondError(w, http.StatusConflict, "Cannot checkpoint: "+err.Error())
+		return
+	case errors.As(err, &rejected):
+		respondError(w, http.StatusUnprocessableEntity, rejected.Error())
+		return
+	case blob.IsNotFound(err):
+		respondError(w, http.StatusConflict, "Blob no longer exists; wait for the next sync to record the deletion")
+		return
+	case err != nil:
+		log.Printf("Error checkpointing %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusBadGateway, "Failed to capture file: "+err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, version)
+}
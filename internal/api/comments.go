@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// maxCommentLength bounds the size of a version comment
+const maxCommentLength = 2000
+
+// commentRequest is the body of a request that carries a change comment
+type commentRequest struct {
+	Comment string `json:"comment"`
+}
+
+// parseComment trims a comment and checks its length
+func parseComment(comment string) (string, bool) {
+	comment = strings.TrimSpace(comment)
+	return comment, len(comment) <= maxCommentLength
+}
+
+// requireComment responds with 400 and returns false if content written
+// through the vault comes without a comment explaining the change
+func requireComment(w http.ResponseWriter, comment string) bool {
+	if comment == "" {
+		respondError(w, http.StatusBadRequest, "A change comment is required")
+		return false
+	}
+	return true
+}
+
+// parseOptionalComment reads an optional body carrying a comment, responding
+// with 400 and returning false if it is invalid
+func parseOptionalComment(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+	comment, ok := parseComment(req.Comment)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Comment is too long")
+		return "", false
+	}
+	return comment, true
+}
+
+// handleSetVersionComment adds or replaces the comment on an existing version,
+// so the reason for a change can be recorded after the fact
+func (s *Server) handleSetVersionComment(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	versionID, err := strconv.ParseInt(chi.URLParam(r, "versionID"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return
+	}
+
+	var req commentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	comment, ok := parseComment(req.Comment)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Comment is too long")
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+
+	version, err := s.store.GetVersion(versionID)
+	if err != nil {
+		log.Printf("Error getting version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	if file == nil || version == nil || version.FileID != file.ID {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+
+	err = s.store.SetVersionComment(versionID, comment)
+	if errors.Is(err, store.ErrAppendOnly) {
+		respondError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting comment on version %d: %v", versionID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to set comment")
+		return
+	}
+	version.Comment = comment
+
+	respondJSON(w, http.StatusOK, version)
+}
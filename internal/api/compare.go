@@ -0,0 +1,131 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// comparedFile is a file whose content differed between two times. Its
+// change type is created if it only existed at t2 and deleted if it only
+// existed at t1.
+type comparedFile struct {
+	Path          string           `json:"path"`
+	ChangeType    store.ChangeType `json:"change_type"`
+	FromVersionID int64            `json:"from_version_id,omitempty"`
+	ToVersionID   int64            `json:"to_version_id,omitempty"`
+	FromHash      string           `json:"from_hash,omitempty"`
+	ToHash        string           `json:"to_hash,omitempty"`
+	// DiffURL is the diff between the two versions, for modified files
+	DiffURL string `json:"diff_url,omitempty"`
+}
+
+// comparison is the difference between the files under a prefix at two times
+type comparison struct {
+	Prefix  string                   `json:"prefix"`
+	T1      time.Time                `json:"t1"`
+	T2      time.Time                `json:"t2"`
+	Summary map[store.ChangeType]int `json:"summary"`
+	Files   []comparedFile           `json:"files"`
+}
+
+// handleCompare lists the files under prefix whose content at t2 differs
+// from their content at t1, including files that only existed at one of them
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	t1, ok := parseAtParam(w, r, "t1")
+	if !ok {
+		return
+	}
+	t2, ok := parseAtParam(w, r, "t2")
+	if !ok {
+		return
+	}
+	if !t1.Before(t2) {
+		respondError(w, http.StatusBadRequest, "t1 must be before t2")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	before, err := s.snapshotAt(prefix, t1)
+	if err != nil {
+		log.Printf("Error getting snapshot at %s: %v", t1, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		return
+	}
+	after, err := s.snapshotAt(prefix, t2)
+	if err != nil {
+		log.Printf("Error getting snapshot at %s: %v", t2, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, compareSnapshots(before, after, s.cfg.Server.BasePath))
+}
+
+// compareSnapshots lists the files that differ between two snapshots. Diff
+// URLs are under basePath.
+func compareSnapshots(before, after *snapshot, basePath string) comparison {
+	result := comparison{
+		Prefix: before.Prefix,
+		T1:     before.Time,
+		T2:     after.Time,
+		Summary: map[store.ChangeType]int{
+			store.ChangeTypeCreated:  0,
+			store.ChangeTypeModified: 0,
+			store.ChangeTypeDeleted:  0,
+		},
+		Files: []comparedFile{},
+	}
+
+	old := make(map[string]store.Version, len(before.Files))
+	for _, f := range before.Files {
+		old[f.Path] = f.Version
+	}
+
+	for _, f := range after.Files {
+		from, existed := old[f.Path]
+		delete(old, f.Path)
+
+		switch {
+		case !existed:
+			result.Files = append(result.Files, comparedFile{
+				Path:        f.Path,
+				ChangeType:  store.ChangeTypeCreated,
+				ToVersionID: f.Version.ID,
+				ToHash:      f.Version.ContentHash,
+			})
+		case from.ContentHash != f.Version.ContentHash:
+			result.Files = append(result.Files, comparedFile{
+				Path:          f.Path,
+				ChangeType:    store.ChangeTypeModified,
+				FromVersionID: from.ID,
+				ToVersionID:   f.Version.ID,
+				FromHash:      from.ContentHash,
+				ToHash:        f.Version.ContentHash,
+				DiffURL:       fmt.Sprintf("%s%s/files/%s/diff/%d/%d", basePath, apiPrefix, url.PathEscape(f.Path), from.ID, f.Version.ID),
+			})
+		}
+	}
+
+	for path, from := range old {
+		result.Files = append(result.Files, comparedFile{
+			Path:          path,
+			ChangeType:    store.ChangeTypeDeleted,
+			FromVersionID: from.ID,
+			FromHash:      from.ContentHash,
+		})
+	}
+
+	sort.Slice(result.Files, func(i, j int) bool {
+		return result.Files[i].Path < result.Files[j].Path
+	})
+	for _, f := range result.Files {
+		result.Summary[f.ChangeType]++
+	}
+	return result
+}
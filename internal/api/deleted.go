@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+// handleListDeleted returns the deleted files, most recently deleted first
+func (s *Server) handleListDeleted(w http.ResponseWriter, r *http.Request) {
+	files, err := s.store.ListDeletedFiles()
+	if err != nil {
+		log.Printf("Error listing deleted files: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list deleted files")
+		return
+	}
+
+	if files == nil {
+		files = []store.DeletedFile{}
+	}
+
+	respondJSON(w, http.StatusOK, files)
+}
+
+// handleUndelete restores a deleted file by re-uploading its last version
+// with content. The body may carry a comment explaining the restore.
+func (s *Server) handleUndelete(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	comment, ok := parseOptionalComment(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if !file.IsDeleted {
+		respondError(w, http.StatusConflict, "File is not deleted")
+		return
+	}
+
+	version, err := s.store.GetLastContentVersion(file.ID)
+	if err != nil {
+		log.Printf("Error getting last version of %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	if version == nil {
+		respondError(w, http.StatusConflict, "File has no earlier version with content to restore")
+		return
+	}
+
+	// Don't overwrite a blob that was recreated since the deletion was recorded
+	storageAccount, containerName, blobPath, err := blob.ParseFullPath(path)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid path")
+		return
+	}
+	exists, err := s.blobClient.BlobExists(r.Context(), storageAccount, containerName, blobPath)
+	if err != nil {
+		log.Printf("Error checking whether %s exists: %v", path, err)
+		respondError(w, http.StatusBadGateway, "Failed to check blob storage")
+		return
+	}
+	if exists {
+		respondError(w, http.StatusConflict, "File exists in blob storage again and will be picked up by the next sync")
+		return
+	}
+
+	if comment == "" {
+		comment = fmt.Sprintf("Restored from deletion (version %d)", version.ID)
+	}
+	s.restoreVersion(w, r, path, version, comment)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/toggle-vault/internal/diff"
+)
+
+// defaultHTMLContext is the number of unchanged lines shown around each
+// change in HTML diffs
+const defaultHTMLContext = 3
+
+// handleDiffHTML renders the diff between two versions as standalone HTML
+// with inline styles, for embedding in e-mails and Teams cards. The context
+// parameter sets the number of unchanged lines around each change; -1 shows
+// the whole file. Decrypted values are never included.
+func (s *Server) handleDiffHTML(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+
+	context := defaultHTMLContext
+	if c := r.URL.Query().Get("context"); c != "" {
+		n, err := strconv.Atoi(c)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid context")
+			return
+		}
+		context = n
+	}
+
+	version1, version2, ok := s.loadDiffVersions(w, r)
+	if !ok {
+		return
+	}
+	diffResult, ok := s.compareVersions(w, r, path, version1, version2)
+	if !ok {
+		return
+	}
+
+	opts := diff.HTMLOptions{
+		Title:    path,
+		OldLabel: fmt.Sprintf("v%d", version1.ID),
+		NewLabel: fmt.Sprintf("v%d", version2.ID),
+		Context:  context,
+	}
+	if s.cfg.Email.BaseURL != "" {
+		opts.Link = strings.TrimRight(s.cfg.Email.BaseURL, "/") + "/?q=" + url.QueryEscape(path)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := diff.RenderHTML(w, diffResult, opts); err != nil {
+		log.Printf("Error rendering HTML diff: %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// defaultDiffPageLimit is the number of diff lines in a page when only
+	// an offset is given
+	defaultDiffPageLimit = 1000
+	// maxDiffPageLimit is the largest page of diff lines served
+	maxDiffPageLimit = 10000
+	// diffRetryAfter is how many seconds a client is told to wait for a diff
+	// computed in the background
+	diffRetryAfter = "2"
+)
+
+// diffCache keeps the diffs computed in the background by jobs, and the
+// jobs still computing them, evicting the least recently used beyond its size
+type diffCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*diffEntry
+	// order is the keys of entries, least recently used first
+	order []string
+}
+
+// diffEntry is a diff in the cache. Its result is nil while its job runs.
+type diffEntry struct {
+	jobID  int64
+	result *diff.DiffResult
+}
+
+// newDiffCache returns a cache of up to size diffs
+func newDiffCache(size int) *diffCache {
+	return &diffCache{size: size, entries: make(map[string]*diffEntry)}
+}
+
+// get returns the diff cached under key, or nil while it is computed, and
+// the ID of the job computing it. ok is false if there is neither.
+func (c *diffCache) get(key string) (result *diff.DiffResult, jobID int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	c.touch(key)
+	return entry.result, entry.jobID, true
+}
+
+// start returns the ID of the job computing the diff under key, submitting
+// one with submit unless there is one already
+func (c *diffCache) start(key string, submit func() (int64, error)) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		return entry.jobID, nil
+	}
+	jobID, err := submit()
+	if err != nil {
+		return 0, err
+	}
+
+	c.entries[key] = &diffEntry{jobID: jobID}
+	c.order = append(c.order, key)
+	for len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return jobID, nil
+}
+
+// put stores the computed diff under key, unless it has been evicted
+func (c *diffCache) put(key string, result *diff.DiffResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.result = result
+	}
+}
+
+// drop removes the diff under key, such as when its job failed
+func (c *diffCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+// touch marks key as the most recently used. The caller holds c.mu.
+func (c *diffCache) touch(key string) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(append(c.order[:i:i], c.order[i+1:]...), key)
+			return
+		}
+	}
+}
+
+// diffTooLarge is the response to a diff over server.diff.max_size
+type diffTooLarge struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	// Size is the combined size of the versions, in bytes
+	Size    int64 `json:"size"`
+	MaxSize int64 `json:"max_size"`
+	// Downloads are the URLs of the versions' content
+	Downloads []string `json:"downloads"`
+}
+
+// checkDiffSize refuses to diff versions larger together than
+// server.diff.max_size with 413, linking to their content instead. It
+// returns false if it did.
+func (s *Server) checkDiffSize(w http.ResponseWriter, path string, version1, version2 *store.Version) bool {
+	maxSize := s.cfg.Server.Diff.MaxSize
+	size := diffSize(version1) + diffSize(version2)
+	if maxSize <= 0 || size <= maxSize {
+		return true
+	}
+
+	download := func(version *store.Version) string {
+		return fmt.Sprintf("%s%s/files/%s/versions/%d/raw", s.cfg.Server.BasePath, apiPrefix, url.PathEscape(path), version.ID)
+	}
+	respondJSON(w, http.StatusRequestEntityTooLarge, diffTooLarge{
+		Error:     http.StatusText(http.StatusRequestEntityTooLarge),
+		Message:   fmt.Sprintf("The versions are too large to diff (%d bytes, the limit is %d), download them instead", size, maxSize),
+		Size:      size,
+		MaxSize:   maxSize,
+		Downloads: []string{download(version1), download(version2)},
+	})
+	return false
+}
+
+// diffInBackground reports whether the diff of two versions is large enough
+// to be computed in the background by a job. Anonymous callers could not
+// read the job, so their diffs are computed in the request.
+func (s *Server) diffInBackground(r *http.Request, version1, version2 *store.Version) bool {
+	if s.jobs == nil || s.currentUser(r) == "" || (s.decrypter == nil && (version1.Encrypted || version2.Encrypted)) {
+		return false
+	}
+	backgroundSize := s.cfg.Server.Diff.BackgroundSize
+	return backgroundSize > 0 && diffSize(version1)+diffSize(version2) > backgroundSize
+}
+
+// diffSize is the size of a version's content that is diffed: the excerpt
+// of a truncated version, or else the full content
+func diffSize(version *store.Version) int64 {
+	if version.Truncated {
+		return int64(len(version.Content))
+	}
+	return version.Size
+}
+
+// diffJobResult is the result of a job computing a diff. The diff itself is
+// served by the diff endpoint once the job has succeeded.
+type diffJobResult struct {
+	DiffURL    string         `json:"diff_url"`
+	HasChanges bool           `json:"has_changes"`
+	Stats      diff.DiffStats `json:"stats"`
+}
+
+// respondBackgroundDiff responds with the diff of two versions if it has
+// been computed, or else starts a job computing it and responds 202 for the
+// client to ask again
+func (s *Server) respondBackgroundDiff(w http.ResponseWriter, r *http.Request, path string, version1, version2 *store.Version) {
+	opts := s.diffOptions()
+	key := opts.cacheKey(path, version1, version2)
+	result, jobID, ok := s.diffs.get(key)
+	if result != nil {
+		respondDiffPage(w, r, result)
+		return
+	}
+
+	if !ok {
+		if !s.checkDiffSize(w, path, version1, version2) {
+			return
+		}
+		if !s.loadVersionContent(w, r, version1) || !s.loadVersionContent(w, r, version2) {
+			return
+		}
+
+		diffURL := fmt.Sprintf("%s%s/files/%s/diff/%d/%d", s.cfg.Server.BasePath, apiPrefix, url.PathEscape(path), version1.ID, version2.ID)
+		var err error
+		jobID, err = s.diffs.start(key, func() (int64, error) {
+			job, err := s.jobs.Submit(store.Job{Kind: jobDiff, CreatedBy: s.currentUser(r)}, func(ctx context.Context, progress jobs.Progress) (result *jobs.Result, err error) {
+				// A failed job leaves no entry behind, so the diff is tried again
+				defer func() {
+					if result == nil {
+						s.diffs.drop(key)
+					}
+				}()
+				diffResult := s.diffVersions(ctx, path, version1, version2, opts)
+				s.diffs.put(key, diffResult)
+				return &jobs.Result{Value: diffJobResult{
+					DiffURL:    diffURL,
+					HasChanges: diffResult.HasChanges,
+					Stats:      diffResult.Stats,
+				}}, nil
+			})
+			if err != nil {
+				return 0, err
+			}
+			return job.ID, nil
+		})
+		if err != nil {
+			respondSubmitError(w, jobDiff, err)
+			return
+		}
+	}
+
+	w.Header().Set("Retry-After", diffRetryAfter)
+	w.Header().Set("Location", s.jobURL(jobID))
+	respondJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status":  "computing",
+		"message": "The diff is being computed, request it again shortly",
+		"job_id":  jobID,
+	})
+}
+
+// diffPage is a page of the lines of a diff
+type diffPage struct {
+	*diff.DiffResult
+	// TotalLines is the number of lines in the whole diff
+	TotalLines int `json:"total_lines"`
+	// NextOffset is the offset of the next page, if there is one
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// respondDiffPage responds with a diff, or with the page of its lines given
+// by the offset and limit URL parameters. A page leaves out the unified
+// diff.
+func respondDiffPage(w http.ResponseWriter, r *http.Request, result *diff.DiffResult) {
+	q := r.URL.Query()
+	if q.Get("offset") == "" && q.Get("limit") == "" {
+		respondJSON(w, http.StatusOK, result)
+		return
+	}
+
+	offset := 0
+	if offsetStr := q.Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			respondError(w, http.StatusBadRequest, "Invalid offset")
+			return
+		}
+	}
+	limit := defaultDiffPageLimit
+	if limitStr := q.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxDiffPageLimit {
+			limit = maxDiffPageLimit
+		}
+	}
+
+	page := *result
+	page.UnifiedDiff = ""
+	total := len(result.Lines)
+	start := min(offset, total)
+	end := min(start+limit, total)
+	page.Lines = result.Lines[start:end]
+	resp := diffPage{DiffResult: &page, TotalLines: total}
+	if end < total {
+		resp.NextOffset = &end
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestBackgroundDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		wantStatus int
+	}{
+		{"user", "alice", http.StatusAccepted},
+		// An anonymous caller could not read the job
+		{"anonymous caller", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			file := &store.File{BlobPath: "account/container/app.yaml", ETag: "etag", ContentHash: "hash"}
+			if err := st.UpsertFile(file); err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for i, content := range []string{"a: 1\n", "a: 2\n"} {
+				v := &store.Version{
+					FileID:      file.ID,
+					Content:     content,
+					ContentHash: fmt.Sprintf("hash-%d", i),
+					ChangeType:  store.ChangeTypeModified,
+					BlobETag:    fmt.Sprintf("etag-%d", i),
+					Size:        int64(len(content)),
+				}
+				if err := st.CreateVersion(v); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, v.ID)
+			}
+
+			cfg := config.Default()
+			cfg.Server.Diff.BackgroundSize = 1
+			s := &Server{cfg: cfg, store: st, jobs: jobs.New(config.JobsConfig{QueueSize: 1}, st), diffs: newDiffCache(1)}
+			router := chi.NewRouter()
+			router.Get("/files/{path:.*}/diff/{v1}/{v2}", s.handleDiff)
+
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/files/%s/diff/%d/%d", url.PathEscape(file.BlobPath), ids[0], ids[1]), nil)
+			if tt.user != "" {
+				req = req.WithContext(context.WithValue(req.Context(), userContextKey{}, tt.user))
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusAccepted {
+				return
+			}
+			var resp struct {
+				JobID int64 `json:"job_id"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			job, err := st.GetJob(resp.JobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job == nil || job.CreatedBy != tt.user {
+				t.Errorf("job = %+v, want one created by %s", job, tt.user)
+			}
+		})
+	}
+}
+
+func TestDiffCacheKey(t *testing.T) {
+	v1, v2 := &store.Version{ID: 1}, &store.Version{ID: 2}
+	keys := map[string]bool{}
+	for _, key := range []string{
+		diffOptions{keyChanges: true}.cacheKey("account/container/app.yaml", v1, v2),
+		diffOptions{keyChanges: false}.cacheKey("account/container/app.yaml", v1, v2),
+		diffOptions{keyChanges: true}.cacheKey("account/container/other.yaml", v1, v2),
+		diffOptions{keyChanges: true}.cacheKey("account/container/app.yaml", v2, v1),
+	} {
+		if keys[key] {
+			t.Errorf("key %q is shared by diffs computed differently", key)
+		}
+		keys[key] = true
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/store"
+)
+
+// maxBatchDiffs is the most diffs a batch request may ask for
+const maxBatchDiffs = 100
+
+// diffPair identifies two versions of a file to compare. A zero From means
+// the version before To, so a change can be summarized from its ID alone.
+type diffPair struct {
+	Path string `json:"path"`
+	From int64  `json:"from"`
+	To   int64  `json:"to"`
+}
+
+// diffSummary is the diff statistics of one pair in a batch, or why they
+// couldn't be computed
+type diffSummary struct {
+	Path       string          `json:"path"`
+	From       int64           `json:"from"`
+	To         int64           `json:"to"`
+	Stats      *diff.DiffStats `json:"stats,omitempty"`
+	HasChanges bool            `json:"has_changes"`
+	Truncated  bool            `json:"truncated,omitempty"`
+	Encrypted  bool            `json:"encrypted,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// handleBatchDiffStats returns the diff statistics of several version pairs
+// in one call, in the order they were asked for. A pair that fails gets an
+// error instead of failing the batch.
+func (s *Server) handleBatchDiffStats(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Diffs []diffPair `json:"diffs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Diffs) > maxBatchDiffs {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("At most %d diffs can be requested at once", maxBatchDiffs))
+		return
+	}
+
+	files := make(map[string]*store.File)
+	summaries := make([]diffSummary, 0, len(req.Diffs))
+	for _, pair := range req.Diffs {
+		summary := diffSummary{Path: pair.Path, From: pair.From, To: pair.To}
+		if err := s.summarizeDiff(r.Context(), files, &summary); err != nil {
+			summary.Error = err.Error()
+		}
+		summaries = append(summaries, summary)
+	}
+
+	respondJSON(w, http.StatusOK, summaries)
+}
+
+// summarizeDiff fills in the statistics of a pair. Errors are returned for
+// the caller to report with the pair; unexpected ones are also logged.
+func (s *Server) summarizeDiff(ctx context.Context, files map[string]*store.File, summary *diffSummary) error {
+	file, ok := files[summary.Path]
+	if !ok {
+		var err error
+		if file, err = s.store.GetFile(summary.Path); err != nil {
+			log.Printf("Error getting file: %v", err)
+			return errors.New("failed to get file")
+		}
+		files[summary.Path] = file
+	}
+	if file == nil {
+		return errors.New("file not found")
+	}
+
+	to, err := s.fileVersion(file, summary.To)
+	if err != nil {
+		return err
+	}
+
+	from := &store.Version{}
+	if summary.From != 0 {
+		if from, err = s.fileVersion(file, summary.From); err != nil {
+			return err
+		}
+	} else {
+		previous, err := s.store.GetPreviousVersion(to.ID)
+		if err != nil {
+			log.Printf("Error getting version before %d: %v", to.ID, err)
+			return errors.New("failed to get version")
+		}
+		if previous != nil {
+			from = previous
+			summary.From = previous.ID
+		}
+	}
+
+	// Line statistics of ciphertext mean nothing
+	if from.Encrypted || to.Encrypted {
+		summary.HasChanges = encryptedByHash(from, to).HasChanges
+		summary.Encrypted = true
+		return nil
+	}
+
+	for _, v := range []*store.Version{from, to} {
+		if !v.ContentPending {
+			continue
+		}
+		if s.syncer == nil {
+			return errors.New("version content has not been fetched")
+		}
+		if err := s.syncer.LoadVersionContent(ctx, v); err != nil {
+			log.Printf("Error loading content of version %d: %v", v.ID, err)
+			return fmt.Errorf("failed to fetch content of version %d", v.ID)
+		}
+	}
+
+	fromContent, toContent := from.Content, to.Content
+	if !from.Truncated && !to.Truncated {
+		fromContent, toContent, _ = diff.Canonicalize(file.BlobPath, fromContent, toContent)
+	}
+	result := diff.Compare(fromContent, toContent)
+	summary.Stats = &result.Stats
+	summary.HasChanges = result.HasChanges
+	summary.Truncated = from.Truncated || to.Truncated
+	return nil
+}
+
+// fileVersion returns a version of a file, or an error if it doesn't exist
+// or belongs to another file
+func (s *Server) fileVersion(file *store.File, id int64) (*store.Version, error) {
+	version, err := s.store.GetVersion(id)
+	if err != nil {
+		log.Printf("Error getting version %d: %v", id, err)
+		return nil, fmt.Errorf("failed to get version %d", id)
+	}
+	if version == nil || version.FileID != file.ID {
+		return nil, fmt.Errorf("version %d not found", id)
+	}
+	return version, nil
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
+	"github.com/toggle-vault/internal/validate"
+)
+
+// editRequest is the body of a content update
+type editRequest struct {
+	Content string `json:"content"`
+	// Comment explains the change and is stored with the version
+	Comment string `json:"comment"`
+	// BaseVersionID is the version the edit started from. If set, the edit is
+	// rejected when the file has changed since.
+	BaseVersionID int64 `json:"base_version_id"`
+	// Preview validates and diffs the edit without writing it
+	Preview bool `json:"preview"`
+	// SkipValidation writes content even if it doesn't parse
+	SkipValidation bool `json:"skip_validation"`
+
+	// ifMatch and restoredFrom are set for a saved restore merge, which was
+	// made against the live blob and restores a version
+	ifMatch      string
+	restoredFrom int64
+}
+
+// editPreview is the response to a preview request, or to an edit that
+// failed validation
+type editPreview struct {
+	Valid  bool             `json:"valid"`
+	Issues []validate.Issue `json:"issues"`
+	Diff   *diff.DiffResult `json:"diff,omitempty"`
+	Base   *store.Version   `json:"base,omitempty"`
+}
+
+// handleUpdateContent writes new content to a blob through the vault and
+// records it as a version attributed to the caller. Files that need approval
+// get a proposal instead (202 Accepted).
+func (s *Server) handleUpdateContent(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	var req editRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	comment, ok := parseComment(req.Comment)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Comment is too long")
+		return
+	}
+	if !req.Preview && !requireComment(w, comment) {
+		return
+	}
+	s.writeEdit(w, r, path, req, comment)
+}
+
+// writeEdit validates an edit of the file at path and writes it to blob
+// storage, or proposes it if the file needs approval. A restore merge is
+// answered like a restore.
+func (s *Server) writeEdit(w http.ResponseWriter, r *http.Request, path string, req editRequest, comment string) {
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	latest, err := s.store.GetLatestVersion(file.ID)
+	if err != nil {
+		log.Printf("Error getting latest version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get latest version")
+		return
+	}
+	if req.BaseVersionID != 0 && (latest == nil || latest.ID != req.BaseVersionID) {
+		respondError(w, http.StatusConflict, "File has changed since the base version; reload and reapply the edit")
+		return
+	}
+
+	preview := editPreview{Issues: []validate.Issue{}}
+	if !req.SkipValidation {
+		if issues := validate.Check(path, []byte(req.Content)); len(issues) > 0 {
+			preview.Issues = issues
+		}
+	}
+	preview.Valid = len(preview.Issues) == 0
+
+	if req.Preview {
+		oldContent := ""
+		if latest != nil {
+			if !s.loadVersionContent(w, r, latest) {
+				return
+			}
+			oldContent = latest.Content
+			preview.Base = latest
+		}
+		preview.Diff = s.compareFiles(r.Context(), path, oldContent, req.Content,
+			fmt.Sprintf("%s (current)", path), fmt.Sprintf("%s (edited)", path), s.diffOptions())
+		respondJSON(w, http.StatusOK, preview)
+		return
+	}
+
+	if !preview.Valid {
+		respondJSON(w, http.StatusUnprocessableEntity, preview)
+		return
+	}
+	if s.rejectReadOnly(w, path) {
+		return
+	}
+
+	if s.syncer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Edits are not available")
+		return
+	}
+
+	// Don't overwrite changes made in blob storage that haven't been synced yet
+	ifMatch := file.ETag
+	if file.IsDeleted {
+		ifMatch = ""
+	}
+	if req.ifMatch != "" {
+		ifMatch = req.ifMatch
+	}
+
+	if s.approvals != nil && s.approvals.Required(path) {
+		if req.restoredFrom != 0 {
+			s.proposeRestore(w, r, path, req.restoredFrom, []byte(req.Content), comment)
+			return
+		}
+		proposal := &store.Proposal{
+			BlobPath: path,
+			Kind:     store.ProposalEdit,
+			Content:  req.Content,
+			BaseETag: ifMatch,
+			Author:   s.currentUser(r),
+			Comment:  comment,
+		}
+		if latest != nil {
+			proposal.BaseVersionID = latest.ID
+		}
+		s.propose(w, r, proposal)
+		return
+	}
+
+	version, err := s.syncer.RecordEdit(r.Context(), syncer.Edit{
+		BlobPath: path,
+		Content:  []byte(req.Content),
+		Author:   s.currentUser(r),
+		Comment:  comment,
+		IfMatch:  ifMatch,
+		LeaseID:  requestLeaseID(r),
+
+		RestoredFrom: req.restoredFrom,
+	})
+
+	var rejected *hooks.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		respondJSON(w, http.StatusUnprocessableEntity, editPreview{
+			Issues: []validate.Issue{{Message: rejected.Error()}},
+		})
+		return
+	case errors.Is(err, blob.ErrConditionNotMet) && req.restoredFrom != 0:
+		respondError(w, http.StatusConflict, "Blob was modified again since the merge; reload the merge")
+		return
+	case errors.Is(err, blob.ErrConditionNotMet):
+		respondError(w, http.StatusConflict, "Blob was modified outside the vault; wait for the next sync and reapply the edit")
+		return
+	case respondLeased(w, err), respondImmutable(w, err):
+		return
+	case err != nil:
+		log.Printf("Error writing %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, "Failed to write file")
+		return
+	}
+
+	if req.restoredFrom != 0 {
+		message := fmt.Sprintf("Restored %s to version %d merged with live changes", path, req.restoredFrom)
+		log.Print(message)
+		respondRestored(w, path, req.restoredFrom, version, message)
+		return
+	}
+	if version == nil {
+		// Nothing changed; report the current version
+		respondJSON(w, http.StatusOK, latest)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, version)
+}
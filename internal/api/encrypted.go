@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/store"
+)
+
+// diffOptions are the runtime settings that shape a diff. They are read
+// once per diff, so a diff computed in the background is cached under the
+// options it was computed with.
+type diffOptions struct {
+	// keyChanges lists the settings that changed, see settings.Settings
+	keyChanges bool
+}
+
+// diffOptions returns the options diffs are computed with now
+func (s *Server) diffOptions() diffOptions {
+	return diffOptions{keyChanges: s.settings.Get().KeyChanges}
+}
+
+// cacheKey identifies the diff of two versions of the file at path
+// computed with these options
+func (o diffOptions) cacheKey(path string, version1, version2 *store.Version) string {
+	return fmt.Sprintf("%d:%d:key_changes=%t:%s", version1.ID, version2.ID, o.keyChanges, path)
+}
+
+// compareFiles compares two versions of the file at path. Encrypted files
+// are compared with compareEncrypted. The settings that changed are left
+// out while the settings file switches key-level changes off.
+func (s *Server) compareFiles(ctx context.Context, path, oldContent, newContent, oldLabel, newLabel string, opts diffOptions) *diff.DiffResult {
+	if encryption.Detect([]byte(oldContent)) != "" || encryption.Detect([]byte(newContent)) != "" {
+		return s.compareEncrypted(ctx, path, oldContent, newContent, opts)
+	}
+	if !opts.keyChanges {
+		oldCanonical, newCanonical, canonical := diff.Canonicalize(path, oldContent, newContent)
+		result := diff.CompareVersions(oldCanonical, newCanonical, oldLabel, newLabel)
+		result.Canonical = canonical
+		return result
+	}
+	return diff.CompareFiles(path, oldContent, newContent, oldLabel, newLabel)
+}
+
+// compareEncrypted compares two versions of an encrypted file. Ciphertext
+// isn't compared line by line; if the file can be decrypted, the settings
+// that changed are listed, without their values.
+func (s *Server) compareEncrypted(ctx context.Context, path, oldContent, newContent string, opts diffOptions) *diff.DiffResult {
+	result := &diff.DiffResult{
+		Lines:      []diff.DiffLine{},
+		HasChanges: oldContent != newContent,
+		Encrypted:  true,
+	}
+	if s.decrypter == nil || !result.HasChanges || !opts.keyChanges {
+		return result
+	}
+
+	changes, err := s.decryptedKeys(ctx, path, oldContent, newContent)
+	if err != nil {
+		log.Printf("Error decrypting %s: %v", path, err)
+		return result
+	}
+	for _, change := range changes {
+		result.Keys = append(result.Keys, diff.KeyChange{Key: change.Key, Type: change.Type})
+	}
+	return result
+}
+
+// respondDecryptedDiff responds with the settings changed between two
+// versions of an encrypted file, with their decrypted values. Every view is
+// logged for audit; the plaintext itself is never logged or stored.
+func (s *Server) respondDecryptedDiff(w http.ResponseWriter, r *http.Request, path string, v1, v2 *store.Version) {
+	changes, err := s.decryptedKeys(r.Context(), path, v1.Content, v2.Content)
+	if err != nil {
+		log.Printf("Error decrypting %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, "Failed to decrypt versions")
+		return
+	}
+
+	log.Printf("Audit: decrypted diff of %s (v%d, v%d) viewed by %q from %s, request %s",
+		path, v1.ID, v2.ID, s.currentUser(r), r.RemoteAddr, middleware.GetReqID(r.Context()))
+
+	respondJSON(w, http.StatusOK, &diff.DiffResult{
+		Lines:      []diff.DiffLine{},
+		HasChanges: v1.ContentHash != v2.ContentHash || len(changes) > 0,
+		Encrypted:  true,
+		Keys:       changes,
+	})
+}
+
+// decryptedKeys decrypts two versions of an encrypted file and returns the
+// settings that changed between them, with their values
+func (s *Server) decryptedKeys(ctx context.Context, path, oldContent, newContent string) ([]diff.KeyChange, error) {
+	oldPlain, err := s.decrypter.Decrypt(ctx, path, []byte(oldContent))
+	if err != nil {
+		return nil, err
+	}
+	newPlain, err := s.decrypter.Decrypt(ctx, path, []byte(newContent))
+	if err != nil {
+		return nil, err
+	}
+	return diff.CompareKeys(encryption.PlainPath(path), string(oldPlain), string(newPlain)), nil
+}
+
+// encryptedByHash compares versions of an encrypted file that can't be
+// decrypted by their hashes, without fetching content stored by hash only
+func encryptedByHash(v1, v2 *store.Version) *diff.DiffResult {
+	deleted := v1.ChangeType == store.ChangeTypeDeleted || v2.ChangeType == store.ChangeTypeDeleted
+	return &diff.DiffResult{
+		Lines:      []diff.DiffLine{},
+		HasChanges: v1.ContentHash != v2.ContentHash || (deleted && v1.ChangeType != v2.ChangeType),
+		Encrypted:  true,
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/toggle-vault/internal/events"
+)
+
+// sseKeepAliveInterval is how often a comment is sent on idle event streams
+// so that proxies and load balancers don't close the connection
+const sseKeepAliveInterval = 30 * time.Second
+
+// handleEvents streams change events to the client using Server-Sent Events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, nil)
+}
+
+// streamEvents writes broker events to w as Server-Sent Events until the
+// client disconnects. If include is non-nil, only events it accepts are sent.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, include func(events.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Streams outlast the admin address's write timeout. Writers that
+	// don't support deadlines have none to lift.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := s.events.Subscribe()
+	defer s.events.Unsubscribe(ch)
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			if include != nil && !include(event) {
+				continue
+			}
+
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+// evidenceFormat identifies the layout of evidence bundles
+const evidenceFormat = "toggle-vault-evidence/v1"
+
+// evidenceManifest describes an evidence bundle and the checksum of every
+// other file in it. The manifest itself is covered by manifest.sig.
+type evidenceManifest struct {
+	Format      string            `json:"format"`
+	Path        string            `json:"path"`
+	GeneratedAt time.Time         `json:"generated_at"`
+	GeneratedBy string            `json:"generated_by,omitempty"`
+	File        *store.File       `json:"file"`
+	Verified    bool              `json:"verified"`
+	Versions    []evidenceVersion `json:"versions"`
+	Files       []evidenceFile    `json:"files"`
+}
+
+// evidenceVersion is a version's metadata and integrity check in a manifest
+type evidenceVersion struct {
+	ID               int64            `json:"id"`
+	ChangeType       store.ChangeType `json:"change_type"`
+	CapturedAt       time.Time        `json:"captured_at"`
+	BlobLastModified time.Time        `json:"blob_last_modified"`
+	BlobETag         string           `json:"blob_etag"`
+	ContentHash      string           `json:"content_hash"`
+	Size             int64            `json:"size"`
+	Author           string           `json:"author,omitempty"`
+	Comment          string           `json:"comment,omitempty"`
+	DeletedVersionID int64            `json:"deleted_version_id,omitempty"`
+	Signature        string           `json:"signature,omitempty"`
+	SignatureKeyID   string           `json:"signature_key_id,omitempty"`
+	// ContentFile is the bundle file holding the version's content; empty if
+	// the vault doesn't hold its full content
+	ContentFile string       `json:"content_file,omitempty"`
+	Check       versionCheck `json:"check"`
+}
+
+// evidenceFile is a file in a bundle with its SHA-256 checksum
+type evidenceFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// evidenceSignature signs a bundle's manifest
+type evidenceSignature struct {
+	Algorithm      string `json:"algorithm"`
+	KeyID          string `json:"key_id"`
+	ManifestSHA256 string `json:"manifest_sha256"`
+	Signature      string `json:"signature"`
+}
+
+// evidenceKey is the public key that verifies evidence bundles, PEM-encoded
+type evidenceKey struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// auditEvent is an entry in a file's audit trail: a recorded version or a
+// step in the review of a proposal
+type auditEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Actor      string    `json:"actor,omitempty"`
+	VersionID  int64     `json:"version_id,omitempty"`
+	ProposalID int64     `json:"proposal_id,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+}
+
+// handleEvidenceBundle returns a gzipped tarball of a file's full history for
+// auditors: the content of every version, the audit trail, and a manifest of
+// hashes, timestamps and signature checks, signed with the integrity key.
+// With async=true the bundle is built as a job, and downloaded as its output.
+func (s *Server) handleEvidenceBundle(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Evidence bundles require a signing key (integrity settings)")
+		return
+	}
+
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+	generatedBy := s.currentUser(r)
+
+	if s.runAsync(r) {
+		s.submitJob(w, r, jobEvidence, false, func(ctx context.Context, progress jobs.Progress) (*jobs.Result, error) {
+			bundle, name, err := s.buildEvidenceBundle(ctx, file, generatedBy)
+			if err != nil {
+				return nil, err
+			}
+			return &jobs.Result{Output: bundle, OutputType: "application/gzip", OutputName: name}, nil
+		})
+		return
+	}
+
+	bundle, name, err := s.buildEvidenceBundle(r.Context(), file, generatedBy)
+	if err != nil {
+		log.Printf("Error building evidence bundle for %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusInternalServerError, "Failed to build evidence bundle")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(bundle)
+}
+
+// handleEvidenceKey returns the public key that verifies the signatures of
+// evidence bundles
+func (s *Server) handleEvidenceKey(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Evidence bundles require a signing key (integrity settings)")
+		return
+	}
+	respondJSON(w, http.StatusOK, evidenceKey{
+		Algorithm: "Ed25519",
+		KeyID:     s.signer.KeyID(),
+		PublicKey: s.signer.PublicKey(),
+	})
+}
+
+// buildEvidenceBundle builds the evidence bundle of a file, returning it and
+// its file name
+func (s *Server) buildEvidenceBundle(ctx context.Context, file *store.File, generatedBy string) ([]byte, string, error) {
+	versions, err := s.store.GetVersionsByFileID(file.ID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get versions: %w", err)
+	}
+
+	proposals, err := s.store.ListProposals(store.ProposalQuery{BlobPath: file.BlobPath})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list proposals: %w", err)
+	}
+
+	manifest := evidenceManifest{
+		Format:      evidenceFormat,
+		Path:        file.BlobPath,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		GeneratedBy: generatedBy,
+		File:        file,
+		Verified:    true,
+		Versions:    make([]evidenceVersion, 0, len(versions)),
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	root := evidenceRoot(file.BlobPath, manifest.GeneratedAt)
+	add := func(name string, data []byte) error {
+		manifest.Files = append(manifest.Files, evidenceFile{Name: name, SHA256: blob.ComputeHash(data), Size: len(data)})
+		return writeTarFile(tw, root+"/"+name, data, manifest.GeneratedAt)
+	}
+
+	for i := range versions {
+		v := &versions[i]
+		if v.ContentPending && s.syncer != nil {
+			if err := s.syncer.LoadVersionContent(ctx, v); err != nil {
+				log.Printf("Error loading content of version %d for evidence bundle: %v", v.ID, err)
+			}
+		}
+
+		ev := evidenceVersion{
+			ID:               v.ID,
+			ChangeType:       v.ChangeType,
+			CapturedAt:       v.CapturedAt,
+			BlobLastModified: v.BlobLastModified,
+			BlobETag:         v.BlobETag,
+			ContentHash:      v.ContentHash,
+			Size:             v.Size,
+			Author:           v.Author,
+			Comment:          v.Comment,
+			DeletedVersionID: v.DeletedVersionID,
+			Signature:        v.Signature,
+			SignatureKeyID:   v.SignatureKeyID,
+			Check:            s.verifyVersion(v),
+		}
+		if ev.Check.Content == checkMismatch || ev.Check.Signature == checkInvalid {
+			manifest.Verified = false
+		}
+
+		if v.ChangeType != store.ChangeTypeDeleted && !v.ContentPending && !v.Truncated {
+			ev.ContentFile = fmt.Sprintf("versions/%d-%s", v.ID, path.Base(file.BlobPath))
+			if err := add(ev.ContentFile, []byte(v.Content)); err != nil {
+				return nil, "", err
+			}
+		}
+		manifest.Versions = append(manifest.Versions, ev)
+	}
+
+	audit, err := json.MarshalIndent(auditTrail(versions, proposals), "", "  ")
+	if err == nil {
+		err = add("audit.json", audit)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	signature, err := json.MarshalIndent(evidenceSignature{
+		Algorithm:      "Ed25519",
+		KeyID:          s.signer.KeyID(),
+		ManifestSHA256: blob.ComputeHash(manifestJSON),
+		Signature:      s.signer.SignDocument(manifestJSON),
+	}, "", "  ")
+	if err == nil {
+		err = writeTarFile(tw, root+"/manifest.json", manifestJSON, manifest.GeneratedAt)
+	}
+	if err == nil {
+		err = writeTarFile(tw, root+"/manifest.sig", signature, manifest.GeneratedAt)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), root + ".tar.gz", nil
+}
+
+// auditTrail lists the recorded versions of a file and the proposals made
+// for it, oldest first
+func auditTrail(versions []store.Version, proposals []store.Proposal) []auditEvent {
+	events := make([]auditEvent, 0, len(versions)+2*len(proposals))
+	for _, v := range versions {
+		actor := v.Author
+		if actor == "" {
+			actor = "sync"
+		}
+		events = append(events, auditEvent{
+			Time:      v.CapturedAt,
+			Event:     "version." + string(v.ChangeType),
+			Actor:     actor,
+			VersionID: v.ID,
+			Comment:   v.Comment,
+		})
+	}
+
+	for _, p := range proposals {
+		events = append(events, auditEvent{
+			Time:       p.CreatedAt,
+			Event:      "proposal." + string(p.Kind),
+			Actor:      p.Author,
+			ProposalID: p.ID,
+			Comment:    p.Comment,
+		})
+		if p.Status == store.ProposalPending {
+			continue
+		}
+
+		closed := auditEvent{
+			Time:       p.UpdatedAt,
+			Event:      "proposal." + string(p.Status),
+			Actor:      p.Reviewer,
+			VersionID:  p.VersionID,
+			ProposalID: p.ID,
+			Comment:    p.ReviewComment,
+		}
+		switch p.Status {
+		case store.ProposalWithdrawn:
+			closed.Actor = p.Author
+		case store.ProposalFailed:
+			closed.Comment = p.Error
+		}
+		events = append(events, closed)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// evidenceRoot names the directory an evidence bundle unpacks into
+func evidenceRoot(blobPath string, generatedAt time.Time) string {
+	return fmt.Sprintf("evidence-%s-%s", path.Base(blobPath), generatedAt.Format("20060102T150405Z"))
+}
+
+// writeTarFile adds a regular file to a tarball
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	defaultFeedLimit = 50
+	maxFeedLimit     = 500
+)
+
+// rssFeed is an RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	LastBuildDate string      `xml:"lastBuildDate"`
+	AtomLink      rssAtomLink `xml:"atom:link"`
+	Items         []rssItem   `xml:"item"`
+}
+
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// handleChangesFeed serves recent changes as an RSS feed.
+//
+// Supported query parameters:
+//   - prefix: only include files whose path (account/container/blob) starts with prefix
+//   - change_type: created, modified or deleted
+//   - limit: maximum number of items (default 50, max 500)
+func (s *Server) handleChangesFeed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := store.SearchQuery{
+		PathPrefix: q.Get("prefix"),
+		ChangeType: store.ChangeType(q.Get("change_type")),
+		Limit:      defaultFeedLimit,
+	}
+
+	if query.ChangeType != "" && !query.ChangeType.Known() {
+		http.Error(w, "Invalid change_type", http.StatusBadRequest)
+		return
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxFeedLimit {
+			limit = maxFeedLimit
+		}
+		query.Limit = limit
+	}
+
+	changes, err := s.store.SearchChanges(query)
+	if err != nil {
+		log.Printf("Error building changes feed: %v", err)
+		http.Error(w, "Failed to load changes", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r) + s.cfg.Server.BasePath
+	title := "Toggle Vault changes"
+	if query.PathPrefix != "" {
+		title += " under " + query.PathPrefix
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         title,
+			Link:          base + "/",
+			Description:   "Configuration file changes detected by Toggle Vault",
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+			AtomLink: rssAtomLink{
+				Href: base + r.URL.RequestURI(),
+				Rel:  "self",
+				Type: "application/rss+xml",
+			},
+		},
+	}
+
+	for _, c := range changes {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       fmt.Sprintf("%s %s", c.BlobPath, c.ChangeType),
+			Link:        base + "/?q=" + url.QueryEscape(c.BlobPath),
+			Description: fmt.Sprintf("%s was %s (version %d, sha256 %s)", c.BlobPath, c.ChangeType, c.VersionID, c.ContentHash),
+			Category:    string(c.ChangeType),
+			GUID:        rssGUID{Value: fmt.Sprintf("toggle-vault:version:%d", c.VersionID)},
+			PubDate:     c.CapturedAt.Format(time.RFC1123Z),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, xml.Header)
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Printf("Error encoding changes feed: %v", err)
+	}
+}
+
+// baseURL returns the scheme and host the client used to reach the server
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
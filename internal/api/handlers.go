@@ -1,16 +1,22 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/blob"
 	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/hooks"
 	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
 )
 
 // getPathParam extracts and URL-decodes a path parameter from the request
@@ -54,15 +60,64 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleListFiles returns all tracked files
+// handleReady reports whether the server can serve requests, which needs
+// the database to be readable
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Ping(); err != nil {
+		log.Printf("Error checking readiness: %v", err)
+		respondError(w, http.StatusServiceUnavailable, "Database unavailable")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{
+		"status": "ready",
+	})
+}
+
+// handleListFiles returns the tracked files with a status, active ones
+// unless status is deleted, archived or all, ordered by path unless sort is
+// latest_change or version_count
 func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := s.store.ListFiles()
+	selectors, err := s.parseLabelSelectors(r.URL.Query()["label"])
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid label: "+err.Error())
+		return
+	}
+
+	status := store.FileStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "":
+		status = store.FileStatusActive
+	case store.FileStatusActive, store.FileStatusDeleted, store.FileStatusArchived, store.FileStatusAll:
+	default:
+		respondError(w, http.StatusBadRequest, "status must be active, deleted, archived or all")
+		return
+	}
+
+	sort := store.FileSort(r.URL.Query().Get("sort"))
+	switch sort {
+	case "":
+		sort = store.FileSortPath
+	case store.FileSortPath, store.FileSortLatestChange, store.FileSortVersionCount:
+	default:
+		respondError(w, http.StatusBadRequest, "sort must be path, latest_change or version_count")
+		return
+	}
+
+	files, err := s.store.ListFilesWithStatus(status, sort, selectors)
 	if err != nil {
 		log.Printf("Error listing files: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to list files")
 		return
 	}
 
+	if err := s.labelFiles(files); err != nil {
+		log.Printf("Error listing file labels: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list files")
+		return
+	}
+	s.ownFiles(files)
+	s.markReadOnly(files)
+
 	if files == nil {
 		files = []store.FileWithVersionCount{}
 	}
@@ -78,6 +133,8 @@ func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.fetchPendingContent(r.Context(), path)
+
 	file, err := s.store.GetFile(path)
 	if err != nil {
 		log.Printf("Error getting file: %v", err)
@@ -90,6 +147,16 @@ func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	set, err := s.store.GetFileLabels(file.ID)
+	if err != nil {
+		log.Printf("Error getting labels of %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	file.Labels = s.effectiveLabels(file.BlobPath, set)
+	file.Owners = s.owners.OwnersFor(file.BlobPath)
+	file.ReadOnly = s.readOnly(file.BlobPath)
+
 	respondJSON(w, http.StatusOK, file)
 }
 
@@ -101,6 +168,8 @@ func (s *Server) handleGetVersions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.fetchPendingContent(r.Context(), path)
+
 	versions, err := s.store.GetVersionsByFilePath(path)
 	if err != nil {
 		log.Printf("Error getting versions: %v", err)
@@ -110,12 +179,23 @@ func (s *Server) handleGetVersions(w http.ResponseWriter, r *http.Request) {
 
 	if versions == nil {
 		versions = []store.Version{}
+	} else {
+		tags, err := s.store.GetVersionTags(versions[0].FileID)
+		if err != nil {
+			log.Printf("Error getting version tags: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to get versions")
+			return
+		}
+		for i := range versions {
+			versions[i].Tags = tags[versions[i].ID]
+		}
 	}
 
 	respondJSON(w, http.StatusOK, versions)
 }
 
-// handleGetVersion returns a specific version
+// handleGetVersion returns a specific version, linked to the versions of
+// the file before and after it
 func (s *Server) handleGetVersion(w http.ResponseWriter, r *http.Request) {
 	versionIDStr := chi.URLParam(r, "versionID")
 	versionID, err := strconv.ParseInt(versionIDStr, 10, 64)
@@ -136,25 +216,108 @@ func (s *Server) handleGetVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondJSON(w, http.StatusOK, version)
+	if !s.loadVersionContent(w, r, version) {
+		return
+	}
+
+	resource, err := s.newVersionResource(getPathParam(r, "path"), version)
+	if err != nil {
+		log.Printf("Error getting adjacent versions: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	respondJSON(w, http.StatusOK, resource)
+}
+
+// handleDownloadVersion returns the content of a version as a file, for
+// versions too large to view or diff
+func (s *Server) handleDownloadVersion(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	versionID, err := strconv.ParseInt(chi.URLParam(r, "versionID"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return
+	}
+
+	version, err := s.store.GetVersion(versionID)
+	if err != nil {
+		log.Printf("Error getting version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	if version == nil {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+	if !s.loadVersionContent(w, r, version) {
+		return
+	}
+
+	name := fmt.Sprintf("v%d-%s", version.ID, path[strings.LastIndex(path, "/")+1:])
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Write([]byte(version.Content))
 }
 
 // handleDiff returns a diff between two versions
 func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
 	path := getPathParam(r, "path")
-	v1Str := chi.URLParam(r, "v1")
-	v2Str := chi.URLParam(r, "v2")
 
-	v1, err := strconv.ParseInt(v1Str, 10, 64)
+	// Decrypted values of encrypted files are shown to admins only
+	decrypt := r.URL.Query().Get("decrypt") == "true"
+	if decrypt {
+		if !s.authorizeAdmin(w, r) {
+			return
+		}
+		if s.decrypter == nil {
+			respondError(w, http.StatusBadRequest, "Decryption is not configured")
+			return
+		}
+	}
+
+	version1, version2, ok := s.loadDiffVersions(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("format") == "patch" {
+		s.respondPatch(w, r, path, version1, version2)
+		return
+	}
+
+	if decrypt && (version1.Encrypted || version2.Encrypted) {
+		if !s.loadVersionContent(w, r, version1) || !s.loadVersionContent(w, r, version2) {
+			return
+		}
+		s.respondDecryptedDiff(w, r, path, version1, version2)
+		return
+	}
+
+	if s.diffInBackground(r, version1, version2) {
+		s.respondBackgroundDiff(w, r, path, version1, version2)
+		return
+	}
+
+	diffResult, ok := s.compareVersions(w, r, path, version1, version2)
+	if !ok {
+		return
+	}
+	respondDiffPage(w, r, diffResult)
+}
+
+// loadDiffVersions returns the versions named by the v1 and v2 URL
+// parameters. On failure it writes the error response and returns false.
+func (s *Server) loadDiffVersions(w http.ResponseWriter, r *http.Request) (*store.Version, *store.Version, bool) {
+	v1, err := strconv.ParseInt(chi.URLParam(r, "v1"), 10, 64)
 	if err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid version ID v1")
-		return
+		return nil, nil, false
 	}
 
-	v2, err := strconv.ParseInt(v2Str, 10, 64)
+	v2, err := strconv.ParseInt(chi.URLParam(r, "v2"), 10, 64)
 	if err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid version ID v2")
-		return
+		return nil, nil, false
 	}
 
 	// Get both versions
@@ -162,33 +325,56 @@ func (s *Server) handleDiff(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Error getting version v1: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get version")
-		return
+		return nil, nil, false
 	}
 	if version1 == nil {
 		respondError(w, http.StatusNotFound, "Version v1 not found")
-		return
+		return nil, nil, false
 	}
 
 	version2, err := s.store.GetVersion(v2)
 	if err != nil {
 		log.Printf("Error getting version v2: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to get version")
-		return
+		return nil, nil, false
 	}
 	if version2 == nil {
 		respondError(w, http.StatusNotFound, "Version v2 not found")
-		return
+		return nil, nil, false
 	}
 
-	// Generate diff
-	diffResult := diff.CompareVersions(
-		version1.Content,
-		version2.Content,
-		fmt.Sprintf("%s (v%d)", path, v1),
-		fmt.Sprintf("%s (v%d)", path, v2),
-	)
+	return version1, version2, true
+}
+
+// compareVersions compares two versions of the file at path, loading content
+// stored by hash only. Versions too large to diff are refused. On failure it
+// writes the error response and returns false.
+func (s *Server) compareVersions(w http.ResponseWriter, r *http.Request, path string, version1, version2 *store.Version) (*diff.DiffResult, bool) {
+	if s.decrypter == nil && (version1.Encrypted || version2.Encrypted) {
+		return encryptedByHash(version1, version2), true
+	}
+
+	if !s.checkDiffSize(w, path, version1, version2) {
+		return nil, false
+	}
+	if !s.loadVersionContent(w, r, version1) || !s.loadVersionContent(w, r, version2) {
+		return nil, false
+	}
+	return s.diffVersions(r.Context(), path, version1, version2, s.diffOptions()), true
+}
 
-	respondJSON(w, http.StatusOK, diffResult)
+// diffVersions diffs two versions of the file at path, whose content is
+// loaded, with opts. Excerpts of truncated versions are compared as they
+// are.
+func (s *Server) diffVersions(ctx context.Context, path string, version1, version2 *store.Version, opts diffOptions) *diff.DiffResult {
+	label1 := fmt.Sprintf("%s (v%d)", path, version1.ID)
+	label2 := fmt.Sprintf("%s (v%d)", path, version2.ID)
+	if version1.Truncated || version2.Truncated {
+		diffResult := diff.CompareVersions(version1.Content, version2.Content, label1, label2)
+		diffResult.Truncated = true
+		return diffResult
+	}
+	return s.compareFiles(ctx, path, version1.Content, version2.Content, label1, label2, opts)
 }
 
 // handleRestore restores a previous version to blob storage
@@ -207,6 +393,12 @@ func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The body is optional and may carry a comment explaining the restore
+	comment, ok := parseOptionalComment(w, r)
+	if !ok {
+		return
+	}
+
 	// Get the version to restore
 	version, err := s.store.GetVersion(versionID)
 	if err != nil {
@@ -220,20 +412,123 @@ func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Upload the content back to blob storage
-	// Path is in format "storageaccount/container/blobpath"
-	if err := s.blobClient.UploadBlobByFullPath(r.Context(), path, []byte(version.Content)); err != nil {
+	s.restoreVersion(w, r, path, version, comment)
+}
+
+// restoreVersion writes a version's content back to blob storage, or proposes
+// it if the file needs approval. The version must be one of the file's own.
+func (s *Server) restoreVersion(w http.ResponseWriter, r *http.Request, path string, version *store.Version, comment string) {
+	versionID := version.ID
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file == nil || version.FileID != file.ID {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+
+	if s.rejectReadOnly(w, path) {
+		return
+	}
+	content, ok := s.restoreContent(w, r, version)
+	if !ok {
+		return
+	}
+
+	if s.approvals != nil && s.approvals.Required(path) {
+		s.proposeRestore(w, r, path, version.ID, content, comment)
+		return
+	}
+
+	// Don't overwrite changes made in blob storage that haven't been synced
+	// yet; a merge with them is offered instead
+	ifMatch := ""
+	if file != nil && !file.IsDeleted {
+		ifMatch = file.ETag
+	}
+
+	// Upload the content back to blob storage and record it right away, so
+	// the comment and the user who restored it are kept with the new version
+	var restored *store.Version
+	if s.syncer != nil {
+		restored, err = s.syncer.RecordEdit(r.Context(), syncer.Edit{
+			BlobPath: path,
+			Content:  content,
+			Author:   s.currentUser(r),
+			Comment:  comment,
+			IfMatch:  ifMatch,
+			LeaseID:  requestLeaseID(r),
+
+			RestoredFrom: version.ID,
+		})
+		var rejected *hooks.RejectedError
+		switch {
+		case errors.As(err, &rejected):
+			respondError(w, http.StatusUnprocessableEntity, rejected.Error())
+			return
+		case errors.Is(err, blob.ErrConditionNotMet):
+			s.respondRestoreConflict(w, r, path, version, content)
+			return
+		case respondLeased(w, err), respondImmutable(w, err):
+			return
+		}
+	} else {
+		// Path is in format "storageaccount/container/blobpath"
+		err = s.blobClient.UploadBlobByFullPath(r.Context(), path, content)
+	}
+	if err != nil {
 		log.Printf("Error restoring blob: %v", err)
 		respondError(w, http.StatusInternalServerError, "Failed to restore file")
 		return
 	}
 
-	log.Printf("Restored %s to version %d", path, versionID)
+	message := fmt.Sprintf("Restored %s to version %d", path, versionID)
+	log.Print(message)
+	respondRestored(w, path, versionID, restored, message)
+}
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
+// respondRestored answers a restore of the file at path to versionID with
+// the version it recorded, if any
+func respondRestored(w http.ResponseWriter, path string, versionID int64, restored *store.Version, message string) {
+	result := map[string]interface{}{
 		"success": true,
-		"message": fmt.Sprintf("Restored %s to version %d", path, versionID),
+		"message": message,
 		"path":    path,
 		"version": versionID,
-	})
+	}
+	if restored != nil {
+		result["new_version"] = restored.ID
+	}
+	respondJSON(w, http.StatusOK, result)
+}
+
+// restoreContent returns the full content of a version to restore. On
+// failure it writes the error response and returns false.
+func (s *Server) restoreContent(w http.ResponseWriter, r *http.Request, version *store.Version) ([]byte, bool) {
+	if !s.loadVersionContent(w, r, version) {
+		return nil, false
+	}
+	if !version.Truncated {
+		return []byte(version.Content), true
+	}
+
+	// Only an excerpt is stored; the full content must come from a snapshot
+	if s.syncer == nil {
+		respondError(w, http.StatusConflict, "Version content is truncated and cannot be restored")
+		return nil, false
+	}
+	content, err := s.syncer.VersionContent(r.Context(), version)
+	if errors.Is(err, syncer.ErrContentUnavailable) {
+		respondError(w, http.StatusConflict, "Version content is truncated and no snapshot of it is available")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error getting content of version %d: %v", version.ID, err)
+		respondError(w, http.StatusBadGateway, "Failed to fetch version content from blob storage")
+		return nil, false
+	}
+	return content, true
 }
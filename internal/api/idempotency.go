@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// idempotencyKeyHeader is the request header naming a request, so that
+	// its retries are answered with the first response instead of repeating
+	// the write
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses sent again for a retry
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength is the longest idempotency key accepted
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize is the largest request body read to fingerprint
+	// a request with an idempotency key
+	maxIdempotentBodySize = 32 << 20
+)
+
+// idempotent makes the writes of a route safe to retry. A request with an
+// Idempotency-Key header claims the key for the caller; a retry with the same
+// key and request gets the first response back, with an Idempotent-Replayed
+// header, instead of being handled again. A retry while the first request is
+// still running is refused with 409, and the key reused for a different
+// request with 422. Server errors aren't kept, so those requests can be
+// retried. Requests without the header are handled as usual. Keys are scoped
+// to the caller's identity, or to the client address of anonymous callers.
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(s.currentUser(r), r)
+		fingerprint := requestFingerprint(r, body)
+		existing, err := s.store.ClaimIdempotencyKey(scope, key, fingerprint, time.Now().Add(-s.cfg.Server.IdempotencyTTL))
+		if err != nil {
+			log.Printf("Error claiming idempotency key: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to check Idempotency-Key")
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			case !existing.Done:
+				w.Header().Set("Retry-After", "1")
+				respondError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				replayResponse(w, existing)
+			}
+			return
+		}
+
+		// Keep the response to answer retries with, or release the key if the
+		// request failed, including by panicking
+		var captured bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&captured)
+		saved := false
+		defer func() {
+			if !saved {
+				if err := s.store.ReleaseIdempotencyKey(scope, key); err != nil {
+					log.Printf("Error releasing idempotency key: %v", err)
+				}
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		response := &store.IdempotentResponse{
+			Status:      status,
+			ContentType: ww.Header().Get("Content-Type"),
+			Location:    ww.Header().Get("Location"),
+			Body:        captured.Bytes(),
+		}
+		if err := s.store.SaveIdempotentResponse(scope, key, response); err != nil {
+			log.Printf("Error saving idempotent response: %v", err)
+			return
+		}
+		saved = true
+	})
+}
+
+// idempotencyScope returns the scope an idempotency key is claimed in: the
+// caller's identity, or for anonymous callers their address, so that one
+// client can't be answered with another's response
+func idempotencyScope(user string, r *http.Request) string {
+	if user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "anonymous@" + host
+}
+
+// requestFingerprint identifies a request by its method, path, query and
+// body, to tell a retry from a different request reusing its key
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+"\n"+r.URL.Path+"\n"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayResponse sends a recorded response again
+func replayResponse(w http.ResponseWriter, response *store.IdempotentResponse) {
+	if response.ContentType != "" {
+		w.Header().Set("Content-Type", response.ContentType)
+	}
+	if response.Location != "" {
+		w.Header().Set("Location", response.Location)
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(response.Status)
+	w.Write(response.Body)
+}
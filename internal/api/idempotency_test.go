@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+func TestIdempotent(t *testing.T) {
+	// Each request is sent in turn to the same server, so later steps see the
+	// keys claimed by earlier ones
+	steps := []struct {
+		name         string
+		user         string
+		remoteAddr   string
+		key          string
+		body         string
+		fail         bool
+		wantStatus   int
+		wantHandled  bool
+		wantReplayed bool
+	}{
+		{name: "first request", user: "alice", key: "k1", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "retry", user: "alice", key: "k1", body: "a", wantStatus: http.StatusCreated, wantReplayed: true},
+		{name: "key reused for another body", user: "alice", key: "k1", body: "b", wantStatus: http.StatusUnprocessableEntity},
+		{name: "same key of another user", user: "bob", key: "k1", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "anonymous caller", remoteAddr: "192.0.2.1:1000", key: "k2", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "anonymous retry from another port", remoteAddr: "192.0.2.1:2000", key: "k2", body: "a", wantStatus: http.StatusCreated, wantReplayed: true},
+		{name: "same key of another anonymous caller", remoteAddr: "192.0.2.2:1000", key: "k2", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "server error", user: "alice", key: "k3", body: "a", fail: true, wantStatus: http.StatusInternalServerError, wantHandled: true},
+		{name: "retry after a server error", user: "alice", key: "k3", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "no key", user: "alice", body: "a", wantStatus: http.StatusCreated, wantHandled: true},
+		{name: "body too large", user: "alice", key: "k4", body: strings.Repeat("x", maxIdempotentBodySize+1), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	s := &Server{
+		store: newTestStore(t),
+		cfg:   &config.Config{Server: config.ServerConfig{IdempotencyTTL: time.Hour}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			handled := false
+			handler := s.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+				if step.fail {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/files/a/b/c.yaml/restore/1", strings.NewReader(step.body))
+			if step.remoteAddr != "" {
+				req.RemoteAddr = step.remoteAddr
+			}
+			if step.user != "" {
+				req = req.WithContext(context.WithValue(req.Context(), userContextKey{}, step.user))
+			}
+			if step.key != "" {
+				req.Header.Set(idempotencyKeyHeader, step.key)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != step.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, step.wantStatus)
+			}
+			if handled != step.wantHandled {
+				t.Errorf("handled = %v, want %v", handled, step.wantHandled)
+			}
+			if replayed := rec.Header().Get(idempotentReplayedHeader) == "true"; replayed != step.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, step.wantReplayed)
+			}
+		})
+	}
+}
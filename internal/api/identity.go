@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+// apiKeyHeader is the request header carrying a caller's API key
+const apiKeyHeader = "X-API-Key"
+
+// identify works out who the caller is from an API key, or from the user
+// header of a trusted SSO proxy, and stores the identity in the request
+// context for currentUser. The user header of any other client is ignored.
+// It checks the address of the connection, so it must run before RealIP
+// replaces it with a forwarded one. An unknown API key is refused with 401
+// rather than treated as anonymous, so that a mistyped key doesn't go
+// unnoticed.
+func identify(cfg config.ServerConfig) func(http.Handler) http.Handler {
+	keys := make(map[string]string, len(cfg.APIKeys))
+	for _, key := range cfg.APIKeys {
+		keys[hashAPIKey(key.Key)] = key.User
+	}
+	proxies, socket, _ := cfg.TrustedProxyNetworks()
+	userHeader := cfg.UserHeader
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := ""
+			if key := r.Header.Get(apiKeyHeader); key != "" {
+				// Keys are looked up by hash so the lookup takes the same
+				// time however much of a key is right
+				user = keys[hashAPIKey(key)]
+				if user == "" {
+					respondError(w, http.StatusUnauthorized, "Invalid API key")
+					return
+				}
+			} else if trustedPeer(r, proxies, socket) {
+				user = strings.TrimSpace(r.Header.Get(userHeader))
+			}
+
+			if user != "" {
+				r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, user))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trustedPeer reports whether a request's connection comes from one of the
+// trusted proxies
+func trustedPeer(r *http.Request, proxies []*net.IPNet, socket bool) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// Unix socket connections have no address
+		return socket
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey returns the hash API keys are looked up by
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+func TestIdentify(t *testing.T) {
+	cfg := config.ServerConfig{
+		UserHeader:     config.DefaultUserHeader,
+		TrustedProxies: []string{"10.0.0.0/8"},
+		APIKeys:        []config.APIKey{{User: "alice", Key: "alice-key-0123456789"}},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		apiKey     string
+		userHeader string
+		wantStatus int
+		wantUser   string
+	}{
+		{
+			name:       "anonymous",
+			remoteAddr: "192.0.2.1:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "API key",
+			remoteAddr: "192.0.2.1:1234",
+			apiKey:     "alice-key-0123456789",
+			wantStatus: http.StatusOK,
+			wantUser:   "alice",
+		},
+		{
+			name:       "unknown API key",
+			remoteAddr: "192.0.2.1:1234",
+			apiKey:     "alice-key-012345678",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "user header from a trusted proxy",
+			remoteAddr: "10.1.2.3:1234",
+			userHeader: " bob ",
+			wantStatus: http.StatusOK,
+			wantUser:   "bob",
+		},
+		{
+			name:       "user header from anywhere else",
+			remoteAddr: "192.0.2.1:1234",
+			userHeader: "bob",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "API key wins over the user header",
+			remoteAddr: "10.1.2.3:1234",
+			apiKey:     "alice-key-0123456789",
+			userHeader: "bob",
+			wantStatus: http.StatusOK,
+			wantUser:   "alice",
+		},
+		{
+			name:       "unknown API key from a trusted proxy",
+			remoteAddr: "10.1.2.3:1234",
+			apiKey:     "wrong",
+			userHeader: "bob",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	s := &Server{userHeader: cfg.UserHeader}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser string
+			handler := identify(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser = s.currentUser(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.apiKey != "" {
+				req.Header.Set(apiKeyHeader, tt.apiKey)
+			}
+			if tt.userHeader != "" {
+				req.Header.Set(cfg.UserHeader, tt.userHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("user = %q, want %q", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// handleGetImpact returns the metrics measured before and after a version
+// was recorded. The list is empty until a window has passed since the
+// change, or if impact measuring isn't configured.
+func (s *Server) handleGetImpact(w http.ResponseWriter, r *http.Request) {
+	versionID, err := strconv.ParseInt(chi.URLParam(r, "versionID"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return
+	}
+
+	impacts, err := s.store.GetImpacts(versionID)
+	if err != nil {
+		log.Printf("Error getting impact of version %d: %v", versionID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get impact")
+		return
+	}
+	if impacts == nil {
+		impacts = []store.Impact{}
+	}
+
+	respondJSON(w, http.StatusOK, impacts)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+// Kinds of jobs
+const (
+	jobBulk     = "bulk"
+	jobEvidence = "evidence"
+	jobDiff     = "diff"
+	jobSync     = "sync"
+)
+
+// maxJobsLimit is the most jobs listed at once
+const maxJobsLimit = 500
+
+// runAsync reports whether a request asked to run as a job with async=true.
+// Without a job runner, requests run while they wait.
+func (s *Server) runAsync(r *http.Request) bool {
+	return s.jobs != nil && r.URL.Query().Get("async") == "true"
+}
+
+// submitJob runs fn as a job and responds 202 with the job, linking to it in
+// the Location header. The job is posted to the callback_url URL parameter,
+// if given, when it finishes. Jobs other than admin ones can only be read by
+// the user who submitted them, so they need a user identity.
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request, kind string, admin bool, fn jobs.Func) {
+	spec := store.Job{Kind: kind, Admin: admin, CreatedBy: s.currentUser(r)}
+	if !admin && spec.CreatedBy == "" {
+		respondError(w, http.StatusUnauthorized, "Missing user identity (an "+apiKeyHeader+" header, or "+s.userHeader+" from a trusted proxy)")
+		return
+	}
+	if callbackURL := r.URL.Query().Get("callback_url"); callbackURL != "" {
+		if err := s.jobs.CheckCallbackURL(callbackURL); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid callback_url: "+err.Error())
+			return
+		}
+		spec.CallbackURL = callbackURL
+	}
+
+	job, err := s.jobs.Submit(spec, fn)
+	if err != nil {
+		respondSubmitError(w, kind, err)
+		return
+	}
+
+	w.Header().Set("Location", s.jobURL(job.ID))
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+// respondSubmitError responds to a job of a kind that couldn't be submitted:
+// 503 while the queue is full, or else 500
+func respondSubmitError(w http.ResponseWriter, kind string, err error) {
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "60")
+		respondError(w, http.StatusServiceUnavailable, "Too many jobs are waiting, try again later")
+		return
+	}
+	log.Printf("Error submitting %s job: %v", kind, err)
+	respondError(w, http.StatusInternalServerError, "Failed to start job")
+}
+
+// jobURL returns the API URL of a job
+func (s *Server) jobURL(id int64) string {
+	return fmt.Sprintf("%s%s/jobs/%d", s.cfg.Server.BasePath, apiPrefix, id)
+}
+
+// loadJob returns the job named by the id URL parameter. Jobs of admin
+// operations are only shown to admins, and other jobs to the user who
+// submitted them or an admin. On failure it writes the error response and
+// returns false.
+func (s *Server) loadJob(w http.ResponseWriter, r *http.Request) (*store.Job, bool) {
+	if s.jobs == nil {
+		respondError(w, http.StatusNotFound, "Job not found")
+		return nil, false
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid job ID")
+		return nil, false
+	}
+
+	job, err := s.store.GetJob(id)
+	if err != nil {
+		log.Printf("Error getting job %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get job")
+		return nil, false
+	}
+	if job == nil {
+		respondError(w, http.StatusNotFound, "Job not found")
+		return nil, false
+	}
+	owner := !job.Admin && job.CreatedBy != "" && job.CreatedBy == s.currentUser(r)
+	if !owner && !s.authorizeAdmin(w, r) {
+		return nil, false
+	}
+	return job, true
+}
+
+// handleGetJob reports the status, progress and result of a job
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, job)
+}
+
+// handleGetJobOutput downloads the file a job produced, such as an export
+func (s *Server) handleGetJobOutput(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.loadJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != store.JobSucceeded {
+		respondError(w, http.StatusConflict, "Job has not succeeded (status "+string(job.Status)+")")
+		return
+	}
+
+	output, contentType, name, err := s.store.GetJobOutput(job.ID)
+	if err != nil {
+		log.Printf("Error getting output of job %d: %v", job.ID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get job output")
+		return
+	}
+	if output == nil {
+		respondError(w, http.StatusNotFound, "Job has no output")
+		return
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if name != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(output)
+}
+
+// handleListJobs lists the latest jobs, newest first
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxJobsLimit {
+			limit = maxJobsLimit
+		}
+	}
+
+	list, err := s.store.ListJobs(limit)
+	if err != nil {
+		log.Printf("Error listing jobs: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list jobs")
+		return
+	}
+	if list == nil {
+		list = []store.Job{}
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+// handleSyncNow runs a sync cycle as a job, which is the backfill if the
+// first cycle hasn't completed yet. A cycle already running finishes first.
+func (s *Server) handleSyncNow(w http.ResponseWriter, r *http.Request) {
+	if s.syncer == nil || s.jobs == nil {
+		respondError(w, http.StatusServiceUnavailable, "Syncing is not available")
+		return
+	}
+	if s.syncer.Paused() {
+		respondError(w, http.StatusConflict, "Syncing is paused")
+		return
+	}
+
+	s.submitJob(w, r, jobSync, true, func(ctx context.Context, progress jobs.Progress) (*jobs.Result, error) {
+		s.syncer.SyncNow(ctx)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &jobs.Result{Value: s.syncer.Status()}, nil
+	})
+}
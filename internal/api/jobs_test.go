@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestGetJobAccess(t *testing.T) {
+	const adminToken = "admin-token-0123456789"
+	st := newTestStore(t)
+
+	aliceJob := &store.Job{Kind: jobEvidence, Status: store.JobQueued, CreatedBy: "alice"}
+	adminJob := &store.Job{Kind: jobBulk, Status: store.JobQueued, Admin: true, CreatedBy: "alice"}
+	anonymousJob := &store.Job{Kind: jobDiff, Status: store.JobQueued}
+	for _, job := range []*store.Job{aliceJob, adminJob, anonymousJob} {
+		if err := st.CreateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &Server{store: st, jobs: jobs.New(config.JobsConfig{}, st), adminToken: adminToken}
+	router := chi.NewRouter()
+	router.Get("/jobs/{id}", s.handleGetJob)
+
+	tests := []struct {
+		name       string
+		job        *store.Job
+		user       string
+		admin      bool
+		wantStatus int
+	}{
+		{"submitter", aliceJob, "alice", false, http.StatusOK},
+		{"other user", aliceJob, "bob", false, http.StatusUnauthorized},
+		{"anonymous caller", aliceJob, "", false, http.StatusUnauthorized},
+		{"admin", aliceJob, "", true, http.StatusOK},
+		{"submitter of an admin job", adminJob, "alice", false, http.StatusUnauthorized},
+		{"admin job with the token", adminJob, "", true, http.StatusOK},
+		{"anonymous job to an anonymous caller", anonymousJob, "", false, http.StatusUnauthorized},
+		{"anonymous job to an admin", anonymousJob, "", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/jobs/"+strconv.FormatInt(tt.job.ID, 10), nil)
+			if tt.user != "" {
+				req = req.WithContext(context.WithValue(req.Context(), userContextKey{}, tt.user))
+			}
+			if tt.admin {
+				req.Header.Set("Authorization", "Bearer "+adminToken)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
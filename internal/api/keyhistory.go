@@ -0,0 +1,133 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/store"
+)
+
+// keyHistoryResponse is the timeline of the values of one setting of a file
+type keyHistoryResponse struct {
+	Path   string `json:"path"`
+	Key    string `json:"key"`
+	Format string `json:"format"`
+	// History lists the versions that added, changed or removed the setting,
+	// oldest first
+	History []keyHistoryEntry `json:"history"`
+	// SkippedVersionIDs are the versions whose settings couldn't be read:
+	// those whose content isn't held in full, encrypted ones, and ones that
+	// don't parse. A change made in one of them shows at the next version
+	// that could be read.
+	SkippedVersionIDs []int64 `json:"skipped_version_ids"`
+}
+
+// keyHistoryEntry is a version that changed a setting
+type keyHistoryEntry struct {
+	VersionID  int64              `json:"version_id"`
+	CapturedAt time.Time          `json:"captured_at"`
+	ChangeType store.ChangeType   `json:"change_type"`
+	Author     string             `json:"author,omitempty"`
+	Comment    string             `json:"comment,omitempty"`
+	Type       diff.KeyChangeType `json:"type"`
+	// Value is the setting's value from this version on, empty once removed
+	Value         string `json:"value,omitempty"`
+	PreviousValue string `json:"previous_value,omitempty"`
+}
+
+// handleGetKeyHistory returns every value a setting of a file has had, such
+// as limits.max_connections, from the versions in which it was added,
+// changed or removed. The key is named as in the keys of diffs; a leading $.
+// as in JSONPath is accepted.
+func (s *Server) handleGetKeyHistory(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	key := strings.TrimPrefix(strings.TrimPrefix(getPathParam(r, "key"), "$"), ".")
+	if key == "" {
+		respondError(w, http.StatusBadRequest, "Key is required")
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get key history")
+		return
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	// Files named for no format are read in the language detected from them
+	format := diff.Format(path)
+	if format == "" {
+		format = file.Language
+	}
+	if format == "" {
+		respondError(w, http.StatusBadRequest, "Key history is only available for YAML, JSON, TOML, INI, XML, .env and .properties files")
+		return
+	}
+
+	versions, err := s.store.GetVersionsByFilePath(path)
+	if err != nil {
+		log.Printf("Error getting versions: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get key history")
+		return
+	}
+
+	response := keyHistoryResponse{
+		Path:              path,
+		Key:               key,
+		Format:            format,
+		History:           []keyHistoryEntry{},
+		SkippedVersionIDs: []int64{},
+	}
+	var value string
+	var present bool
+	// Versions are listed newest first
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := &versions[i]
+
+		var newValue string
+		var newPresent bool
+		if version.ChangeType != store.ChangeTypeDeleted {
+			if version.ContentPending || version.Truncated || version.Encrypted {
+				response.SkippedVersionIDs = append(response.SkippedVersionIDs, version.ID)
+				continue
+			}
+			keys, err := diff.ParseKeysAs(format, version.Content)
+			if err != nil {
+				response.SkippedVersionIDs = append(response.SkippedVersionIDs, version.ID)
+				continue
+			}
+			newValue, newPresent = keys[key]
+		}
+
+		entry := keyHistoryEntry{
+			VersionID:     version.ID,
+			CapturedAt:    version.CapturedAt,
+			ChangeType:    version.ChangeType,
+			Author:        version.Author,
+			Comment:       version.Comment,
+			Value:         newValue,
+			PreviousValue: value,
+		}
+		switch {
+		case newPresent && !present:
+			entry.Type = diff.KeyAdded
+			entry.PreviousValue = ""
+		case !newPresent && present:
+			entry.Type = diff.KeyRemoved
+		case newPresent && newValue != value:
+			entry.Type = diff.KeyChanged
+		default:
+			continue
+		}
+		response.History = append(response.History, entry)
+		value, present = newValue, newPresent
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
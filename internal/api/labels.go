@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+// fileLabels is a file's labels split by where they come from
+type fileLabels struct {
+	// Labels are the labels in effect: derived labels overridden by set ones
+	Labels map[string]string `json:"labels"`
+	// Set are the labels set through the API
+	Set map[string]string `json:"set"`
+	// Derived are the labels the configured label rules give the file
+	Derived map[string]string `json:"derived"`
+}
+
+// parseLabelSelectors parses the label query parameters: key=value, or a
+// bare key to match files with the label set to any value. The selectors
+// also match the files the label rules give the label.
+func (s *Server) parseLabelSelectors(values []string) ([]store.LabelSelector, error) {
+	var selectors []store.LabelSelector
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		key, val, found := strings.Cut(value, "=")
+		if err := config.ValidateLabel(key, val); err != nil {
+			return nil, err
+		}
+		sel := store.LabelSelector{Key: key, Value: val, AnyValue: !found}
+		sel.Derived = s.derivedLabelPaths(sel)
+		selectors = append(selectors, sel)
+	}
+	return selectors, nil
+}
+
+// derivedLabelPaths returns the paths whose files the label rules give the
+// label of a selector. Rules apply in order, so a path matched by a rule is
+// excluded where a later rule gives the same key another value.
+func (s *Server) derivedLabelPaths(sel store.LabelSelector) []store.LabelPaths {
+	var paths []store.LabelPaths
+	rules := s.cfg.Labels
+	for i, rule := range rules {
+		value, ok := rule.Labels[sel.Key]
+		if !ok || (!sel.AnyValue && value != sel.Value) {
+			continue
+		}
+		match := store.LabelPaths{Prefix: rule.PathPrefix}
+		if !sel.AnyValue {
+			for _, later := range rules[i+1:] {
+				if v, ok := later.Labels[sel.Key]; ok && v != sel.Value {
+					match.Except = append(match.Except, later.PathPrefix)
+				}
+			}
+		}
+		paths = append(paths, match)
+	}
+	return paths
+}
+
+// effectiveLabels merges the labels derived for a path with the labels set on
+// the file, which take precedence
+func (s *Server) effectiveLabels(blobPath string, set map[string]string) map[string]string {
+	labels := s.cfg.LabelsFor(blobPath)
+	for key, value := range set {
+		labels[key] = value
+	}
+	return labels
+}
+
+// labelFiles fills in the labels of files
+func (s *Server) labelFiles(files []store.FileWithVersionCount) error {
+	set, err := s.store.ListFileLabels()
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		files[i].Labels = s.effectiveLabels(files[i].BlobPath, set[files[i].ID])
+	}
+	return nil
+}
+
+// handleGetLabels returns a file's labels
+func (s *Server) handleGetLabels(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+
+	set, err := s.store.GetFileLabels(file.ID)
+	if err != nil {
+		log.Printf("Error getting labels of %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get labels")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, s.describeLabels(file.BlobPath, set))
+}
+
+// handleSetLabels replaces the labels set on a file through the API. Labels
+// derived from the configured rules stay in effect unless overridden.
+func (s *Server) handleSetLabels(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Labels map[string]string `json:"labels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	for key, value := range req.Labels {
+		if err := config.ValidateLabel(key, value); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := s.store.SetFileLabels(file.ID, req.Labels); err != nil {
+		log.Printf("Error setting labels of %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusInternalServerError, "Failed to set labels")
+		return
+	}
+
+	set := req.Labels
+	if set == nil {
+		set = map[string]string{}
+	}
+	respondJSON(w, http.StatusOK, s.describeLabels(file.BlobPath, set))
+}
+
+// describeLabels splits a file's labels by where they come from
+func (s *Server) describeLabels(blobPath string, set map[string]string) fileLabels {
+	return fileLabels{
+		Labels:  s.effectiveLabels(blobPath, set),
+		Set:     set,
+		Derived: s.cfg.LabelsFor(blobPath),
+	}
+}
+
+// loadFile looks up the file in the URL, responding with an error and
+// returning false if it can't be found
+func (s *Server) loadFile(w http.ResponseWriter, r *http.Request) (*store.File, bool) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return nil, false
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return nil, false
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return nil, false
+	}
+	return file, true
+}
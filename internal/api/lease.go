@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// leaseIDHeader carries the ID of the lease to write a leased blob under
+const leaseIDHeader = "X-Lease-ID"
+
+// requestLeaseID returns the lease ID a request writes under, if any
+func requestLeaseID(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get(leaseIDHeader))
+}
+
+// respondLeased responds with 409 if err is a write refused because the blob
+// is leased, reporting whether it did
+func respondLeased(w http.ResponseWriter, err error) bool {
+	var leased *blob.LeasedError
+	if !errors.As(err, &leased) {
+		return false
+	}
+	message := "Blob is leased"
+	if leased.Duration != "" {
+		message += " (" + leased.Duration + " lease, " + leased.State + ")"
+	}
+	if leased.Mismatch {
+		message += "; the " + leaseIDHeader + " given is not its current lease"
+	} else {
+		message += "; send the lease ID of its holder in the " + leaseIDHeader + " header to write it"
+	}
+	respondError(w, http.StatusConflict, message)
+	return true
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation
+const listenFDsStart = 3
+
+// Listen opens the server's listener: the socket passed by systemd socket
+// activation if there is one, otherwise the configured Unix socket or TCP
+// address. It also returns a description of where it listens, for logging.
+func (s *Server) Listen() (net.Listener, string, error) {
+	l, err := systemdListener()
+	if err != nil {
+		return nil, "", err
+	}
+	if l != nil {
+		return l, "systemd socket " + l.Addr().String(), nil
+	}
+
+	if path := s.cfg.Server.Socket; path != "" {
+		if l, err = listenUnix(path, s.cfg.Server); err != nil {
+			return nil, "", err
+		}
+		return l, "unix:" + path, nil
+	}
+
+	if l, err = net.Listen("tcp", s.Addr); err != nil {
+		return nil, "", err
+	}
+	return l, "http://" + s.Addr, nil
+}
+
+// systemdListener returns the first socket passed by systemd socket
+// activation, or nil if the process wasn't socket activated. The activation
+// variables are cleared so child processes don't pick the sockets up.
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use systemd socket: %w", err)
+	}
+	return l, nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a socket left
+// behind by an earlier run, and applies the configured file mode
+func listenUnix(path string, cfg config.ServerConfig) (net.Listener, error) {
+	mode, err := cfg.SocketFileMode()
+	if err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+	return l, nil
+}
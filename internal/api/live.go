@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// liveURLTTL is how long a live file URL stays valid
+const liveURLTTL = 15 * time.Minute
+
+// liveURLResponse is a short-lived link to the current blob in Azure
+type liveURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleLiveURL mints a short-lived, read-only SAS URL for a tracked file so
+// the UI can open the live blob directly from Azure. The link works for
+// anyone holding it, so who asked for it is logged.
+func (s *Server) handleLiveURL(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file == nil || file.IsDeleted {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	expiresAt := time.Now().Add(liveURLTTL).UTC().Truncate(time.Second)
+	url, err := s.blobClient.ReadSASURL(r.Context(), path, expiresAt)
+	if errors.Is(err, blob.ErrSASUnsupported) {
+		respondError(w, http.StatusNotImplemented, "The storage account's auth method cannot create live file links")
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating SAS URL for %s: %v", path, err)
+		respondError(w, http.StatusBadGateway, "Failed to create live file link")
+		return
+	}
+
+	log.Printf("Audit: live file link to %s created by %q until %s", path, s.currentUser(r), expiresAt.Format(time.RFC3339))
+	respondJSON(w, http.StatusOK, liveURLResponse{URL: url, ExpiresAt: expiresAt})
+}
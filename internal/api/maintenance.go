@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maintenanceState is whether the service is in maintenance mode, and why
+type maintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+	// Since is when maintenance mode was last switched on
+	Since *time.Time `json:"since,omitempty"`
+	// By is who switched it on, if known
+	By string `json:"by,omitempty"`
+}
+
+// maintenance guards the maintenance state of a server
+type maintenance struct {
+	mu    sync.Mutex
+	state maintenanceState
+}
+
+// get returns the current maintenance state
+func (m *maintenance) get() maintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// set replaces the maintenance state
+func (m *maintenance) set(state maintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// maintenanceExempt are the API routes that stay available in maintenance
+// mode despite their method: reads sent as POST, and switching maintenance
+// mode off
+var maintenanceExempt = map[string]bool{
+	"/diffs":             true,
+	"/admin/maintenance": true,
+}
+
+// rejectInMaintenance rejects requests that change anything with 503 while
+// the service is in maintenance mode. Reads are still served.
+func (s *Server) rejectInMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		state := s.maintenance.get()
+		if !state.Enabled || maintenanceExempt[routePath(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := "The vault is in maintenance mode, only reads are served"
+		if state.Reason != "" {
+			message += ": " + state.Reason
+		}
+		respondError(w, http.StatusServiceUnavailable, message)
+	})
+}
+
+// routePath returns the path of a request within the router serving it
+func routePath(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		return strings.TrimSuffix(rctx.RoutePath, "/")
+	}
+	return r.URL.Path
+}
+
+// handleGetMaintenance reports whether the service is in maintenance mode
+func (s *Server) handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.maintenance.get())
+}
+
+// handleSetMaintenance switches maintenance mode on or off, pausing or
+// resuming the syncer with it
+func (s *Server) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool  `json:"enabled"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Enabled == nil {
+		respondError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	state := maintenanceState{Enabled: *req.Enabled}
+	if state.Enabled {
+		now := time.Now().UTC()
+		state.Reason = strings.TrimSpace(req.Reason)
+		state.Since = &now
+		state.By = s.currentUser(r)
+	}
+	s.setMaintenance(state)
+
+	respondJSON(w, http.StatusOK, state)
+}
+
+// setMaintenance switches maintenance mode, pausing the syncer while it is on
+func (s *Server) setMaintenance(state maintenanceState) {
+	s.maintenance.set(state)
+	if s.syncer != nil {
+		s.syncer.SetPaused(state.Enabled)
+	}
+	switch {
+	case state.Enabled && state.Reason != "":
+		log.Printf("Maintenance mode on: %s", state.Reason)
+	case state.Enabled:
+		log.Printf("Maintenance mode on")
+	default:
+		log.Printf("Maintenance mode off")
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/validate"
+)
+
+// restoreMerge is a three-way merge of a restore with a change made in blob
+// storage that hasn't been synced yet: the base is the last synced version,
+// ours the restored version and theirs the live blob
+type restoreMerge struct {
+	diff.MergeResult
+	BaseVersionID    int64 `json:"base_version_id,omitempty"`
+	RestoreVersionID int64 `json:"restore_version_id"`
+	// LiveETag is the ETag of the live blob the merge is based on; saving
+	// the merge fails if the blob has changed again since
+	LiveETag string `json:"live_etag"`
+}
+
+// restoreConflict is the response to a restore that lost a race with a
+// change in blob storage
+type restoreConflict struct {
+	APIError
+	Merge *restoreMerge `json:"merge,omitempty"`
+}
+
+// saveMergeRequest is the body of a resolved merge
+type saveMergeRequest struct {
+	Content        string `json:"content"`
+	LiveETag       string `json:"live_etag"`
+	Comment        string `json:"comment"`
+	SkipValidation bool   `json:"skip_validation"`
+}
+
+// respondRestoreConflict answers a restore that found the blob changed
+// since the last sync with 409 and, where possible, a merge with the change
+func (s *Server) respondRestoreConflict(w http.ResponseWriter, r *http.Request, path string, version *store.Version, content []byte) {
+	conflict := restoreConflict{APIError: APIError{
+		Error:   http.StatusText(http.StatusConflict),
+		Message: "Blob was modified outside the vault since the last sync",
+	}}
+	merge, err := s.mergeRestore(r, path, version, content)
+	if err != nil {
+		log.Printf("Error merging restore of %s: %v", path, err)
+	}
+	if merge == nil {
+		conflict.Message += "; wait for the next sync and restore again"
+	}
+	conflict.Merge = merge
+	respondJSON(w, http.StatusConflict, conflict)
+}
+
+// mergeRestore merges the content of a version being restored with the live
+// blob. It returns nil and no error if the file can't be merged line by line.
+func (s *Server) mergeRestore(r *http.Request, path string, version *store.Version, content []byte) (*restoreMerge, error) {
+	live, err := s.blobClient.GetBlobByFullPath(r.Context(), path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download live blob: %w", err)
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	var base *store.Version
+	if file != nil && !file.IsDeleted {
+		base, err = s.store.GetLatestVersion(file.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get latest version: %w", err)
+		}
+	}
+
+	merge := &restoreMerge{RestoreVersionID: version.ID, LiveETag: live.ETag}
+	baseContent := ""
+	if base != nil {
+		if base.Encrypted || base.Truncated {
+			return nil, nil
+		}
+		if base.ContentPending {
+			if s.syncer == nil {
+				return nil, nil
+			}
+			if err := s.syncer.LoadVersionContent(r.Context(), base); err != nil {
+				return nil, fmt.Errorf("failed to load content of version %d: %w", base.ID, err)
+			}
+		}
+		baseContent = base.Content
+		merge.BaseVersionID = base.ID
+	}
+	if encryption.Detect(content) != "" || encryption.Detect(live.Content) != "" {
+		return nil, nil
+	}
+
+	merge.MergeResult = *diff.Merge(baseContent, string(content), string(live.Content),
+		fmt.Sprintf("restore (v%d)", version.ID), "live")
+	return merge, nil
+}
+
+// handleRestoreMerge returns a three-way merge of a version with the live
+// blob, for restoring it without losing changes that haven't been synced
+func (s *Server) handleRestoreMerge(w http.ResponseWriter, r *http.Request) {
+	path, version, ok := s.loadRestoreVersion(w, r)
+	if !ok {
+		return
+	}
+	content, ok := s.restoreContent(w, r, version)
+	if !ok {
+		return
+	}
+
+	merge, err := s.mergeRestore(r, path, version, content)
+	if blob.IsNotFound(err) {
+		respondError(w, http.StatusNotFound, "Blob not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error merging restore of %s: %v", path, err)
+		respondError(w, http.StatusBadGateway, "Failed to merge with the live blob")
+		return
+	}
+	if merge == nil {
+		respondError(w, http.StatusConflict, "Encrypted and oversized files can't be merged")
+		return
+	}
+	respondJSON(w, http.StatusOK, merge)
+}
+
+// handleSaveRestoreMerge writes a resolved merge to blob storage, provided
+// the blob hasn't changed since the merge
+func (s *Server) handleSaveRestoreMerge(w http.ResponseWriter, r *http.Request) {
+	path, version, ok := s.loadRestoreVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var req saveMergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.LiveETag == "" {
+		respondError(w, http.StatusBadRequest, "live_etag is required")
+		return
+	}
+	comment, ok := parseComment(req.Comment)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Comment is too long")
+		return
+	}
+	if !requireComment(w, comment) {
+		return
+	}
+	if diff.HasConflictMarkers(req.Content) {
+		respondJSON(w, http.StatusUnprocessableEntity, editPreview{
+			Issues: []validate.Issue{{Message: "content still has conflict markers"}},
+		})
+		return
+	}
+
+	// Written like any other edit, over the live blob the merge was made with
+	s.writeEdit(w, r, path, editRequest{
+		Content:        req.Content,
+		Comment:        req.Comment,
+		SkipValidation: req.SkipValidation,
+		ifMatch:        req.LiveETag,
+		restoredFrom:   version.ID,
+	}, comment)
+}
+
+// loadRestoreVersion returns the path and the version of its file named by
+// the versionID URL parameter. On failure it writes the error response and
+// returns false.
+func (s *Server) loadRestoreVersion(w http.ResponseWriter, r *http.Request) (string, *store.Version, bool) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return "", nil, false
+	}
+
+	versionID, err := strconv.ParseInt(chi.URLParam(r, "versionID"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return "", nil, false
+	}
+
+	version, err := s.store.GetVersion(versionID)
+	if err != nil {
+		log.Printf("Error getting version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return "", nil, false
+	}
+	if version == nil {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return "", nil, false
+	}
+
+	// Only the file's own versions can be restored into it
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return "", nil, false
+	}
+	if file == nil || version.FileID != file.ID {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return "", nil, false
+	}
+	return path, version, true
+}
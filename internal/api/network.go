@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeouts of the admin address. Event streams lift the write timeout.
+const (
+	adminReadHeaderTimeout = 10 * time.Second
+	adminReadTimeout       = time.Minute
+	adminWriteTimeout      = 5 * time.Minute
+	adminIdleTimeout       = 2 * time.Minute
+)
+
+// adminListenerKey marks the context of requests received on the admin
+// address
+type adminListenerKey struct{}
+
+// onAdminListener reports whether a request was received on the admin
+// address
+func onAdminListener(r *http.Request) bool {
+	admin, _ := r.Context().Value(adminListenerKey{}).(bool)
+	return admin
+}
+
+// requireWriteAddress refuses requests that change files with 403 on the
+// main address when server.admin_writes restricts them to the admin address
+func (s *Server) requireWriteAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminWrites && !onAdminListener(r) {
+			respondError(w, http.StatusForbidden, "Changes to files are only accepted on the admin address")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowNetworks refuses requests from addresses outside networks with 403.
+// It checks the address of the connection, not X-Forwarded-For, which any
+// client can set. Requests over a Unix socket come from the same host and
+// are allowed.
+func allowNetworks(networks []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, network := range networks {
+				if network.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			log.Printf("Refused request from %s to %s: address not allowed", host, r.URL.Path)
+			respondError(w, http.StatusForbidden, "Address not allowed")
+		})
+	}
+}
+
+// ServeAdmin listens on the admin address, if one is configured, and serves
+// the API on it in the background, admin endpoints included. It returns the
+// address it listens on, or "" if there is none.
+func (s *Server) ServeAdmin() (string, error) {
+	if s.admin == nil {
+		return "", nil
+	}
+	l, err := net.Listen("tcp", s.admin.Addr)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := s.admin.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Admin server error: %v", err)
+		}
+	}()
+	return "http://" + l.Addr().String(), nil
+}
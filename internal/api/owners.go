@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/toggle-vault/internal/owners"
+	"github.com/toggle-vault/internal/store"
+)
+
+// ownersResponse lists the owner rules in effect
+type ownersResponse struct {
+	Rules []owners.Rule `json:"rules"`
+	File  string        `json:"file,omitempty"`
+}
+
+// handleListOwners returns the owner rules in effect, from the configuration
+// followed by the OWNERS file. The last matching rule wins.
+func (s *Server) handleListOwners(w http.ResponseWriter, r *http.Request) {
+	rules := s.owners.Rules()
+	if rules == nil {
+		rules = []owners.Rule{}
+	}
+	respondJSON(w, http.StatusOK, ownersResponse{Rules: rules, File: s.cfg.Owners.File})
+}
+
+// ownFiles fills in the owners of files
+func (s *Server) ownFiles(files []store.FileWithVersionCount) {
+	rules := s.owners.Rules()
+	for i := range files {
+		files[i].Owners = owners.Match(rules, files[i].BlobPath)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/store"
+)
+
+// applyPatchRequest is the body of a patch applied to the latest version
+type applyPatchRequest struct {
+	// Patch is a unified diff of the file, as produced by git diff or
+	// ?format=patch
+	Patch   string `json:"patch"`
+	Comment string `json:"comment"`
+	// BaseVersionID, Preview and SkipValidation work as for content updates
+	BaseVersionID  int64 `json:"base_version_id"`
+	Preview        bool  `json:"preview"`
+	SkipValidation bool  `json:"skip_validation"`
+}
+
+// respondPatch writes the changes between two versions as a patch that git
+// apply accepts
+func (s *Server) respondPatch(w http.ResponseWriter, r *http.Request, path string, version1, version2 *store.Version) {
+	if version1.Encrypted || version2.Encrypted {
+		respondError(w, http.StatusBadRequest, "Patches of encrypted files are not available")
+		return
+	}
+	if version1.Truncated || version2.Truncated {
+		respondError(w, http.StatusBadRequest, "The file exceeds the content size limit; a patch would be incomplete")
+		return
+	}
+	if !s.loadVersionContent(w, r, version1) || !s.loadVersionContent(w, r, version2) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-patch; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, diff.Patch(path, version1.Content, version2.Content))
+}
+
+// handleApplyPatch applies a patch to the latest version of a file and
+// writes the result like a content update
+func (s *Server) handleApplyPatch(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	if path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+
+	var req applyPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	comment, ok := parseComment(req.Comment)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "Comment is too long")
+		return
+	}
+	if !req.Preview && !requireComment(w, comment) {
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	latest, err := s.store.GetLatestVersion(file.ID)
+	if err != nil {
+		log.Printf("Error getting latest version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get latest version")
+		return
+	}
+	var content string
+	if latest != nil {
+		if latest.Encrypted || latest.Truncated {
+			respondError(w, http.StatusBadRequest, "Patches can't be applied to encrypted or oversized files")
+			return
+		}
+		if !s.loadVersionContent(w, r, latest) {
+			return
+		}
+		content = latest.Content
+	}
+
+	patched, err := diff.ApplyPatch(content, req.Patch)
+	var patchErr *diff.PatchError
+	if errors.As(err, &patchErr) {
+		respondError(w, http.StatusUnprocessableEntity, "Patch does not apply: "+patchErr.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error applying patch to %s: %v", path, err)
+		respondError(w, http.StatusInternalServerError, "Failed to apply patch")
+		return
+	}
+
+	// The edit is based on the version the patch was applied to, so a change
+	// in between is a conflict rather than silently overwritten
+	baseVersionID := req.BaseVersionID
+	if baseVersionID == 0 && latest != nil {
+		baseVersionID = latest.ID
+	}
+	s.writeEdit(w, r, path, editRequest{
+		Content:        patched,
+		Comment:        comment,
+		BaseVersionID:  baseVersionID,
+		Preview:        req.Preview,
+		SkipValidation: req.SkipValidation,
+	}, comment)
+}
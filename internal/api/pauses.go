@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// handleListSyncPauses returns the paused storage accounts and containers
+func (s *Server) handleListSyncPauses(w http.ResponseWriter, r *http.Request) {
+	pauses, err := s.store.ListSyncPauses()
+	if err != nil {
+		log.Printf("Error listing sync pauses: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list sync pauses")
+		return
+	}
+	if pauses == nil {
+		pauses = []store.SyncPause{}
+	}
+
+	respondJSON(w, http.StatusOK, pauses)
+}
+
+// handlePauseSync stops syncing a storage account, or one of its containers,
+// from the next sync cycle on. The pause is kept across restarts until it is
+// removed.
+func (s *Server) handlePauseSync(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		StorageAccount string `json:"storage_account"`
+		Container      string `json:"container"`
+		Reason         string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.StorageAccount = strings.TrimSpace(req.StorageAccount)
+	req.Container = strings.TrimSpace(req.Container)
+	if strings.Contains(req.Container, "/") {
+		respondError(w, http.StatusBadRequest, "Invalid container name")
+		return
+	}
+	if !s.checkStorageAccount(w, req.StorageAccount) {
+		return
+	}
+
+	pause := &store.SyncPause{
+		StorageAccount: req.StorageAccount,
+		Container:      req.Container,
+		Reason:         strings.TrimSpace(req.Reason),
+		PausedBy:       s.currentUser(r),
+	}
+	if err := s.store.PauseSync(pause); err != nil {
+		log.Printf("Error pausing sync: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to pause sync")
+		return
+	}
+
+	log.Printf("Sync of %s paused by %s: %s", pauseScope(pause), pause.PausedBy, pause.Reason)
+	respondJSON(w, http.StatusCreated, pause)
+}
+
+// handleResumeSync removes a pause, so the next sync cycle syncs the storage
+// account or container again
+func (s *Server) handleResumeSync(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid pause ID")
+		return
+	}
+
+	found, err := s.store.ResumeSync(id)
+	if err != nil {
+		log.Printf("Error resuming sync pause %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Failed to resume sync")
+		return
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, "Sync pause not found")
+		return
+	}
+
+	log.Printf("Sync pause %d removed by %s", id, s.currentUser(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pauseScope names what a pause applies to, for logging
+func pauseScope(pause *store.SyncPause) string {
+	if pause.Container == "" {
+		return pause.StorageAccount
+	}
+	return pause.StorageAccount + "/" + pause.Container
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/preview"
+)
+
+// previewResponse is the highlighted content of a version
+type previewResponse struct {
+	VersionID int64  `json:"version_id"`
+	Language  string `json:"language"`
+	// Truncated is set when only the start of the content was captured
+	Truncated bool           `json:"truncated"`
+	Lines     []preview.Line `json:"lines"`
+}
+
+// handlePreview renders the content of a version highlighted as the language
+// detected for its file, as standalone HTML with inline styles, or as JSON
+// lines of classified tokens with format=json
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	path := getPathParam(r, "path")
+	versionID, err := strconv.ParseInt(chi.URLParam(r, "versionID"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid version ID")
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	version, err := s.store.GetVersion(versionID)
+	if err != nil {
+		log.Printf("Error getting version: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	if file == nil || version == nil || version.FileID != file.ID {
+		respondError(w, http.StatusNotFound, "Version not found")
+		return
+	}
+	if !s.loadVersionContent(w, r, version) {
+		return
+	}
+
+	language := file.Language
+	switch r.URL.Query().Get("format") {
+	case "", "html":
+	case "json":
+		respondJSON(w, http.StatusOK, previewResponse{
+			VersionID: version.ID,
+			Language:  language,
+			Truncated: version.Truncated,
+			Lines:     preview.Highlight(language, version.Content),
+		})
+		return
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid format, must be html or json")
+		return
+	}
+
+	opts := preview.HTMLOptions{
+		Title:     path,
+		Label:     fmt.Sprintf("v%d", version.ID),
+		Language:  language,
+		Truncated: version.Truncated,
+	}
+	if s.cfg.Email.BaseURL != "" {
+		opts.Link = strings.TrimRight(s.cfg.Email.BaseURL, "/") + "/?q=" + url.QueryEscape(path)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := preview.RenderHTML(w, version.Content, opts); err != nil {
+		log.Printf("Error rendering preview: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/config"
+)
+
+func TestPreview(t *testing.T) {
+	st := newTestStore(t)
+	versions := createTestVersions(t, st, "account/container/app.conf", "checkout: true\n")
+	other := createTestVersions(t, st, "account/container/other.conf", "checkout: false\n")
+	file, err := st.GetFile("account/container/app.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetFileLanguage(file.ID, "properties"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{cfg: config.Default(), store: st}
+	router := chi.NewRouter()
+	router.Get("/files/{path:.*}/versions/{versionID}/preview", s.handlePreview)
+
+	tests := []struct {
+		name         string
+		versionID    int64
+		wantStatus   int
+		wantLanguage string
+	}{
+		{"version of the file", versions[0], http.StatusOK, "properties"},
+		{"version of another file", other[0], http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/files/%s/versions/%d/preview?format=json", url.PathEscape(file.BlobPath), tt.versionID), nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var resp previewResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Language != tt.wantLanguage {
+				t.Errorf("language = %q, want the file's %q", resp.Language, tt.wantLanguage)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/approval"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	defaultProposalLimit = 100
+	maxProposalLimit     = 1000
+)
+
+// proposalDetail is a proposal together with what it would change now
+type proposalDetail struct {
+	store.Proposal
+	Diff *diff.DiffResult `json:"diff"`
+}
+
+// propose stores a change as a proposal awaiting approval and responds with
+// 202 Accepted
+func (s *Server) propose(w http.ResponseWriter, r *http.Request, p *store.Proposal) {
+	if err := s.approvals.Propose(r.Context(), p); err != nil {
+		log.Printf("Error creating proposal for %s: %v", p.BlobPath, err)
+		if p.ID != 0 {
+			// Stored, but its pull request could not be opened
+			respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to open a pull request for proposal %d", p.ID))
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to create proposal")
+		return
+	}
+	respondJSON(w, http.StatusAccepted, p)
+}
+
+// proposeRestore creates a proposal to restore a version, based on the
+// file's current state
+func (s *Server) proposeRestore(w http.ResponseWriter, r *http.Request, path string, versionID int64, content []byte, comment string) {
+	user := s.currentUser(r)
+	if user == "" {
+		respondError(w, http.StatusUnauthorized, "Missing user identity (an "+apiKeyHeader+" header, or "+s.userHeader+" from a trusted proxy); restores of this file need approval")
+		return
+	}
+
+	file, err := s.store.GetFile(path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+
+	proposal := &store.Proposal{
+		BlobPath:         path,
+		Kind:             store.ProposalRestore,
+		Content:          string(content),
+		RestoreVersionID: versionID,
+		Author:           user,
+		Comment:          comment,
+	}
+	if file != nil {
+		latest, err := s.store.GetLatestVersion(file.ID)
+		if err != nil {
+			log.Printf("Error getting latest version: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to get latest version")
+			return
+		}
+		if latest != nil {
+			proposal.BaseVersionID = latest.ID
+		}
+		if !file.IsDeleted {
+			proposal.BaseETag = file.ETag
+		}
+	}
+
+	s.propose(w, r, proposal)
+}
+
+// handleListProposals lists proposals, optionally filtered by status, path
+// and author
+func (s *Server) handleListProposals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := store.ProposalQuery{
+		Status:   store.ProposalStatus(q.Get("status")),
+		BlobPath: q.Get("path"),
+		Author:   q.Get("author"),
+		Limit:    defaultProposalLimit,
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxProposalLimit {
+			limit = maxProposalLimit
+		}
+		query.Limit = limit
+	}
+
+	proposals, err := s.store.ListProposals(query)
+	if err != nil {
+		log.Printf("Error listing proposals: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list proposals")
+		return
+	}
+
+	if proposals == nil {
+		proposals = []store.Proposal{}
+	}
+
+	respondJSON(w, http.StatusOK, proposals)
+}
+
+// handleGetProposal returns a proposal with a diff against the file's
+// current content
+func (s *Server) handleGetProposal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid proposal ID")
+		return
+	}
+
+	p, err := s.store.GetProposal(id)
+	if err != nil {
+		log.Printf("Error getting proposal: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get proposal")
+		return
+	}
+	if p == nil {
+		respondError(w, http.StatusNotFound, "Proposal not found")
+		return
+	}
+
+	oldContent := ""
+	file, err := s.store.GetFile(p.BlobPath)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get file")
+		return
+	}
+	if file != nil {
+		latest, err := s.store.GetLatestVersion(file.ID)
+		if err != nil {
+			log.Printf("Error getting latest version: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to get latest version")
+			return
+		}
+		if latest != nil {
+			if !s.loadVersionContent(w, r, latest) {
+				return
+			}
+			oldContent = latest.Content
+		}
+	}
+
+	respondJSON(w, http.StatusOK, proposalDetail{
+		Proposal: *p,
+		Diff: s.compareFiles(r.Context(), p.BlobPath, oldContent, p.Content,
+			fmt.Sprintf("%s (current)", p.BlobPath), fmt.Sprintf("%s (proposed)", p.BlobPath), s.diffOptions()),
+	})
+}
+
+// handleApproveProposal approves a proposal and writes it to blob storage
+func (s *Server) handleApproveProposal(w http.ResponseWriter, r *http.Request) {
+	s.reviewProposal(w, r, func(id int64, user, comment string) (*store.Proposal, error) {
+		return s.approvals.Approve(r.Context(), id, user, comment)
+	})
+}
+
+// handleRejectProposal rejects a proposal
+func (s *Server) handleRejectProposal(w http.ResponseWriter, r *http.Request) {
+	s.reviewProposal(w, r, s.approvals.Reject)
+}
+
+// handleWithdrawProposal lets the author withdraw their proposal
+func (s *Server) handleWithdrawProposal(w http.ResponseWriter, r *http.Request) {
+	s.reviewProposal(w, r, func(id int64, user, _ string) (*store.Proposal, error) {
+		return s.approvals.Withdraw(r.Context(), id, user)
+	})
+}
+
+// reviewProposal parses a review request and applies a state transition,
+// mapping workflow errors to HTTP status codes
+func (s *Server) reviewProposal(w http.ResponseWriter, r *http.Request, act func(id int64, user, comment string) (*store.Proposal, error)) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid proposal ID")
+		return
+	}
+
+	// The body is optional and may carry a review comment
+	comment, ok := parseOptionalComment(w, r)
+	if !ok {
+		return
+	}
+
+	p, err := act(id, s.currentUser(r), comment)
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		respondError(w, http.StatusNotFound, "Proposal not found")
+	case errors.Is(err, approval.ErrNotPending), errors.Is(err, approval.ErrReviewedOnGitHub):
+		respondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, approval.ErrSelfApproval), errors.Is(err, approval.ErrNotAuthor):
+		respondError(w, http.StatusForbidden, err.Error())
+	case err != nil:
+		log.Printf("Error reviewing proposal %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Failed to update proposal")
+	default:
+		respondJSON(w, http.StatusOK, p)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+// readOnly returns why the blob at path can't be written, or "" if it can
+func (s *Server) readOnly(path string) string {
+	if s.syncer == nil {
+		return ""
+	}
+	return s.syncer.ReadOnly(path)
+}
+
+// markReadOnly fills in why files can't be written, for those that can't
+func (s *Server) markReadOnly(files []store.FileWithVersionCount) {
+	for i := range files {
+		files[i].ReadOnly = s.readOnly(files[i].BlobPath)
+	}
+}
+
+// rejectReadOnly responds with 409 if the blob at path can't be written,
+// before anything is proposed or written, reporting whether it did
+func (s *Server) rejectReadOnly(w http.ResponseWriter, path string) bool {
+	reason := s.readOnly(path)
+	if reason == "" {
+		return false
+	}
+	respondError(w, http.StatusConflict, "File is read-only and can't be restored or edited: "+reason)
+	return true
+}
+
+// respondImmutable responds with 409 if err is a write refused because the
+// blob is immutable, reporting whether it did
+func respondImmutable(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, blob.ErrImmutable) {
+		return false
+	}
+	respondError(w, http.StatusConflict, "File is read-only and can't be restored or edited: "+err.Error())
+	return true
+}
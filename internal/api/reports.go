@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/owners"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// defaultReportMonths is how many months back a team report starts
+	// unless since is given
+	defaultReportMonths = 6
+	// unownedTeam is the team of changes to files without owners
+	unownedTeam = "(unowned)"
+)
+
+// Periods of team reports
+const (
+	periodWeek  = "week"
+	periodMonth = "month"
+)
+
+// teamReport is the change velocity of each team per period
+type teamReport struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Period   string    `json:"period"`
+	Timezone string    `json:"timezone"`
+	// Rows are the periods of each team with changes, by team and then
+	// period
+	Rows []teamPeriod `json:"rows"`
+	// Totals are each team's counts over the whole report
+	Totals []teamPeriod `json:"totals"`
+}
+
+// teamPeriod is what a team changed in one period, or in the whole report
+type teamPeriod struct {
+	Team        string     `json:"team"`
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	// Changes is the number of versions recorded
+	Changes      int `json:"changes"`
+	FilesChanged int `json:"files_changed"`
+	// OutOfHours is the number of changes captured outside reports working
+	// hours
+	OutOfHours int `json:"out_of_hours"`
+	Restores   int `json:"restores"`
+	Deletions  int `json:"deletions"`
+	// RestoreRate is the fraction of changes that were restores, a measure of
+	// how often changes had to be rolled back
+	RestoreRate float64 `json:"restore_rate"`
+
+	files map[int64]bool
+}
+
+// add counts a change
+func (p *teamPeriod) add(change store.ChangeEvent, hours *config.WorkingHours) {
+	p.Changes++
+	if !p.files[change.FileID] {
+		p.files[change.FileID] = true
+		p.FilesChanged++
+	}
+	if hours.OutOfHours(change.CapturedAt) {
+		p.OutOfHours++
+	}
+	if change.RestoredFromVersionID != 0 {
+		p.Restores++
+	}
+	if change.ChangeType == store.ChangeTypeDeleted {
+		p.Deletions++
+	}
+	p.RestoreRate = math.Round(float64(p.Restores)/float64(p.Changes)*1000) / 1000
+}
+
+// handleTeamReport reports the changes, files changed, out-of-hours changes,
+// restores and deletions of each team, by the owners of the files, per week
+// or month (the default). It covers since to until, by default the last six
+// months, and is downloaded as CSV with format=csv.
+func (s *Server) handleTeamReport(w http.ResponseWriter, r *http.Request) {
+	hours, err := s.cfg.Reports.WorkingHours()
+	if err != nil {
+		log.Printf("Error reading working hours: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create report")
+		return
+	}
+
+	q := r.URL.Query()
+	period := q.Get("period")
+	switch period {
+	case "":
+		period = periodMonth
+	case periodWeek, periodMonth:
+	default:
+		respondError(w, http.StatusBadRequest, "period must be week or month")
+		return
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		respondError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	since, err := parseTimeParam(q.Get("since"), false)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid since: "+err.Error())
+		return
+	}
+	until, err := parseTimeParam(q.Get("until"), true)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid until: "+err.Error())
+		return
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if since.IsZero() {
+		since = periodStart(until.In(hours.Location), periodMonth).AddDate(0, 1-defaultReportMonths, 0)
+	}
+	if !since.Before(until) {
+		respondError(w, http.StatusBadRequest, "since must be before until")
+		return
+	}
+
+	changes, err := s.store.SearchChanges(store.SearchQuery{Since: since, Until: until})
+	if err != nil {
+		log.Printf("Error listing changes for team report: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create report")
+		return
+	}
+
+	report := buildTeamReport(changes, s.owners.Rules(), hours, period)
+	report.Since, report.Until = since, until
+	report.Timezone = s.cfg.Reports.Timezone
+
+	if format == "csv" {
+		respondTeamReportCSV(w, report)
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
+
+// buildTeamReport counts changes for each owner of the changed file, per
+// period starting in the working hours' time zone
+func buildTeamReport(changes []store.ChangeEvent, rules []owners.Rule, hours *config.WorkingHours, period string) teamReport {
+	type rowKey struct {
+		team  string
+		start time.Time
+	}
+	rows := make(map[rowKey]*teamPeriod)
+	totals := make(map[string]*teamPeriod)
+
+	for _, change := range changes {
+		teams := owners.Match(rules, change.BlobPath)
+		if len(teams) == 0 {
+			teams = []string{unownedTeam}
+		}
+		start := periodStart(change.CapturedAt.In(hours.Location), period)
+		for _, team := range teams {
+			key := rowKey{team: team, start: start}
+			row, ok := rows[key]
+			if !ok {
+				row = &teamPeriod{Team: team, PeriodStart: &key.start, files: make(map[int64]bool)}
+				rows[key] = row
+			}
+			row.add(change, hours)
+
+			total, ok := totals[team]
+			if !ok {
+				total = &teamPeriod{Team: team, files: make(map[int64]bool)}
+				totals[team] = total
+			}
+			total.add(change, hours)
+		}
+	}
+
+	report := teamReport{Period: period, Rows: []teamPeriod{}, Totals: []teamPeriod{}}
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.Team != b.Team {
+			return a.Team < b.Team
+		}
+		return a.PeriodStart.Before(*b.PeriodStart)
+	})
+	for _, total := range totals {
+		report.Totals = append(report.Totals, *total)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool {
+		return report.Totals[i].Team < report.Totals[j].Team
+	})
+	return report
+}
+
+// periodStart returns the start of the week (Monday) or month t is in, in
+// t's location
+func periodStart(t time.Time, period string) time.Time {
+	if period == periodWeek {
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// respondTeamReportCSV responds with the rows of a team report as a CSV
+// download, one line per team and period
+func respondTeamReportCSV(w http.ResponseWriter, report teamReport) {
+	name := fmt.Sprintf("team-report-%s-%s.csv", report.Since.Format("2006-01-02"), report.Until.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"team", "period_start", "changes", "files_changed", "out_of_hours", "restores", "deletions", "restore_rate"})
+	for _, row := range report.Rows {
+		out.Write([]string{
+			row.Team,
+			row.PeriodStart.Format("2006-01-02"),
+			strconv.Itoa(row.Changes),
+			strconv.Itoa(row.FilesChanged),
+			strconv.Itoa(row.OutOfHours),
+			strconv.Itoa(row.Restores),
+			strconv.Itoa(row.Deletions),
+			strconv.FormatFloat(row.RestoreRate, 'f', 3, 64),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error writing team report: %v", err)
+	}
+}
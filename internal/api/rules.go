@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// ruleRequest is the body of a create or update tracking rule request
+type ruleRequest struct {
+	StorageAccount string   `json:"storage_account"`
+	Container      string   `json:"container"`
+	Prefix         string   `json:"prefix"`
+	Patterns       []string `json:"patterns"`
+}
+
+// parseRuleRequest decodes and validates a tracking rule request, responding
+// with 400 and returning false if it is invalid
+func (s *Server) parseRuleRequest(w http.ResponseWriter, r *http.Request) (*ruleRequest, bool) {
+	var req ruleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+
+	req.StorageAccount = strings.TrimSpace(req.StorageAccount)
+	req.Container = strings.TrimSpace(req.Container)
+	if req.Container == "" || strings.Contains(req.Container, "/") {
+		respondError(w, http.StatusBadRequest, "A container name is required")
+		return nil, false
+	}
+
+	if !s.checkStorageAccount(w, req.StorageAccount) {
+		return nil, false
+	}
+
+	var patterns []string
+	for _, pattern := range req.Patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid pattern %q", pattern))
+			return nil, false
+		}
+		patterns = append(patterns, pattern)
+	}
+	req.Patterns = patterns
+
+	return &req, true
+}
+
+// checkStorageAccount responds with 400 and returns false if name isn't a
+// configured storage account
+func (s *Server) checkStorageAccount(w http.ResponseWriter, name string) bool {
+	accounts := s.blobClient.GetStorageAccountNames()
+	for _, account := range accounts {
+		if account == name {
+			return true
+		}
+	}
+	respondError(w, http.StatusBadRequest,
+		fmt.Sprintf("Unknown storage account (configured: %s)", strings.Join(accounts, ", ")))
+	return false
+}
+
+// handleListRules returns all tracking rules
+func (s *Server) handleListRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.store.ListTrackingRules()
+	if err != nil {
+		log.Printf("Error listing tracking rules: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list tracking rules")
+		return
+	}
+
+	if rules == nil {
+		rules = []store.TrackingRule{}
+	}
+
+	respondJSON(w, http.StatusOK, rules)
+}
+
+// handleCreateRule adds a tracking rule, picked up by the next sync cycle
+func (s *Server) handleCreateRule(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.parseRuleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rule := &store.TrackingRule{
+		StorageAccount: req.StorageAccount,
+		Container:      req.Container,
+		Prefix:         req.Prefix,
+		Patterns:       req.Patterns,
+		CreatedBy:      s.currentUser(r),
+	}
+	if err := s.store.CreateTrackingRule(rule); err != nil {
+		log.Printf("Error creating tracking rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create tracking rule")
+		return
+	}
+
+	log.Printf("Tracking rule %d added by %s: %s/%s/%s", rule.ID, rule.CreatedBy, rule.StorageAccount, rule.Container, rule.Prefix)
+	respondJSON(w, http.StatusCreated, rule)
+}
+
+// handleGetRule returns a single tracking rule
+func (s *Server) handleGetRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.loadRule(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// handleUpdateRule replaces the scope of a tracking rule
+func (s *Server) handleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.loadRule(w, r)
+	if !ok {
+		return
+	}
+	req, ok := s.parseRuleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rule.StorageAccount = req.StorageAccount
+	rule.Container = req.Container
+	rule.Prefix = req.Prefix
+	rule.Patterns = req.Patterns
+	if err := s.store.UpdateTrackingRule(rule); err != nil {
+		log.Printf("Error updating tracking rule %d: %v", rule.ID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to update tracking rule")
+		return
+	}
+
+	log.Printf("Tracking rule %d updated by %s", rule.ID, s.currentUser(r))
+	respondJSON(w, http.StatusOK, rule)
+}
+
+// handleDeleteRule removes a tracking rule. Files only it tracked are
+// recorded as deleted by the next sync cycle.
+func (s *Server) handleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.loadRule(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.store.DeleteTrackingRule(rule.ID)
+	if err != nil {
+		log.Printf("Error deleting tracking rule %d: %v", rule.ID, err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete tracking rule")
+		return
+	}
+
+	log.Printf("Tracking rule %d deleted by %s", rule.ID, s.currentUser(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadRule looks up the tracking rule in the URL, responding with an error
+// and returning false if it can't be found
+func (s *Server) loadRule(w http.ResponseWriter, r *http.Request) (*store.TrackingRule, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return nil, false
+	}
+
+	rule, err := s.store.GetTrackingRule(id)
+	if err != nil {
+		log.Printf("Error getting tracking rule: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get tracking rule")
+		return nil, false
+	}
+	if rule == nil {
+		respondError(w, http.StatusNotFound, "Tracking rule not found")
+		return nil, false
+	}
+	return rule, true
+}
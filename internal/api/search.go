@@ -0,0 +1,127 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	defaultSearchLimit = 200
+	maxSearchLimit     = 1000
+)
+
+// handleSearch searches the change history across all tracked files.
+//
+// Supported query parameters:
+//   - q: text matched against the blob path and version content
+//   - storage_account, container: scope results to a storage account and/or container
+//   - change_type: created, modified, deleted or recreated
+//   - since, until: RFC3339 timestamps or YYYY-MM-DD dates (until is exclusive;
+//     a bare date includes the whole day)
+//   - limit: maximum number of results (default 200, max 1000)
+//   - label: key=value, or a bare key, that files must be labelled with
+//     (repeatable; all must match)
+//   - tag: a tag the versions must have
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := store.SearchQuery{
+		Text:           q.Get("q"),
+		StorageAccount: q.Get("storage_account"),
+		Container:      q.Get("container"),
+		Tag:            q.Get("tag"),
+		Limit:          defaultSearchLimit,
+	}
+
+	if !parseChangeFilters(w, q, &query) {
+		return
+	}
+
+	var err error
+	if query.Labels, err = s.parseLabelSelectors(q["label"]); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid label: "+err.Error())
+		return
+	}
+
+	events, err := s.store.SearchChanges(query)
+	if err != nil {
+		log.Printf("Error searching changes: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to search changes")
+		return
+	}
+
+	if events == nil {
+		events = []store.ChangeEvent{}
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
+
+// parseChangeFilters reads the change_type, since, until and limit query
+// parameters into query, responding with 400 and returning false if any is
+// invalid
+func parseChangeFilters(w http.ResponseWriter, q url.Values, query *store.SearchQuery) bool {
+	query.ChangeType = store.ChangeType(q.Get("change_type"))
+	if query.ChangeType != "" && !query.ChangeType.Known() {
+		respondError(w, http.StatusBadRequest, "Invalid change_type")
+		return false
+	}
+
+	var err error
+	if query.Since, err = parseTimeParam(q.Get("since"), false); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid since: "+err.Error())
+		return false
+	}
+	if query.Until, err = parseTimeParam(q.Get("until"), true); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid until: "+err.Error())
+		return false
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return false
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+		query.Limit = limit
+	}
+
+	return true
+}
+
+// parseTimeParam parses an RFC3339 timestamp or a YYYY-MM-DD date.
+// When endOfDay is set, a bare date resolves to the start of the following day
+// so that it can be used as an exclusive upper bound.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 timestamp or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// handleListChangeTypes returns the known change types, built-in ones first,
+// for change type filters
+func (s *Server) handleListChangeTypes(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, store.ChangeTypes())
+}
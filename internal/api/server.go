@@ -3,14 +3,27 @@ package api
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/toggle-vault/internal/approval"
 	"github.com/toggle-vault/internal/blob"
 	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/integrity"
+	"github.com/toggle-vault/internal/jobs"
+	"github.com/toggle-vault/internal/owners"
+	"github.com/toggle-vault/internal/settings"
 	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
 	"github.com/toggle-vault/web"
 )
 
@@ -20,65 +33,354 @@ type Server struct {
 	router     chi.Router
 	store      store.Store
 	blobClient *blob.Client
+	events     *events.Broker
+	syncer     *syncer.Syncer
+	approvals  *approval.Service
+	cfg        *config.Config
+	owners     *owners.Resolver
+	signer     *integrity.Signer
+	decrypter  *encryption.Decrypter
+	userHeader string
+	// trustUserHeader believes the user header of any request, for Routes,
+	// whose callers authenticate requests themselves
+	trustUserHeader bool
+	adminToken      string
+	// admin serves the API on the admin address, if configured
+	admin *http.Server
+	// adminWrites only accepts requests that change files on the admin
+	// address
+	adminWrites bool
+	// maintenance is whether mutating requests are rejected
+	maintenance maintenance
+	// diffs are the large diffs computed in the background
+	diffs *diffCache
+	// jobs runs long operations in the background
+	jobs *jobs.Runner
+	// settings are switched at runtime by the settings file, if configured
+	settings *settings.Watcher
+	// watchGeneration counts changes to watches, so that event streams
+	// reload their user's watches only after one
+	watchGeneration atomic.Int64
 }
 
 // NewServer creates a new HTTP server with all routes configured
-func NewServer(cfg config.ServerConfig, st store.Store, blobClient *blob.Client) *Server {
+func NewServer(cfg *config.Config, st store.Store, blobClient *blob.Client, broker *events.Broker, syncService *syncer.Syncer, approvals *approval.Service, jobRunner *jobs.Runner, settingsWatcher *settings.Watcher, signer *integrity.Signer, decrypter *encryption.Decrypter) *Server {
 	r := chi.NewRouter()
 
-	// Middleware
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	// Middleware. The allowlist checks the connection's address, so it comes
+	// before RealIP replaces it with a forwarded one.
+	if networks, _ := cfg.Server.AllowedNetworks(); len(networks) > 0 {
+		r.Use(allowNetworks(networks))
+	}
+	r.Use(identify(cfg.Server))
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	if logger := accessLog(cfg.Server.AccessLog); logger != nil {
+		r.Use(logger)
+	}
+	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-ID"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-ID", leaseIDHeader, idempotencyKeyHeader, apiKeyHeader, cfg.Server.UserHeader},
+		ExposedHeaders:   []string{"Link", "Retry-After", idempotentReplayedHeader},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	s := &Server{
 		Server: &http.Server{
-			Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-			Handler: r,
+			Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+			Handler: withBasePath(cfg.Server.BasePath, r),
 		},
 		router:     r,
 		store:      st,
 		blobClient: blobClient,
+		events:     broker,
+		syncer:     syncService,
+		approvals:  approvals,
+		cfg:        cfg,
+		owners:     owners.New(cfg.Owners, st),
+		signer:     signer,
+		decrypter:  decrypter,
+		userHeader: cfg.Server.UserHeader,
+		adminToken: cfg.Server.AdminToken,
+		diffs:      newDiffCache(cfg.Server.Diff.CacheSize),
+		jobs:       jobRunner,
+		settings:   settingsWatcher,
+	}
+
+	if cfg.Server.AdminAddress != "" {
+		handler := s.Handler
+		s.admin = &http.Server{
+			Addr: cfg.Server.AdminAddress,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminListenerKey{}, true)))
+			}),
+			ReadHeaderTimeout: adminReadHeaderTimeout,
+			ReadTimeout:       adminReadTimeout,
+			WriteTimeout:      adminWriteTimeout,
+			IdleTimeout:       adminIdleTimeout,
+		}
+		s.adminWrites = cfg.Server.AdminWrites
+	}
+
+	if cfg.Maintenance.Enabled {
+		now := time.Now().UTC()
+		s.setMaintenance(maintenanceState{Enabled: true, Reason: cfg.Maintenance.Reason, Since: &now})
 	}
 
 	// Setup routes
 	s.setupRoutes()
 
+	if cfg.Server.Pprof {
+		r.With(s.requireAdmin).Mount("/debug", middleware.Profiler())
+	}
+
 	return s
 }
 
+// withBasePath serves next under basePath by removing it from request paths.
+// Paths without it are passed on unchanged, for proxies that remove it first.
+func withBasePath(basePath string, next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			// The UI loads its assets relative to the page
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, basePath+"/") {
+			http.StripPrefix(basePath, next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Routes returns the API alone, for mounting under a sub-path of another
+// service's router, which brings its own middleware such as authentication:
+//
+//	r.Mount("/toggle-vault", api.Routes(st, blobClient))
+//
+// The routes are those served under /api/v1 by the full server, with every
+// setting at its default. The syncer is not started, so files are only
+// recorded through the API, and the features configured in toggle-vault's
+// config file (approvals, labels, owners, signing, admin endpoints) are off. Callers are identified by the
+// X-Toggle-Vault-User header, which the caller's middleware must set or
+// strip.
+func Routes(st store.Store, blobClient *blob.Client) chi.Router {
+	cfg := config.Default()
+	broker := events.NewBroker()
+	syncService := syncer.New(blobClient, st, cfg.Sync, broker, hooks.Builtin())
+
+	s := &Server{
+		store:           st,
+		blobClient:      blobClient,
+		events:          broker,
+		syncer:          syncService,
+		approvals:       approval.New(cfg.Approvals, st, syncService, broker),
+		cfg:             cfg,
+		owners:          owners.New(cfg.Owners, st),
+		userHeader:      cfg.Server.UserHeader,
+		trustUserHeader: true,
+		diffs:           newDiffCache(cfg.Server.Diff.CacheSize),
+	}
+
+	r := chi.NewRouter()
+	s.apiRoutes(r)
+	return r
+}
+
 // setupRoutes configures all API routes
 func (s *Server) setupRoutes() {
-	// API routes
+	// API routes. The unversioned paths of the first API stay until
+	// api_sunset for existing callers, answering with deprecation headers.
+	s.router.Route(apiPrefix, s.apiRoutes)
 	s.router.Route("/api", func(r chi.Router) {
-		r.Use(middleware.SetHeader("Content-Type", "application/json"))
+		r.Use(deprecate(s.unversionedAPI()))
+		s.apiRoutes(r)
+	})
+
+	// Build info, at a path that stays the same across API versions
+	s.router.With(middleware.SetHeader("Content-Type", "application/json")).Get("/api/version", s.handleVersion)
 
-		// Health check
-		r.Get("/health", s.handleHealth)
+	// Readiness probe, outside /api like the orchestrators expect it
+	s.router.With(middleware.SetHeader("Content-Type", "application/json")).Get("/readyz", s.handleReady)
 
-		// Files
-		r.Get("/files", s.handleListFiles)
-		r.Get("/files/{path:.*}/versions", s.handleGetVersions)
-		r.Get("/files/{path:.*}/versions/{versionID}", s.handleGetVersion)
-		r.Get("/files/{path:.*}/diff/{v1}/{v2}", s.handleDiff)
-		r.Post("/files/{path:.*}/restore/{versionID}", s.handleRestore)
-		r.Get("/files/{path:.*}", s.handleGetFile)
+	// Change feeds for RSS readers
+	s.router.Get("/feeds/changes.xml", s.handleChangesFeed)
+
+	// Links shared with view tokens, read-only and limited to one file's
+	// history or one diff
+	s.router.Route("/share/{token}", func(r chi.Router) {
+		r.Use(middleware.SetHeader("Content-Type", "application/json"))
+		r.Use(s.requireViewToken)
+		r.Get("/", s.handleShare)
+		r.With(s.requireSharedVersions).Get("/versions/{versionID}", s.handleGetVersion)
+		r.With(s.requireSharedVersions).Get("/versions/{versionID}/raw", s.handleDownloadVersion)
+		r.With(s.requireSharedVersions).Get("/versions/{versionID}/preview", s.handlePreview)
+		r.With(s.requireSharedVersions).Get("/diff/{v1}/{v2}", s.handleDiff)
+		r.With(s.requireSharedVersions).Get("/diff/{v1}/{v2}/html", s.handleDiffHTML)
 	})
 
 	// Serve static files for web UI
 	s.router.Handle("/*", http.FileServer(http.FS(web.StaticFiles)))
 }
 
+// apiRoutes configures the API routes on r
+func (s *Server) apiRoutes(r chi.Router) {
+	r.Use(middleware.SetHeader("Content-Type", "application/json"))
+	r.Use(s.rejectInMaintenance)
+
+	// Health check
+	r.Get("/health", s.handleHealth)
+	r.Get("/version", s.handleVersion)
+
+	// Settings switched at runtime by the settings file
+	r.Get("/settings", s.handleGetSettings)
+
+	// Maintenance mode
+	r.Get("/maintenance", s.handleGetMaintenance)
+	r.With(s.requireAdmin).Put("/admin/maintenance", s.handleSetMaintenance)
+
+	// View tokens sharing a file's history or a diff
+	r.With(s.requireUser).Post("/shares", s.handleCreateShare)
+	r.With(s.requireUser).Get("/shares", s.handleListShares)
+	r.With(s.requireUser).Delete("/shares/{id}", s.handleRevokeShare)
+	r.With(s.requireAdmin).Get("/admin/shares", s.handleListAllShares)
+	r.With(s.requireAdmin).Delete("/admin/shares/{id}", s.handleRevokeAnyShare)
+
+	// Search
+	r.Get("/search", s.handleSearch)
+	r.Get("/change-types", s.handleListChangeTypes)
+
+	// Live change events (Server-Sent Events)
+	r.Get("/events", s.handleEvents)
+
+	// E-mail subscriptions
+	r.Get("/subscriptions", s.handleListSubscriptions)
+	r.With(s.requireUser).Post("/subscriptions", s.handleCreateSubscription)
+	r.With(s.requireUser).Delete("/subscriptions/{id}", s.handleDeleteSubscription)
+	r.With(s.requireAdmin).Delete("/admin/subscriptions/{id}", s.handleDeleteAnySubscription)
+
+	// Per-user watches and inbox
+	r.Route("/me", func(r chi.Router) {
+		r.Get("/", s.handleMe)
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireUser)
+
+			r.Get("/watches", s.handleListWatches)
+			r.Post("/watches", s.handleCreateWatch)
+			r.Delete("/watches/{id}", s.handleDeleteWatch)
+			r.Get("/inbox", s.handleListInbox)
+			r.Post("/inbox/read", s.handleMarkInboxRead)
+			r.Get("/events", s.handleMyEvents)
+		})
+	})
+
+	// Proposals awaiting approval
+	r.Get("/proposals", s.handleListProposals)
+	r.Get("/proposals/{id}", s.handleGetProposal)
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireUser)
+
+		r.With(s.requireWriteAddress).Post("/proposals/{id}/approve", s.handleApproveProposal)
+		r.Post("/proposals/{id}/reject", s.handleRejectProposal)
+		r.Post("/proposals/{id}/withdraw", s.handleWithdrawProposal)
+	})
+
+	// Tracking rules, in addition to the configured containers
+	r.Get("/rules", s.handleListRules)
+	r.Get("/rules/{id}", s.handleGetRule)
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireUser)
+
+		r.Post("/rules", s.handleCreateRule)
+		r.Put("/rules/{id}", s.handleUpdateRule)
+		r.Delete("/rules/{id}", s.handleDeleteRule)
+	})
+
+	// Application groupings
+	r.Get("/apps", s.handleListApps)
+	r.Get("/apps/{name}/activity", s.handleAppActivity)
+
+	// Owners
+	r.Get("/owners", s.handleListOwners)
+
+	// Reports
+	r.Get("/reports/teams", s.handleTeamReport)
+
+	// Sync
+	r.Get("/sync/status", s.handleSyncStatus)
+	r.Get("/sync/dry-run", s.handleDryRunReport)
+	r.With(s.requireAdmin).Delete("/sync/dry-run", s.handleClearDryRunReport)
+	r.Get("/sync/pauses", s.handleListSyncPauses)
+	r.With(s.requireAdmin).Post("/sync/pauses", s.handlePauseSync)
+	r.With(s.requireAdmin).Delete("/sync/pauses/{id}", s.handleResumeSync)
+	r.Get("/errors", s.handleListSyncErrors)
+	r.With(s.requireAdmin).Post("/admin/sync", s.handleSyncNow)
+
+	// Background jobs
+	r.With(s.requireAdmin).Get("/jobs", s.handleListJobs)
+	r.Get("/jobs/{id}", s.handleGetJob)
+	r.Get("/jobs/{id}/output", s.handleGetJobOutput)
+
+	// Admin diagnostics
+	r.With(s.requireAdmin).Get("/admin/runtime", s.handleRuntimeStats)
+
+	// Files
+	r.Get("/files", s.handleListFiles)
+	r.Get("/browse", s.handleBrowse)
+	r.Get("/deleted", s.handleListDeleted)
+	r.Get("/snapshot", s.handleSnapshot)
+	r.Get("/compare", s.handleCompare)
+	r.Get("/analysis/similar", s.handleSimilarFiles)
+	r.Get("/widgets/restores", s.handleRecentRestores)
+	r.Get("/widgets/deletions", s.handleRecentDeletions)
+	r.Get("/widgets/churn", s.handleChurnedFiles)
+	r.Post("/diffs", s.handleBatchDiffStats)
+	r.With(s.requireAdmin, s.requireUser, s.idempotent).Post("/bulk", s.handleBulk)
+	r.Get("/files/{path:.*}/versions", s.handleGetVersions)
+	r.Get("/files/{path:.*}/versions/{versionID}", s.handleGetVersion)
+	r.Get("/files/{path:.*}/versions/{versionID}/raw", s.handleDownloadVersion)
+	r.Get("/files/{path:.*}/versions/{versionID}/preview", s.handlePreview)
+	r.Get("/files/{path:.*}/versions/{versionID}/impact", s.handleGetImpact)
+	r.Get("/files/{path:.*}/at", s.handleGetFileAt)
+	r.Get("/files/{path:.*}/keys/{key}/history", s.handleGetKeyHistory)
+	r.With(s.requireUser).Put("/files/{path:.*}/versions/{versionID}/comment", s.handleSetVersionComment)
+	r.Get("/files/{path:.*}/diff/{v1}/{v2}", s.handleDiff)
+	r.Get("/files/{path:.*}/diff/{v1}/{v2}/html", s.handleDiffHTML)
+	r.With(s.requireUser).Get("/files/{path:.*}/live-url", s.handleLiveURL)
+	r.Get("/files/{path:.*}/labels", s.handleGetLabels)
+	r.With(s.requireUser).Put("/files/{path:.*}/labels", s.handleSetLabels)
+	r.With(s.requireWriteAddress, s.idempotent).Post("/files/{path:.*}/restore/{versionID}", s.handleRestore)
+	r.Get("/files/{path:.*}/restore/{versionID}/merge", s.handleRestoreMerge)
+	r.With(s.requireWriteAddress, s.requireUser, s.idempotent).Post("/files/{path:.*}/restore/{versionID}/merge", s.handleSaveRestoreMerge)
+	r.With(s.requireWriteAddress, s.requireUser, s.idempotent).Post("/files/{path:.*}/undelete", s.handleUndelete)
+	r.With(s.requireWriteAddress, s.requireUser).Post("/files/{path:.*}/archive", s.handleArchive)
+	r.With(s.requireWriteAddress, s.requireUser).Post("/files/{path:.*}/unarchive", s.handleUnarchive)
+	r.With(s.requireWriteAddress, s.requireUser).Post("/files/{path:.*}/sync", s.handleSyncFile)
+	r.With(s.requireAdmin).Post("/files/{path:.*}/checkpoint", s.handleCheckpoint)
+	r.Get("/files/{path:.*}/verify", s.handleVerifyFile)
+	r.Get("/files/{path:.*}/evidence", s.handleEvidenceBundle)
+	r.Get("/evidence/key", s.handleEvidenceKey)
+	r.With(s.requireWriteAddress, s.requireUser, s.idempotent).Put("/files/{path:.*}/content", s.handleUpdateContent)
+	r.With(s.requireWriteAddress, s.requireUser, s.idempotent).Post("/files/{path:.*}/apply-patch", s.handleApplyPatch)
+	r.Get("/files/{path:.*}", s.handleGetFile)
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
+	// End open event streams, otherwise Shutdown waits for them until ctx expires
+	s.events.Close()
+	if s.admin != nil {
+		if err := s.admin.Shutdown(ctx); err != nil {
+			log.Printf("Error during admin server shutdown: %v", err)
+		}
+	}
 	return s.Server.Shutdown(ctx)
 }
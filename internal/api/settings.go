@@ -0,0 +1,10 @@
+package api
+
+import "net/http"
+
+// handleGetSettings reports the settings switched at runtime by the settings
+// file, the version of the file they were read from, and why its latest
+// version couldn't be applied, if it couldn't
+func (s *Server) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, s.settings.State())
+}
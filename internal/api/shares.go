@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// viewTokenKey is the request context key of the view token of a shared link
+type viewTokenKey struct{}
+
+// shareRequest creates a view token to a file's history, or to the diff
+// between two of its versions
+type shareRequest struct {
+	Path          string `json:"path"`
+	FromVersionID int64  `json:"from_version_id"`
+	ToVersionID   int64  `json:"to_version_id"`
+	// ExpiresIn is how long the token is valid, such as 4h; shares.default_ttl
+	// if empty
+	ExpiresIn string `json:"expires_in"`
+}
+
+// shareResponse is a new view token. The token itself is only returned here.
+type shareResponse struct {
+	*store.ViewToken
+	Token string `json:"token"`
+	// URL is the link to share
+	URL string `json:"url"`
+}
+
+// handleCreateShare creates a view token giving read-only access to a file's
+// history, or to one diff of it if from_version_id and to_version_id are
+// set, until it expires
+func (s *Server) handleCreateShare(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" {
+		respondError(w, http.StatusBadRequest, "Path is required")
+		return
+	}
+	if (req.FromVersionID == 0) != (req.ToVersionID == 0) {
+		respondError(w, http.StatusBadRequest, "from_version_id and to_version_id must be set together")
+		return
+	}
+
+	ttl := s.cfg.Shares.DefaultTTL
+	if req.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid expires_in")
+			return
+		}
+	}
+	if ttl <= 0 {
+		respondError(w, http.StatusBadRequest, "expires_in is required")
+		return
+	}
+	if max := s.cfg.Shares.MaxTTL; max > 0 && ttl > max {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("expires_in must be at most %s", max))
+		return
+	}
+
+	file, err := s.store.GetFile(req.Path)
+	if err != nil {
+		log.Printf("Error getting file: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create share")
+		return
+	}
+	if file == nil {
+		respondError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	for _, id := range []int64{req.FromVersionID, req.ToVersionID} {
+		if id == 0 {
+			continue
+		}
+		version, err := s.store.GetVersion(id)
+		if err != nil {
+			log.Printf("Error getting version: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to create share")
+			return
+		}
+		if version == nil || version.FileID != file.ID {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Version %d is not a version of %s", id, file.BlobPath))
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating view token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create share")
+		return
+	}
+	tokenString := base64.RawURLEncoding.EncodeToString(secret)
+
+	token := &store.ViewToken{
+		TokenHash:     hashViewToken(tokenString),
+		BlobPath:      file.BlobPath,
+		FromVersionID: req.FromVersionID,
+		ToVersionID:   req.ToVersionID,
+		CreatedBy:     s.currentUser(r),
+		ExpiresAt:     time.Now().Add(ttl).UTC(),
+	}
+	if err := s.store.CreateViewToken(token); err != nil {
+		log.Printf("Error creating view token: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create share")
+		return
+	}
+
+	log.Printf("View token %d to %s shared by %s until %s", token.ID, token.BlobPath, token.CreatedBy, token.ExpiresAt.Format(time.RFC3339))
+	respondJSON(w, http.StatusCreated, shareResponse{ViewToken: token, Token: tokenString, URL: s.shareURL(tokenString)})
+}
+
+// handleListShares returns the caller's view tokens, including expired and
+// revoked ones
+func (s *Server) handleListShares(w http.ResponseWriter, r *http.Request) {
+	s.listShares(w, s.currentUser(r))
+}
+
+// handleListAllShares returns the view tokens of every user
+func (s *Server) handleListAllShares(w http.ResponseWriter, r *http.Request) {
+	s.listShares(w, "")
+}
+
+// listShares responds with the view tokens made by createdBy, or by anyone
+func (s *Server) listShares(w http.ResponseWriter, createdBy string) {
+	tokens, err := s.store.ListViewTokens(createdBy)
+	if err != nil {
+		log.Printf("Error listing view tokens: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list shares")
+		return
+	}
+	if tokens == nil {
+		tokens = []store.ViewToken{}
+	}
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// handleRevokeShare revokes one of the caller's view tokens
+func (s *Server) handleRevokeShare(w http.ResponseWriter, r *http.Request) {
+	s.revokeShare(w, r, s.currentUser(r))
+}
+
+// handleRevokeAnyShare revokes any user's view token, such as one posted
+// somewhere it shouldn't have been
+func (s *Server) handleRevokeAnyShare(w http.ResponseWriter, r *http.Request) {
+	s.revokeShare(w, r, "")
+}
+
+// revokeShare revokes the view token named by the id URL parameter, if it was
+// made by createdBy, or by anyone
+func (s *Server) revokeShare(w http.ResponseWriter, r *http.Request, createdBy string) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid share ID")
+		return
+	}
+
+	found, err := s.store.RevokeViewToken(id, createdBy)
+	if err != nil {
+		log.Printf("Error revoking view token %d: %v", id, err)
+		respondError(w, http.StatusInternalServerError, "Failed to revoke share")
+		return
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, "Share not found")
+		return
+	}
+
+	log.Printf("View token %d revoked by %s", id, s.currentUser(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireViewToken serves the routes under /share/{token} only for a valid
+// view token, passing its file on in the path URL parameter like the API
+// routes get it
+func (s *Server) requireViewToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := s.store.GetViewToken(hashViewToken(chi.URLParam(r, "token")))
+		if err != nil {
+			log.Printf("Error getting view token: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to check share")
+			return
+		}
+		// Unknown, expired and revoked tokens look the same to the caller
+		if token == nil || !token.Valid(time.Now()) {
+			respondError(w, http.StatusNotFound, "Share not found or expired")
+			return
+		}
+
+		chi.RouteContext(r.Context()).URLParams.Add("path", url.PathEscape(token.BlobPath))
+		ctx := context.WithValue(r.Context(), viewTokenKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireSharedVersions serves a shared route only for the versions the view
+// token covers: those of its file, or the two versions of its diff
+func (s *Server) requireSharedVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Context().Value(viewTokenKey{}).(*store.ViewToken)
+
+		if token.FromVersionID != 0 {
+			if chi.URLParam(r, "v1") != strconv.FormatInt(token.FromVersionID, 10) ||
+				chi.URLParam(r, "v2") != strconv.FormatInt(token.ToVersionID, 10) {
+				respondError(w, http.StatusNotFound, "The share only covers the diff between versions "+
+					strconv.FormatInt(token.FromVersionID, 10)+" and "+strconv.FormatInt(token.ToVersionID, 10))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		file, err := s.store.GetFile(token.BlobPath)
+		if err != nil {
+			log.Printf("Error getting file: %v", err)
+			respondError(w, http.StatusInternalServerError, "Failed to get version")
+			return
+		}
+		for _, name := range []string{"versionID", "v1", "v2"} {
+			param := chi.URLParam(r, name)
+			if param == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "Invalid version ID")
+				return
+			}
+			version, err := s.store.GetVersion(id)
+			if err != nil {
+				log.Printf("Error getting version: %v", err)
+				respondError(w, http.StatusInternalServerError, "Failed to get version")
+				return
+			}
+			if file == nil || version == nil || version.FileID != file.ID {
+				respondError(w, http.StatusNotFound, "Version not found")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleShare serves the link of a view token: the HTML diff of a shared
+// diff, or the versions of a shared file
+func (s *Server) handleShare(w http.ResponseWriter, r *http.Request) {
+	token := r.Context().Value(viewTokenKey{}).(*store.ViewToken)
+	if token.FromVersionID == 0 {
+		s.handleGetVersions(w, r)
+		return
+	}
+
+	params := &chi.RouteContext(r.Context()).URLParams
+	params.Add("v1", strconv.FormatInt(token.FromVersionID, 10))
+	params.Add("v2", strconv.FormatInt(token.ToVersionID, 10))
+	s.handleDiffHTML(w, r)
+}
+
+// shareURL returns the link of a view token, absolute if email.base_url
+// tells where the vault is reachable
+func (s *Server) shareURL(token string) string {
+	if s.cfg.Email.BaseURL != "" {
+		return strings.TrimRight(s.cfg.Email.BaseURL, "/") + "/share/" + token
+	}
+	return s.cfg.Server.BasePath + "/share/" + token
+}
+
+// hashViewToken returns the hash a view token is stored by, so that the
+// tokens can't be read back from the database
+func hashViewToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
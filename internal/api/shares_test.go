@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// createTestVersions records a file with versions of the given contents and
+// returns their IDs
+func createTestVersions(t *testing.T, st *store.SQLiteStore, blobPath string, contents ...string) []int64 {
+	t.Helper()
+	file := &store.File{BlobPath: blobPath, ETag: "etag", ContentHash: "hash"}
+	if err := st.UpsertFile(file); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for i, content := range contents {
+		v := &store.Version{
+			FileID:      file.ID,
+			Content:     content,
+			ContentHash: fmt.Sprintf("hash-%d", i),
+			ChangeType:  store.ChangeTypeModified,
+			CapturedAt:  time.Now().Add(time.Duration(i) * time.Minute),
+			BlobETag:    fmt.Sprintf("etag-%d", i),
+		}
+		if err := st.CreateVersion(v); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, v.ID)
+	}
+	return ids
+}
+
+func TestShareTokenScope(t *testing.T) {
+	st := newTestStore(t)
+	shared := createTestVersions(t, st, "account/container/shared.yaml", "a: 1\n", "a: 2\n", "a: 3\n")
+	other := createTestVersions(t, st, "account/container/other.yaml", "b: 1\n")
+
+	tokens := []store.ViewToken{
+		{TokenHash: hashViewToken("file-token"), BlobPath: "account/container/shared.yaml", ExpiresAt: time.Now().Add(time.Hour)},
+		{TokenHash: hashViewToken("diff-token"), BlobPath: "account/container/shared.yaml", FromVersionID: shared[0], ToVersionID: shared[1], ExpiresAt: time.Now().Add(time.Hour)},
+		{TokenHash: hashViewToken("expired-token"), BlobPath: "account/container/shared.yaml", ExpiresAt: time.Now().Add(-time.Minute)},
+		{TokenHash: hashViewToken("revoked-token"), BlobPath: "account/container/shared.yaml", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for i := range tokens {
+		if err := st.CreateViewToken(&tokens[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.RevokeViewToken(tokens[3].ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{store: st}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The shared file is passed on like the API routes get it
+		if path := getPathParam(r, "path"); path != "account/container/shared.yaml" {
+			t.Errorf("path = %q", path)
+		}
+	})
+	router := chi.NewRouter()
+	router.Route("/share/{token}", func(r chi.Router) {
+		r.Use(s.requireViewToken)
+		r.With(s.requireSharedVersions).Get("/versions/{versionID}", ok)
+		r.With(s.requireSharedVersions).Get("/diff/{v1}/{v2}", ok)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"version of the shared file", fmt.Sprintf("/share/file-token/versions/%d", shared[2]), http.StatusOK},
+		{"diff of the shared file", fmt.Sprintf("/share/file-token/diff/%d/%d", shared[0], shared[2]), http.StatusOK},
+		{"version of another file", fmt.Sprintf("/share/file-token/versions/%d", other[0]), http.StatusNotFound},
+		{"diff with a version of another file", fmt.Sprintf("/share/file-token/diff/%d/%d", shared[0], other[0]), http.StatusNotFound},
+		{"missing version", "/share/file-token/versions/999999", http.StatusNotFound},
+		{"shared diff", fmt.Sprintf("/share/diff-token/diff/%d/%d", shared[0], shared[1]), http.StatusOK},
+		{"other diff of the shared file", fmt.Sprintf("/share/diff-token/diff/%d/%d", shared[0], shared[2]), http.StatusNotFound},
+		{"version covered by a diff share", fmt.Sprintf("/share/diff-token/versions/%d", shared[0]), http.StatusNotFound},
+		{"expired token", fmt.Sprintf("/share/expired-token/versions/%d", shared[0]), http.StatusNotFound},
+		{"revoked token", fmt.Sprintf("/share/revoked-token/versions/%d", shared[0]), http.StatusNotFound},
+		{"unknown token", fmt.Sprintf("/share/unknown-token/versions/%d", shared[0]), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/diff"
+)
+
+// defaultSimilarity is the similarity above which files are reported as
+// copies of each other, unless the request sets threshold
+const defaultSimilarity = 0.8
+
+// similarFile is a file in a group of similar files
+type similarFile struct {
+	Path      string `json:"path"`
+	VersionID int64  `json:"version_id"`
+	Size      int64  `json:"size"`
+}
+
+// similarGroup is a set of files connected by similar pairs
+type similarGroup struct {
+	Files []similarFile      `json:"files"`
+	Pairs []diff.SimilarPair `json:"pairs"`
+}
+
+// similarityReport lists groups of files with similar current content
+type similarityReport struct {
+	Prefix    string  `json:"prefix,omitempty"`
+	Threshold float64 `json:"threshold"`
+	// Compared is the number of files compared; encrypted, truncated and
+	// not yet downloaded versions are skipped
+	Compared int            `json:"compared"`
+	Groups   []similarGroup `json:"groups"`
+}
+
+// handleSimilarFiles finds files with highly similar current content, such
+// as copies of the same flag file that have drifted apart
+func (s *Server) handleSimilarFiles(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	threshold := defaultSimilarity
+	if v := q.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < diff.MinSimilarity || t > 1 {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("threshold must be between %g and 1", diff.MinSimilarity))
+			return
+		}
+		threshold = t
+	}
+
+	prefix := q.Get("prefix")
+	snap, err := s.snapshotAt(prefix, time.Now())
+	if err != nil {
+		log.Printf("Error getting current snapshot: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to get current files")
+		return
+	}
+
+	files := make(map[string]similarFile, len(snap.Files))
+	var docs []diff.Document
+	for _, f := range snap.Files {
+		v := f.Version
+		if v.Encrypted || v.Truncated || v.ContentPending || v.Content == "" {
+			continue
+		}
+		files[f.Path] = similarFile{Path: f.Path, VersionID: v.ID, Size: v.Size}
+		docs = append(docs, diff.Document{ID: f.Path, Content: v.Content})
+	}
+
+	respondJSON(w, http.StatusOK, similarityReport{
+		Prefix:    prefix,
+		Threshold: threshold,
+		Compared:  len(docs),
+		Groups:    groupSimilar(diff.FindSimilar(docs, threshold), files),
+	})
+}
+
+// groupSimilar joins similar pairs that share a file into groups, largest
+// group first
+func groupSimilar(pairs []diff.SimilarPair, files map[string]similarFile) []similarGroup {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(p string) string {
+		if parent[p] == "" || parent[p] == p {
+			return p
+		}
+		parent[p] = find(parent[p])
+		return parent[p]
+	}
+	for _, p := range pairs {
+		if a, b := find(p.A), find(p.B); a != b {
+			parent[b] = a
+		}
+	}
+
+	byRoot := make(map[string]*similarGroup)
+	var groups []*similarGroup
+	for _, p := range pairs {
+		root := find(p.A)
+		g, ok := byRoot[root]
+		if !ok {
+			g = &similarGroup{}
+			byRoot[root] = g
+			groups = append(groups, g)
+		}
+		g.Pairs = append(g.Pairs, p)
+	}
+
+	result := make([]similarGroup, 0, len(groups))
+	for _, g := range groups {
+		seen := make(map[string]bool)
+		for _, p := range g.Pairs {
+			for _, path := range []string{p.A, p.B} {
+				if !seen[path] {
+					seen[path] = true
+					g.Files = append(g.Files, files[path])
+				}
+			}
+		}
+		sort.Slice(g.Files, func(i, j int) bool { return g.Files[i].Path < g.Files[j].Path })
+		result = append(result, *g)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return len(result[i].Files) > len(result[j].Files) })
+	return result
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/store"
+)
+
+// subscriptionRequest is the body of a create subscription request
+type subscriptionRequest struct {
+	Email      string             `json:"email"`
+	PathPrefix string             `json:"path_prefix"`
+	Mode       store.DeliveryMode `json:"mode"`
+}
+
+// handleListSubscriptions returns all e-mail subscriptions
+func (s *Server) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subs, err := s.store.ListSubscriptions()
+	if err != nil {
+		log.Printf("Error listing subscriptions: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list subscriptions")
+		return
+	}
+
+	if subs == nil {
+		subs = []store.Subscription{}
+	}
+
+	respondJSON(w, http.StatusOK, subs)
+}
+
+// handleCreateSubscription subscribes an e-mail address to a path prefix on
+// behalf of the caller, who is recorded on the subscription
+func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = store.DeliveryImmediate
+	}
+	switch req.Mode {
+	case store.DeliveryImmediate, store.DeliveryHourly, store.DeliveryDaily:
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid mode (expected immediate, hourly or daily)")
+		return
+	}
+
+	sub := &store.Subscription{
+		Email:      addr.Address,
+		PathPrefix: req.PathPrefix,
+		Mode:       req.Mode,
+		CreatedBy:  s.currentUser(r),
+	}
+	if err := s.store.CreateSubscription(sub); err != nil {
+		log.Printf("Error creating subscription: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create subscription")
+		return
+	}
+
+	log.Printf("Subscription %d for %s added by %s", sub.ID, sub.Email, sub.CreatedBy)
+	respondJSON(w, http.StatusCreated, sub)
+}
+
+// handleDeleteSubscription removes one of the caller's subscriptions
+func (s *Server) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	s.deleteSubscription(w, r, s.currentUser(r))
+}
+
+// handleDeleteAnySubscription removes any user's subscription, such as one
+// made before subscriptions recorded who made them
+func (s *Server) handleDeleteAnySubscription(w http.ResponseWriter, r *http.Request) {
+	s.deleteSubscription(w, r, "")
+}
+
+// deleteSubscription removes the subscription named by the id URL parameter,
+// if it was made by createdBy, or by anyone
+func (s *Server) deleteSubscription(w http.ResponseWriter, r *http.Request, createdBy string) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid subscription ID")
+		return
+	}
+
+	found, err := s.store.DeleteSubscription(id, createdBy)
+	if err != nil {
+		log.Printf("Error deleting subscription: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete subscription")
+		return
+	}
+	if !found {
+		respondError(w, http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	log.Printf("Subscription %d removed by %s", id, s.currentUser(r))
+	w.WriteHeader(http.StatusNoContent)
+}
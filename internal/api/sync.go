@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
+)
+
+// dryRunReport is the response of the dry-run report endpoint
+type dryRunReport struct {
+	DryRun  bool                     `json:"dry_run"`
+	Summary map[store.ChangeType]int `json:"summary"`
+	Changes []store.ShadowChange     `json:"changes"`
+}
+
+// handleSyncStatus reports sync progress: the phase (backfill or sync),
+// processed/total blobs and an ETA for the running cycle
+func (s *Server) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if s.syncer == nil {
+		respondJSON(w, http.StatusOK, syncer.Status{})
+		return
+	}
+	respondJSON(w, http.StatusOK, s.syncer.Status())
+}
+
+// fetchPendingContent fetches the content of a file that was backfilled
+// metadata-only, so that it has a version to show on first view
+func (s *Server) fetchPendingContent(ctx context.Context, path string) {
+	if s.syncer == nil {
+		return
+	}
+	if _, err := s.syncer.FetchContent(ctx, path); err != nil {
+		log.Printf("Error fetching content of %s: %v", path, err)
+	}
+}
+
+// loadVersionContent makes sure a version recorded by hash only has its
+// content, downloading it on first use. On failure it writes the error
+// response and returns false.
+func (s *Server) loadVersionContent(w http.ResponseWriter, r *http.Request, version *store.Version) bool {
+	if !version.ContentPending {
+		return true
+	}
+	if s.syncer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Version content has not been fetched")
+		return false
+	}
+
+	err := s.syncer.LoadVersionContent(r.Context(), version)
+	if errors.Is(err, syncer.ErrContentUnavailable) {
+		respondError(w, http.StatusGone, err.Error())
+		return false
+	}
+	if err != nil {
+		log.Printf("Error loading content of version %d: %v", version.ID, err)
+		respondError(w, http.StatusBadGateway, "Failed to fetch version content from blob storage")
+		return false
+	}
+	return true
+}
+
+// handleDryRunReport lists the changes a dry-run sync would have recorded
+func (s *Server) handleDryRunReport(w http.ResponseWriter, r *http.Request) {
+	changes, err := s.store.ListShadowChanges()
+	if err != nil {
+		log.Printf("Error listing dry-run changes: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to load dry-run report")
+		return
+	}
+
+	report := dryRunReport{
+		DryRun: s.syncer != nil && s.syncer.DryRun(),
+		Summary: map[store.ChangeType]int{
+			store.ChangeTypeCreated:   0,
+			store.ChangeTypeModified:  0,
+			store.ChangeTypeDeleted:   0,
+			store.ChangeTypeRecreated: 0,
+		},
+		Changes: changes,
+	}
+	for _, change := range changes {
+		report.Summary[change.ChangeType]++
+	}
+	if report.Changes == nil {
+		report.Changes = []store.ShadowChange{}
+	}
+
+	respondJSON(w, http.StatusOK, report)
+}
+
+// handleListSyncErrors lists the blobs that failed to sync, with their
+// latest error and how many cycles they failed in
+func (s *Server) handleListSyncErrors(w http.ResponseWriter, r *http.Request) {
+	syncErrors, err := s.store.ListSyncErrors()
+	if err != nil {
+		log.Printf("Error listing sync errors: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to load sync errors")
+		return
+	}
+	if syncErrors == nil {
+		syncErrors = []store.SyncError{}
+	}
+	respondJSON(w, http.StatusOK, syncErrors)
+}
+
+// handleClearDryRunReport empties the dry-run report
+func (s *Server) handleClearDryRunReport(w http.ResponseWriter, r *http.Request) {
+	err := s.store.ClearShadowChanges()
+	if err != nil {
+		log.Printf("Error clearing dry-run changes: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to clear dry-run report")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSyncFile fetches the blob of one file straight away and records a
+// version if it changed, rather than waiting for the next sync cycle
+func (s *Server) handleSyncFile(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+	if s.syncer == nil {
+		respondError(w, http.StatusServiceUnavailable, "Syncing is not available")
+		return
+	}
+
+	version, err := s.syncer.SyncFile(r.Context(), file)
+	var fetchErr *syncer.FetchError
+	var rejected *hooks.RejectedError
+	switch {
+	case errors.Is(err, syncer.ErrFileArchived):
+		respondError(w, http.StatusConflict, "File is archived; unarchive it to sync it")
+		return
+	case errors.Is(err, syncer.ErrSyncPaused):
+		respondError(w, http.StatusConflict, "Syncing of the file's storage account or container is paused")
+		return
+	case errors.As(err, &rejected):
+		respondError(w, http.StatusUnprocessableEntity, rejected.Error())
+		return
+	case errors.As(err, &fetchErr):
+		log.Printf("Error syncing %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusBadGateway, "Failed to fetch the file's blob")
+		return
+	case err != nil:
+		log.Printf("Error syncing %s: %v", file.BlobPath, err)
+		respondError(w, http.StatusInternalServerError, "Failed to sync file")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"changed": version != nil,
+		"version": version,
+	})
+}
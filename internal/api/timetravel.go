@@ -0,0 +1,128 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// snapshotFile is a file's version at a point in time
+type snapshotFile struct {
+	Path    string        `json:"path"`
+	Version store.Version `json:"version"`
+}
+
+// snapshot is the state of the files under a prefix at a point in time
+type snapshot struct {
+	Prefix string         `json:"prefix"`
+	Time   time.Time      `json:"time"`
+	Files  []snapshotFile `json:"files"`
+}
+
+// handleGetFileAt returns the version of a file that was current at the
+// time given by the time parameter. If the file had been deleted by then,
+// the deletion is returned.
+func (s *Server) handleGetFileAt(w http.ResponseWriter, r *http.Request) {
+	at, ok := parseAtParam(w, r, "time")
+	if !ok {
+		return
+	}
+
+	file, ok := s.loadFile(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := s.store.GetVersionAt(file.ID, at)
+	if err != nil {
+		log.Printf("Error getting version of %s at %s: %v", file.BlobPath, at, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get version")
+		return
+	}
+	if version == nil {
+		respondError(w, http.StatusNotFound, "File was not tracked yet at that time")
+		return
+	}
+
+	if !s.loadVersionContent(w, r, version) {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, version)
+}
+
+// handleSnapshot returns the versions that were current at the time given
+// by the time parameter for every file under prefix, to reconstruct the
+// configuration at that instant. Files deleted by then are left out, and
+// content is only included with content=true.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	at, ok := parseAtParam(w, r, "time")
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	includeContent, _ := strconv.ParseBool(q.Get("content"))
+
+	snap, err := s.snapshotAt(q.Get("prefix"), at)
+	if err != nil {
+		log.Printf("Error getting snapshot at %s: %v", at, err)
+		respondError(w, http.StatusInternalServerError, "Failed to get snapshot")
+		return
+	}
+
+	if !includeContent {
+		for i := range snap.Files {
+			snap.Files[i].Version.Content = ""
+		}
+	}
+
+	respondJSON(w, http.StatusOK, snap)
+}
+
+// snapshotAt returns the files under prefix that existed at a time, with the
+// version each had then
+func (s *Server) snapshotAt(prefix string, at time.Time) (*snapshot, error) {
+	versions, err := s.store.GetVersionsAt(prefix, at)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.store.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make(map[int64]string, len(files))
+	for _, f := range files {
+		paths[f.ID] = f.BlobPath
+	}
+
+	snap := &snapshot{Prefix: prefix, Time: at, Files: []snapshotFile{}}
+	for _, v := range versions {
+		if v.ChangeType == store.ChangeTypeDeleted {
+			continue
+		}
+		snap.Files = append(snap.Files, snapshotFile{Path: paths[v.FileID], Version: v})
+	}
+	return snap, nil
+}
+
+// parseAtParam parses a required time parameter, responding with an error
+// and returning false if it is missing or invalid
+func parseAtParam(w http.ResponseWriter, r *http.Request, name string) (time.Time, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		respondError(w, http.StatusBadRequest, name+" is required")
+		return time.Time{}, false
+	}
+
+	at, err := parseTimeParam(value, false)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid "+name+": "+err.Error())
+		return time.Time{}, false
+	}
+	return at, true
+}
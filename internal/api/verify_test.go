@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/integrity"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
+)
+
+// upperHook is a pre-store hook that transforms content to upper case
+type upperHook struct{}
+
+func (upperHook) Name() string { return "upper" }
+
+func (upperHook) PreStore(ctx context.Context, p *hooks.Payload) error {
+	p.Version.Content = strings.ToUpper(p.Version.Content)
+	return nil
+}
+
+func (upperHook) PostStore(ctx context.Context, p *hooks.Payload) error { return nil }
+
+func newTestStore(t *testing.T) *store.SQLiteStore {
+	t.Helper()
+	st, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestVerifyVersion(t *testing.T) {
+	signer := integrity.NewSigner([]byte("0123456789abcdef0123456789abcdef"), "k1")
+
+	tests := []struct {
+		name          string
+		hooks         *hooks.Registry
+		signer        *integrity.Signer
+		tamper        func(v *store.Version)
+		wantContent   string
+		wantSignature string
+	}{
+		{
+			name:          "plain version",
+			wantContent:   checkOK,
+			wantSignature: checkDisabled,
+		},
+		{
+			name:          "transformed by a hook",
+			hooks:         hooks.NewRegistry(upperHook{}),
+			signer:        signer,
+			wantContent:   checkOK,
+			wantSignature: checkOK,
+		},
+		{
+			name:          "content changed",
+			signer:        signer,
+			tamper:        func(v *store.Version) { v.Content = "key: other\n" },
+			wantContent:   checkMismatch,
+			wantSignature: checkOK,
+		},
+		{
+			name:          "hash changed with content",
+			signer:        signer,
+			tamper:        func(v *store.Version) { v.Content, v.ContentHash = "key: other\n", "" },
+			wantContent:   checkMismatch,
+			wantSignature: checkInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			if tt.signer != nil {
+				st.SetSigner(tt.signer)
+			}
+			recorder := syncer.NewRecorder(st, tt.hooks)
+			version, err := recorder.RecordCapture(context.Background(), nil, syncer.Capture{
+				BlobPath: "account/container/app.yaml",
+				Content:  []byte("key: value\n"),
+				ETag:     "etag-1",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := st.GetVersion(version.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(stored)
+			}
+
+			s := &Server{signer: tt.signer}
+			check := s.verifyVersion(stored)
+			if check.Content != tt.wantContent {
+				t.Errorf("content = %s, want %s", check.Content, tt.wantContent)
+			}
+			if check.Signature != tt.wantSignature {
+				t.Errorf("signature = %s, want %s", check.Signature, tt.wantSignature)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/version"
+)
+
+// buildInfo is the response of the version endpoint, telling support what
+// an instance is running
+type buildInfo struct {
+	version.Info
+	// Features are the optional features enabled on the instance
+	Features map[string]bool `json:"features"`
+	Config   configSummary   `json:"config"`
+}
+
+// configSummary is the configuration without secrets, credentials, storage
+// account names or paths
+type configSummary struct {
+	Cloud           string        `json:"cloud,omitempty"`
+	StorageAccounts int           `json:"storage_accounts"`
+	Discovery       int           `json:"discovery_rules"`
+	AuthMethods     []string      `json:"auth_methods"`
+	SyncInterval    string        `json:"sync_interval"`
+	Patterns        int           `json:"patterns"`
+	PatternGroups   int           `json:"pattern_groups"`
+	MaxContentSize  int64         `json:"max_content_size,omitempty"`
+	Hooks           int           `json:"hooks"`
+	Notifiers       int           `json:"notifiers"`
+	Applications    int           `json:"applications"`
+	BasePath        string        `json:"base_path,omitempty"`
+	APISunset       *time.Time    `json:"api_sunset,omitempty"`
+	JobWorkers      int           `json:"job_workers"`
+	Diff            diffLimitInfo `json:"diff"`
+}
+
+// diffLimitInfo is the server.diff settings
+type diffLimitInfo struct {
+	MaxSize        int64 `json:"max_size"`
+	BackgroundSize int64 `json:"background_size"`
+}
+
+// handleVersion reports the build version, commit and Go version, the
+// enabled features and a summary of the configuration
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	cfg := s.cfg
+	runtimeSettings := s.settings.Get()
+	info := buildInfo{
+		Info: version.Get(),
+		Features: map[string]bool{
+			"admin_api":            s.adminToken != "",
+			"admin_address":        s.admin != nil,
+			"approvals":            cfg.Approvals.Required,
+			"github_reviews":       cfg.Approvals.GitHub.Repo != "",
+			"append_only":          cfg.Database.AppendOnly,
+			"database_replica":     cfg.Database.Replica != "",
+			"dry_run":              cfg.Sync.DryRun,
+			"email":                cfg.Email.Enabled(),
+			"encryption":           s.decrypter != nil,
+			"impact":               cfg.Impact.Enabled(),
+			"integrity":            s.signer != nil,
+			"key_changes":          runtimeSettings.KeyChanges,
+			"jobs":                 s.jobs != nil,
+			"job_callbacks":        s.jobs != nil && len(cfg.Jobs.CallbackHosts) > 0,
+			"maintenance":          s.maintenance.get().Enabled,
+			"network_restrictions": len(cfg.Server.AllowedCIDRs) > 0,
+			"notifications":        runtimeSettings.Notifications,
+			"pprof":                cfg.Server.Pprof,
+			"settings_file":        s.settings != nil,
+			"snapshots":            cfg.Sync.Snapshots,
+		},
+		Config: summarizeConfig(cfg),
+	}
+	respondJSON(w, http.StatusOK, info)
+}
+
+// summarizeConfig returns the configuration without anything secret
+func summarizeConfig(cfg *config.Config) configSummary {
+	accounts := cfg.Azure.GetStorageAccounts()
+	methods := make([]string, 0, len(accounts))
+	seen := make(map[string]bool)
+	for _, account := range accounts {
+		auth := cfg.Azure.AuthFor(account)
+		method := auth.GetAuthMethod()
+		if !seen[method] {
+			seen[method] = true
+			methods = append(methods, method)
+		}
+	}
+
+	summary := configSummary{
+		Cloud:           cfg.Azure.Cloud,
+		StorageAccounts: len(accounts),
+		Discovery:       len(cfg.Azure.Discovery),
+		AuthMethods:     methods,
+		SyncInterval:    cfg.Sync.Interval.String(),
+		Patterns:        len(cfg.Sync.Patterns),
+		PatternGroups:   len(cfg.Sync.PatternGroups),
+		MaxContentSize:  cfg.Sync.MaxContentSize,
+		Hooks:           len(cfg.Hooks),
+		Notifiers:       len(cfg.Notifiers),
+		Applications:    len(cfg.Applications),
+		BasePath:        cfg.Server.BasePath,
+		JobWorkers:      cfg.Jobs.Workers,
+		Diff: diffLimitInfo{
+			MaxSize:        cfg.Server.Diff.MaxSize,
+			BackgroundSize: cfg.Server.Diff.BackgroundSize,
+		},
+	}
+	if !cfg.Server.APISunset.IsZero() {
+		summary.APISunset = &cfg.Server.APISunset
+	}
+	return summary
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is where the current version of the API is served. The same
+// routes are still served under the unversioned /api, marked deprecated.
+const apiPrefix = "/api/v1"
+
+// unversionedDeprecated is when the unversioned /api paths were deprecated
+// in favour of /api/v1
+var unversionedDeprecated = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// deprecation describes a deprecated endpoint to its callers
+type deprecation struct {
+	// Since is when the endpoint was deprecated
+	Since time.Time
+	// Sunset is when the endpoint will be removed, if decided
+	Sunset time.Time
+	// Successor returns the path that replaces the requested one, if any
+	Successor func(r *http.Request) string
+}
+
+// deprecate marks the responses of deprecated endpoints with a Deprecation
+// header (RFC 9745), a Sunset header (RFC 8594) once a removal date is set,
+// and a Link to the successor, so callers can find and migrate their uses
+// before anything breaks. Wrap a route with it to deprecate it:
+//
+//	r.With(deprecate(deprecation{Since: ...})).Get("/old", s.handleOld)
+func deprecate(d deprecation) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+			if !d.Sunset.IsZero() {
+				w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Successor != nil {
+				if successor := d.Successor(r); successor != "" {
+					w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// unversionedAPI is the deprecation of the unversioned /api paths, whose
+// successors are the same paths under /api/v1
+func (s *Server) unversionedAPI() deprecation {
+	return deprecation{
+		Since:  unversionedDeprecated,
+		Sunset: s.cfg.Server.APISunset,
+		Successor: func(r *http.Request) string {
+			successor := s.cfg.Server.BasePath + apiPrefix + strings.TrimPrefix(r.URL.Path, "/api")
+			if r.URL.RawQuery != "" {
+				successor += "?" + r.URL.RawQuery
+			}
+			return successor
+		},
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// versionResource is a version with the versions of its file captured just
+// before and after it, so that clients can walk the history one version at a
+// time
+type versionResource struct {
+	*store.Version
+	// PrevVersionID and NextVersionID are left out for the first and the
+	// latest version
+	PrevVersionID int64        `json:"prev_version_id,omitempty"`
+	NextVersionID int64        `json:"next_version_id,omitempty"`
+	Links         versionLinks `json:"links"`
+}
+
+// versionLinks are the URLs of a version and of what it can be navigated to
+type versionLinks struct {
+	Self     string `json:"self"`
+	Raw      string `json:"raw"`
+	Preview  string `json:"preview"`
+	File     string `json:"file"`
+	Versions string `json:"versions"`
+	Prev     string `json:"prev,omitempty"`
+	Next     string `json:"next,omitempty"`
+	// DiffPrevious is the diff from the previous version to this one
+	DiffPrevious string `json:"diff_previous,omitempty"`
+	// DiffNext is the diff from this version to the next one
+	DiffNext string `json:"diff_next,omitempty"`
+}
+
+// newVersionResource links version, of the file at path, to the versions
+// before and after it, and fills in its tags
+func (s *Server) newVersionResource(path string, version *store.Version) (*versionResource, error) {
+	previous, next, err := s.store.GetAdjacentVersionIDs(version.ID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.store.GetVersionTags(version.FileID)
+	if err != nil {
+		return nil, err
+	}
+	version.Tags = tags[version.ID]
+
+	fileURL := fmt.Sprintf("%s%s/files/%s", s.cfg.Server.BasePath, apiPrefix, url.PathEscape(path))
+	versionURL := func(id int64) string {
+		return fmt.Sprintf("%s/versions/%d", fileURL, id)
+	}
+	resource := &versionResource{
+		Version:       version,
+		PrevVersionID: previous,
+		NextVersionID: next,
+		Links: versionLinks{
+			Self:     versionURL(version.ID),
+			Raw:      versionURL(version.ID) + "/raw",
+			Preview:  versionURL(version.ID) + "/preview",
+			File:     fileURL,
+			Versions: fileURL + "/versions",
+		},
+	}
+	if previous != 0 {
+		resource.Links.Prev = versionURL(previous)
+		resource.Links.DiffPrevious = fmt.Sprintf("%s/diff/%d/%d", fileURL, previous, version.ID)
+	}
+	if next != 0 {
+		resource.Links.Next = versionURL(next)
+		resource.Links.DiffNext = fmt.Sprintf("%s/diff/%d/%d", fileURL, version.ID, next)
+	}
+	return resource, nil
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	defaultInboxLimit = 100
+	maxInboxLimit     = 1000
+)
+
+type userContextKey struct{}
+
+// watchRequest is the body of a create watch request
+type watchRequest struct {
+	Path  string `json:"path"`
+	Exact bool   `json:"exact"`
+}
+
+// markReadRequest is the body of a mark inbox read request. Omitting IDs
+// marks the whole inbox as read.
+type markReadRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// currentUser returns the identity of the caller, as worked out by identify
+// from an API key or a trusted proxy's user header, or an empty string if
+// the request is anonymous
+func (s *Server) currentUser(r *http.Request) string {
+	if user, ok := r.Context().Value(userContextKey{}).(string); ok {
+		return user
+	}
+	if s.trustUserHeader {
+		return strings.TrimSpace(r.Header.Get(s.userHeader))
+	}
+	return ""
+}
+
+// requireUser rejects anonymous requests and stores the caller's identity in
+// the request context
+func (s *Server) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := s.currentUser(r)
+		if user == "" {
+			respondError(w, http.StatusUnauthorized, "Missing user identity (an "+apiKeyHeader+" header, or "+s.userHeader+" from a trusted proxy)")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// handleMe returns the caller's identity, which is empty for anonymous requests
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{
+		"user":        s.currentUser(r),
+		"user_header": s.userHeader,
+	})
+}
+
+// handleListWatches returns the caller's watches
+func (s *Server) handleListWatches(w http.ResponseWriter, r *http.Request) {
+	watches, err := s.store.ListWatches(s.currentUser(r))
+	if err != nil {
+		log.Printf("Error listing watches: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list watches")
+		return
+	}
+
+	if watches == nil {
+		watches = []store.Watch{}
+	}
+
+	respondJSON(w, http.StatusOK, watches)
+}
+
+// handleCreateWatch watches a file (exact) or every file under a prefix
+func (s *Server) handleCreateWatch(w http.ResponseWriter, r *http.Request) {
+	var req watchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Path == "" && req.Exact {
+		respondError(w, http.StatusBadRequest, "Path is required for exact watches")
+		return
+	}
+
+	watch := &store.Watch{
+		UserID: s.currentUser(r),
+		Path:   req.Path,
+		Exact:  req.Exact,
+	}
+	if err := s.store.CreateWatch(watch); err != nil {
+		log.Printf("Error creating watch: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to create watch")
+		return
+	}
+	s.watchGeneration.Add(1)
+
+	respondJSON(w, http.StatusCreated, watch)
+}
+
+// handleDeleteWatch removes one of the caller's watches
+func (s *Server) handleDeleteWatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid watch ID")
+		return
+	}
+
+	err = s.store.DeleteWatch(s.currentUser(r), id)
+	if err != nil {
+		log.Printf("Error deleting watch: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to delete watch")
+		return
+	}
+	s.watchGeneration.Add(1)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListInbox returns changes delivered to the caller by their watches.
+// Query parameters: unread=true to skip read items, limit (default 100, max 1000).
+func (s *Server) handleListInbox(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	unreadOnly := q.Get("unread") == "true"
+
+	limit := defaultInboxLimit
+	if limitStr := q.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		if limit > maxInboxLimit {
+			limit = maxInboxLimit
+		}
+	}
+
+	items, err := s.store.ListInbox(s.currentUser(r), unreadOnly, limit)
+	if err != nil {
+		log.Printf("Error listing inbox: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list inbox")
+		return
+	}
+
+	if items == nil {
+		items = []store.InboxItem{}
+	}
+
+	respondJSON(w, http.StatusOK, items)
+}
+
+// handleMarkInboxRead marks inbox items as read
+func (s *Server) handleMarkInboxRead(w http.ResponseWriter, r *http.Request) {
+	var req markReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	if err := s.store.MarkInboxRead(s.currentUser(r), req.IDs); err != nil {
+		log.Printf("Error marking inbox read: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to mark inbox read")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMyEvents streams change events for files the caller watches
+func (s *Server) handleMyEvents(w http.ResponseWriter, r *http.Request) {
+	user := s.currentUser(r)
+
+	// Watches are loaded once, and again only after any watch changed
+	var watches []store.Watch
+	generation := int64(-1)
+	s.streamEvents(w, r, func(event events.Event) bool {
+		change, ok := event.Data.(store.ChangeEvent)
+		if event.Type != events.EventChange || !ok {
+			return false
+		}
+
+		if current := s.watchGeneration.Load(); current != generation {
+			loaded, err := s.store.ListWatches(user)
+			if err != nil {
+				log.Printf("Error listing watches: %v", err)
+				return false
+			}
+			watches, generation = loaded, current
+		}
+		for _, watch := range watches {
+			if watch.Matches(change.BlobPath) {
+				return true
+			}
+		}
+		return false
+	})
+}
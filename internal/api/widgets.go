@@ -0,0 +1,114 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// defaultWidgetDays is how far back the widgets look unless days is given
+	defaultWidgetDays = 7
+	// maxWidgetDays caps the days parameter
+	maxWidgetDays = 365
+)
+
+// widgetList is the changes of one kind recorded in the last few days
+type widgetList struct {
+	Days    int                 `json:"days"`
+	Since   time.Time           `json:"since"`
+	Changes []store.ChangeEvent `json:"changes"`
+}
+
+// handleRecentRestores lists the restores of the last days (7 by default),
+// including restores from deletion and merged restores, most recent first
+func (s *Server) handleRecentRestores(w http.ResponseWriter, r *http.Request) {
+	s.respondWidget(w, r, store.SearchQuery{Restored: true}, "restores")
+}
+
+// handleRecentDeletions lists the deletions of the last days (7 by default),
+// most recent first
+func (s *Server) handleRecentDeletions(w http.ResponseWriter, r *http.Request) {
+	s.respondWidget(w, r, store.SearchQuery{ChangeType: store.ChangeTypeDeleted}, "deletions")
+}
+
+// churnList is the files changed most often in the last few days
+type churnList struct {
+	Days  int                 `json:"days"`
+	Since time.Time           `json:"since"`
+	Files []store.ChurnedFile `json:"files"`
+}
+
+// handleChurnedFiles lists the files changed most often in the last days
+// (7 by default), most changed first
+func (s *Server) handleChurnedFiles(w http.ResponseWriter, r *http.Request) {
+	days, limit, ok := widgetParams(w, r)
+	if !ok {
+		return
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	files, err := s.store.ListChurnedFiles(since, limit)
+	if err != nil {
+		log.Printf("Error listing churned files: %v", err)
+		respondError(w, http.StatusInternalServerError, "Failed to list churned files")
+		return
+	}
+	if files == nil {
+		files = []store.ChurnedFile{}
+	}
+
+	respondJSON(w, http.StatusOK, churnList{Days: days, Since: since, Files: files})
+}
+
+// respondWidget responds with the changes matching query over the days
+// parameter, up to the limit parameter
+func (s *Server) respondWidget(w http.ResponseWriter, r *http.Request, query store.SearchQuery, what string) {
+	days, limit, ok := widgetParams(w, r)
+	if !ok {
+		return
+	}
+	query.Limit = limit
+	query.Since = time.Now().AddDate(0, 0, -days)
+
+	changes, err := s.store.SearchChanges(query)
+	if err != nil {
+		log.Printf("Error listing recent %s: %v", what, err)
+		respondError(w, http.StatusInternalServerError, "Failed to list recent "+what)
+		return
+	}
+	if changes == nil {
+		changes = []store.ChangeEvent{}
+	}
+
+	respondJSON(w, http.StatusOK, widgetList{Days: days, Since: query.Since, Changes: changes})
+}
+
+// widgetParams reads the days and limit parameters of a widget, responding
+// with an error if either is invalid
+func widgetParams(w http.ResponseWriter, r *http.Request) (days, limit int, ok bool) {
+	q := r.URL.Query()
+	days = defaultWidgetDays
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxWidgetDays {
+			respondError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxWidgetDays))
+			return 0, 0, false
+		}
+		days = n
+	}
+
+	limit = defaultSearchLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+		limit = min(n, maxSearchLimit)
+	}
+	return days, limit, true
+}
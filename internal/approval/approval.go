@@ -0,0 +1,256 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/github"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
+)
+
+var (
+	// ErrNotFound is returned for an unknown proposal
+	ErrNotFound = errors.New("proposal not found")
+	// ErrNotPending is returned when a proposal has already been decided
+	ErrNotPending = errors.New("proposal is no longer pending")
+	// ErrSelfApproval is returned when the author tries to approve their own proposal
+	ErrSelfApproval = errors.New("a proposal must be approved by someone other than its author")
+	// ErrNotAuthor is returned when someone other than the author withdraws a proposal
+	ErrNotAuthor = errors.New("only the author can withdraw a proposal")
+	// ErrReviewedOnGitHub is returned when approving or rejecting a proposal
+	// that is reviewed as a pull request
+	ErrReviewedOnGitHub = errors.New("proposal is reviewed in its pull request; merge or close it there")
+)
+
+// Service runs the approval workflow: edits and restores to files that need
+// approval are stored as proposals, and only written to blob storage once a
+// second user approves them
+type Service struct {
+	cfg    config.ApprovalConfig
+	store  store.Store
+	syncer *syncer.Syncer
+	events *events.Broker
+	github *github.Client
+}
+
+// New creates an approval service
+func New(cfg config.ApprovalConfig, st store.Store, syncService *syncer.Syncer, broker *events.Broker) *Service {
+	s := &Service{cfg: cfg, store: st, syncer: syncService, events: broker}
+	if cfg.GitHub.Enabled() {
+		s.github = github.NewClient(cfg.GitHub)
+	}
+	return s
+}
+
+// Required reports whether changes to blobPath must go through approval
+func (s *Service) Required(blobPath string) bool {
+	return s.cfg.RequiredFor(blobPath)
+}
+
+// Propose stores a pending proposal and notifies reviewers. Proposals for
+// paths reviewed on GitHub also get a pull request; if it can't be opened the
+// proposal fails and the error is returned.
+func (s *Service) Propose(ctx context.Context, p *store.Proposal) error {
+	p.Status = store.ProposalPending
+	if err := s.store.CreateProposal(p); err != nil {
+		return err
+	}
+
+	log.Printf("Proposal %d: %s of %s proposed by %s", p.ID, p.Kind, p.BlobPath, p.Author)
+
+	if s.cfg.GitHub.Matches(p.BlobPath) {
+		if err := s.openPullRequest(ctx, p); err != nil {
+			p.Status = store.ProposalFailed
+			p.Error = err.Error()
+			if terr := s.transition(p, store.ProposalPending); terr != nil {
+				log.Printf("Error failing proposal %d: %v", p.ID, terr)
+			}
+			return err
+		}
+	}
+
+	s.publish(p)
+	return nil
+}
+
+// Approve approves a pending proposal and writes it to blob storage. A
+// proposal that can't be applied, for example because the file changed since
+// it was proposed, ends up failed with the reason in its Error field. The
+// reviewer must be an authenticated identity, such as the user of an API key,
+// so that authors can't approve their own proposals under another name.
+func (s *Service) Approve(ctx context.Context, id int64, reviewer, comment string) (*store.Proposal, error) {
+	p, err := s.pending(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.PullRequestNumber != 0 {
+		return nil, ErrReviewedOnGitHub
+	}
+	if sameUser(p.Author, reviewer) {
+		return nil, ErrSelfApproval
+	}
+	return s.approve(ctx, p, reviewer, comment)
+}
+
+// approve moves a pending proposal through approved to applied or failed
+func (s *Service) approve(ctx context.Context, p *store.Proposal, reviewer, comment string) (*store.Proposal, error) {
+	p.Status = store.ProposalApproved
+	p.Reviewer = reviewer
+	p.ReviewComment = comment
+	if err := s.transition(p, store.ProposalPending); err != nil {
+		return nil, err
+	}
+
+	if err := s.apply(ctx, p); err != nil {
+		log.Printf("Proposal %d could not be applied: %v", p.ID, err)
+		p.Status = store.ProposalFailed
+		p.Error = err.Error()
+	} else {
+		p.Status = store.ProposalApplied
+	}
+
+	if err := s.transition(p, store.ProposalApproved); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reject closes a pending proposal without writing it
+func (s *Service) Reject(id int64, reviewer, comment string) (*store.Proposal, error) {
+	p, err := s.pending(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.PullRequestNumber != 0 {
+		return nil, ErrReviewedOnGitHub
+	}
+
+	p.Status = store.ProposalRejected
+	p.Reviewer = reviewer
+	p.ReviewComment = comment
+	if err := s.transition(p, store.ProposalPending); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Withdraw lets the author close their own pending proposal. Its pull
+// request, if any, is closed too.
+func (s *Service) Withdraw(ctx context.Context, id int64, user string) (*store.Proposal, error) {
+	p, err := s.pending(id)
+	if err != nil {
+		return nil, err
+	}
+	if !sameUser(p.Author, user) {
+		return nil, ErrNotAuthor
+	}
+
+	p.Status = store.ProposalWithdrawn
+	if err := s.transition(p, store.ProposalPending); err != nil {
+		return nil, err
+	}
+
+	if p.PullRequestNumber != 0 && s.github != nil {
+		if err := s.github.ClosePullRequest(ctx, p.PullRequestNumber); err != nil {
+			log.Printf("Error closing pull request of proposal %d: %v", p.ID, err)
+		}
+	}
+	return p, nil
+}
+
+// pending loads a proposal that is still waiting for review
+func (s *Service) pending(id int64) (*store.Proposal, error) {
+	p, err := s.store.GetProposal(id)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNotFound
+	}
+	if p.Status != store.ProposalPending {
+		return nil, ErrNotPending
+	}
+	return p, nil
+}
+
+// transition saves a proposal's new state, provided it is still in state
+// from, and notifies subscribers
+func (s *Service) transition(p *store.Proposal, from store.ProposalStatus) error {
+	ok, err := s.store.UpdateProposal(p, from)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotPending
+	}
+
+	log.Printf("Proposal %d: %s -> %s", p.ID, from, p.Status)
+	s.publish(p)
+	return nil
+}
+
+// apply writes an approved proposal to blob storage and records the version
+func (s *Service) apply(ctx context.Context, p *store.Proposal) error {
+	if p.BaseVersionID != 0 {
+		file, err := s.store.GetFile(p.BlobPath)
+		if err != nil {
+			return err
+		}
+		var latest *store.Version
+		if file != nil {
+			if latest, err = s.store.GetLatestVersion(file.ID); err != nil {
+				return err
+			}
+		}
+		if latest == nil || latest.ID != p.BaseVersionID {
+			return fmt.Errorf("file has changed since the change was proposed")
+		}
+	}
+
+	approval := "approved by " + p.Reviewer
+	if p.ReviewComment != "" {
+		approval += ": " + p.ReviewComment
+	}
+	comment := fmt.Sprintf("(%s)", approval)
+	if p.Comment != "" {
+		comment = p.Comment + " " + comment
+	}
+
+	version, err := s.syncer.RecordEdit(ctx, syncer.Edit{
+		BlobPath: p.BlobPath,
+		Content:  []byte(p.Content),
+		Author:   p.Author,
+		Comment:  comment,
+		IfMatch:  p.BaseETag,
+
+		RestoredFrom: p.RestoreVersionID,
+	})
+	if err != nil {
+		return err
+	}
+	if version != nil {
+		p.VersionID = version.ID
+	}
+	return nil
+}
+
+// publish notifies subscribers of a proposal's current state. The content is
+// left out; it is available from the API.
+func (s *Service) publish(p *store.Proposal) {
+	event := *p
+	event.Content = ""
+	s.events.Publish(events.Event{Type: events.EventProposal, Data: event})
+}
+
+// sameUser reports whether two identities name the same user. Names are
+// compared without regard to case, as an SSO proxy may not spell a name the
+// way the API keys do.
+func sameUser(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
@@ -0,0 +1,190 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// openPullRequest commits a proposal's content to a new branch of the mirror
+// repository and opens a pull request for it. The branch is cut after the
+// base branch has been brought up to date with the vault, so that the pull
+// request shows only the proposed change.
+func (s *Service) openPullRequest(ctx context.Context, p *store.Proposal) error {
+	base := s.cfg.GitHub.BaseBranch
+	branch := fmt.Sprintf("toggle-vault/proposal-%d", p.ID)
+	repoPath := s.github.RepoPath(p.BlobPath)
+
+	if err := s.syncBaseBranch(ctx, p.BlobPath, repoPath); err != nil {
+		return err
+	}
+
+	sha, err := s.github.BranchSHA(ctx, base)
+	if err != nil {
+		return err
+	}
+	if err := s.github.CreateBranch(ctx, branch, sha); err != nil {
+		return err
+	}
+
+	_, fileSHA, err := s.github.GetFile(ctx, repoPath, branch)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("toggle-vault: %s %s", p.Kind, p.BlobPath)
+	if err := s.github.PutFile(ctx, repoPath, branch, title, []byte(p.Content), fileSHA); err != nil {
+		return err
+	}
+
+	pr, err := s.github.CreatePullRequest(ctx, title, pullRequestBody(p), branch)
+	if err != nil {
+		return err
+	}
+
+	p.PullRequestNumber = pr.Number
+	p.PullRequestURL = pr.HTMLURL
+	if err := s.store.SetProposalPullRequest(p.ID, pr.Number, pr.HTMLURL); err != nil {
+		return err
+	}
+
+	log.Printf("Proposal %d: opened pull request %s", p.ID, pr.HTMLURL)
+	return nil
+}
+
+// syncBaseBranch commits the vault's current content of a file to the
+// mirror's base branch if the branch holds something else. Files the vault
+// has no content for, such as deleted ones, are left as they are.
+func (s *Service) syncBaseBranch(ctx context.Context, blobPath, repoPath string) error {
+	file, err := s.store.GetFile(blobPath)
+	if err != nil {
+		return err
+	}
+	if file == nil || file.IsDeleted {
+		return nil
+	}
+	latest, err := s.store.GetLatestVersion(file.ID)
+	if err != nil {
+		return err
+	}
+	if latest == nil || latest.ChangeType == store.ChangeTypeDeleted {
+		return nil
+	}
+	content, err := s.syncer.VersionContent(ctx, latest)
+	if err != nil {
+		return err
+	}
+
+	base := s.cfg.GitHub.BaseBranch
+	mirrored, sha, err := s.github.GetFile(ctx, repoPath, base)
+	if err != nil {
+		return err
+	}
+	if sha != "" && bytes.Equal(mirrored, content) {
+		return nil
+	}
+	message := fmt.Sprintf("toggle-vault: sync %s (version %d)", blobPath, latest.ID)
+	return s.github.PutFile(ctx, repoPath, base, message, content, sha)
+}
+
+// pullRequestBody describes a proposal for reviewers on GitHub
+func pullRequestBody(p *store.Proposal) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Proposed by **%s** through toggle-vault (proposal %d).\n\n", p.Author, p.ID)
+	if p.Comment != "" {
+		fmt.Fprintf(&sb, "> %s\n\n", p.Comment)
+	}
+	if p.Kind == store.ProposalRestore {
+		fmt.Fprintf(&sb, "Restores version %d of `%s`.\n\n", p.RestoreVersionID, p.BlobPath)
+	}
+	sb.WriteString("Merging this pull request writes the file to blob storage. Closing it rejects the proposal.\n")
+	return sb.String()
+}
+
+// StartPolling checks the pull requests of pending proposals in the
+// background until ctx is cancelled. Merged pull requests are applied,
+// closed ones reject their proposal.
+func (s *Service) StartPolling(ctx context.Context) {
+	if s.github == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.cfg.GitHub.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.checkPullRequests(ctx); err != nil {
+					log.Printf("Error checking pull requests: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// checkPullRequests settles every pending proposal whose pull request was
+// merged or closed. Nothing is settled in maintenance mode, which pauses the
+// syncer: applying a merged pull request writes its blob.
+func (s *Service) checkPullRequests(ctx context.Context) error {
+	if s.syncer != nil && s.syncer.Paused() {
+		return nil
+	}
+
+	proposals, err := s.store.ListProposals(store.ProposalQuery{Status: store.ProposalPending})
+	if err != nil {
+		return err
+	}
+
+	for i := range proposals {
+		p := &proposals[i]
+		if p.PullRequestNumber == 0 {
+			continue
+		}
+
+		pr, err := s.github.GetPullRequest(ctx, p.PullRequestNumber)
+		if err != nil {
+			log.Printf("Proposal %d: %v", p.ID, err)
+			continue
+		}
+
+		switch {
+		case pr.Merged:
+			// Apply what was merged, which may have been amended during review
+			content, _, err := s.github.GetFile(ctx, s.github.RepoPath(p.BlobPath), pr.MergeCommitSHA)
+			if err != nil {
+				log.Printf("Proposal %d: %v", p.ID, err)
+				continue
+			}
+			if content != nil {
+				p.Content = string(content)
+			}
+
+			reviewer := "github"
+			if pr.MergedBy != nil {
+				reviewer = "github:" + pr.MergedBy.Login
+			}
+			if _, err := s.approve(ctx, p, reviewer, "merged "+pr.HTMLURL); err != nil {
+				log.Printf("Error applying proposal %d: %v", p.ID, err)
+			}
+
+		case pr.State == "closed":
+			p.Status = store.ProposalRejected
+			p.Reviewer = "github"
+			p.ReviewComment = "closed without merging " + pr.HTMLURL
+			if err := s.transition(p, store.ProposalPending); err != nil {
+				log.Printf("Error rejecting proposal %d: %v", p.ID, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package blob
+
+import (
+	"fmt"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+// accountClients returns a snapshot of the storage account clients
+func (c *Client) accountClients() []*StorageAccountClient {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.accounts
+}
+
+// HasStorageAccount reports whether a storage account is registered
+func (c *Client) HasStorageAccount(name string) bool {
+	_, err := c.getAccountClient(name)
+	return err == nil
+}
+
+// AddStorageAccount registers a storage account at runtime, for example one
+// found by discovery. The next sync lists its blobs. Adding an account that is
+// already registered does nothing.
+func (c *Client) AddStorageAccount(accountCfg config.StorageAccountConfig) error {
+	accountClient, err := newStorageAccountClient(accountCfg, c.authConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create client for storage account '%s': %w", accountCfg.Name, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, account := range c.accounts {
+		if account.accountConfig.Name == accountCfg.Name {
+			return nil
+		}
+	}
+	// Copy so snapshots handed out by accountClients stay unchanged
+	accounts := make([]*StorageAccountClient, len(c.accounts), len(c.accounts)+1)
+	copy(accounts, c.accounts)
+	c.accounts = append(accounts, accountClient)
+	return nil
+}
@@ -8,10 +8,10 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 	"github.com/toggle-vault/internal/config"
@@ -26,6 +26,7 @@ type BlobInfo struct {
 	ETag           string
 	LastModified   time.Time
 	Size           int64
+	ContentMD5     string // hex MD5 of the content, if the uploader set one
 }
 
 // BlobContent represents the content and metadata of a blob
@@ -39,12 +40,14 @@ type BlobContent struct {
 type StorageAccountClient struct {
 	serviceClient  *service.Client
 	credential     azcore.TokenCredential
+	sharedKey      *service.SharedKeyCredential // Set for account key auth, used to sign SAS URLs
 	accountConfig  config.StorageAccountConfig
 	authConfig     config.AzureConfig // For auth settings (shared across accounts)
 }
 
 // Client wraps multiple storage account clients
 type Client struct {
+	mu         sync.RWMutex // guards accounts, which discovery can extend
 	accounts   []*StorageAccountClient
 	authConfig config.AzureConfig
 }
@@ -52,7 +55,7 @@ type Client struct {
 // NewClient creates a new Azure Blob client that supports multiple storage accounts
 func NewClient(cfg config.AzureConfig) (*Client, error) {
 	storageAccounts := cfg.GetStorageAccounts()
-	if len(storageAccounts) == 0 {
+	if len(storageAccounts) == 0 && len(cfg.Discovery) == 0 {
 		return nil, fmt.Errorf("no storage accounts configured")
 	}
 
@@ -77,46 +80,48 @@ func NewClient(cfg config.AzureConfig) (*Client, error) {
 func newStorageAccountClient(accountCfg config.StorageAccountConfig, authCfg config.AzureConfig) (*StorageAccountClient, error) {
 	var serviceClient *service.Client
 	var cred azcore.TokenCredential
+	var sharedKey *service.SharedKeyCredential
 	var err error
 
 	serviceURL := accountCfg.GetServiceURL()
+	auth := authCfg.AuthFor(accountCfg)
 
-	switch authCfg.GetAuthMethod() {
-	case "connection_string":
-		serviceClient, err = service.NewClientFromConnectionString(authCfg.ConnectionString, nil)
+	switch method := auth.GetAuthMethod(); method {
+	case config.AuthConnectionString:
+		serviceClient, err = service.NewClientFromConnectionString(auth.ConnectionString, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create client from connection string: %w", err)
 		}
 
-	case "sas_token":
+	case config.AuthSASToken:
 		sasURL := serviceURL
-		if !strings.HasPrefix(authCfg.SASToken, "?") {
+		if !strings.HasPrefix(auth.SASToken, "?") {
 			sasURL += "?"
 		}
-		sasURL += authCfg.SASToken
+		sasURL += auth.SASToken
 		serviceClient, err = service.NewClientWithNoCredential(sasURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create client with SAS token: %w", err)
 		}
 
-	case "managed_identity":
-		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	case config.AuthAccountKey:
+		sharedKey, err = service.NewSharedKeyCredential(accountCfg.Name, auth.AccountKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create default azure credential: %w", err)
+			return nil, fmt.Errorf("failed to create shared key credential: %w", err)
 		}
-		serviceClient, err = service.NewClient(serviceURL, cred, nil)
+		serviceClient, err = service.NewClientWithSharedKeyCredential(serviceURL, sharedKey, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create client with managed identity: %w", err)
+			return nil, fmt.Errorf("failed to create client with account key: %w", err)
 		}
 
-	case "service_principal":
-		cred, err = azidentity.NewClientSecretCredential(authCfg.TenantID, authCfg.ClientID, authCfg.ClientSecret, nil)
+	case config.AuthManagedIdentity, config.AuthWorkloadIdentity, config.AuthServicePrincipal, config.AuthServicePrincipalCertificate:
+		cred, err = NewTokenCredential(auth)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create service principal credential: %w", err)
+			return nil, err
 		}
 		serviceClient, err = service.NewClient(serviceURL, cred, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create client with service principal: %w", err)
+			return nil, fmt.Errorf("failed to create client with %s: %w", method, err)
 		}
 
 	default:
@@ -126,6 +131,7 @@ func newStorageAccountClient(accountCfg config.StorageAccountConfig, authCfg con
 	return &StorageAccountClient{
 		serviceClient: serviceClient,
 		credential:    cred,
+		sharedKey:     sharedKey,
 		accountConfig: accountCfg,
 		authConfig:    authCfg,
 	}, nil
@@ -133,8 +139,9 @@ func newStorageAccountClient(accountCfg config.StorageAccountConfig, authCfg con
 
 // GetStorageAccountNames returns the names of all configured storage accounts
 func (c *Client) GetStorageAccountNames() []string {
-	names := make([]string, len(c.accounts))
-	for i, account := range c.accounts {
+	accounts := c.accountClients()
+	names := make([]string, len(accounts))
+	for i, account := range accounts {
 		names[i] = account.accountConfig.Name
 	}
 	return names
@@ -142,7 +149,7 @@ func (c *Client) GetStorageAccountNames() []string {
 
 // getAccountClient returns the client for a specific storage account
 func (c *Client) getAccountClient(storageAccount string) (*StorageAccountClient, error) {
-	for _, account := range c.accounts {
+	for _, account := range c.accountClients() {
 		if account.accountConfig.Name == storageAccount {
 			return account, nil
 		}
@@ -154,7 +161,7 @@ func (c *Client) getAccountClient(storageAccount string) (*StorageAccountClient,
 func (c *Client) ListContainers(ctx context.Context) (map[string][]string, error) {
 	result := make(map[string][]string)
 
-	for _, account := range c.accounts {
+	for _, account := range c.accountClients() {
 		containers, err := account.ListContainers(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to list containers in '%s': %w", account.accountConfig.Name, err)
@@ -186,58 +193,153 @@ func (s *StorageAccountClient) ListContainers(ctx context.Context) ([]string, er
 	return containers, nil
 }
 
-// GetContainersToScan returns the list of containers to scan based on config
+// GetContainersToScan returns the list of containers to scan based on config.
+// Discovered containers are filtered by the include/exclude expressions.
 func (s *StorageAccountClient) GetContainersToScan(ctx context.Context) ([]string, error) {
-	if s.accountConfig.ShouldScanAllContainers() {
-		return s.ListContainers(ctx)
+	if !s.accountConfig.DiscoversContainers() {
+		return s.accountConfig.GetContainers(), nil
+	}
+
+	match, err := s.accountConfig.ContainerMatcher()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := s.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []string
+	for _, name := range containers {
+		if match(name) {
+			selected = append(selected, name)
+		}
 	}
-	return s.accountConfig.GetContainers(), nil
+	return selected, nil
 }
 
 // ListBlobs lists all blobs across all storage accounts and their containers
 func (c *Client) ListBlobs(ctx context.Context, patterns []string) ([]BlobInfo, error) {
 	var allBlobs []BlobInfo
 
-	for _, account := range c.accounts {
-		blobs, err := account.ListBlobs(ctx, patterns)
-		if err != nil {
+	for _, listing := range c.ListBlobsByAccount(ctx, patterns, nil) {
+		if listing.Err != nil {
 			// Log error but continue with other accounts
-			fmt.Printf("Warning: failed to list blobs in storage account %s: %v\n", account.accountConfig.Name, err)
+			fmt.Printf("Warning: failed to list blobs in storage account %s: %v\n", listing.StorageAccount, listing.Err)
 			continue
 		}
-		allBlobs = append(allBlobs, blobs...)
+		allBlobs = append(allBlobs, listing.Blobs...)
 	}
 
 	return allBlobs, nil
 }
 
+// AccountListing is the result of listing the blobs of one storage account
+type AccountListing struct {
+	StorageAccount string
+	Blobs          []BlobInfo
+	Err            error
+	// FailedContainers are the containers that failed to list, with their
+	// errors, when others could be listed. Their blobs are missing from Blobs.
+	FailedContainers map[string]error
+	// Paused is set when the account was skipped because it is paused
+	Paused bool
+}
+
+// ListBlobsByAccount lists the blobs of each storage account matching the
+// patterns, with the error of each account whose listing failed. Accounts
+// and containers for which paused returns true, called with an empty
+// container for the account itself, are skipped. paused may be nil.
+func (c *Client) ListBlobsByAccount(ctx context.Context, patterns []string, paused func(storageAccount, container string) bool) []AccountListing {
+	var listings []AccountListing
+	for _, account := range c.accountClients() {
+		name := account.accountConfig.Name
+		if paused != nil && paused(name, "") {
+			listings = append(listings, AccountListing{StorageAccount: name, Paused: true})
+			continue
+		}
+		var skip func(container string) bool
+		if paused != nil {
+			skip = func(container string) bool { return paused(name, container) }
+		}
+		blobs, failed, err := account.listBlobs(ctx, patterns, skip)
+		listings = append(listings, AccountListing{
+			StorageAccount:   account.accountConfig.Name,
+			Blobs:            blobs,
+			Err:              err,
+			FailedContainers: failed,
+		})
+	}
+	return listings
+}
+
+// ListBlobsWithPrefix lists the blobs under prefix in a container of a
+// storage account matching the patterns
+func (c *Client) ListBlobsWithPrefix(ctx context.Context, storageAccount, containerName, prefix string, patterns []string) ([]BlobInfo, error) {
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+	return accountClient.ListBlobsWithPrefix(ctx, containerName, prefix, patterns)
+}
+
 // ListBlobs lists all blobs in this storage account matching the patterns
 func (s *StorageAccountClient) ListBlobs(ctx context.Context, patterns []string) ([]BlobInfo, error) {
+	blobs, _, err := s.listBlobs(ctx, patterns, nil)
+	return blobs, err
+}
+
+// listBlobs lists the blobs in this storage account matching the patterns,
+// skipping the containers for which skip, if not nil, returns true. The
+// containers that failed to list are returned with their errors.
+func (s *StorageAccountClient) listBlobs(ctx context.Context, patterns []string, skip func(container string) bool) ([]BlobInfo, map[string]error, error) {
 	containers, err := s.GetContainersToScan(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var allBlobs []BlobInfo
+	var lastErr error
+	failed := make(map[string]error)
+	scanned := 0
 	for _, containerName := range containers {
+		if skip != nil && skip(containerName) {
+			continue
+		}
+		scanned++
 		blobs, err := s.ListBlobsInContainer(ctx, containerName, patterns)
 		if err != nil {
 			// Log error but continue with other containers
 			fmt.Printf("Warning: failed to list blobs in %s/%s: %v\n", s.accountConfig.Name, containerName, err)
+			lastErr = err
+			failed[containerName] = err
 			continue
 		}
 		allBlobs = append(allBlobs, blobs...)
 	}
 
-	return allBlobs, nil
+	// An account none of whose containers could be listed, for example
+	// because its credentials were revoked, has failed as a whole
+	if len(failed) > 0 && len(failed) == scanned {
+		return nil, nil, lastErr
+	}
+	if len(failed) == 0 {
+		failed = nil
+	}
+
+	return allBlobs, failed, nil
 }
 
 // ListBlobsInContainer lists all blobs in a specific container matching the patterns
 func (s *StorageAccountClient) ListBlobsInContainer(ctx context.Context, containerName string, patterns []string) ([]BlobInfo, error) {
+	return s.ListBlobsWithPrefix(ctx, containerName, s.accountConfig.Prefix, patterns)
+}
+
+// ListBlobsWithPrefix lists the blobs under prefix in a container matching the patterns
+func (s *StorageAccountClient) ListBlobsWithPrefix(ctx context.Context, containerName, prefix string, patterns []string) ([]BlobInfo, error) {
 	var blobs []BlobInfo
 
 	containerClient := s.serviceClient.NewContainerClient(containerName)
-	prefix := s.accountConfig.Prefix
 
 	pager := containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
 		Prefix: &prefix,
@@ -257,7 +359,7 @@ func (s *StorageAccountClient) ListBlobsInContainer(ctx context.Context, contain
 			name := *blob.Name
 
 			// Check if blob matches any of the patterns
-			if !matchesPatterns(name, patterns) {
+			if !MatchesPatterns(name, patterns) {
 				continue
 			}
 
@@ -278,6 +380,9 @@ func (s *StorageAccountClient) ListBlobsInContainer(ctx context.Context, contain
 				if blob.Properties.ContentLength != nil {
 					info.Size = *blob.Properties.ContentLength
 				}
+				if len(blob.Properties.ContentMD5) > 0 {
+					info.ContentMD5 = hex.EncodeToString(blob.Properties.ContentMD5)
+				}
 			}
 
 			blobs = append(blobs, info)
@@ -287,8 +392,9 @@ func (s *StorageAccountClient) ListBlobsInContainer(ctx context.Context, contain
 	return blobs, nil
 }
 
-// matchesPatterns checks if a blob name matches any of the configured patterns
-func matchesPatterns(name string, patterns []string) bool {
+// MatchesPatterns checks if a blob name matches any of the configured patterns.
+// An empty pattern list matches everything.
+func MatchesPatterns(name string, patterns []string) bool {
 	if len(patterns) == 0 {
 		return true
 	}
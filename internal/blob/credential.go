@@ -0,0 +1,71 @@
+package blob
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/toggle-vault/internal/config"
+)
+
+// NewTokenCredential creates a Microsoft Entra ID credential for auth methods
+// that use one (managed identity, workload identity and service principals)
+func NewTokenCredential(auth config.AuthConfig) (azcore.TokenCredential, error) {
+	switch auth.GetAuthMethod() {
+	case config.AuthManagedIdentity:
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create default azure credential: %w", err)
+		}
+		return cred, nil
+
+	case config.AuthWorkloadIdentity:
+		cred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      auth.TenantID,
+			ClientID:      auth.ClientID,
+			TokenFilePath: auth.FederatedTokenFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create workload identity credential: %w", err)
+		}
+		return cred, nil
+
+	case config.AuthServicePrincipal:
+		cred, err := azidentity.NewClientSecretCredential(auth.TenantID, auth.ClientID, auth.ClientSecret, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create service principal credential: %w", err)
+		}
+		return cred, nil
+
+	case config.AuthServicePrincipalCertificate:
+		return newClientCertificateCredential(auth)
+
+	default:
+		return nil, fmt.Errorf("auth method %q does not use a token credential", auth.GetAuthMethod())
+	}
+}
+
+// newClientCertificateCredential loads the configured PEM or PFX certificate
+// and creates a service principal credential from it
+func newClientCertificateCredential(authCfg config.AuthConfig) (azcore.TokenCredential, error) {
+	data, err := os.ReadFile(authCfg.ClientCertificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+
+	var password []byte
+	if authCfg.ClientCertificatePassword != "" {
+		password = []byte(authCfg.ClientCertificatePassword)
+	}
+	certs, key, err := azidentity.ParseCertificates(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+
+	cred, err := azidentity.NewClientCertificateCredential(authCfg.TenantID, authCfg.ClientID, certs, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create service principal certificate credential: %w", err)
+	}
+	return cred, nil
+}
@@ -0,0 +1,27 @@
+package blob
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// IsAuthError reports whether err means the credentials were rejected or
+// lack access: a token that couldn't be obtained, or a 401 or 403 response
+func IsAuthError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusUnauthorized || respErr.StatusCode == http.StatusForbidden
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	return errors.As(err, &authErr)
+}
+
+// IsNotFound reports whether err means the blob or its container doesn't
+// exist, for example because it was deleted after being listed
+func IsNotFound(err error) bool {
+	return bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound)
+}
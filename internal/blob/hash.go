@@ -0,0 +1,36 @@
+package blob
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+)
+
+// listedHashPrefix marks content hashes taken from the Content-MD5 of a
+// listing rather than computed from downloaded content
+const listedHashPrefix = "md5:"
+
+// ListedHash returns the content hash of a blob as known from its listing,
+// or "" if the uploader set no Content-MD5. It lets a change be recorded
+// without downloading the blob.
+func ListedHash(info BlobInfo) string {
+	if info.ContentMD5 == "" {
+		return ""
+	}
+	return listedHashPrefix + info.ContentMD5
+}
+
+// IsListedHash reports whether a content hash was taken from a listing
+func IsListedHash(hash string) bool {
+	return strings.HasPrefix(hash, listedHashPrefix)
+}
+
+// HashMatches reports whether content has the given hash, which may be a
+// SHA256 computed by ComputeHash or a listed hash from ListedHash
+func HashMatches(content []byte, hash string) bool {
+	if md5Hex, ok := strings.CutPrefix(hash, listedHashPrefix); ok {
+		sum := md5.Sum(content)
+		return hex.EncodeToString(sum[:]) == md5Hex
+	}
+	return ComputeHash(content) == hash
+}
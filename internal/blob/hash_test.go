@@ -0,0 +1,39 @@
+package blob
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashMatches(t *testing.T) {
+	content := []byte("key: value\n")
+	sum := md5.Sum(content)
+	listed := ListedHash(BlobInfo{ContentMD5: hex.EncodeToString(sum[:])})
+
+	tests := []struct {
+		name    string
+		content []byte
+		hash    string
+		want    bool
+	}{
+		{"computed hash", content, ComputeHash(content), true},
+		{"computed hash of other content", []byte("key: other\n"), ComputeHash(content), false},
+		{"listed hash", content, listed, true},
+		{"listed hash of other content", []byte("key: other\n"), listed, false},
+		{"empty hash", content, "", false},
+		{"base64 listed hash", content, listedHashPrefix + base64.StdEncoding.EncodeToString(sum[:]), false},
+	}
+
+	if hash := ListedHash(BlobInfo{}); hash != "" {
+		t.Errorf("ListedHash() without a Content-MD5 = %q, want none", hash)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashMatches(tt.content, tt.hash); got != tt.want {
+				t.Errorf("HashMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// ErrImmutable is returned when writing a blob that an immutability policy
+// or legal hold protects
+var ErrImmutable = errors.New("blob is protected by an immutability policy")
+
+// Immutability describes the immutable storage settings of a container
+type Immutability struct {
+	// Policy is set when a time-based retention policy covers the container
+	Policy bool
+	// LegalHold is set when the container has a legal hold
+	LegalHold bool
+	// VersionLevel is set when individual blob versions can have their own
+	// policies
+	VersionLevel bool
+}
+
+// ReadOnly reports whether the container's blobs can't be overwritten
+func (i Immutability) ReadOnly() bool {
+	return i.Policy || i.LegalHold
+}
+
+// Reason describes why the container is read-only, or "" if it isn't
+func (i Immutability) Reason() string {
+	switch {
+	case i.Policy && i.LegalHold:
+		return "container has an immutability policy and a legal hold"
+	case i.Policy:
+		return "container has an immutability policy"
+	case i.LegalHold:
+		return "container has a legal hold"
+	}
+	return ""
+}
+
+// GetContainerImmutability returns the immutable storage settings of a
+// container
+func (c *Client) GetContainerImmutability(ctx context.Context, storageAccount, containerName string) (Immutability, error) {
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return Immutability{}, err
+	}
+
+	resp, err := accountClient.serviceClient.NewContainerClient(containerName).GetProperties(ctx, nil)
+	if err != nil {
+		return Immutability{}, fmt.Errorf("failed to get container properties: %w", err)
+	}
+	return Immutability{
+		Policy:       resp.HasImmutabilityPolicy != nil && *resp.HasImmutabilityPolicy,
+		LegalHold:    resp.HasLegalHold != nil && *resp.HasLegalHold,
+		VersionLevel: resp.IsImmutableStorageWithVersioningEnabled != nil && *resp.IsImmutableStorageWithVersioningEnabled,
+	}, nil
+}
+
+// isImmutable reports whether err means a write was refused because the blob
+// is immutable
+func isImmutable(err error) bool {
+	return bloberror.HasCode(err, bloberror.BlobImmutableDueToPolicy)
+}
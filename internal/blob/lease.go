@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+)
+
+// LeasedError is returned when writing a blob that someone holds a lease on,
+// without that lease's ID
+type LeasedError struct {
+	FullPath string
+	// State is the state of the lease, such as leased or breaking
+	State string
+	// Duration is infinite, or fixed for a lease of 15 to 60 seconds
+	Duration string
+	// Mismatch is set when a lease ID was given but isn't the blob's lease,
+	// or the lease has expired
+	Mismatch bool
+}
+
+func (e *LeasedError) Error() string {
+	msg := "blob " + e.FullPath + " is leased"
+	if e.Duration != "" {
+		msg += " (" + e.Duration + " lease"
+		if e.State != "" && e.State != string(lease.StateTypeLeased) {
+			msg += ", " + e.State
+		}
+		msg += ")"
+	}
+	if e.Mismatch {
+		return msg + " and the given lease ID is not its current lease"
+	}
+	return msg + "; writing it requires the lease ID of its holder"
+}
+
+// isLeaseConflict reports whether err means a write was refused because of
+// a lease on the blob
+func isLeaseConflict(err error) bool {
+	return bloberror.HasCode(err, bloberror.LeaseIDMissing, bloberror.LeaseIDMismatchWithBlobOperation,
+		bloberror.LeaseIDMismatchWithLeaseOperation, bloberror.LeaseNotPresentWithLeaseOperation, bloberror.LeaseLost)
+}
+
+// renewLease renews the lease leaseID on a blob, so that a fixed lease
+// doesn't expire while the blob is written under it
+func (s *StorageAccountClient) renewLease(ctx context.Context, blobClient *blockblob.Client, containerName, path, leaseID string) error {
+	leaseClient, err := lease.NewBlobClient(blobClient, &lease.BlobClientOptions{LeaseID: &leaseID})
+	if err != nil {
+		return fmt.Errorf("failed to create lease client: %w", err)
+	}
+	if _, err := leaseClient.RenewLease(ctx, nil); err != nil {
+		if isLeaseConflict(err) {
+			return s.leasedError(ctx, blobClient, containerName, path, true)
+		}
+		return fmt.Errorf("failed to renew lease: %w", err)
+	}
+	return nil
+}
+
+// leasedError describes the lease on a blob whose write it refused. The
+// lease's details are left out if they can't be read.
+func (s *StorageAccountClient) leasedError(ctx context.Context, blobClient *blockblob.Client, containerName, path string, mismatch bool) *LeasedError {
+	leased := &LeasedError{
+		FullPath: s.accountConfig.Name + "/" + containerName + "/" + path,
+		Mismatch: mismatch,
+	}
+	props, err := blobClient.BlobClient().GetProperties(ctx, nil)
+	if err != nil {
+		return leased
+	}
+	if props.LeaseState != nil {
+		leased.State = string(*props.LeaseState)
+	}
+	if props.LeaseDuration != nil {
+		leased.Duration = string(*props.LeaseDuration)
+	}
+	return leased
+}
@@ -0,0 +1,75 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// sasClockSkew backdates the start of SAS URLs so they are valid immediately
+// even if the storage service's clock is slightly behind
+const sasClockSkew = 5 * time.Minute
+
+// ErrSASUnsupported is returned when the storage account's auth method can't
+// sign SAS URLs (connection strings and SAS tokens)
+var ErrSASUnsupported = errors.New("storage account auth method cannot sign SAS URLs")
+
+// ReadSASURL returns a read-only SAS URL for a blob using its full path
+// (storageaccount/container/blobpath), valid until expiry
+func (c *Client) ReadSASURL(ctx context.Context, fullPath string, expiry time.Time) (string, error) {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return "", err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return "", err
+	}
+	return accountClient.ReadSASURL(ctx, containerName, blobPath, expiry)
+}
+
+// ReadSASURL returns a read-only SAS URL for a blob in this storage account.
+// Entra ID credentials sign a user delegation SAS; account keys sign a
+// service SAS.
+func (s *StorageAccountClient) ReadSASURL(ctx context.Context, containerName, path string, expiry time.Time) (string, error) {
+	start := time.Now().UTC().Add(-sasClockSkew)
+	expiry = expiry.UTC()
+
+	values := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     start,
+		ExpiryTime:    expiry,
+		Permissions:   (&sas.BlobPermissions{Read: true}).String(),
+		ContainerName: containerName,
+		BlobName:      path,
+	}
+
+	var params sas.QueryParameters
+	var err error
+	switch {
+	case s.credential != nil:
+		startStr := start.Format(sas.TimeFormat)
+		expiryStr := expiry.Format(sas.TimeFormat)
+		udc, uerr := s.serviceClient.GetUserDelegationCredential(ctx, service.KeyInfo{Start: &startStr, Expiry: &expiryStr}, nil)
+		if uerr != nil {
+			return "", fmt.Errorf("failed to get user delegation key: %w", uerr)
+		}
+		params, err = values.SignWithUserDelegation(udc)
+
+	case s.sharedKey != nil:
+		params, err = values.SignWithSharedKey(s.sharedKey)
+
+	default:
+		return "", ErrSASUnsupported
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign SAS: %w", err)
+	}
+
+	blobURL := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path).URL()
+	return blobURL + "?" + params.Encode(), nil
+}
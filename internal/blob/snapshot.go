@@ -0,0 +1,130 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+)
+
+// CreateSnapshot creates a read-only snapshot of a blob and returns its
+// snapshot ID. If etag is set the snapshot is only taken while the blob still
+// has that ETag, so the snapshot is guaranteed to hold the captured content.
+func (c *Client) CreateSnapshot(ctx context.Context, fullPath, etag string) (string, error) {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return "", err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return "", err
+	}
+	return accountClient.CreateSnapshot(ctx, containerName, blobPath, etag)
+}
+
+// CreateSnapshot creates a snapshot of a blob in this storage account
+func (s *StorageAccountClient) CreateSnapshot(ctx context.Context, containerName, path, etag string) (string, error) {
+	blobClient := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path)
+
+	var opts *azblobblob.CreateSnapshotOptions
+	if etag != "" {
+		match := azcore.ETag(etag)
+		opts = &azblobblob.CreateSnapshotOptions{
+			AccessConditions: &azblobblob.AccessConditions{
+				ModifiedAccessConditions: &azblobblob.ModifiedAccessConditions{IfMatch: &match},
+			},
+		}
+	}
+
+	resp, err := blobClient.CreateSnapshot(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if resp.Snapshot == nil {
+		return "", fmt.Errorf("failed to create snapshot: no snapshot ID returned")
+	}
+
+	return *resp.Snapshot, nil
+}
+
+// GetBlobSnapshot downloads a blob snapshot using the blob's full path
+// (storageaccount/container/blobpath) and snapshot ID
+func (c *Client) GetBlobSnapshot(ctx context.Context, fullPath, snapshot string) (*BlobContent, error) {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+	return accountClient.GetBlobSnapshot(ctx, containerName, blobPath, snapshot)
+}
+
+// GetBlobSnapshot downloads a blob snapshot from this storage account
+func (s *StorageAccountClient) GetBlobSnapshot(ctx context.Context, containerName, path, snapshot string) (*BlobContent, error) {
+	blobClient, err := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path).WithSnapshot(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to address snapshot: %w", err)
+	}
+
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot content: %w", err)
+	}
+
+	snapshotContent := &BlobContent{
+		BlobInfo: BlobInfo{
+			StorageAccount: s.accountConfig.Name,
+			Container:      containerName,
+			Path:           path,
+			FullPath:       s.accountConfig.Name + "/" + containerName + "/" + path,
+			Size:           int64(len(content)),
+		},
+		Content:     content,
+		ContentHash: ComputeHash(content),
+	}
+	if resp.ETag != nil {
+		snapshotContent.ETag = string(*resp.ETag)
+	}
+	if resp.LastModified != nil {
+		snapshotContent.LastModified = *resp.LastModified
+	}
+
+	return snapshotContent, nil
+}
+
+// DeleteSnapshot deletes a blob snapshot using the blob's full path
+// (storageaccount/container/blobpath) and snapshot ID
+func (c *Client) DeleteSnapshot(ctx context.Context, fullPath, snapshot string) error {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return err
+	}
+	return accountClient.DeleteSnapshot(ctx, containerName, blobPath, snapshot)
+}
+
+// DeleteSnapshot deletes a blob snapshot from this storage account
+func (s *StorageAccountClient) DeleteSnapshot(ctx context.Context, containerName, path, snapshot string) error {
+	blobClient, err := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path).WithSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to address snapshot: %w", err)
+	}
+
+	if _, err := blobClient.Delete(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
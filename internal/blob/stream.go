@@ -0,0 +1,83 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// UploadStream uploads the content read from r to a blob using its full
+// path (storageaccount/container/blobpath), without holding all of it in
+// memory
+func (c *Client) UploadStream(ctx context.Context, fullPath string, r io.Reader) error {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return err
+	}
+	return accountClient.UploadStream(ctx, containerName, blobPath, r)
+}
+
+// UploadStream uploads the content read from r to a blob in this storage
+// account
+func (s *StorageAccountClient) UploadStream(ctx context.Context, containerName, path string, r io.Reader) error {
+	blobClient := s.serviceClient.NewContainerClient(containerName).NewBlockBlobClient(path)
+
+	if _, err := blobClient.UploadStream(ctx, r, nil); err != nil {
+		return fmt.Errorf("failed to upload blob: %w", err)
+	}
+	return nil
+}
+
+// OpenBlob opens a blob for reading using its full path
+// (storageaccount/container/blobpath), without downloading all of it first.
+// The caller closes the reader.
+func (c *Client) OpenBlob(ctx context.Context, fullPath string) (io.ReadCloser, error) {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+	return accountClient.OpenBlob(ctx, containerName, blobPath)
+}
+
+// OpenBlob opens a blob in this storage account for reading
+func (s *StorageAccountClient) OpenBlob(ctx context.Context, containerName, path string) (io.ReadCloser, error) {
+	blobClient := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path)
+
+	resp, err := blobClient.DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download blob: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// DeleteBlob deletes a blob using its full path
+// (storageaccount/container/blobpath)
+func (c *Client) DeleteBlob(ctx context.Context, fullPath string) error {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return err
+	}
+	return accountClient.DeleteBlob(ctx, containerName, blobPath)
+}
+
+// DeleteBlob deletes a blob from this storage account
+func (s *StorageAccountClient) DeleteBlob(ctx context.Context, containerName, path string) error {
+	blobClient := s.serviceClient.NewContainerClient(containerName).NewBlobClient(path)
+
+	if _, err := blobClient.Delete(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
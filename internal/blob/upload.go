@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+)
+
+// ErrConditionNotMet is returned when a conditional write finds that the
+// blob no longer has the expected ETag
+var ErrConditionNotMet = errors.New("blob was modified since it was last read")
+
+// UploadBlobIfMatch uploads content to a blob using its full path
+// (storageaccount/container/blobpath) and returns the new blob's metadata.
+// If etag is set the upload only succeeds while the blob still has that ETag,
+// otherwise ErrConditionNotMet is returned. A leased blob can only be written
+// with its leaseID, which is renewed first; without it a *LeasedError is
+// returned. A blob protected by an immutability policy returns ErrImmutable.
+func (c *Client) UploadBlobIfMatch(ctx context.Context, fullPath string, content []byte, etag, leaseID string) (*BlobInfo, error) {
+	storageAccount, containerName, blobPath, err := ParseFullPath(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	accountClient, err := c.getAccountClient(storageAccount)
+	if err != nil {
+		return nil, err
+	}
+	return accountClient.UploadBlobIfMatch(ctx, containerName, blobPath, content, etag, leaseID)
+}
+
+// UploadBlobIfMatch uploads content to a blob in this storage account
+func (s *StorageAccountClient) UploadBlobIfMatch(ctx context.Context, containerName, path string, content []byte, etag, leaseID string) (*BlobInfo, error) {
+	blobClient := s.serviceClient.NewContainerClient(containerName).NewBlockBlobClient(path)
+
+	conditions := &azblobblob.AccessConditions{}
+	if etag != "" {
+		match := azcore.ETag(etag)
+		conditions.ModifiedAccessConditions = &azblobblob.ModifiedAccessConditions{IfMatch: &match}
+	}
+	if leaseID != "" {
+		if err := s.renewLease(ctx, blobClient, containerName, path, leaseID); err != nil {
+			return nil, err
+		}
+		conditions.LeaseAccessConditions = &azblobblob.LeaseAccessConditions{LeaseID: &leaseID}
+	}
+
+	resp, err := blobClient.UploadBuffer(ctx, content, &blockblob.UploadBufferOptions{AccessConditions: conditions})
+	if bloberror.HasCode(err, bloberror.ConditionNotMet) {
+		return nil, ErrConditionNotMet
+	}
+	if isImmutable(err) {
+		return nil, ErrImmutable
+	}
+	if isLeaseConflict(err) {
+		return nil, s.leasedError(ctx, blobClient, containerName, path, leaseID != "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload blob: %w", err)
+	}
+
+	info := &BlobInfo{
+		StorageAccount: s.accountConfig.Name,
+		Container:      containerName,
+		Path:           path,
+		FullPath:       s.accountConfig.Name + "/" + containerName + "/" + path,
+		Size:           int64(len(content)),
+	}
+	if resp.ETag != nil {
+		info.ETag = string(*resp.ETag)
+	}
+	if resp.LastModified != nil {
+		info.LastModified = *resp.LastModified
+	}
+
+	return info, nil
+}
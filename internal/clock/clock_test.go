@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// fired reports whether c has a value ready
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		stop        bool
+		wantTick    bool
+		wantTimeout bool
+	}{
+		{name: "before the interval", advance: 30 * time.Second},
+		{name: "at the interval", advance: time.Minute, wantTick: true},
+		{name: "past the timeout", advance: 5 * time.Minute, wantTick: true, wantTimeout: true},
+		{name: "stopped ticker", advance: 5 * time.Minute, stop: true, wantTimeout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFake(start)
+			ticker := f.NewTicker(time.Minute)
+			timeout := f.After(5 * time.Minute)
+			if tt.stop {
+				ticker.Stop()
+			}
+
+			f.Advance(tt.advance)
+
+			if got := f.Now(); !got.Equal(start.Add(tt.advance)) {
+				t.Errorf("now = %s, want %s", got, start.Add(tt.advance))
+			}
+			if got := fired(ticker.C()); got != tt.wantTick {
+				t.Errorf("ticked = %v, want %v", got, tt.wantTick)
+			}
+			if got := fired(timeout); got != tt.wantTimeout {
+				t.Errorf("timed out = %v, want %v", got, tt.wantTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds how long an external command may run when no
+// timeout is configured
+const DefaultTimeout = 30 * time.Second
+
+// Run executes name with args, writing input to its stdin, and returns what
+// it wrote to stdout. A non-zero exit status is returned as an error that
+// includes the command's stderr output.
+func Run(ctx context.Context, name string, args []string, timeout time.Duration, input []byte) ([]byte, error) {
+	return RunEnv(ctx, name, args, nil, timeout, input)
+}
+
+// RunEnv is Run with extra environment variables ("KEY=value") on top of
+// the process's own
+func RunEnv(ctx context.Context, name string, args, env []string, timeout time.Duration, input []byte) ([]byte, error) {
+	return run(ctx, name, args, env, timeout, input, nil)
+}
+
+// ExtraFilePath is where a command run by RunWithFile reads the extra file
+const ExtraFilePath = "/dev/fd/3"
+
+// RunWithFile is Run with file passed to the command through a pipe that it
+// can read at ExtraFilePath, for secrets that mustn't be written to disk
+func RunWithFile(ctx context.Context, name string, args []string, timeout time.Duration, input, file []byte) ([]byte, error) {
+	return run(ctx, name, args, nil, timeout, input, file)
+}
+
+func run(ctx context.Context, name string, args, env []string, timeout time.Duration, input, file []byte) ([]byte, error) {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if file != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		cmd.ExtraFiles = []*os.File{r}
+		// Written concurrently, so a file larger than the pipe's buffer
+		// doesn't block; the command sees EOF once it is all written
+		go func() {
+			defer w.Close()
+			w.Write(file)
+		}()
+	}
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -2,7 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,10 +16,40 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Azure    AzureConfig    `yaml:"azure"`
-	Sync     SyncConfig     `yaml:"sync"`
-	Database DatabaseConfig `yaml:"database"`
-	Server   ServerConfig   `yaml:"server"`
+	Azure     AzureConfig      `yaml:"azure"`
+	Sync      SyncConfig       `yaml:"sync"`
+	Database  DatabaseConfig   `yaml:"database"`
+	Server    ServerConfig     `yaml:"server"`
+	Hooks     []HookConfig     `yaml:"hooks"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Email     EmailConfig      `yaml:"email"`
+	Approvals ApprovalConfig   `yaml:"approvals"`
+	// Applications group the files that affect each application
+	Applications []ApplicationConfig `yaml:"applications"`
+	// Labels derive file labels from their paths
+	Labels []LabelRuleConfig `yaml:"labels"`
+	// Owners maps paths to the teams or people responsible for them
+	Owners OwnersConfig `yaml:"owners"`
+	// Integrity signs versions so tampering with the database is detectable
+	Integrity IntegrityConfig `yaml:"integrity"`
+	// Encryption decrypts SOPS- and age-encrypted files for comparison
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// Impact measures metrics before and after each change
+	Impact ImpactConfig `yaml:"impact"`
+	// ChangeTypes are custom change types recorded in the database, such as
+	// by programs embedding the vault, in addition to the built-in ones
+	ChangeTypes []string `yaml:"change_types"`
+	// Maintenance starts the service in maintenance mode
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
+	// Jobs runs long API operations in the background
+	Jobs JobsConfig `yaml:"jobs"`
+	// Reports sets the working hours the team reports count changes outside
+	Reports ReportsConfig `yaml:"reports"`
+	// Shares limits the view tokens that share a file's history or a diff
+	Shares SharesConfig `yaml:"shares"`
+	// SettingsFile is the full blob path of a tracked YAML file that switches
+	// the vault's own optional behaviors, such as notifications, at runtime
+	SettingsFile string `yaml:"settings_file"`
 }
 
 // StorageAccountConfig contains settings for a single storage account
@@ -34,8 +69,18 @@ type StorageAccountConfig struct {
 	Containers        []string `yaml:"containers"`
 	Container         string   `yaml:"container"`
 
+	// ContainerInclude and ContainerExclude are regular expressions matched
+	// against container names while listing the account. Setting
+	// ContainerInclude scans every container it matches, so new containers
+	// are picked up without config changes; ContainerExclude drops matches.
+	ContainerInclude string `yaml:"container_include"`
+	ContainerExclude string `yaml:"container_exclude"`
+
 	// Prefix filters files to only those with this path prefix
 	Prefix string `yaml:"prefix"`
+
+	// Auth overrides the top-level azure authentication for this account
+	Auth *AuthConfig `yaml:"auth"`
 }
 
 // GetContainers returns the list of containers to scan for this storage account
@@ -58,6 +103,36 @@ func (s *StorageAccountConfig) ShouldScanAllContainers() bool {
 	return s.ScanAllContainers
 }
 
+// DiscoversContainers returns true if the containers to scan are found by
+// listing the account rather than configured by name
+func (s *StorageAccountConfig) DiscoversContainers() bool {
+	return s.ScanAllContainers || s.ContainerInclude != ""
+}
+
+// ContainerMatcher compiles the container include/exclude expressions into a
+// function reporting whether a listed container should be scanned
+func (s *StorageAccountConfig) ContainerMatcher() (func(name string) bool, error) {
+	var include, exclude *regexp.Regexp
+	var err error
+	if s.ContainerInclude != "" {
+		if include, err = regexp.Compile(s.ContainerInclude); err != nil {
+			return nil, fmt.Errorf("invalid container_include: %w", err)
+		}
+	}
+	if s.ContainerExclude != "" {
+		if exclude, err = regexp.Compile(s.ContainerExclude); err != nil {
+			return nil, fmt.Errorf("invalid container_exclude: %w", err)
+		}
+	}
+
+	return func(name string) bool {
+		if include != nil && !include.MatchString(name) {
+			return false
+		}
+		return exclude == nil || !exclude.MatchString(name)
+	}, nil
+}
+
 // GetServiceURL returns the Azure Blob service URL for this storage account
 func (s *StorageAccountConfig) GetServiceURL() string {
 	return fmt.Sprintf("https://%s.blob.core.windows.net/", s.Name)
@@ -65,59 +140,892 @@ func (s *StorageAccountConfig) GetServiceURL() string {
 
 // AzureConfig contains Azure Blob Storage settings
 type AzureConfig struct {
+	// Cloud and Region are read by the init container (scripts/init-cloud.sh)
+	// to install the cloud's CA certificates; toggle-vault doesn't use them
+	Cloud  string `yaml:"cloud"`
+	Region string `yaml:"region"`
+
 	// Multiple storage accounts (preferred)
 	StorageAccounts []StorageAccountConfig `yaml:"storage_accounts"`
 
 	// Legacy single storage account fields (for backward compatibility)
-	StorageAccount   string `yaml:"storage_account"`
+	StorageAccount string `yaml:"storage_account"`
+	Prefix         string `yaml:"prefix"`
+
+	// Authentication shared by all storage accounts without their own auth
+	AuthConfig `yaml:",inline"`
+
+	// Legacy container scoping (for backward compatibility with single account)
+	ScanAllContainers bool     `yaml:"scan_all_containers"`
+	Containers        []string `yaml:"containers"`
+	Container         string   `yaml:"container"`
+
+	// Discovery registers storage accounts found through Azure Resource
+	// Manager, in addition to StorageAccounts
+	Discovery []DiscoveryConfig `yaml:"discovery"`
+	// DiscoveryInterval is how often discovery looks for new accounts
+	DiscoveryInterval time.Duration `yaml:"discovery_interval"`
+
+	// AccountAliases maps the old names of renamed storage accounts to their
+	// new names. History recorded under an old name is moved to the new one
+	// at startup.
+	AccountAliases map[string]string `yaml:"account_aliases"`
+
+	Endpoints EndpointsConfig `yaml:"endpoints"`
+}
+
+// EndpointsConfig overrides cloud-specific endpoints
+type EndpointsConfig struct {
+	// Management is the Azure Resource Manager endpoint used by discovery
+	Management string `yaml:"management"`
+}
+
+// DiscoveryConfig finds the storage accounts in a subscription, or one of its
+// resource groups, whose tags match. The embedded account settings (container
+// scope, prefix, auth) apply to every account found; Name is ignored.
+type DiscoveryConfig struct {
+	StorageAccountConfig `yaml:",inline"`
+
+	// Tags must all be present on an account. An empty value matches any value.
+	Tags map[string]string `yaml:"tags"`
+}
+
+// MatchesTags reports whether an account's tags satisfy the discovery tags
+func (d *DiscoveryConfig) MatchesTags(tags map[string]string) bool {
+	for key, want := range d.Tags {
+		got, ok := tags[key]
+		if !ok || (want != "" && got != want) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsTokenCredential reports whether an auth method authenticates with
+// Microsoft Entra ID tokens, as Azure Resource Manager requires
+func IsTokenCredential(method string) bool {
+	switch method {
+	case AuthManagedIdentity, AuthWorkloadIdentity, AuthServicePrincipal, AuthServicePrincipalCertificate:
+		return true
+	}
+	return false
+}
+
+// Authentication methods
+const (
+	AuthConnectionString            = "connection_string"
+	AuthSASToken                    = "sas_token"
+	AuthAccountKey                  = "account_key"
+	AuthManagedIdentity             = "managed_identity"
+	AuthWorkloadIdentity            = "workload_identity"
+	AuthServicePrincipal            = "service_principal"
+	AuthServicePrincipalCertificate = "service_principal_certificate"
+)
+
+// AuthConfig contains the credentials used to access a storage account
+type AuthConfig struct {
+	// Method selects the auth method explicitly. When empty it is inferred
+	// from the fields that are set.
+	Method string `yaml:"auth_method"`
+
 	ConnectionString string `yaml:"connection_string"`
 	SASToken         string `yaml:"sas_token"`
-	Prefix           string `yaml:"prefix"`
+
+	// AccountKey is a storage account access key, used with shared key auth
+	AccountKey string `yaml:"account_key"`
 
 	// For service principal auth
 	TenantID     string `yaml:"tenant_id"`
 	ClientID     string `yaml:"client_id"`
 	ClientSecret string `yaml:"client_secret"`
 
+	// For service principal auth with a certificate instead of a secret.
+	// The file is PEM or PFX and must contain the private key.
+	ClientCertificatePath     string `yaml:"client_certificate_path"`
+	ClientCertificatePassword string `yaml:"client_certificate_password"`
+
 	// Use managed identity
 	UseManagedIdentity bool `yaml:"use_managed_identity"`
 
-	// Legacy container scoping (for backward compatibility with single account)
-	ScanAllContainers bool     `yaml:"scan_all_containers"`
-	Containers        []string `yaml:"containers"`
-	Container         string   `yaml:"container"`
+	// Use workload identity federation (e.g. AKS workload identity). TenantID,
+	// ClientID and FederatedTokenFile default to the AZURE_TENANT_ID,
+	// AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE variables set by the
+	// workload identity webhook.
+	UseWorkloadIdentity bool   `yaml:"use_workload_identity"`
+	FederatedTokenFile  string `yaml:"federated_token_file"`
 }
 
 // SyncConfig contains sync settings
 type SyncConfig struct {
 	Interval time.Duration `yaml:"interval"`
 	Patterns []string      `yaml:"patterns"`
+	// DryRun lists and diffs blobs but only records what would have been
+	// stored in the dry-run report, leaving the version history untouched
+	DryRun bool `yaml:"dry_run"`
+	// BackfillMetadataOnly tracks files found by the first sync without
+	// downloading them; content is fetched when a file is first viewed or changes
+	BackfillMetadataOnly bool `yaml:"backfill_metadata_only"`
+	// LazyPatterns select files (among those matching Patterns) whose versions
+	// are recorded by hash only; content is downloaded on first view, diff or
+	// restore and cached from then on
+	LazyPatterns []string `yaml:"lazy_patterns"`
+	// MaxContentSize is the largest content (in bytes) stored in full. Larger
+	// files keep only an excerpt of that size plus the full hash. 0 means no limit.
+	MaxContentSize int64 `yaml:"max_content_size"`
+	// Snapshots creates an Azure blob snapshot of every captured version.
+	// Restores and content retrieval of truncated or hash-only versions then
+	// read from the snapshot.
+	Snapshots bool `yaml:"snapshots"`
+	// SnapshotOnly stores versions with a snapshot by hash only, keeping the
+	// content in blob storage instead of the database (requires Snapshots)
+	SnapshotOnly bool `yaml:"snapshot_only"`
+	// WriteBatchSize is how many captured blobs the backfill writes per
+	// transaction. 1 writes each blob on its own.
+	WriteBatchSize int `yaml:"write_batch_size"`
+	// IgnoreSOPSMetadata records a new version of a SOPS-encrypted file only
+	// if its settings changed, not when only the sops metadata section (MAC,
+	// last-modified date) did. With an encryption key the decrypted settings
+	// are compared; without one SOPS files are stored with their ciphertext so
+	// that it can be compared.
+	IgnoreSOPSMetadata bool `yaml:"ignore_sops_metadata"`
+	// StaleAfter is how long a storage account can go without a successful
+	// listing before its history is reported as stale. Defaults to three
+	// sync intervals.
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// RetryInterval is how often the retry queue is checked between cycles.
+	// Each blob in it is retried after a backoff that starts at this interval
+	// and doubles with each failure, up to the sync interval.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// FileQuota and TotalQuota limit the bytes of content stored for the
+	// versions of one file and of all files. Past a quota the content of the
+	// oldest versions is dropped, never that of the latest of a file; a
+	// warning is logged from 80% of it. 0 means unlimited.
+	FileQuota  int64 `yaml:"file_quota"`
+	TotalQuota int64 `yaml:"total_quota"`
+	// PatternGroups track more files, or the files of Patterns, with their
+	// own settings
+	PatternGroups PatternGroups `yaml:"pattern_groups"`
+}
+
+// Content capture modes of a pattern group
+const (
+	CaptureFull     = "full"
+	CaptureHash     = "hash"
+	CaptureMetadata = "metadata"
+)
+
+// PatternGroup gives the files matching its patterns their own settings.
+// Files matching a group are tracked even if they don't match sync.patterns.
+type PatternGroup struct {
+	Name     string   `yaml:"name"`
+	Patterns []string `yaml:"patterns"`
+	// Capture is how versions are recorded: "full" stores the content,
+	// "hash" stores only its hash and fetches the content on first use, like
+	// lazy_patterns, and "metadata" tracks the file's ETag and modification
+	// time without downloading it or recording versions. Empty uses the sync
+	// settings.
+	Capture string `yaml:"capture"`
+	// Retention is how long versions are kept. Older versions are deleted,
+	// except the latest version of each file. 0 keeps them all.
+	Retention time.Duration `yaml:"retention"`
+	// Coalesce keeps at most one version per window of this length for each
+	// file, the latest: a change recorded in the same window as the previous
+	// version replaces it. Only modifications captured by the sync are
+	// coalesced; creations, deletions and edits with an author are kept.
+	// 0 keeps every version.
+	Coalesce time.Duration `yaml:"coalesce"`
+	// Hooks are the names of the hooks that check and transform the group's
+	// versions, such as a schema validation command. Empty runs every hook.
+	Hooks []string `yaml:"hooks"`
+	// Notifiers are the names of the configured notifiers that receive
+	// notifications about the group's files. Empty sends them to every
+	// notifier.
+	Notifiers []string `yaml:"notifiers"`
+}
+
+// PatternGroups are matched in order; a file takes the settings of the
+// first group it matches
+type PatternGroups []PatternGroup
+
+// Find returns the first group whose patterns match the file name of a blob
+// path, or nil if none do. Patterns are matched like sync.patterns.
+func (g PatternGroups) Find(blobPath string) *PatternGroup {
+	name := filepath.Base(blobPath)
+	for i := range g {
+		for _, pattern := range g[i].Patterns {
+			if matched, err := filepath.Match(pattern, name); err == nil && matched {
+				return &g[i]
+			}
+		}
+	}
+	return nil
+}
+
+// HasRetention reports whether any group deletes old versions
+func (g PatternGroups) HasRetention() bool {
+	for _, group := range g {
+		if group.Retention > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasQuota reports whether the stored content of versions is limited
+func (s *SyncConfig) HasQuota() bool {
+	return s.FileQuota > 0 || s.TotalQuota > 0
+}
+
+// TrackedPatterns returns the patterns of the blobs to track: Patterns and
+// the patterns of the pattern groups
+func (s *SyncConfig) TrackedPatterns() []string {
+	patterns := append([]string(nil), s.Patterns...)
+	for _, group := range s.PatternGroups {
+		patterns = append(patterns, group.Patterns...)
+	}
+	return patterns
 }
 
 // DatabaseConfig contains database settings
 type DatabaseConfig struct {
 	Path string `yaml:"path"`
+	// AppendOnly stops recorded versions and proposals from ever being deleted
+	// or changed (WORM retention). Once enabled it can't be turned off for
+	// the database.
+	AppendOnly bool `yaml:"append_only"`
+	// Replica is a path, as storageaccount/container/path, that copies of the
+	// database and its shipped write-ahead log are written under. A missing
+	// database file is restored from it on start.
+	Replica string `yaml:"replica"`
+	// ReplicaInterval is how often the log is checked for transactions to
+	// ship (default 1s)
+	ReplicaInterval time.Duration `yaml:"replica_interval"`
 }
 
+// Access log formats
+const (
+	AccessLogText = "text"
+	AccessLogJSON = "json"
+	AccessLogOff  = "off"
+)
+
+// DefaultUserHeader is the request header carrying the caller's identity
+// unless server.user_header is set
+const DefaultUserHeader = "X-Toggle-Vault-User"
+
 // ServerConfig contains HTTP server settings
 type ServerConfig struct {
 	Port int    `yaml:"port"`
 	Host string `yaml:"host"`
+	// UserHeader is the request header carrying the caller's identity when
+	// it comes from one of TrustedProxies. Behind an SSO proxy set it to the
+	// header the proxy sets (e.g. X-Forwarded-Email); it defaults to
+	// X-Toggle-Vault-User.
+	UserHeader string `yaml:"user_header"`
+	// TrustedProxies are the addresses or CIDRs of the SSO proxies whose
+	// UserHeader is believed. The header is ignored on requests from anywhere
+	// else, so that clients can't claim to be someone else. "unix" trusts
+	// proxies connecting over the Unix socket.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// APIKeys identify callers by the key they send in the X-API-Key header,
+	// for clients that don't go through an SSO proxy
+	APIKeys []APIKey `yaml:"api_keys"`
+	// AdminToken is the bearer token required for admin and debug endpoints.
+	// Admin endpoints are disabled when it is empty.
+	AdminToken string `yaml:"admin_token"`
+	// Pprof exposes Go profiling endpoints under /debug/pprof (admin only)
+	Pprof bool `yaml:"pprof"`
+	// BasePath serves the UI and API under a path prefix, such as
+	// /toggle-vault behind a reverse proxy. Requests without the prefix, from
+	// a proxy that strips it, are served too.
+	BasePath string `yaml:"base_path"`
+	// Socket is the path of a Unix domain socket to listen on instead of
+	// Host and Port. Both are ignored when systemd passes a listening socket.
+	Socket string `yaml:"socket"`
+	// SocketMode is the octal file mode of Socket, such as "0660" to let a
+	// reverse proxy in the same group connect. Defaults to the umask.
+	SocketMode string `yaml:"socket_mode"`
+	// APISunset is when the deprecated unversioned /api paths will be
+	// removed, announced to their callers in a Sunset header if set
+	APISunset time.Time `yaml:"api_sunset"`
+	// AccessLog is the format of the request log: text (key=value pairs, the
+	// default), json, or off
+	AccessLog string `yaml:"access_log"`
+	// AllowedCIDRs are the networks requests are accepted from. Requests from
+	// other addresses are refused with 403. Empty allows any address.
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+	// AdminAddress is a separate host:port serving the admin endpoints, such
+	// as on the management network's interface. When set, admin endpoints
+	// are no longer served on the main address.
+	AdminAddress string `yaml:"admin_address"`
+	// AdminWrites also restricts the requests that change files, such as
+	// restores, edits, archiving and syncs, to the admin address
+	AdminWrites bool `yaml:"admin_writes"`
+	// Diff limits the diffs of versions the API computes
+	Diff DiffConfig `yaml:"diff"`
+	// IdempotencyTTL is how long the response to a request with an
+	// Idempotency-Key header is kept to answer its retries. Defaults to 24h.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl"`
+}
+
+// APIKey identifies the callers that send Key as User
+type APIKey struct {
+	User string `yaml:"user"`
+	Key  string `yaml:"key"`
+}
+
+// minAPIKeyLength is the shortest API key accepted, so that keys can't be
+// guessed
+const minAPIKeyLength = 16
+
+// DiffConfig limits the diffs of versions the API computes, by the combined
+// size of the two versions in bytes
+type DiffConfig struct {
+	// MaxSize is the largest diff computed. Larger ones are refused with
+	// links to download the versions instead.
+	MaxSize int64 `yaml:"max_size"`
+	// BackgroundSize is the size above which diffs are computed in the
+	// background and kept for their next request, rather than while the
+	// request waits
+	BackgroundSize int64 `yaml:"background_size"`
+	// CacheSize is how many diffs computed in the background are kept
+	CacheSize int `yaml:"cache_size"`
+}
+
+// AllowedNetworks returns AllowedCIDRs parsed. A bare address is a network
+// of that address alone.
+func (s ServerConfig) AllowedNetworks() ([]*net.IPNet, error) {
+	return parseNetworks("server.allowed_cidrs", s.AllowedCIDRs)
+}
+
+// TrustedProxyNetworks returns TrustedProxies parsed, and whether proxies
+// connecting over the Unix socket are trusted
+func (s ServerConfig) TrustedProxyNetworks() (networks []*net.IPNet, socket bool, err error) {
+	var cidrs []string
+	for _, proxy := range s.TrustedProxies {
+		if proxy == "unix" {
+			socket = true
+			continue
+		}
+		cidrs = append(cidrs, proxy)
+	}
+	networks, err = parseNetworks("server.trusted_proxies", cidrs)
+	return networks, socket, err
+}
+
+// parseNetworks parses the addresses and CIDRs of a setting. A bare address
+// is a network of that address alone.
+func parseNetworks(field string, cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for i, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%s[%d]: %q is not an address or CIDR", field, i, cidr)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %q is not an address or CIDR", field, i, cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// SocketFileMode returns SocketMode parsed, or 0 if it is not set
+func (s ServerConfig) SocketFileMode() (os.FileMode, error) {
+	if s.SocketMode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("server.socket_mode: %q is not an octal file mode", s.SocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Hook stages
+const (
+	HookStagePreStore  = "pre_store"
+	HookStagePostStore = "post_store"
+)
+
+// HookConfig configures an external command run for every captured version
+type HookConfig struct {
+	Name    string        `yaml:"name"`
+	Command string        `yaml:"command"`
+	Args    []string      `yaml:"args"`
+	Stage   string        `yaml:"stage"` // pre_store or post_store
+	Timeout time.Duration `yaml:"timeout"`
+	// Transform replaces the version content with the command's stdout (pre_store only)
+	Transform bool `yaml:"transform"`
+}
+
+// Notifier types
+const (
+	NotifierTypeCommand   = "command"
+	NotifierTypePagerDuty = "pagerduty"
+	NotifierTypeOpsgenie  = "opsgenie"
+	NotifierTypeGrafana   = "grafana"
+)
+
+// NotifierConfig configures a destination for change notifications
+type NotifierConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+
+	// Optional filters. Events defaults to ["change"]; add "validation_failed"
+	// to be notified when a hook rejects a version. Empty filters match all.
+	Events       []string `yaml:"events"`
+	ChangeTypes  []string `yaml:"change_types"`
+	PathPrefixes []string `yaml:"path_prefixes"`
+
+	// Command notifier settings
+	Command string        `yaml:"command"`
+	Args    []string      `yaml:"args"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Alerting settings (pagerduty, opsgenie)
+	RoutingKey string `yaml:"routing_key"` // PagerDuty integration key
+	APIKey     string `yaml:"api_key"`     // Opsgenie API key
+	URL        string `yaml:"url"`         // Overrides the public API endpoint
+	Severity   string `yaml:"severity"`    // PagerDuty severity or Opsgenie priority
+
+	// Grafana annotation settings. URL is the Grafana address and APIKey a
+	// service account token. Dashboards are the UIDs of the dashboards
+	// annotated; without them annotations are organization-wide.
+	Dashboards []string `yaml:"dashboards"`
+	Tags       []string `yaml:"tags"`
+}
+
+// EmailConfig contains SMTP settings for e-mail subscriptions
+type EmailConfig struct {
+	SMTPHost string `yaml:"smtp_host"`
+	SMTPPort int    `yaml:"smtp_port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	// BaseURL is the externally reachable UI address used for links in e-mails
+	BaseURL string `yaml:"base_url"`
+}
+
+// Enabled returns true if e-mail delivery is configured
+func (e *EmailConfig) Enabled() bool {
+	return e.SMTPHost != ""
+}
+
+// ApprovalConfig controls which changes made through the vault need a
+// second user's approval before they are written to blob storage
+type ApprovalConfig struct {
+	// Required turns edits and restores into proposals that must be approved
+	Required bool `yaml:"required"`
+	// PathPrefixes limits approval to files under these prefixes; empty means all files
+	PathPrefixes []string `yaml:"path_prefixes"`
+	// GitHub reviews proposals for some paths as pull requests instead
+	GitHub GitHubConfig `yaml:"github"`
+}
+
+// ApplicationConfig is a named set of files, possibly across storage
+// accounts, that together configure one application
+type ApplicationConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Paths are full blob paths or prefixes of them (storageaccount/container/path)
+	Paths []string `yaml:"paths"`
+}
+
+// Matches reports whether blobPath belongs to the application
+func (a *ApplicationConfig) Matches(blobPath string) bool {
+	return len(a.Paths) > 0 && hasAnyPrefix(blobPath, a.Paths)
+}
+
+// LabelRuleConfig labels every file under a path prefix
+type LabelRuleConfig struct {
+	PathPrefix string            `yaml:"path_prefix"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+// changeTypePattern matches the names of custom change types
+var changeTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// labelKeyPattern restricts label keys to names that are safe in query
+// parameters and config files
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,62}$`)
+
+// ValidateLabel checks a label key and value
+func ValidateLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key %q (letters, digits, '.', '_', '-' and '/', up to 63 characters)", key)
+	}
+	if len(value) > 256 || strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid value for label %q (a single line of up to 256 characters)", key)
+	}
+	return nil
+}
+
+// LabelsFor returns the labels the label rules derive for blobPath. Rules
+// apply in order, so later rules override earlier ones.
+func (c *Config) LabelsFor(blobPath string) map[string]string {
+	labels := make(map[string]string)
+	for _, rule := range c.Labels {
+		if !strings.HasPrefix(blobPath, rule.PathPrefix) {
+			continue
+		}
+		for key, value := range rule.Labels {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// IntegrityConfig holds the key versions are signed with. The key is set
+// directly (use ${ENV} to keep it out of the file) or read from an Azure Key
+// Vault secret at startup.
+type IntegrityConfig struct {
+	SigningKey string `yaml:"signing_key"`
+	// KeyVaultSecret is the URL of a Key Vault secret holding the key, e.g.
+	// https://myvault.vault.azure.net/secrets/toggle-vault-signing. It is read
+	// with the azure auth settings, which must use Entra ID.
+	KeyVaultSecret string `yaml:"key_vault_secret"`
+	// KeyID is recorded with each signature so the key can be rotated
+	KeyID string `yaml:"key_id"`
+}
+
+// Enabled reports whether versions are signed
+func (c *IntegrityConfig) Enabled() bool {
+	return c.SigningKey != "" || c.KeyVaultSecret != ""
+}
+
+// EncryptionConfig holds the key SOPS- and age-encrypted files are decrypted
+// with to compare their versions. Decryption runs the sops and age commands
+// and only the ciphertext is stored. Without a key, encrypted files are
+// tracked by hash only.
+type EncryptionConfig struct {
+	// AgeKeyFile is a file of age identities (private keys)
+	AgeKeyFile string `yaml:"age_key_file"`
+	// KeyVaultSecret is the URL of a Key Vault secret holding the age
+	// identities instead, read at startup with the azure auth settings
+	KeyVaultSecret string `yaml:"key_vault_secret"`
+	// SOPSCommand and AgeCommand default to sops and age on the PATH
+	SOPSCommand string        `yaml:"sops_command"`
+	AgeCommand  string        `yaml:"age_command"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// Enabled reports whether encrypted files are decrypted
+func (c *EncryptionConfig) Enabled() bool {
+	return c.AgeKeyFile != "" || c.KeyVaultSecret != ""
+}
+
+// ImpactConfig queries a Prometheus-compatible API for metrics at each
+// change and one window later, so versions show what the change did
+type ImpactConfig struct {
+	// URL is the query API's base URL, such as http://prometheus:9090 or the
+	// query endpoint of an Azure Monitor workspace. Measuring is off when it
+	// is empty.
+	URL string `yaml:"url"`
+	// BearerToken authenticates the queries, if set
+	BearerToken string `yaml:"bearer_token"`
+	// AzureAuth authenticates with an Entra ID token from the azure auth
+	// settings instead, as Azure Monitor managed Prometheus requires
+	AzureAuth bool `yaml:"azure_auth"`
+	// Window is how long after a change it is measured again (default 15m)
+	Window  time.Duration        `yaml:"window"`
+	Metrics []ImpactMetricConfig `yaml:"metrics"`
+}
+
+// MaintenanceConfig puts the service into maintenance mode, such as during a
+// storage account migration: syncing is paused and mutating API requests are
+// rejected, while reads are still served. It can be switched at runtime
+// through the admin API.
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Reason is told to callers whose requests are rejected
+	Reason string `yaml:"reason"`
+}
+
+// JobsConfig sets how long API operations, such as exports and bulk
+// operations, run in the background as jobs
+type JobsConfig struct {
+	// Workers is how many jobs run at once
+	Workers int `yaml:"workers"`
+	// QueueSize is how many jobs can wait for a worker; more are refused
+	QueueSize int `yaml:"queue_size"`
+	// Retention is how long finished jobs and their output are kept
+	Retention time.Duration `yaml:"retention"`
+	// CallbackHosts are the hosts a finished job can be sent to, such as
+	// ci.example.com or *.example.com. Callbacks are refused when empty.
+	CallbackHosts []string `yaml:"callback_hosts"`
+	// CallbackSecret signs callbacks with HMAC-SHA256, so receivers can
+	// check they came from the vault
+	CallbackSecret string `yaml:"callback_secret"`
+}
+
+// SharesConfig limits how long view tokens, links that share a file's
+// history or a diff with people who can't use the vault, stay valid
+type SharesConfig struct {
+	// DefaultTTL is how long a token is valid unless its creator says.
+	// Defaults to 24h.
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	// MaxTTL is the longest a token can be valid. Defaults to 7 days.
+	MaxTTL time.Duration `yaml:"max_ttl"`
+}
+
+// ReportsConfig sets the working hours of the team reports. Changes
+// captured outside them are counted as out-of-hours changes.
+type ReportsConfig struct {
+	// Timezone is the IANA time zone of the working hours and of the report
+	// periods, such as Europe/London (default UTC)
+	Timezone string `yaml:"timezone"`
+	// WorkdayStart and WorkdayEnd are the working hours as 15:04 (default
+	// 09:00 to 18:00)
+	WorkdayStart string `yaml:"workday_start"`
+	WorkdayEnd   string `yaml:"workday_end"`
+	// Workdays are the working days, as mon to sun (default mon to fri)
+	Workdays []string `yaml:"workdays"`
+}
+
+// WorkingHours are the parsed working hours of the team reports
+type WorkingHours struct {
+	Location *time.Location
+	// Start and End are offsets from midnight
+	Start, End time.Duration
+	Days       map[time.Weekday]bool
+}
+
+// weekdays maps the names of days in reports.workdays to weekdays
+var weekdays = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
 }
 
-// Load reads and parses the configuration file
-func Load(path string) (*Config, error) {
+// WorkingHours returns the working hours parsed
+func (c ReportsConfig) WorkingHours() (*WorkingHours, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("reports.timezone: %w", err)
+	}
+	start, err := parseClock(c.WorkdayStart)
+	if err != nil {
+		return nil, fmt.Errorf("reports.workday_start: %w", err)
+	}
+	end, err := parseClock(c.WorkdayEnd)
+	if err != nil {
+		return nil, fmt.Errorf("reports.workday_end: %w", err)
+	}
+	if end <= start {
+		return nil, fmt.Errorf("reports.workday_end must be after reports.workday_start")
+	}
+
+	days := make(map[time.Weekday]bool)
+	for _, name := range c.Workdays {
+		day, ok := weekdays[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("reports.workdays: unknown day %q (mon to sun)", name)
+		}
+		days[day] = true
+	}
+	return &WorkingHours{Location: loc, Start: start, End: end, Days: days}, nil
+}
+
+// parseClock parses a time of day as 15:04 into its offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// OutOfHours reports whether t is outside the working hours
+func (h *WorkingHours) OutOfHours(t time.Time) bool {
+	t = t.In(h.Location)
+	if !h.Days[t.Weekday()] {
+		return true
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	return sinceMidnight < h.Start || sinceMidnight >= h.End
+}
+
+// ImpactMetricConfig is a metric measured around changes
+type ImpactMetricConfig struct {
+	Name string `yaml:"name"`
+	// Query is a PromQL expression. $window is replaced by the window, as in
+	// rate(http_errors_total[$window]).
+	Query string `yaml:"query"`
+	// Unit is shown after the values, such as "%" or "ms"
+	Unit string `yaml:"unit"`
+	// PathPrefixes limits the metric to changes under these prefixes; empty
+	// means all files
+	PathPrefixes []string `yaml:"path_prefixes"`
+}
+
+// Enabled returns true if impact measuring is configured
+func (c *ImpactConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// MetricsFor returns the metrics measured for changes to blobPath
+func (c *ImpactConfig) MetricsFor(blobPath string) []ImpactMetricConfig {
+	var metrics []ImpactMetricConfig
+	for _, m := range c.Metrics {
+		if hasAnyPrefix(blobPath, m.PathPrefixes) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// OwnersConfig maps path prefixes to owners, CODEOWNERS-style. Owners are
+// team names or e-mail addresses.
+type OwnersConfig struct {
+	Rules []OwnerRuleConfig `yaml:"rules"`
+	// File is the full blob path of a tracked OWNERS file. Its rules are
+	// read from the latest captured version and apply after Rules.
+	File string `yaml:"file"`
+	// Teams maps team names to the e-mail addresses notified for them
+	Teams map[string][]string `yaml:"teams"`
+	// Notify e-mails the owners of a file when it changes (requires email)
+	Notify bool `yaml:"notify"`
+}
+
+// OwnerRuleConfig assigns owners to every file under a path prefix
+type OwnerRuleConfig struct {
+	PathPrefix string   `yaml:"path_prefix"`
+	Owners     []string `yaml:"owners"`
+}
+
+// RequiredFor reports whether changes to blobPath need approval
+func (a *ApprovalConfig) RequiredFor(blobPath string) bool {
+	if a.GitHub.Matches(blobPath) {
+		return true
+	}
+	return a.Required && hasAnyPrefix(blobPath, a.PathPrefixes)
+}
+
+// GitHubConfig turns proposals into pull requests on a Git repository that
+// mirrors the blobs. The change is written to blob storage when the pull
+// request is merged, and rejected when it is closed without merging.
+type GitHubConfig struct {
+	// Repo is the mirror repository as owner/name. The integration is
+	// disabled when it is empty.
+	Repo       string `yaml:"repo"`
+	Token      string `yaml:"token"`
+	BaseBranch string `yaml:"base_branch"`
+	// APIURL is the REST API endpoint, for GitHub Enterprise Server
+	APIURL string `yaml:"api_url"`
+	// RepoPrefix is the directory in the repository that holds the blobs,
+	// laid out as storageaccount/container/blobpath
+	RepoPrefix string `yaml:"repo_prefix"`
+	// PathPrefixes selects the blobs whose proposals become pull requests;
+	// empty means all files
+	PathPrefixes []string `yaml:"path_prefixes"`
+	// PollInterval is how often open pull requests are checked
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// Enabled returns true if the GitHub integration is configured
+func (g *GitHubConfig) Enabled() bool {
+	return g.Repo != ""
+}
+
+// Matches reports whether proposals for blobPath become pull requests
+func (g *GitHubConfig) Matches(blobPath string) bool {
+	return g.Enabled() && hasAnyPrefix(blobPath, g.PathPrefixes)
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes. An empty list
+// matches everything.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads and parses the configuration file. Settings in the file are
+// overridden by TOGGLE_VAULT_* environment variables (see EnvPrefix), which
+// are overridden in turn by set, key=value pairs such as sync.interval=10s.
+// Keys that don't match a setting are an *UnknownKeysError, so that a typo
+// doesn't silently leave a setting at its default.
+func Load(path string, set ...string) (*Config, error) {
+	cfg, _, err := load(path, set, true)
+	return cfg, err
+}
+
+// LoadLenient reads and parses the configuration file like Load, but ignores
+// keys that don't match a setting and returns them instead
+func LoadLenient(path string, set ...string) (*Config, []UnknownKey, error) {
+	return load(path, set, false)
+}
+
+// Default returns the configuration of an empty config file, with every
+// setting at its default. It isn't validated, as no storage account is set.
+func Default() *Config {
+	var cfg Config
+	cfg.applyDefaults()
+	return &cfg
+}
+
+// load reads and parses the configuration file and applies the overrides,
+// failing on unknown keys if strict is set
+func load(path string, set []string, strict bool) (*Config, []UnknownKey, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
 	// Expand environment variables in the config
 	expanded := os.ExpandEnv(string(data))
 
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(expanded), &root); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	// Check for unknown keys before decoding, as a misspelled key often
+	// leaves a required setting unset
+	configType := reflect.TypeOf(Config{})
+	unknown := unknownKeys(&root, configType, "")
+
+	// Environment variables first, so flags take precedence
+	flags, err := setOverrides(set)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, o := range append(envOverrides(os.Environ()), flags...) {
+		t, bad := settingType(configType, o.path)
+		if bad != nil {
+			// Only -set keys can be unknown, unmatched variables are ignored
+			bad.Source = "-set"
+			unknown = append(unknown, *bad)
+			continue
+		}
+		if err := applyOverride(&root, o, t); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if strict && len(unknown) > 0 {
+		return nil, nil, &UnknownKeysError{Keys: unknown}
+	}
+
 	var cfg Config
-	if err := yaml.Unmarshal([]byte(expanded), &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if root.Kind != 0 {
+		if err := root.Decode(&cfg); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
 	}
 
 	// Apply defaults
@@ -125,10 +1033,10 @@ func Load(path string) (*Config, error) {
 
 	// Validate configuration
 	if err := cfg.validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	return &cfg, nil
+	return &cfg, unknown, nil
 }
 
 // applyDefaults sets default values for unspecified config options
@@ -137,13 +1045,32 @@ func (c *Config) applyDefaults() {
 		c.Sync.Interval = 30 * time.Second
 	}
 
+	if c.Azure.DiscoveryInterval == 0 {
+		c.Azure.DiscoveryInterval = 10 * time.Minute
+	}
+	if c.Azure.Endpoints.Management == "" {
+		c.Azure.Endpoints.Management = "https://management.azure.com"
+	}
+
 	if len(c.Sync.Patterns) == 0 {
-		c.Sync.Patterns = []string{"*.yaml", "*.yml"}
+		c.Sync.Patterns = []string{"*.yaml", "*.yml", "*.toml", "*.ini"}
+	}
+	if c.Sync.WriteBatchSize == 0 {
+		c.Sync.WriteBatchSize = 500
+	}
+	if c.Sync.StaleAfter == 0 {
+		c.Sync.StaleAfter = 3 * c.Sync.Interval
+	}
+	if c.Sync.RetryInterval == 0 {
+		c.Sync.RetryInterval = 10 * time.Second
 	}
 
 	if c.Database.Path == "" {
 		c.Database.Path = "./toggle-vault.db"
 	}
+	if c.Database.Replica != "" && c.Database.ReplicaInterval == 0 {
+		c.Database.ReplicaInterval = time.Second
+	}
 
 	if c.Server.Port == 0 {
 		c.Server.Port = 8080
@@ -152,6 +1079,103 @@ func (c *Config) applyDefaults() {
 	if c.Server.Host == "" {
 		c.Server.Host = "0.0.0.0"
 	}
+
+	if c.Server.UserHeader == "" {
+		c.Server.UserHeader = DefaultUserHeader
+	}
+	if c.Server.AccessLog == "" {
+		c.Server.AccessLog = AccessLogText
+	}
+	if c.Server.Diff.MaxSize == 0 {
+		c.Server.Diff.MaxSize = 20 << 20
+	}
+	if c.Server.Diff.BackgroundSize == 0 {
+		c.Server.Diff.BackgroundSize = 1 << 20
+	}
+	if c.Server.Diff.CacheSize == 0 {
+		c.Server.Diff.CacheSize = 32
+	}
+	if c.Server.IdempotencyTTL == 0 {
+		c.Server.IdempotencyTTL = 24 * time.Hour
+	}
+
+	if c.Jobs.Workers == 0 {
+		c.Jobs.Workers = 2
+	}
+	if c.Jobs.QueueSize == 0 {
+		c.Jobs.QueueSize = 100
+	}
+	if c.Jobs.Retention == 0 {
+		c.Jobs.Retention = 24 * time.Hour
+	}
+
+	if c.Reports.Timezone == "" {
+		c.Reports.Timezone = "UTC"
+	}
+	if c.Reports.WorkdayStart == "" {
+		c.Reports.WorkdayStart = "09:00"
+	}
+	if c.Reports.WorkdayEnd == "" {
+		c.Reports.WorkdayEnd = "18:00"
+	}
+	if len(c.Reports.Workdays) == 0 {
+		c.Reports.Workdays = []string{"mon", "tue", "wed", "thu", "fri"}
+	}
+	if c.Shares.DefaultTTL == 0 {
+		c.Shares.DefaultTTL = 24 * time.Hour
+	}
+	if c.Shares.MaxTTL == 0 {
+		c.Shares.MaxTTL = 7 * 24 * time.Hour
+	}
+	if c.Server.BasePath = strings.Trim(c.Server.BasePath, "/"); c.Server.BasePath != "" {
+		c.Server.BasePath = "/" + c.Server.BasePath
+	}
+
+	if c.Approvals.GitHub.Enabled() {
+		if c.Approvals.GitHub.BaseBranch == "" {
+			c.Approvals.GitHub.BaseBranch = "main"
+		}
+		if c.Approvals.GitHub.APIURL == "" {
+			c.Approvals.GitHub.APIURL = "https://api.github.com"
+		}
+		if c.Approvals.GitHub.PollInterval == 0 {
+			c.Approvals.GitHub.PollInterval = time.Minute
+		}
+	}
+
+	if c.Integrity.Enabled() && c.Integrity.KeyID == "" {
+		c.Integrity.KeyID = "default"
+	}
+
+	if c.Encryption.SOPSCommand == "" {
+		c.Encryption.SOPSCommand = "sops"
+	}
+	if c.Encryption.AgeCommand == "" {
+		c.Encryption.AgeCommand = "age"
+	}
+
+	if c.Email.Enabled() && c.Email.SMTPPort == 0 {
+		c.Email.SMTPPort = 587
+	}
+
+	if c.Impact.Enabled() && c.Impact.Window == 0 {
+		c.Impact.Window = 15 * time.Minute
+	}
+
+	for i := range c.Notifiers {
+		if c.Notifiers[i].Name == "" {
+			c.Notifiers[i].Name = c.Notifiers[i].Type
+		}
+	}
+
+	for i := range c.Hooks {
+		if c.Hooks[i].Stage == "" {
+			c.Hooks[i].Stage = HookStagePostStore
+		}
+		if c.Hooks[i].Name == "" {
+			c.Hooks[i].Name = c.Hooks[i].Command
+		}
+	}
 }
 
 // validate checks that the configuration is valid
@@ -159,8 +1183,33 @@ func (c *Config) validate() error {
 	// Get all storage accounts (handles both new and legacy config)
 	accounts := c.Azure.GetStorageAccounts()
 
-	if len(accounts) == 0 {
-		return fmt.Errorf("at least one storage account is required: use storage_accounts or storage_account")
+	if len(accounts) == 0 && len(c.Azure.Discovery) == 0 {
+		return fmt.Errorf("at least one storage account is required: use storage_accounts, storage_account or discovery")
+	}
+
+	for i, d := range c.Azure.Discovery {
+		if d.SubscriptionID == "" {
+			return fmt.Errorf("azure.discovery[%d].subscription_id is required", i)
+		}
+		if !d.DiscoversContainers() && len(d.Containers) == 0 && d.Container == "" {
+			return fmt.Errorf("azure.discovery[%d]: container scope is required (set scan_all_containers, container_include, containers, or container)", i)
+		}
+		if _, err := d.ContainerMatcher(); err != nil {
+			return fmt.Errorf("azure.discovery[%d]: %w", i, err)
+		}
+		auth := c.Azure.AuthFor(d.StorageAccountConfig)
+		if method := auth.GetAuthMethod(); !IsTokenCredential(method) {
+			return fmt.Errorf("azure.discovery[%d]: auth method %q can't access Azure Resource Manager (use managed_identity, workload_identity or a service principal)", i, method)
+		}
+	}
+
+	for from, to := range c.Azure.AccountAliases {
+		if from == "" || to == "" || from == to || strings.Contains(from+to, "/") {
+			return fmt.Errorf("azure.account_aliases: invalid alias %q: %q", from, to)
+		}
+		if _, ok := c.Azure.AccountAliases[to]; ok {
+			return fmt.Errorf("azure.account_aliases: %s is renamed to %s, which is itself renamed", from, to)
+		}
 	}
 
 	// Validate each storage account
@@ -170,25 +1219,316 @@ func (c *Config) validate() error {
 		}
 
 		// Check that at least one container scoping method is configured
-		hasContainerScope := account.ScanAllContainers ||
+		hasContainerScope := account.DiscoversContainers() ||
 			len(account.Containers) > 0 ||
 			account.Container != ""
 
 		if !hasContainerScope {
-			return fmt.Errorf("storage account '%s': container scope is required (set scan_all_containers, containers, or container)", account.Name)
+			return fmt.Errorf("storage account '%s': container scope is required (set scan_all_containers, container_include, containers, or container)", account.Name)
 		}
+		if _, err := account.ContainerMatcher(); err != nil {
+			return fmt.Errorf("storage account '%s': %w", account.Name, err)
+		}
+
+		// Check that an auth method is configured, here or at the top level
+		auth := c.Azure.AuthFor(account)
+		switch method := auth.GetAuthMethod(); method {
+		case AuthConnectionString, AuthSASToken, AuthAccountKey, AuthManagedIdentity, AuthWorkloadIdentity,
+			AuthServicePrincipal, AuthServicePrincipalCertificate:
+		case "none":
+			return fmt.Errorf("storage account '%s': no Azure authentication method configured (connection_string, sas_token, account_key, managed_identity, workload_identity, or service principal with a secret or certificate)", account.Name)
+		default:
+			return fmt.Errorf("storage account '%s': auth_method %q is not supported", account.Name, method)
+		}
+	}
+
+	for i, hook := range c.Hooks {
+		if hook.Command == "" {
+			return fmt.Errorf("hooks[%d].command is required", i)
+		}
+		if hook.Stage != HookStagePreStore && hook.Stage != HookStagePostStore {
+			return fmt.Errorf("hooks[%d].stage must be %s or %s", i, HookStagePreStore, HookStagePostStore)
+		}
+		if hook.Transform && hook.Stage != HookStagePreStore {
+			return fmt.Errorf("hooks[%d].transform is only supported for %s hooks", i, HookStagePreStore)
+		}
+	}
+
+	if c.Sync.SnapshotOnly && !c.Sync.Snapshots {
+		return fmt.Errorf("sync.snapshot_only requires sync.snapshots")
+	}
+	if c.Sync.WriteBatchSize < 0 {
+		return fmt.Errorf("sync.write_batch_size must not be negative")
+	}
+	if c.Sync.StaleAfter < 0 {
+		return fmt.Errorf("sync.stale_after must not be negative")
+	}
+	if c.Sync.RetryInterval < 0 {
+		return fmt.Errorf("sync.retry_interval must not be negative")
+	}
+	if c.Sync.FileQuota < 0 {
+		return fmt.Errorf("sync.file_quota must not be negative")
+	}
+	if c.Sync.TotalQuota < 0 {
+		return fmt.Errorf("sync.total_quota must not be negative")
 	}
 
-	// Check that at least one auth method is configured
-	hasAuth := c.Azure.ConnectionString != "" ||
-		c.Azure.SASToken != "" ||
-		c.Azure.UseManagedIdentity ||
-		(c.Azure.TenantID != "" && c.Azure.ClientID != "" && c.Azure.ClientSecret != "")
+	if c.Database.Replica != "" {
+		parts := strings.SplitN(c.Database.Replica, "/", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			return fmt.Errorf("database.replica must be storageaccount/container/path")
+		}
+		// It is restored from before discovery runs, so the account must be listed
+		configured := false
+		for _, account := range accounts {
+			configured = configured || account.Name == parts[0]
+		}
+		if !configured {
+			return fmt.Errorf("database.replica: storage account %q is not configured", parts[0])
+		}
+		if c.Database.ReplicaInterval < time.Second {
+			return fmt.Errorf("database.replica_interval must be at least 1s")
+		}
+	}
 
-	if !hasAuth {
-		return fmt.Errorf("no Azure authentication method configured (connection_string, sas_token, managed_identity, or service principal)")
+	if c.Sync.HasQuota() && c.Database.AppendOnly {
+		return fmt.Errorf("sync quotas can't delete versions with database.append_only")
+	}
+	if err := c.validatePatternGroups(); err != nil {
+		return err
 	}
 
+	if _, err := c.Server.SocketFileMode(); err != nil {
+		return err
+	}
+	if _, err := c.Server.AllowedNetworks(); err != nil {
+		return err
+	}
+	if _, _, err := c.Server.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+	keys := make(map[string]bool)
+	for i, key := range c.Server.APIKeys {
+		if key.User == "" {
+			return fmt.Errorf("server.api_keys[%d].user is required", i)
+		}
+		if len(key.Key) < minAPIKeyLength {
+			return fmt.Errorf("server.api_keys[%d].key must be at least %d characters", i, minAPIKeyLength)
+		}
+		if keys[key.Key] {
+			return fmt.Errorf("server.api_keys[%d].key is used by another key", i)
+		}
+		keys[key.Key] = true
+	}
+	if c.Server.AdminAddress != "" {
+		if _, _, err := net.SplitHostPort(c.Server.AdminAddress); err != nil {
+			return fmt.Errorf("server.admin_address must be host:port: %w", err)
+		}
+		if c.Server.AdminToken == "" {
+			return fmt.Errorf("server.admin_address requires server.admin_token")
+		}
+	}
+	if c.Server.AdminWrites && c.Server.AdminAddress == "" {
+		return fmt.Errorf("server.admin_writes requires server.admin_address")
+	}
+	switch c.Server.AccessLog {
+	case AccessLogText, AccessLogJSON, AccessLogOff:
+	default:
+		return fmt.Errorf("server.access_log must be text, json or off")
+	}
+	if c.Server.Diff.MaxSize < 0 || c.Server.Diff.BackgroundSize < 0 || c.Server.Diff.CacheSize < 0 {
+		return fmt.Errorf("server.diff sizes must not be negative")
+	}
+	if c.Server.IdempotencyTTL < 0 {
+		return fmt.Errorf("server.idempotency_ttl must not be negative")
+	}
+	if c.Jobs.Workers < 0 || c.Jobs.QueueSize < 0 {
+		return fmt.Errorf("jobs.workers and jobs.queue_size must not be negative")
+	}
+	if c.Jobs.Retention < 0 {
+		return fmt.Errorf("jobs.retention must not be negative")
+	}
+	for i, host := range c.Jobs.CallbackHosts {
+		if _, err := filepath.Match(host, ""); err != nil || host == "" {
+			return fmt.Errorf("jobs.callback_hosts[%d]: invalid host pattern %q", i, host)
+		}
+	}
+	if _, err := c.Reports.WorkingHours(); err != nil {
+		return err
+	}
+	if c.Shares.DefaultTTL < 0 || c.Shares.MaxTTL < 0 {
+		return fmt.Errorf("shares.default_ttl and shares.max_ttl must not be negative")
+	}
+	if c.Shares.DefaultTTL > c.Shares.MaxTTL {
+		return fmt.Errorf("shares.default_ttl must not be longer than shares.max_ttl")
+	}
+
+	if c.Approvals.GitHub.Enabled() {
+		if strings.Count(c.Approvals.GitHub.Repo, "/") != 1 {
+			return fmt.Errorf("approvals.github.repo must be owner/name")
+		}
+		if c.Approvals.GitHub.Token == "" {
+			return fmt.Errorf("approvals.github.token is required when approvals.github.repo is set")
+		}
+	}
+
+	if c.Email.Enabled() && c.Email.From == "" {
+		return fmt.Errorf("email.from is required when email.smtp_host is set")
+	}
+
+	apps := make(map[string]bool)
+	for i, app := range c.Applications {
+		if app.Name == "" || strings.Contains(app.Name, "/") {
+			return fmt.Errorf("applications[%d].name is required and can't contain '/'", i)
+		}
+		if apps[app.Name] {
+			return fmt.Errorf("applications[%d].name %q is used more than once", i, app.Name)
+		}
+		apps[app.Name] = true
+		if len(app.Paths) == 0 {
+			return fmt.Errorf("applications[%d].paths is required", i)
+		}
+	}
+
+	for i, name := range c.ChangeTypes {
+		if !changeTypePattern.MatchString(name) {
+			return fmt.Errorf("change_types[%d] must be lowercase letters, digits, '_' and '-', up to 32 characters", i)
+		}
+	}
+
+	for i, rule := range c.Labels {
+		if len(rule.Labels) == 0 {
+			return fmt.Errorf("labels[%d].labels is required", i)
+		}
+		for key, value := range rule.Labels {
+			if err := ValidateLabel(key, value); err != nil {
+				return fmt.Errorf("labels[%d]: %w", i, err)
+			}
+		}
+	}
+
+	if c.Integrity.SigningKey != "" && c.Integrity.KeyVaultSecret != "" {
+		return fmt.Errorf("integrity.signing_key and integrity.key_vault_secret are mutually exclusive")
+	}
+	if c.Integrity.KeyVaultSecret != "" {
+		if method := c.Azure.GetAuthMethod(); !IsTokenCredential(method) {
+			return fmt.Errorf("integrity.key_vault_secret requires Entra ID auth (managed_identity, workload_identity or a service principal), not %q", method)
+		}
+	}
+
+	if c.Encryption.AgeKeyFile != "" && c.Encryption.KeyVaultSecret != "" {
+		return fmt.Errorf("encryption.age_key_file and encryption.key_vault_secret are mutually exclusive")
+	}
+	if c.Encryption.KeyVaultSecret != "" {
+		if method := c.Azure.GetAuthMethod(); !IsTokenCredential(method) {
+			return fmt.Errorf("encryption.key_vault_secret requires Entra ID auth (managed_identity, workload_identity or a service principal), not %q", method)
+		}
+	}
+
+	if c.Impact.Enabled() {
+		if len(c.Impact.Metrics) == 0 {
+			return fmt.Errorf("impact.metrics is required when impact.url is set")
+		}
+		if c.Impact.Window < time.Second {
+			return fmt.Errorf("impact.window must be at least 1s")
+		}
+		if c.Impact.BearerToken != "" && c.Impact.AzureAuth {
+			return fmt.Errorf("impact.bearer_token and impact.azure_auth are mutually exclusive")
+		}
+		if c.Impact.AzureAuth {
+			if method := c.Azure.GetAuthMethod(); !IsTokenCredential(method) {
+				return fmt.Errorf("impact.azure_auth requires Entra ID auth (managed_identity, workload_identity or a service principal), not %q", method)
+			}
+		}
+		metrics := make(map[string]bool)
+		for i, m := range c.Impact.Metrics {
+			if m.Name == "" || m.Query == "" {
+				return fmt.Errorf("impact.metrics[%d] requires name and query", i)
+			}
+			if metrics[m.Name] {
+				return fmt.Errorf("impact.metrics[%d].name %q is used more than once", i, m.Name)
+			}
+			metrics[m.Name] = true
+		}
+	}
+
+	for i, rule := range c.Owners.Rules {
+		if len(rule.Owners) == 0 {
+			return fmt.Errorf("owners.rules[%d].owners is required", i)
+		}
+	}
+	if c.Owners.Notify && !c.Email.Enabled() {
+		return fmt.Errorf("owners.notify requires email.smtp_host")
+	}
+
+	for i, notifier := range c.Notifiers {
+		switch notifier.Type {
+		case NotifierTypeCommand:
+			if notifier.Command == "" {
+				return fmt.Errorf("notifiers[%d].command is required for command notifiers", i)
+			}
+		case NotifierTypePagerDuty:
+			if notifier.RoutingKey == "" {
+				return fmt.Errorf("notifiers[%d].routing_key is required for pagerduty notifiers", i)
+			}
+		case NotifierTypeOpsgenie:
+			if notifier.APIKey == "" {
+				return fmt.Errorf("notifiers[%d].api_key is required for opsgenie notifiers", i)
+			}
+		case NotifierTypeGrafana:
+			if notifier.URL == "" || notifier.APIKey == "" {
+				return fmt.Errorf("notifiers[%d].url and api_key are required for grafana notifiers", i)
+			}
+		case "":
+			return fmt.Errorf("notifiers[%d].type is required", i)
+		default:
+			return fmt.Errorf("notifiers[%d].type %q is not supported", i, notifier.Type)
+		}
+	}
+
+	return nil
+}
+
+// validatePatternGroups checks the pattern groups. Hook names are checked
+// at startup, since compiled-in hooks aren't configured.
+func (c *Config) validatePatternGroups() error {
+	notifiers := make(map[string]bool)
+	for _, notifier := range c.Notifiers {
+		notifiers[notifier.Name] = true
+	}
+
+	for i, group := range c.Sync.PatternGroups {
+		if len(group.Patterns) == 0 {
+			return fmt.Errorf("sync.pattern_groups[%d].patterns is required", i)
+		}
+		for _, pattern := range group.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("sync.pattern_groups[%d]: invalid pattern %q", i, pattern)
+			}
+		}
+		switch group.Capture {
+		case "", CaptureFull, CaptureHash, CaptureMetadata:
+		default:
+			return fmt.Errorf("sync.pattern_groups[%d].capture must be %s, %s or %s", i, CaptureFull, CaptureHash, CaptureMetadata)
+		}
+		if group.Retention < 0 {
+			return fmt.Errorf("sync.pattern_groups[%d].retention must not be negative", i)
+		}
+		if group.Retention > 0 && c.Database.AppendOnly {
+			return fmt.Errorf("sync.pattern_groups[%d].retention can't delete versions with database.append_only", i)
+		}
+		if group.Coalesce < 0 {
+			return fmt.Errorf("sync.pattern_groups[%d].coalesce must not be negative", i)
+		}
+		if group.Coalesce > 0 && c.Database.AppendOnly {
+			return fmt.Errorf("sync.pattern_groups[%d].coalesce can't replace versions with database.append_only", i)
+		}
+		for _, name := range group.Notifiers {
+			if !notifiers[name] {
+				return fmt.Errorf("sync.pattern_groups[%d]: no notifier named %q", i, name)
+			}
+		}
+	}
 	return nil
 }
 
@@ -237,42 +1577,114 @@ func (c *AzureConfig) ShouldScanAllContainers() bool {
 }
 
 // UnmarshalYAML implements custom unmarshaling for SyncConfig to handle duration
-func (s *SyncConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+func (s *SyncConfig) UnmarshalYAML(value *yaml.Node) error {
 	type rawSyncConfig struct {
 		Interval string   `yaml:"interval"`
 		Patterns []string `yaml:"patterns"`
+		DryRun   bool     `yaml:"dry_run"`
+
+		BackfillMetadataOnly bool     `yaml:"backfill_metadata_only"`
+		LazyPatterns         []string `yaml:"lazy_patterns"`
+		MaxContentSize       int64    `yaml:"max_content_size"`
+		Snapshots            bool     `yaml:"snapshots"`
+		SnapshotOnly         bool     `yaml:"snapshot_only"`
+		WriteBatchSize       int      `yaml:"write_batch_size"`
+		IgnoreSOPSMetadata   bool     `yaml:"ignore_sops_metadata"`
+		StaleAfter           string   `yaml:"stale_after"`
+		RetryInterval        string   `yaml:"retry_interval"`
+		FileQuota            int64    `yaml:"file_quota"`
+		TotalQuota           int64    `yaml:"total_quota"`
+
+		PatternGroups PatternGroups `yaml:"pattern_groups"`
 	}
 
 	var raw rawSyncConfig
-	if err := unmarshal(&raw); err != nil {
+	if err := value.Decode(&raw); err != nil {
 		return err
 	}
 
 	if raw.Interval != "" {
 		duration, err := time.ParseDuration(raw.Interval)
 		if err != nil {
-			return fmt.Errorf("invalid sync interval: %w", err)
+			return fmt.Errorf("line %d: invalid sync interval: %w", keyLine(value, "interval"), err)
 		}
 		s.Interval = duration
 	}
+	if raw.StaleAfter != "" {
+		duration, err := time.ParseDuration(raw.StaleAfter)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid sync stale_after: %w", keyLine(value, "stale_after"), err)
+		}
+		s.StaleAfter = duration
+	}
+	if raw.RetryInterval != "" {
+		duration, err := time.ParseDuration(raw.RetryInterval)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid sync retry_interval: %w", keyLine(value, "retry_interval"), err)
+		}
+		s.RetryInterval = duration
+	}
 
 	s.Patterns = raw.Patterns
+	s.DryRun = raw.DryRun
+	s.BackfillMetadataOnly = raw.BackfillMetadataOnly
+	s.LazyPatterns = raw.LazyPatterns
+	s.MaxContentSize = raw.MaxContentSize
+	s.Snapshots = raw.Snapshots
+	s.SnapshotOnly = raw.SnapshotOnly
+	s.WriteBatchSize = raw.WriteBatchSize
+	s.IgnoreSOPSMetadata = raw.IgnoreSOPSMetadata
+	s.FileQuota = raw.FileQuota
+	s.TotalQuota = raw.TotalQuota
+	s.PatternGroups = raw.PatternGroups
 	return nil
 }
 
+// keyLine returns the line of a key in a mapping node, or of the node itself
+// if it doesn't have the key
+func keyLine(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i].Line
+		}
+	}
+	return node.Line
+}
+
+// AuthFor returns the authentication used for a storage account: its own
+// auth section if it has one, otherwise the top-level settings
+func (c *AzureConfig) AuthFor(account StorageAccountConfig) AuthConfig {
+	if account.Auth != nil {
+		return *account.Auth
+	}
+	return c.AuthConfig
+}
+
 // GetAuthMethod returns a string describing the configured auth method
-func (c *AzureConfig) GetAuthMethod() string {
+func (c *AuthConfig) GetAuthMethod() string {
+	if c.Method != "" {
+		return c.Method
+	}
 	if c.ConnectionString != "" {
-		return "connection_string"
+		return AuthConnectionString
 	}
 	if c.SASToken != "" {
-		return "sas_token"
+		return AuthSASToken
+	}
+	if c.AccountKey != "" {
+		return AuthAccountKey
+	}
+	if c.UseWorkloadIdentity || c.FederatedTokenFile != "" {
+		return AuthWorkloadIdentity
 	}
 	if c.UseManagedIdentity {
-		return "managed_identity"
+		return AuthManagedIdentity
+	}
+	if c.TenantID != "" && c.ClientID != "" && c.ClientCertificatePath != "" {
+		return AuthServicePrincipalCertificate
 	}
 	if c.TenantID != "" && c.ClientID != "" && c.ClientSecret != "" {
-		return "service_principal"
+		return AuthServicePrincipal
 	}
 	return "none"
 }
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	cfg := Default()
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "idempotency TTL", got: cfg.Server.IdempotencyTTL, want: 24 * time.Hour},
+		{name: "share default TTL", got: cfg.Shares.DefaultTTL, want: 24 * time.Hour},
+		{name: "share max TTL", got: cfg.Shares.MaxTTL, want: 7 * 24 * time.Hour},
+		{name: "diff max size", got: cfg.Server.Diff.MaxSize, want: int64(20 << 20)},
+		{name: "user header", got: cfg.Server.UserHeader, want: DefaultUserHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
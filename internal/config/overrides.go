@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings: the setting's key path in upper case, with underscores for dots,
+// such as TOGGLE_VAULT_SYNC_INTERVAL for sync.interval
+const EnvPrefix = "TOGGLE_VAULT_"
+
+// override is a setting given outside the config file
+type override struct {
+	// source names the override in messages: the environment variable or
+	// the -set flag
+	source string
+	// path is the setting's key path; list indexes are elements like "[0]"
+	path  []string
+	value string
+}
+
+// envOverrides returns the settings overridden by environment variables, in
+// "KEY=value" form. Variables with the prefix that don't name a setting are
+// ignored, as they are also used for ${} references in the config file.
+func envOverrides(environ []string) []override {
+	settings := make(map[string][]string)
+	envSettings(reflect.TypeOf(Config{}), EnvPrefix, nil, settings)
+
+	var overrides []override
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if path, ok := settings[name]; ok {
+			overrides = append(overrides, override{source: name, path: path, value: value})
+		}
+	}
+	return overrides
+}
+
+// envSettings adds the environment variable names of the settings in a
+// struct type to settings, mapped to their key paths
+func envSettings(t reflect.Type, prefix string, path []string, settings map[string][]string) {
+	for key, ft := range yamlFields(t) {
+		name := prefix + strings.ToUpper(key)
+		fieldPath := append(append([]string(nil), path...), key)
+		settings[name] = fieldPath
+
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			envSettings(ft, name+"_", fieldPath, settings)
+		}
+	}
+}
+
+// setOverrides parses the key=value settings of -set flags
+func setOverrides(set []string) ([]override, error) {
+	overrides := make([]override, 0, len(set))
+	for _, kv := range set {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -set %q, expected key=value", kv)
+		}
+		path, err := parseKeyPath(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -set %q: %w", kv, err)
+		}
+		overrides = append(overrides, override{source: "-set " + key, path: path, value: value})
+	}
+	return overrides, nil
+}
+
+// parseKeyPath splits a key path such as azure.storage_accounts[0].container
+// into its elements
+func parseKeyPath(key string) ([]string, error) {
+	var path []string
+	for _, part := range strings.Split(key, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" {
+			return nil, fmt.Errorf("empty key in %q", key)
+		}
+		path = append(path, name)
+		for rest != "" {
+			index, after, ok := strings.Cut(rest, "]")
+			if _, err := strconv.Atoi(index); !ok || err != nil {
+				return nil, fmt.Errorf("invalid list index in %q", key)
+			}
+			path = append(path, "["+index+"]")
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return path, nil
+}
+
+// listIndex returns the index of a list index path element
+func listIndex(elem string) (int, bool) {
+	if !strings.HasPrefix(elem, "[") {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.Trim(elem, "[]"))
+	return i, err == nil
+}
+
+// formatKeyPath joins key path elements back into a key path
+func formatKeyPath(path []string) string {
+	var b strings.Builder
+	for _, elem := range path {
+		if b.Len() > 0 && !strings.HasPrefix(elem, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(elem)
+	}
+	return b.String()
+}
+
+// settingType returns the type of the setting at path below t, or an
+// UnknownKey if there is no such setting
+func settingType(t reflect.Type, path []string) (reflect.Type, *UnknownKey) {
+	for i, elem := range path {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if _, ok := listIndex(elem); ok {
+			if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+				return nil, &UnknownKey{Key: formatKeyPath(path)}
+			}
+			t = t.Elem()
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			fields := yamlFields(t)
+			field, ok := fields[elem]
+			if !ok {
+				return nil, &UnknownKey{Key: formatKeyPath(path), Suggestion: suggestKey(elem, fields, formatKeyPath(path[:i]))}
+			}
+			t = field
+		default:
+			return nil, &UnknownKey{Key: formatKeyPath(path)}
+		}
+	}
+	return t, nil
+}
+
+// applyOverride sets a setting of type t in the parsed config file, adding
+// the keys leading to it if the file doesn't have them. Values of string
+// settings are taken as they are; others are parsed as YAML, so lists and
+// maps can be given as ["a", "b"] and {a: b}.
+func applyOverride(doc *yaml.Node, o override, t reflect.Type) error {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: o.value}
+	if t.Kind() != reflect.String {
+		var parsed yaml.Node
+		if err := yaml.Unmarshal([]byte(o.value), &parsed); err != nil || len(parsed.Content) == 0 {
+			return fmt.Errorf("%s: invalid value %q", o.source, o.value)
+		}
+		value = parsed.Content[0]
+	}
+	if err := value.Decode(reflect.New(t).Interface()); err != nil {
+		return fmt.Errorf("%s: invalid value %q", o.source, o.value)
+	}
+
+	node := documentMapping(doc)
+	for i, elem := range o.path {
+		if index, ok := listIndex(elem); ok {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return fmt.Errorf("%s: %s has no item %d in the config file", o.source, formatKeyPath(o.path[:i]), index)
+			}
+			node = node.Content[index]
+		} else {
+			if node.Kind != yaml.MappingNode {
+				*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			node = mappingValue(node, elem)
+		}
+		if i == len(o.path)-1 {
+			*node = *value
+		}
+	}
+	return nil
+}
+
+// documentMapping returns the top-level mapping of a parsed config file,
+// creating it for an empty file
+func documentMapping(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode {
+		*doc = yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+	return doc.Content[0]
+}
+
+// mappingValue returns the value of a key in a mapping node, adding the key
+// if the mapping doesn't have it
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
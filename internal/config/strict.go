@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UnknownKey is a key in the config file, or given with -set, that doesn't
+// match a setting
+type UnknownKey struct {
+	// Key is the key's full path, such as sync.intervall or
+	// azure.storage_accounts[0].nmae
+	Key  string
+	Line int
+	// Source is where a key from outside the config file was given
+	Source string
+	// Suggestion is the full path of a known key with a similar name, if any
+	Suggestion string
+}
+
+func (k UnknownKey) String() string {
+	where := fmt.Sprintf("line %d", k.Line)
+	if k.Source != "" {
+		where = k.Source
+	}
+	s := fmt.Sprintf("%s: unknown key %s", where, k.Key)
+	if k.Suggestion != "" {
+		s += fmt.Sprintf(" (did you mean %s?)", k.Suggestion)
+	}
+	return s
+}
+
+// UnknownKeysError is returned by Load for a config file with keys that
+// don't match a setting, which would otherwise be ignored
+type UnknownKeysError struct {
+	Keys []UnknownKey
+}
+
+func (e *UnknownKeysError) Error() string {
+	lines := make([]string, len(e.Keys))
+	for i, k := range e.Keys {
+		lines[i] = k.String()
+	}
+	return "unknown configuration keys:\n  " + strings.Join(lines, "\n  ")
+}
+
+// unknownKeys returns the keys in node, and in the nodes below it, that have
+// no matching field in t. path is the key path of node.
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []UnknownKey {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var keys []UnknownKey
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			keys = append(keys, unknownKeys(n, t, path)...)
+		}
+	case yaml.AliasNode:
+		// The anchored node is checked where it is defined
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i, n := range node.Content {
+			keys = append(keys, unknownKeys(n, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				keys = append(keys, unknownKeys(node.Content[i+1], t.Elem(), joinKey(path, node.Content[i].Value))...)
+			}
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k, v := node.Content[i], node.Content[i+1]
+				if k.Value == "<<" {
+					// Merge keys bring in the keys of another mapping
+					keys = append(keys, unknownKeys(v, t, path)...)
+					continue
+				}
+				field, ok := fields[k.Value]
+				if !ok {
+					keys = append(keys, UnknownKey{
+						Key:        joinKey(path, k.Value),
+						Line:       k.Line,
+						Suggestion: suggestKey(k.Value, fields, path),
+					})
+					continue
+				}
+				keys = append(keys, unknownKeys(v, field, joinKey(path, k.Value))...)
+			}
+		}
+	}
+	return keys
+}
+
+// yamlFields maps the keys of a struct type to the types of their fields,
+// including the fields of inlined structs
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if hasOption(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// hasOption reports whether a comma-separated list of tag options has opt
+func hasOption(opts, opt string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestKey returns the full path of the field whose key is closest to key,
+// if one is close enough to be a likely typo
+func suggestKey(key string, fields map[string]reflect.Type, path string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Allow about one edit for every three characters
+	best, bestDistance := "", len(key)/3+2
+	for _, name := range names {
+		if d := editDistance(key, name); d < bestDistance {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return joinKey(path, best)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// joinKey appends a key to a key path
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
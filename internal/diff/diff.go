@@ -17,6 +17,16 @@ type DiffResult struct {
 	Stats DiffStats `json:"stats"`
 	// HasChanges indicates if there are any differences
 	HasChanges bool `json:"has_changes"`
+	// Truncated is set when either side is only an excerpt of a large file
+	Truncated bool `json:"truncated,omitempty"`
+	// Keys lists the settings that changed, for formats CompareKeys supports
+	Keys []KeyChange `json:"keys,omitempty"`
+	// Canonical is set when the versions were compared in canonical form,
+	// so formatting differences don't show
+	Canonical bool `json:"canonical,omitempty"`
+	// Encrypted is set when the versions are of an encrypted file, whose
+	// ciphertext isn't compared line by line
+	Encrypted bool `json:"encrypted,omitempty"`
 }
 
 // DiffLine represents a single line in the diff
@@ -57,14 +67,27 @@ func Compare(oldContent, newContent string) *DiffResult {
 
 	result.HasChanges = true
 
+	// Only the lines between those the versions start and end with are
+	// diffed, so a small change to a large file stays cheap
+	prefix, suffix := commonLines(oldContent, newContent)
+	oldMiddle := oldContent[prefix : len(oldContent)-suffix]
+	newMiddle := newContent[prefix : len(newContent)-suffix]
+
 	dmp := diffmatchpatch.New()
 
 	// Create line-mode diff for better readability
-	oldLines, newLines, lineArray := dmp.DiffLinesToChars(oldContent, newContent)
+	oldLines, newLines, lineArray := dmp.DiffLinesToChars(oldMiddle, newMiddle)
 	diffs := dmp.DiffMain(oldLines, newLines, false)
 	diffs = dmp.DiffCharsToLines(diffs, lineArray)
 	diffs = dmp.DiffCleanupSemantic(diffs)
 
+	if prefix > 0 {
+		diffs = append([]diffmatchpatch.Diff{{Type: diffmatchpatch.DiffEqual, Text: oldContent[:prefix]}}, diffs...)
+	}
+	if suffix > 0 {
+		diffs = append(diffs, diffmatchpatch.Diff{Type: diffmatchpatch.DiffEqual, Text: oldContent[len(oldContent)-suffix:]})
+	}
+
 	// Generate unified diff
 	result.UnifiedDiff = generateUnifiedDiff(diffs, oldContent, newContent)
 
@@ -74,6 +97,28 @@ func Compare(oldContent, newContent string) *DiffResult {
 	return result
 }
 
+// commonLines returns the lengths of the whole lines two contents start
+// with and end with in common. They don't overlap.
+func commonLines(oldContent, newContent string) (prefix, suffix int) {
+	n := min(len(oldContent), len(newContent))
+	for prefix < n && oldContent[prefix] == newContent[prefix] {
+		prefix++
+	}
+	// Back to the start of the line the contents differ in
+	prefix = strings.LastIndexByte(oldContent[:prefix], '\n') + 1
+
+	n -= prefix
+	for suffix < n && oldContent[len(oldContent)-1-suffix] == newContent[len(newContent)-1-suffix] {
+		suffix++
+	}
+	// On to the start of the line after the one the contents differ in
+	end := strings.IndexByte(oldContent[len(oldContent)-suffix:], '\n')
+	if end < 0 {
+		return prefix, 0
+	}
+	return prefix, suffix - end - 1
+}
+
 // generateUnifiedDiff creates a unified diff format string
 func generateUnifiedDiff(diffs []diffmatchpatch.Diff, oldContent, newContent string) string {
 	var sb strings.Builder
@@ -179,6 +224,44 @@ func CompareVersions(oldContent, newContent, oldLabel, newLabel string) *DiffRes
 	return result
 }
 
+// CompareFiles compares two versions of the file at path like
+// CompareVersions, in canonical form where the format has one, and lists
+// the settings that changed
+func CompareFiles(path, oldContent, newContent, oldLabel, newLabel string) *DiffResult {
+	oldCanonical, newCanonical, canonical := Canonicalize(path, oldContent, newContent)
+	result := CompareVersions(oldCanonical, newCanonical, oldLabel, newLabel)
+	result.Canonical = canonical
+	result.Keys = CompareKeys(path, oldContent, newContent)
+	return result
+}
+
+// Canonicalize returns two versions of the file at path in canonical form
+// (for XML, see CanonicalXML) and true, or them unchanged and false if the
+// format has no canonical form or either version doesn't parse. An empty
+// version stays empty.
+func Canonicalize(path, oldContent, newContent string) (string, string, bool) {
+	if Format(path) != FormatXML {
+		return oldContent, newContent, false
+	}
+	oldCanonical, err := canonicalOrEmpty(oldContent)
+	if err != nil {
+		return oldContent, newContent, false
+	}
+	newCanonical, err := canonicalOrEmpty(newContent)
+	if err != nil {
+		return oldContent, newContent, false
+	}
+	return oldCanonical, newCanonical, true
+}
+
+// canonicalOrEmpty is CanonicalXML, leaving empty content empty
+func canonicalOrEmpty(content string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return "", nil
+	}
+	return CanonicalXML(content)
+}
+
 // min returns the smaller of two integers
 func min(a, b int) int {
 	if a < b {
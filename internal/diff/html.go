@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"html/template"
+	"io"
+)
+
+// HTMLOptions describe a diff rendered by RenderHTML
+type HTMLOptions struct {
+	// Title heads the diff, usually the file path
+	Title string
+	// OldLabel and NewLabel name the compared versions, e.g. "v3" and "v4"
+	OldLabel string
+	NewLabel string
+	// Context is the number of unchanged lines shown around each change.
+	// A negative value shows the whole file.
+	Context int
+	// Link, if set, is shown as a link to the change in the web UI
+	Link string
+}
+
+// htmlRow is a line of the rendered diff, or a gap of hidden unchanged
+// lines when Gap is set
+type htmlRow struct {
+	DiffLine
+	Gap bool
+}
+
+// diffHTML renders a diff with inline styles only, since e-mail clients and
+// Teams cards drop style sheets
+var diffHTML = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="margin:0;padding:16px;font-family:-apple-system,Segoe UI,Helvetica,Arial,sans-serif;font-size:14px;color:#1f2328;background:#ffffff;">
+<div style="font-size:16px;font-weight:600;margin-bottom:4px;">{{.Title}}</div>
+<div style="color:#59636e;margin-bottom:12px;">{{.OldLabel}} &rarr; {{.NewLabel}}
+{{- if .Result.HasChanges}} &middot; <span style="color:#1a7f37;">+{{.Result.Stats.LinesAdded}}</span> <span style="color:#d1242f;">&minus;{{.Result.Stats.LinesRemoved}}</span>{{end}}
+{{- if .Link}} &middot; <a href="{{.Link}}" style="color:#0969da;">View in Toggle Vault</a>{{end}}</div>
+{{- if not .Result.HasChanges}}
+<p style="color:#59636e;">No changes.</p>
+{{- end}}
+{{- if .Result.Encrypted}}
+<p style="color:#9a6700;">This file is encrypted; its content is not shown.</p>
+{{- end}}
+{{- if .Result.Truncated}}
+<p style="color:#9a6700;">The file exceeds the content size limit; only excerpts are compared.</p>
+{{- end}}
+{{- if .Result.Canonical}}
+<p style="color:#59636e;">Compared in canonical form; formatting changes are not shown.</p>
+{{- end}}
+{{- if .Result.Keys}}
+<table cellpadding="0" cellspacing="0" style="border-collapse:collapse;margin-bottom:12px;font-size:13px;">
+<tr><th style="text-align:left;padding:4px 8px;border-bottom:1px solid #d1d9e0;">Setting</th><th style="text-align:left;padding:4px 8px;border-bottom:1px solid #d1d9e0;">Change</th><th style="text-align:left;padding:4px 8px;border-bottom:1px solid #d1d9e0;">Old</th><th style="text-align:left;padding:4px 8px;border-bottom:1px solid #d1d9e0;">New</th></tr>
+{{- range .Result.Keys}}
+<tr><td style="padding:4px 8px;font-family:Consolas,Menlo,monospace;">{{.Key}}</td><td style="padding:4px 8px;">{{.Type}}</td><td style="padding:4px 8px;font-family:Consolas,Menlo,monospace;color:#d1242f;">{{.OldValue}}</td><td style="padding:4px 8px;font-family:Consolas,Menlo,monospace;color:#1a7f37;">{{.NewValue}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Rows}}
+<table cellpadding="0" cellspacing="0" style="border-collapse:collapse;width:100%;font-family:Consolas,Menlo,monospace;font-size:12px;border:1px solid #d1d9e0;">
+{{- range .Rows}}
+{{- if .Gap}}
+<tr><td colspan="3" style="padding:2px 8px;color:#59636e;background:#f6f8fa;">&hellip;</td></tr>
+{{- else if eq .Type "added"}}
+<tr style="background:#dafbe1;"><td style="padding:0 8px;color:#59636e;text-align:right;"></td><td style="padding:0 8px;color:#59636e;text-align:right;">{{.NewLineNum}}</td><td style="padding:0 8px;white-space:pre-wrap;">+{{.Content}}</td></tr>
+{{- else if eq .Type "removed"}}
+<tr style="background:#ffebe9;"><td style="padding:0 8px;color:#59636e;text-align:right;">{{.OldLineNum}}</td><td style="padding:0 8px;color:#59636e;text-align:right;"></td><td style="padding:0 8px;white-space:pre-wrap;">-{{.Content}}</td></tr>
+{{- else}}
+<tr><td style="padding:0 8px;color:#59636e;text-align:right;">{{.OldLineNum}}</td><td style="padding:0 8px;color:#59636e;text-align:right;">{{.NewLineNum}}</td><td style="padding:0 8px;white-space:pre-wrap;"> {{.Content}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// RenderHTML writes a diff as a standalone HTML document with inline
+// styles, for e-mails and chat cards. Only the changed lines and the
+// surrounding context are shown.
+func RenderHTML(w io.Writer, result *DiffResult, opts HTMLOptions) error {
+	return diffHTML.Execute(w, struct {
+		HTMLOptions
+		Result *DiffResult
+		Rows   []htmlRow
+	}{opts, result, hunkRows(result.Lines, opts.Context)})
+}
+
+// hunkRows returns the lines within context lines of a change, with a gap
+// row wherever unchanged lines are left out. A negative context keeps every
+// line.
+func hunkRows(lines []DiffLine, context int) []htmlRow {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if context < 0 {
+			keep[i] = true
+			continue
+		}
+		if line.Type == DiffLineContext {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(lines)-1, i+context); j++ {
+			keep[j] = true
+		}
+	}
+
+	var rows []htmlRow
+	for i, line := range lines {
+		if !keep[i] {
+			if len(rows) == 0 || !rows[len(rows)-1].Gap {
+				rows = append(rows, htmlRow{Gap: true})
+			}
+			continue
+		}
+		rows = append(rows, htmlRow{DiffLine: line})
+	}
+	return rows
+}
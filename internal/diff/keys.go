@@ -0,0 +1,469 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// KeyChange is a setting that differs between two versions of a file
+type KeyChange struct {
+	// Key is the setting's path, e.g. "checkout.enabled" or "servers[0].host"
+	Key      string        `json:"key"`
+	Type     KeyChangeType `json:"type"`
+	OldValue string        `json:"old_value,omitempty"`
+	NewValue string        `json:"new_value,omitempty"`
+}
+
+// KeyChangeType represents how a setting changed
+type KeyChangeType string
+
+const (
+	KeyAdded   KeyChangeType = "added"
+	KeyRemoved KeyChangeType = "removed"
+	KeyChanged KeyChangeType = "changed"
+)
+
+// File formats with key-level diffs
+const (
+	FormatYAML       = "yaml"
+	FormatJSON       = "json"
+	FormatTOML       = "toml"
+	FormatINI        = "ini"
+	FormatXML        = "xml"
+	FormatDotenv     = "dotenv"
+	FormatProperties = "properties"
+)
+
+// ParseError is a syntax error in a file parsed by ParseKeys
+type ParseError struct {
+	// Line is the 1-based line of the error
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Format returns the format of a file from its name, or "" if its settings
+// can't be compared key by key
+func Format(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	switch filepath.Ext(base) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	case ".ini":
+		return FormatINI
+	case ".xml":
+		return FormatXML
+	case ".env":
+		return FormatDotenv
+	case ".properties":
+		return FormatProperties
+	}
+	// .env.production, .env.local, ...
+	if strings.HasPrefix(base, ".env.") {
+		return FormatDotenv
+	}
+	return ""
+}
+
+// ParseKeys returns the settings in content as flat key paths and their
+// values, according to the file's format. Nested YAML, JSON and TOML keys
+// and INI sections are joined with dots and list items are indexed, e.g.
+// "servers[0].host".
+func ParseKeys(path, content string) (map[string]string, error) {
+	format := Format(path)
+	if format == "" {
+		return nil, fmt.Errorf("no key-level format for %s", filepath.Base(path))
+	}
+	return ParseKeysAs(format, content)
+}
+
+// ParseKeysAs is ParseKeys for content in a known format, whatever the name
+// of its file
+func ParseKeysAs(format, content string) (map[string]string, error) {
+	switch format {
+	case FormatYAML:
+		return parseYAML(content)
+	case FormatJSON:
+		dec := json.NewDecoder(strings.NewReader(content))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return flatten(v), nil
+	case FormatTOML:
+		var v map[string]interface{}
+		if err := toml.Unmarshal([]byte(content), &v); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, &ParseError{Line: parseErr.Position.Line, Message: parseErr.Message}
+			}
+			return nil, err
+		}
+		return flatten(v), nil
+	case FormatINI:
+		return parseINI(content)
+	case FormatXML:
+		nodes, err := parseXML(content)
+		if err != nil {
+			return nil, err
+		}
+		return flattenXML(nodes), nil
+	case FormatDotenv:
+		return parseDotenv(content)
+	case FormatProperties:
+		return parseProperties(content), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// CompareKeys returns the settings that differ between two versions of a
+// file, sorted by key. It returns nil if the file's format isn't supported
+// or either version doesn't parse; an empty version has no settings.
+func CompareKeys(path, oldContent, newContent string) []KeyChange {
+	if Format(path) == "" {
+		return nil
+	}
+	oldKeys, err := parseKeysOrEmpty(path, oldContent)
+	if err != nil {
+		return nil
+	}
+	newKeys, err := parseKeysOrEmpty(path, newContent)
+	if err != nil {
+		return nil
+	}
+
+	changes := []KeyChange{}
+	for key, oldValue := range oldKeys {
+		newValue, ok := newKeys[key]
+		switch {
+		case !ok:
+			changes = append(changes, KeyChange{Key: key, Type: KeyRemoved, OldValue: oldValue})
+		case newValue != oldValue:
+			changes = append(changes, KeyChange{Key: key, Type: KeyChanged, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+	for key, newValue := range newKeys {
+		if _, ok := oldKeys[key]; !ok {
+			changes = append(changes, KeyChange{Key: key, Type: KeyAdded, NewValue: newValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// parseKeysOrEmpty is ParseKeys, treating empty content (a file that didn't
+// exist yet or was deleted) as having no settings
+func parseKeysOrEmpty(path, content string) (map[string]string, error) {
+	if strings.TrimSpace(content) == "" {
+		return map[string]string{}, nil
+	}
+	return ParseKeys(path, content)
+}
+
+// parseYAML returns the settings in every document of a YAML file, with
+// anchors, aliases and merge keys (<<) resolved. The keys of the first
+// document have no prefix, so adding a document doesn't rename them; those of
+// later documents start with the document's index, e.g. "[1].spec.replicas".
+// Empty documents aren't counted.
+func parseYAML(content string) (map[string]string, error) {
+	var docs []interface{}
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Empty documents, such as after a trailing ---, have no settings
+		if v != nil {
+			docs = append(docs, v)
+		}
+	}
+
+	keys := map[string]string{}
+	for i, doc := range docs {
+		prefix := ""
+		if i > 0 {
+			prefix = fmt.Sprintf("[%d]", i)
+		}
+		flattenInto(keys, prefix, doc)
+	}
+	return keys, nil
+}
+
+// flatten converts a decoded YAML, JSON or TOML value into key paths and values
+func flatten(v interface{}) map[string]string {
+	keys := map[string]string{}
+	flattenInto(keys, "", v)
+	return keys
+}
+
+func flattenInto(keys map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 && prefix != "" {
+			keys[prefix] = "{}"
+		}
+		for k, item := range v {
+			flattenInto(keys, joinKey(prefix, k), item)
+		}
+	case map[interface{}]interface{}:
+		if len(v) == 0 && prefix != "" {
+			keys[prefix] = "{}"
+		}
+		for k, item := range v {
+			flattenInto(keys, joinKey(prefix, fmt.Sprint(k)), item)
+		}
+	case []interface{}:
+		if len(v) == 0 && prefix != "" {
+			keys[prefix] = "[]"
+		}
+		for i, item := range v {
+			flattenInto(keys, fmt.Sprintf("%s[%d]", prefix, i), item)
+		}
+	case []map[string]interface{}:
+		// TOML arrays of tables
+		for i, item := range v {
+			flattenInto(keys, fmt.Sprintf("%s[%d]", prefix, i), item)
+		}
+	case nil:
+		if prefix != "" {
+			keys[prefix] = "null"
+		}
+	default:
+		keys[prefix] = fmt.Sprint(v)
+	}
+}
+
+// joinKey appends key to a dotted key path
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// parseDotenv parses a .env file: KEY=value lines, optionally prefixed with
+// "export". Values may be single-quoted (literal), double-quoted (with
+// escapes, possibly spanning lines) or unquoted with a trailing # comment.
+func parseDotenv(content string) (map[string]string, error) {
+	keys := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, &ParseError{Line: lineNum, Message: "expected KEY=value"}
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, &ParseError{Line: lineNum, Message: "unterminated quoted value"}
+			}
+			value = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			// Double-quoted values continue until the closing quote
+			raw := value[1:]
+			for closingQuote(raw) < 0 && i+1 < len(lines) {
+				i++
+				raw += "\n" + lines[i]
+			}
+			end := closingQuote(raw)
+			if end < 0 {
+				return nil, &ParseError{Line: lineNum, Message: "unterminated quoted value"}
+			}
+			value = unescapeDotenv(raw[:end])
+		default:
+			if hash := strings.Index(value, " #"); hash >= 0 {
+				value = strings.TrimSpace(value[:hash])
+			}
+		}
+		keys[key] = value
+	}
+	return keys, nil
+}
+
+// closingQuote returns the index of the first unescaped double quote in s,
+// or -1
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeDotenv resolves the escapes in a double-quoted .env value
+func unescapeDotenv(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// parseINI parses an INI file: [section] headers and "key = value" or
+// "key: value" lines, with ; and # comments. Keys before the first section
+// have no prefix; double-quoted values are unquoted.
+func parseINI(content string) (map[string]string, error) {
+	keys := map[string]string{}
+	section := ""
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, &ParseError{Line: i + 1, Message: "unterminated section header"}
+			}
+			section = strings.TrimSpace(line[1:end])
+			continue
+		}
+
+		sep := strings.IndexAny(line, "=:")
+		if sep <= 0 {
+			return nil, &ParseError{Line: i + 1, Message: "expected key = value"}
+		}
+		value := strings.TrimSpace(line[sep+1:])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		keys[joinKey(section, strings.TrimSpace(line[:sep]))] = value
+	}
+	return keys, nil
+}
+
+// parseProperties parses a Java .properties file. Keys end at the first
+// unescaped '=', ':' or whitespace; lines ending in a backslash continue on
+// the next line. Later definitions of a key replace earlier ones.
+func parseProperties(content string) map[string]string {
+	keys := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for continuesLine(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continuesLine(line) {
+			line = line[:len(line)-1]
+		}
+
+		end := len(line)
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.IndexByte("=: \t\f", line[j]) >= 0 {
+				end = j
+				break
+			}
+		}
+		key := line[:end]
+		value := strings.TrimLeft(line[end:], " \t\f")
+		if value != "" && (value[0] == '=' || value[0] == ':') {
+			value = strings.TrimLeft(value[1:], " \t\f")
+		}
+		keys[unescapeProperties(key)] = unescapeProperties(value)
+	}
+	return keys
+}
+
+// continuesLine reports whether a .properties line ends in an odd number of
+// backslashes, joining it with the next line
+func continuesLine(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return n%2 == 1
+}
+
+// unescapeProperties resolves the escapes in a .properties key or value
+func unescapeProperties(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					buf.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			buf.WriteByte('u')
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
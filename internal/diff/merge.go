@@ -0,0 +1,158 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// MergeResult is the result of a three-way merge
+type MergeResult struct {
+	// Content is the merged content, with conflict markers around each
+	// conflict
+	Content string `json:"content"`
+	// Conflicts is the number of conflicts; 0 means the merge is clean
+	Conflicts int `json:"conflicts"`
+}
+
+// Conflict markers, as written by git
+const (
+	markerOurs   = "<<<<<<< "
+	markerSplit  = "======="
+	markerTheirs = ">>>>>>> "
+)
+
+// edit replaces the base lines from start up to end with lines
+type edit struct {
+	start, end int
+	lines      []string
+}
+
+// Merge merges the changes from base to ours and from base to theirs, line
+// by line. Changes to the same or adjacent lines conflict unless they are
+// the same; a conflict keeps both sides between markers labeled with
+// oursLabel and theirsLabel, like git merge.
+func Merge(base, ours, theirs, oursLabel, theirsLabel string) *MergeResult {
+	baseLines := splitLines(base)
+	oursEdits := edits(baseLines, splitLines(ours))
+	theirsEdits := edits(baseLines, splitLines(theirs))
+
+	result := &MergeResult{}
+	var out []string
+	pos, i, j := 0, 0, 0
+	for i < len(oursEdits) || j < len(theirsEdits) {
+		// Start a group at the next edit and grow it while an edit of
+		// either side overlaps or touches it
+		start := len(baseLines)
+		if i < len(oursEdits) {
+			start = oursEdits[i].start
+		}
+		if j < len(theirsEdits) {
+			start = min(start, theirsEdits[j].start)
+		}
+		end := start
+		oi, tj := i, j
+		for grown := true; grown; {
+			grown = false
+			if oi < len(oursEdits) && oursEdits[oi].start <= end {
+				end = max(end, oursEdits[oi].end)
+				oi, grown = oi+1, true
+			}
+			if tj < len(theirsEdits) && theirsEdits[tj].start <= end {
+				end = max(end, theirsEdits[tj].end)
+				tj, grown = tj+1, true
+			}
+		}
+		out = append(out, baseLines[pos:start]...)
+		oursText := applyEdits(baseLines, start, end, oursEdits[i:oi])
+		theirsText := applyEdits(baseLines, start, end, theirsEdits[j:tj])
+		switch {
+		case oi == i:
+			out = append(out, theirsText...)
+		case tj == j, equalLines(oursText, theirsText):
+			out = append(out, oursText...)
+		default:
+			result.Conflicts++
+			out = append(out, markerOurs+oursLabel+"\n")
+			out = append(out, terminated(oursText)...)
+			out = append(out, markerSplit+"\n")
+			out = append(out, terminated(theirsText)...)
+			out = append(out, markerTheirs+theirsLabel+"\n")
+		}
+		pos, i, j = end, oi, tj
+	}
+	out = append(out, baseLines[pos:]...)
+	result.Content = strings.Join(out, "")
+	return result
+}
+
+// HasConflictMarkers reports whether content still has conflict markers
+// left by Merge
+func HasConflictMarkers(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if strings.HasPrefix(line, markerOurs) || strings.HasPrefix(line, markerTheirs) || line == markerSplit {
+			return true
+		}
+	}
+	return false
+}
+
+// edits returns the changes from base to other as replaced ranges of base
+func edits(base, other []string) []edit {
+	var result []edit
+	var current *edit
+	pos := 0
+	for _, op := range lineOps(base, other) {
+		if op.op == diffmatchpatch.DiffEqual {
+			current = nil
+			pos += len(op.lines)
+			continue
+		}
+		if current == nil {
+			result = append(result, edit{start: pos, end: pos})
+			current = &result[len(result)-1]
+		}
+		if op.op == diffmatchpatch.DiffDelete {
+			pos += len(op.lines)
+			current.end = pos
+		} else {
+			current.lines = append(current.lines, op.lines...)
+		}
+	}
+	return result
+}
+
+// applyEdits returns the base lines from start up to end with edits applied
+func applyEdits(base []string, start, end int, changes []edit) []string {
+	var out []string
+	pos := start
+	for _, e := range changes {
+		out = append(out, base[pos:e.start]...)
+		out = append(out, e.lines...)
+		pos = e.end
+	}
+	return append(out, base[pos:end]...)
+}
+
+// terminated ends the last line with a newline, so a conflict marker after
+// it starts a line of its own
+func terminated(lines []string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines = append(lines[:n-1:n-1], lines[n-1]+"\n")
+	}
+	return lines
+}
+
+// equalLines reports whether two lists of lines are the same
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,376 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// patchContext is the number of unchanged lines around each change in a
+// patch, as in git diff
+const patchContext = 3
+
+// noNewline marks a line without a newline at the end of the file
+const noNewline = `\ No newline at end of file`
+
+// Patch returns the changes between two versions of the file at path as a
+// unified diff that git apply and patch accept. Empty content stands for a
+// file that doesn't exist. It returns "" if the versions are the same.
+func Patch(path, oldContent, newContent string) string {
+	if oldContent == newContent {
+		return ""
+	}
+	lines := lineDiff(oldContent, newContent)
+	oldCount, newCount := countLines(oldContent), countLines(newContent)
+	oldNoEOL := oldContent != "" && !strings.HasSuffix(oldContent, "\n")
+	newNoEOL := newContent != "" && !strings.HasSuffix(newContent, "\n")
+
+	var sb strings.Builder
+	path = strings.TrimPrefix(path, "/")
+	fmt.Fprintf(&sb, "diff --git a/%s b/%s\n", path, path)
+	switch {
+	case oldContent == "":
+		fmt.Fprintf(&sb, "new file mode 100644\n--- /dev/null\n+++ b/%s\n", path)
+	case newContent == "":
+		fmt.Fprintf(&sb, "deleted file mode 100644\n--- a/%s\n+++ /dev/null\n", path)
+	default:
+		fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", path, path)
+	}
+
+	for _, h := range hunks(lines, patchContext) {
+		oldPos, newPos := 0, 0
+		for _, line := range lines[:h.start] {
+			if line.Type != DiffLineAdded {
+				oldPos++
+			}
+			if line.Type != DiffLineRemoved {
+				newPos++
+			}
+		}
+		oldLen, newLen := 0, 0
+		for _, line := range lines[h.start:h.end] {
+			if line.Type != DiffLineAdded {
+				oldLen++
+			}
+			if line.Type != DiffLineRemoved {
+				newLen++
+			}
+		}
+		oldStart, newStart := oldPos, newPos
+		if oldLen > 0 {
+			oldStart++
+		}
+		if newLen > 0 {
+			newStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLen, newStart, newLen)
+
+		for _, line := range lines[h.start:h.end] {
+			switch line.Type {
+			case DiffLineContext:
+				sb.WriteString(" " + line.Content + "\n")
+			case DiffLineRemoved:
+				sb.WriteString("-" + line.Content + "\n")
+			case DiffLineAdded:
+				sb.WriteString("+" + line.Content + "\n")
+			}
+			lastOld := line.Type != DiffLineAdded && line.OldLineNum == oldCount && oldNoEOL
+			lastNew := line.Type != DiffLineRemoved && line.NewLineNum == newCount && newNoEOL
+			if lastOld || lastNew {
+				sb.WriteString(noNewline + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// lineDiff compares two versions line by line. Every diff line is a whole
+// line of one of the versions, unlike in Compare, whose semantic cleanup can
+// merge changes across lines.
+func lineDiff(oldContent, newContent string) []DiffLine {
+	var diffs []diffmatchpatch.Diff
+	for _, op := range lineOps(splitLines(oldContent), splitLines(newContent)) {
+		diffs = append(diffs, diffmatchpatch.Diff{Type: op.op, Text: strings.Join(op.lines, "")})
+	}
+	lines, _ := generateLineDiff(diffs)
+	return lines
+}
+
+// lineOp is a run of lines that are equal, deleted or inserted
+type lineOp struct {
+	op    diffmatchpatch.Operation
+	lines []string
+}
+
+// lineOps compares two lists of lines. go-diff's own line encoding can split
+// line numbers, so each distinct line is encoded as one rune here instead.
+func lineOps(a, b []string) []lineOp {
+	index := make(map[string]rune)
+	var text []string
+	encode := func(lines []string) []rune {
+		runes := make([]rune, len(lines))
+		for i, line := range lines {
+			r, ok := index[line]
+			if !ok {
+				// Skip the surrogate range, which doesn't survive
+				// conversion to a string
+				r = rune(len(text)) + 1
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				index[line] = r
+				text = append(text, line)
+			}
+			runes[i] = r
+		}
+		return runes
+	}
+	decode := func(r rune) string {
+		if r >= 0xD800 {
+			r -= 0x800
+		}
+		return text[r-1]
+	}
+
+	dmp := diffmatchpatch.New()
+	var ops []lineOp
+	for _, d := range dmp.DiffMainRunes(encode(a), encode(b), false) {
+		op := lineOp{op: d.Type}
+		for _, r := range d.Text {
+			op.lines = append(op.lines, decode(r))
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// splitLines splits content into lines that keep their newlines
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// hunk is a range of diff lines, from start up to end
+type hunk struct {
+	start, end int
+}
+
+// hunks groups the changed lines with context unchanged lines around them.
+// Changes closer than twice the context share a hunk.
+func hunks(lines []DiffLine, context int) []hunk {
+	var result []hunk
+	for i, line := range lines {
+		if line.Type == DiffLineContext {
+			continue
+		}
+		start, end := max(0, i-context), min(len(lines), i+context+1)
+		if n := len(result); n > 0 && start <= result[n-1].end {
+			result[n-1].end = end
+			continue
+		}
+		result = append(result, hunk{start: start, end: end})
+	}
+	return result
+}
+
+// countLines returns the number of lines in content, counting a last line
+// without a newline
+func countLines(content string) int {
+	n := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		n++
+	}
+	return n
+}
+
+// PatchError is returned when a patch is malformed or doesn't apply
+type PatchError struct {
+	// Hunk is the 1-based number of the hunk that failed, 0 for the patch
+	// as a whole
+	Hunk    int
+	Message string
+}
+
+func (e *PatchError) Error() string {
+	if e.Hunk == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("hunk %d: %s", e.Hunk, e.Message)
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// patchHunk is a parsed hunk: the lines it expects and the lines that
+// replace them
+type patchHunk struct {
+	oldStart int
+	oldLines []string
+	newLines []string
+	// oldNoEOL and newNoEOL are set when the last old or new line has no
+	// newline at the end of the file
+	oldNoEOL, newNoEOL bool
+}
+
+// ApplyPatch applies a unified diff of a single file to content and returns
+// the result. Like git apply, a hunk whose lines moved is applied where
+// its context is found nearest to the line it names; a hunk whose context
+// isn't found fails with a PatchError.
+func ApplyPatch(content, patch string) (string, error) {
+	parsed, err := parsePatch(patch)
+	if err != nil {
+		return "", err
+	}
+
+	lines := splitLines(content)
+
+	// Hunks apply to the original line numbers; offset tracks how far
+	// earlier hunks moved them
+	var result []string
+	pos, offset := 0, 0
+	for i, h := range parsed {
+		old := hunkText(h.oldLines, h.oldNoEOL)
+		at := findHunk(lines, old, pos, h.oldStart-1+offset)
+		if at < 0 {
+			return "", &PatchError{Hunk: i + 1, Message: fmt.Sprintf("does not apply at line %d", h.oldStart)}
+		}
+		result = append(result, lines[pos:at]...)
+		result = append(result, hunkText(h.newLines, h.newNoEOL)...)
+		pos = at + len(old)
+		offset = at - (h.oldStart - 1)
+	}
+	result = append(result, lines[pos:]...)
+	return strings.Join(result, ""), nil
+}
+
+// parsePatch parses the hunks of a unified diff of one file. Hunk lines are
+// counted from the hunk headers, like git does, so lines that look like
+// headers inside a hunk are read as its content.
+func parsePatch(patch string) ([]patchHunk, error) {
+	var hunks []patchHunk
+	var current *patchHunk
+	var last byte
+	oldLeft, newLeft, files := 0, 0, 0
+
+	for _, line := range strings.Split(strings.TrimSuffix(patch, "\n"), "\n") {
+		if current != nil && strings.HasPrefix(line, `\`) {
+			if last == '-' || last == ' ' {
+				current.oldNoEOL = true
+			}
+			if last == '+' || last == ' ' {
+				current.newNoEOL = true
+			}
+			continue
+		}
+
+		if oldLeft > 0 || newLeft > 0 {
+			// Editors may strip the space of empty context lines
+			kind := byte(' ')
+			if line != "" {
+				kind = line[0]
+			}
+			switch kind {
+			case ' ':
+				text := strings.TrimPrefix(line, " ")
+				current.oldLines = append(current.oldLines, text)
+				current.newLines = append(current.newLines, text)
+				oldLeft--
+				newLeft--
+			case '-':
+				current.oldLines = append(current.oldLines, line[1:])
+				oldLeft--
+			case '+':
+				current.newLines = append(current.newLines, line[1:])
+				newLeft--
+			default:
+				return nil, &PatchError{Hunk: len(hunks), Message: "has fewer lines than its header says"}
+			}
+			if oldLeft < 0 || newLeft < 0 {
+				return nil, &PatchError{Hunk: len(hunks), Message: "has more lines than its header says"}
+			}
+			last = kind
+			continue
+		}
+
+		if m := hunkHeader.FindStringSubmatch(line); m != nil {
+			start, _ := strconv.Atoi(m[1])
+			oldLeft, newLeft = hunkLength(m[2]), hunkLength(m[4])
+			hunks = append(hunks, patchHunk{oldStart: start})
+			current = &hunks[len(hunks)-1]
+			if oldLeft == 0 {
+				// An insertion names the line it follows
+				current.oldStart++
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "+++ ") {
+			files++
+		}
+		// Other lines are headers (diff --git, index, ---, file modes) or
+		// text around the patch, like a commit message
+		current = nil
+	}
+
+	switch {
+	case oldLeft > 0 || newLeft > 0:
+		return nil, &PatchError{Hunk: len(hunks), Message: "is truncated"}
+	case files > 1:
+		return nil, &PatchError{Message: "patch changes more than one file"}
+	case len(hunks) == 0:
+		return nil, &PatchError{Message: "patch has no hunks"}
+	}
+	return hunks, nil
+}
+
+// hunkLength parses the line count of a hunk header, which is 1 if left out
+func hunkLength(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// hunkText returns the lines of one side of a hunk with their newlines
+func hunkText(lines []string, noEOL bool) []string {
+	text := make([]string, len(lines))
+	for i, line := range lines {
+		text[i] = line + "\n"
+	}
+	if noEOL && len(text) > 0 {
+		text[len(text)-1] = lines[len(lines)-1]
+	}
+	return text
+}
+
+// findHunk returns the position of old in lines, at or after from, nearest
+// to want, or -1 if it isn't found
+func findHunk(lines, old []string, from, want int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(old) > len(lines) {
+			return false
+		}
+		for i, line := range old {
+			if lines[at+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	want = max(want, from)
+	for delta := 0; want-delta >= from || want+delta <= len(lines); delta++ {
+		if matches(want - delta) {
+			return want - delta
+		}
+		if matches(want + delta) {
+			return want + delta
+		}
+	}
+	return -1
+}
@@ -0,0 +1,153 @@
+package diff
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+const (
+	// shingleSize is the number of consecutive words in a shingle
+	shingleSize = 3
+	// minHashBands and minHashRows split a MinHash signature into bands for
+	// locality-sensitive hashing: files that agree on every row of any band
+	// are compared. 32 bands of 4 rows find pairs above about 0.5 similarity.
+	minHashBands = 32
+	minHashRows  = 4
+)
+
+// MinSimilarity is the lowest similarity FindSimilar reliably finds
+const MinSimilarity = 0.5
+
+// Document is a file compared by FindSimilar
+type Document struct {
+	ID      string
+	Content string
+}
+
+// SimilarPair is a pair of documents with similar content
+type SimilarPair struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// Similarity is the Jaccard similarity of the documents' word shingles,
+	// from 0 to 1
+	Similarity float64 `json:"similarity"`
+}
+
+// FindSimilar returns the pairs of documents whose similarity is at least
+// threshold, most similar first. Documents are compared as sets of
+// overlapping word sequences, so reordered or reformatted copies still
+// match. Candidate pairs are found with MinHash, so pairs below
+// MinSimilarity may be missed.
+func FindSimilar(docs []Document, threshold float64) []SimilarPair {
+	sets := make([]map[uint64]struct{}, len(docs))
+	buckets := make(map[[2]uint64][]int)
+	for i, doc := range docs {
+		sets[i] = shingles(doc.Content)
+		if len(sets[i]) == 0 {
+			continue
+		}
+		sig := minHash(sets[i])
+		for band := 0; band < minHashBands; band++ {
+			h := fnv.New64a()
+			var buf [8]byte
+			for _, v := range sig[band*minHashRows : (band+1)*minHashRows] {
+				binary.LittleEndian.PutUint64(buf[:], v)
+				h.Write(buf[:])
+			}
+			key := [2]uint64{uint64(band), h.Sum64()}
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	seen := make(map[[2]int]bool)
+	pairs := []SimilarPair{}
+	for _, bucket := range buckets {
+		for x := 0; x < len(bucket); x++ {
+			for y := x + 1; y < len(bucket); y++ {
+				i, j := bucket[x], bucket[y]
+				if seen[[2]int{i, j}] {
+					continue
+				}
+				seen[[2]int{i, j}] = true
+				if sim := jaccard(sets[i], sets[j]); sim >= threshold {
+					pairs = append(pairs, SimilarPair{A: docs[i].ID, B: docs[j].ID, Similarity: sim})
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Similarity != pairs[j].Similarity {
+			return pairs[i].Similarity > pairs[j].Similarity
+		}
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+	return pairs
+}
+
+// shingles returns the hashes of the sequences of shingleSize consecutive
+// words in content. Content shorter than that is one shingle.
+func shingles(content string) map[uint64]struct{} {
+	words := strings.Fields(content)
+	set := make(map[uint64]struct{})
+	if len(words) == 0 {
+		return set
+	}
+	for i := 0; i < max(1, len(words)-shingleSize+1); i++ {
+		h := fnv.New64a()
+		for _, word := range words[i:min(i+shingleSize, len(words))] {
+			h.Write([]byte(word))
+			h.Write([]byte{0})
+		}
+		set[h.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// minHash returns the MinHash signature of a set of shingles: for each of
+// the hash functions, the smallest hash of any shingle
+func minHash(set map[uint64]struct{}) []uint64 {
+	sig := make([]uint64, minHashBands*minHashRows)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for shingle := range set {
+		for i := range sig {
+			if v := mix(shingle ^ mix(uint64(i)+1)); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// mix is the splitmix64 finalizer, used to derive independent hash
+// functions from one shingle hash
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// jaccard returns the size of the intersection of two sets over the size
+// of their union
+func jaccard(a, b map[uint64]struct{}) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	common := 0
+	for x := range a {
+		if _, ok := b[x]; ok {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
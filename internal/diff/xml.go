@@ -0,0 +1,194 @@
+package diff
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// xmlNode is an element, text, comment, processing instruction or directive
+// of a parsed XML document
+type xmlNode struct {
+	// Exactly one of these is set, depending on the kind of node
+	element *xmlElement
+	text    string
+	raw     string
+}
+
+// xmlElement is an element with its attributes and children
+type xmlElement struct {
+	name     string
+	attrs    []xml.Attr
+	children []xmlNode
+}
+
+// parseXML parses content into its top-level nodes. Whitespace between
+// elements is dropped; other text is kept as written.
+func parseXML(content string) ([]xmlNode, error) {
+	dec := xml.NewDecoder(strings.NewReader(content))
+	root := &xmlElement{}
+	stack := []*xmlElement{root}
+
+	for {
+		// RawToken keeps namespace prefixes as written, so end tags are
+		// matched by hand
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, &ParseError{Line: syntaxErr.Line, Message: syntaxErr.Msg}
+			}
+			return nil, err
+		}
+
+		line, _ := dec.InputPos()
+		parent := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			el := &xmlElement{name: xmlName(tok.Name), attrs: append([]xml.Attr(nil), tok.Attr...)}
+			parent.children = append(parent.children, xmlNode{element: el})
+			stack = append(stack, el)
+		case xml.EndElement:
+			if len(stack) == 1 || xmlName(tok.Name) != parent.name {
+				return nil, &ParseError{Line: line, Message: fmt.Sprintf("unexpected end element </%s>", xmlName(tok.Name))}
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if strings.TrimSpace(string(tok)) == "" {
+				continue
+			}
+			if len(stack) == 1 {
+				return nil, &ParseError{Line: line, Message: "text outside the root element"}
+			}
+			parent.children = append(parent.children, xmlNode{text: string(tok)})
+		case xml.Comment:
+			parent.children = append(parent.children, xmlNode{raw: "<!--" + string(tok) + "-->"})
+		case xml.ProcInst:
+			parent.children = append(parent.children, xmlNode{raw: "<?" + tok.Target + " " + string(tok.Inst) + "?>"})
+		case xml.Directive:
+			parent.children = append(parent.children, xmlNode{raw: "<!" + string(tok) + ">"})
+		}
+	}
+
+	if len(stack) > 1 {
+		line, _ := dec.InputPos()
+		return nil, &ParseError{Line: line, Message: fmt.Sprintf("element <%s> is not closed", stack[len(stack)-1].name)}
+	}
+	return root.children, nil
+}
+
+// xmlName returns a name with its namespace prefix, as written
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// CanonicalXML rewrites an XML document so that formatting doesn't show up
+// in diffs: one element per line, indented by two spaces, attributes sorted
+// by name and whitespace between elements dropped. Text, comments and
+// processing instructions are kept.
+func CanonicalXML(content string) (string, error) {
+	nodes, err := parseXML(content)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, node := range nodes {
+		writeXMLNode(&sb, node, 0)
+	}
+	return sb.String(), nil
+}
+
+func writeXMLNode(sb *strings.Builder, node xmlNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch {
+	case node.element == nil && node.raw != "":
+		sb.WriteString(indent + node.raw + "\n")
+	case node.element == nil:
+		sb.WriteString(indent + escapeXML(node.text) + "\n")
+	default:
+		el := node.element
+		sb.WriteString(indent + "<" + el.name)
+		attrs := append([]xml.Attr(nil), el.attrs...)
+		sort.Slice(attrs, func(i, j int) bool { return xmlName(attrs[i].Name) < xmlName(attrs[j].Name) })
+		for _, attr := range attrs {
+			sb.WriteString(" " + xmlName(attr.Name) + `="` + escapeXML(attr.Value) + `"`)
+		}
+
+		switch {
+		case len(el.children) == 0:
+			sb.WriteString("/>\n")
+		case len(el.children) == 1 && el.children[0].element == nil && el.children[0].raw == "":
+			// Elements holding only text stay on one line
+			sb.WriteString(">" + escapeXML(el.children[0].text) + "</" + el.name + ">\n")
+		default:
+			sb.WriteString(">\n")
+			for _, child := range el.children {
+				writeXMLNode(sb, child, depth+1)
+			}
+			sb.WriteString(indent + "</" + el.name + ">\n")
+		}
+	}
+}
+
+// escapeXML escapes text or an attribute value
+func escapeXML(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// flattenXML returns the settings in an XML document: the text of each
+// element and its attributes, keyed by element path. Repeated elements are
+// indexed and attributes follow an @, e.g.
+// "configuration.appSettings.add[2]@value".
+func flattenXML(nodes []xmlNode) map[string]string {
+	keys := map[string]string{}
+	flattenXMLChildren(keys, "", nodes)
+	return keys
+}
+
+func flattenXMLChildren(keys map[string]string, prefix string, nodes []xmlNode) {
+	counts := map[string]int{}
+	for _, node := range nodes {
+		if node.element != nil {
+			counts[node.element.name]++
+		}
+	}
+
+	seen := map[string]int{}
+	var text strings.Builder
+	for _, node := range nodes {
+		if node.element == nil {
+			if node.raw == "" {
+				text.WriteString(node.text)
+			}
+			continue
+		}
+		el := node.element
+		key := joinKey(prefix, el.name)
+		if counts[el.name] > 1 {
+			key = fmt.Sprintf("%s[%d]", key, seen[el.name])
+			seen[el.name]++
+		}
+		for _, attr := range el.attrs {
+			keys[key+"@"+xmlName(attr.Name)] = attr.Value
+		}
+		if len(el.children) == 0 && len(el.attrs) == 0 {
+			keys[key] = ""
+		}
+		flattenXMLChildren(keys, key, el.children)
+	}
+
+	if s := strings.TrimSpace(text.String()); s != "" && prefix != "" {
+		keys[prefix] = s
+	}
+}
@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+)
+
+const (
+	storageAPIVersion = "2023-01-01"
+	requestTimeout    = 30 * time.Second
+)
+
+// storageAccount is the part of an ARM storage account resource discovery uses
+type storageAccount struct {
+	ID   string            `json:"id"`
+	Name string            `json:"name"`
+	Tags map[string]string `json:"tags"`
+}
+
+// Discoverer registers storage accounts found through Azure Resource Manager
+// with the blob client
+type Discoverer struct {
+	cfg        config.AzureConfig
+	blobClient *blob.Client
+	client     *http.Client
+	creds      []azcore.TokenCredential // one per discovery entry
+	// succeeded is called after each discovery in which every entry was
+	// listed, may be nil
+	succeeded func()
+}
+
+// New creates a discoverer for the configured discovery entries
+func New(cfg config.AzureConfig, blobClient *blob.Client) (*Discoverer, error) {
+	d := &Discoverer{
+		cfg:        cfg,
+		blobClient: blobClient,
+		client:     &http.Client{Timeout: requestTimeout},
+	}
+
+	for i, entry := range cfg.Discovery {
+		cred, err := blob.NewTokenCredential(cfg.AuthFor(entry.StorageAccountConfig))
+		if err != nil {
+			return nil, fmt.Errorf("discovery[%d]: %w", i, err)
+		}
+		d.creds = append(d.creds, cred)
+	}
+
+	return d, nil
+}
+
+// OnSuccess sets a function called after each discovery in which every entry
+// was listed, e.g. to resume detecting deletions in discovered accounts
+func (d *Discoverer) OnSuccess(fn func()) {
+	d.succeeded = fn
+}
+
+// Discover lists the storage accounts of every discovery entry and registers
+// those with matching tags that aren't registered yet. It returns the number
+// of accounts added; entries that fail are reported in the error but don't
+// stop the others.
+func (d *Discoverer) Discover(ctx context.Context) (int, error) {
+	added := 0
+	var errs []error
+
+	for i, entry := range d.cfg.Discovery {
+		accounts, err := d.listStorageAccounts(ctx, d.creds[i], entry.SubscriptionID, entry.ResourceGroup)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("discovery[%d]: %w", i, err))
+			continue
+		}
+
+		for _, account := range accounts {
+			if !entry.MatchesTags(account.Tags) || d.blobClient.HasStorageAccount(account.Name) {
+				continue
+			}
+
+			accountCfg := entry.StorageAccountConfig
+			accountCfg.Name = account.Name
+			if accountCfg.ResourceGroup == "" {
+				accountCfg.ResourceGroup = resourceGroupFromID(account.ID)
+			}
+
+			if err := d.blobClient.AddStorageAccount(accountCfg); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			added++
+			log.Printf("Discovered storage account %s (resource group %s)", accountCfg.Name, accountCfg.ResourceGroup)
+		}
+	}
+
+	if len(errs) == 0 && d.succeeded != nil {
+		d.succeeded()
+	}
+	return added, errors.Join(errs...)
+}
+
+// Start runs discovery periodically in the background until ctx is cancelled
+func (d *Discoverer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.cfg.DiscoveryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := d.Discover(ctx); err != nil {
+					log.Printf("Error discovering storage accounts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// listStorageAccounts lists the storage accounts of a subscription, or of one
+// of its resource groups, following pagination
+func (d *Discoverer) listStorageAccounts(ctx context.Context, cred azcore.TokenCredential, subscriptionID, resourceGroup string) ([]storageAccount, error) {
+	management := strings.TrimRight(d.cfg.Endpoints.Management, "/")
+
+	path := "/subscriptions/" + url.PathEscape(subscriptionID)
+	if resourceGroup != "" {
+		path += "/resourceGroups/" + url.PathEscape(resourceGroup)
+	}
+	next := management + path + "/providers/Microsoft.Storage/storageAccounts?api-version=" + storageAPIVersion
+
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{management + "/.default"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get management token: %w", err)
+	}
+
+	var accounts []storageAccount
+	for next != "" {
+		var page struct {
+			Value    []storageAccount `json:"value"`
+			NextLink string           `json:"nextLink"`
+		}
+		if err := d.get(ctx, next, token.Token, &page); err != nil {
+			return nil, fmt.Errorf("failed to list storage accounts: %w", err)
+		}
+		accounts = append(accounts, page.Value...)
+		next = page.NextLink
+	}
+
+	return accounts, nil
+}
+
+// get sends an authenticated GET request and decodes the JSON response into out
+func (d *Discoverer) get(ctx context.Context, endpoint, token string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// resourceGroupFromID extracts the resource group from an ARM resource ID
+func resourceGroupFromID(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+// Package encryption detects SOPS- and age-encrypted files and decrypts
+// them, in memory only, to compare their versions
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/toggle-vault/internal/command"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/keyvault"
+)
+
+// Scheme identifies how a file is encrypted
+type Scheme string
+
+const (
+	// SOPS files keep their structure and encrypt each value
+	SOPS Scheme = "sops"
+	// Age files are encrypted as a whole
+	Age Scheme = "age"
+)
+
+// sopsMetadata matches the metadata section SOPS adds to the files it
+// encrypts, in its YAML, JSON, dotenv and INI forms
+var sopsMetadata = regexp.MustCompile(`(?m)^(sops:|\s*"sops"\s*:|sops_mac=|\[sops\])`)
+
+// Detect returns how content is encrypted, or "" if it isn't
+func Detect(content []byte) Scheme {
+	switch {
+	case bytes.HasPrefix(content, []byte("age-encryption.org/v1\n")),
+		bytes.HasPrefix(bytes.TrimSpace(content), []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return Age
+	case bytes.Contains(content, []byte("ENC[AES256_GCM,")) && sopsMetadata.Match(content):
+		return SOPS
+	}
+	return ""
+}
+
+// SameIgnoringMetadata reports whether two versions of a SOPS-encrypted file
+// hold the same settings with the same encrypted values, ignoring the sops
+// metadata section, whose MAC and last-modified date change on every save.
+// Files whose format can't be parsed are never the same.
+func SameIgnoringMetadata(path string, oldContent, newContent []byte) bool {
+	oldKeys, err := diff.ParseKeys(path, string(oldContent))
+	if err != nil || oldKeys == nil {
+		return false
+	}
+	newKeys, err := diff.ParseKeys(path, string(newContent))
+	if err != nil || newKeys == nil {
+		return false
+	}
+	dotenv := diff.Format(path) == diff.FormatDotenv
+	stripMetadata(oldKeys, dotenv)
+	stripMetadata(newKeys, dotenv)
+	if len(oldKeys) != len(newKeys) {
+		return false
+	}
+	for key, value := range oldKeys {
+		if newValue, ok := newKeys[key]; !ok || newValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// stripMetadata removes the keys of the sops metadata section: the top-level
+// sops object in YAML and JSON and the [sops] section in INI. In dotenv
+// files, which have no nesting, SOPS writes its metadata as sops_ variables
+// and reserves that prefix, so those are removed instead.
+func stripMetadata(keys map[string]string, dotenv bool) {
+	for key := range keys {
+		if dotenv {
+			if strings.HasPrefix(key, "sops_") {
+				delete(keys, key)
+			}
+		} else if key == "sops" || strings.HasPrefix(key, "sops.") || strings.HasPrefix(key, "sops[") {
+			delete(keys, key)
+		}
+	}
+}
+
+// PlainPath returns the path the decrypted content of a file would have,
+// which tells its format: secrets.yaml.age holds YAML
+func PlainPath(path string) string {
+	return strings.TrimSuffix(path, ".age")
+}
+
+// Decrypter decrypts encrypted files with the configured key
+type Decrypter struct {
+	cfg config.EncryptionConfig
+	// keyFile holds the age identities, unless they were read from Key Vault
+	// into key, which is kept in memory only
+	keyFile string
+	key     string
+}
+
+// Load creates the Decrypter for the encryption configuration, reading the
+// key from Key Vault if configured. It returns nil if no key is configured.
+func Load(ctx context.Context, cfg config.EncryptionConfig, auth config.AuthConfig) (*Decrypter, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.AgeKeyFile != "" {
+		return &Decrypter{cfg: cfg, keyFile: cfg.AgeKeyFile}, nil
+	}
+
+	key, err := keyvault.ReadSecret(ctx, cfg.KeyVaultSecret, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age key from Key Vault: %w", err)
+	}
+	return &Decrypter{cfg: cfg, key: strings.TrimSpace(key) + "\n"}, nil
+}
+
+// String describes where the key comes from, for logs
+func (d *Decrypter) String() string {
+	if d.keyFile == "" {
+		return d.cfg.KeyVaultSecret
+	}
+	return d.keyFile
+}
+
+// Decrypt returns the plaintext of an encrypted file at path. Content that
+// isn't encrypted is returned unchanged. A key read from Key Vault is given
+// to sops in its environment and to age through a pipe, never in a file.
+func (d *Decrypter) Decrypt(ctx context.Context, path string, content []byte) ([]byte, error) {
+	switch Detect(content) {
+	case SOPS:
+		format := sopsFormat(path)
+		args := []string{"--decrypt", "--input-type", format, "--output-type", format, "/dev/stdin"}
+		env := []string{"SOPS_AGE_KEY_FILE=" + d.keyFile}
+		if d.keyFile == "" {
+			env = []string{"SOPS_AGE_KEY=" + d.key}
+		}
+		plaintext, err := command.RunEnv(ctx, d.cfg.SOPSCommand, args, env, d.cfg.Timeout, content)
+		if err != nil {
+			return nil, fmt.Errorf("sops: %w", err)
+		}
+		return plaintext, nil
+	case Age:
+		var plaintext []byte
+		var err error
+		if d.keyFile != "" {
+			args := []string{"--decrypt", "--identity", d.keyFile}
+			plaintext, err = command.Run(ctx, d.cfg.AgeCommand, args, d.cfg.Timeout, content)
+		} else {
+			args := []string{"--decrypt", "--identity", command.ExtraFilePath}
+			plaintext, err = command.RunWithFile(ctx, d.cfg.AgeCommand, args, d.cfg.Timeout, content, []byte(d.key))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("age: %w", err)
+		}
+		return plaintext, nil
+	}
+	return content, nil
+}
+
+// sopsFormat returns the sops --input-type of a file
+func sopsFormat(path string) string {
+	switch diff.Format(path) {
+	case diff.FormatYAML:
+		return "yaml"
+	case diff.FormatJSON:
+		return "json"
+	case diff.FormatDotenv:
+		return "dotenv"
+	case diff.FormatINI:
+		return "ini"
+	}
+	return "binary"
+}
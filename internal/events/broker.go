@@ -0,0 +1,112 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of event published by the broker
+type EventType string
+
+const (
+	// EventChange is published when a new version of a file is recorded
+	EventChange EventType = "change"
+	// EventSyncComplete is published at the end of every sync cycle
+	EventSyncComplete EventType = "sync_complete"
+	// EventValidationFailed is published when a hook rejects a captured version
+	EventValidationFailed EventType = "validation_failed"
+	// EventProposal is published when a proposal is created or changes state
+	EventProposal EventType = "proposal"
+)
+
+// ValidationFailure is the data of an EventValidationFailed event
+type ValidationFailure struct {
+	BlobPath    string    `json:"blob_path"`
+	ContentHash string    `json:"content_hash"`
+	Hook        string    `json:"hook"`
+	Error       string    `json:"error"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// Event is a notification delivered to subscribers
+type Event struct {
+	Type EventType   `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// subscriberBuffer is the number of events buffered per subscriber before
+// events start being dropped for that subscriber
+const subscriberBuffer = 64
+
+// Broker fans out events to any number of subscribers
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// NewBroker creates a new event broker
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber and returns its event channel.
+// The channel is closed immediately if the broker has been closed.
+func (b *Broker) Subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Broker) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish delivers an event to all subscribers. Slow subscribers whose buffer
+// is full miss the event rather than blocking the publisher.
+func (b *Broker) Publish(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close closes all subscriber channels so that long-lived consumers (such as
+// event streams) return, allowing a graceful shutdown to complete
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
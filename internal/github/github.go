@@ -0,0 +1,215 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Client is a minimal GitHub REST API client for the mirror repository
+type Client struct {
+	cfg    config.GitHubConfig
+	client *http.Client
+}
+
+// PullRequest is the part of a GitHub pull request the vault uses
+type PullRequest struct {
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	State          string `json:"state"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+	MergedBy       *struct {
+		Login string `json:"login"`
+	} `json:"merged_by"`
+}
+
+// StatusError is returned for non-2xx API responses
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("github: unexpected status %d: %s", e.StatusCode, e.Message)
+}
+
+// NewClient creates a client for the configured repository
+func NewClient(cfg config.GitHubConfig) *Client {
+	return &Client{
+		cfg:    cfg,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// RepoPath returns the path of a blob in the mirror repository
+func (c *Client) RepoPath(blobPath string) string {
+	prefix := strings.Trim(c.cfg.RepoPrefix, "/")
+	if prefix == "" {
+		return blobPath
+	}
+	return prefix + "/" + blobPath
+}
+
+// BranchSHA returns the commit a branch points to
+func (c *Client) BranchSHA(ctx context.Context, branch string) (string, error) {
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/git/ref/heads/"+escapePath(branch), nil, &ref); err != nil {
+		return "", fmt.Errorf("failed to get branch %s: %w", branch, err)
+	}
+	return ref.Object.SHA, nil
+}
+
+// CreateBranch creates a branch pointing at sha
+func (c *Client) CreateBranch(ctx context.Context, branch, sha string) error {
+	body := map[string]string{"ref": "refs/heads/" + branch, "sha": sha}
+	if err := c.do(ctx, http.MethodPost, "/git/refs", body, nil); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// GetFile returns the content and blob SHA of a file at ref. A missing file
+// returns nil content and an empty SHA.
+func (c *Client) GetFile(ctx context.Context, path, ref string) ([]byte, string, error) {
+	var file struct {
+		SHA      string `json:"sha"`
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	err := c.do(ctx, http.MethodGet, "/contents/"+escapePath(path)+"?ref="+url.QueryEscape(ref), nil, &file)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	if file.Encoding != "base64" {
+		return nil, "", fmt.Errorf("failed to get %s: unsupported encoding %q (file too large?)", path, file.Encoding)
+	}
+
+	// The API wraps base64 content at 60 characters
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return content, file.SHA, nil
+}
+
+// PutFile commits content to path on a branch. sha is the blob SHA of the
+// file being replaced, or empty to create it.
+func (c *Client) PutFile(ctx context.Context, path, branch, message string, content []byte, sha string) error {
+	body := map[string]string{
+		"message": message,
+		"content": base64.StdEncoding.EncodeToString(content),
+		"branch":  branch,
+	}
+	if sha != "" {
+		body["sha"] = sha
+	}
+	if err := c.do(ctx, http.MethodPut, "/contents/"+escapePath(path), body, nil); err != nil {
+		return fmt.Errorf("failed to commit %s: %w", path, err)
+	}
+	return nil
+}
+
+// CreatePullRequest opens a pull request from head into the base branch
+func (c *Client) CreatePullRequest(ctx context.Context, title, body, head string) (*PullRequest, error) {
+	req := map[string]string{
+		"title": title,
+		"body":  body,
+		"head":  head,
+		"base":  c.cfg.BaseBranch,
+	}
+	var pr PullRequest
+	if err := c.do(ctx, http.MethodPost, "/pulls", req, &pr); err != nil {
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+	return &pr, nil
+}
+
+// GetPullRequest returns the current state of a pull request
+func (c *Client) GetPullRequest(ctx context.Context, number int) (*PullRequest, error) {
+	var pr PullRequest
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/pulls/%d", number), nil, &pr); err != nil {
+		return nil, fmt.Errorf("failed to get pull request %d: %w", number, err)
+	}
+	return &pr, nil
+}
+
+// ClosePullRequest closes a pull request without merging it
+func (c *Client) ClosePullRequest(ctx context.Context, number int) error {
+	body := map[string]string{"state": "closed"}
+	if err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/pulls/%d", number), body, nil); err != nil {
+		return fmt.Errorf("failed to close pull request %d: %w", number, err)
+	}
+	return nil
+}
+
+// do sends a request to a repository endpoint and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := strings.TrimRight(c.cfg.APIURL, "/") + "/repos/" + c.cfg.Repo + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// escapePath escapes each segment of a slash-separated path
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,67 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/toggle-vault/internal/command"
+	"github.com/toggle-vault/internal/config"
+)
+
+// CommandHook runs an external executable for each captured version.
+//
+// The payload is written to the command's stdin as JSON. A pre_store command
+// rejects the version by exiting non-zero; with transform enabled, anything it
+// writes to stdout replaces the version content. A post_store command's exit
+// status is only logged.
+type CommandHook struct {
+	cfg config.HookConfig
+}
+
+// NewCommandHook creates a hook from its configuration
+func NewCommandHook(cfg config.HookConfig) *CommandHook {
+	return &CommandHook{cfg: cfg}
+}
+
+// Name returns the configured hook name
+func (h *CommandHook) Name() string {
+	return h.cfg.Name
+}
+
+// PreStore runs the command if it is configured for the pre_store stage
+func (h *CommandHook) PreStore(ctx context.Context, p *Payload) error {
+	if h.cfg.Stage != config.HookStagePreStore {
+		return nil
+	}
+
+	out, err := h.run(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	if h.cfg.Transform && len(out) > 0 {
+		p.Version.Content = string(out)
+	}
+	return nil
+}
+
+// PostStore runs the command if it is configured for the post_store stage
+func (h *CommandHook) PostStore(ctx context.Context, p *Payload) error {
+	if h.cfg.Stage != config.HookStagePostStore {
+		return nil
+	}
+
+	_, err := h.run(ctx, p)
+	return err
+}
+
+// run executes the command with the payload on stdin and returns its stdout
+func (h *CommandHook) run(ctx context.Context, p *Payload) ([]byte, error) {
+	input, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode hook payload: %w", err)
+	}
+
+	return command.Run(ctx, h.cfg.Command, h.cfg.Args, h.cfg.Timeout, input)
+}
@@ -0,0 +1,141 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// Payload describes a captured version passed to hooks
+type Payload struct {
+	BlobPath string         `json:"blob_path"`
+	Version  *store.Version `json:"version"`
+}
+
+// Hook is invoked around every version the syncer captures.
+//
+// PreStore runs before the version is written. It may modify the version
+// (for example to transform its content) or return an error to reject it, in
+// which case the version is not stored. PostStore runs after the version has
+// been written; its errors are logged but otherwise ignored.
+type Hook interface {
+	Name() string
+	PreStore(ctx context.Context, p *Payload) error
+	PostStore(ctx context.Context, p *Payload) error
+}
+
+var (
+	builtinMu sync.Mutex
+	builtin   []Hook
+)
+
+// Register makes a compiled-in hook active for every Registry created by
+// Builtin. It is intended to be called from the init function of a plugin
+// package that is linked in with a blank import.
+func Register(h Hook) {
+	builtinMu.Lock()
+	defer builtinMu.Unlock()
+	builtin = append(builtin, h)
+}
+
+// RejectedError is returned when a hook rejects a version in PreStore
+type RejectedError struct {
+	Hook string
+	Err  error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("hook %s rejected version: %v", e.Hook, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Registry is an ordered set of hooks
+type Registry struct {
+	hooks []Hook
+}
+
+// NewRegistry creates a registry with the given hooks
+func NewRegistry(hooks ...Hook) *Registry {
+	return &Registry{hooks: hooks}
+}
+
+// Builtin creates a registry containing all compiled-in hooks
+func Builtin() *Registry {
+	builtinMu.Lock()
+	defer builtinMu.Unlock()
+	return NewRegistry(append([]Hook(nil), builtin...)...)
+}
+
+// Add appends a hook to the registry
+func (r *Registry) Add(h Hook) {
+	r.hooks = append(r.hooks, h)
+}
+
+// Has reports whether the registry has a hook with the given name
+func (r *Registry) Has(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, h := range r.hooks {
+		if h.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Only returns a registry with just the named hooks, in their order in r
+func (r *Registry) Only(names []string) *Registry {
+	if r == nil {
+		return nil
+	}
+	only := &Registry{}
+	for _, h := range r.hooks {
+		for _, name := range names {
+			if h.Name() == name {
+				only.hooks = append(only.hooks, h)
+				break
+			}
+		}
+	}
+	return only
+}
+
+// Len returns the number of hooks in the registry
+func (r *Registry) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.hooks)
+}
+
+// PreStore runs every hook's PreStore in order, stopping at the first error
+func (r *Registry) PreStore(ctx context.Context, p *Payload) error {
+	if r == nil {
+		return nil
+	}
+	for _, h := range r.hooks {
+		if err := h.PreStore(ctx, p); err != nil {
+			return &RejectedError{Hook: h.Name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// PostStore runs every hook's PostStore in order, logging any errors
+func (r *Registry) PostStore(ctx context.Context, p *Payload) {
+	if r == nil {
+		return
+	}
+	for _, h := range r.hooks {
+		if err := h.PostStore(ctx, p); err != nil {
+			log.Printf("Hook %s failed for %s: %v", h.Name(), p.BlobPath, err)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package impact measures configured metrics when a change is recorded and
+// again one window later, and stores both values with the version so the UI
+// can show what the change did, such as the error rate rising after it.
+package impact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	queryTimeout = 30 * time.Second
+	// azureMonitorScope is the token scope of Azure Monitor managed Prometheus
+	azureMonitorScope = "https://prometheus.monitor.azure.com/.default"
+)
+
+// Measurer queries the metrics around each recorded change
+type Measurer struct {
+	cfg    config.ImpactConfig
+	store  store.Store
+	client *http.Client
+	cred   azcore.TokenCredential // set with azure_auth
+}
+
+// New creates a measurer for the configured metrics
+func New(cfg config.ImpactConfig, auth config.AuthConfig, st store.Store) (*Measurer, error) {
+	m := &Measurer{
+		cfg:    cfg,
+		store:  st,
+		client: &http.Client{Timeout: queryTimeout},
+	}
+	if cfg.AzureAuth {
+		cred, err := blob.NewTokenCredential(auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create credential: %w", err)
+		}
+		m.cred = cred
+	}
+	return m, nil
+}
+
+// Start subscribes to the broker and measures each change one window after
+// it was recorded, until ctx is cancelled. Changes still waiting when the
+// process stops aren't measured.
+func (m *Measurer) Start(ctx context.Context, broker *events.Broker) {
+	ch := broker.Subscribe()
+
+	go func() {
+		defer broker.Unsubscribe(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				change, ok := event.Data.(store.ChangeEvent)
+				if !ok || len(m.cfg.MetricsFor(change.BlobPath)) == 0 {
+					continue
+				}
+				go m.measureLater(ctx, change)
+			}
+		}
+	}()
+}
+
+// measureLater waits until a window has passed since the change and
+// measures it
+func (m *Measurer) measureLater(ctx context.Context, change store.ChangeEvent) {
+	timer := time.NewTimer(time.Until(change.CapturedAt.Add(m.cfg.Window)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	if err := m.Measure(ctx, change); err != nil {
+		log.Printf("Error saving the impact of %s version %d: %v", change.BlobPath, change.VersionID, err)
+	}
+}
+
+// Measure queries each metric of the changed file at the change and one
+// window later and saves the values. A metric that fails to query is saved
+// with its error.
+func (m *Measurer) Measure(ctx context.Context, change store.ChangeEvent) error {
+	window := promDuration(m.cfg.Window)
+	for _, metric := range m.cfg.MetricsFor(change.BlobPath) {
+		impact := &store.Impact{
+			VersionID: change.VersionID,
+			Metric:    metric.Name,
+			Unit:      metric.Unit,
+			Window:    window,
+		}
+
+		expr := strings.ReplaceAll(metric.Query, "$window", window)
+		var err error
+		if impact.Before, err = m.query(ctx, expr, change.CapturedAt); err == nil {
+			impact.After, err = m.query(ctx, expr, change.CapturedAt.Add(m.cfg.Window))
+		}
+		if err != nil {
+			log.Printf("Error measuring %s for %s version %d: %v", metric.Name, change.BlobPath, change.VersionID, err)
+			impact.Before, impact.After = nil, nil
+			impact.Error = err.Error()
+		}
+
+		if err := m.store.SaveImpact(impact); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryResponse is the part of a Prometheus instant query response used
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// query evaluates expr at a time. It returns nil if the result is empty and
+// an error if it has more than one series, since there would be no single
+// value to compare.
+func (m *Measurer) query(ctx context.Context, expr string, at time.Time) (*float64, error) {
+	params := url.Values{}
+	params.Set("query", expr)
+	params.Set("time", strconv.FormatFloat(float64(at.UnixMilli())/1000, 'f', 3, 64))
+	endpoint := strings.TrimRight(m.cfg.URL, "/") + "/api/v1/query?" + params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if token, err := m.token(ctx); err != nil {
+		return nil, err
+	} else if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	var result queryResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("query returned %s", resp.Status)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("query failed: %s", result.Error)
+	}
+
+	var sample [2]any
+	switch result.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(result.Data.Result, &sample); err != nil {
+			return nil, fmt.Errorf("failed to decode result: %w", err)
+		}
+	case "vector":
+		var series []struct {
+			Value [2]any `json:"value"`
+		}
+		if err := json.Unmarshal(result.Data.Result, &series); err != nil {
+			return nil, fmt.Errorf("failed to decode result: %w", err)
+		}
+		switch len(series) {
+		case 0:
+			return nil, nil
+		case 1:
+			sample = series[0].Value
+		default:
+			return nil, fmt.Errorf("query returned %d series; aggregate it to one, as with sum()", len(series))
+		}
+	default:
+		return nil, fmt.Errorf("query returned a %s; it must return a scalar or a single series", result.Data.ResultType)
+	}
+
+	text, _ := sample[1].(string)
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("query returned an invalid value %q", text)
+	}
+	return &value, nil
+}
+
+// token returns the bearer token for queries, if any
+func (m *Measurer) token(ctx context.Context) (string, error) {
+	if m.cred == nil {
+		return m.cfg.BearerToken, nil
+	}
+	token, err := m.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureMonitorScope}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get Azure Monitor token: %w", err)
+	}
+	return token.Token, nil
+}
+
+// promDuration formats d as a PromQL duration, such as 15m or 90s
+func promDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+}
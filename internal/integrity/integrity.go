@@ -0,0 +1,116 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// signatureVersion prefixes the signed message so its layout can change
+const signatureVersion = "toggle-vault/v1"
+
+// evidenceKeyContext separates the seed of the evidence key from other uses
+// of the signing key
+const evidenceKeyContext = "toggle-vault/evidence-key/v1"
+
+var (
+	// ErrUnsigned is returned for versions recorded before signing was enabled
+	ErrUnsigned = errors.New("version is not signed")
+	// ErrUnknownKey is returned for versions signed with a different key
+	ErrUnknownKey = errors.New("version is signed with an unknown key")
+	// ErrBadSignature is returned when a signature doesn't match the version
+	ErrBadSignature = errors.New("signature does not match")
+)
+
+// Signer signs versions with an HMAC-SHA256 key and verifies them. Documents
+// handed to others, such as evidence bundle manifests, are signed with an
+// Ed25519 key derived from the same key instead, so they can be verified with
+// the public key by people who must not be able to sign.
+type Signer struct {
+	key         []byte
+	keyID       string
+	evidenceKey ed25519.PrivateKey
+}
+
+// NewSigner creates a signer for a key
+func NewSigner(key []byte, keyID string) *Signer {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(evidenceKeyContext))
+	return &Signer{key: key, keyID: keyID, evidenceKey: ed25519.NewKeyFromSeed(mac.Sum(nil))}
+}
+
+// KeyID returns the ID recorded with signatures
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// Sign sets the version's signature
+func (s *Signer) Sign(v *store.Version) {
+	v.Signature = s.sign(v)
+	v.SignatureKeyID = s.keyID
+}
+
+// Verify checks the version's signature
+func (s *Signer) Verify(v *store.Version) error {
+	if v.Signature == "" {
+		return ErrUnsigned
+	}
+	if v.SignatureKeyID != s.keyID {
+		return ErrUnknownKey
+	}
+	if !hmac.Equal([]byte(v.Signature), []byte(s.sign(v))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// sign computes the signature over the fields of a version that never change
+// once it is recorded. The comment can be edited and the content of versions
+// captured by hash is filled in later, so they are covered by the content
+// hash rather than signed directly.
+func (s *Signer) sign(v *store.Version) string {
+	message := strings.Join([]string{
+		signatureVersion,
+		strconv.FormatInt(v.FileID, 10),
+		string(v.ChangeType),
+		v.ContentHash,
+		strconv.FormatInt(v.CapturedAt.Unix(), 10),
+		v.BlobETag,
+		v.Author,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignDocument returns the base64-encoded Ed25519 signature of data
+func (s *Signer) SignDocument(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.evidenceKey, data))
+}
+
+// PublicKey returns the public key that verifies the signatures of
+// SignDocument, PEM-encoded for tools such as openssl
+func (s *Signer) PublicKey() string {
+	der, err := x509.MarshalPKIXPublicKey(s.evidenceKey.Public())
+	if err != nil {
+		// Ed25519 keys always marshal
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// String describes the signer without revealing the key
+func (s *Signer) String() string {
+	return fmt.Sprintf("HMAC-SHA256 (key %s)", s.keyID)
+}
@@ -0,0 +1,51 @@
+package integrity
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestVerify(t *testing.T) {
+	signer := NewSigner([]byte("0123456789abcdef0123456789abcdef"), "k1")
+	otherKey := NewSigner([]byte("fedcba9876543210fedcba9876543210"), "k1")
+
+	tests := []struct {
+		name    string
+		change  func(v *store.Version)
+		wantErr error
+	}{
+		{name: "unchanged"},
+		{name: "comment edited", change: func(v *store.Version) { v.Comment = "why" }},
+		{name: "content filled in", change: func(v *store.Version) { v.Content = "key: value\n" }},
+		{name: "content hash changed", change: func(v *store.Version) { v.ContentHash = "other" }, wantErr: ErrBadSignature},
+		{name: "author changed", change: func(v *store.Version) { v.Author = "mallory" }, wantErr: ErrBadSignature},
+		{name: "moved to another file", change: func(v *store.Version) { v.FileID = 2 }, wantErr: ErrBadSignature},
+		{name: "captured at changed", change: func(v *store.Version) { v.CapturedAt = v.CapturedAt.Add(time.Hour) }, wantErr: ErrBadSignature},
+		{name: "signed with another key", change: func(v *store.Version) { otherKey.Sign(v) }, wantErr: ErrBadSignature},
+		{name: "unknown key ID", change: func(v *store.Version) { v.SignatureKeyID = "k0" }, wantErr: ErrUnknownKey},
+		{name: "unsigned", change: func(v *store.Version) { v.Signature, v.SignatureKeyID = "", "" }, wantErr: ErrUnsigned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &store.Version{
+				FileID:      1,
+				ContentHash: "hash",
+				ChangeType:  store.ChangeTypeModified,
+				CapturedAt:  time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC),
+				BlobETag:    "etag",
+				Author:      "alice",
+			}
+			signer.Sign(v)
+			if tt.change != nil {
+				tt.change(v)
+			}
+			if err := signer.Verify(v); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package integrity
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/keyvault"
+)
+
+// LoadSigner creates the signer for the integrity configuration, reading the
+// key from Key Vault if configured. It returns nil if signing is disabled.
+func LoadSigner(ctx context.Context, cfg config.IntegrityConfig, auth config.AuthConfig) (*Signer, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	if cfg.SigningKey != "" {
+		return NewSigner([]byte(cfg.SigningKey), cfg.KeyID), nil
+	}
+
+	key, err := keyvault.ReadSecret(ctx, cfg.KeyVaultSecret, auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key from Key Vault: %w", err)
+	}
+	return NewSigner([]byte(key), cfg.KeyID), nil
+}
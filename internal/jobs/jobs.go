@@ -0,0 +1,385 @@
+// Package jobs runs long operations, such as exports and bulk operations, in
+// the background, so that API requests can return a job ID at once instead
+// of running into the timeouts of proxies in front of the vault. Jobs are
+// kept in the store, where their progress and outcome can be looked up.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// progressInterval is the least time between stored progress updates
+	progressInterval = time.Second
+	// pruneInterval is how often finished jobs past their retention are
+	// deleted
+	pruneInterval = time.Hour
+	// callbackAttempts is how many times a callback is tried
+	callbackAttempts = 3
+	// callbackBackoff is the delay before the first retry of a callback,
+	// doubled after each
+	callbackBackoff = 5 * time.Second
+)
+
+// CallbackTimeout bounds each attempt to send a callback, and how long
+// stopping waits for the callbacks being sent
+const CallbackTimeout = 15 * time.Second
+
+// SignatureHeader carries the HMAC-SHA256 of a callback's body, as
+// sha256=<hex>, when jobs.callback_secret is set
+const SignatureHeader = "X-Toggle-Vault-Signature"
+
+// ErrQueueFull is returned when submitting a job while jobs.queue_size jobs
+// are waiting for a worker
+var ErrQueueFull = errors.New("too many jobs are waiting, try again later")
+
+// Progress reports how much of a job's work is done
+type Progress func(done, total int)
+
+// Func is the work of a job. It should stop when ctx is cancelled.
+type Func func(ctx context.Context, progress Progress) (*Result, error)
+
+// Result is what a job produced
+type Result struct {
+	// Value is stored as the job's result, encoded as JSON
+	Value interface{}
+	// Output is a file the job produced, such as an export, with its content
+	// type and file name
+	Output     []byte
+	OutputType string
+	OutputName string
+}
+
+// queued is a job waiting for a worker
+type queued struct {
+	job *store.Job
+	fn  Func
+}
+
+// Runner runs submitted jobs on a fixed number of workers
+type Runner struct {
+	cfg    config.JobsConfig
+	store  store.Store
+	queue  chan queued
+	client *http.Client
+	// workers tracks the running workers, and stopped is closed once they
+	// have all returned
+	workers sync.WaitGroup
+	stopped chan struct{}
+	// callbacks tracks the callbacks being sent, which stopping waits for
+	callbacks sync.WaitGroup
+}
+
+// New creates a runner for the jobs settings. Jobs submitted before Start
+// wait for it.
+func New(cfg config.JobsConfig, st store.Store) *Runner {
+	return &Runner{
+		cfg:     cfg,
+		store:   st,
+		queue:   make(chan queued, cfg.QueueSize),
+		stopped: make(chan struct{}),
+		client: &http.Client{
+			Timeout: CallbackTimeout,
+			// A redirect could lead off the allowed callback hosts
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Start fails the jobs a previous run left unfinished, whose work is lost,
+// and runs jobs until ctx is cancelled. Jobs running then fail; Stopped
+// tells when they have and their callbacks are sent.
+func (r *Runner) Start(ctx context.Context) {
+	if n, err := r.store.FailUnfinishedJobs("interrupted by a restart"); err != nil {
+		log.Printf("Error failing unfinished jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Failed %d jobs left unfinished by a restart", n)
+	}
+
+	for i := 0; i < r.cfg.Workers; i++ {
+		r.workers.Add(1)
+		go func() {
+			defer r.workers.Done()
+			r.work(ctx)
+		}()
+	}
+	go func() {
+		r.workers.Wait()
+		r.waitCallbacks()
+		close(r.stopped)
+	}()
+	go r.prune(ctx)
+}
+
+// Stopped is closed once the workers have returned after the context given
+// to Start is cancelled, with the jobs they were running recorded as failed,
+// and the callbacks being sent have finished or CallbackTimeout has passed
+func (r *Runner) Stopped() <-chan struct{} {
+	return r.stopped
+}
+
+// waitCallbacks waits up to CallbackTimeout for the callbacks being sent.
+// The workers have returned, so no more are started.
+func (r *Runner) waitCallbacks() {
+	sent := make(chan struct{})
+	go func() {
+		r.callbacks.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(CallbackTimeout):
+		log.Printf("Error stopping jobs: callbacks still being sent after %s", CallbackTimeout)
+	}
+}
+
+// Submit queues fn as a job and returns it. The kind, creator, admin flag
+// and callback URL are taken from spec; check the callback URL with
+// CheckCallbackURL first.
+func (r *Runner) Submit(spec store.Job, fn Func) (*store.Job, error) {
+	if len(r.queue) == cap(r.queue) {
+		return nil, ErrQueueFull
+	}
+
+	job := &store.Job{
+		Kind:        spec.Kind,
+		Status:      store.JobQueued,
+		Admin:       spec.Admin,
+		CreatedBy:   spec.CreatedBy,
+		CallbackURL: spec.CallbackURL,
+	}
+	if err := r.store.CreateJob(job); err != nil {
+		return nil, err
+	}
+
+	// The worker changes the queued job, so the caller gets a copy
+	submitted := *job
+	select {
+	case r.queue <- queued{job: job, fn: fn}:
+	default:
+		job.Status = store.JobFailed
+		job.Error = ErrQueueFull.Error()
+		r.finish(job)
+		return nil, ErrQueueFull
+	}
+	log.Printf("Queued %s job %d", job.Kind, job.ID)
+	return &submitted, nil
+}
+
+// work runs queued jobs one at a time until ctx is cancelled
+func (r *Runner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-r.queue:
+			r.run(ctx, q.job, q.fn)
+		}
+	}
+}
+
+// run runs one job and stores its outcome
+func (r *Runner) run(ctx context.Context, job *store.Job, fn Func) {
+	now := time.Now().UTC()
+	job.Status = store.JobRunning
+	job.StartedAt = &now
+	if err := r.store.UpdateJob(job); err != nil {
+		log.Printf("Error starting job %d: %v", job.ID, err)
+	}
+
+	var lastProgress time.Time
+	progress := func(done, total int) {
+		job.Done, job.Total = done, total
+		if time.Since(lastProgress) < progressInterval && done < total {
+			return
+		}
+		lastProgress = time.Now()
+		if err := r.store.UpdateJob(job); err != nil {
+			log.Printf("Error storing progress of job %d: %v", job.ID, err)
+		}
+	}
+
+	result, err := call(ctx, fn, progress)
+	if err == nil && result != nil {
+		err = r.storeResult(job, result)
+	}
+	if err != nil {
+		job.Status = store.JobFailed
+		job.Error = err.Error()
+		log.Printf("Job %d (%s) failed: %v", job.ID, job.Kind, err)
+	} else {
+		job.Status = store.JobSucceeded
+		log.Printf("Job %d (%s) succeeded", job.ID, job.Kind)
+	}
+	r.finish(job)
+
+	if job.CallbackURL != "" {
+		r.callbacks.Add(1)
+		go func(job store.Job) {
+			defer r.callbacks.Done()
+			r.sendCallback(ctx, job)
+		}(*job)
+	}
+}
+
+// call runs fn, turning a panic into an error so it fails only its job
+func call(ctx context.Context, fn Func, progress Progress) (result *Result, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx, progress)
+}
+
+// storeResult stores what a job produced with it
+func (r *Runner) storeResult(job *store.Job, result *Result) error {
+	if result.Value != nil {
+		value, err := json.Marshal(result.Value)
+		if err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+		job.Result = value
+	}
+	if result.Output != nil {
+		if err := r.store.SetJobOutput(job.ID, result.Output, result.OutputType, result.OutputName); err != nil {
+			return err
+		}
+		job.OutputName = result.OutputName
+	}
+	return nil
+}
+
+// finish stores the outcome of a job
+func (r *Runner) finish(job *store.Job) {
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	if err := r.store.UpdateJob(job); err != nil {
+		log.Printf("Error storing outcome of job %d: %v", job.ID, err)
+	}
+}
+
+// prune deletes finished jobs past their retention every pruneInterval
+func (r *Runner) prune(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := r.store.DeleteJobsFinishedBefore(time.Now().UTC().Add(-r.cfg.Retention))
+			if err != nil {
+				log.Printf("Error deleting old jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("Deleted %d finished jobs", n)
+			}
+		}
+	}
+}
+
+// CheckCallbackURL returns an error unless rawURL is an http or https URL
+// on one of jobs.callback_hosts
+func (r *Runner) CheckCallbackURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range r.cfg.CallbackHosts {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("callbacks to %s are not allowed (jobs.callback_hosts)", host)
+}
+
+// sendCallback posts a finished job to its callback URL, retrying with
+// backoff until ctx is cancelled. If every attempt fails, the error is
+// stored with the job.
+func (r *Runner) sendCallback(ctx context.Context, job store.Job) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("Error encoding callback of job %d: %v", job.ID, err)
+		return
+	}
+
+	delay := callbackBackoff
+	for attempt := 1; ; attempt++ {
+		err = r.postCallback(job.CallbackURL, body)
+		if err == nil {
+			log.Printf("Sent callback of job %d", job.ID)
+			return
+		}
+		if attempt == callbackAttempts || !sleep(ctx, delay) {
+			break
+		}
+		delay *= 2
+	}
+
+	log.Printf("Error sending callback of job %d: %v", job.ID, err)
+	job.CallbackError = err.Error()
+	if err := r.store.UpdateJob(&job); err != nil {
+		log.Printf("Error storing callback outcome of job %d: %v", job.ID, err)
+	}
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// postCallback posts the body of a callback once, signed if
+// jobs.callback_secret is set
+func (r *Runner) postCallback(callbackURL string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.cfg.CallbackSecret != "" {
+		mac := hmac.New(sha256.New, []byte(r.cfg.CallbackSecret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
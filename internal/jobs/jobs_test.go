@@ -0,0 +1,129 @@
+package jobs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestCheckCallbackURL(t *testing.T) {
+	r := New(config.JobsConfig{CallbackHosts: []string{"ci.example.com", "*.hooks.example.com"}}, nil)
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://ci.example.com/jobs", false},
+		{"http://CI.example.com:8080/jobs", false},
+		{"https://build.hooks.example.com/done", false},
+		{"https://hooks.example.com/done", true},
+		{"https://ci.example.com.evil.test/jobs", true},
+		{"https://other.example.com/jobs", true},
+		{"ftp://ci.example.com/jobs", true},
+		{"/jobs", true},
+		{"://bad", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := r.CheckCallbackURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckCallbackURL(%q) = %v, want error %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPostCallbackSignature(t *testing.T) {
+	body := []byte(`{"id":1,"status":"succeeded"}`)
+	mac := hmac.New(sha256.New, []byte("callback-secret"))
+	mac.Write(body)
+	signed := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name          string
+		secret        string
+		status        int
+		wantSignature string
+		wantErr       bool
+	}{
+		{name: "signed", secret: "callback-secret", status: http.StatusOK, wantSignature: signed},
+		{name: "unsigned without a secret", status: http.StatusNoContent},
+		{name: "receiver error", secret: "callback-secret", status: http.StatusInternalServerError, wantSignature: signed, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSignature string
+			var gotBody []byte
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotSignature = r.Header.Get(SignatureHeader)
+				gotBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer receiver.Close()
+
+			r := New(config.JobsConfig{CallbackSecret: tt.secret}, nil)
+			err := r.postCallback(receiver.URL, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postCallback() = %v, want error %v", err, tt.wantErr)
+			}
+			if gotSignature != tt.wantSignature {
+				t.Errorf("signature = %q, want %q", gotSignature, tt.wantSignature)
+			}
+			if string(gotBody) != string(body) {
+				t.Errorf("body = %s, want %s", gotBody, body)
+			}
+		})
+	}
+}
+
+func TestStoppedWaitsForCallbacks(t *testing.T) {
+	st, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	received := make(chan struct{})
+	sent := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		time.Sleep(100 * time.Millisecond)
+		close(sent)
+	}))
+	defer receiver.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := New(config.JobsConfig{Workers: 1, QueueSize: 1, CallbackHosts: []string{"127.0.0.1"}}, st)
+	r.Start(ctx)
+	if _, err := r.Submit(store.Job{Kind: "test", CallbackURL: receiver.URL}, func(ctx context.Context, progress Progress) (*Result, error) {
+		return &Result{}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-received
+	cancel()
+	select {
+	case <-r.Stopped():
+	case <-time.After(CallbackTimeout):
+		t.Fatal("runner did not stop")
+	}
+	select {
+	case <-sent:
+	default:
+		t.Error("runner stopped while a callback was being sent")
+	}
+}
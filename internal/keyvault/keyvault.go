@@ -0,0 +1,78 @@
+// Package keyvault reads secrets from Azure Key Vault
+package keyvault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+)
+
+const (
+	apiVersion = "7.4"
+	timeout    = 30 * time.Second
+)
+
+// ReadSecret fetches the value of a Key Vault secret, e.g.
+// https://myvault.vault.azure.net/secrets/name, authenticating with the azure
+// auth settings, which must use Entra ID
+func ReadSecret(ctx context.Context, secretURL string, auth config.AuthConfig) (string, error) {
+	u, err := url.Parse(secretURL)
+	if err != nil || u.Scheme != "https" || !strings.Contains(u.Host, ".") {
+		return "", fmt.Errorf("invalid secret URL %q", secretURL)
+	}
+
+	cred, err := blob.NewTokenCredential(auth)
+	if err != nil {
+		return "", err
+	}
+
+	// The token audience is the vault's DNS suffix, e.g. vault.azure.net,
+	// which differs in sovereign clouds
+	suffix := u.Host[strings.Index(u.Host, ".")+1:]
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://" + suffix + "/.default"}})
+	if err != nil {
+		return "", fmt.Errorf("failed to get Key Vault token: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("api-version", apiVersion)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	if secret.Value == "" {
+		return "", fmt.Errorf("secret is empty")
+	}
+	return secret.Value, nil
+}
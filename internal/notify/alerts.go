@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieURL  = "https://api.opsgenie.com/v2/alerts"
+
+	alertTimeout = 15 * time.Second
+)
+
+// dedupKey identifies the incident for a file so that repeated (flapping)
+// changes to the same file update one open incident instead of paging again
+func dedupKey(n Notification) string {
+	return "toggle-vault:" + n.BlobPath
+}
+
+// alertDetails returns the structured details attached to an incident
+func alertDetails(n Notification) map[string]interface{} {
+	details := map[string]interface{}{
+		"event":     n.Event,
+		"blob_path": n.BlobPath,
+	}
+	if len(n.Owners) > 0 {
+		details["owners"] = n.Owners
+	}
+	if n.Change != nil {
+		details["change_type"] = n.Change.ChangeType
+		details["version_id"] = n.Change.VersionID
+		details["content_hash"] = n.Change.ContentHash
+		details["captured_at"] = n.Change.CapturedAt
+	}
+	if n.ValidationFailure != nil {
+		details["hook"] = n.ValidationFailure.Hook
+		details["error"] = n.ValidationFailure.Error
+		details["content_hash"] = n.ValidationFailure.ContentHash
+	}
+	if n.Proposal != nil {
+		details["proposal_id"] = n.Proposal.ID
+		details["proposal_status"] = n.Proposal.Status
+		details["author"] = n.Proposal.Author
+	}
+	return details
+}
+
+// postJSON sends body as JSON and treats any non-2xx response as an error
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// PagerDutyNotifier triggers PagerDuty incidents through the Events API v2
+type PagerDutyNotifier struct {
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+// NewPagerDutyNotifier creates a PagerDuty notifier from its configuration
+func NewPagerDutyNotifier(cfg config.NotifierConfig) *PagerDutyNotifier {
+	if cfg.URL == "" {
+		cfg.URL = defaultPagerDutyURL
+	}
+	if cfg.Severity == "" {
+		cfg.Severity = "error"
+	}
+	return &PagerDutyNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: alertTimeout},
+	}
+}
+
+// Name returns the configured notifier name
+func (p *PagerDutyNotifier) Name() string {
+	return p.cfg.Name
+}
+
+// Notify triggers (or updates) the incident for the notification's file
+func (p *PagerDutyNotifier) Notify(ctx context.Context, n Notification) error {
+	event := map[string]interface{}{
+		"routing_key":  p.cfg.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    dedupKey(n),
+		"payload": map[string]interface{}{
+			"summary":        "[toggle-vault] " + n.Summary(),
+			"source":         n.BlobPath,
+			"severity":       p.cfg.Severity,
+			"component":      "toggle-vault",
+			"class":          string(n.Event),
+			"custom_details": alertDetails(n),
+		},
+	}
+	return postJSON(ctx, p.client, p.cfg.URL, nil, event)
+}
+
+// OpsgenieNotifier creates Opsgenie alerts through the Alert API
+type OpsgenieNotifier struct {
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+// NewOpsgenieNotifier creates an Opsgenie notifier from its configuration
+func NewOpsgenieNotifier(cfg config.NotifierConfig) *OpsgenieNotifier {
+	if cfg.URL == "" {
+		cfg.URL = defaultOpsgenieURL
+	}
+	if cfg.Severity == "" {
+		cfg.Severity = "P3"
+	}
+	return &OpsgenieNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: alertTimeout},
+	}
+}
+
+// Name returns the configured notifier name
+func (o *OpsgenieNotifier) Name() string {
+	return o.cfg.Name
+}
+
+// Notify creates an alert; Opsgenie de-duplicates open alerts by alias
+func (o *OpsgenieNotifier) Notify(ctx context.Context, n Notification) error {
+	details := make(map[string]string)
+	for k, v := range alertDetails(n) {
+		details[k] = fmt.Sprint(v)
+	}
+
+	alert := map[string]interface{}{
+		"message":     truncate("[toggle-vault] "+n.Summary(), 130),
+		"alias":       dedupKey(n),
+		"description": n.Summary(),
+		"source":      "toggle-vault",
+		"priority":    o.cfg.Severity,
+		"details":     details,
+	}
+	headers := map[string]string{"Authorization": "GenieKey " + o.cfg.APIKey}
+	return postJSON(ctx, o.client, o.cfg.URL, headers, alert)
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
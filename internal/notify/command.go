@@ -0,0 +1,38 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/toggle-vault/internal/command"
+	"github.com/toggle-vault/internal/config"
+)
+
+// CommandNotifier runs an external executable for each notification, writing
+// it as JSON to its stdin. This lets environments without outbound
+// network access wire changes into their own ticketing or alerting tools.
+type CommandNotifier struct {
+	cfg config.NotifierConfig
+}
+
+// NewCommandNotifier creates a command notifier from its configuration
+func NewCommandNotifier(cfg config.NotifierConfig) *CommandNotifier {
+	return &CommandNotifier{cfg: cfg}
+}
+
+// Name returns the configured notifier name
+func (n *CommandNotifier) Name() string {
+	return n.cfg.Name
+}
+
+// Notify runs the command with the notification payload on stdin
+func (n *CommandNotifier) Notify(ctx context.Context, notification Notification) error {
+	input, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	_, err = command.Run(ctx, n.cfg.Command, n.cfg.Args, n.cfg.Timeout, input)
+	return err
+}
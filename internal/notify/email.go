@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// digestCheckInterval is how often subscriptions are checked for due digests
+	digestCheckInterval = time.Minute
+	// maxDigestChanges caps the number of changes listed in one digest
+	maxDigestChanges = 500
+)
+
+// EmailNotifier sends e-mail to subscribers. Immediate subscriptions are
+// notified as changes arrive; hourly and daily subscriptions receive digests.
+type EmailNotifier struct {
+	cfg   config.EmailConfig
+	store store.Store
+}
+
+// NewEmailNotifier creates an e-mail notifier
+func NewEmailNotifier(cfg config.EmailConfig, st store.Store) *EmailNotifier {
+	return &EmailNotifier{cfg: cfg, store: st}
+}
+
+// Name returns the notifier name
+func (e *EmailNotifier) Name() string {
+	return "email"
+}
+
+// Notify e-mails the notification to every immediate subscription whose
+// prefix matches the file
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	subs, err := e.store.ListSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	subject := "[toggle-vault] " + n.Summary()
+	body := e.formatNotification(n)
+
+	for _, sub := range subs {
+		if sub.Mode != store.DeliveryImmediate || !strings.HasPrefix(n.BlobPath, sub.PathPrefix) {
+			continue
+		}
+		if err := e.send(sub.Email, subject, body); err != nil {
+			log.Printf("Error sending e-mail to %s: %v", sub.Email, err)
+		}
+	}
+	return nil
+}
+
+// StartDigests sends hourly and daily digests in the background until ctx is cancelled
+func (e *EmailNotifier) StartDigests(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(digestCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := e.sendDueDigests(time.Now()); err != nil {
+					log.Printf("Error sending digests: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// sendDueDigests sends a digest to every subscription whose period has elapsed
+func (e *EmailNotifier) sendDueDigests(now time.Time) error {
+	subs, err := e.store.ListSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		var period time.Duration
+		switch sub.Mode {
+		case store.DeliveryHourly:
+			period = time.Hour
+		case store.DeliveryDaily:
+			period = 24 * time.Hour
+		default:
+			continue
+		}
+
+		if now.Sub(sub.LastSentAt) < period {
+			continue
+		}
+
+		changes, err := e.store.SearchChanges(store.SearchQuery{
+			PathPrefix: sub.PathPrefix,
+			Since:      sub.LastSentAt,
+			Until:      now,
+			Limit:      maxDigestChanges,
+		})
+		if err != nil {
+			log.Printf("Error collecting digest for %s: %v", sub.Email, err)
+			continue
+		}
+
+		// Empty periods are skipped but still advance the window
+		if len(changes) > 0 {
+			subject := fmt.Sprintf("[toggle-vault] %s digest: %d changes under %q", sub.Mode, len(changes), sub.PathPrefix)
+			if err := e.send(sub.Email, subject, e.formatDigest(sub, changes)); err != nil {
+				log.Printf("Error sending digest to %s: %v", sub.Email, err)
+				continue
+			}
+		}
+
+		if err := e.store.MarkSubscriptionSent(sub.ID, now); err != nil {
+			log.Printf("Error updating subscription %d: %v", sub.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// formatNotification renders the body of an immediate notification
+func (e *EmailNotifier) formatNotification(n Notification) string {
+	var sb strings.Builder
+	sb.WriteString(n.Summary() + "\n\n")
+
+	if n.Change != nil {
+		fmt.Fprintf(&sb, "File:        %s\n", n.BlobPath)
+		fmt.Fprintf(&sb, "Change:      %s\n", n.Change.ChangeType)
+		fmt.Fprintf(&sb, "Version:     %d\n", n.Change.VersionID)
+		fmt.Fprintf(&sb, "Captured at: %s\n", n.Change.CapturedAt.Format(time.RFC1123))
+	}
+	if n.ValidationFailure != nil {
+		fmt.Fprintf(&sb, "File:  %s\n", n.BlobPath)
+		fmt.Fprintf(&sb, "Hook:  %s\n", n.ValidationFailure.Hook)
+		fmt.Fprintf(&sb, "Error: %s\n", n.ValidationFailure.Error)
+	}
+	if n.Proposal != nil {
+		fmt.Fprintf(&sb, "File:     %s\n", n.BlobPath)
+		fmt.Fprintf(&sb, "Proposal: %d (%s)\n", n.Proposal.ID, n.Proposal.Kind)
+		fmt.Fprintf(&sb, "Status:   %s\n", n.Proposal.Status)
+		fmt.Fprintf(&sb, "Author:   %s\n", n.Proposal.Author)
+		if n.Proposal.Comment != "" {
+			fmt.Fprintf(&sb, "Comment:  %s\n", n.Proposal.Comment)
+		}
+		if n.Proposal.Reviewer != "" {
+			fmt.Fprintf(&sb, "Reviewer: %s\n", n.Proposal.Reviewer)
+		}
+		if n.Proposal.Error != "" {
+			fmt.Fprintf(&sb, "Error:    %s\n", n.Proposal.Error)
+		}
+	}
+
+	if len(n.Owners) > 0 {
+		fmt.Fprintf(&sb, "Owners:      %s\n", strings.Join(n.Owners, ", "))
+	}
+
+	if e.cfg.BaseURL != "" {
+		fmt.Fprintf(&sb, "\n%s\n", e.fileURL(n.BlobPath))
+	}
+	return sb.String()
+}
+
+// formatDigest renders the body of a digest
+func (e *EmailNotifier) formatDigest(sub store.Subscription, changes []store.ChangeEvent) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d changes under %q since %s:\n\n", len(changes), sub.PathPrefix, sub.LastSentAt.Format(time.RFC1123))
+
+	for _, c := range changes {
+		fmt.Fprintf(&sb, "  %s  %-9s  v%-6d %s\n", c.CapturedAt.Format("2006-01-02 15:04"), c.ChangeType, c.VersionID, c.BlobPath)
+	}
+
+	if len(changes) == maxDigestChanges {
+		sb.WriteString("\n(digest truncated; see Toggle Vault for the full list)\n")
+	}
+	if e.cfg.BaseURL != "" {
+		fmt.Fprintf(&sb, "\n%s\n", strings.TrimRight(e.cfg.BaseURL, "/"))
+	}
+
+	sb.WriteString("\nYou are receiving this because of a Toggle Vault subscription.\n")
+	return sb.String()
+}
+
+// fileURL links to a search for the file in the web UI
+func (e *EmailNotifier) fileURL(blobPath string) string {
+	return strings.TrimRight(e.cfg.BaseURL, "/") + "/?q=" + url.QueryEscape(blobPath)
+}
+
+// send delivers a plain-text e-mail to a single recipient
+func (e *EmailNotifier) send(to, subject, body string) error {
+	headers := []string{
+		"From: " + e.cfg.From,
+		"To: " + to,
+		"Subject: " + encodeSubject(subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.SMTPHost)
+	}
+
+	addr := e.cfg.SMTPHost + ":" + strconv.Itoa(e.cfg.SMTPPort)
+	return smtp.SendMail(addr, auth, e.cfg.From, []string{to}, []byte(msg))
+}
+
+// encodeSubject folds a subject onto one line, since summaries can span
+// several, such as a hook's output, and would otherwise add headers of their
+// own, and encodes any non-ASCII characters
+func encodeSubject(subject string) string {
+	subject = strings.Join(strings.Fields(subject), " ")
+	return mime.QEncoding.Encode("utf-8", subject)
+}
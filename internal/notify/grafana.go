@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/toggle-vault/internal/config"
+)
+
+// GrafanaNotifier writes notifications as annotations through the Grafana
+// HTTP API, so changes show up on the graphs they may have affected
+type GrafanaNotifier struct {
+	cfg    config.NotifierConfig
+	client *http.Client
+}
+
+// NewGrafanaNotifier creates a Grafana notifier from its configuration
+func NewGrafanaNotifier(cfg config.NotifierConfig) *GrafanaNotifier {
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &GrafanaNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: alertTimeout},
+	}
+}
+
+// Name returns the configured notifier name
+func (g *GrafanaNotifier) Name() string {
+	return g.cfg.Name
+}
+
+// Notify adds an annotation to each configured dashboard, or a single
+// organization-wide one if there are none
+func (g *GrafanaNotifier) Notify(ctx context.Context, n Notification) error {
+	at := time.Now()
+	if n.Change != nil {
+		at = n.Change.CapturedAt
+	}
+
+	tags := []string{"toggle-vault", string(n.Event)}
+	if n.Change != nil {
+		tags = append(tags, string(n.Change.ChangeType))
+	}
+	tags = append(tags, g.cfg.Tags...)
+
+	text := n.Summary()
+	if len(n.Owners) > 0 {
+		text += "\nOwners: " + strings.Join(n.Owners, ", ")
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + g.cfg.APIKey}
+	annotation := map[string]interface{}{
+		"time": at.UnixMilli(),
+		"tags": tags,
+		"text": text,
+	}
+	if len(g.cfg.Dashboards) == 0 {
+		return postJSON(ctx, g.client, g.cfg.URL+"/api/annotations", headers, annotation)
+	}
+
+	var failed []string
+	for _, uid := range g.cfg.Dashboards {
+		annotation["dashboardUID"] = uid
+		if err := postJSON(ctx, g.client, g.cfg.URL+"/api/annotations", headers, annotation); err != nil {
+			failed = append(failed, fmt.Sprintf("dashboard %s: %v", uid, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to annotate %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
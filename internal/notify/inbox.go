@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// InboxNotifier delivers changes to the inbox of every user watching the file
+type InboxNotifier struct {
+	store store.Store
+}
+
+// NewInboxNotifier creates an inbox notifier
+func NewInboxNotifier(st store.Store) *InboxNotifier {
+	return &InboxNotifier{store: st}
+}
+
+// Name returns the notifier name
+func (i *InboxNotifier) Name() string {
+	return "inbox"
+}
+
+// Notify adds the change to the inbox of each user with a matching watch
+func (i *InboxNotifier) Notify(ctx context.Context, n Notification) error {
+	if n.Change == nil {
+		return nil
+	}
+
+	watches, err := i.store.ListWatches("")
+	if err != nil {
+		return err
+	}
+
+	delivered := make(map[string]bool)
+	for _, watch := range watches {
+		if delivered[watch.UserID] || !watch.Matches(n.BlobPath) {
+			continue
+		}
+		delivered[watch.UserID] = true
+
+		item := &store.InboxItem{UserID: watch.UserID, ChangeEvent: *n.Change}
+		if err := i.store.AddInboxItem(item); err != nil {
+			return fmt.Errorf("failed to deliver to %s: %w", watch.UserID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/owners"
+	"github.com/toggle-vault/internal/store"
+)
+
+// Notification is what notifiers receive: a recorded change, a captured
+// version that was rejected by a validation hook, or a proposal that needs
+// review or was decided
+type Notification struct {
+	Event             events.EventType          `json:"event"`
+	BlobPath          string                    `json:"blob_path"`
+	Change            *store.ChangeEvent        `json:"change,omitempty"`
+	ValidationFailure *events.ValidationFailure `json:"validation_failure,omitempty"`
+	Proposal          *store.Proposal           `json:"proposal,omitempty"`
+	// Owners are the owners of the file, if owner rules are configured
+	Owners []string `json:"owners,omitempty"`
+}
+
+// Summary returns a one-line human readable description of the notification
+func (n Notification) Summary() string {
+	switch {
+	case n.Change != nil:
+		return fmt.Sprintf("%s %s (version %d)", n.BlobPath, n.Change.ChangeType, n.Change.VersionID)
+	case n.ValidationFailure != nil:
+		return fmt.Sprintf("%s failed validation by %s: %s", n.BlobPath, n.ValidationFailure.Hook, n.ValidationFailure.Error)
+	case n.Proposal != nil && n.Proposal.Status == store.ProposalPending:
+		return fmt.Sprintf("%s: %s by %s awaits approval (proposal %d)", n.BlobPath, n.Proposal.Kind, n.Proposal.Author, n.Proposal.ID)
+	case n.Proposal != nil:
+		return fmt.Sprintf("%s: proposal %d %s", n.BlobPath, n.Proposal.ID, n.Proposal.Status)
+	default:
+		return fmt.Sprintf("%s %s", n.BlobPath, n.Event)
+	}
+}
+
+// Notifier delivers notifications to an external system
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+// New creates a notifier from its configuration
+func New(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case config.NotifierTypeCommand:
+		return NewCommandNotifier(cfg), nil
+	case config.NotifierTypePagerDuty:
+		return NewPagerDutyNotifier(cfg), nil
+	case config.NotifierTypeOpsgenie:
+		return NewOpsgenieNotifier(cfg), nil
+	case config.NotifierTypeGrafana:
+		return NewGrafanaNotifier(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+}
+
+// Filter decides which notifications a notifier receives. Empty fields match
+// everything, except Events which defaults to change events only.
+type Filter struct {
+	Events       []string
+	ChangeTypes  []string
+	PathPrefixes []string
+}
+
+// matches reports whether a notification passes the filter
+func (f Filter) matches(n Notification) bool {
+	eventTypes := f.Events
+	if len(eventTypes) == 0 {
+		eventTypes = []string{string(events.EventChange)}
+	}
+	if !contains(eventTypes, string(n.Event)) {
+		return false
+	}
+
+	if len(f.ChangeTypes) > 0 && n.Change != nil && !contains(f.ChangeTypes, string(n.Change.ChangeType)) {
+		return false
+	}
+
+	if len(f.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range f.PathPrefixes {
+		if strings.HasPrefix(n.BlobPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// route pairs a notifier with the filter that decides what it receives
+type route struct {
+	notifier Notifier
+	filter   Filter
+	// name is the name of a configured notifier, which pattern groups can
+	// send their notifications to
+	name string
+}
+
+// Dispatcher delivers events from the broker to configured notifiers
+type Dispatcher struct {
+	routes []route
+	owners *owners.Resolver
+	groups config.PatternGroups
+	// enabled switches all deliveries on or off at runtime, if set
+	enabled func() bool
+}
+
+// NewDispatcher creates a dispatcher for the configured notifiers
+func NewDispatcher(cfgs []config.NotifierConfig) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for _, cfg := range cfgs {
+		n, err := New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: %w", cfg.Name, err)
+		}
+		d.routes = append(d.routes, route{
+			notifier: n,
+			filter: Filter{
+				Events:       cfg.Events,
+				ChangeTypes:  cfg.ChangeTypes,
+				PathPrefixes: cfg.PathPrefixes,
+			},
+			name: cfg.Name,
+		})
+	}
+	return d, nil
+}
+
+// Add registers a notifier with its filter
+func (d *Dispatcher) Add(n Notifier, filter Filter) {
+	d.routes = append(d.routes, route{notifier: n, filter: filter})
+}
+
+// SetOwners attaches the owners of the file to every notification
+func (d *Dispatcher) SetOwners(resolver *owners.Resolver) {
+	d.owners = resolver
+}
+
+// SetPatternGroups sends the notifications about files in a pattern group
+// with notifiers only to those configured notifiers. Notifiers registered
+// with Add, such as the inbox, still receive them.
+func (d *Dispatcher) SetPatternGroups(groups config.PatternGroups) {
+	d.groups = groups
+}
+
+// SetEnabled drops notifications while enabled returns false, such as when
+// the settings file switches notifications off
+func (d *Dispatcher) SetEnabled(enabled func() bool) {
+	d.enabled = enabled
+}
+
+// Len returns the number of registered notifiers
+func (d *Dispatcher) Len() int {
+	return len(d.routes)
+}
+
+// Start subscribes to the broker and delivers events in the background until
+// ctx is cancelled or the broker is closed. The subscription is made before
+// Start returns so no events published afterwards are missed.
+func (d *Dispatcher) Start(ctx context.Context, broker *events.Broker) {
+	ch := broker.Subscribe()
+
+	go func() {
+		defer broker.Unsubscribe(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				// Deliver asynchronously so a slow notifier doesn't cause the
+				// broker to drop events for this subscriber
+				if n, ok := notificationFromEvent(event); ok {
+					go d.Dispatch(ctx, n)
+				}
+			}
+		}
+	}()
+}
+
+// notificationFromEvent converts a broker event into a notification
+func notificationFromEvent(event events.Event) (Notification, bool) {
+	switch data := event.Data.(type) {
+	case store.ChangeEvent:
+		return Notification{Event: event.Type, BlobPath: data.BlobPath, Change: &data}, true
+	case events.ValidationFailure:
+		return Notification{Event: event.Type, BlobPath: data.BlobPath, ValidationFailure: &data}, true
+	case store.Proposal:
+		return Notification{Event: event.Type, BlobPath: data.BlobPath, Proposal: &data}, true
+	default:
+		return Notification{}, false
+	}
+}
+
+// Notifies reports whether a notification would be sent out of the vault,
+// to a notifier other than the inbox, whose deliveries are kept in the store
+func (d *Dispatcher) Notifies(n Notification) bool {
+	if d.enabled != nil && !d.enabled() {
+		return false
+	}
+	for _, r := range d.receivers(n) {
+		if _, inbox := r.notifier.(*InboxNotifier); !inbox {
+			return true
+		}
+	}
+	return false
+}
+
+// receivers returns the routes a notification is delivered to
+func (d *Dispatcher) receivers(n Notification) []route {
+	var channels []string
+	if group := d.groups.Find(n.BlobPath); group != nil {
+		channels = group.Notifiers
+	}
+
+	var receivers []route
+	for _, r := range d.routes {
+		if !r.filter.matches(n) {
+			continue
+		}
+		if len(channels) > 0 && r.name != "" && !contains(channels, r.name) {
+			continue
+		}
+		receivers = append(receivers, r)
+	}
+	return receivers
+}
+
+// Dispatch sends a notification to every matching notifier concurrently and
+// waits for them to finish. Failures are logged.
+func (d *Dispatcher) Dispatch(ctx context.Context, n Notification) {
+	if d.enabled != nil && !d.enabled() {
+		return
+	}
+	if d.owners != nil && n.Owners == nil {
+		n.Owners = d.owners.OwnersFor(n.BlobPath)
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range d.receivers(n) {
+		wg.Add(1)
+		go func(notifier Notifier) {
+			defer wg.Done()
+			if err := notifier.Notify(ctx, n); err != nil {
+				log.Printf("Notifier %s failed for %s: %v", notifier.Name(), n.BlobPath, err)
+			}
+		}(r.notifier)
+	}
+	wg.Wait()
+}
+
+// contains reports whether list contains s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
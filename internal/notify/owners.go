@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"context"
+	"log"
+
+	"github.com/toggle-vault/internal/owners"
+)
+
+// OwnerNotifier e-mails the owners of a file, resolved from the owner rules,
+// about its notifications
+type OwnerNotifier struct {
+	email  *EmailNotifier
+	owners *owners.Resolver
+}
+
+// NewOwnerNotifier creates an owner notifier that sends through email
+func NewOwnerNotifier(email *EmailNotifier, resolver *owners.Resolver) *OwnerNotifier {
+	return &OwnerNotifier{email: email, owners: resolver}
+}
+
+// Name returns the notifier name
+func (o *OwnerNotifier) Name() string {
+	return "owners"
+}
+
+// Notify e-mails every address configured for the file's owners
+func (o *OwnerNotifier) Notify(ctx context.Context, n Notification) error {
+	fileOwners := n.Owners
+	if fileOwners == nil {
+		fileOwners = o.owners.OwnersFor(n.BlobPath)
+	}
+
+	recipients := o.owners.Recipients(fileOwners)
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	subject := "[toggle-vault] " + n.Summary()
+	body := o.email.formatNotification(n) + "\nYou are receiving this because you own this file.\n"
+	for _, to := range recipients {
+		if err := o.email.send(to, subject, body); err != nil {
+			log.Printf("Error sending e-mail to %s: %v", to, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package owners
+
+import (
+	"bufio"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+// Rule assigns owners to the files under a path prefix
+type Rule struct {
+	PathPrefix string   `json:"path_prefix"`
+	Owners     []string `json:"owners"`
+}
+
+// Resolver finds the owners of files from the configured rules and, if one
+// is configured, a tracked OWNERS file. As in CODEOWNERS, the last matching
+// rule wins.
+type Resolver struct {
+	cfg   config.OwnersConfig
+	store store.Store
+
+	mu       sync.Mutex
+	fileHash string // content hash of the OWNERS file the cached rules came from
+	rules    []Rule
+}
+
+// New creates a resolver for the configured owners
+func New(cfg config.OwnersConfig, st store.Store) *Resolver {
+	r := &Resolver{cfg: cfg, store: st}
+	r.rules = r.configRules()
+	return r
+}
+
+// Enabled reports whether any owners are configured
+func (r *Resolver) Enabled() bool {
+	return len(r.cfg.Rules) > 0 || r.cfg.File != ""
+}
+
+// Rules returns the rules in effect, re-reading the OWNERS file if a new
+// version of it has been captured
+func (r *Resolver) Rules() []Rule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cfg.File == "" {
+		return r.rules
+	}
+
+	file, err := r.store.GetFile(r.cfg.File)
+	if err != nil {
+		log.Printf("Error getting OWNERS file %s: %v", r.cfg.File, err)
+		return r.rules
+	}
+	if file == nil || file.IsDeleted {
+		if r.fileHash != "" {
+			r.fileHash = ""
+			r.rules = r.configRules()
+		}
+		return r.rules
+	}
+	if file.ContentHash == r.fileHash {
+		return r.rules
+	}
+
+	version, err := r.store.GetLatestVersion(file.ID)
+	if err != nil || version == nil || version.ContentPending || version.Truncated {
+		log.Printf("OWNERS file %s has no readable content yet", r.cfg.File)
+		return r.rules
+	}
+
+	r.rules = append(r.configRules(), Parse(version.Content)...)
+	r.fileHash = file.ContentHash
+	log.Printf("Loaded %d owner rules from %s", len(r.rules)-len(r.cfg.Rules), r.cfg.File)
+	return r.rules
+}
+
+// OwnersFor returns the owners of a file, or nil if it has none
+func (r *Resolver) OwnersFor(blobPath string) []string {
+	return Match(r.Rules(), blobPath)
+}
+
+// Recipients returns the e-mail addresses to notify for owners: owners that
+// are e-mail addresses themselves, and the addresses configured for teams
+func (r *Resolver) Recipients(owners []string) []string {
+	var recipients []string
+	seen := make(map[string]bool)
+	add := func(email string) {
+		if !seen[email] {
+			seen[email] = true
+			recipients = append(recipients, email)
+		}
+	}
+
+	for _, owner := range owners {
+		if strings.Contains(owner, "@") {
+			add(owner)
+			continue
+		}
+		for _, email := range r.cfg.Teams[owner] {
+			add(email)
+		}
+	}
+	return recipients
+}
+
+// configRules converts the rules from the configuration
+func (r *Resolver) configRules() []Rule {
+	rules := make([]Rule, 0, len(r.cfg.Rules))
+	for _, rule := range r.cfg.Rules {
+		rules = append(rules, Rule{PathPrefix: rule.PathPrefix, Owners: rule.Owners})
+	}
+	return rules
+}
+
+// Match returns the owners of the last rule matching blobPath
+func Match(rules []Rule, blobPath string) []string {
+	var owners []string
+	for _, rule := range rules {
+		if strings.HasPrefix(blobPath, rule.PathPrefix) {
+			owners = rule.Owners
+		}
+	}
+	return owners
+}
+
+// Parse reads OWNERS file content. Each line is a path prefix followed by
+// one or more owners, separated by whitespace; blank lines and lines starting
+// with # are ignored. A prefix of * matches every file.
+func Parse(content string) []Rule {
+	var rules []Rule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		prefix := fields[0]
+		if prefix == "*" {
+			prefix = ""
+		}
+		rules = append(rules, Rule{PathPrefix: prefix, Owners: fields[1:]})
+	}
+	return rules
+}
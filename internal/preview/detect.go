@@ -0,0 +1,114 @@
+// Package preview detects the language of configuration files and renders
+// their content highlighted, so that clients don't have to guess a file's
+// syntax from its name.
+package preview
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/toggle-vault/internal/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// dotenvLine matches a line of a dotenv file: KEY=value, without spaces
+// around the equals sign
+var dotenvLine = regexp.MustCompile(`^(export\s+)?[A-Za-z_][A-Za-z0-9_.]*=`)
+
+// Detect returns the language of a file, one of the diff package's formats
+// such as yaml or dotenv, or "" for plain text. A file named for a format is
+// of that format if its content parses as it; otherwise, and for files
+// named for none, the language is worked out from the content. Content in
+// no known language keeps the language of the file's name.
+func Detect(path string, content []byte) string {
+	byName := diff.Format(path)
+	text := strings.TrimSpace(string(content))
+	if text == "" {
+		return byName
+	}
+	if byName != "" && parses(byName, text) {
+		return byName
+	}
+	if sniffed := sniff(text); sniffed != "" {
+		return sniffed
+	}
+	return byName
+}
+
+// sniff works out the language of trimmed content, or returns "" if it is
+// in none. Formats whose parsers accept almost anything are only tried on
+// content that looks like them.
+func sniff(text string) string {
+	switch text[0] {
+	case '<':
+		if parses(diff.FormatXML, text) {
+			return diff.FormatXML
+		}
+		return ""
+	case '{':
+		if parses(diff.FormatJSON, text) {
+			return diff.FormatJSON
+		}
+	case '[':
+		if parses(diff.FormatJSON, text) {
+			return diff.FormatJSON
+		}
+	}
+
+	if isDotenv(text) {
+		return diff.FormatDotenv
+	}
+	if hasSettings(diff.FormatTOML, text) {
+		return diff.FormatTOML
+	}
+	if text[0] == '[' && hasSettings(diff.FormatINI, text) {
+		return diff.FormatINI
+	}
+	if isYAMLCollection(text) {
+		return diff.FormatYAML
+	}
+	return ""
+}
+
+// parses reports whether content parses as format
+func parses(format, content string) bool {
+	_, err := diff.ParseKeysAs(format, content)
+	return err == nil
+}
+
+// hasSettings reports whether content parses as format and has settings,
+// for formats that content with none, such as only comments, parses as too
+func hasSettings(format, content string) bool {
+	keys, err := diff.ParseKeysAs(format, content)
+	return err == nil && len(keys) > 0
+}
+
+// isDotenv reports whether every line of content that isn't blank or a
+// comment is a KEY=value assignment, and there is one
+func isDotenv(text string) bool {
+	assignments := 0
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !dotenvLine.MatchString(line) {
+			return false
+		}
+		assignments++
+	}
+	return assignments > 0
+}
+
+// isYAMLCollection reports whether content is YAML holding a mapping or a
+// sequence. Any text parses as a YAML string, which doesn't count.
+func isYAMLCollection(text string) bool {
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(text)))
+	var node yaml.Node
+	if err := decoder.Decode(&node); err != nil || len(node.Content) == 0 {
+		return false
+	}
+	kind := node.Content[0].Kind
+	return kind == yaml.MappingNode || kind == yaml.SequenceNode
+}
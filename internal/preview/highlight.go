@@ -0,0 +1,562 @@
+package preview
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/toggle-vault/internal/diff"
+)
+
+// Classes of highlighted text
+const (
+	ClassKey    = "key"
+	ClassString = "string"
+	ClassNumber = "number"
+	// ClassLiteral is true, false, null and the like
+	ClassLiteral = "literal"
+	ClassComment = "comment"
+	// ClassSection is an INI or TOML section header
+	ClassSection     = "section"
+	ClassPunctuation = "punctuation"
+	ClassTag         = "tag"
+	ClassAttribute   = "attribute"
+)
+
+// Token is a run of text of one class in a highlighted line
+type Token struct {
+	// Class is the kind of text, empty for text that isn't highlighted
+	Class string `json:"class,omitempty"`
+	Text  string `json:"text"`
+}
+
+// Line is a highlighted line of content
+type Line struct {
+	// Number is the 1-based line number
+	Number int     `json:"number"`
+	Tokens []Token `json:"tokens"`
+}
+
+// numberPattern matches numbers, and the dates and times of TOML
+var numberPattern = regexp.MustCompile(`^([-+]?(0x[0-9a-fA-F_]+|0o[0-7_]+|0b[01_]+|\d[\d_]*(\.\d[\d_]*)?([eE][-+]?\d+)?|\.\d+([eE][-+]?\d+)?|inf|nan|\.inf|\.nan)|\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?([Zz]|[-+]\d{2}:\d{2})?)?|\d{2}:\d{2}:\d{2}(\.\d+)?)$`)
+
+// literals are the words highlighted as ClassLiteral, in lower case
+var literals = map[string]bool{
+	"true": true, "false": true, "null": true, "~": true,
+	"yes": true, "no": true, "on": true, "off": true,
+}
+
+// Highlight splits content into lines of tokens according to its language,
+// as returned by Detect. Content in no known language is returned as plain
+// text. Constructs that span lines, such as YAML block scalars and XML
+// comments, are followed; others are highlighted line by line.
+func Highlight(language, content string) []Line {
+	texts := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	if len(texts) > 1 && texts[len(texts)-1] == "" {
+		texts = texts[:len(texts)-1]
+	}
+
+	highlight := highlighterFor(language)
+	lines := make([]Line, len(texts))
+	for i, text := range texts {
+		lines[i] = Line{Number: i + 1, Tokens: highlight(text)}
+	}
+	return lines
+}
+
+// highlighterFor returns a function highlighting the lines of a file in
+// language one after the other
+func highlighterFor(language string) func(string) []Token {
+	switch language {
+	case diff.FormatYAML:
+		h := &yamlHighlighter{blockIndent: -1}
+		return h.line
+	case diff.FormatJSON:
+		return func(text string) []Token {
+			var t tokens
+			t.value(text, ':', false)
+			return t
+		}
+	case diff.FormatTOML:
+		return tomlLine
+	case diff.FormatINI:
+		return iniLine
+	case diff.FormatDotenv:
+		h := &dotenvHighlighter{}
+		return h.line
+	case diff.FormatProperties:
+		h := &propertiesHighlighter{}
+		return h.line
+	case diff.FormatXML:
+		h := &xmlHighlighter{}
+		return h.line
+	}
+	return func(text string) []Token {
+		var t tokens
+		t.add("", text)
+		return t
+	}
+}
+
+// tokens builds a highlighted line
+type tokens []Token
+
+// add appends text of class, joining it to the last token if that is of the
+// same class
+func (t *tokens) add(class, text string) {
+	if text == "" {
+		return
+	}
+	if n := len(*t); n > 0 && (*t)[n-1].Class == class {
+		(*t)[n-1].Text += text
+		return
+	}
+	*t = append(*t, Token{Class: class, Text: text})
+}
+
+// indent adds the leading whitespace of text and returns the rest
+func (t *tokens) indent(text string) string {
+	rest := strings.TrimLeft(text, " \t")
+	t.add("", text[:len(text)-len(rest)])
+	return rest
+}
+
+// scalar adds a single value, keeping the whitespace around it plain
+func (t *tokens) scalar(text string) {
+	trimmed := strings.TrimSpace(text)
+	start := strings.Index(text, trimmed)
+	t.add("", text[:start])
+	t.add(classify(trimmed), trimmed)
+	t.add("", text[start+len(trimmed):])
+}
+
+// value adds JSON or TOML values, or a YAML flow collection: strings,
+// numbers, literals and the brackets and commas between them. A string or
+// word followed by keySep is a key. A # outside strings starts a comment if
+// comments is set.
+func (t *tokens) value(text string, keySep byte, comments bool) {
+	punctuation := "{}[]," + string(keySep)
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			j := i
+			for j < len(text) && (text[j] == ' ' || text[j] == '\t') {
+				j++
+			}
+			t.add("", text[i:j])
+			i = j
+		case comments && c == '#':
+			t.add(ClassComment, text[i:])
+			return
+		case c == '"' || c == '\'':
+			j := closingQuote(text, i)
+			class := ClassString
+			if followedBy(text[j:], keySep) {
+				class = ClassKey
+			}
+			t.add(class, text[i:j])
+			i = j
+		case strings.IndexByte(punctuation, c) >= 0:
+			t.add(ClassPunctuation, text[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(text) && !strings.ContainsRune(" \t\"'"+punctuation, rune(text[j])) && !(comments && text[j] == '#') {
+				j++
+			}
+			class := classify(text[i:j])
+			if followedBy(text[j:], keySep) {
+				class = ClassKey
+			}
+			t.add(class, text[i:j])
+			i = j
+		}
+	}
+}
+
+// classify returns the class of a scalar value
+func classify(value string) string {
+	switch {
+	case value == "":
+		return ""
+	case value[0] == '"' || value[0] == '\'':
+		return ClassString
+	case numberPattern.MatchString(value):
+		return ClassNumber
+	case literals[strings.ToLower(value)]:
+		return ClassLiteral
+	}
+	return ClassString
+}
+
+// closingQuote returns the index just after the string starting with a quote
+// at text[start], or the end of text if it isn't closed on the line.
+// Backslashes escape characters in double-quoted strings.
+func closingQuote(text string, start int) int {
+	quote := text[start]
+	for i := start + 1; i < len(text); i++ {
+		if text[i] == '\\' && quote == '"' {
+			i++
+			continue
+		}
+		if text[i] == quote {
+			return i + 1
+		}
+	}
+	return len(text)
+}
+
+// followedBy reports whether text starts with sep after any spaces
+func followedBy(text string, sep byte) bool {
+	text = strings.TrimLeft(text, " \t")
+	return text != "" && text[0] == sep
+}
+
+// splitComment splits a line at a # outside quotes that starts it or follows
+// whitespace, as comments do in YAML, TOML and dotenv files
+func splitComment(text string) (code, comment string) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			i = closingQuote(text, i) - 1
+		case '#':
+			if i == 0 || text[i-1] == ' ' || text[i-1] == '\t' {
+				return text[:i], text[i:]
+			}
+		}
+	}
+	return text, ""
+}
+
+// yamlHighlighter highlights YAML, following block scalars across lines
+type yamlHighlighter struct {
+	// blockIndent is the indentation of the key of the block scalar being
+	// highlighted, or -1 outside block scalars
+	blockIndent int
+}
+
+func (h *yamlHighlighter) line(text string) []Token {
+	var t tokens
+	rest := t.indent(text)
+	indent := len(text) - len(rest)
+
+	if h.blockIndent >= 0 {
+		if rest == "" || indent > h.blockIndent {
+			t.add(ClassString, rest)
+			return t
+		}
+		h.blockIndent = -1
+	}
+
+	if rest == "---" || rest == "..." {
+		t.add(ClassPunctuation, rest)
+		return t
+	}
+	// List items, possibly nested on one line: - - value
+	for rest == "-" || strings.HasPrefix(rest, "- ") {
+		t.add(ClassPunctuation, "-")
+		item := t.indent(rest[1:])
+		indent += len(rest) - len(item)
+		rest = item
+	}
+
+	if key, value, ok := splitYAMLKey(rest); ok {
+		t.add(ClassKey, key)
+		t.add(ClassPunctuation, ":")
+		rest = value
+	}
+
+	code, comment := splitComment(rest)
+	value := strings.TrimSpace(code)
+	switch {
+	case value == "":
+		t.add("", code)
+	case value[0] == '|' || value[0] == '>':
+		t.add("", code[:strings.Index(code, value)])
+		t.add(ClassPunctuation, value)
+		t.add("", code[strings.Index(code, value)+len(value):])
+		h.blockIndent = indent
+	case value[0] == '[' || value[0] == '{':
+		t.value(code, ':', false)
+	default:
+		t.scalar(code)
+	}
+	t.add(ClassComment, comment)
+	return t
+}
+
+// splitYAMLKey splits a mapping entry into its key and what follows the
+// colon, reporting whether text is one
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	if text == "" || strings.HasPrefix(text, "#") {
+		return "", "", false
+	}
+	end := -1
+	if text[0] == '"' || text[0] == '\'' {
+		closed := closingQuote(text, 0)
+		if closed < len(text) && text[closed] == ':' {
+			end = closed
+		}
+	} else if text[0] != '[' && text[0] != '{' {
+		end = strings.Index(text, ": ")
+		if end < 0 && strings.HasSuffix(text, ":") {
+			end = len(text) - 1
+		}
+		if end >= 0 && strings.Contains(text[:end], " #") {
+			end = -1
+		}
+	}
+	if end <= 0 || (end+1 < len(text) && text[end+1] != ' ' && text[end+1] != '\t') {
+		return "", "", false
+	}
+	return text[:end], text[end+1:], true
+}
+
+// tomlLine highlights a line of TOML
+func tomlLine(text string) []Token {
+	var t tokens
+	rest := t.indent(text)
+	code, comment := splitComment(rest)
+	switch {
+	case strings.HasPrefix(code, "["):
+		trimmed := strings.TrimRight(code, " \t")
+		t.add(ClassSection, trimmed)
+		t.add("", code[len(trimmed):])
+	default:
+		if eq := assignment(code); eq > 0 {
+			t.add(ClassKey, strings.TrimRight(code[:eq], " \t"))
+			t.add("", code[len(strings.TrimRight(code[:eq], " \t")):eq])
+			t.add(ClassPunctuation, "=")
+			code = code[eq+1:]
+		}
+		t.value(code, '=', false)
+	}
+	t.add(ClassComment, comment)
+	return t
+}
+
+// assignment returns the index of the = after a TOML key, which may be
+// quoted and dotted, or -1 if the line isn't an assignment
+func assignment(text string) int {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			i = closingQuote(text, i) - 1
+		case '=':
+			return i
+		case '[', '{', ',', ']', '}':
+			return -1
+		}
+	}
+	return -1
+}
+
+// iniLine highlights a line of an INI file
+func iniLine(text string) []Token {
+	var t tokens
+	rest := t.indent(text)
+	switch {
+	case rest == "":
+	case rest[0] == ';' || rest[0] == '#':
+		t.add(ClassComment, rest)
+	case rest[0] == '[':
+		t.add(ClassSection, rest)
+	default:
+		sep := strings.IndexAny(rest, "=:")
+		if sep <= 0 {
+			t.add("", rest)
+			break
+		}
+		key := strings.TrimRight(rest[:sep], " \t")
+		t.add(ClassKey, key)
+		t.add("", rest[len(key):sep])
+		t.add(ClassPunctuation, rest[sep:sep+1])
+		t.scalar(rest[sep+1:])
+	}
+	return t
+}
+
+// dotenvHighlighter highlights dotenv files, following double-quoted values
+// across lines
+type dotenvHighlighter struct {
+	inQuote bool
+}
+
+func (h *dotenvHighlighter) line(text string) []Token {
+	var t tokens
+	if h.inQuote {
+		end := closingQuote(`"`+text, 0) - 1
+		t.add(ClassString, text[:end])
+		h.inQuote = end == len(text) && !strings.HasSuffix(text, `"`)
+		code, comment := splitComment(text[end:])
+		t.add("", code)
+		t.add(ClassComment, comment)
+		return t
+	}
+
+	rest := t.indent(text)
+	if rest == "" || rest[0] == '#' {
+		t.add(ClassComment, rest)
+		return t
+	}
+	if strings.HasPrefix(rest, "export ") {
+		t.add(ClassLiteral, "export")
+		rest = t.indent(rest[len("export"):])
+	}
+	eq := strings.IndexByte(rest, '=')
+	if eq <= 0 {
+		t.add("", rest)
+		return t
+	}
+	t.add(ClassKey, rest[:eq])
+	t.add(ClassPunctuation, "=")
+	rest = rest[eq+1:]
+
+	if strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'") {
+		end := closingQuote(rest, 0)
+		t.add(ClassString, rest[:end])
+		h.inQuote = rest[0] == '"' && (end == 1 || rest[end-1] != '"')
+		code, comment := splitComment(rest[end:])
+		t.add("", code)
+		t.add(ClassComment, comment)
+		return t
+	}
+	code, comment := splitComment(rest)
+	t.scalar(code)
+	t.add(ClassComment, comment)
+	return t
+}
+
+// propertiesHighlighter highlights Java .properties files, following values
+// continued on the next line with a backslash
+type propertiesHighlighter struct {
+	continued bool
+}
+
+func (h *propertiesHighlighter) line(text string) []Token {
+	var t tokens
+	continued := h.continued
+	h.continued = strings.HasSuffix(text, `\`) && !strings.HasSuffix(text, `\\`)
+	if continued {
+		t.add(ClassString, text)
+		return t
+	}
+
+	rest := strings.TrimLeft(text, " \t\f")
+	t.add("", text[:len(text)-len(rest)])
+	if rest == "" || rest[0] == '#' || rest[0] == '!' {
+		t.add(ClassComment, rest)
+		h.continued = false
+		return t
+	}
+
+	end := len(rest)
+	for i := 0; i < len(rest); i++ {
+		if rest[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", rest[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+	t.add(ClassKey, rest[:end])
+	rest = rest[end:]
+	value := strings.TrimLeft(rest, " \t\f")
+	t.add("", rest[:len(rest)-len(value)])
+	if value != "" && (value[0] == '=' || value[0] == ':') {
+		t.add(ClassPunctuation, value[:1])
+		rest = value[1:]
+		value = strings.TrimLeft(rest, " \t\f")
+		t.add("", rest[:len(rest)-len(value)])
+	}
+	t.add(ClassString, value)
+	return t
+}
+
+// xmlHighlighter highlights XML, following comments and tags across lines
+type xmlHighlighter struct {
+	inComment bool
+	inTag     bool
+}
+
+func (h *xmlHighlighter) line(text string) []Token {
+	var t tokens
+	for i := 0; i < len(text); {
+		switch {
+		case h.inComment:
+			end := strings.Index(text[i:], "-->")
+			if end < 0 {
+				t.add(ClassComment, text[i:])
+				return t
+			}
+			t.add(ClassComment, text[i:i+end+3])
+			i += end + 3
+			h.inComment = false
+		case h.inTag:
+			i = h.tag(&t, text, i)
+		case strings.HasPrefix(text[i:], "<!--"):
+			t.add(ClassComment, "<!--")
+			i += 4
+			h.inComment = true
+		case text[i] == '<':
+			j := i + 1
+			if j < len(text) && strings.IndexByte("/?!", text[j]) >= 0 {
+				j++
+			}
+			t.add(ClassPunctuation, text[i:j])
+			k := j
+			for k < len(text) && !strings.ContainsRune(" \t/>", rune(text[k])) {
+				k++
+			}
+			t.add(ClassTag, text[j:k])
+			i = k
+			h.inTag = true
+		default:
+			end := strings.IndexByte(text[i:], '<')
+			if end < 0 {
+				end = len(text) - i
+			}
+			t.add("", text[i:i+end])
+			i += end
+		}
+	}
+	return t
+}
+
+// tag highlights the attributes and end of a tag from text[i], returning
+// where it stopped
+func (h *xmlHighlighter) tag(t *tokens, text string, i int) int {
+	c := text[i]
+	switch {
+	case c == ' ' || c == '\t':
+		t.add("", text[i:i+1])
+		return i + 1
+	case c == '>':
+		t.add(ClassPunctuation, ">")
+		h.inTag = false
+		return i + 1
+	case (c == '/' || c == '?') && strings.HasPrefix(text[i+1:], ">"):
+		t.add(ClassPunctuation, text[i:i+2])
+		h.inTag = false
+		return i + 2
+	case c == '=':
+		t.add(ClassPunctuation, "=")
+		return i + 1
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(text[i+1:], c)
+		if end < 0 {
+			t.add(ClassString, text[i:])
+			return len(text)
+		}
+		t.add(ClassString, text[i:i+end+2])
+		return i + end + 2
+	}
+	j := i + 1
+	for j < len(text) && !strings.ContainsRune(" \t=>/?\"'", rune(text[j])) {
+		j++
+	}
+	t.add(ClassAttribute, text[i:j])
+	return j
+}
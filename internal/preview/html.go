@@ -0,0 +1,77 @@
+package preview
+
+import (
+	"html/template"
+	"io"
+)
+
+// HTMLOptions describe content rendered by RenderHTML
+type HTMLOptions struct {
+	// Title heads the preview, usually the file path
+	Title string
+	// Label names the version shown, e.g. "v4"
+	Label string
+	// Language is the content's language, as returned by Detect
+	Language string
+	// Truncated notes that only the start of the content is shown
+	Truncated bool
+	// Link, if set, is shown as a link to the version in the web UI
+	Link string
+}
+
+// classColors are the text colors of the highlighted classes
+var classColors = map[string]string{
+	ClassKey:         "#0550ae",
+	ClassString:      "#0a3069",
+	ClassNumber:      "#953800",
+	ClassLiteral:     "#cf222e",
+	ClassComment:     "#59636e",
+	ClassSection:     "#8250df",
+	ClassPunctuation: "#59636e",
+	ClassTag:         "#116329",
+	ClassAttribute:   "#0550ae",
+}
+
+// previewHTML renders highlighted content with inline styles only, like the
+// HTML diffs, so that it can be embedded as is
+var previewHTML = template.Must(template.New("preview").Funcs(template.FuncMap{
+	"style": func(class string) template.CSS {
+		color, ok := classColors[class]
+		if !ok {
+			return ""
+		}
+		if class == ClassComment {
+			return template.CSS("color:" + color + ";font-style:italic;")
+		}
+		return template.CSS("color:" + color + ";")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="margin:0;padding:16px;font-family:-apple-system,Segoe UI,Helvetica,Arial,sans-serif;font-size:14px;color:#1f2328;background:#ffffff;">
+<div style="font-size:16px;font-weight:600;margin-bottom:4px;">{{.Title}}</div>
+<div style="color:#59636e;margin-bottom:12px;">{{.Label}}
+{{- if .Language}} &middot; {{.Language}}{{else}} &middot; plain text{{end}}
+{{- if .Link}} &middot; <a href="{{.Link}}" style="color:#0969da;">View in Toggle Vault</a>{{end}}</div>
+{{- if .Truncated}}
+<p style="color:#9a6700;">The file exceeds the content size limit; only its start is shown.</p>
+{{- end}}
+<table cellpadding="0" cellspacing="0" style="border-collapse:collapse;width:100%;font-family:Consolas,Menlo,monospace;font-size:12px;border:1px solid #d1d9e0;">
+{{- range .Lines}}
+<tr><td style="padding:0 8px;color:#59636e;text-align:right;user-select:none;">{{.Number}}</td><td style="padding:0 8px;white-space:pre-wrap;">
+{{- range .Tokens}}{{if .Class}}<span style="{{style .Class}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{end -}}
+</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML writes content highlighted as opts.Language as a standalone
+// HTML document with inline styles and line numbers
+func RenderHTML(w io.Writer, content string, opts HTMLOptions) error {
+	return previewHTML.Execute(w, struct {
+		HTMLOptions
+		Lines []Line
+	}{opts, Highlight(opts.Language, content)})
+}
@@ -0,0 +1,348 @@
+// Package replica keeps a copy of the SQLite database in blob storage and
+// restores it on start, so a single node survives losing its disk without
+// a separate database server.
+//
+// The replica is made of generations, each a copy of the database followed
+// by the write-ahead log shipped since, so only the changes are uploaded
+// once a generation has started:
+//
+//	<target>/<generation>/database.gz
+//	<target>/<generation>/wal/<log>-<offset>.gz
+package replica
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+const (
+	// stopTimeout bounds the last shipment made when the replicator stops
+	stopTimeout = time.Minute
+	// walCheckpointSize is the size the log is checkpointed and started over
+	// at, about SQLite's own automatic checkpoint of 1000 pages
+	walCheckpointSize = 4 << 20
+	// generationInterval is how long a generation is shipped to before a
+	// new one is started from a fresh copy, which bounds the log replayed
+	// by a restore
+	generationInterval = 24 * time.Hour
+)
+
+// databaseName is the copy of the database that starts a generation
+const databaseName = "database.gz"
+
+// Database is a database whose file and write-ahead log can be copied
+type Database interface {
+	CopyDatabase(ctx context.Context, w io.Writer) error
+	CopyWAL(ctx context.Context, pos store.WALPosition, w io.Writer, checkpointSize int64) (store.WALPosition, error)
+}
+
+// Replicator ships the database's write-ahead log to blob storage
+type Replicator struct {
+	db       Database
+	client   *blob.Client
+	target   string
+	interval time.Duration
+
+	// generation is the generation being shipped to, empty until the
+	// database has been copied to one; started is when that was
+	generation string
+	started    time.Time
+	// pos is how far the log has been shipped
+	pos    store.WALPosition
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a replicator that ships db to target, a path as
+// storageaccount/container/path that the generations are written under,
+// every interval
+func New(db Database, client *blob.Client, target string, interval time.Duration) *Replicator {
+	return &Replicator{db: db, client: client, target: target, interval: interval}
+}
+
+// Restore restores the latest generation of the replica at target to
+// dbPath if there is no database at dbPath yet. It reports whether it
+// restored one; a missing replica isn't an error, the database then starts
+// empty. Blobs are streamed to disk, so the database needn't fit in memory.
+func Restore(ctx context.Context, client *blob.Client, target, dbPath string) (bool, error) {
+	if _, err := os.Stat(dbPath); err == nil || !os.IsNotExist(err) {
+		return false, err
+	}
+
+	names, err := list(ctx, client, target)
+	if err != nil {
+		return false, err
+	}
+	// A generation without a copy of the database was cut short starting
+	var generation string
+	for _, name := range names {
+		if g, file, _ := strings.Cut(name, "/"); file == databaseName && g > generation {
+			generation = g
+		}
+	}
+	if generation == "" {
+		return false, nil
+	}
+
+	// Restore next to the database and rename, so a failed restore doesn't
+	// leave a partial database behind
+	tmp := dbPath + ".restore"
+	err = restore(ctx, client, target, generation, names, tmp)
+	if err == nil {
+		err = os.Rename(tmp, dbPath)
+	}
+	if err != nil {
+		for _, path := range []string{tmp, tmp + "-wal", tmp + "-shm"} {
+			os.Remove(path)
+		}
+		return false, fmt.Errorf("failed to restore database: %w", err)
+	}
+	return true, nil
+}
+
+// restore writes the copy of the database in generation to path, then
+// applies the logs shipped to the generation in order
+func restore(ctx context.Context, client *blob.Client, target, generation string, names []string, path string) error {
+	if err := download(ctx, client, target+"/"+generation+"/"+databaseName, path, false); err != nil {
+		return err
+	}
+
+	// Segments are named by log and offset, so their sorted names are in
+	// the order they were shipped
+	prefix := generation + "/wal/"
+	var current string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		wal, _, _ := strings.Cut(strings.TrimPrefix(name, prefix), "-")
+		if current != "" && wal != current {
+			if err := store.ApplyWAL(path); err != nil {
+				return err
+			}
+		}
+		current = wal
+		if err := download(ctx, client, target+"/"+name, path+"-wal", true); err != nil {
+			return err
+		}
+	}
+	if current != "" {
+		return store.ApplyWAL(path)
+	}
+	return nil
+}
+
+// download decompresses the blob at fullPath into the file at path,
+// appending to the file if appending is set
+func download(ctx context.Context, client *blob.Client, fullPath, path string, appending bool) error {
+	body, err := client.OpenBlob(ctx, fullPath)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fullPath, err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, zr)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fullPath, err)
+	}
+	return nil
+}
+
+// list returns the names of the replica's blobs relative to target, sorted
+func list(ctx context.Context, client *blob.Client, target string) ([]string, error) {
+	account, container, prefix, err := blob.ParseFullPath(target)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := client.ListBlobsWithPrefix(ctx, account, container, prefix+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(blobs))
+	for i, b := range blobs {
+		names[i] = strings.TrimPrefix(b.Path, prefix+"/")
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Start ships the log every interval until Stop is called
+func (r *Replicator) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Replicate(ctx); err != nil {
+					log.Printf("Error replicating database: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the shipping loop and ships the log a last time, so the replica
+// has every change made before shutdown. Call it before closing the
+// database.
+func (r *Replicator) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := r.Replicate(ctx); err != nil {
+		log.Printf("Error replicating database: %v", err)
+	}
+}
+
+// Replicate ships the log written since the last call. A new generation is
+// started from a copy of the database first if there is none yet, the
+// current one is older than generationInterval, or part of the log was
+// lost, for example because a checkpointed log failed to upload.
+func (r *Replicator) Replicate(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation == "" || time.Since(r.started) >= generationInterval {
+		if err := r.startGeneration(ctx); err != nil {
+			return err
+		}
+	}
+
+	err := r.shipWAL(ctx)
+	if errors.Is(err, store.ErrWALRestarted) {
+		r.generation = ""
+	}
+	return err
+}
+
+// startGeneration copies the database to a new generation, then deletes
+// the earlier ones
+func (r *Replicator) startGeneration(ctx context.Context) error {
+	// Hex nanoseconds sort in the order generations were started
+	generation := fmt.Sprintf("%016x", time.Now().UnixNano())
+	err := r.upload(ctx, generation+"/"+databaseName, func(w io.Writer) error {
+		return r.db.CopyDatabase(ctx, w)
+	})
+	if err != nil {
+		return err
+	}
+	r.generation, r.started, r.pos = generation, time.Now(), store.WALPosition{}
+
+	names, err := list(ctx, r.client, r.target)
+	if err != nil {
+		log.Printf("Error listing earlier replica generations: %v", err)
+		return nil
+	}
+	for _, name := range names {
+		if g, _, _ := strings.Cut(name, "/"); g < generation {
+			if err := r.client.DeleteBlob(ctx, r.target+"/"+name); err != nil {
+				log.Printf("Error deleting earlier replica generation: %v", err)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// shipWAL uploads the log written since r.pos as a segment of the current
+// generation
+func (r *Replicator) shipWAL(ctx context.Context) error {
+	// The log is copied to a file, so writes wait for a local copy but not
+	// for the upload
+	f, err := os.CreateTemp("", "toggle-vault-wal")
+	if err != nil {
+		return fmt.Errorf("failed to create log copy: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	next, err := r.db.CopyWAL(ctx, r.pos, f, walCheckpointSize)
+	if err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil && size > 0 {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			name := fmt.Sprintf("%s/wal/%08d-%016x.gz", r.generation, r.pos.Log, r.pos.Offset)
+			err = r.upload(ctx, name, func(w io.Writer) error {
+				_, err := io.Copy(w, f)
+				return err
+			})
+		}
+	}
+	if err != nil {
+		// A checkpointed log can't be copied again, so what it held is only
+		// in a new copy of the database
+		if next.Log != r.pos.Log {
+			r.generation = ""
+		}
+		return err
+	}
+	r.pos = next
+	return nil
+}
+
+// upload streams what write writes, gzip-compressed, to the blob name
+// under the target
+func (r *Replicator) upload(ctx context.Context, name string, write func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		zw := gzip.NewWriter(pw)
+		err := write(zw)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+		written <- err
+	}()
+
+	err := r.client.UploadStream(ctx, r.target+"/"+name, pr)
+	// An upload that stopped early leaves the writer blocked until the pipe
+	// is closed
+	pr.CloseWithError(err)
+	if writeErr := <-written; err == nil && writeErr != nil {
+		err = fmt.Errorf("failed to copy %s: %w", name, writeErr)
+	}
+	return err
+}
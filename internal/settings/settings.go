@@ -0,0 +1,213 @@
+// Package settings switches the vault's own optional behaviors at runtime
+// from a tracked settings file. The file is a blob like any other: its
+// changes are captured, diffed and can be restored, and when a new version
+// is recorded the change event is picked up from the broker and applied.
+package settings
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are the behaviors that can be switched at runtime
+type Settings struct {
+	// Notifications delivers notifications to notifiers, e-mail and inboxes
+	Notifications bool `json:"notifications"`
+	// KeyChanges lists the settings that changed in diffs
+	KeyChanges bool `json:"key_changes"`
+}
+
+// Defaults are the settings without a settings file, or for those it leaves
+// out: everything on
+func Defaults() Settings {
+	return Settings{Notifications: true, KeyChanges: true}
+}
+
+// fileSettings is the format of the settings file. Pointers tell settings
+// left out from those switched off.
+type fileSettings struct {
+	Notifications *bool `yaml:"notifications"`
+	KeyChanges    *bool `yaml:"key_changes"`
+}
+
+// Parse reads settings file content, a YAML mapping such as
+//
+//	notifications: false
+//	key_changes: true
+//
+// Unknown keys are an error, so that a misspelt setting isn't ignored.
+func Parse(content string) (Settings, error) {
+	var parsed fileSettings
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&parsed); err != nil && !errors.Is(err, io.EOF) {
+		return Settings{}, err
+	}
+
+	settings := Defaults()
+	if parsed.Notifications != nil {
+		settings.Notifications = *parsed.Notifications
+	}
+	if parsed.KeyChanges != nil {
+		settings.KeyChanges = *parsed.KeyChanges
+	}
+	return settings, nil
+}
+
+// State is the settings in effect and where they came from
+type State struct {
+	Settings
+	// File is the blob path of the settings file, if one is configured
+	File string `json:"file,omitempty"`
+	// VersionID is the version of the file the settings were read from
+	VersionID int64      `json:"version_id,omitempty"`
+	LoadedAt  *time.Time `json:"loaded_at,omitempty"`
+	// Error is why the latest version of the file couldn't be applied. The
+	// settings from before stay in effect.
+	Error string `json:"error,omitempty"`
+}
+
+// Watcher keeps the settings from the latest captured version of the
+// settings file
+type Watcher struct {
+	path  string
+	store store.Store
+
+	mu    sync.RWMutex
+	state State
+}
+
+// New creates a watcher for the settings file at path, a full blob path.
+// The settings are the defaults until Start loads the file.
+func New(path string, st store.Store) *Watcher {
+	return &Watcher{
+		path:  path,
+		store: st,
+		state: State{Settings: Defaults(), File: path},
+	}
+}
+
+// Get returns the settings in effect. A nil watcher returns the defaults.
+func (w *Watcher) Get() Settings {
+	if w == nil {
+		return Defaults()
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.state.Settings
+}
+
+// State returns the settings in effect and where they came from. A nil
+// watcher returns the defaults.
+func (w *Watcher) State() State {
+	if w == nil {
+		return State{Settings: Defaults()}
+	}
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.state
+}
+
+// Start loads the settings file and applies its new versions as their
+// change events are published, until ctx is cancelled or the broker is
+// closed. The subscription is made before Start returns.
+func (w *Watcher) Start(ctx context.Context, broker *events.Broker) {
+	w.load()
+	ch := broker.Subscribe()
+
+	go func() {
+		defer broker.Unsubscribe(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				change, isChange := event.Data.(store.ChangeEvent)
+				if event.Type == events.EventChange && isChange && change.BlobPath == w.path {
+					w.load()
+				}
+			}
+		}
+	}()
+}
+
+// load applies the latest version of the settings file, or the defaults if
+// the file doesn't exist or was deleted
+func (w *Watcher) load() {
+	file, err := w.store.GetFile(w.path)
+	if err != nil {
+		log.Printf("Error getting settings file %s: %v", w.path, err)
+		return
+	}
+	if file == nil || file.IsDeleted {
+		w.apply(State{Settings: Defaults(), File: w.path})
+		return
+	}
+
+	version, err := w.store.GetLatestVersion(file.ID)
+	if err != nil {
+		log.Printf("Error getting settings file %s: %v", w.path, err)
+		return
+	}
+	if version == nil || version.ContentPending || version.Truncated {
+		w.fail(fmt.Errorf("the settings file has no readable content yet"))
+		return
+	}
+	if w.State().VersionID == version.ID {
+		return
+	}
+
+	settings, err := Parse(version.Content)
+	if err != nil {
+		w.fail(fmt.Errorf("version %d: %w", version.ID, err))
+		return
+	}
+	now := time.Now().UTC()
+	w.apply(State{Settings: settings, File: w.path, VersionID: version.ID, LoadedAt: &now})
+}
+
+// apply puts state into effect, logging the settings that changed
+func (w *Watcher) apply(state State) {
+	w.mu.Lock()
+	previous := w.state.Settings
+	w.state = state
+	w.mu.Unlock()
+
+	if state.Notifications != previous.Notifications {
+		log.Printf("Settings file %s switched notifications %s", w.path, onOff(state.Notifications))
+	}
+	if state.KeyChanges != previous.KeyChanges {
+		log.Printf("Settings file %s switched key-level changes %s", w.path, onOff(state.KeyChanges))
+	}
+}
+
+// fail records why the settings file couldn't be applied, keeping the
+// settings in effect
+func (w *Watcher) fail(err error) {
+	log.Printf("Error applying settings file %s: %v", w.path, err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state.Error = err.Error()
+}
+
+// onOff describes a switched setting in logs
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -1,34 +1,198 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/toggle-vault/internal/clock"
 )
 
-// SQLiteStore implements the Store interface using SQLite
+const (
+	// busyTimeoutMs is how long SQLite itself waits for a lock
+	busyTimeoutMs = 5000
+	// busyRetries is how many more times a write is tried when SQLite still
+	// reports the database busy, e.g. when a WAL snapshot went stale
+	busyRetries = 5
+	// busyBackoff is the base delay between retries, doubled each time
+	busyBackoff = 20 * time.Millisecond
+	// connMaxIdleTime closes pooled connections that have been idle this long
+	connMaxIdleTime = 5 * time.Minute
+)
+
+// SQLiteStore implements the Store interface using SQLite. Writes go through
+// a pool of one connection, which queues them, so the syncer and API never
+// contend for the write lock; reads use a separate read-only pool and, in
+// WAL mode, proceed alongside the writer.
 type SQLiteStore struct {
-	db *sql.DB
+	db         *sql.DB // writer
+	readDB     *sql.DB
+	path       string
+	stmts      statements
+	signer     VersionSigner
+	appendOnly bool
+	// clock timestamps subscriptions, watches, proposals and other records
+	clock clock.Clock
+}
+
+// statements are the prepared statements of the sync hot path
+type statements struct {
+	getFile          *sql.Stmt
+	getVersion       *sql.Stmt
+	getLatestVersion *sql.Stmt
+	upsertFile       *sql.Stmt
+	createVersion    *sql.Stmt
 }
 
 // NewSQLiteStore creates a new SQLite store and initializes the schema
 func NewSQLiteStore(dbPath string) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d", dbPath, busyTimeoutMs)
+
+	// Immediate transactions take the write lock up front, so they wait for it
+	// rather than failing when they first write
+	db, err := sql.Open("sqlite3", dsn+"&_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db.SetMaxOpenConns(1)
 
-	store := &SQLiteStore{db: db}
+	store := &SQLiteStore{db: db, readDB: db, path: dbPath, clock: clock.Real}
 	if err := store.migrate(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// An in-memory database only exists on its own connection
+	if dbPath != ":memory:" && !strings.Contains(dbPath, "mode=memory") {
+		if store.readDB, err = sql.Open("sqlite3", dsn+"&_query_only=true"); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		readers := runtime.NumCPU()
+		if readers < 4 {
+			readers = 4
+		}
+		store.readDB.SetMaxOpenConns(readers)
+		store.readDB.SetMaxIdleConns(readers)
+		store.readDB.SetConnMaxIdleTime(connMaxIdleTime)
+	}
+
+	if store.appendOnly, err = store.hasAppendOnlyTriggers(); err == nil {
+		err = store.prepare()
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	return store, nil
 }
 
+// prepare prepares the statements of the sync hot path
+func (s *SQLiteStore) prepare() error {
+	prepared := []struct {
+		stmt  **sql.Stmt
+		db    *sql.DB
+		query string
+	}{
+		{&s.stmts.getFile, s.readDB, `
+			SELECT id, blob_path, storage_account, container, path, etag, content_hash, last_modified, is_deleted, is_archived, COALESCE(archived_by, ''), archived_at, language
+			FROM files WHERE blob_path = ?`},
+		{&s.stmts.getVersion, s.readDB, `SELECT ` + versionColumns + ` FROM versions v WHERE v.id = ?`},
+		{&s.stmts.getLatestVersion, s.readDB, `
+			SELECT ` + versionColumns + `
+			FROM versions v WHERE v.file_id = ?
+			ORDER BY v.captured_at DESC LIMIT 1`},
+		{&s.stmts.upsertFile, s.db, `
+			INSERT INTO files (blob_path, storage_account, container, path, etag, content_hash, last_modified, is_deleted, language)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(blob_path) DO UPDATE SET
+				etag = excluded.etag,
+				content_hash = excluded.content_hash,
+				last_modified = excluded.last_modified,
+				is_deleted = excluded.is_deleted,
+				language = CASE WHEN excluded.language = '' THEN files.language ELSE excluded.language END
+			RETURNING id`},
+		// A capture already recorded by another sync entry point is skipped,
+		// checked in the same statement so no write can come in between, and
+		// by idx_versions_capture where it could be created. A file
+		// recreated after a deletion starts over, so its captures are only
+		// compared with those since the deletion.
+		{&s.stmts.createVersion, s.db, `
+			INSERT INTO versions (file_id, content, content_hash, change_type, captured_at, blob_etag, blob_last_modified,
+				content_pending, size, truncated, snapshot_id, author, comment, deleted_version_id, signature, signature_key_id,
+				encrypted, restored_from_version_id)
+			SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14, ?15, ?16, ?17, ?18
+			WHERE ?4 IN ('deleted', 'checkpoint') OR COALESCE(?6, '') = '' OR NOT EXISTS (
+				SELECT 1 FROM versions
+				WHERE file_id = ?1 AND content_hash = ?3 AND blob_etag = ?6 AND COALESCE(deleted_version_id, 0) = COALESCE(?14, 0)
+					AND change_type NOT IN ('deleted', 'checkpoint')
+			)
+			ON CONFLICT DO NOTHING`},
+	}
+	for _, p := range prepared {
+		stmt, err := p.db.Prepare(p.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		*p.stmt = stmt
+	}
+	return nil
+}
+
+// exec runs a write statement, retrying while the database is busy
+func (s *SQLiteStore) exec(query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := retryBusy(func() error {
+		var err error
+		result, err = s.db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
+
+// inTx runs fn in a transaction on the writer, committing if it succeeds.
+// The whole transaction is retried while the database is busy.
+func (s *SQLiteStore) inTx(fn func(tx *sql.Tx) error) error {
+	return retryBusy(func() error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// retryBusy calls fn until it doesn't fail with a busy or locked database,
+// backing off with jitter so that retrying writers don't collide again
+func retryBusy(fn func() error) error {
+	delay := busyBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt == busyRetries || !isBusy(err) {
+			return err
+		}
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		delay *= 2
+	}
+}
+
 // migrate creates the database schema if it doesn't exist
 func (s *SQLiteStore) migrate() error {
 	schema := `
@@ -47,34 +211,651 @@ func (s *SQLiteStore) migrate() error {
 		content TEXT NOT NULL,
 		content_hash TEXT NOT NULL,
 		change_type TEXT NOT NULL,
-		captured_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		captured_at DATETIME,
 		blob_etag TEXT,
 		blob_last_modified DATETIME
 	);
 
+	CREATE TABLE IF NOT EXISTS subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		path_prefix TEXT NOT NULL DEFAULT '',
+		mode TEXT NOT NULL,
+		created_at DATETIME,
+		last_sent_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS shadow_changes (
+		blob_path TEXT PRIMARY KEY,
+		change_type TEXT NOT NULL,
+		etag TEXT,
+		content_hash TEXT,
+		size INTEGER,
+		detected_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS sync_errors (
+		blob_path TEXT PRIMARY KEY,
+		error TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 1,
+		first_seen_at DATETIME,
+		last_seen_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		status TEXT NOT NULL,
+		admin BOOLEAN DEFAULT FALSE,
+		created_by TEXT,
+		done INTEGER DEFAULT 0,
+		total INTEGER DEFAULT 0,
+		result TEXT,
+		error TEXT,
+		output BLOB,
+		output_type TEXT,
+		output_name TEXT,
+		created_at DATETIME,
+		started_at DATETIME,
+		finished_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS view_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_hash TEXT NOT NULL UNIQUE,
+		blob_path TEXT NOT NULL,
+		from_version_id INTEGER,
+		to_version_id INTEGER,
+		created_by TEXT,
+		created_at DATETIME,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT,
+		location TEXT,
+		body BLOB,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (scope, key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS sync_pauses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		storage_account TEXT NOT NULL,
+		container TEXT NOT NULL DEFAULT '',
+		reason TEXT,
+		paused_by TEXT,
+		paused_at DATETIME,
+		UNIQUE (storage_account, container)
+	);
+
+	CREATE TABLE IF NOT EXISTS watches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		exact BOOLEAN DEFAULT FALSE,
+		created_at DATETIME,
+		UNIQUE(user_id, path, exact)
+	);
+
+	CREATE TABLE IF NOT EXISTS inbox_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id TEXT NOT NULL,
+		version_id INTEGER NOT NULL REFERENCES versions(id),
+		read_at DATETIME,
+		UNIQUE(user_id, version_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS proposals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		blob_path TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		content TEXT NOT NULL,
+		base_version_id INTEGER,
+		base_etag TEXT,
+		restore_version_id INTEGER,
+		author TEXT NOT NULL,
+		comment TEXT,
+		status TEXT NOT NULL,
+		reviewer TEXT,
+		review_comment TEXT,
+		error TEXT,
+		version_id INTEGER,
+		created_at DATETIME,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS tracking_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		storage_account TEXT NOT NULL,
+		container TEXT NOT NULL,
+		prefix TEXT NOT NULL DEFAULT '',
+		patterns TEXT NOT NULL DEFAULT '',
+		created_by TEXT,
+		created_at DATETIME,
+		updated_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS file_labels (
+		file_id INTEGER NOT NULL REFERENCES files(id),
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (file_id, key)
+	);
+
+	CREATE TABLE IF NOT EXISTS version_tags (
+		version_id INTEGER NOT NULL REFERENCES versions(id),
+		tag TEXT NOT NULL,
+		tagged_by TEXT,
+		tagged_at DATETIME,
+		PRIMARY KEY (version_id, tag)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_version_tags_tag ON version_tags(tag);
+
+	CREATE TABLE IF NOT EXISTS version_impacts (
+		version_id INTEGER NOT NULL REFERENCES versions(id),
+		metric TEXT NOT NULL,
+		unit TEXT,
+		before_value REAL,
+		after_value REAL,
+		window_length TEXT,
+		measured_at DATETIME,
+		error TEXT,
+		PRIMARY KEY (version_id, metric)
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_versions_file_id ON versions(file_id);
+	CREATE INDEX IF NOT EXISTS idx_inbox_items_user_id ON inbox_items(user_id);
 	CREATE INDEX IF NOT EXISTS idx_versions_captured_at ON versions(captured_at);
 	CREATE INDEX IF NOT EXISTS idx_files_blob_path ON files(blob_path);
+	CREATE INDEX IF NOT EXISTS idx_proposals_status ON proposals(status);
+	CREATE INDEX IF NOT EXISTS idx_versions_file_latest ON versions(file_id, captured_at, change_type);
 	`
 
-	_, err := s.db.Exec(schema)
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	columns := []struct{ table, column, definition string }{
+		{"versions", "content_pending", "BOOLEAN DEFAULT FALSE"},
+		{"versions", "size", "INTEGER"},
+		{"versions", "truncated", "BOOLEAN DEFAULT FALSE"},
+		{"versions", "snapshot_id", "TEXT"},
+		{"versions", "author", "TEXT"},
+		{"versions", "comment", "TEXT"},
+		{"versions", "deleted_version_id", "INTEGER"},
+		{"versions", "signature", "TEXT"},
+		{"versions", "signature_key_id", "TEXT"},
+		{"versions", "encrypted", "BOOLEAN DEFAULT FALSE"},
+		{"versions", "restored_from_version_id", "INTEGER"},
+		{"files", "storage_account", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "container", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "path", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "is_archived", "BOOLEAN DEFAULT FALSE"},
+		{"files", "archived_by", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "archived_at", "DATETIME"},
+		{"files", "language", "TEXT NOT NULL DEFAULT ''"},
+		{"proposals", "pull_request_number", "INTEGER"},
+		{"proposals", "pull_request_url", "TEXT"},
+		{"jobs", "callback_url", "TEXT"},
+		{"jobs", "callback_error", "TEXT"},
+		{"subscriptions", "created_by", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := s.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if err := s.backfillFileLocations(); err != nil {
+		return err
+	}
+	if err := s.normalizeTimestamps(); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_files_location ON files(storage_account, container, path)`); err != nil {
+		return fmt.Errorf("failed to create file location index: %w", err)
+	}
+	if err := s.createCaptureIndex(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createCaptureIndex makes each capture unique among the versions of its
+// file since it was last recreated, as CreateVersion checks. Databases that
+// recorded a capture twice before the check existed keep their history and
+// go without the index.
+func (s *SQLiteStore) createCaptureIndex() error {
+	var duplicates int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM versions v
+		WHERE v.change_type NOT IN ('deleted', 'checkpoint') AND v.blob_etag != '' AND EXISTS (
+			SELECT 1 FROM versions e
+			WHERE e.file_id = v.file_id AND e.content_hash = v.content_hash AND e.blob_etag = v.blob_etag
+				AND COALESCE(e.deleted_version_id, 0) = COALESCE(v.deleted_version_id, 0)
+				AND e.change_type NOT IN ('deleted', 'checkpoint') AND e.id < v.id
+		)
+	`).Scan(&duplicates)
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicate captures: %w", err)
+	}
+	if duplicates > 0 {
+		log.Printf("Warning: %d versions repeat an earlier capture of their file, so captures are only kept unique as they are recorded", duplicates)
+		return nil
+	}
+
+	if _, err := s.db.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_versions_capture
+		ON versions(file_id, content_hash, blob_etag, COALESCE(deleted_version_id, 0))
+		WHERE change_type NOT IN ('deleted', 'checkpoint') AND blob_etag != ''
+	`); err != nil {
+		return fmt.Errorf("failed to create capture index: %w", err)
+	}
+	return nil
+}
+
+// backfillFileLocations fills in the storage account, container and path of
+// files recorded before they had their own columns
+func (s *SQLiteStore) backfillFileLocations() error {
+	rows, err := s.db.Query(`SELECT id, blob_path FROM files WHERE storage_account = ''`)
+	if err != nil {
+		return fmt.Errorf("failed to list files to backfill: %w", err)
+	}
+	var files []File
+	for rows.Next() {
+		var f File
+		if err := rows.Scan(&f.ID, &f.BlobPath); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan file row: %w", err)
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list files to backfill: %w", err)
+	}
+
+	err = s.inTx(func(tx *sql.Tx) error {
+		for _, f := range files {
+			account, container, path := splitBlobPath(f.BlobPath)
+			if account == "" {
+				continue
+			}
+			if _, err := tx.Exec(`
+				UPDATE files SET storage_account = ?, container = ?, path = ? WHERE id = ?
+			`, account, container, path, f.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to backfill file locations: %w", err)
+	}
+	return nil
+}
+
+// utcTimestamp is the SQL expression rewriting the timestamp column %[1]s in
+// UTC, in the format the driver writes times in. Values SQLite can't parse
+// are kept.
+const utcTimestamp = `COALESCE(strftime('%%Y-%%m-%%d %%H:%%M:', %[1]s) ||
+	rtrim(rtrim(strftime('%%f', %[1]s), '0'), '.') || '+00:00', %[1]s)`
+
+// normalizeTimestamps rewrites in UTC the capture and modification times
+// recorded in local time, or by SQLite's CURRENT_TIMESTAMP, before they were
+// all stored in UTC, so that they compare as text. Append-only databases
+// allow it too, as the times stay the same.
+func (s *SQLiteStore) normalizeTimestamps() error {
+	appendOnly, err := s.hasAppendOnlyTriggers()
+	if err != nil {
+		return err
+	}
+
+	err = s.inTx(func(tx *sql.Tx) error {
+		if appendOnly {
+			if _, err := tx.Exec(`DROP TRIGGER IF EXISTS append_only_versions_update`); err != nil {
+				return err
+			}
+		}
+		for _, column := range []struct{ table, name string }{
+			{"versions", "captured_at"},
+			{"files", "last_modified"},
+		} {
+			update := fmt.Sprintf(`UPDATE %s SET %s = %s WHERE %[2]s NOT LIKE '%%+00:00'`,
+				column.table, column.name, fmt.Sprintf(utcTimestamp, column.name))
+			if _, err := tx.Exec(update); err != nil {
+				return err
+			}
+		}
+		if appendOnly {
+			if _, err := tx.Exec(appendOnlyTriggers); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to normalize timestamps: %w", err)
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to an existing table unless it already exists
+func (s *SQLiteStore) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+
+	if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// appendOnlyTriggers stop the recorded history from being deleted or changed
+// by anything writing to the database, not only this store. Content can
+// still be filled in for versions captured by hash, and proposals can still
+// move through review until they are closed.
+const appendOnlyTriggers = `
+	CREATE TRIGGER IF NOT EXISTS append_only_files_delete BEFORE DELETE ON files
+	BEGIN SELECT RAISE(ABORT, 'append-only: files cannot be deleted'); END;
+
+	CREATE TRIGGER IF NOT EXISTS append_only_versions_delete BEFORE DELETE ON versions
+	BEGIN SELECT RAISE(ABORT, 'append-only: versions cannot be deleted'); END;
+
+	CREATE TRIGGER IF NOT EXISTS append_only_versions_update
+	BEFORE UPDATE OF id, file_id, content_hash, change_type, captured_at, blob_etag, blob_last_modified,
+		size, snapshot_id, author, comment, deleted_version_id, signature, signature_key_id, encrypted,
+		restored_from_version_id ON versions
+	BEGIN SELECT RAISE(ABORT, 'append-only: versions cannot be changed'); END;
+
+	CREATE TRIGGER IF NOT EXISTS append_only_versions_content
+	BEFORE UPDATE OF content, content_pending, truncated ON versions WHEN NOT OLD.content_pending
+	BEGIN SELECT RAISE(ABORT, 'append-only: versions cannot be changed'); END;
+
+	CREATE TRIGGER IF NOT EXISTS append_only_proposals_delete BEFORE DELETE ON proposals
+	BEGIN SELECT RAISE(ABORT, 'append-only: proposals cannot be deleted'); END;
+
+	CREATE TRIGGER IF NOT EXISTS append_only_proposals_update BEFORE UPDATE ON proposals
+	WHEN OLD.status NOT IN ('pending', 'approved')
+	BEGIN SELECT RAISE(ABORT, 'append-only: closed proposals cannot be changed'); END;
+`
+
+// WAL file layout, see https://www.sqlite.org/fileformat.html#the_write_ahead_log
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// WALPosition is how far the write-ahead log has been copied by CopyWAL
+type WALPosition struct {
+	// Log counts the logs since CopyDatabase; it goes up each time CopyWAL
+	// checkpoints the log and starts it over
+	Log int64
+	// Salt identifies the current log, unset until its header is copied
+	Salt uint64
+	// Offset is where the next frame to copy starts, 0 if the header has
+	// yet to be copied
+	Offset int64
+}
+
+// ErrWALRestarted is returned by CopyWAL when the log was started over
+// since the position to copy from, so frames may have been missed
+var ErrWALRestarted = errors.New("write-ahead log was restarted")
+
+// CopyDatabase checkpoints the write-ahead log and copies the database file
+// to w. Only CopyWAL checkpoints the log from then on, so the file stays as
+// copied and, with the log copied from WALPosition{}, is the whole database.
+// Writes only wait for the checkpoint, not the copy. It must not run at the
+// same time as CopyWAL.
+func (s *SQLiteStore) CopyDatabase(ctx context.Context, w io.Writer) error {
+	err := s.withWriter(ctx, func(conn *sql.Conn) error {
+		// Readers may keep it from truncating the log; the frames it couldn't
+		// checkpoint are then copied with the rest of the log
+		_, err := checkpoint(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to copy database: %w", err)
+	}
+	return nil
+}
+
+// CopyWAL copies the frames of the write-ahead log committed since pos to w,
+// with the log's header if pos is at its start, and returns the position
+// after them. Writes wait while it runs, so it never copies part of a
+// transaction. Once the log has reached checkpointSize it is checkpointed
+// and started over, and the position returned is the start of the next log.
+func (s *SQLiteStore) CopyWAL(ctx context.Context, pos WALPosition, w io.Writer, checkpointSize int64) (WALPosition, error) {
+	next := pos
+	err := s.withWriter(ctx, func(conn *sql.Conn) error {
+		f, err := os.Open(s.path + "-wal")
+		if os.IsNotExist(err) {
+			if pos.Offset > 0 {
+				return ErrWALRestarted
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open write-ahead log: %w", err)
+		}
+		defer f.Close()
+
+		end, salt, err := walEnd(f, pos)
+		if err != nil || end == pos.Offset {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(f, pos.Offset, end-pos.Offset)); err != nil {
+			return fmt.Errorf("failed to copy write-ahead log: %w", err)
+		}
+		next = WALPosition{Log: pos.Log, Salt: salt, Offset: end}
+
+		if checkpointSize <= 0 || end < checkpointSize {
+			return nil
+		}
+		truncated, err := checkpoint(ctx, conn)
+		if truncated {
+			next = WALPosition{Log: pos.Log + 1}
+		}
+		return err
+	})
+	return next, err
+}
+
+// walEnd returns the end of the last transaction committed to the log in f
+// and the log's salt, checking that the log is still the one pos is in.
+// Frames with another salt are left over from an earlier log.
+func walEnd(f *os.File, pos WALPosition) (int64, uint64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+	header := make([]byte, walHeaderSize)
+	if info.Size() < walHeaderSize {
+		if pos.Offset > 0 {
+			return 0, 0, ErrWALRestarted
+		}
+		return 0, 0, nil
+	}
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return 0, 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+	salt := binary.BigEndian.Uint64(header[16:24])
+	if pos.Offset > 0 && salt != pos.Salt {
+		return 0, 0, ErrWALRestarted
+	}
+
+	frameSize := walFrameHeaderSize + int64(binary.BigEndian.Uint32(header[8:12]))
+	end := pos.Offset
+	frame := make([]byte, walFrameHeaderSize)
+	for offset := max(pos.Offset, walHeaderSize); offset+frameSize <= info.Size(); offset += frameSize {
+		if _, err := f.ReadAt(frame, offset); err != nil {
+			return 0, 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+		}
+		if binary.BigEndian.Uint64(frame[8:16]) != salt {
+			break
+		}
+		// Only the last frame of a transaction has the database size set
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			end = offset + frameSize
+		}
+	}
+	return end, salt, nil
+}
+
+// withWriter runs fn on the writer connection with automatic checkpoints
+// turned off, so that the log is only started over by checkpoint. Writes
+// wait until fn returns.
+func (s *SQLiteStore) withWriter(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get writer connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA wal_autocheckpoint = 0`); err != nil {
+		return fmt.Errorf("failed to turn off automatic checkpoints: %w", err)
+	}
+	return fn(conn)
+}
+
+// checkpoint copies the write-ahead log into the database and truncates it,
+// reporting whether it could. Readers still using the log keep it from
+// being truncated.
+func checkpoint(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var busy, frames, checkpointed int
+	err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &frames, &checkpointed)
+	if err != nil {
+		return false, fmt.Errorf("failed to checkpoint write-ahead log: %w", err)
+	}
+	return busy == 0, nil
+}
+
+// ApplyWAL checkpoints the write-ahead log next to the database at dbPath,
+// such as one put together from copies made by CopyWAL, into the database
+func ApplyWAL(dbPath string) error {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+
+	truncated, err := checkpoint(context.Background(), conn)
+	if err == nil && !truncated {
+		err = fmt.Errorf("failed to checkpoint write-ahead log: database busy")
+	}
 	return err
 }
 
-// Close closes the database connection
+// EnableAppendOnly makes the store append-only. This can't be undone: the
+// triggers installed stay in the database, so it remains append-only even if
+// opened without the setting.
+func (s *SQLiteStore) EnableAppendOnly() error {
+	if _, err := s.db.Exec(appendOnlyTriggers); err != nil {
+		return fmt.Errorf("failed to install append-only triggers: %w", err)
+	}
+	s.appendOnly = true
+	return nil
+}
+
+// AppendOnly reports whether the store is append-only
+func (s *SQLiteStore) AppendOnly() bool {
+	return s.appendOnly
+}
+
+// hasAppendOnlyTriggers reports whether the database was made append-only
+func (s *SQLiteStore) hasAppendOnlyTriggers() (bool, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'append_only_%'
+	`).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect triggers: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Ping checks that the database can be read
+func (s *SQLiteStore) Ping() error {
+	var n int
+	if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&n); err != nil {
+		return fmt.Errorf("failed to read database: %w", err)
+	}
+	return nil
+}
+
+// Close closes the prepared statements and database connections
 func (s *SQLiteStore) Close() error {
+	for _, stmt := range []*sql.Stmt{
+		s.stmts.getFile, s.stmts.getVersion, s.stmts.getLatestVersion, s.stmts.upsertFile, s.stmts.createVersion,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	if s.readDB != s.db {
+		s.readDB.Close()
+	}
 	return s.db.Close()
 }
 
 // GetFile retrieves a file by its blob path
 func (s *SQLiteStore) GetFile(blobPath string) (*File, error) {
 	var f File
-	var lastModified sql.NullString
+	var lastModified, archivedAt sql.NullString
 
-	err := s.db.QueryRow(`
-		SELECT id, blob_path, etag, content_hash, last_modified, is_deleted
-		FROM files WHERE blob_path = ?
-	`, blobPath).Scan(&f.ID, &f.BlobPath, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted)
+	err := s.stmts.getFile.QueryRow(blobPath).Scan(
+		&f.ID, &f.BlobPath, &f.StorageAccount, &f.Container, &f.Path, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted, &f.IsArchived, &f.ArchivedBy, &archivedAt, &f.Language,
+	)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -86,6 +867,10 @@ func (s *SQLiteStore) GetFile(blobPath string) (*File, error) {
 	if lastModified.Valid {
 		f.LastModified = parseTime(lastModified.String)
 	}
+	if archivedAt.Valid {
+		t := parseTime(archivedAt.String)
+		f.ArchivedAt = &t
+	}
 
 	return &f, nil
 }
@@ -93,12 +878,12 @@ func (s *SQLiteStore) GetFile(blobPath string) (*File, error) {
 // GetFileByID retrieves a file by its ID
 func (s *SQLiteStore) GetFileByID(id int64) (*File, error) {
 	var f File
-	var lastModified sql.NullString
+	var lastModified, archivedAt sql.NullString
 
-	err := s.db.QueryRow(`
-		SELECT id, blob_path, etag, content_hash, last_modified, is_deleted
+	err := s.readDB.QueryRow(`
+		SELECT id, blob_path, storage_account, container, path, etag, content_hash, last_modified, is_deleted, is_archived, COALESCE(archived_by, ''), archived_at, language
 		FROM files WHERE id = ?
-	`, id).Scan(&f.ID, &f.BlobPath, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted)
+	`, id).Scan(&f.ID, &f.BlobPath, &f.StorageAccount, &f.Container, &f.Path, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted, &f.IsArchived, &f.ArchivedBy, &archivedAt, &f.Language)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -110,23 +895,73 @@ func (s *SQLiteStore) GetFileByID(id int64) (*File, error) {
 	if lastModified.Valid {
 		f.LastModified = parseTime(lastModified.String)
 	}
+	if archivedAt.Valid {
+		t := parseTime(archivedAt.String)
+		f.ArchivedAt = &t
+	}
 
 	return &f, nil
 }
 
 // ListFiles returns all tracked files with version counts
 func (s *SQLiteStore) ListFiles() ([]FileWithVersionCount, error) {
-	rows, err := s.db.Query(`
-		SELECT 
-			f.id, f.blob_path, f.etag, f.content_hash, f.last_modified, f.is_deleted,
+	return s.ListFilesWithStatus(FileStatusAll, FileSortPath, nil)
+}
+
+// fileStatusConditions are the WHERE clauses selecting files by status
+var fileStatusConditions = map[FileStatus]string{
+	FileStatusAll:      "",
+	FileStatusActive:   "WHERE NOT f.is_deleted AND NOT f.is_archived",
+	FileStatusDeleted:  "WHERE f.is_deleted",
+	FileStatusArchived: "WHERE f.is_archived AND NOT f.is_deleted",
+}
+
+// fileSortOrders are the ORDER BY clauses of the file sorts. Ties are
+// broken by path so the order is stable.
+var fileSortOrders = map[FileSort]string{
+	FileSortPath:         "f.blob_path",
+	FileSortLatestChange: "COALESCE(MAX(v.captured_at), f.last_modified) DESC, f.blob_path",
+	FileSortVersionCount: "version_count DESC, f.blob_path",
+}
+
+// ListFilesWithStatus returns the tracked files with a status, with version
+// counts, in an order
+func (s *SQLiteStore) ListFilesWithStatus(status FileStatus, sort FileSort, labels []LabelSelector) ([]FileWithVersionCount, error) {
+	condition, ok := fileStatusConditions[status]
+	if !ok {
+		return nil, fmt.Errorf("unknown file status %q", status)
+	}
+	order, ok := fileSortOrders[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown file sort %q", sort)
+	}
+	labelCondition, args := labelConditions(labels)
+	if labelCondition != "" {
+		if condition == "" {
+			condition = "WHERE " + labelCondition
+		} else {
+			condition += " AND " + labelCondition
+		}
+	}
+
+	// Everything is read from idx_versions_file_latest, without touching the
+	// versions themselves: the counts in one pass, and the latest change type
+	// with one lookup per file
+	rows, err := s.readDB.Query(`
+		SELECT
+			f.id, f.blob_path, f.storage_account, f.container, f.path, f.etag, f.content_hash, f.last_modified, f.is_deleted, f.is_archived, COALESCE(f.archived_by, ''), f.archived_at, f.language,
 			COUNT(v.id) as version_count,
-			COALESCE(MAX(v.captured_at), f.last_modified) as latest_change,
-			(SELECT change_type FROM versions WHERE file_id = f.id ORDER BY captured_at DESC LIMIT 1) as latest_change_type
+			MAX(v.captured_at) as latest_change,
+			(
+				SELECT lv.change_type FROM versions lv WHERE lv.file_id = f.id
+				ORDER BY lv.captured_at DESC, lv.id DESC LIMIT 1
+			) as latest_change_type
 		FROM files f
 		LEFT JOIN versions v ON f.id = v.file_id
+		`+condition+`
 		GROUP BY f.id
-		ORDER BY f.blob_path
-	`)
+		ORDER BY `+order+`
+	`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list files: %w", err)
 	}
@@ -135,11 +970,11 @@ func (s *SQLiteStore) ListFiles() ([]FileWithVersionCount, error) {
 	var files []FileWithVersionCount
 	for rows.Next() {
 		var f FileWithVersionCount
-		var lastModified, latestChange sql.NullString
+		var lastModified, latestChange, archivedAt sql.NullString
 		var latestChangeType sql.NullString
 
 		err := rows.Scan(
-			&f.ID, &f.BlobPath, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted,
+			&f.ID, &f.BlobPath, &f.StorageAccount, &f.Container, &f.Path, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted, &f.IsArchived, &f.ArchivedBy, &archivedAt, &f.Language,
 			&f.VersionCount, &latestChange, &latestChangeType,
 		)
 		if err != nil {
@@ -149,8 +984,14 @@ func (s *SQLiteStore) ListFiles() ([]FileWithVersionCount, error) {
 		if lastModified.Valid {
 			f.LastModified = parseTime(lastModified.String)
 		}
+		if archivedAt.Valid {
+			t := parseTime(archivedAt.String)
+			f.ArchivedAt = &t
+		}
 		if latestChange.Valid {
 			f.LatestChange = parseTime(latestChange.String)
+		} else {
+			f.LatestChange = f.LastModified
 		}
 		if latestChangeType.Valid {
 			f.LatestChangeType = ChangeType(latestChangeType.String)
@@ -162,56 +1003,310 @@ func (s *SQLiteStore) ListFiles() ([]FileWithVersionCount, error) {
 	return files, rows.Err()
 }
 
-// UpsertFile creates or updates a file record
-func (s *SQLiteStore) UpsertFile(file *File) error {
-	result, err := s.db.Exec(`
-		INSERT INTO files (blob_path, etag, content_hash, last_modified, is_deleted)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(blob_path) DO UPDATE SET
-			etag = excluded.etag,
-			content_hash = excluded.content_hash,
-			last_modified = excluded.last_modified,
-			is_deleted = excluded.is_deleted
-	`, file.BlobPath, file.ETag, file.ContentHash, file.LastModified, file.IsDeleted)
+// ListChurnedFiles returns the files with the most changes since a time,
+// most changed first
+func (s *SQLiteStore) ListChurnedFiles(since time.Time, limit int) ([]ChurnedFile, error) {
+	// Changes are counted from idx_versions_captured_at, so only the versions
+	// in the period are read, and files are joined to the top few only.
+	// Timestamps are stored as text in UTC, as in SearchChanges.
+	rows, err := s.readDB.Query(`
+		SELECT
+			f.id, f.blob_path, f.storage_account, f.container, f.path, f.etag, f.content_hash, f.last_modified, f.is_deleted, f.is_archived, COALESCE(f.archived_by, ''), f.archived_at, f.language,
+			c.changes, c.latest_change
+		FROM (
+			SELECT file_id, COUNT(*) as changes, MAX(captured_at) as latest_change
+			FROM versions
+			WHERE captured_at >= ?
+			GROUP BY file_id
+			ORDER BY changes DESC, latest_change DESC
+			LIMIT ?
+		) c
+		JOIN files f ON f.id = c.file_id
+		ORDER BY c.changes DESC, c.latest_change DESC
+	`, since.UTC(), limit)
 	if err != nil {
-		return fmt.Errorf("failed to upsert file: %w", err)
+		return nil, fmt.Errorf("failed to list churned files: %w", err)
 	}
+	defer rows.Close()
 
-	// Get the ID if it was an insert
-	if file.ID == 0 {
-		id, err := result.LastInsertId()
-		if err == nil && id > 0 {
-			file.ID = id
-		} else {
-			// It was an update, get the existing ID
-			existing, err := s.GetFile(file.BlobPath)
-			if err == nil && existing != nil {
-				file.ID = existing.ID
-			}
-		}
-	}
+	var files []ChurnedFile
+	for rows.Next() {
+		var f ChurnedFile
+		var lastModified, latestChange, archivedAt sql.NullString
+
+		err := rows.Scan(
+			&f.ID, &f.BlobPath, &f.StorageAccount, &f.Container, &f.Path, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted, &f.IsArchived, &f.ArchivedBy, &archivedAt, &f.Language,
+			&f.Changes, &latestChange,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan churned file row: %w", err)
+		}
+
+		if lastModified.Valid {
+			f.LastModified = parseTime(lastModified.String)
+		}
+		if archivedAt.Valid {
+			t := parseTime(archivedAt.String)
+			f.ArchivedAt = &t
+		}
+		if latestChange.Valid {
+			f.LatestChange = parseTime(latestChange.String)
+		}
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// ListDeletedFiles returns the files currently marked deleted, most recently
+// deleted first
+func (s *SQLiteStore) ListDeletedFiles() ([]DeletedFile, error) {
+	rows, err := s.readDB.Query(`
+		SELECT
+			f.id, f.blob_path, f.storage_account, f.container, f.path, f.etag, f.content_hash, f.last_modified, f.is_deleted, f.is_archived, COALESCE(f.archived_by, ''), f.archived_at, f.language,
+			(SELECT MAX(captured_at) FROM versions WHERE file_id = f.id AND change_type = ?) as deleted_at,
+			(SELECT id FROM versions
+				WHERE file_id = f.id AND change_type != ? AND (content != '' OR size > 0)
+				ORDER BY captured_at DESC, id DESC LIMIT 1) as last_version_id
+		FROM files f
+		WHERE f.is_deleted
+		ORDER BY deleted_at DESC
+	`, ChangeTypeDeleted, ChangeTypeDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []DeletedFile
+	for rows.Next() {
+		var f DeletedFile
+		var lastModified, deletedAt, archivedAt sql.NullString
+		var lastVersionID sql.NullInt64
+
+		err := rows.Scan(
+			&f.ID, &f.BlobPath, &f.StorageAccount, &f.Container, &f.Path, &f.ETag, &f.ContentHash, &lastModified, &f.IsDeleted, &f.IsArchived, &f.ArchivedBy, &archivedAt, &f.Language,
+			&deletedAt, &lastVersionID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan deleted file row: %w", err)
+		}
+
+		if lastModified.Valid {
+			f.LastModified = parseTime(lastModified.String)
+		}
+		if archivedAt.Valid {
+			t := parseTime(archivedAt.String)
+			f.ArchivedAt = &t
+		}
+		if deletedAt.Valid {
+			f.DeletedAt = parseTime(deletedAt.String)
+		}
+		f.LastVersionID = lastVersionID.Int64
+
+		files = append(files, f)
+	}
+
+	return files, rows.Err()
+}
+
+// UpsertFile creates or updates a file record and sets its ID
+func (s *SQLiteStore) UpsertFile(file *File) error {
+	err := retryBusy(func() error {
+		return s.stmts.upsertFile.QueryRow(fileArgs(file)...).Scan(&file.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upsert file: %w", err)
+	}
 
 	return nil
 }
 
 // MarkFileDeleted marks a file as deleted
 func (s *SQLiteStore) MarkFileDeleted(blobPath string) error {
-	_, err := s.db.Exec(`
+	_, err := s.exec(`
 		UPDATE files SET is_deleted = TRUE WHERE blob_path = ?
 	`, blobPath)
 	return err
 }
 
-// CreateVersion creates a new version record
+// SetFileLanguage sets the detected language of a file
+func (s *SQLiteStore) SetFileLanguage(fileID int64, language string) error {
+	_, err := s.exec(`
+		UPDATE files SET language = ? WHERE id = ?
+	`, language, fileID)
+	return err
+}
+
+// SetFileArchived archives or unarchives a file
+func (s *SQLiteStore) SetFileArchived(blobPath string, archived bool, by string, at time.Time) error {
+	_, err := s.exec(archiveFileQuery+` WHERE blob_path = ?`, archiveArgs(archived, by, at, blobPath)...)
+	return err
+}
+
+// archiveFileQuery sets the archived state of files, clearing who archived
+// them and when on unarchiving
+const archiveFileQuery = `UPDATE files SET is_archived = ?, archived_by = ?, archived_at = ?`
+
+// archiveArgs returns the arguments of archiveFileQuery followed by where
+func archiveArgs(archived bool, by string, at time.Time, where ...interface{}) []interface{} {
+	if !archived {
+		return append([]interface{}{false, "", nil}, where...)
+	}
+	return append([]interface{}{true, by, at}, where...)
+}
+
+// UpdateFiles applies the updates in one transaction, so either all of them
+// or none are made
+func (s *SQLiteStore) UpdateFiles(updates []FileUpdate) error {
+	err := s.inTx(func(tx *sql.Tx) error {
+		for _, u := range updates {
+			if u.Archived != nil {
+				if _, err := tx.Exec(archiveFileQuery+` WHERE id = ?`, archiveArgs(*u.Archived, u.ArchivedBy, u.ArchivedAt, u.FileID)...); err != nil {
+					return err
+				}
+			}
+			for key, value := range u.Labels {
+				if _, err := tx.Exec(`
+					INSERT INTO file_labels (file_id, key, value) VALUES (?, ?, ?)
+					ON CONFLICT (file_id, key) DO UPDATE SET value = excluded.value
+				`, u.FileID, key, value); err != nil {
+					return err
+				}
+			}
+			if u.Tag != "" {
+				if _, err := tx.Exec(`
+					INSERT OR IGNORE INTO version_tags (version_id, tag, tagged_by, tagged_at)
+					SELECT id, ?, ?, ? FROM versions WHERE file_id = ?
+					ORDER BY captured_at DESC, id DESC LIMIT 1
+				`, u.Tag, u.TaggedBy, u.TaggedAt, u.FileID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update files: %w", err)
+	}
+	return nil
+}
+
+// RenameStorageAccount moves the files of a storage account to a new name,
+// keeping their IDs so their versions follow. A file already recorded at its
+// new path, as when the new name was synced before the rename, is left under
+// the old name and reported as a conflict. Open proposals, tracking rules,
+// watches and subscriptions naming the account are moved along.
+func (s *SQLiteStore) RenameStorageAccount(from, to string) (*AccountRename, error) {
+	var rename AccountRename
+	err := s.inTx(func(tx *sql.Tx) error {
+		rename = AccountRename{}
+
+		rows, err := tx.Query(`
+			SELECT f.blob_path FROM files f
+			JOIN files n ON n.storage_account = ? AND n.container = f.container AND n.path = f.path
+			WHERE f.storage_account = ?
+			ORDER BY f.blob_path
+		`, to, from)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return err
+			}
+			rename.Conflicts = append(rename.Conflicts, path)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`
+			UPDATE files SET storage_account = ?, blob_path = ? || '/' || container || '/' || path
+			WHERE storage_account = ? AND NOT EXISTS (
+				SELECT 1 FROM files n WHERE n.storage_account = ? AND n.container = files.container AND n.path = files.path
+			)
+		`, to, to, from, to)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		rename.Files = int(n)
+
+		if _, err := tx.Exec(`
+			UPDATE tracking_rules SET storage_account = ? WHERE storage_account = ?
+		`, to, from); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE OR IGNORE sync_pauses SET storage_account = ? WHERE storage_account = ?
+		`, to, from); err != nil {
+			return err
+		}
+
+		// Paths and prefixes naming the account, or something in it
+		renamed := func(column string) string {
+			return fmt.Sprintf(`%[1]s = ? || substr(%[1]s, %[2]d) WHERE (%[1]s = ? OR substr(%[1]s, 1, %[2]d) = ?)`,
+				column, len(from)+1)
+		}
+		args := []any{to, from, from + "/"}
+		for _, update := range []string{
+			`UPDATE proposals SET ` + renamed("blob_path") + ` AND status IN ('pending', 'approved')`,
+			`UPDATE OR IGNORE watches SET ` + renamed("path"),
+			`UPDATE subscriptions SET ` + renamed("path_prefix"),
+		} {
+			if _, err := tx.Exec(update, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename storage account %s: %w", from, err)
+	}
+	return &rename, nil
+}
+
+// SetSigner signs every version created from now on
+func (s *SQLiteStore) SetSigner(signer VersionSigner) {
+	s.signer = signer
+}
+
+// SetClock replaces the system clock, e.g. with a clock.Fake in tests
+func (s *SQLiteStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// CreateVersion creates a new version record, signed if a signer is set,
+// unless the capture is already recorded. A version without a capture time
+// is stamped with the store's clock.
 func (s *SQLiteStore) CreateVersion(version *Version) error {
-	result, err := s.db.Exec(`
-		INSERT INTO versions (file_id, content, content_hash, change_type, captured_at, blob_etag, blob_last_modified)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, version.FileID, version.Content, version.ContentHash, version.ChangeType, version.CapturedAt, version.BlobETag, version.BlobLastModified)
+	if version.CapturedAt.IsZero() {
+		version.CapturedAt = s.clock.Now()
+	}
+	if s.signer != nil {
+		s.signer.Sign(version)
+	}
+
+	var result sql.Result
+	err := retryBusy(func() error {
+		var err error
+		result, err = s.stmts.createVersion.Exec(versionArgs(version)...)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create version: %w", err)
 	}
 
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrDuplicateVersion
+	}
 	id, err := result.LastInsertId()
 	if err == nil {
 		version.ID = id
@@ -220,16 +1315,112 @@ func (s *SQLiteStore) CreateVersion(version *Version) error {
 	return nil
 }
 
+// WriteCaptures upserts the file records and creates their versions, signed
+// if a signer is set, in one transaction, so either all of them or none are
+// written. It sets the IDs of the files and versions; versions whose capture
+// is already recorded, and writes whose file's ETag is no longer their
+// PreviousETag, are skipped and leave an ID of 0.
+func (s *SQLiteStore) WriteCaptures(writes []CaptureWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		upsertFile := tx.Stmt(s.stmts.upsertFile)
+		createVersion := tx.Stmt(s.stmts.createVersion)
+		for _, w := range writes {
+			if w.PreviousETag != nil {
+				var etag string
+				err := tx.QueryRow(`SELECT COALESCE(etag, '') FROM files WHERE blob_path = ?`, w.File.BlobPath).Scan(&etag)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
+				if etag != *w.PreviousETag {
+					continue
+				}
+			}
+			if err := upsertFile.QueryRow(fileArgs(w.File)...).Scan(&w.File.ID); err != nil {
+				return err
+			}
+			if w.Version == nil {
+				continue
+			}
+
+			// The file ID is signed, so new files' versions can only be
+			// signed once the file is written
+			w.Version.FileID = w.File.ID
+			if w.Version.CapturedAt.IsZero() {
+				w.Version.CapturedAt = s.clock.Now()
+			}
+			if s.signer != nil {
+				s.signer.Sign(w.Version)
+			}
+			result, err := createVersion.Exec(versionArgs(w.Version)...)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				w.Version.ID = 0
+				continue
+			}
+			if w.Version.ID, err = result.LastInsertId(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write captures: %w", err)
+	}
+	return nil
+}
+
+// fileArgs are the arguments of the prepared upsertFile statement. The
+// file's storage account, container and path are set from its blob path.
+// Its modification time is stored in UTC, to compare with capture times.
+func fileArgs(file *File) []any {
+	file.StorageAccount, file.Container, file.Path = splitBlobPath(file.BlobPath)
+	return []any{
+		file.BlobPath, file.StorageAccount, file.Container, file.Path,
+		file.ETag, file.ContentHash, file.LastModified.UTC(), file.IsDeleted, file.Language,
+	}
+}
+
+// splitBlobPath splits a blob path into its storage account, container and
+// path within the container. All three are empty if it isn't a full path.
+func splitBlobPath(blobPath string) (account, container, path string) {
+	parts := strings.SplitN(blobPath, "/", 3)
+	if len(parts) != 3 {
+		return "", "", ""
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// versionArgs are the arguments of the prepared createVersion statement. The
+// capture time is stored in UTC, so that capture times compare as text.
+func versionArgs(version *Version) []any {
+	return []any{
+		version.FileID, version.Content, version.ContentHash, version.ChangeType, version.CapturedAt.UTC(),
+		version.BlobETag, version.BlobLastModified, version.ContentPending, version.Size, version.Truncated,
+		version.SnapshotID, version.Author, version.Comment,
+		sql.NullInt64{Int64: version.DeletedVersionID, Valid: version.DeletedVersionID != 0},
+		version.Signature, version.SignatureKeyID, version.Encrypted,
+		sql.NullInt64{Int64: version.RestoredFromVersionID, Valid: version.RestoredFromVersionID != 0},
+	}
+}
+
+// versionColumns are the columns read by scanVersion, qualified by the "v" alias
+const versionColumns = `v.id, v.file_id, v.content, v.content_hash, v.change_type, v.captured_at,
+	v.blob_etag, v.blob_last_modified, v.content_pending, v.size, v.truncated, v.snapshot_id, v.author, v.comment,
+	v.deleted_version_id, v.signature, v.signature_key_id, v.encrypted, v.restored_from_version_id`
+
 // GetVersion retrieves a specific version by ID
 func (s *SQLiteStore) GetVersion(id int64) (*Version, error) {
-	var v Version
-	var capturedAt, blobLastModified sql.NullString
-
-	err := s.db.QueryRow(`
-		SELECT id, file_id, content, content_hash, change_type, captured_at, blob_etag, blob_last_modified
-		FROM versions WHERE id = ?
-	`, id).Scan(&v.ID, &v.FileID, &v.Content, &v.ContentHash, &v.ChangeType, &capturedAt, &v.BlobETag, &blobLastModified)
+	row := s.stmts.getVersion.QueryRow(id)
 
+	v, err := scanVersion(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -237,22 +1428,38 @@ func (s *SQLiteStore) GetVersion(id int64) (*Version, error) {
 		return nil, fmt.Errorf("failed to get version: %w", err)
 	}
 
-	if capturedAt.Valid {
-		v.CapturedAt = parseTime(capturedAt.String)
-	}
-	if blobLastModified.Valid {
-		v.BlobLastModified = parseTime(blobLastModified.String)
+	return v, nil
+}
+
+// SetVersionContent stores content fetched for a version captured without it
+func (s *SQLiteStore) SetVersionContent(id int64, content string, truncated bool) error {
+	_, err := s.exec(`
+		UPDATE versions SET content = ?, content_pending = FALSE, truncated = ? WHERE id = ?
+	`, content, truncated, id)
+	if err != nil {
+		return fmt.Errorf("failed to set version content: %w", err)
 	}
+	return nil
+}
 
-	return &v, nil
+// SetVersionComment replaces the comment explaining a version
+func (s *SQLiteStore) SetVersionComment(id int64, comment string) error {
+	if s.appendOnly {
+		return ErrAppendOnly
+	}
+	_, err := s.exec(`UPDATE versions SET comment = ? WHERE id = ?`, comment, id)
+	if err != nil {
+		return fmt.Errorf("failed to set version comment: %w", err)
+	}
+	return nil
 }
 
 // GetVersionsByFileID retrieves all versions for a file by file ID
 func (s *SQLiteStore) GetVersionsByFileID(fileID int64) ([]Version, error) {
-	rows, err := s.db.Query(`
-		SELECT id, file_id, content, content_hash, change_type, captured_at, blob_etag, blob_last_modified
-		FROM versions WHERE file_id = ?
-		ORDER BY captured_at DESC
+	rows, err := s.readDB.Query(`
+		SELECT `+versionColumns+`
+		FROM versions v WHERE v.file_id = ?
+		ORDER BY v.captured_at DESC
 	`, fileID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get versions: %w", err)
@@ -264,8 +1471,8 @@ func (s *SQLiteStore) GetVersionsByFileID(fileID int64) ([]Version, error) {
 
 // GetVersionsByFilePath retrieves all versions for a file by blob path
 func (s *SQLiteStore) GetVersionsByFilePath(blobPath string) ([]Version, error) {
-	rows, err := s.db.Query(`
-		SELECT v.id, v.file_id, v.content, v.content_hash, v.change_type, v.captured_at, v.blob_etag, v.blob_last_modified
+	rows, err := s.readDB.Query(`
+		SELECT `+versionColumns+`
 		FROM versions v
 		JOIN files f ON v.file_id = f.id
 		WHERE f.blob_path = ?
@@ -281,15 +1488,9 @@ func (s *SQLiteStore) GetVersionsByFilePath(blobPath string) ([]Version, error)
 
 // GetLatestVersion retrieves the most recent version for a file
 func (s *SQLiteStore) GetLatestVersion(fileID int64) (*Version, error) {
-	var v Version
-	var capturedAt, blobLastModified sql.NullString
-
-	err := s.db.QueryRow(`
-		SELECT id, file_id, content, content_hash, change_type, captured_at, blob_etag, blob_last_modified
-		FROM versions WHERE file_id = ?
-		ORDER BY captured_at DESC LIMIT 1
-	`, fileID).Scan(&v.ID, &v.FileID, &v.Content, &v.ContentHash, &v.ChangeType, &capturedAt, &v.BlobETag, &blobLastModified)
+	row := s.stmts.getLatestVersion.QueryRow(fileID)
 
+	v, err := scanVersion(row)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -297,61 +1498,1736 @@ func (s *SQLiteStore) GetLatestVersion(fileID int64) (*Version, error) {
 		return nil, fmt.Errorf("failed to get latest version: %w", err)
 	}
 
-	if capturedAt.Valid {
-		v.CapturedAt = parseTime(capturedAt.String)
+	return v, nil
+}
+
+// GetPreviousVersion returns the version of the same file captured before
+// the given one, or nil if it is the first
+func (s *SQLiteStore) GetPreviousVersion(id int64) (*Version, error) {
+	row := s.readDB.QueryRow(`
+		SELECT `+versionColumns+`
+		FROM versions v
+		JOIN versions cur ON cur.id = ? AND v.file_id = cur.file_id
+		WHERE v.captured_at < cur.captured_at OR (v.captured_at = cur.captured_at AND v.id < cur.id)
+		ORDER BY v.captured_at DESC, v.id DESC LIMIT 1
+	`, id)
+
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	if blobLastModified.Valid {
-		v.BlobLastModified = parseTime(blobLastModified.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previous version: %w", err)
 	}
 
-	return &v, nil
+	return v, nil
 }
 
-// scanVersions is a helper to scan multiple version rows
-func scanVersions(rows *sql.Rows) ([]Version, error) {
-	var versions []Version
-	for rows.Next() {
-		var v Version
-		var capturedAt, blobLastModified sql.NullString
+// VersionReferenced reports whether inbox items, impact measurements, tags,
+// share links or proposals refer to a version
+func (s *SQLiteStore) VersionReferenced(id int64) (bool, error) {
+	var referenced bool
+	err := s.readDB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM inbox_items WHERE version_id = ?1)
+			OR EXISTS (SELECT 1 FROM version_impacts WHERE version_id = ?1)
+			OR EXISTS (SELECT 1 FROM version_tags WHERE version_id = ?1)
+			OR EXISTS (SELECT 1 FROM view_tokens WHERE from_version_id = ?1 OR to_version_id = ?1)
+			OR EXISTS (SELECT 1 FROM proposals WHERE base_version_id = ?1 OR restore_version_id = ?1)
+	`, id).Scan(&referenced)
+	if err != nil {
+		return false, fmt.Errorf("failed to check references to version: %w", err)
+	}
+	return referenced, nil
+}
+
+// GetAdjacentVersionIDs returns the IDs of the versions of the same file
+// captured just before and after the given one, in the order of
+// GetPreviousVersion, or 0 where there is none
+func (s *SQLiteStore) GetAdjacentVersionIDs(id int64) (int64, int64, error) {
+	var previous, next sql.NullInt64
+	err := s.readDB.QueryRow(`
+		SELECT
+			(SELECT v.id FROM versions v
+			 WHERE v.file_id = cur.file_id
+			   AND (v.captured_at < cur.captured_at OR (v.captured_at = cur.captured_at AND v.id < cur.id))
+			 ORDER BY v.captured_at DESC, v.id DESC LIMIT 1),
+			(SELECT v.id FROM versions v
+			 WHERE v.file_id = cur.file_id
+			   AND (v.captured_at > cur.captured_at OR (v.captured_at = cur.captured_at AND v.id > cur.id))
+			 ORDER BY v.captured_at, v.id LIMIT 1)
+		FROM versions cur WHERE cur.id = ?
+	`, id).Scan(&previous, &next)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get adjacent versions: %w", err)
+	}
+	return previous.Int64, next.Int64, nil
+}
+
+// GetVersionAt returns the latest version of a file captured at or before at,
+// or nil if the file wasn't tracked yet
+func (s *SQLiteStore) GetVersionAt(fileID int64, at time.Time) (*Version, error) {
+	row := s.readDB.QueryRow(`
+		SELECT `+versionColumns+`
+		FROM versions v WHERE v.file_id = ? AND v.captured_at <= ?
+		ORDER BY v.captured_at DESC, v.id DESC LIMIT 1
+	`, fileID, at.UTC())
+
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version at %s: %w", at.Format(time.RFC3339), err)
+	}
+
+	return v, nil
+}
+
+// GetVersionsAt returns, for every file under pathPrefix, the latest version
+// captured at or before at, ordered by path. Files first tracked later are
+// left out; files deleted by then are included with their deletion.
+func (s *SQLiteStore) GetVersionsAt(pathPrefix string, at time.Time) ([]Version, error) {
+	rows, err := s.readDB.Query(`
+		SELECT `+versionColumns+`
+		FROM versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE f.blob_path LIKE ? ESCAPE '\' AND v.id = (
+			SELECT v2.id FROM versions v2
+			WHERE v2.file_id = v.file_id AND v2.captured_at <= ?
+			ORDER BY v2.captured_at DESC, v2.id DESC LIMIT 1
+		)
+		ORDER BY f.blob_path
+	`, escapeLike(pathPrefix)+"%", at.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get versions at %s: %w", at.Format(time.RFC3339), err)
+	}
+	defer rows.Close()
+
+	return scanVersions(rows)
+}
 
-		err := rows.Scan(&v.ID, &v.FileID, &v.Content, &v.ContentHash, &v.ChangeType, &capturedAt, &v.BlobETag, &blobLastModified)
+// PruneVersions deletes the versions of a file captured before a time,
+// except its latest version, along with the inbox items, impacts and tags
+// pointing to them
+func (s *SQLiteStore) PruneVersions(fileID int64, before time.Time) (int64, error) {
+	if s.appendOnly {
+		return 0, ErrAppendOnly
+	}
+
+	const pruned = `
+		SELECT id FROM versions WHERE file_id = ? AND captured_at < ? AND id != (
+			SELECT id FROM versions WHERE file_id = ? ORDER BY captured_at DESC, id DESC LIMIT 1
+		)`
+	args := []any{fileID, before.UTC(), fileID}
+
+	var deleted int64
+	err := s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM inbox_items WHERE version_id IN (`+pruned+`)`, args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM version_impacts WHERE version_id IN (`+pruned+`)`, args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM version_tags WHERE version_id IN (`+pruned+`)`, args...); err != nil {
+			return err
+		}
+		result, err := tx.Exec(`DELETE FROM versions WHERE id IN (`+pruned+`)`, args...)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan version row: %w", err)
+			return err
 		}
+		deleted, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune versions: %w", err)
+	}
+	return deleted, nil
+}
 
-		if capturedAt.Valid {
-			v.CapturedAt = parseTime(capturedAt.String)
-		}
-		if blobLastModified.Valid {
-			v.BlobLastModified = parseTime(blobLastModified.String)
+// StorageByFile returns the content stored for the versions of each file
+func (s *SQLiteStore) StorageByFile() ([]FileStorage, error) {
+	rows, err := s.readDB.Query(`
+		SELECT f.id, f.blob_path, COUNT(v.id), COALESCE(SUM(LENGTH(CAST(v.content AS BLOB))), 0)
+		FROM files f JOIN versions v ON v.file_id = f.id
+		GROUP BY f.id, f.blob_path
+		ORDER BY f.blob_path
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage by file: %w", err)
+	}
+	defer rows.Close()
+
+	var result []FileStorage
+	for rows.Next() {
+		var fs FileStorage
+		if err := rows.Scan(&fs.FileID, &fs.BlobPath, &fs.Versions, &fs.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan file storage: %w", err)
 		}
+		result = append(result, fs)
+	}
+	return result, rows.Err()
+}
 
-		versions = append(versions, v)
+// DropVersionContent clears the stored content of a version and marks it
+// pending, so that it's fetched again from its snapshot or blob when needed
+func (s *SQLiteStore) DropVersionContent(id int64) error {
+	if s.appendOnly {
+		return ErrAppendOnly
 	}
 
-	return versions, rows.Err()
+	_, err := s.exec(`
+		UPDATE versions SET content = '', content_pending = TRUE, truncated = FALSE WHERE id = ?
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to drop version content: %w", err)
+	}
+	return nil
 }
 
-// parseTime parses a SQLite datetime string into time.Time
-func parseTime(s string) time.Time {
-	if s == "" {
-		return time.Time{}
+// OldestVersions returns up to limit versions of a file, or of every file if
+// fileID is 0, oldest first. The latest version of each file and versions
+// without stored content are left out.
+func (s *SQLiteStore) OldestVersions(fileID int64, limit int) ([]StoredVersion, error) {
+	rows, err := s.readDB.Query(`
+		SELECT v.id, v.file_id, LENGTH(CAST(v.content AS BLOB))
+		FROM versions v
+		WHERE (? = 0 OR v.file_id = ?) AND v.content != '' AND v.id != (
+			SELECT l.id FROM versions l WHERE l.file_id = v.file_id ORDER BY l.captured_at DESC, l.id DESC LIMIT 1
+		)
+		ORDER BY v.captured_at, v.id
+		LIMIT ?
+	`, fileID, fileID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oldest versions: %w", err)
 	}
-	
-	// Try various SQLite datetime formats
-	formats := []string{
-		"2006-01-02 15:04:05",
-		"2006-01-02T15:04:05Z",
-		"2006-01-02T15:04:05.000Z",
-		time.RFC3339,
-		time.RFC3339Nano,
+	defer rows.Close()
+
+	var result []StoredVersion
+	for rows.Next() {
+		var v StoredVersion
+		if err := rows.Scan(&v.ID, &v.FileID, &v.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		result = append(result, v)
 	}
-	
-	for _, format := range formats {
-		if t, err := time.Parse(format, s); err == nil {
-			return t
+	return result, rows.Err()
+}
+
+// DeleteVersion deletes a version, the inbox items delivering it, its
+// measured impacts and its tags
+func (s *SQLiteStore) DeleteVersion(id int64) error {
+	if s.appendOnly {
+		return ErrAppendOnly
+	}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM inbox_items WHERE version_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM version_impacts WHERE version_id = ?`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM version_tags WHERE version_id = ?`, id); err != nil {
+			return err
 		}
+		_, err := tx.Exec(`DELETE FROM versions WHERE id = ?`, id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete version: %w", err)
 	}
-	
-	return time.Time{}
+	return nil
+}
+
+// SaveImpact saves a version's impact on a metric, replacing the one saved
+// before
+func (s *SQLiteStore) SaveImpact(impact *Impact) error {
+	if impact.MeasuredAt.IsZero() {
+		impact.MeasuredAt = s.clock.Now()
+	}
+	_, err := s.exec(`
+		INSERT INTO version_impacts (version_id, metric, unit, before_value, after_value, window_length, measured_at, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(version_id, metric) DO UPDATE SET
+			unit = excluded.unit,
+			before_value = excluded.before_value,
+			after_value = excluded.after_value,
+			window_length = excluded.window_length,
+			measured_at = excluded.measured_at,
+			error = excluded.error
+	`, impact.VersionID, impact.Metric, impact.Unit, impact.Before, impact.After, impact.Window, impact.MeasuredAt, impact.Error)
+	if err != nil {
+		return fmt.Errorf("failed to save impact: %w", err)
+	}
+	return nil
+}
+
+// GetImpacts returns the impacts measured for a version, by metric name
+func (s *SQLiteStore) GetImpacts(versionID int64) ([]Impact, error) {
+	rows, err := s.readDB.Query(`
+		SELECT version_id, metric, unit, before_value, after_value, window_length, measured_at, error
+		FROM version_impacts WHERE version_id = ? ORDER BY metric
+	`, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get impacts: %w", err)
+	}
+	defer rows.Close()
+
+	var impacts []Impact
+	for rows.Next() {
+		var i Impact
+		var unit, window, measuredAt, errMsg sql.NullString
+		var before, after sql.NullFloat64
+		if err := rows.Scan(&i.VersionID, &i.Metric, &unit, &before, &after, &window, &measuredAt, &errMsg); err != nil {
+			return nil, fmt.Errorf("failed to scan impact row: %w", err)
+		}
+		i.Unit = unit.String
+		i.Window = window.String
+		i.Error = errMsg.String
+		if before.Valid {
+			i.Before = &before.Float64
+		}
+		if after.Valid {
+			i.After = &after.Float64
+		}
+		if measuredAt.Valid {
+			i.MeasuredAt = parseTime(measuredAt.String)
+		}
+		impacts = append(impacts, i)
+	}
+	return impacts, rows.Err()
+}
+
+// GetLastContentVersion returns the latest version of a file that has
+// content, skipping deletions and empty versions, or nil if there is none
+func (s *SQLiteStore) GetLastContentVersion(fileID int64) (*Version, error) {
+	row := s.readDB.QueryRow(`
+		SELECT `+versionColumns+`
+		FROM versions v WHERE v.file_id = ? AND v.change_type != ? AND (v.content != '' OR v.size > 0)
+		ORDER BY v.captured_at DESC, v.id DESC LIMIT 1
+	`, fileID, ChangeTypeDeleted)
+
+	v, err := scanVersion(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last version with content: %w", err)
+	}
+
+	return v, nil
+}
+
+// SearchChanges returns recorded versions matching the query, newest first
+func (s *SQLiteStore) SearchChanges(query SearchQuery) ([]ChangeEvent, error) {
+	var conditions []string
+	var args []interface{}
+
+	if query.Text != "" {
+		pattern := "%" + escapeLike(query.Text) + "%"
+		conditions = append(conditions, `(f.blob_path LIKE ? ESCAPE '\' OR v.content LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	if query.PathPrefix != "" {
+		conditions = append(conditions, `f.blob_path LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(query.PathPrefix)+"%")
+	}
+
+	if len(query.PathPrefixes) > 0 {
+		var matches []string
+		for _, prefix := range query.PathPrefixes {
+			matches = append(matches, `f.blob_path LIKE ? ESCAPE '\'`)
+			args = append(args, escapeLike(prefix)+"%")
+		}
+		conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+	}
+
+	if labelCondition, labelArgs := labelConditions(query.Labels); labelCondition != "" {
+		conditions = append(conditions, labelCondition)
+		args = append(args, labelArgs...)
+	}
+
+	// Blob paths are stored as storageaccount/container/path
+	if query.StorageAccount != "" {
+		prefix := escapeLike(query.StorageAccount) + "/"
+		if query.Container != "" {
+			prefix += escapeLike(query.Container) + "/"
+		}
+		conditions = append(conditions, `f.blob_path LIKE ? ESCAPE '\'`)
+		args = append(args, prefix+"%")
+	} else if query.Container != "" {
+		conditions = append(conditions, `f.blob_path LIKE ? ESCAPE '\'`)
+		args = append(args, "%/"+escapeLike(query.Container)+"/%")
+	}
+
+	if query.ChangeType != "" {
+		conditions = append(conditions, "v.change_type = ?")
+		args = append(args, query.ChangeType)
+	}
+	if query.Restored {
+		conditions = append(conditions, "v.restored_from_version_id IS NOT NULL")
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM version_tags t WHERE t.version_id = v.id AND t.tag = ?)")
+		args = append(args, query.Tag)
+	}
+	// Timestamps are stored as text in UTC, so bounds are converted to UTC
+	// for the comparison to be lexically correct
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "v.captured_at >= ?")
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "v.captured_at < ?")
+		args = append(args, query.Until.UTC())
+	}
+
+	sqlQuery := `
+		SELECT v.id, v.file_id, f.blob_path, v.change_type, v.content_hash, v.captured_at,
+			v.author, v.comment, v.restored_from_version_id
+		FROM versions v
+		JOIN files f ON v.file_id = f.id
+	`
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY v.captured_at DESC, v.id DESC"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.readDB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search changes: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ChangeEvent
+	for rows.Next() {
+		var e ChangeEvent
+		var capturedAt, author, comment sql.NullString
+		var restoredFromVersionID sql.NullInt64
+
+		if err := rows.Scan(&e.VersionID, &e.FileID, &e.BlobPath, &e.ChangeType, &e.ContentHash, &capturedAt,
+			&author, &comment, &restoredFromVersionID); err != nil {
+			return nil, fmt.Errorf("failed to scan change row: %w", err)
+		}
+		e.Author = author.String
+		e.Comment = comment.String
+		e.RestoredFromVersionID = restoredFromVersionID.Int64
+
+		if capturedAt.Valid {
+			e.CapturedAt = parseTime(capturedAt.String)
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// CreateSubscription creates a new subscription
+func (s *SQLiteStore) CreateSubscription(sub *Subscription) error {
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = s.clock.Now()
+	}
+	if sub.LastSentAt.IsZero() {
+		sub.LastSentAt = sub.CreatedAt
+	}
+
+	result, err := s.exec(`
+		INSERT INTO subscriptions (email, path_prefix, mode, created_by, created_at, last_sent_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, sub.Email, sub.PathPrefix, sub.Mode, sub.CreatedBy, sub.CreatedAt, sub.LastSentAt)
+	if err != nil {
+		return fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		sub.ID = id
+	}
+
+	return nil
+}
+
+// ListSubscriptions returns all subscriptions
+func (s *SQLiteStore) ListSubscriptions() ([]Subscription, error) {
+	rows, err := s.readDB.Query(`
+		SELECT id, email, path_prefix, mode, created_by, created_at, last_sent_at
+		FROM subscriptions ORDER BY email, path_prefix
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		var createdAt, lastSentAt sql.NullString
+
+		if err := rows.Scan(&sub.ID, &sub.Email, &sub.PathPrefix, &sub.Mode, &sub.CreatedBy, &createdAt, &lastSentAt); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription row: %w", err)
+		}
+
+		if createdAt.Valid {
+			sub.CreatedAt = parseTime(createdAt.String)
+		}
+		if lastSentAt.Valid {
+			sub.LastSentAt = parseTime(lastSentAt.String)
+		}
+
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// DeleteSubscription deletes a subscription by ID if it was created by
+// createdBy, or by anyone if createdBy is empty, and reports whether it did
+func (s *SQLiteStore) DeleteSubscription(id int64, createdBy string) (bool, error) {
+	query := `DELETE FROM subscriptions WHERE id = ?`
+	args := []interface{}{id}
+	if createdBy != "" {
+		query += ` AND created_by = ?`
+		args = append(args, createdBy)
+	}
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete subscription: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// MarkSubscriptionSent records that a digest covering changes up to sentAt was sent
+func (s *SQLiteStore) MarkSubscriptionSent(id int64, sentAt time.Time) error {
+	_, err := s.exec(`UPDATE subscriptions SET last_sent_at = ? WHERE id = ?`, sentAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update subscription: %w", err)
+	}
+	return nil
+}
+
+// CreateWatch creates a watch. Watching the same path twice is not an error;
+// the existing watch is returned.
+func (s *SQLiteStore) CreateWatch(watch *Watch) error {
+	if watch.CreatedAt.IsZero() {
+		watch.CreatedAt = s.clock.Now()
+	}
+
+	_, err := s.exec(`
+		INSERT INTO watches (user_id, path, exact, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, path, exact) DO NOTHING
+	`, watch.UserID, watch.Path, watch.Exact, watch.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create watch: %w", err)
+	}
+
+	var createdAt sql.NullString
+	err = s.db.QueryRow(`
+		SELECT id, created_at FROM watches WHERE user_id = ? AND path = ? AND exact = ?
+	`, watch.UserID, watch.Path, watch.Exact).Scan(&watch.ID, &createdAt)
+	if err != nil {
+		return fmt.Errorf("failed to get watch: %w", err)
+	}
+	if createdAt.Valid {
+		watch.CreatedAt = parseTime(createdAt.String)
+	}
+
+	return nil
+}
+
+// ListWatches returns the watches of a user, or of all users if userID is empty
+func (s *SQLiteStore) ListWatches(userID string) ([]Watch, error) {
+	query := `SELECT id, user_id, path, exact, created_at FROM watches`
+	var args []interface{}
+	if userID != "" {
+		query += ` WHERE user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY user_id, path`
+
+	rows, err := s.readDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list watches: %w", err)
+	}
+	defer rows.Close()
+
+	var watches []Watch
+	for rows.Next() {
+		var w Watch
+		var createdAt sql.NullString
+
+		if err := rows.Scan(&w.ID, &w.UserID, &w.Path, &w.Exact, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan watch row: %w", err)
+		}
+
+		if createdAt.Valid {
+			w.CreatedAt = parseTime(createdAt.String)
+		}
+
+		watches = append(watches, w)
+	}
+
+	return watches, rows.Err()
+}
+
+// DeleteWatch deletes one of a user's watches
+func (s *SQLiteStore) DeleteWatch(userID string, id int64) error {
+	_, err := s.exec(`DELETE FROM watches WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete watch: %w", err)
+	}
+	return nil
+}
+
+// AddInboxItem delivers a version to a user's inbox. Delivering the same
+// version twice is ignored.
+func (s *SQLiteStore) AddInboxItem(item *InboxItem) error {
+	result, err := s.exec(`
+		INSERT INTO inbox_items (user_id, version_id) VALUES (?, ?)
+		ON CONFLICT(user_id, version_id) DO NOTHING
+	`, item.UserID, item.VersionID)
+	if err != nil {
+		return fmt.Errorf("failed to add inbox item: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		item.ID = id
+	}
+
+	return nil
+}
+
+// ListInbox returns a user's inbox, newest first
+func (s *SQLiteStore) ListInbox(userID string, unreadOnly bool, limit int) ([]InboxItem, error) {
+	query := `
+		SELECT i.id, i.user_id, i.read_at, v.id, v.file_id, f.blob_path, v.change_type, v.content_hash, v.captured_at
+		FROM inbox_items i
+		JOIN versions v ON i.version_id = v.id
+		JOIN files f ON v.file_id = f.id
+		WHERE i.user_id = ?
+	`
+	args := []interface{}{userID}
+	if unreadOnly {
+		query += ` AND i.read_at IS NULL`
+	}
+	query += ` ORDER BY v.captured_at DESC, v.id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.readDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var items []InboxItem
+	for rows.Next() {
+		var item InboxItem
+		var readAt, capturedAt sql.NullString
+
+		err := rows.Scan(&item.ID, &item.UserID, &readAt, &item.VersionID, &item.FileID,
+			&item.BlobPath, &item.ChangeType, &item.ContentHash, &capturedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan inbox row: %w", err)
+		}
+
+		if readAt.Valid {
+			t := parseTime(readAt.String)
+			item.ReadAt = &t
+		}
+		if capturedAt.Valid {
+			item.CapturedAt = parseTime(capturedAt.String)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// MarkInboxRead marks inbox items as read. A nil ids marks the whole inbox.
+func (s *SQLiteStore) MarkInboxRead(userID string, ids []int64) error {
+	query := `UPDATE inbox_items SET read_at = ? WHERE user_id = ? AND read_at IS NULL`
+	args := []interface{}{s.clock.Now(), userID}
+
+	if ids != nil {
+		if len(ids) == 0 {
+			return nil
+		}
+		placeholders := make([]string, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		query += ` AND id IN (` + strings.Join(placeholders, ",") + `)`
+	}
+
+	if _, err := s.exec(query, args...); err != nil {
+		return fmt.Errorf("failed to mark inbox read: %w", err)
+	}
+	return nil
+}
+
+// RecordShadowChange records (or replaces) the dry-run change for a blob path
+func (s *SQLiteStore) RecordShadowChange(change *ShadowChange) error {
+	if change.DetectedAt.IsZero() {
+		change.DetectedAt = s.clock.Now()
+	}
+
+	_, err := s.exec(`
+		INSERT INTO shadow_changes (blob_path, change_type, etag, content_hash, size, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(blob_path) DO UPDATE SET
+			change_type = excluded.change_type,
+			etag = excluded.etag,
+			content_hash = excluded.content_hash,
+			size = excluded.size,
+			detected_at = excluded.detected_at
+	`, change.BlobPath, change.ChangeType, change.ETag, change.ContentHash, change.Size, change.DetectedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record shadow change: %w", err)
+	}
+	return nil
+}
+
+// GetShadowChange returns the dry-run change for a blob path
+func (s *SQLiteStore) GetShadowChange(blobPath string) (*ShadowChange, error) {
+	var change ShadowChange
+	var etag, contentHash, detectedAt sql.NullString
+	var size sql.NullInt64
+
+	err := s.readDB.QueryRow(`
+		SELECT blob_path, change_type, etag, content_hash, size, detected_at
+		FROM shadow_changes WHERE blob_path = ?
+	`, blobPath).Scan(&change.BlobPath, &change.ChangeType, &etag, &contentHash, &size, &detectedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shadow change: %w", err)
+	}
+
+	change.ETag = etag.String
+	change.ContentHash = contentHash.String
+	change.Size = size.Int64
+	if detectedAt.Valid {
+		change.DetectedAt = parseTime(detectedAt.String)
+	}
+
+	return &change, nil
+}
+
+// ListShadowChanges returns the dry-run report ordered by path
+func (s *SQLiteStore) ListShadowChanges() ([]ShadowChange, error) {
+	rows, err := s.readDB.Query(`
+		SELECT blob_path, change_type, etag, content_hash, size, detected_at
+		FROM shadow_changes ORDER BY blob_path
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shadow changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []ShadowChange
+	for rows.Next() {
+		var change ShadowChange
+		var etag, contentHash, detectedAt sql.NullString
+		var size sql.NullInt64
+
+		if err := rows.Scan(&change.BlobPath, &change.ChangeType, &etag, &contentHash, &size, &detectedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan shadow change row: %w", err)
+		}
+
+		change.ETag = etag.String
+		change.ContentHash = contentHash.String
+		change.Size = size.Int64
+		if detectedAt.Valid {
+			change.DetectedAt = parseTime(detectedAt.String)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// ClearShadowChanges empties the dry-run report
+func (s *SQLiteStore) ClearShadowChanges() error {
+	if _, err := s.exec(`DELETE FROM shadow_changes`); err != nil {
+		return fmt.Errorf("failed to clear shadow changes: %w", err)
+	}
+	return nil
+}
+
+// RecordSyncError records an error processing a blob, counting it if the
+// blob already has one
+func (s *SQLiteStore) RecordSyncError(blobPath, message string) error {
+	now := s.clock.Now()
+	_, err := s.exec(`
+		INSERT INTO sync_errors (blob_path, error, count, first_seen_at, last_seen_at)
+		VALUES (?, ?, 1, ?, ?)
+		ON CONFLICT(blob_path) DO UPDATE SET
+			error = excluded.error,
+			count = sync_errors.count + 1,
+			last_seen_at = excluded.last_seen_at
+	`, blobPath, message, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to record sync error: %w", err)
+	}
+	return nil
+}
+
+// ListSyncErrors returns the blobs that failed to sync, most recent first
+func (s *SQLiteStore) ListSyncErrors() ([]SyncError, error) {
+	rows, err := s.readDB.Query(`
+		SELECT blob_path, error, count, first_seen_at, last_seen_at
+		FROM sync_errors ORDER BY last_seen_at DESC, blob_path
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sync errors: %w", err)
+	}
+	defer rows.Close()
+
+	var syncErrors []SyncError
+	for rows.Next() {
+		var e SyncError
+		var firstSeenAt, lastSeenAt sql.NullString
+		if err := rows.Scan(&e.BlobPath, &e.Error, &e.Count, &firstSeenAt, &lastSeenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sync error row: %w", err)
+		}
+		e.FirstSeenAt = parseTime(firstSeenAt.String)
+		e.LastSeenAt = parseTime(lastSeenAt.String)
+		syncErrors = append(syncErrors, e)
+	}
+
+	return syncErrors, rows.Err()
+}
+
+// ClearSyncError removes the error of a blob. Sync errors aren't part of the
+// recorded history, so they can be cleared in an append-only store.
+func (s *SQLiteStore) ClearSyncError(blobPath string) error {
+	if _, err := s.exec(`DELETE FROM sync_errors WHERE blob_path = ?`, blobPath); err != nil {
+		return fmt.Errorf("failed to clear sync error: %w", err)
+	}
+	return nil
+}
+
+// PauseSync stores a pause of a storage account or container, replacing the
+// reason of an existing pause of the same one
+func (s *SQLiteStore) PauseSync(pause *SyncPause) error {
+	if pause.PausedAt.IsZero() {
+		pause.PausedAt = s.clock.Now()
+	}
+
+	err := s.inTx(func(tx *sql.Tx) error {
+		return tx.QueryRow(`
+			INSERT INTO sync_pauses (storage_account, container, reason, paused_by, paused_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(storage_account, container) DO UPDATE SET
+				reason = excluded.reason,
+				paused_by = excluded.paused_by,
+				paused_at = excluded.paused_at
+			RETURNING id
+		`, pause.StorageAccount, pause.Container, pause.Reason, pause.PausedBy, pause.PausedAt).Scan(&pause.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pause sync: %w", err)
+	}
+	return nil
+}
+
+// ListSyncPauses returns the paused storage accounts and containers
+func (s *SQLiteStore) ListSyncPauses() ([]SyncPause, error) {
+	rows, err := s.readDB.Query(`
+		SELECT id, storage_account, container, reason, paused_by, paused_at
+		FROM sync_pauses ORDER BY storage_account, container
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sync pauses: %w", err)
+	}
+	defer rows.Close()
+
+	var pauses []SyncPause
+	for rows.Next() {
+		var p SyncPause
+		var reason, pausedBy, pausedAt sql.NullString
+		if err := rows.Scan(&p.ID, &p.StorageAccount, &p.Container, &reason, &pausedBy, &pausedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sync pause row: %w", err)
+		}
+		p.Reason = reason.String
+		p.PausedBy = pausedBy.String
+		p.PausedAt = parseTime(pausedAt.String)
+		pauses = append(pauses, p)
+	}
+
+	return pauses, rows.Err()
+}
+
+// ResumeSync removes a pause, reporting whether it existed. Pauses aren't
+// part of the recorded history, so they can be removed in an append-only
+// store.
+func (s *SQLiteStore) ResumeSync(id int64) (bool, error) {
+	result, err := s.exec(`DELETE FROM sync_pauses WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to resume sync: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to resume sync: %w", err)
+	}
+	return n > 0, nil
+}
+
+// GetSyncState returns the value of a sync state key, or "" if it isn't set
+func (s *SQLiteStore) GetSyncState(key string) (string, error) {
+	var value string
+	err := s.readDB.QueryRow(`SELECT value FROM sync_state WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get sync state: %w", err)
+	}
+	return value, nil
+}
+
+// SetSyncState sets the value of a sync state key
+func (s *SQLiteStore) SetSyncState(key, value string) error {
+	_, err := s.exec(`
+		INSERT INTO sync_state (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at
+	`, key, value, s.clock.Now())
+	if err != nil {
+		return fmt.Errorf("failed to set sync state: %w", err)
+	}
+	return nil
+}
+
+// jobColumns is the column list read by scanJob
+const jobColumns = `id, kind, status, admin, created_by, done, total, result, error, output_name,
+	callback_url, callback_error, created_at, started_at, finished_at`
+
+// scanJob reads a job selected with jobColumns
+func scanJob(row rowScanner) (*Job, error) {
+	var j Job
+	var createdBy, result, errMsg, outputName, callbackURL, callbackErr, createdAt, startedAt, finishedAt sql.NullString
+	err := row.Scan(&j.ID, &j.Kind, &j.Status, &j.Admin, &createdBy, &j.Done, &j.Total, &result, &errMsg,
+		&outputName, &callbackURL, &callbackErr, &createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	j.CreatedBy = createdBy.String
+	if result.String != "" {
+		j.Result = json.RawMessage(result.String)
+	}
+	j.Error = errMsg.String
+	j.OutputName = outputName.String
+	j.CallbackURL = callbackURL.String
+	j.CallbackError = callbackErr.String
+	if createdAt.Valid {
+		j.CreatedAt = parseTime(createdAt.String)
+	}
+	if startedAt.Valid {
+		t := parseTime(startedAt.String)
+		j.StartedAt = &t
+	}
+	if finishedAt.Valid {
+		t := parseTime(finishedAt.String)
+		j.FinishedAt = &t
+	}
+	return &j, nil
+}
+
+// CreateJob stores a new job
+func (s *SQLiteStore) CreateJob(job *Job) error {
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = s.clock.Now()
+	}
+
+	result, err := s.exec(`
+		INSERT INTO jobs (kind, status, admin, created_by, total, callback_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, job.Kind, job.Status, job.Admin, job.CreatedBy, job.Total, job.CallbackURL, job.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	job.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	return nil
+}
+
+// UpdateJob stores the status, progress and outcome of a job, and the
+// outcome of its callback
+func (s *SQLiteStore) UpdateJob(job *Job) error {
+	var result sql.NullString
+	if len(job.Result) > 0 {
+		result = sql.NullString{String: string(job.Result), Valid: true}
+	}
+
+	_, err := s.exec(`
+		UPDATE jobs
+		SET status = ?, done = ?, total = ?, result = ?, error = ?, callback_error = ?, started_at = ?, finished_at = ?
+		WHERE id = ?
+	`, job.Status, job.Done, job.Total, result, job.Error, job.CallbackError, job.StartedAt, job.FinishedAt, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// SetJobOutput stores the file a job produced
+func (s *SQLiteStore) SetJobOutput(id int64, output []byte, contentType, name string) error {
+	_, err := s.exec(`
+		UPDATE jobs SET output = ?, output_type = ?, output_name = ? WHERE id = ?
+	`, output, contentType, name, id)
+	if err != nil {
+		return fmt.Errorf("failed to set job output: %w", err)
+	}
+	return nil
+}
+
+// GetJob retrieves a job by ID, without its output
+func (s *SQLiteStore) GetJob(id int64) (*Job, error) {
+	job, err := scanJob(s.readDB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return job, nil
+}
+
+// GetJobOutput returns the file a job produced, or nil if there is none
+func (s *SQLiteStore) GetJobOutput(id int64) ([]byte, string, string, error) {
+	var output []byte
+	var contentType, name sql.NullString
+	err := s.readDB.QueryRow(`
+		SELECT output, output_type, output_name FROM jobs WHERE id = ?
+	`, id).Scan(&output, &contentType, &name)
+	if err == sql.ErrNoRows {
+		return nil, "", "", nil
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to get job output: %w", err)
+	}
+	return output, contentType.String, name.String, nil
+}
+
+// ListJobs returns the latest jobs, newest first
+func (s *SQLiteStore) ListJobs(limit int) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs ORDER BY id DESC`
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.readDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job row: %w", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// FailUnfinishedJobs fails the jobs left queued or running with message
+func (s *SQLiteStore) FailUnfinishedJobs(message string) (int64, error) {
+	result, err := s.exec(`
+		UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE status IN (?, ?)
+	`, JobFailed, message, s.clock.Now(), JobQueued, JobRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail unfinished jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// DeleteJobsFinishedBefore deletes the jobs that finished before t, with
+// their output. Jobs aren't part of the recorded history, so they can be
+// deleted in an append-only store.
+func (s *SQLiteStore) DeleteJobsFinishedBefore(t time.Time) (int64, error) {
+	result, err := s.exec(`DELETE FROM jobs WHERE finished_at IS NOT NULL AND finished_at < ?`, t)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// viewTokenColumns are the columns scanned by scanViewToken
+const viewTokenColumns = `id, token_hash, blob_path, from_version_id, to_version_id, created_by, created_at, expires_at, revoked_at`
+
+// CreateViewToken records a view token, setting its ID
+func (s *SQLiteStore) CreateViewToken(token *ViewToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = s.clock.Now()
+	}
+	result, err := s.exec(`
+		INSERT INTO view_tokens (token_hash, blob_path, from_version_id, to_version_id, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, token.TokenHash, token.BlobPath,
+		sql.NullInt64{Int64: token.FromVersionID, Valid: token.FromVersionID != 0},
+		sql.NullInt64{Int64: token.ToVersionID, Valid: token.ToVersionID != 0},
+		token.CreatedBy, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to create view token: %w", err)
+	}
+	token.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetViewToken returns the view token with the hash, or nil if there is none,
+// whether or not it is still valid
+func (s *SQLiteStore) GetViewToken(tokenHash string) (*ViewToken, error) {
+	row := s.readDB.QueryRow(`SELECT `+viewTokenColumns+` FROM view_tokens WHERE token_hash = ?`, tokenHash)
+	token, err := scanViewToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get view token: %w", err)
+	}
+	return token, nil
+}
+
+// ListViewTokens returns the view tokens made by createdBy, or by anyone if
+// it is empty, newest first
+func (s *SQLiteStore) ListViewTokens(createdBy string) ([]ViewToken, error) {
+	query := `SELECT ` + viewTokenColumns + ` FROM view_tokens`
+	var args []interface{}
+	if createdBy != "" {
+		query += ` WHERE created_by = ?`
+		args = append(args, createdBy)
+	}
+	query += ` ORDER BY id DESC`
+
+	rows, err := s.readDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list view tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []ViewToken
+	for rows.Next() {
+		token, err := scanViewToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan view token row: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeViewToken revokes a view token made by createdBy, or by anyone if it
+// is empty, reporting whether there was one to revoke
+func (s *SQLiteStore) RevokeViewToken(id int64, createdBy string) (bool, error) {
+	query := `UPDATE view_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`
+	args := []interface{}{s.clock.Now(), id}
+	if createdBy != "" {
+		query += ` AND created_by = ?`
+		args = append(args, createdBy)
+	}
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke view token: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// scanViewToken scans a row selected with viewTokenColumns
+func scanViewToken(row rowScanner) (*ViewToken, error) {
+	var token ViewToken
+	var from, to sql.NullInt64
+	var createdBy, createdAt, expiresAt, revokedAt sql.NullString
+	err := row.Scan(&token.ID, &token.TokenHash, &token.BlobPath, &from, &to, &createdBy, &createdAt, &expiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.FromVersionID = from.Int64
+	token.ToVersionID = to.Int64
+	token.CreatedBy = createdBy.String
+	token.CreatedAt = parseTime(createdAt.String)
+	token.ExpiresAt = parseTime(expiresAt.String)
+	if revokedAt.Valid {
+		revoked := parseTime(revokedAt.String)
+		token.RevokedAt = &revoked
+	}
+	return &token, nil
+}
+
+// ClaimIdempotencyKey claims key in scope for the request with fingerprint,
+// first forgetting the keys claimed before expiredBefore. It returns nil if
+// the key was claimed, or the recorded response, which isn't Done while the
+// request that claimed it is running.
+func (s *SQLiteStore) ClaimIdempotencyKey(scope, key, fingerprint string, expiredBefore time.Time) (*IdempotentResponse, error) {
+	var existing *IdempotentResponse
+	err := s.inTx(func(tx *sql.Tx) error {
+		existing = nil
+		if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, expiredBefore); err != nil {
+			return err
+		}
+		result, err := tx.Exec(`
+			INSERT OR IGNORE INTO idempotency_keys (scope, key, fingerprint, created_at)
+			VALUES (?, ?, ?, ?)
+		`, scope, key, fingerprint, s.clock.Now())
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		var response IdempotentResponse
+		var contentType, location sql.NullString
+		err = tx.QueryRow(`
+			SELECT fingerprint, status, content_type, location, body, created_at
+			FROM idempotency_keys WHERE scope = ? AND key = ?
+		`, scope, key).Scan(&response.Fingerprint, &response.Status, &contentType, &location, &response.Body, &response.CreatedAt)
+		if err != nil {
+			return err
+		}
+		response.Done = response.Status != 0
+		response.ContentType = contentType.String
+		response.Location = location.String
+		existing = &response
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	return existing, nil
+}
+
+// SaveIdempotentResponse records the response to the request that claimed
+// key in scope
+func (s *SQLiteStore) SaveIdempotentResponse(scope, key string, response *IdempotentResponse) error {
+	_, err := s.exec(`
+		UPDATE idempotency_keys SET status = ?, content_type = ?, location = ?, body = ?
+		WHERE scope = ? AND key = ?
+	`, response.Status, response.ContentType, response.Location, response.Body, scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key in scope
+func (s *SQLiteStore) ReleaseIdempotencyKey(scope, key string) error {
+	if _, err := s.exec(`DELETE FROM idempotency_keys WHERE scope = ? AND key = ?`, scope, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in a user-supplied search term
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return r.Replace(s)
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVersion scans a row selected with versionColumns
+func scanVersion(row rowScanner) (*Version, error) {
+	var v Version
+	var capturedAt, blobLastModified, snapshotID, author, comment, signature, signatureKeyID sql.NullString
+	var contentPending, truncated, encrypted sql.NullBool
+	var size, deletedVersionID, restoredFromVersionID sql.NullInt64
+
+	err := row.Scan(&v.ID, &v.FileID, &v.Content, &v.ContentHash, &v.ChangeType, &capturedAt,
+		&v.BlobETag, &blobLastModified, &contentPending, &size, &truncated, &snapshotID, &author, &comment,
+		&deletedVersionID, &signature, &signatureKeyID, &encrypted, &restoredFromVersionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if capturedAt.Valid {
+		v.CapturedAt = parseTime(capturedAt.String)
+	}
+	if blobLastModified.Valid {
+		v.BlobLastModified = parseTime(blobLastModified.String)
+	}
+	v.ContentPending = contentPending.Bool
+	v.Size = size.Int64
+	v.Truncated = truncated.Bool
+	v.SnapshotID = snapshotID.String
+	v.Author = author.String
+	v.Comment = comment.String
+	v.DeletedVersionID = deletedVersionID.Int64
+	v.RestoredFromVersionID = restoredFromVersionID.Int64
+	v.Signature = signature.String
+	v.SignatureKeyID = signatureKeyID.String
+	v.Encrypted = encrypted.Bool
+
+	return &v, nil
+}
+
+// scanVersions is a helper to scan multiple version rows
+func scanVersions(rows *sql.Rows) ([]Version, error) {
+	var versions []Version
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version row: %w", err)
+		}
+
+		versions = append(versions, *v)
+	}
+
+	return versions, rows.Err()
+}
+
+// parseTime parses a SQLite datetime string into time.Time
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+
+	// Try various SQLite datetime formats
+	formats := []string{
+		"2006-01-02 15:04:05",
+		// As times are written by the driver, and read back by aggregates
+		"2006-01-02 15:04:05.999999999-07:00",
+		"2006-01-02T15:04:05Z",
+		"2006-01-02T15:04:05.000Z",
+		time.RFC3339,
+		time.RFC3339Nano,
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// proposalColumns is the column list read by scanProposal
+const proposalColumns = `id, blob_path, kind, content, base_version_id, base_etag,
+	restore_version_id, author, comment, status, reviewer, review_comment, error,
+	version_id, pull_request_number, pull_request_url, created_at, updated_at`
+
+// scanProposal reads a proposal selected with proposalColumns
+func scanProposal(row rowScanner) (*Proposal, error) {
+	var p Proposal
+	var baseVersionID, restoreVersionID, versionID, prNumber sql.NullInt64
+	var baseETag, comment, reviewer, reviewComment, errMsg, prURL, createdAt, updatedAt sql.NullString
+
+	err := row.Scan(&p.ID, &p.BlobPath, &p.Kind, &p.Content, &baseVersionID, &baseETag,
+		&restoreVersionID, &p.Author, &comment, &p.Status, &reviewer, &reviewComment, &errMsg,
+		&versionID, &prNumber, &prURL, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	p.BaseVersionID = baseVersionID.Int64
+	p.BaseETag = baseETag.String
+	p.RestoreVersionID = restoreVersionID.Int64
+	p.Comment = comment.String
+	p.Reviewer = reviewer.String
+	p.ReviewComment = reviewComment.String
+	p.Error = errMsg.String
+	p.VersionID = versionID.Int64
+	p.PullRequestNumber = int(prNumber.Int64)
+	p.PullRequestURL = prURL.String
+	if createdAt.Valid {
+		p.CreatedAt = parseTime(createdAt.String)
+	}
+	if updatedAt.Valid {
+		p.UpdatedAt = parseTime(updatedAt.String)
+	}
+
+	return &p, nil
+}
+
+// CreateProposal stores a new proposal
+func (s *SQLiteStore) CreateProposal(p *Proposal) error {
+	now := s.clock.Now()
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = p.CreatedAt
+
+	result, err := s.exec(`
+		INSERT INTO proposals (blob_path, kind, content, base_version_id, base_etag,
+			restore_version_id, author, comment, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.BlobPath, p.Kind, p.Content, p.BaseVersionID, p.BaseETag,
+		p.RestoreVersionID, p.Author, p.Comment, p.Status, p.CreatedAt, p.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create proposal: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get proposal ID: %w", err)
+	}
+	p.ID = id
+
+	return nil
+}
+
+// GetProposal retrieves a proposal by ID
+func (s *SQLiteStore) GetProposal(id int64) (*Proposal, error) {
+	p, err := scanProposal(s.readDB.QueryRow(`SELECT `+proposalColumns+` FROM proposals WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get proposal: %w", err)
+	}
+	return p, nil
+}
+
+// ListProposals returns proposals matching the query, newest first
+func (s *SQLiteStore) ListProposals(query ProposalQuery) ([]Proposal, error) {
+	var conditions []string
+	var args []interface{}
+
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+	if query.BlobPath != "" {
+		conditions = append(conditions, "blob_path = ?")
+		args = append(args, query.BlobPath)
+	}
+	if query.Author != "" {
+		conditions = append(conditions, "author = ?")
+		args = append(args, query.Author)
+	}
+
+	sqlQuery := `SELECT ` + proposalColumns + ` FROM proposals`
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY created_at DESC, id DESC"
+	if query.Limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.readDB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list proposals: %w", err)
+	}
+	defer rows.Close()
+
+	var proposals []Proposal
+	for rows.Next() {
+		p, err := scanProposal(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan proposal row: %w", err)
+		}
+		proposals = append(proposals, *p)
+	}
+
+	return proposals, rows.Err()
+}
+
+// UpdateProposal saves the review state of a proposal, provided its stored
+// status is still from. This makes each transition happen at most once even
+// when two reviewers act at the same time.
+func (s *SQLiteStore) UpdateProposal(p *Proposal, from ProposalStatus) (bool, error) {
+	p.UpdatedAt = s.clock.Now()
+
+	result, err := s.exec(`
+		UPDATE proposals
+		SET status = ?, content = ?, reviewer = ?, review_comment = ?, error = ?, version_id = ?, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, p.Status, p.Content, p.Reviewer, p.ReviewComment, p.Error, p.VersionID, p.UpdatedAt, p.ID, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to update proposal: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update proposal: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SetProposalPullRequest links a proposal to the pull request reviewing it
+func (s *SQLiteStore) SetProposalPullRequest(id int64, number int, url string) error {
+	_, err := s.exec(`
+		UPDATE proposals SET pull_request_number = ?, pull_request_url = ? WHERE id = ?
+	`, number, url, id)
+	if err != nil {
+		return fmt.Errorf("failed to set proposal pull request: %w", err)
+	}
+	return nil
+}
+
+// trackingRuleColumns is the column list read by scanTrackingRule
+const trackingRuleColumns = `id, storage_account, container, prefix, patterns, created_by, created_at, updated_at`
+
+// scanTrackingRule reads a tracking rule selected with trackingRuleColumns.
+// Patterns are stored one per line.
+func scanTrackingRule(row rowScanner) (*TrackingRule, error) {
+	var rule TrackingRule
+	var patterns string
+	var createdBy, createdAt, updatedAt sql.NullString
+
+	err := row.Scan(&rule.ID, &rule.StorageAccount, &rule.Container, &rule.Prefix, &patterns,
+		&createdBy, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if patterns != "" {
+		rule.Patterns = strings.Split(patterns, "\n")
+	}
+	rule.CreatedBy = createdBy.String
+	if createdAt.Valid {
+		rule.CreatedAt = parseTime(createdAt.String)
+	}
+	if updatedAt.Valid {
+		rule.UpdatedAt = parseTime(updatedAt.String)
+	}
+
+	return &rule, nil
+}
+
+// CreateTrackingRule stores a new tracking rule
+func (s *SQLiteStore) CreateTrackingRule(rule *TrackingRule) error {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = s.clock.Now()
+	}
+	rule.UpdatedAt = rule.CreatedAt
+
+	result, err := s.exec(`
+		INSERT INTO tracking_rules (storage_account, container, prefix, patterns, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, rule.StorageAccount, rule.Container, rule.Prefix, strings.Join(rule.Patterns, "\n"),
+		rule.CreatedBy, rule.CreatedAt, rule.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create tracking rule: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err == nil {
+		rule.ID = id
+	}
+
+	return nil
+}
+
+// GetTrackingRule returns a tracking rule, or nil if it doesn't exist
+func (s *SQLiteStore) GetTrackingRule(id int64) (*TrackingRule, error) {
+	rule, err := scanTrackingRule(s.readDB.QueryRow(`SELECT `+trackingRuleColumns+` FROM tracking_rules WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracking rule: %w", err)
+	}
+	return rule, nil
+}
+
+// ListTrackingRules returns all tracking rules
+func (s *SQLiteStore) ListTrackingRules() ([]TrackingRule, error) {
+	rows, err := s.readDB.Query(`
+		SELECT ` + trackingRuleColumns + ` FROM tracking_rules
+		ORDER BY storage_account, container, prefix
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tracking rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []TrackingRule
+	for rows.Next() {
+		rule, err := scanTrackingRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tracking rule row: %w", err)
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// UpdateTrackingRule replaces the scope of an existing tracking rule
+func (s *SQLiteStore) UpdateTrackingRule(rule *TrackingRule) error {
+	rule.UpdatedAt = s.clock.Now()
+
+	_, err := s.exec(`
+		UPDATE tracking_rules
+		SET storage_account = ?, container = ?, prefix = ?, patterns = ?, updated_at = ?
+		WHERE id = ?
+	`, rule.StorageAccount, rule.Container, rule.Prefix, strings.Join(rule.Patterns, "\n"),
+		rule.UpdatedAt, rule.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update tracking rule: %w", err)
+	}
+	return nil
+}
+
+// DeleteTrackingRule deletes a tracking rule
+func (s *SQLiteStore) DeleteTrackingRule(id int64) error {
+	_, err := s.exec(`DELETE FROM tracking_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete tracking rule: %w", err)
+	}
+	return nil
+}
+
+// GetVersionTags returns the tags of a file's versions, by version ID, in
+// the order they were set
+func (s *SQLiteStore) GetVersionTags(fileID int64) (map[int64][]string, error) {
+	rows, err := s.readDB.Query(`
+		SELECT t.version_id, t.tag FROM version_tags t
+		JOIN versions v ON v.id = t.version_id
+		WHERE v.file_id = ?
+		ORDER BY t.tagged_at, t.tag
+	`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]string)
+	for rows.Next() {
+		var versionID int64
+		var tag string
+		if err := rows.Scan(&versionID, &tag); err != nil {
+			return nil, fmt.Errorf("failed to scan version tag row: %w", err)
+		}
+		tags[versionID] = append(tags[versionID], tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// GetFileLabels returns the labels set on a file
+func (s *SQLiteStore) GetFileLabels(fileID int64) (map[string]string, error) {
+	rows, err := s.readDB.Query(`SELECT key, value FROM file_labels WHERE file_id = ?`, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file labels: %w", err)
+	}
+	defer rows.Close()
+
+	labels := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan file label row: %w", err)
+		}
+		labels[key] = value
+	}
+
+	return labels, rows.Err()
+}
+
+// ListFileLabels returns the labels set on every file, by file ID
+func (s *SQLiteStore) ListFileLabels() (map[int64]map[string]string, error) {
+	rows, err := s.readDB.Query(`SELECT file_id, key, value FROM file_labels`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list file labels: %w", err)
+	}
+	defer rows.Close()
+
+	labels := make(map[int64]map[string]string)
+	for rows.Next() {
+		var fileID int64
+		var key, value string
+		if err := rows.Scan(&fileID, &key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan file label row: %w", err)
+		}
+		if labels[fileID] == nil {
+			labels[fileID] = make(map[string]string)
+		}
+		labels[fileID][key] = value
+	}
+
+	return labels, rows.Err()
+}
+
+// labelConditions returns the condition on the files table, aliased f,
+// matching every label selector, or "" if there are none
+func labelConditions(selectors []LabelSelector) (string, []any) {
+	var conditions []string
+	var args []any
+	for _, sel := range selectors {
+		condition := `EXISTS (SELECT 1 FROM file_labels l WHERE l.file_id = f.id AND l.key = ?`
+		args = append(args, sel.Key)
+		if !sel.AnyValue {
+			condition += ` AND l.value = ?`
+			args = append(args, sel.Value)
+		}
+		condition += `)`
+
+		// Files without the label set have it if their path derives it
+		if len(sel.Derived) > 0 {
+			args = append(args, sel.Key)
+			var derived []string
+			for _, paths := range sel.Derived {
+				match := `f.blob_path LIKE ? ESCAPE '\'`
+				args = append(args, escapeLike(paths.Prefix)+"%")
+				for _, except := range paths.Except {
+					match += ` AND f.blob_path NOT LIKE ? ESCAPE '\'`
+					args = append(args, escapeLike(except)+"%")
+				}
+				derived = append(derived, "("+match+")")
+			}
+			condition += ` OR (NOT EXISTS (SELECT 1 FROM file_labels l WHERE l.file_id = f.id AND l.key = ?) AND (` +
+				strings.Join(derived, " OR ") + `))`
+		}
+		conditions = append(conditions, "("+condition+")")
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// SetFileLabels replaces the labels set on a file
+func (s *SQLiteStore) SetFileLabels(fileID int64, labels map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM file_labels WHERE file_id = ?`, fileID); err != nil {
+		return fmt.Errorf("failed to clear file labels: %w", err)
+	}
+	for key, value := range labels {
+		if _, err := tx.Exec(`INSERT INTO file_labels (file_id, key, value) VALUES (?, ?, ?)`, fileID, key, value); err != nil {
+			return fmt.Errorf("failed to set file label: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file labels: %w", err)
+	}
+	return nil
 }
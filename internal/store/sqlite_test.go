@@ -0,0 +1,487 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/toggle-vault/internal/clock"
+)
+
+// BenchmarkListFiles lists 10,000 files with 3 versions each, the size at
+// which a per-file lookup of the latest change type became slow
+func BenchmarkListFiles(b *testing.B) {
+	const files, versionsPerFile = 10000, 3
+
+	s, err := NewSQLiteStore(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for v := 0; v < versionsPerFile; v++ {
+		changeType := ChangeTypeModified
+		if v == 0 {
+			changeType = ChangeTypeCreated
+		}
+		writes := make([]CaptureWrite, files)
+		for i := range writes {
+			content := fmt.Sprintf("key: %d-%d\n", i, v)
+			hash := fmt.Sprintf("hash-%d-%d", i, v)
+			etag := fmt.Sprintf("etag-%d-%d", i, v)
+			writes[i] = CaptureWrite{
+				File: &File{BlobPath: fmt.Sprintf("account/container/files/%05d.yaml", i), ETag: etag, ContentHash: hash},
+				Version: &Version{
+					Content:     content,
+					ContentHash: hash,
+					ChangeType:  changeType,
+					CapturedAt:  start.Add(time.Duration(v) * time.Minute),
+					BlobETag:    etag,
+					Size:        int64(len(content)),
+				},
+			}
+		}
+		if err := s.WriteCaptures(writes); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		listed, err := s.ListFiles()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(listed) != files {
+			b.Fatalf("listed %d files, want %d", len(listed), files)
+		}
+	}
+}
+
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// createTestFile records a file with versions of the given change types,
+// captured a minute apart from start
+func createTestFile(t *testing.T, s *SQLiteStore, blobPath string, start time.Time, changeTypes ...ChangeType) *File {
+	t.Helper()
+	file := &File{BlobPath: blobPath, ETag: "etag", ContentHash: "hash", LastModified: start}
+	if err := s.UpsertFile(file); err != nil {
+		t.Fatal(err)
+	}
+	for i, changeType := range changeTypes {
+		v := &Version{
+			FileID:      file.ID,
+			Content:     fmt.Sprintf("v%d", i),
+			ContentHash: fmt.Sprintf("%s-hash-%d", blobPath, i),
+			ChangeType:  changeType,
+			CapturedAt:  start.Add(time.Duration(i) * time.Minute),
+			BlobETag:    fmt.Sprintf("etag-%d", i),
+		}
+		if err := s.CreateVersion(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file
+}
+
+func TestListFiles(t *testing.T) {
+	s := newTestStore(t)
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	createTestFile(t, s, "account/container/a.yaml", start, ChangeTypeCreated)
+	createTestFile(t, s, "account/container/b.yaml", start, ChangeTypeCreated, ChangeTypeModified, ChangeTypeRestored)
+	createTestFile(t, s, "account/container/c.yaml", start, ChangeTypeCreated, ChangeTypeDeleted)
+	if err := s.MarkFileDeleted("account/container/c.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	createTestFile(t, s, "account/container/d.yaml", start)
+
+	files, err := s.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path           string
+		wantVersions   int
+		wantChangeType ChangeType
+		wantDeleted    bool
+	}{
+		{"account/container/a.yaml", 1, ChangeTypeCreated, false},
+		{"account/container/b.yaml", 3, ChangeTypeRestored, false},
+		{"account/container/c.yaml", 2, ChangeTypeDeleted, true},
+		{"account/container/d.yaml", 0, "", false},
+	}
+	if len(files) != len(tests) {
+		t.Fatalf("listed %d files, want %d", len(files), len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f := files[i]
+			if f.BlobPath != tt.path {
+				t.Fatalf("file %d = %s, want %s", i, f.BlobPath, tt.path)
+			}
+			if f.VersionCount != tt.wantVersions {
+				t.Errorf("versions = %d, want %d", f.VersionCount, tt.wantVersions)
+			}
+			if f.LatestChangeType != tt.wantChangeType {
+				t.Errorf("latest change type = %q, want %q", f.LatestChangeType, tt.wantChangeType)
+			}
+			if f.IsDeleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", f.IsDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestListChurnedFiles(t *testing.T) {
+	s := newTestStore(t)
+	since := time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC)
+	// 12:30 at UTC+2 is before since, 11:30 at UTC-2 after it
+	createTestFile(t, s, "account/container/east.yaml", time.Date(2026, 10, 16, 12, 30, 0, 0, time.FixedZone("east", 2*60*60)),
+		ChangeTypeCreated, ChangeTypeModified)
+	createTestFile(t, s, "account/container/west.yaml", time.Date(2026, 10, 16, 11, 30, 0, 0, time.FixedZone("west", -2*60*60)),
+		ChangeTypeCreated)
+
+	files, err := s.ListChurnedFiles(since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].BlobPath != "account/container/west.yaml" {
+		t.Fatalf("churned files = %v, want only west.yaml", files)
+	}
+	if files[0].Changes != 1 {
+		t.Errorf("changes = %d, want 1", files[0].Changes)
+	}
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	tests := []struct {
+		name       string
+		appendOnly bool
+	}{
+		{name: "regular database"},
+		{name: "append-only database", appendOnly: true},
+	}
+
+	// Capture times as written in local time, by CURRENT_TIMESTAMP and in UTC
+	capturedAt := []struct {
+		stored string
+		want   string
+	}{
+		{"2026-10-16 14:00:00.5+02:00", "2026-10-16 12:00:00.5+00:00"},
+		{"2026-10-16 12:00:01", "2026-10-16 12:00:01+00:00"},
+		{"2026-10-16 12:00:02.123456789+00:00", "2026-10-16 12:00:02.123456789+00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			s, err := NewSQLiteStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := createTestFile(t, s, "account/container/app.yaml", time.Now())
+			if tt.appendOnly {
+				if err := s.EnableAppendOnly(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, c := range capturedAt {
+				if _, err := s.db.Exec(`
+					INSERT INTO versions (file_id, content, content_hash, change_type, captured_at) VALUES (?, '', ?, ?, ?)
+				`, file.ID, fmt.Sprintf("hash-%d", i), ChangeTypeModified, c.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Close()
+
+			s, err = NewSQLiteStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if s.AppendOnly() != tt.appendOnly {
+				t.Errorf("append-only = %v, want %v", s.AppendOnly(), tt.appendOnly)
+			}
+
+			// Concatenated to read the stored text, which the driver would parse
+			rows, err := s.db.Query(`SELECT captured_at || '' FROM versions ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			for i := 0; rows.Next(); i++ {
+				var got string
+				if err := rows.Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != capturedAt[i].want {
+					t.Errorf("captured at = %s, want %s", got, capturedAt[i].want)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVersionCapturedAt(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		capturedAt time.Time
+		want       time.Time
+	}{
+		{name: "stamped with the store's clock", want: now},
+		{name: "capture time kept", capturedAt: earlier, want: earlier},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			s.SetClock(clock.NewFake(now))
+			file := createTestFile(t, s, "account/container/app.yaml", now)
+
+			v := &Version{FileID: file.ID, Content: "key: value\n", ContentHash: "hash", ChangeType: ChangeTypeCreated, CapturedAt: tt.capturedAt}
+			if err := s.CreateVersion(v); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := s.GetVersion(v.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.CapturedAt.Equal(tt.want) {
+				t.Errorf("captured at = %s, want %s", stored.CapturedAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateVersionDuplicates(t *testing.T) {
+	tests := []struct {
+		name          string
+		version       Version
+		wantDuplicate bool
+	}{
+		{
+			name:          "same capture",
+			version:       Version{ContentHash: "hash-0", BlobETag: "etag-0", ChangeType: ChangeTypeModified},
+			wantDuplicate: true,
+		},
+		{
+			name:    "new content",
+			version: Version{ContentHash: "hash-1", BlobETag: "etag-1", ChangeType: ChangeTypeModified},
+		},
+		{
+			name:    "recreated with the same content and ETag",
+			version: Version{ContentHash: "hash-0", BlobETag: "etag-0", ChangeType: ChangeTypeRecreated},
+		},
+		{
+			name:    "checkpoint",
+			version: Version{ContentHash: "hash-0", BlobETag: "etag-0", ChangeType: ChangeTypeCheckpoint},
+		},
+		{
+			name:    "no ETag",
+			version: Version{ContentHash: "hash-0", ChangeType: ChangeTypeModified},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+			file := createTestFile(t, s, "account/container/app.yaml", start, ChangeTypeCreated)
+			if err := s.CreateVersion(&Version{FileID: file.ID, ContentHash: "account/container/app.yaml-hash-0", BlobETag: "etag-0", ChangeType: ChangeTypeModified}); !errors.Is(err, ErrDuplicateVersion) {
+				t.Fatalf("recording the first capture again = %v, want ErrDuplicateVersion", err)
+			}
+
+			v := tt.version
+			v.FileID = file.ID
+			v.ContentHash = "account/container/app.yaml-" + v.ContentHash
+			if v.ChangeType == ChangeTypeRecreated {
+				deletion := &Version{FileID: file.ID, ChangeType: ChangeTypeDeleted}
+				if err := s.CreateVersion(deletion); err != nil {
+					t.Fatal(err)
+				}
+				v.DeletedVersionID = deletion.ID
+			}
+			err := s.CreateVersion(&v)
+			if tt.wantDuplicate {
+				if !errors.Is(err, ErrDuplicateVersion) {
+					t.Errorf("CreateVersion() = %v, want ErrDuplicateVersion", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.ID == 0 {
+				t.Error("version not recorded")
+			}
+		})
+	}
+}
+
+func TestCaptureIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasIndex := func() bool {
+		t.Helper()
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_versions_capture'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n == 1
+	}
+	if !hasIndex() {
+		t.Fatal("capture index not created")
+	}
+
+	// A capture recorded twice before captures were unique keeps its
+	// versions, and the database opens without the index
+	file := createTestFile(t, s, "account/container/app.yaml", time.Now(), ChangeTypeCreated)
+	if _, err := s.db.Exec(`DROP INDEX idx_versions_capture`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`
+		INSERT INTO versions (file_id, content, content_hash, change_type, captured_at, blob_etag) VALUES (?, 'v0', ?, ?, ?, 'etag-0')
+	`, file.ID, "account/container/app.yaml-hash-0", ChangeTypeModified, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if hasIndex() {
+		t.Error("capture index created over duplicate captures")
+	}
+	versions, err := s.GetVersionsByFileID(file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Errorf("got %d versions, want both captures kept", len(versions))
+	}
+}
+
+func TestDeleteSubscription(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdBy string
+		deleteBy  string
+		wantFound bool
+	}{
+		{name: "by its creator", createdBy: "alice", deleteBy: "alice", wantFound: true},
+		{name: "by another user", createdBy: "alice", deleteBy: "bob", wantFound: false},
+		{name: "by an admin", createdBy: "alice", deleteBy: "", wantFound: true},
+		{name: "without a creator by a user", createdBy: "", deleteBy: "bob", wantFound: false},
+		{name: "without a creator by an admin", createdBy: "", deleteBy: "", wantFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			sub := &Subscription{Email: "team@example.com", Mode: DeliveryDaily, CreatedBy: tt.createdBy}
+			if err := s.CreateSubscription(sub); err != nil {
+				t.Fatal(err)
+			}
+
+			found, err := s.DeleteSubscription(sub.ID, tt.deleteBy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.wantFound {
+				t.Errorf("found = %v, want %v", found, tt.wantFound)
+			}
+			subs, err := s.ListSubscriptions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if remaining := len(subs) == 1; remaining == tt.wantFound {
+				t.Errorf("subscription remaining = %v after delete found = %v", remaining, found)
+			}
+			if !tt.wantFound && subs[0].CreatedBy != tt.createdBy {
+				t.Errorf("created by = %q, want %q", subs[0].CreatedBy, tt.createdBy)
+			}
+		})
+	}
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	s := newTestStore(t)
+	longAgo := time.Now().Add(-time.Hour)
+
+	// Each step claims a key in turn, so later steps see earlier claims
+	steps := []struct {
+		name        string
+		scope, key  string
+		fingerprint string
+		save        int // status to save for the claim, if any
+		release     bool
+		expire      bool
+		wantClaimed bool
+		wantDone    bool
+		wantFP      string
+	}{
+		{name: "first claim", scope: "alice", key: "k1", fingerprint: "fp1", wantClaimed: true},
+		{name: "claim in progress", scope: "alice", key: "k1", fingerprint: "fp1", wantFP: "fp1"},
+		{name: "other scope", scope: "bob", key: "k1", fingerprint: "fp2", wantClaimed: true, save: 201},
+		{name: "claim after saving", scope: "bob", key: "k1", fingerprint: "fp2", wantDone: true, wantFP: "fp2"},
+		{name: "different request", scope: "bob", key: "k1", fingerprint: "fp3", wantDone: true, wantFP: "fp2"},
+		{name: "claim to release", scope: "carol", key: "k1", fingerprint: "fp1", wantClaimed: true, release: true},
+		{name: "claim after release", scope: "carol", key: "k1", fingerprint: "fp1", wantClaimed: true},
+		{name: "claim after expiry", scope: "bob", key: "k1", fingerprint: "fp3", expire: true, wantClaimed: true},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			expiredBefore := longAgo
+			if step.expire {
+				expiredBefore = time.Now().Add(time.Hour)
+			}
+			existing, err := s.ClaimIdempotencyKey(step.scope, step.key, step.fingerprint, expiredBefore)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed := existing == nil; claimed != step.wantClaimed {
+				t.Fatalf("claimed = %v, want %v", claimed, step.wantClaimed)
+			}
+			if existing != nil {
+				if existing.Done != step.wantDone {
+					t.Errorf("done = %v, want %v", existing.Done, step.wantDone)
+				}
+				if existing.Fingerprint != step.wantFP {
+					t.Errorf("fingerprint = %q, want %q", existing.Fingerprint, step.wantFP)
+				}
+			}
+
+			if step.save != 0 {
+				if err := s.SaveIdempotentResponse(step.scope, step.key, &IdempotentResponse{Status: step.save, Body: []byte("{}")}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if step.release {
+				if err := s.ReleaseIdempotencyKey(step.scope, step.key); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,23 @@
 package store
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
 	"time"
 )
 
+// ErrAppendOnly is returned for deletes and changes to recorded history when
+// the store is append-only
+var ErrAppendOnly = errors.New("the vault is append-only: recorded history cannot be deleted or changed")
+
+// ErrDuplicateVersion is returned by CreateVersion for a capture that is
+// already recorded: a version of the same file with the same content hash
+// and blob ETag since the file was last recreated. Deletions and
+// checkpoints are never duplicates.
+var ErrDuplicateVersion = errors.New("the version is already recorded")
+
 // ChangeType represents the type of change detected
 type ChangeType string
 
@@ -11,16 +25,98 @@ const (
 	ChangeTypeCreated  ChangeType = "created"
 	ChangeTypeModified ChangeType = "modified"
 	ChangeTypeDeleted  ChangeType = "deleted"
+	// ChangeTypeRecreated is a file reappearing at the path of a deleted file
+	ChangeTypeRecreated ChangeType = "recreated"
+	// ChangeTypeCheckpoint is a version captured on request although the
+	// content hadn't changed
+	ChangeTypeCheckpoint ChangeType = "checkpoint"
+	// ChangeTypeRestored is an earlier version written back over the file
+	ChangeTypeRestored ChangeType = "restored"
+	// ChangeTypeRenamed is content recorded at a path it was moved to
+	ChangeTypeRenamed ChangeType = "renamed"
+	// ChangeTypeImported is content imported from another system's history
+	ChangeTypeImported ChangeType = "imported"
+)
+
+var (
+	// changeTypes are the known change types, built-in ones first, then
+	// those added by RegisterChangeType, guarded by changeTypesMu
+	changeTypes = []ChangeType{
+		ChangeTypeCreated, ChangeTypeModified, ChangeTypeDeleted, ChangeTypeRecreated,
+		ChangeTypeCheckpoint, ChangeTypeRestored, ChangeTypeRenamed, ChangeTypeImported,
+	}
+	changeTypesMu sync.RWMutex
 )
 
+// RegisterChangeType adds a custom change type, such as one recorded by a
+// program embedding the vault, so that it is accepted as a search filter
+func RegisterChangeType(t ChangeType) {
+	changeTypesMu.Lock()
+	defer changeTypesMu.Unlock()
+	for _, known := range changeTypes {
+		if known == t {
+			return
+		}
+	}
+	changeTypes = append(changeTypes, t)
+}
+
+// ChangeTypes returns the known change types
+func ChangeTypes() []ChangeType {
+	changeTypesMu.RLock()
+	defer changeTypesMu.RUnlock()
+	return append([]ChangeType(nil), changeTypes...)
+}
+
+// Known reports whether t is a built-in or registered change type
+func (t ChangeType) Known() bool {
+	for _, known := range ChangeTypes() {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
 // File represents a tracked file in the database
 type File struct {
-	ID           int64     `json:"id"`
-	BlobPath     string    `json:"blob_path"`
-	ETag         string    `json:"etag"`
-	ContentHash  string    `json:"content_hash"`
-	LastModified time.Time `json:"last_modified"`
-	IsDeleted    bool      `json:"is_deleted"`
+	ID       int64  `json:"id"`
+	BlobPath string `json:"blob_path"`
+	// StorageAccount, Container and Path are the parts of BlobPath. They are
+	// set by the store from BlobPath.
+	StorageAccount string    `json:"storage_account"`
+	Container      string    `json:"container"`
+	Path           string    `json:"path"`
+	ETag           string    `json:"etag"`
+	ContentHash    string    `json:"content_hash"`
+	LastModified   time.Time `json:"last_modified"`
+	IsDeleted      bool      `json:"is_deleted"`
+	// IsArchived is set for files that are no longer synced. Their history
+	// is kept, but their blobs are skipped by sync cycles.
+	IsArchived bool `json:"is_archived"`
+	// ArchivedBy and ArchivedAt are who archived the file and when, unset
+	// while it isn't archived
+	ArchivedBy string     `json:"archived_by,omitempty"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// Language is the language of the file's content, such as yaml or
+	// dotenv, detected when it is synced; empty for plain text or until the
+	// content is fetched
+	Language string `json:"language,omitempty"`
+	// Labels are the file's key/value labels, both set through the API and
+	// derived from the configured label rules. Only filled in by the API.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Owners are the teams or people responsible for the file, from the
+	// owner rules. Only filled in by the API.
+	Owners []string `json:"owners,omitempty"`
+	// ReadOnly is why the file can't be written, such as an immutability
+	// policy on its container. Only filled in by the API.
+	ReadOnly string `json:"read_only,omitempty"`
+}
+
+// ContentPending reports whether the file is tracked by metadata only and its
+// content has not been fetched yet
+func (f *File) ContentPending() bool {
+	return !f.IsDeleted && f.ContentHash == ""
 }
 
 // Version represents a historical version of a file
@@ -33,32 +129,632 @@ type Version struct {
 	CapturedAt       time.Time  `json:"captured_at"`
 	BlobETag         string     `json:"blob_etag"`
 	BlobLastModified time.Time  `json:"blob_last_modified"`
+	// ContentPending is set for versions captured by hash only; their content
+	// is downloaded from the blob on first use
+	ContentPending bool `json:"content_pending,omitempty"`
+	// Size is the size of the full content in bytes
+	Size int64 `json:"size"`
+	// Truncated is set when Content holds only an excerpt of a file over the
+	// size limit; ContentHash is still the hash of the full content, which is
+	// kept by the snapshot named by SnapshotID unless none could be taken
+	Truncated bool `json:"truncated,omitempty"`
+	// SnapshotID identifies the Azure blob snapshot holding this version's
+	// full content, if one was taken
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// Encrypted is set when the content is a SOPS- or age-encrypted file
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Author is the identity of the user who made the change through the
+	// vault; empty for changes detected by the syncer
+	Author string `json:"author,omitempty"`
+	// Comment explains why the change was made
+	Comment string `json:"comment,omitempty"`
+	// DeletedVersionID links a recreated version to the deletion it follows
+	DeletedVersionID int64 `json:"deleted_version_id,omitempty"`
+	// RestoredFromVersionID is the earlier version a restore brought back
+	RestoredFromVersionID int64 `json:"restored_from_version_id,omitempty"`
+	// Signature is an HMAC over the version's immutable fields, made with the
+	// key identified by SignatureKeyID; empty if signing was off
+	Signature      string `json:"signature,omitempty"`
+	SignatureKeyID string `json:"signature_key_id,omitempty"`
+	// Tags name the version, such as the release it went out with. They are
+	// only filled in from GetVersionTags.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// AccountRename is the outcome of moving a storage account's files to its
+// new name
+type AccountRename struct {
+	// Files is how many files were moved
+	Files int
+	// Conflicts are the files left under the old name because a file was
+	// already recorded at their new path
+	Conflicts []string
+}
+
+// VersionSigner signs versions as they are created
+type VersionSigner interface {
+	Sign(v *Version)
 }
 
 // FileWithVersionCount extends File with version count for listing
 type FileWithVersionCount struct {
 	File
-	VersionCount   int       `json:"version_count"`
-	LatestChange   time.Time `json:"latest_change"`
+	VersionCount     int        `json:"version_count"`
+	LatestChange     time.Time  `json:"latest_change"`
 	LatestChangeType ChangeType `json:"latest_change_type"`
 }
 
-// Store defines the interface for the version store
+// FileStatus selects files by whether they are deleted or archived
+type FileStatus string
+
+const (
+	// FileStatusActive files are neither deleted nor archived
+	FileStatusActive FileStatus = "active"
+	// FileStatusDeleted files had their blob deleted
+	FileStatusDeleted FileStatus = "deleted"
+	// FileStatusArchived files are archived and not deleted
+	FileStatusArchived FileStatus = "archived"
+	// FileStatusAll matches every file
+	FileStatusAll FileStatus = "all"
+)
+
+// FileSort orders a file listing
+type FileSort string
+
+const (
+	// FileSortPath orders files by blob path
+	FileSortPath FileSort = "path"
+	// FileSortLatestChange orders files by their latest change, newest first
+	FileSortLatestChange FileSort = "latest_change"
+	// FileSortVersionCount orders files by their number of versions, most
+	// first
+	FileSortVersionCount FileSort = "version_count"
+)
+
+// LabelSelector selects the files with the label Key set to Value, or to any
+// value with AnyValue
+type LabelSelector struct {
+	Key      string
+	Value    string
+	AnyValue bool
+	// Derived are the paths whose files have the label without it being set
+	// on them, as the label rules give it. A label set on a file takes
+	// precedence over the derived one.
+	Derived []LabelPaths
+}
+
+// LabelPaths are the blob paths under Prefix and under none of Except
+type LabelPaths struct {
+	Prefix string
+	Except []string
+}
+
+// FileUpdate is a change to the record of a file made by a bulk operation
+type FileUpdate struct {
+	FileID int64
+	// Archived archives or unarchives the file, unless nil
+	Archived *bool
+	// ArchivedBy and ArchivedAt are recorded as who archived the file and
+	// when, if Archived archives it
+	ArchivedBy string
+	ArchivedAt time.Time
+	// Labels are set on the file in addition to those already set
+	Labels map[string]string
+	// Tag is added to the file's latest version, unless empty, recorded as
+	// set by TaggedBy at TaggedAt. Files without versions are left alone.
+	Tag      string
+	TaggedBy string
+	TaggedAt time.Time
+}
+
+// CaptureWrite is a file record to upsert and, unless nil, a version of the
+// file to create, as written by WriteCaptures
+type CaptureWrite struct {
+	File    *File
+	Version *Version
+	// PreviousETag, unless nil, is the file's stored ETag the write was
+	// worked out from, "" for a file not recorded yet. If the stored ETag
+	// has changed since, newer content was recorded in the meantime and the
+	// write is skipped, leaving the version's ID 0.
+	PreviousETag *string
+}
+
+// ChurnedFile is a file with the number of changes recorded for it in a
+// period
+type ChurnedFile struct {
+	File
+	Changes      int       `json:"changes"`
+	LatestChange time.Time `json:"latest_change"`
+}
+
+// DeletedFile is a deleted file with the time it was deleted and the version
+// a restore from deletion brings back
+type DeletedFile struct {
+	File
+	DeletedAt time.Time `json:"deleted_at"`
+	// LastVersionID is the latest version with content, or 0 if there is none
+	LastVersionID int64 `json:"last_version_id"`
+}
+
+// FileStorage is the content stored for the versions of a file
+type FileStorage struct {
+	FileID   int64  `json:"file_id"`
+	BlobPath string `json:"blob_path"`
+	Versions int    `json:"versions"`
+	// Bytes is the size of the stored content; versions stored by hash
+	// only count for nothing and truncated ones for their excerpt
+	Bytes int64 `json:"bytes"`
+}
+
+// StoredVersion is a version with the size of its stored content
+type StoredVersion struct {
+	ID     int64
+	FileID int64
+	Bytes  int64
+}
+
+// ChangeEvent is a single recorded version together with the file it belongs to
+type ChangeEvent struct {
+	VersionID   int64      `json:"version_id"`
+	FileID      int64      `json:"file_id"`
+	BlobPath    string     `json:"blob_path"`
+	ChangeType  ChangeType `json:"change_type"`
+	ContentHash string     `json:"content_hash"`
+	CapturedAt  time.Time  `json:"captured_at"`
+	// Author, Comment and RestoredFromVersionID are only filled in by
+	// SearchChanges
+	Author                string `json:"author,omitempty"`
+	Comment               string `json:"comment,omitempty"`
+	RestoredFromVersionID int64  `json:"restored_from_version_id,omitempty"`
+}
+
+// Impact is a metric measured before and after a version was recorded
+type Impact struct {
+	VersionID int64  `json:"version_id"`
+	Metric    string `json:"metric"`
+	Unit      string `json:"unit,omitempty"`
+	// Before and After are the metric's values at the change and one window
+	// later; nil if the query returned no value
+	Before     *float64  `json:"before"`
+	After      *float64  `json:"after"`
+	Window     string    `json:"window"`
+	MeasuredAt time.Time `json:"measured_at"`
+	// Error is why the metric couldn't be measured, if it couldn't
+	Error string `json:"error,omitempty"`
+}
+
+// SearchQuery filters the change history. Zero-valued fields are ignored.
+type SearchQuery struct {
+	// Text matches against the blob path and the captured content
+	Text           string
+	PathPrefix     string
+	StorageAccount string
+	Container      string
+	ChangeType     ChangeType
+	Since          time.Time
+	Until          time.Time
+	Limit          int
+	// PathPrefixes matches files under any of these prefixes
+	PathPrefixes []string
+	// Labels matches only files that match every label selector
+	Labels []LabelSelector
+	// Restored matches only versions that restored an earlier version
+	Restored bool
+	// Tag matches only versions with this tag
+	Tag string
+}
+
+// DeliveryMode controls when a subscription's notifications are sent
+type DeliveryMode string
+
+const (
+	DeliveryImmediate DeliveryMode = "immediate"
+	DeliveryHourly    DeliveryMode = "hourly"
+	DeliveryDaily     DeliveryMode = "daily"
+)
+
+// Subscription is an e-mail subscription to changes under a path prefix
+type Subscription struct {
+	ID         int64        `json:"id"`
+	Email      string       `json:"email"`
+	PathPrefix string       `json:"path_prefix"`
+	Mode       DeliveryMode `json:"mode"`
+	// CreatedBy is the user who subscribed the address, the only one who
+	// can unsubscribe it apart from an admin
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastSentAt is when the last digest covered changes up to (digest modes only)
+	LastSentAt time.Time `json:"last_sent_at"`
+}
+
+// Watch is a user's interest in a single file or every file under a prefix
+type Watch struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"user_id"`
+	Path   string `json:"path"`
+	// Exact watches only Path itself; otherwise Path is a prefix
+	Exact     bool      `json:"exact"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Matches reports whether the watch covers blobPath
+func (w Watch) Matches(blobPath string) bool {
+	if w.Exact {
+		return blobPath == w.Path
+	}
+	return strings.HasPrefix(blobPath, w.Path)
+}
+
+// InboxItem is a change delivered to a user because of one of their watches
+type InboxItem struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"user_id"`
+	ChangeEvent
+	ReadAt *time.Time `json:"read_at,omitempty"`
+}
+
+// ShadowChange is a change a dry-run sync would have recorded
+type ShadowChange struct {
+	BlobPath   string     `json:"blob_path"`
+	ChangeType ChangeType `json:"change_type"`
+	ETag       string     `json:"etag"`
+	// ContentHash is only known for modifications, which require a download
+	ContentHash string    `json:"content_hash,omitempty"`
+	Size        int64     `json:"size"`
+	DetectedAt  time.Time `json:"detected_at"`
+}
+
+// SyncError is an error processing a blob, kept until the blob syncs
+// successfully or is no longer listed
+type SyncError struct {
+	BlobPath string `json:"blob_path"`
+	// Error is the message of the latest occurrence
+	Error string `json:"error"`
+	// Count is the number of sync cycles the blob failed in
+	Count       int       `json:"count"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// SyncPause stops a storage account, or one of its containers, from being
+// synced until it is resumed
+type SyncPause struct {
+	ID             int64  `json:"id"`
+	StorageAccount string `json:"storage_account"`
+	// Container is the paused container, or empty if the whole account is
+	Container string    `json:"container,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	PausedBy  string    `json:"paused_by,omitempty"`
+	PausedAt  time.Time `json:"paused_at"`
+}
+
+// JobStatus is the state of a job. Jobs start queued, run, and end up
+// succeeded or failed.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a long operation run in the background for an API request
+type Job struct {
+	ID int64 `json:"id"`
+	// Kind names the operation, such as bulk or evidence
+	Kind   string    `json:"kind"`
+	Status JobStatus `json:"status"`
+	// Admin is set for jobs of admin operations, which only admins can see
+	Admin     bool   `json:"admin,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	// Done and Total report the progress of a running job, for the jobs
+	// that know how much work they have
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Result is what a succeeded job returns, as JSON
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// OutputName is the file name of the file a job produced, such as an
+	// export; the file itself is read with GetJobOutput
+	OutputName string `json:"output_name,omitempty"`
+	// CallbackURL is sent the job when it finishes, and CallbackError is
+	// set if that failed
+	CallbackURL   string     `json:"callback_url,omitempty"`
+	CallbackError string     `json:"callback_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// ViewToken grants read-only access to a single file's history, or to a
+// single diff of it, for sharing a link with people who can't use the vault
+type ViewToken struct {
+	ID int64 `json:"id"`
+	// TokenHash is the SHA-256 of the token, in hex. The token itself is only
+	// known to its creator.
+	TokenHash string `json:"-"`
+	BlobPath  string `json:"blob_path"`
+	// FromVersionID and ToVersionID limit the token to the diff between the
+	// two versions; both are 0 for a token to the whole history
+	FromVersionID int64      `json:"from_version_id,omitempty"`
+	ToVersionID   int64      `json:"to_version_id,omitempty"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Valid reports whether the token grants access at now
+func (t *ViewToken) Valid(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// IdempotentResponse is the response recorded for an idempotency key, which
+// is sent again when a request is retried with the same key
+type IdempotentResponse struct {
+	// Fingerprint identifies the request the key was first used for
+	Fingerprint string
+	// Done is false while the first request is still being handled
+	Done        bool
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ProposalKind is the kind of write a proposal makes
+type ProposalKind string
+
+const (
+	ProposalEdit    ProposalKind = "edit"
+	ProposalRestore ProposalKind = "restore"
+)
+
+// ProposalStatus is the state of a proposal. Proposals start pending and
+// end up applied, failed, rejected or withdrawn; approved is the short-lived
+// state while the approved change is being written.
+type ProposalStatus string
+
+const (
+	ProposalPending   ProposalStatus = "pending"
+	ProposalApproved  ProposalStatus = "approved"
+	ProposalApplied   ProposalStatus = "applied"
+	ProposalFailed    ProposalStatus = "failed"
+	ProposalRejected  ProposalStatus = "rejected"
+	ProposalWithdrawn ProposalStatus = "withdrawn"
+)
+
+// TrackingRule tracks the blobs under a container and prefix in addition to
+// the containers configured in YAML. Rules are managed through the API and
+// picked up by the next sync cycle.
+type TrackingRule struct {
+	ID             int64  `json:"id"`
+	StorageAccount string `json:"storage_account"`
+	Container      string `json:"container"`
+	Prefix         string `json:"prefix"`
+	// Patterns select blob names within the prefix; empty uses sync.patterns
+	Patterns  []string  `json:"patterns"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Proposal is an edit or restore waiting for a second user's approval before
+// it is written to blob storage
+type Proposal struct {
+	ID       int64        `json:"id"`
+	BlobPath string       `json:"blob_path"`
+	Kind     ProposalKind `json:"kind"`
+	Content  string       `json:"content"`
+	// BaseVersionID and BaseETag are the file's state when the change was
+	// proposed; the write fails if the file has changed since
+	BaseVersionID int64  `json:"base_version_id,omitempty"`
+	BaseETag      string `json:"base_etag,omitempty"`
+	// RestoreVersionID is the version a restore proposal brings back
+	RestoreVersionID int64          `json:"restore_version_id,omitempty"`
+	Author           string         `json:"author"`
+	Comment          string         `json:"comment,omitempty"`
+	Status           ProposalStatus `json:"status"`
+	Reviewer         string         `json:"reviewer,omitempty"`
+	ReviewComment    string         `json:"review_comment,omitempty"`
+	// Error explains why an approved proposal could not be applied
+	Error string `json:"error,omitempty"`
+	// VersionID is the version recorded when the proposal was applied
+	VersionID int64 `json:"version_id,omitempty"`
+	// PullRequestNumber and PullRequestURL identify the pull request that
+	// reviews the proposal, for paths reviewed on GitHub
+	PullRequestNumber int       `json:"pull_request_number,omitempty"`
+	PullRequestURL    string    `json:"pull_request_url,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ProposalQuery filters proposals. Zero-valued fields are ignored.
+type ProposalQuery struct {
+	Status   ProposalStatus
+	BlobPath string
+	Author   string
+	Limit    int
+}
+
+// Store defines the interface for the version store. An append-only store
+// returns ErrAppendOnly from the deletes of files, versions and proposals and
+// from SetVersionComment; settings such as watches and subscriptions can
+// still be deleted.
 type Store interface {
 	// File operations
 	GetFile(blobPath string) (*File, error)
 	GetFileByID(id int64) (*File, error)
 	ListFiles() ([]FileWithVersionCount, error)
+	// ListFilesWithStatus lists only the files with a status that match
+	// every label selector, in an order
+	ListFilesWithStatus(status FileStatus, sort FileSort, labels []LabelSelector) ([]FileWithVersionCount, error)
+	// ListChurnedFiles lists the files changed most often since a time, up
+	// to limit
+	ListChurnedFiles(since time.Time, limit int) ([]ChurnedFile, error)
+	ListDeletedFiles() ([]DeletedFile, error)
 	UpsertFile(file *File) error
 	MarkFileDeleted(blobPath string) error
+	// SetFileArchived archives or unarchives a file, recording who archived
+	// it and when
+	SetFileArchived(blobPath string, archived bool, by string, at time.Time) error
+	// SetFileLanguage sets the detected language of a file
+	SetFileLanguage(fileID int64, language string) error
+	// UpdateFiles applies several file updates in one transaction
+	UpdateFiles(updates []FileUpdate) error
+	// RenameStorageAccount moves the files of a storage account, and the open
+	// proposals, rules, watches and subscriptions naming it, to a new name
+	RenameStorageAccount(from, to string) (*AccountRename, error)
 
 	// Version operations
+	// CreateVersion creates a version and sets its ID, or returns
+	// ErrDuplicateVersion if the capture is already recorded
 	CreateVersion(version *Version) error
+	// WriteCaptures upserts several files and creates their versions in one
+	// transaction. Captures already recorded are skipped and get an ID of 0.
+	WriteCaptures(writes []CaptureWrite) error
+	SetVersionContent(id int64, content string, truncated bool) error
+	SetVersionComment(id int64, comment string) error
 	GetVersion(id int64) (*Version, error)
 	GetVersionsByFileID(fileID int64) ([]Version, error)
 	GetVersionsByFilePath(blobPath string) ([]Version, error)
 	GetLatestVersion(fileID int64) (*Version, error)
+	GetLastContentVersion(fileID int64) (*Version, error)
+	GetPreviousVersion(id int64) (*Version, error)
+	// GetAdjacentVersionIDs returns the IDs of the versions of the same file
+	// captured just before and after a version, or 0 where there is none
+	GetAdjacentVersionIDs(id int64) (previous, next int64, err error)
+	// GetVersionAt returns the version of a file that was current at a time,
+	// and GetVersionsAt the one of every file under a prefix that had one
+	GetVersionAt(fileID int64, at time.Time) (*Version, error)
+	GetVersionsAt(pathPrefix string, at time.Time) ([]Version, error)
+	// PruneVersions deletes the versions of a file captured before a time,
+	// except its latest version, and returns how many it deleted
+	PruneVersions(fileID int64, before time.Time) (int64, error)
+	// DeleteVersion deletes a version, the inbox items delivering it and its
+	// measured impacts
+	DeleteVersion(id int64) error
+	// VersionReferenced reports whether a version was delivered to an inbox,
+	// had its impact measured, or is named by a share link or a proposal
+	VersionReferenced(id int64) (bool, error)
+	// StorageByFile returns the content stored for the versions of each file
+	StorageByFile() ([]FileStorage, error)
+	// DropVersionContent removes the content stored for a version but keeps
+	// the version, which then has its content fetched like one captured by
+	// hash only
+	DropVersionContent(id int64) error
+	// OldestVersions returns up to limit versions of a file, or of every
+	// file if fileID is 0, oldest first. The latest version of each file and
+	// versions without stored content are left out.
+	OldestVersions(fileID int64, limit int) ([]StoredVersion, error)
+
+	// Impact operations. Saving a version's impact on a metric replaces the
+	// one saved before.
+	SaveImpact(impact *Impact) error
+	GetImpacts(versionID int64) ([]Impact, error)
+
+	// Search operations
+	SearchChanges(query SearchQuery) ([]ChangeEvent, error)
+
+	// Subscription operations
+	CreateSubscription(sub *Subscription) error
+	ListSubscriptions() ([]Subscription, error)
+	// DeleteSubscription deletes a subscription if it was created by
+	// createdBy, or by anyone if createdBy is empty, and reports whether it
+	// found one
+	DeleteSubscription(id int64, createdBy string) (bool, error)
+	MarkSubscriptionSent(id int64, sentAt time.Time) error
+
+	// Proposal operations. UpdateProposal only succeeds while the proposal
+	// still has status from, and reports whether it did. It also saves the
+	// content, which may have been amended during review.
+	CreateProposal(p *Proposal) error
+	GetProposal(id int64) (*Proposal, error)
+	ListProposals(query ProposalQuery) ([]Proposal, error)
+	UpdateProposal(p *Proposal, from ProposalStatus) (bool, error)
+	SetProposalPullRequest(id int64, number int, url string) error
+
+	// Tracking rule operations
+	CreateTrackingRule(rule *TrackingRule) error
+	GetTrackingRule(id int64) (*TrackingRule, error)
+	ListTrackingRules() ([]TrackingRule, error)
+	UpdateTrackingRule(rule *TrackingRule) error
+	DeleteTrackingRule(id int64) error
+
+	// GetVersionTags returns the tags of a file's versions, by version ID
+	GetVersionTags(fileID int64) (map[int64][]string, error)
+
+	// Label operations. Only labels set through the API are stored.
+	GetFileLabels(fileID int64) (map[string]string, error)
+	ListFileLabels() (map[int64]map[string]string, error)
+	SetFileLabels(fileID int64, labels map[string]string) error
+
+	// Watch and inbox operations. An empty userID lists watches of all users.
+	CreateWatch(watch *Watch) error
+	ListWatches(userID string) ([]Watch, error)
+	DeleteWatch(userID string, id int64) error
+	AddInboxItem(item *InboxItem) error
+	ListInbox(userID string, unreadOnly bool, limit int) ([]InboxItem, error)
+	MarkInboxRead(userID string, ids []int64) error
+
+	// Dry-run report operations. Each blob path keeps its latest shadow change.
+	RecordShadowChange(change *ShadowChange) error
+	GetShadowChange(blobPath string) (*ShadowChange, error)
+	ListShadowChanges() ([]ShadowChange, error)
+	ClearShadowChanges() error
+
+	// Sync error operations. Each blob path keeps its latest error and the
+	// number of times it occurred.
+	RecordSyncError(blobPath, message string) error
+	ListSyncErrors() ([]SyncError, error)
+	ClearSyncError(blobPath string) error
+
+	// Sync pause operations. Pausing a storage account or container that is
+	// already paused replaces its reason.
+	PauseSync(pause *SyncPause) error
+	ListSyncPauses() ([]SyncPause, error)
+	ResumeSync(id int64) (bool, error)
+
+	// Sync state operations. The syncer keeps what it must remember across
+	// restarts, such as that the backfill completed, by key. An unset key
+	// reads as "".
+	GetSyncState(key string) (string, error)
+	SetSyncState(key, value string) error
+
+	// Job operations. A job's output is stored when it finishes, and read
+	// separately from the job.
+	CreateJob(job *Job) error
+	UpdateJob(job *Job) error
+	SetJobOutput(id int64, output []byte, contentType, name string) error
+	GetJob(id int64) (*Job, error)
+	GetJobOutput(id int64) (output []byte, contentType, name string, err error)
+	ListJobs(limit int) ([]Job, error)
+	// FailUnfinishedJobs fails the jobs left queued or running, such as by a
+	// restart, with message
+	FailUnfinishedJobs(message string) (int64, error)
+	DeleteJobsFinishedBefore(t time.Time) (int64, error)
+
+	// View token operations. Tokens are looked up by the hash of the token,
+	// and revoked rather than deleted. ListViewTokens and RevokeViewToken
+	// cover every creator's tokens if createdBy is empty.
+	CreateViewToken(token *ViewToken) error
+	GetViewToken(tokenHash string) (*ViewToken, error)
+	ListViewTokens(createdBy string) ([]ViewToken, error)
+	RevokeViewToken(id int64, createdBy string) (bool, error)
+
+	// Idempotency key operations. ClaimIdempotencyKey claims key for a
+	// request and returns nil, or returns what is recorded for it if it is
+	// already claimed. Keys claimed before expiredBefore are forgotten.
+	ClaimIdempotencyKey(scope, key, fingerprint string, expiredBefore time.Time) (*IdempotentResponse, error)
+	SaveIdempotentResponse(scope, key string, response *IdempotentResponse) error
+	// ReleaseIdempotencyKey forgets a claimed key, so that the request can
+	// be retried
+	ReleaseIdempotencyKey(scope, key string) error
 
 	// Utility
+	// Ping checks that the database can be read
+	Ping() error
 	Close() error
 }
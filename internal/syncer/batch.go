@@ -0,0 +1,98 @@
+package syncer
+
+import (
+	"context"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// Batch records captures in bulk. Captures are checked and run through the
+// pre-store hooks as they are added, but their writes are queued and made a
+// batch at a time, each batch in one transaction.
+type Batch struct {
+	recorder *Recorder
+	size     int
+	pending  []batchItem
+}
+
+// batchItem is a queued capture and the callback to run once it is written
+type batchItem struct {
+	pendingCapture
+	done func(version *store.Version)
+}
+
+// NewBatch creates a batch that writes every size captures. A size of 1 or
+// less writes each capture as it is added.
+func (r *Recorder) NewBatch(size int) *Batch {
+	return &Batch{recorder: r, size: size}
+}
+
+// Add queues the writes recording a capture, as RecordCapture would make
+// them, and writes the batch if it is full. Once the capture is written,
+// done is called with its version, which is nil if the content was unchanged
+// or another entry point recorded the capture first.
+func (b *Batch) Add(ctx context.Context, existing *store.File, c Capture, done func(version *store.Version)) error {
+	p, err := b.recorder.prepare(ctx, existing, c)
+	if err != nil {
+		return err
+	}
+	return b.queue(ctx, batchItem{pendingCapture: *p, done: done})
+}
+
+// AddFile queues a write of a file record alone, to track a file by its
+// metadata. existing is the current record of the file, or nil for a new
+// file.
+func (b *Batch) AddFile(ctx context.Context, existing *store.File, file *store.File) error {
+	p := pendingCapture{file: file}
+	if existing != nil {
+		p.previousETag = existing.ETag
+	}
+	return b.queue(ctx, batchItem{pendingCapture: p})
+}
+
+// queue adds an item and writes the batch if it is full
+func (b *Batch) queue(ctx context.Context, item batchItem) error {
+	b.pending = append(b.pending, item)
+	if len(b.pending) < b.size {
+		return nil
+	}
+	return b.Flush(ctx)
+}
+
+// Flush writes the queued captures in one transaction. If it fails, none of
+// the batch is written and its files keep their previous ETags, so the next
+// sync picks the changes up again. A capture whose file's ETag changed since
+// it was added is skipped: another entry point recorded newer content while
+// the capture waited in the batch.
+func (b *Batch) Flush(ctx context.Context) error {
+	items := b.pending
+	b.pending = nil
+	if len(items) == 0 {
+		return nil
+	}
+
+	writes := make([]store.CaptureWrite, len(items))
+	for i, item := range items {
+		writes[i] = store.CaptureWrite{File: item.file, Version: item.version, PreviousETag: &items[i].previousETag}
+	}
+	if err := b.recorder.store.WriteCaptures(writes); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		version := item.version
+		if version != nil && version.ID == 0 {
+			// Recorded by another entry point first, or superseded
+			b.recorder.discard(ctx, &item.pendingCapture)
+			version = nil
+		}
+		if version != nil {
+			b.recorder.hooksFor(item.payload.BlobPath).PostStore(ctx, item.payload)
+			b.recorder.coalesce(item.payload.BlobPath, version)
+		}
+		if item.done != nil {
+			item.done(version)
+		}
+	}
+	return nil
+}
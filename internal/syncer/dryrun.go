@@ -0,0 +1,75 @@
+package syncer
+
+import (
+	"context"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+// DryRun reports whether the syncer only records what it would have stored
+func (s *Syncer) DryRun() bool {
+	return s.config.DryRun
+}
+
+// shadowBlob records the change processing blobInfo would have stored,
+// without touching the version history. New files are reported from the
+// listing alone; modifications are downloaded to compare content hashes.
+func (s *Syncer) shadowBlob(ctx context.Context, blobInfo blob.BlobInfo, existingFile *store.File) error {
+	if existingFile != nil && !existingFile.IsDeleted && existingFile.ETag == blobInfo.ETag {
+		return nil
+	}
+
+	// Skip blobs already reported at this ETag
+	shadow, err := s.store.GetShadowChange(blobInfo.FullPath)
+	if err != nil {
+		return err
+	}
+	if shadow != nil && shadow.ETag == blobInfo.ETag {
+		return nil
+	}
+
+	change := &store.ShadowChange{
+		BlobPath:   blobInfo.FullPath,
+		ChangeType: store.ChangeTypeCreated,
+		ETag:       blobInfo.ETag,
+		Size:       blobInfo.Size,
+	}
+
+	if existingFile != nil && existingFile.IsDeleted {
+		change.ChangeType = store.ChangeTypeRecreated
+	} else if existingFile != nil {
+		blobContent, err := s.blobClient.GetBlob(ctx, blobInfo.StorageAccount, blobInfo.Container, blobInfo.Path)
+		if err != nil {
+			return err
+		}
+		if !contentChanged(existingFile, blobContent) {
+			return nil
+		}
+		change.ChangeType = store.ChangeTypeModified
+		change.ContentHash = blobContent.ContentHash
+	}
+
+	log.Printf("[dry-run] Would record %s: %s", change.ChangeType, change.BlobPath)
+	return s.store.RecordShadowChange(change)
+}
+
+// shadowDeletion records that file would have been marked deleted
+func (s *Syncer) shadowDeletion(file *store.File) error {
+	shadow, err := s.store.GetShadowChange(file.BlobPath)
+	if err != nil {
+		return err
+	}
+	if shadow != nil && shadow.ChangeType == store.ChangeTypeDeleted {
+		return nil
+	}
+
+	log.Printf("[dry-run] Would record deleted: %s", file.BlobPath)
+	return s.store.RecordShadowChange(&store.ShadowChange{
+		BlobPath:    file.BlobPath,
+		ChangeType:  store.ChangeTypeDeleted,
+		ETag:        file.ETag,
+		ContentHash: file.ContentHash,
+	})
+}
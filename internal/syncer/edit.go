@@ -0,0 +1,81 @@
+package syncer
+
+import (
+	"context"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+// Edit is a change made through the vault rather than detected in blob storage
+type Edit struct {
+	BlobPath string
+	Content  []byte
+	Author   string
+	Comment  string
+	// IfMatch is the ETag the blob must still have for the write to succeed.
+	// Empty overwrites unconditionally.
+	IfMatch string
+	// LeaseID is the lease to write a leased blob under, if it has one
+	LeaseID string
+	// RestoredFrom is the version the edit restores, if it is a restore
+	RestoredFrom int64
+}
+
+// RecordEdit writes new content to the blob and immediately records it as a
+// version attributed to the editor. The pre-store hooks run before anything
+// is written, so a rejected edit leaves both the blob and the history untouched.
+// A conditional write that loses a race returns blob.ErrConditionNotMet, and
+// a write to a leased blob without its lease a *blob.LeasedError. Blobs of
+// read-only containers return an error wrapping blob.ErrImmutable.
+func (s *Syncer) RecordEdit(ctx context.Context, edit Edit) (*store.Version, error) {
+	if err := s.checkWritable(edit.BlobPath); err != nil {
+		return nil, err
+	}
+
+	content, err := s.recorder.PreStore(ctx, edit.BlobPath, edit.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sync cycle waits to see the written blob until it is recorded as
+	// the edit
+	defer s.inflight.lock(edit.BlobPath)()
+
+	existing, err := s.store.GetFile(edit.BlobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.blobClient.UploadBlobIfMatch(ctx, edit.BlobPath, content, edit.IfMatch, edit.LeaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	blobContent := &blob.BlobContent{
+		BlobInfo:    *info,
+		Content:     content,
+		ContentHash: blob.ComputeHash(content),
+	}
+
+	c := s.capture(ctx, blobContent, existing)
+	c.Author = edit.Author
+	c.Comment = edit.Comment
+	c.RestoredFrom = edit.RestoredFrom
+	c.Prevalidated = true
+
+	version, err := s.recorder.RecordCapture(ctx, existing, c)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil {
+		// Content was unchanged; only the blob's ETag moved
+		return nil, nil
+	}
+
+	s.publishChange(edit.BlobPath, version)
+
+	log.Printf("Recorded edit of %s by %s (version %d)", edit.BlobPath, edit.Author, version.ID)
+	return version, nil
+}
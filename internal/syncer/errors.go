@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// failingBlobs returns the paths of the blobs with a recorded sync error
+func (s *Syncer) failingBlobs() map[string]bool {
+	syncErrors, err := s.store.ListSyncErrors()
+	if err != nil {
+		log.Printf("Error listing sync errors: %v", err)
+		return nil
+	}
+	failing := make(map[string]bool, len(syncErrors))
+	for _, e := range syncErrors {
+		failing[e.BlobPath] = true
+	}
+	return failing
+}
+
+// recordSyncError records an error processing a blob
+func (s *Syncer) recordSyncError(blobPath string, syncErr error) {
+	if err := s.store.RecordSyncError(blobPath, syncErr.Error()); err != nil {
+		log.Printf("Error recording sync error of %s: %v", blobPath, err)
+	}
+}
+
+// clearSyncErrors clears the errors of the blobs that were failing before a
+// cycle and didn't fail in it: they were listed and synced successfully, or
+// are no longer listed. Errors of storage accounts and containers that
+// failed to list are kept.
+func (s *Syncer) clearSyncErrors(failing, listed, unlisted map[string]bool) {
+	for blobPath := range failing {
+		if !listed[blobPath] {
+			if storageAccount, container, _, err := blob.ParseFullPath(blobPath); err == nil &&
+				(unlisted[storageAccount] || unlisted[storageAccount+"/"+container]) {
+				continue
+			}
+		}
+		if err := s.store.ClearSyncError(blobPath); err != nil {
+			log.Printf("Error clearing sync error of %s: %v", blobPath, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package syncer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+// pruneInterval is how often versions past their pattern group's retention
+// are deleted and the content of versions over a storage quota is dropped
+const pruneInterval = time.Hour
+
+// metadataOnly reports whether a file is in a pattern group that tracks
+// files by their metadata only
+func (s *Syncer) metadataOnly(blobPath string) bool {
+	group := s.config.PatternGroups.Find(blobPath)
+	return group != nil && group.Capture == config.CaptureMetadata
+}
+
+// trackMetadataChange updates the record of a file tracked by its metadata
+// only, without downloading it. Deletions are still recorded as versions by
+// checkDeleted.
+func (s *Syncer) trackMetadataChange(ctx context.Context, batch *Batch, blobInfo blob.BlobInfo, existing *store.File) error {
+	if existing != nil && !existing.IsDeleted && existing.ETag == blobInfo.ETag {
+		return nil
+	}
+	if existing == nil {
+		log.Printf("New file detected: %s (tracking metadata only)", blobInfo.FullPath)
+	}
+	return s.trackMetadata(ctx, batch, blobInfo, existing)
+}
+
+// pruneVersions deletes the versions that are older than the retention of
+// their file's pattern group, keeping the latest version of every file
+func (s *Syncer) pruneVersions() error {
+	files, err := s.store.ListFiles()
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now()
+	var pruned int64
+	for _, file := range files {
+		group := s.config.PatternGroups.Find(file.BlobPath)
+		if group == nil || group.Retention <= 0 || file.VersionCount < 2 {
+			continue
+		}
+		n, err := s.store.PruneVersions(file.ID, now.Add(-group.Retention))
+		if err != nil {
+			return err
+		}
+		pruned += n
+	}
+
+	if pruned > 0 {
+		log.Printf("Deleted %d versions past their retention", pruned)
+	}
+	return nil
+}
+
+// coalesce deletes the version recorded before version if both were captured
+// in the same window of the file's pattern group coalesce setting, so that
+// the group keeps at most one version per window: the latest. A previous
+// version that was already delivered, by a notification, a share link or a
+// blob snapshot, is kept so that what points to it still works.
+func (r *Recorder) coalesce(blobPath string, version *store.Version) {
+	group := r.groups.Find(blobPath)
+	if group == nil || group.Coalesce <= 0 || !coalescable(version) {
+		return
+	}
+
+	previous, err := r.store.GetPreviousVersion(version.ID)
+	if err != nil {
+		log.Printf("Error getting previous version of %s: %v", blobPath, err)
+		return
+	}
+	if previous == nil || !coalescable(previous) ||
+		!previous.CapturedAt.Truncate(group.Coalesce).Equal(version.CapturedAt.Truncate(group.Coalesce)) {
+		return
+	}
+	if delivered, err := r.delivered(blobPath, previous); err != nil {
+		log.Printf("Error checking references to version %d of %s: %v", previous.ID, blobPath, err)
+		return
+	} else if delivered {
+		return
+	}
+
+	if err := r.store.DeleteVersion(previous.ID); err != nil {
+		log.Printf("Error coalescing version %d of %s: %v", previous.ID, blobPath, err)
+		return
+	}
+	log.Printf("Coalesced version %d of %s into version %d", previous.ID, blobPath, version.ID)
+}
+
+// delivered reports whether a version has gone anywhere that would be left
+// pointing at nothing if it were deleted: a notification sent out of the
+// vault, an inbox, a share link, a proposal or an impact measurement, or a
+// blob snapshot kept for it
+func (r *Recorder) delivered(blobPath string, v *store.Version) (bool, error) {
+	if v.SnapshotID != "" {
+		return true, nil
+	}
+	if r.notified != nil && r.notified(store.ChangeEvent{
+		VersionID:   v.ID,
+		FileID:      v.FileID,
+		BlobPath:    blobPath,
+		ChangeType:  v.ChangeType,
+		ContentHash: v.ContentHash,
+		CapturedAt:  v.CapturedAt,
+	}) {
+		return true, nil
+	}
+	return r.store.VersionReferenced(v.ID)
+}
+
+// coalescable reports whether a version can be replaced by a later one:
+// modifications captured by the sync, without an author or comment
+func coalescable(v *store.Version) bool {
+	return v.ChangeType == store.ChangeTypeModified && v.Author == "" && v.Comment == ""
+}
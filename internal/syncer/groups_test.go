@@ -0,0 +1,65 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/toggle-vault/internal/clock"
+	"github.com/toggle-vault/internal/config"
+)
+
+func TestPruneVersions(t *testing.T) {
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		elapsed      time.Duration
+		wantVersions int
+	}{
+		{name: "within the retention", elapsed: 12 * time.Hour, wantVersions: 2},
+		{name: "past the retention", elapsed: 36 * time.Hour, wantVersions: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(start)
+			st := newTestStore(t)
+			st.SetClock(fake)
+			s := New(nil, st, config.SyncConfig{
+				PatternGroups: config.PatternGroups{{Patterns: []string{"*.yaml"}, Retention: 24 * time.Hour}},
+			}, nil, nil)
+			s.SetClock(fake)
+
+			const blobPath = "account/container/app.yaml"
+			if _, err := s.recorder.RecordCapture(context.Background(), nil, Capture{
+				BlobPath: blobPath, Content: []byte("key: 1\n"), ETag: "etag-1",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			fake.Advance(time.Minute)
+			file, err := st.GetFile(blobPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.recorder.RecordCapture(context.Background(), file, Capture{
+				BlobPath: blobPath, Content: []byte("key: 2\n"), ETag: "etag-2",
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			fake.Advance(tt.elapsed)
+			if err := s.pruneVersions(); err != nil {
+				t.Fatal(err)
+			}
+
+			versions, err := st.GetVersionsByFileID(file.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != tt.wantVersions {
+				t.Errorf("%d versions left, want %d", len(versions), tt.wantVersions)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package syncer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// Storage account health statuses
+const (
+	// HealthHealthy accounts were listed by the last cycle
+	HealthHealthy = "healthy"
+	// HealthDegraded accounts failed to list in the last cycle, but were
+	// listed successfully within sync.stale_after
+	HealthDegraded = "degraded"
+	// HealthStale accounts haven't been listed successfully within
+	// sync.stale_after, so their history may be out of date
+	HealthStale = "stale"
+	// HealthPaused accounts are not synced until they are resumed
+	HealthPaused = "paused"
+)
+
+// AccountHealth describes how up to date the history of a storage account is
+type AccountHealth struct {
+	StorageAccount string `json:"storage_account"`
+	Status         string `json:"status"`
+	// ConsecutiveFailures is the number of cycles in a row whose listing of
+	// the account failed
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// LastSuccessAt is when the account was last listed successfully
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	// SinceLastSuccess is how long ago that was
+	SinceLastSuccess string `json:"since_last_success,omitempty"`
+	// AuthFailed is set when the last failure was caused by the credentials
+	// being rejected or lacking access
+	AuthFailed bool   `json:"auth_failed"`
+	LastError  string `json:"last_error,omitempty"`
+	// Paused is set while the account is paused, which keeps it from
+	// becoming stale
+	Paused bool `json:"paused"`
+
+	// failingSince is when the account started failing, for accounts that
+	// have never been listed successfully
+	failingSince time.Time
+}
+
+// recordListing updates the health of the storage accounts from a cycle's
+// listings, once they are complete. An account some of whose containers
+// failed to list has failed too.
+func (s *Syncer) recordListing(listings []blob.AccountListing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.health == nil {
+		s.health = make(map[string]*AccountHealth)
+	}
+	now := s.clock.Now()
+	for _, listing := range listings {
+		health := s.health[listing.StorageAccount]
+		if health == nil {
+			health = &AccountHealth{StorageAccount: listing.StorageAccount}
+			s.health[listing.StorageAccount] = health
+		}
+
+		health.Paused = listing.Paused
+		if listing.Paused {
+			continue
+		}
+		err := listingError(listing)
+		if err == nil {
+			health.ConsecutiveFailures = 0
+			health.LastSuccessAt = &now
+			health.AuthFailed = false
+			health.LastError = ""
+			continue
+		}
+		if health.ConsecutiveFailures == 0 && health.LastSuccessAt == nil {
+			health.failingSince = now
+		}
+		health.ConsecutiveFailures++
+		health.AuthFailed = blob.IsAuthError(err)
+		health.LastError = err.Error()
+	}
+}
+
+// listingError returns the error of an account's listing: the account's own
+// or, if only some of its containers failed, the first of theirs by name
+func listingError(listing blob.AccountListing) error {
+	if listing.Err != nil || len(listing.FailedContainers) == 0 {
+		return listing.Err
+	}
+	containers := make([]string, 0, len(listing.FailedContainers))
+	for container := range listing.FailedContainers {
+		containers = append(containers, container)
+	}
+	sort.Strings(containers)
+	return fmt.Errorf("container %s: %w", containers[0], listing.FailedContainers[containers[0]])
+}
+
+// accountHealth returns the health of each storage account listed so far,
+// sorted by name. s.mu must be held.
+func (s *Syncer) accountHealth() []AccountHealth {
+	accounts := make([]AccountHealth, 0, len(s.health))
+	for _, h := range s.health {
+		health := *h
+		since := health.failingSince
+		if health.LastSuccessAt != nil {
+			since = *health.LastSuccessAt
+			health.SinceLastSuccess = s.clock.Now().Sub(since).Round(time.Second).String()
+		}
+
+		// An account listed by the running cycle is as up to date as it can
+		// be until the cycle ends, however long that takes, as a backfill can
+		listedThisCycle := s.status.Running && s.status.CycleStartedAt != nil &&
+			health.LastSuccessAt != nil && !health.LastSuccessAt.Before(*s.status.CycleStartedAt)
+
+		switch {
+		case health.Paused:
+			health.Status = HealthPaused
+		case s.config.StaleAfter > 0 && !listedThisCycle && s.clock.Now().Sub(since) > s.config.StaleAfter:
+			health.Status = HealthStale
+		case health.ConsecutiveFailures > 0:
+			health.Status = HealthDegraded
+		default:
+			health.Status = HealthHealthy
+		}
+		accounts = append(accounts, health)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].StorageAccount < accounts[j].StorageAccount
+	})
+	return accounts
+}
@@ -0,0 +1,72 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// refreshImmutability looks up which of the containers of the listed blobs
+// are read-only because of an immutability policy or legal hold. Containers
+// that can't be looked up keep what was known about them.
+func (s *Syncer) refreshImmutability(ctx context.Context, blobs []blob.BlobInfo) {
+	checked := make(map[string]bool)
+	found := make(map[string]string)
+	for _, b := range blobs {
+		key := b.StorageAccount + "/" + b.Container
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+
+		immutability, err := s.blobClient.GetContainerImmutability(ctx, b.StorageAccount, b.Container)
+		if err != nil {
+			log.Printf("Error checking immutability of container %s: %v", key, err)
+			if reason := s.readOnlyReason(key); reason != "" {
+				found[key] = reason
+			}
+			continue
+		}
+		if immutability.ReadOnly() {
+			found[key] = immutability.Reason()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range found {
+		if s.immutable[key] == "" {
+			log.Printf("Container %s is read-only: %s", key, found[key])
+		}
+	}
+	s.immutable = found
+}
+
+// readOnlyReason returns why a container, keyed by account/container, is
+// read-only, or "" if it isn't
+func (s *Syncer) readOnlyReason(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.immutable[key]
+}
+
+// ReadOnly returns why the blob at a full path can't be written, such as an
+// immutability policy on its container, or "" if it can be
+func (s *Syncer) ReadOnly(fullPath string) string {
+	storageAccount, container, _, err := blob.ParseFullPath(fullPath)
+	if err != nil {
+		return ""
+	}
+	return s.readOnlyReason(storageAccount + "/" + container)
+}
+
+// checkWritable returns an error wrapping blob.ErrImmutable if the blob at a
+// full path is known to be read-only
+func (s *Syncer) checkWritable(fullPath string) error {
+	if reason := s.ReadOnly(fullPath); reason != "" {
+		return fmt.Errorf("%w: %s", blob.ErrImmutable, reason)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package syncer
+
+import (
+	"sync"
+
+	"github.com/toggle-vault/internal/store"
+)
+
+// inflight locks the blob paths being processed, so that the sync cycle,
+// retries, targeted syncs, checkpoints and edits don't process the same
+// blob at once and record its change twice
+type inflight struct {
+	mu    sync.Mutex
+	paths map[string]*pathLock
+}
+
+// pathLock is the lock of one path, removed once no one holds or waits
+// for it
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock waits until no one else processes path and returns the function
+// that releases it
+func (f *inflight) lock(path string) (unlock func()) {
+	f.mu.Lock()
+	if f.paths == nil {
+		f.paths = make(map[string]*pathLock)
+	}
+	l, ok := f.paths[path]
+	if !ok {
+		l = &pathLock{}
+		f.paths[path] = l
+	}
+	l.refs++
+	f.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(f.paths, path)
+		}
+	}
+}
+
+// lockFile locks the path of a file and reads its record again, as another
+// entry point may have changed it while the lock was held. The record is nil
+// if the file is gone.
+func (s *Syncer) lockFile(file *store.File) (*store.File, func(), error) {
+	unlock := s.inflight.lock(file.BlobPath)
+	current, err := s.store.GetFile(file.BlobPath)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return current, unlock, nil
+}
@@ -0,0 +1,46 @@
+package syncer
+
+import (
+	"log"
+
+	"github.com/toggle-vault/internal/preview"
+	"github.com/toggle-vault/internal/store"
+)
+
+// detectLanguages sets the language of files recorded before languages were
+// detected, from their last content. Files whose content is plain text or
+// still pending are checked again on the next start.
+func (s *Syncer) detectLanguages() {
+	files, err := s.store.ListFilesWithStatus(store.FileStatusAll, store.FileSortPath, nil)
+	if err != nil {
+		log.Printf("Error listing files to detect their languages: %v", err)
+		return
+	}
+
+	detected := 0
+	for _, file := range files {
+		if file.Language != "" || file.ContentPending() {
+			continue
+		}
+		version, err := s.store.GetLastContentVersion(file.ID)
+		if err != nil {
+			log.Printf("Error getting content of %s to detect its language: %v", file.BlobPath, err)
+			continue
+		}
+		if version == nil {
+			continue
+		}
+		language := preview.Detect(file.BlobPath, []byte(version.Content))
+		if language == "" {
+			continue
+		}
+		if err := s.store.SetFileLanguage(file.ID, language); err != nil {
+			log.Printf("Error setting language of %s: %v", file.BlobPath, err)
+			continue
+		}
+		detected++
+	}
+	if detected > 0 {
+		log.Printf("Detected the language of %d files", detected)
+	}
+}
@@ -0,0 +1,219 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/store"
+)
+
+// ErrContentUnavailable is returned when the content of a hash-only version
+// can no longer be fetched because the blob has changed since it was captured
+var ErrContentUnavailable = errors.New("content no longer available: the blob has changed since this version was captured")
+
+// capture converts downloaded blob content into a Capture of a change to
+// existing, snapshotting the blob if snapshots are enabled or the content is
+// over the size limit. Files matching the lazy patterns or a hash capture
+// group are snapshotted too and recorded by hash only, so their content can
+// still be fetched once the blob has changed; if the snapshot fails the
+// downloaded content is kept instead.
+// Encrypted files are recorded by hash only unless they can be decrypted, or
+// are SOPS files whose ciphertext is kept to compare it without the metadata.
+func (s *Syncer) capture(ctx context.Context, blobContent *blob.BlobContent, existing *store.File) Capture {
+	return s.captureContent(ctx, blobContent, existing, s.lazyCapture(blobContent.Path))
+}
+
+// captureContent is capture with the lazy capture of the file decided by the
+// caller
+func (s *Syncer) captureContent(ctx context.Context, blobContent *blob.BlobContent, existing *store.File, lazy bool) Capture {
+	c := captureFromBlob(blobContent)
+	if scheme := encryption.Detect(blobContent.Content); s.decrypter == nil && scheme != "" &&
+		!(scheme == encryption.SOPS && s.config.IgnoreSOPSMetadata) {
+		c.HashOnly = true
+	}
+	c.MaxContentSize = s.config.MaxContentSize
+	// Content over the size limit is kept in full by a snapshot
+	oversized := c.MaxContentSize > 0 && int64(len(blobContent.Content)) > c.MaxContentSize
+
+	if (s.config.Snapshots || lazy || oversized) && contentChanged(existing, blobContent) {
+		c.SnapshotID = s.snapshot(ctx, blobContent.FullPath, blobContent.ETag)
+		if c.SnapshotID != "" && (s.config.SnapshotOnly || lazy) {
+			c.HashOnly = true
+		}
+	}
+	return c
+}
+
+// lazyCapture reports whether the versions of a blob, by its path within its
+// container, are recorded by hash only and their content fetched on demand
+func (s *Syncer) lazyCapture(path string) bool {
+	if group := s.config.PatternGroups.Find(path); group != nil && group.Capture != "" {
+		return group.Capture == config.CaptureHash
+	}
+	return len(s.config.LazyPatterns) > 0 && blob.MatchesPatterns(path, s.config.LazyPatterns)
+}
+
+// captureFromListing records a change of a lazily captured file from its
+// listing alone, without downloading the blob: the Content-MD5 of the
+// listing stands in for the content hash and a snapshot keeps the content
+// for when it's first viewed. It returns false, leaving the blob to be
+// downloaded, if the listing has no Content-MD5, the file was last recorded
+// with a hash the listing can't be compared with, or the snapshot fails.
+func (s *Syncer) captureFromListing(ctx context.Context, blobInfo blob.BlobInfo, existing *store.File) (Capture, bool) {
+	hash := blob.ListedHash(blobInfo)
+	if hash == "" || !s.lazyCapture(blobInfo.Path) {
+		return Capture{}, false
+	}
+	known := existing != nil && !existing.IsDeleted && !existing.ContentPending()
+	if known && !blob.IsListedHash(existing.ContentHash) {
+		return Capture{}, false
+	}
+
+	c := Capture{
+		BlobPath:       blobInfo.FullPath,
+		ContentHash:    hash,
+		ETag:           blobInfo.ETag,
+		LastModified:   blobInfo.LastModified,
+		HashOnly:       true,
+		Listed:         true,
+		Size:           blobInfo.Size,
+		MaxContentSize: s.config.MaxContentSize,
+	}
+	if known && existing.ContentHash == hash {
+		// Only the ETag changed, which is recorded without a version
+		return c, true
+	}
+	c.SnapshotID = s.snapshot(ctx, blobInfo.FullPath, blobInfo.ETag)
+	return c, c.SnapshotID != ""
+}
+
+// contentChanged reports whether downloaded content would be recorded as a
+// new version of existing
+func contentChanged(existing *store.File, blobContent *blob.BlobContent) bool {
+	if existing == nil || existing.IsDeleted || existing.ContentPending() {
+		return true
+	}
+	if blob.IsListedHash(existing.ContentHash) {
+		return !blob.HashMatches(blobContent.Content, existing.ContentHash)
+	}
+	return existing.ContentHash != blobContent.ContentHash
+}
+
+// snapshot creates a snapshot of a blob while it still has the given ETag and
+// returns its ID, or "" if the blob changed since or the snapshot failed
+func (s *Syncer) snapshot(ctx context.Context, fullPath, etag string) string {
+	snapshotID, err := s.blobClient.CreateSnapshot(ctx, fullPath, etag)
+	if err != nil {
+		log.Printf("Error creating snapshot of %s: %v", fullPath, err)
+		return ""
+	}
+	return snapshotID
+}
+
+// trackMetadata tracks a file from its listing alone, existing being its
+// current record or nil. A new file has no versions until its content is
+// fetched by FetchContent or a change.
+func (s *Syncer) trackMetadata(ctx context.Context, batch *Batch, blobInfo blob.BlobInfo, existing *store.File) error {
+	return batch.AddFile(ctx, existing, &store.File{
+		BlobPath:     blobInfo.FullPath,
+		ETag:         blobInfo.ETag,
+		LastModified: blobInfo.LastModified,
+	})
+}
+
+// FetchContent downloads and records the content of a file that was tracked
+// by metadata only. It returns nil if the file is unknown, already has
+// content or is in a metadata capture group.
+func (s *Syncer) FetchContent(ctx context.Context, blobPath string) (*store.Version, error) {
+	if s.metadataOnly(blobPath) {
+		return nil, nil
+	}
+
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	file, err := s.store.GetFile(blobPath)
+	if err != nil || file == nil || !file.ContentPending() {
+		return nil, err
+	}
+
+	blobContent, err := s.blobClient.GetBlobByFullPath(ctx, blobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content is wanted now, so it's kept even for a lazily captured file
+	c := s.captureContent(ctx, blobContent, file, false)
+	version, err := s.recorder.RecordCapture(ctx, file, c)
+	if err != nil {
+		s.rejectCapture(ctx, c, err)
+		return nil, err
+	}
+
+	s.publishChange(blobPath, version)
+
+	log.Printf("Fetched content of %s on first view (version %d)", blobPath, version.ID)
+	return version, nil
+}
+
+// LoadVersionContent fills in the content of a version recorded by hash only,
+// downloading it and caching it in the store. See VersionContent for where
+// the content comes from.
+func (s *Syncer) LoadVersionContent(ctx context.Context, version *store.Version) error {
+	if !version.ContentPending {
+		return nil
+	}
+
+	fullContent, err := s.VersionContent(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	content, truncated := truncateContent(string(fullContent), s.config.MaxContentSize)
+	if err := s.store.SetVersionContent(version.ID, content, truncated); err != nil {
+		return err
+	}
+
+	version.Content = content
+	version.Truncated = truncated
+	version.ContentPending = false
+	return nil
+}
+
+// VersionContent returns the full content of a version. Versions stored in
+// full are returned as is; truncated and hash-only versions are read from
+// their blob snapshot, or from the blob itself while it still holds the
+// captured content. ErrContentUnavailable is returned otherwise.
+func (s *Syncer) VersionContent(ctx context.Context, version *store.Version) ([]byte, error) {
+	if !version.ContentPending && !version.Truncated {
+		return []byte(version.Content), nil
+	}
+
+	file, err := s.store.GetFileByID(version.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("file %d not found", version.FileID)
+	}
+
+	var blobContent *blob.BlobContent
+	if version.SnapshotID != "" {
+		blobContent, err = s.blobClient.GetBlobSnapshot(ctx, file.BlobPath, version.SnapshotID)
+	} else {
+		blobContent, err = s.blobClient.GetBlobByFullPath(ctx, file.BlobPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !blob.HashMatches(blobContent.Content, version.ContentHash) {
+		return nil, ErrContentUnavailable
+	}
+
+	return blobContent.Content, nil
+}
@@ -0,0 +1,56 @@
+package syncer
+
+import (
+	"errors"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// ErrSyncPaused is returned when syncing a file of a paused storage account
+// or container
+var ErrSyncPaused = errors.New("syncing of the file's storage account or container is paused")
+
+// syncPauses are the paused storage accounts, and containers keyed by
+// account/container
+type syncPauses map[string]bool
+
+// loadPauses returns the storage accounts and containers paused in the store
+func (s *Syncer) loadPauses() (syncPauses, error) {
+	list, err := s.store.ListSyncPauses()
+	if err != nil {
+		return nil, err
+	}
+	pauses := make(syncPauses, len(list))
+	for _, p := range list {
+		key := p.StorageAccount
+		if p.Container != "" {
+			key += "/" + p.Container
+		}
+		pauses[key] = true
+	}
+	return pauses, nil
+}
+
+// covers reports whether a storage account, or a container of it if
+// container isn't empty, is paused
+func (p syncPauses) covers(storageAccount, container string) bool {
+	return p[storageAccount] || (container != "" && p[storageAccount+"/"+container])
+}
+
+// coversPath reports whether the blob at a full path is paused
+func (p syncPauses) coversPath(fullPath string) bool {
+	storageAccount, container, _, err := blob.ParseFullPath(fullPath)
+	return err == nil && p.covers(storageAccount, container)
+}
+
+// checkPaused returns ErrSyncPaused if the blob at a full path is paused
+func (s *Syncer) checkPaused(fullPath string) error {
+	pauses, err := s.loadPauses()
+	if err != nil {
+		return err
+	}
+	if pauses.coversPath(fullPath) {
+		return ErrSyncPaused
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package syncer
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// quotaWarnRatio is the share of a quota at which warnings start
+	quotaWarnRatio = 0.8
+	// quotaBatch is how many versions are listed at a time when dropping
+	// content
+	quotaBatch = 500
+)
+
+// StorageStatus is the content stored for versions, as of the last quota
+// check
+type StorageStatus struct {
+	Bytes      int64 `json:"bytes"`
+	TotalQuota int64 `json:"total_quota,omitempty"`
+	FileQuota  int64 `json:"file_quota,omitempty"`
+	// Warnings lists the quotas that are 80% used or more
+	Warnings  []QuotaWarning `json:"warnings,omitempty"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// QuotaWarning is a quota that is nearly or fully used, that of a file or,
+// without BlobPath, the total quota
+type QuotaWarning struct {
+	BlobPath string `json:"blob_path,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	Quota    int64  `json:"quota"`
+}
+
+// enforceQuotas drops the stored content of the oldest versions of the files
+// over sync.file_quota and then of all files while the total is over
+// sync.total_quota, and warns about quotas nearly used up. The versions
+// themselves are kept, so notifications, links and snapshots naming them
+// still work, and their content can be fetched back while the blob or a
+// snapshot has it.
+func (s *Syncer) enforceQuotas() error {
+	usage, err := s.store.StorageByFile()
+	if err != nil {
+		return err
+	}
+
+	storage := StorageStatus{
+		TotalQuota: s.config.TotalQuota,
+		FileQuota:  s.config.FileQuota,
+		CheckedAt:  s.clock.Now(),
+	}
+	for _, file := range usage {
+		quota := s.config.FileQuota
+		if quota > 0 && file.Bytes > quota {
+			dropped, freed, err := s.dropOldest(file.FileID, file.Bytes-quota)
+			if err != nil {
+				return err
+			}
+			if dropped > 0 {
+				log.Printf("%s exceeds its quota of %d bytes; dropped the stored content of its %d oldest versions (%d bytes)",
+					file.BlobPath, quota, dropped, freed)
+			}
+			file.Bytes -= freed
+		}
+		if quota > 0 && float64(file.Bytes) >= quotaWarnRatio*float64(quota) {
+			log.Printf("Warning: %s uses %d of its %d byte quota", file.BlobPath, file.Bytes, quota)
+			storage.Warnings = append(storage.Warnings, QuotaWarning{BlobPath: file.BlobPath, Bytes: file.Bytes, Quota: quota})
+		}
+		storage.Bytes += file.Bytes
+	}
+
+	if quota := s.config.TotalQuota; quota > 0 {
+		if storage.Bytes > quota {
+			dropped, freed, err := s.dropOldest(0, storage.Bytes-quota)
+			if err != nil {
+				return err
+			}
+			if dropped > 0 {
+				log.Printf("Stored versions exceed the total quota of %d bytes; dropped the stored content of the %d oldest versions (%d bytes)",
+					quota, dropped, freed)
+			}
+			storage.Bytes -= freed
+		}
+		if float64(storage.Bytes) >= quotaWarnRatio*float64(quota) {
+			log.Printf("Warning: stored versions use %d of the %d byte total quota", storage.Bytes, quota)
+			storage.Warnings = append(storage.Warnings, QuotaWarning{Bytes: storage.Bytes, Quota: quota})
+		}
+	}
+
+	s.mu.Lock()
+	s.status.Storage = &storage
+	s.mu.Unlock()
+	return nil
+}
+
+// dropOldest drops the stored content of the oldest versions of a file, or
+// of all files if fileID is 0, until at least excess bytes are freed or only
+// the latest versions have content. It returns the number of versions
+// dropped and the bytes freed.
+func (s *Syncer) dropOldest(fileID int64, excess int64) (int, int64, error) {
+	dropped, freed := 0, int64(0)
+	for freed < excess {
+		versions, err := s.store.OldestVersions(fileID, quotaBatch)
+		if err != nil {
+			return dropped, freed, err
+		}
+		if len(versions) == 0 {
+			break
+		}
+		for _, v := range versions {
+			if freed >= excess {
+				break
+			}
+			if err := s.store.DropVersionContent(v.ID); err != nil {
+				return dropped, freed, err
+			}
+			dropped++
+			freed += v.Bytes
+		}
+	}
+	return dropped, freed, nil
+}
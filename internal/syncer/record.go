@@ -0,0 +1,402 @@
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/clock"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/preview"
+	"github.com/toggle-vault/internal/store"
+)
+
+// Capture is the content and metadata read from a blob at a point in time
+type Capture struct {
+	BlobPath     string
+	Content      []byte
+	ContentHash  string
+	ETag         string
+	LastModified time.Time
+	// HashOnly records the version without its content, which is fetched on
+	// first use instead
+	HashOnly bool
+	// Listed records a change from the blob's listing without its content,
+	// whose size is then given by Size. The pre-store hooks are skipped as
+	// there's no content for them to check.
+	Listed bool
+	Size   int64
+	// MaxContentSize truncates content larger than this many bytes to an
+	// excerpt. 0 means no limit.
+	MaxContentSize int64
+	// SnapshotID is the blob snapshot taken of this content, if any
+	SnapshotID string
+	// Author is the user who wrote the content through the vault, if any
+	Author string
+	// Comment explains the change, if given
+	Comment string
+	// RestoredFrom is the version this content restores, if any
+	RestoredFrom int64
+	// Prevalidated skips the pre-store hooks because the content already
+	// passed them (see Recorder.PreStore)
+	Prevalidated bool
+	// Checkpoint records unchanged content as a checkpoint version rather
+	// than skipping it
+	Checkpoint bool
+	// ChangeType records a change as this type rather than the one detected,
+	// such as imported for content brought in from elsewhere. Unchanged
+	// content is still skipped unless Checkpoint is set.
+	ChangeType store.ChangeType
+}
+
+// captureFromBlob converts downloaded blob content into a Capture
+func captureFromBlob(blobContent *blob.BlobContent) Capture {
+	return Capture{
+		BlobPath:     blobContent.FullPath,
+		Content:      blobContent.Content,
+		ContentHash:  blobContent.ContentHash,
+		ETag:         blobContent.ETag,
+		LastModified: blobContent.LastModified,
+	}
+}
+
+// Recorder turns captured content into versions in the store, running any
+// registered hooks around each version it writes
+type Recorder struct {
+	store store.Store
+	hooks *hooks.Registry
+	// groups limit the hooks run for their files
+	groups config.PatternGroups
+	// ignoreSOPSMetadata compares SOPS files without their metadata section
+	ignoreSOPSMetadata bool
+	// decrypter, if set, lets SOPS files be compared by their plaintext
+	decrypter *encryption.Decrypter
+	// clock timestamps captured versions
+	clock clock.Clock
+	// notified, if set, reports whether a change was notified outside the
+	// vault, which keeps its version from being coalesced
+	notified func(store.ChangeEvent) bool
+	// deleteSnapshot, if set, deletes a blob snapshot taken for a capture
+	// that wasn't recorded
+	deleteSnapshot func(ctx context.Context, blobPath, snapshotID string)
+}
+
+// NewRecorder creates a Recorder. The hook registry may be nil.
+func NewRecorder(st store.Store, registry *hooks.Registry) *Recorder {
+	return &Recorder{
+		store: st,
+		hooks: registry,
+		clock: clock.Real,
+	}
+}
+
+// hooksFor returns the hooks to run for a file: those named by its pattern
+// group, or all of them
+func (r *Recorder) hooksFor(blobPath string) *hooks.Registry {
+	if group := r.groups.Find(blobPath); group != nil && len(group.Hooks) > 0 {
+		return r.hooks.Only(group.Hooks)
+	}
+	return r.hooks
+}
+
+// pendingCapture is a capture worked out into the writes that record it
+type pendingCapture struct {
+	file *store.File
+	// version is nil when the content is unchanged and only the file's ETag
+	// and modification time are updated
+	version *store.Version
+	payload *hooks.Payload
+	// previousETag is the file's stored ETag the writes were worked out
+	// from, "" for a new file
+	previousETag string
+}
+
+// RecordCapture stores captured content as a new version of its file if it
+// differs from the last recorded content. existing is the current file record,
+// or nil for a file that has never been seen. A file whose content has never been
+// fetched is recorded as created; a file that was previously deleted is recorded
+// as recreated, linked to the deletion it follows. When the content is unchanged
+// only the file's ETag and modification time are updated and a nil version is
+// returned, unless the capture is a checkpoint.
+func (r *Recorder) RecordCapture(ctx context.Context, existing *store.File, c Capture) (*store.Version, error) {
+	st := r.store
+
+	p, err := r.prepare(ctx, existing, c)
+	if err != nil {
+		return nil, err
+	}
+	if p.version == nil {
+		return nil, st.UpsertFile(p.file)
+	}
+
+	// New files need an ID before the version can reference them
+	if p.file.ID == 0 {
+		if err := st.UpsertFile(p.file); err != nil {
+			return nil, err
+		}
+		p.version.FileID = p.file.ID
+	}
+
+	if err := st.CreateVersion(p.version); err != nil {
+		if errors.Is(err, store.ErrDuplicateVersion) {
+			// Recorded by another entry point first
+			r.discard(ctx, p)
+			return nil, st.UpsertFile(p.file)
+		}
+		return nil, err
+	}
+
+	if err := st.UpsertFile(p.file); err != nil {
+		return nil, err
+	}
+
+	r.hooksFor(p.payload.BlobPath).PostStore(ctx, p.payload)
+	r.coalesce(p.payload.BlobPath, p.version)
+
+	return p.version, nil
+}
+
+// discard cleans up after a capture that wasn't recorded: the snapshot taken
+// of it is deleted, since no version refers to it
+func (r *Recorder) discard(ctx context.Context, p *pendingCapture) {
+	if p.version != nil && p.version.SnapshotID != "" && r.deleteSnapshot != nil {
+		r.deleteSnapshot(ctx, p.payload.BlobPath, p.version.SnapshotID)
+	}
+}
+
+// prepare works out the file update and version that record a capture and
+// runs the pre-store hooks on the version, without writing anything
+func (r *Recorder) prepare(ctx context.Context, existing *store.File, c Capture) (*pendingCapture, error) {
+	if c.ContentHash == "" {
+		c.ContentHash = blob.ComputeHash(c.Content)
+	}
+	var previousETag string
+	if existing != nil {
+		previousETag = existing.ETag
+	}
+
+	changeType := store.ChangeTypeModified
+	file := existing
+	var deletedVersionID int64
+
+	if existing == nil || existing.IsDeleted || existing.ContentPending() {
+		changeType = store.ChangeTypeCreated
+		file = &store.File{BlobPath: c.BlobPath}
+		if existing != nil {
+			file.ID = existing.ID
+		}
+		if existing != nil && existing.IsDeleted {
+			changeType = store.ChangeTypeRecreated
+			deletion, err := r.store.GetLatestVersion(existing.ID)
+			if err != nil {
+				return nil, err
+			}
+			if deletion != nil && deletion.ChangeType == store.ChangeTypeDeleted {
+				deletedVersionID = deletion.ID
+			}
+		}
+	} else if same, err := r.sameContent(ctx, existing, c); err != nil {
+		return nil, err
+	} else if same && c.Checkpoint {
+		changeType = store.ChangeTypeCheckpoint
+	} else if same {
+		// Content same (ETag might change without content changing, XML
+		// may only be reformatted and SOPS only re-encrypted), just update
+		// ETag. The file takes the hash of the blob as it is now, so an
+		// unchanged blob isn't downloaded and compared again next sync.
+		existing.ETag = c.ETag
+		existing.LastModified = c.LastModified
+		existing.ContentHash = c.ContentHash
+		return &pendingCapture{file: existing, previousETag: previousETag}, nil
+	}
+
+	// Writing back an earlier version is a restore rather than an edit
+	if changeType == store.ChangeTypeModified && c.RestoredFrom != 0 {
+		changeType = store.ChangeTypeRestored
+	}
+	if c.ChangeType != "" {
+		changeType = c.ChangeType
+	}
+
+	file.ETag = c.ETag
+	file.ContentHash = c.ContentHash
+	file.LastModified = c.LastModified
+	file.IsDeleted = false
+	file.Language = preview.Detect(c.BlobPath, c.Content)
+
+	version := &store.Version{
+		FileID:           file.ID,
+		Content:          string(c.Content),
+		ContentHash:      c.ContentHash,
+		ChangeType:       changeType,
+		CapturedAt:       r.clock.Now(),
+		BlobETag:         c.ETag,
+		BlobLastModified: c.LastModified,
+		Size:             int64(len(c.Content)),
+		SnapshotID:       c.SnapshotID,
+		Encrypted:        encryption.Detect(c.Content) != "",
+		Author:           c.Author,
+		Comment:          c.Comment,
+		DeletedVersionID: deletedVersionID,
+
+		RestoredFromVersionID: c.RestoredFrom,
+	}
+	if c.Listed {
+		version.Size = c.Size
+	}
+
+	// Hooks see the version before anything is written so a rejection leaves
+	// the file untouched and the change is picked up again on the next sync
+	payload := &hooks.Payload{BlobPath: c.BlobPath, Version: version}
+	if !c.Prevalidated && !c.Listed {
+		if err := r.hooksFor(c.BlobPath).PreStore(ctx, payload); err != nil {
+			return nil, err
+		}
+		// A transform hook changes what is stored, so the version takes the
+		// hash and size of its new content. Hash-only versions keep those of
+		// the blob, whose content they are later filled in with.
+		if !c.HashOnly && version.Content != string(c.Content) {
+			version.ContentHash = blob.ComputeHash([]byte(version.Content))
+			version.Size = int64(len(version.Content))
+		}
+	}
+
+	if c.HashOnly {
+		version.Content = ""
+		version.ContentPending = true
+	} else {
+		version.Content, version.Truncated = truncateContent(version.Content, c.MaxContentSize)
+	}
+
+	return &pendingCapture{file: file, version: version, payload: payload, previousETag: previousETag}, nil
+}
+
+// sameContent reports whether captured content is that of the latest
+// version of existing, or differs from it only in formatting, such as
+// whitespace between XML elements or the order of attributes, or, if
+// enabled, in the sops metadata of a SOPS-encrypted file
+func (r *Recorder) sameContent(ctx context.Context, existing *store.File, c Capture) (bool, error) {
+	if existing.ContentHash == c.ContentHash {
+		return true, nil
+	}
+	// Files last recorded from their listing have the hash of the listing
+	if blob.IsListedHash(existing.ContentHash) && !c.Listed && blob.HashMatches(c.Content, existing.ContentHash) {
+		return true, nil
+	}
+	sops := r.ignoreSOPSMetadata && encryption.Detect(c.Content) == encryption.SOPS
+	if !sops && diff.Format(c.BlobPath) != diff.FormatXML {
+		return false, nil
+	}
+	latest, err := r.store.GetLatestVersion(existing.ID)
+	if err != nil {
+		return false, err
+	}
+	if latest == nil || latest.ContentPending || latest.Truncated {
+		return false, nil
+	}
+	if sops {
+		return r.sameSOPSContent(ctx, c.BlobPath, []byte(latest.Content), c.Content), nil
+	}
+	oldContent, newContent, ok := diff.Canonicalize(c.BlobPath, latest.Content, string(c.Content))
+	return ok && oldContent == newContent, nil
+}
+
+// sameSOPSContent reports whether two versions of a SOPS file hold the same
+// settings. With a decrypter their plaintext is compared, so values that were
+// only re-encrypted don't count as changes either; otherwise the ciphertext
+// is compared without the sops metadata.
+func (r *Recorder) sameSOPSContent(ctx context.Context, blobPath string, oldContent, newContent []byte) bool {
+	if r.decrypter != nil {
+		oldPlain, err := r.decrypter.Decrypt(ctx, blobPath, oldContent)
+		if err == nil {
+			var newPlain []byte
+			newPlain, err = r.decrypter.Decrypt(ctx, blobPath, newContent)
+			if err == nil {
+				return bytes.Equal(oldPlain, newPlain)
+			}
+		}
+		log.Printf("Error decrypting %s to compare versions: %v", blobPath, err)
+	}
+	return encryption.SameIgnoringMetadata(blobPath, oldContent, newContent)
+}
+
+// PreStore runs the pre-store hooks on content that is about to be written
+// to blob storage and returns it, as transformed by the hooks. Content that
+// passed is then recorded with Capture.Prevalidated set.
+func (r *Recorder) PreStore(ctx context.Context, blobPath string, content []byte) ([]byte, error) {
+	payload := &hooks.Payload{
+		BlobPath: blobPath,
+		Version: &store.Version{
+			Content:     string(content),
+			ContentHash: blob.ComputeHash(content),
+			CapturedAt:  r.clock.Now(),
+		},
+	}
+	if err := r.hooksFor(blobPath).PreStore(ctx, payload); err != nil {
+		return nil, err
+	}
+	return []byte(payload.Version.Content), nil
+}
+
+// truncateContent cuts content down to at most maxSize bytes, without
+// splitting a UTF-8 character, and reports whether it was truncated
+func truncateContent(content string, maxSize int64) (string, bool) {
+	if maxSize <= 0 || int64(len(content)) <= maxSize {
+		return content, false
+	}
+
+	cut := int(maxSize)
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut], true
+}
+
+// RecordDeletion records a deletion version for a file and marks it deleted.
+// The deletion version keeps the last known content hash.
+func (r *Recorder) RecordDeletion(ctx context.Context, file *store.File) (*store.Version, error) {
+	st := r.store
+
+	// Get the last version to record in the delete version
+	lastVersion, err := st.GetLatestVersion(file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	version := &store.Version{
+		FileID:      file.ID,
+		Content:     "", // Empty content for deleted files
+		ContentHash: "",
+		ChangeType:  store.ChangeTypeDeleted,
+		CapturedAt:  r.clock.Now(),
+	}
+
+	// Preserve the last known content hash
+	if lastVersion != nil {
+		version.ContentHash = lastVersion.ContentHash
+	}
+
+	payload := &hooks.Payload{BlobPath: file.BlobPath, Version: version}
+	if err := r.hooksFor(file.BlobPath).PreStore(ctx, payload); err != nil {
+		return nil, err
+	}
+
+	if err := st.CreateVersion(version); err != nil {
+		return nil, err
+	}
+
+	if err := st.MarkFileDeleted(file.BlobPath); err != nil {
+		return version, err
+	}
+
+	r.hooksFor(file.BlobPath).PostStore(ctx, payload)
+
+	return version, nil
+}
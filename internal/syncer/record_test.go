@@ -0,0 +1,142 @@
+package syncer
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/store"
+)
+
+// upperHook is a pre-store hook that transforms content to upper case
+type upperHook struct{}
+
+func (upperHook) Name() string { return "upper" }
+
+func (upperHook) PreStore(ctx context.Context, p *hooks.Payload) error {
+	p.Version.Content = strings.ToUpper(p.Version.Content)
+	return nil
+}
+
+func (upperHook) PostStore(ctx context.Context, p *hooks.Payload) error { return nil }
+
+func newTestStore(t *testing.T) *store.SQLiteStore {
+	t.Helper()
+	st, err := store.NewSQLiteStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestRecordCaptureHashesStoredContent(t *testing.T) {
+	raw := []byte("key: value\n")
+
+	tests := []struct {
+		name        string
+		hooks       *hooks.Registry
+		hashOnly    bool
+		wantContent string
+		wantHash    string
+	}{
+		{
+			name:        "no hooks",
+			wantContent: string(raw),
+			wantHash:    blob.ComputeHash(raw),
+		},
+		{
+			name:        "transform hook",
+			hooks:       hooks.NewRegistry(upperHook{}),
+			wantContent: "KEY: VALUE\n",
+			wantHash:    blob.ComputeHash([]byte("KEY: VALUE\n")),
+		},
+		{
+			name:        "hash only keeps the blob hash",
+			hooks:       hooks.NewRegistry(upperHook{}),
+			hashOnly:    true,
+			wantContent: "",
+			wantHash:    blob.ComputeHash(raw),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			r := NewRecorder(st, tt.hooks)
+
+			version, err := r.RecordCapture(context.Background(), nil, Capture{
+				BlobPath: "account/container/app.yaml",
+				Content:  raw,
+				ETag:     "etag-1",
+				HashOnly: tt.hashOnly,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := st.GetVersion(version.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", stored.Content, tt.wantContent)
+			}
+			if stored.ContentHash != tt.wantHash {
+				t.Errorf("content hash = %s, want %s", stored.ContentHash, tt.wantHash)
+			}
+			if !tt.hashOnly && stored.Size != int64(len(stored.Content)) {
+				t.Errorf("size = %d, want %d", stored.Size, len(stored.Content))
+			}
+
+			// The file keeps the blob's hash, so the unchanged blob isn't
+			// recorded again on the next sync
+			file, err := st.GetFile("account/container/app.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.ContentHash != blob.ComputeHash(raw) {
+				t.Errorf("file hash = %s, want the blob's %s", file.ContentHash, blob.ComputeHash(raw))
+			}
+		})
+	}
+}
+
+func TestDuplicateCaptureDeletesSnapshot(t *testing.T) {
+	st := newTestStore(t)
+	r := NewRecorder(st, nil)
+	var deleted []string
+	r.deleteSnapshot = func(ctx context.Context, blobPath, snapshotID string) {
+		deleted = append(deleted, snapshotID)
+	}
+
+	// Two entry points capture the same blob before either has recorded it
+	for _, snapshotID := range []string{"snapshot-1", "snapshot-2"} {
+		if _, err := r.RecordCapture(context.Background(), nil, Capture{
+			BlobPath:   "account/container/app.yaml",
+			Content:    []byte("key: value\n"),
+			ETag:       "etag-1",
+			SnapshotID: snapshotID,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := st.GetFile("account/container/app.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := st.GetVersionsByFileID(file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].SnapshotID != "snapshot-1" {
+		t.Fatalf("versions = %+v, want one with snapshot-1", versions)
+	}
+	if len(deleted) != 1 || deleted[0] != "snapshot-2" {
+		t.Errorf("deleted snapshots %v, want snapshot-2", deleted)
+	}
+}
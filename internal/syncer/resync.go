@@ -0,0 +1,168 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/store"
+)
+
+var (
+	// ErrFileArchived is returned when syncing a file that is archived
+	ErrFileArchived = errors.New("file is archived")
+	// ErrMetadataOnly is returned when checkpointing a file tracked by
+	// metadata only, whose content is never captured
+	ErrMetadataOnly = errors.New("file is tracked by metadata only")
+	// ErrDryRun is returned when checkpointing in dry-run mode, where no
+	// versions are recorded
+	ErrDryRun = errors.New("the syncer is in dry-run mode")
+)
+
+// FetchError is returned by SyncFile when the file's blob couldn't be
+// fetched, as opposed to a failure to record it
+type FetchError struct {
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return "failed to fetch blob: " + e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// SyncFile fetches the blob of a tracked file straight away, outside the
+// sync cycle, and records a version if its content changed or the blob was
+// deleted, unless its storage account or container is paused. It returns nil
+// if nothing changed, or if the syncer is in dry-run mode or the file is
+// tracked by metadata only, where no version is recorded.
+func (s *Syncer) SyncFile(ctx context.Context, file *store.File) (*store.Version, error) {
+	if err := s.checkPaused(file.BlobPath); err != nil {
+		return nil, err
+	}
+
+	file, unlock, err := s.lockFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if file == nil {
+		return nil, nil
+	}
+	if file.IsArchived {
+		return nil, ErrFileArchived
+	}
+
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	blobContent, err := s.blobClient.GetBlobByFullPath(ctx, file.BlobPath)
+	if blob.IsNotFound(err) {
+		return s.syncDeletedFile(ctx, file)
+	}
+	if err != nil {
+		return nil, &FetchError{Err: err}
+	}
+
+	if s.config.DryRun {
+		return nil, s.shadowBlob(ctx, blobContent.BlobInfo, file)
+	}
+	if s.metadataOnly(file.BlobPath) {
+		batch := s.recorder.NewBatch(1)
+		if err := s.trackMetadataChange(ctx, batch, blobContent.BlobInfo, file); err != nil {
+			return nil, err
+		}
+		return nil, batch.Flush(ctx)
+	}
+
+	c := s.capture(ctx, blobContent, file)
+	version, err := s.recorder.RecordCapture(ctx, file, c)
+	if err != nil {
+		s.rejectCapture(ctx, c, err)
+		return nil, err
+	}
+	if version == nil {
+		return nil, nil
+	}
+
+	s.publishChange(file.BlobPath, version)
+
+	log.Printf("Recorded %s file on targeted sync: %s (version %d)", version.ChangeType, file.BlobPath, version.ID)
+	return version, nil
+}
+
+// syncDeletedFile records the deletion of a file whose blob is gone, unless
+// it is already marked deleted
+func (s *Syncer) syncDeletedFile(ctx context.Context, file *store.File) (*store.Version, error) {
+	if file.IsDeleted {
+		return nil, nil
+	}
+	if s.config.DryRun {
+		return nil, s.shadowDeletion(file)
+	}
+
+	version, err := s.recorder.RecordDeletion(ctx, file)
+	if version == nil {
+		return nil, err
+	}
+	s.publishChange(file.BlobPath, version)
+
+	log.Printf("Recorded deleted file on targeted sync: %s (version %d)", file.BlobPath, version.ID)
+	return version, err
+}
+
+// Checkpoint captures the current content of a file as a version even if it
+// is unchanged, recorded as a checkpoint with the author and comment. Content
+// that did change is recorded as an ordinary change.
+func (s *Syncer) Checkpoint(ctx context.Context, file *store.File, author, comment string) (*store.Version, error) {
+	file, unlock, err := s.lockFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if file == nil {
+		return nil, nil
+	}
+
+	switch {
+	case file.IsArchived:
+		return nil, ErrFileArchived
+	case s.metadataOnly(file.BlobPath):
+		return nil, ErrMetadataOnly
+	case s.config.DryRun:
+		return nil, ErrDryRun
+	}
+	if err := s.checkPaused(file.BlobPath); err != nil {
+		return nil, err
+	}
+
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	blobContent, err := s.blobClient.GetBlobByFullPath(ctx, file.BlobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := s.capture(ctx, blobContent, file)
+	c.Author = author
+	c.Comment = comment
+	c.Checkpoint = true
+
+	version, err := s.recorder.RecordCapture(ctx, file, c)
+	if err != nil {
+		s.rejectCapture(ctx, c, err)
+		return nil, err
+	}
+	if version == nil {
+		return nil, nil
+	}
+
+	s.publishChange(file.BlobPath, version)
+
+	log.Printf("Recorded %s of %s (version %d)", version.ChangeType, file.BlobPath, version.ID)
+	return version, nil
+}
@@ -0,0 +1,119 @@
+package syncer
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// retryItem is a blob whose download failed, waiting to be retried
+type retryItem struct {
+	blobInfo    blob.BlobInfo
+	failures    int
+	nextAttempt time.Time
+}
+
+// download downloads a blob. A blob that can't be downloaded is added to the
+// retry queue, which backs off its retries, so that it's retried before the
+// next cycle without holding up the rest of this one.
+func (s *Syncer) download(ctx context.Context, blobInfo blob.BlobInfo) (*blob.BlobContent, error) {
+	blobContent, err := s.blobClient.GetBlob(ctx, blobInfo.StorageAccount, blobInfo.Container, blobInfo.Path)
+	if err == nil {
+		s.dequeueRetry(blobInfo.FullPath)
+		return blobContent, nil
+	}
+	// Retrying won't bring back deleted blobs or fix credentials
+	if blob.IsNotFound(err) || blob.IsAuthError(err) {
+		s.dequeueRetry(blobInfo.FullPath)
+		return nil, err
+	}
+	if ctx.Err() == nil {
+		s.queueRetry(blobInfo)
+	}
+	return nil, err
+}
+
+// queueRetry adds a blob to the retry queue, or pushes back its next retry
+// if it's already queued
+func (s *Syncer) queueRetry(blobInfo blob.BlobInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retries == nil {
+		s.retries = make(map[string]*retryItem)
+	}
+	item := s.retries[blobInfo.FullPath]
+	if item == nil {
+		item = &retryItem{}
+		s.retries[blobInfo.FullPath] = item
+	}
+	item.blobInfo = blobInfo
+	item.failures++
+
+	backoff := s.config.RetryInterval << (item.failures - 1)
+	if backoff <= 0 || (s.config.Interval > 0 && backoff > s.config.Interval) {
+		backoff = s.config.Interval
+	}
+	item.nextAttempt = s.clock.Now().Add(backoff)
+}
+
+// dequeueRetry removes a blob from the retry queue
+func (s *Syncer) dequeueRetry(blobPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.retries, blobPath)
+}
+
+// dueRetries returns the queued blobs whose retry is due
+func (s *Syncer) dueRetries() []blob.BlobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	var due []blob.BlobInfo
+	for _, item := range s.retries {
+		if !now.Before(item.nextAttempt) {
+			due = append(due, item.blobInfo)
+		}
+	}
+	return due
+}
+
+// retryFailed processes the queued blobs whose retry is due. Blobs that fail
+// again stay queued with a longer backoff.
+func (s *Syncer) retryFailed(ctx context.Context) {
+	due := s.dueRetries()
+	if len(due) == 0 || s.Paused() {
+		return
+	}
+	s.cycleMu.Lock()
+	defer s.cycleMu.Unlock()
+
+	pauses, err := s.loadPauses()
+	if err != nil {
+		log.Printf("Error loading sync pauses: %v", err)
+		return
+	}
+
+	log.Printf("Retrying %d failed blobs...", len(due))
+	batch := s.recorder.NewBatch(1)
+	for _, blobInfo := range due {
+		// Blobs of paused accounts stay queued until they are resumed
+		if pauses.covers(blobInfo.StorageAccount, blobInfo.Container) {
+			continue
+		}
+		if err := s.processBlob(ctx, batch, blobInfo, false); err != nil {
+			log.Printf("Error retrying blob %s: %v", blobInfo.FullPath, err)
+			s.recordSyncError(blobInfo.FullPath, err)
+			continue
+		}
+		if err := s.store.ClearSyncError(blobInfo.FullPath); err != nil {
+			log.Printf("Error clearing sync error of %s: %v", blobInfo.FullPath, err)
+		}
+	}
+	if err := batch.Flush(ctx); err != nil {
+		log.Printf("Error writing batch: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package syncer
+
+import (
+	"context"
+	"log"
+
+	"github.com/toggle-vault/internal/blob"
+)
+
+// listBlobs lists the blobs in the configured containers plus those selected
+// by tracking rules. Blobs covered by both are listed once. The storage
+// accounts and containers that failed to list are returned too, so that
+// their files aren't taken for deleted.
+func (s *Syncer) listBlobs(ctx context.Context) ([]blob.BlobInfo, map[string]bool, error) {
+	pauses, err := s.loadPauses()
+	if err != nil {
+		return nil, nil, err
+	}
+	listings := s.blobClient.ListBlobsByAccount(ctx, s.config.TrackedPatterns(), pauses.covers)
+	s.recordListing(listings)
+
+	var blobs []blob.BlobInfo
+	// Paused storage accounts and containers aren't listed either
+	unlisted := make(map[string]bool, len(pauses))
+	for key := range pauses {
+		unlisted[key] = true
+	}
+	for _, listing := range listings {
+		if listing.Paused {
+			continue
+		}
+		if listing.Err != nil {
+			// Log error but continue with other accounts
+			log.Printf("Warning: failed to list blobs in storage account %s: %v", listing.StorageAccount, listing.Err)
+			unlisted[listing.StorageAccount] = true
+			continue
+		}
+		// The files of a container that failed to list aren't deleted
+		for container := range listing.FailedContainers {
+			unlisted[listing.StorageAccount+"/"+container] = true
+		}
+		blobs = append(blobs, listing.Blobs...)
+	}
+
+	rules, err := s.store.ListTrackingRules()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rules) == 0 {
+		return blobs, unlisted, nil
+	}
+
+	listed := make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		listed[b.FullPath] = true
+	}
+
+	for _, rule := range rules {
+		if pauses.covers(rule.StorageAccount, rule.Container) {
+			continue
+		}
+		patterns := rule.Patterns
+		if len(patterns) == 0 {
+			patterns = s.config.TrackedPatterns()
+		}
+
+		ruleBlobs, err := s.blobClient.ListBlobsWithPrefix(ctx, rule.StorageAccount, rule.Container, rule.Prefix, patterns)
+		if err != nil {
+			// Log error but continue with other rules
+			log.Printf("Warning: failed to list blobs for tracking rule %d: %v", rule.ID, err)
+			unlisted[rule.StorageAccount+"/"+rule.Container] = true
+			continue
+		}
+
+		for _, b := range ruleBlobs {
+			if !listed[b.FullPath] {
+				listed[b.FullPath] = true
+				blobs = append(blobs, b)
+			}
+		}
+	}
+
+	return blobs, unlisted, nil
+}
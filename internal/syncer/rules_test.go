@@ -0,0 +1,54 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/store"
+)
+
+func TestFailedRuleListingKeepsFiles(t *testing.T) {
+	st := newTestStore(t)
+
+	// No account is registered yet, so listing the rule's container fails
+	client, err := blob.NewClient(config.AzureConfig{Discovery: []config.DiscoveryConfig{{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(client, st, config.SyncConfig{}, nil, nil)
+
+	if _, err := s.recorder.RecordCapture(context.Background(), nil, Capture{
+		BlobPath: "account/configs/app.yaml",
+		Content:  []byte("key: value\n"),
+		ETag:     "etag-1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateTrackingRule(&store.TrackingRule{StorageAccount: "account", Container: "configs"}); err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, unlisted, err := s.listBlobs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 0 {
+		t.Fatalf("listed %d blobs, want none", len(blobs))
+	}
+	if !unlisted["account/configs"] {
+		t.Errorf("unlisted = %v, want account/configs", unlisted)
+	}
+
+	if err := s.checkDeleted(context.Background(), map[string]bool{}, unlisted); err != nil {
+		t.Fatal(err)
+	}
+	file, err := st.GetFile("account/configs/app.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.IsDeleted {
+		t.Error("file of a container whose rule failed to list was marked deleted")
+	}
+}
@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"time"
+)
+
+// Sync phases
+const (
+	// PhaseBackfill is the first cycle after startup, which picks up every
+	// blob that isn't tracked yet
+	PhaseBackfill = "backfill"
+	// PhaseSync is a regular incremental cycle
+	PhaseSync = "sync"
+)
+
+// Status describes the progress of the sync loop
+type Status struct {
+	// Phase is the phase of the current (or last) cycle
+	Phase string `json:"phase"`
+	// Running is true while a sync cycle is in progress
+	Running bool `json:"running"`
+	// Paused is true while sync cycles are skipped
+	Paused bool `json:"paused"`
+	// CycleStartedAt is when the current (or last) cycle started
+	CycleStartedAt *time.Time `json:"cycle_started_at,omitempty"`
+	// LastCompletedAt is when the last cycle finished
+	LastCompletedAt *time.Time `json:"last_completed_at,omitempty"`
+	// LastDuration is how long the last completed cycle took
+	LastDuration string `json:"last_duration,omitempty"`
+	// BlobsListed is the number of blobs found by the current (or last) cycle
+	BlobsListed int `json:"blobs_listed"`
+	// Processed is the number of listed blobs processed so far
+	Processed int `json:"processed"`
+	// QueueDepth is the number of listed blobs still waiting to be processed
+	QueueDepth int `json:"queue_depth"`
+	// ETA estimates when the running cycle will finish, from its rate so far
+	ETA *time.Time `json:"eta,omitempty"`
+	// LastError is the error that aborted the last cycle, if any
+	LastError string `json:"last_error,omitempty"`
+	// RetryQueue is the number of blobs waiting to be retried after their
+	// download failed
+	RetryQueue int `json:"retry_queue"`
+	// Accounts is the health of each storage account
+	Accounts []AccountHealth `json:"accounts"`
+	// Storage is the content stored for versions, if quotas are set
+	Storage *StorageStatus `json:"storage,omitempty"`
+}
+
+// Status returns a snapshot of the sync loop's progress
+func (s *Syncer) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	if status.Running && status.Processed > 0 && status.QueueDepth > 0 && status.CycleStartedAt != nil {
+		elapsed := s.clock.Now().Sub(*status.CycleStartedAt)
+		perBlob := elapsed / time.Duration(status.Processed)
+		eta := s.clock.Now().Add(perBlob * time.Duration(status.QueueDepth))
+		status.ETA = &eta
+	}
+	status.Paused = s.paused
+	status.RetryQueue = len(s.retries)
+	status.Accounts = s.accountHealth()
+	return status
+}
+
+// beginCycle records the start of a sync cycle
+func (s *Syncer) beginCycle(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.status.Phase = phase
+	s.status.Running = true
+	s.status.CycleStartedAt = &now
+	s.status.BlobsListed = 0
+	s.status.Processed = 0
+	s.status.QueueDepth = 0
+	s.status.LastError = ""
+}
+
+// setProgress records how many of the listed blobs have been processed
+func (s *Syncer) setProgress(listed, processed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.BlobsListed = listed
+	s.status.Processed = processed
+	s.status.QueueDepth = listed - processed
+}
+
+// endCycle records the end of a sync cycle
+func (s *Syncer) endCycle(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.status.Running = false
+	s.status.QueueDepth = 0
+	if err != nil {
+		s.status.LastError = err.Error()
+		return
+	}
+	s.status.LastCompletedAt = &now
+	if s.status.CycleStartedAt != nil {
+		s.status.LastDuration = now.Sub(*s.status.CycleStartedAt).Round(time.Millisecond).String()
+	}
+}
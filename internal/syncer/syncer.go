@@ -2,11 +2,17 @@ package syncer
 
 import (
 	"context"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/clock"
 	"github.com/toggle-vault/internal/config"
+	"github.com/toggle-vault/internal/encryption"
+	"github.com/toggle-vault/internal/events"
+	"github.com/toggle-vault/internal/hooks"
 	"github.com/toggle-vault/internal/store"
 )
 
@@ -14,88 +20,315 @@ import (
 type Syncer struct {
 	blobClient *blob.Client
 	store      store.Store
+	recorder   *Recorder
 	config     config.SyncConfig
+	events     *events.Broker
+	// clock is the time source of sync cycles, retries and retention
+	clock clock.Clock
+	// decrypter decrypts encrypted files, if a key is configured
+	decrypter *encryption.Decrypter
+	// health tracks the listing of each storage account, guarded by mu
+	health map[string]*AccountHealth
+	// retries are the blobs whose download failed, by path, guarded by mu
+	retries map[string]*retryItem
+
+	// backfilled is set once the first cycle has completed
+	backfilled bool
+	// lastPrune is when versions past their retention were last deleted
+	lastPrune time.Time
+
+	mu     sync.Mutex
+	status Status
+	// paused skips sync cycles and retries, guarded by mu
+	paused bool
+	// discoveryPending is set until storage account discovery has
+	// succeeded, guarded by mu
+	discoveryPending bool
+	// immutable are the read-only containers, keyed by account/container,
+	// with the reason, guarded by mu
+	immutable map[string]string
+
+	// fetchMu serializes lazy content fetches
+	fetchMu sync.Mutex
+	// cycleMu serializes sync cycles and retries, which SyncNow can start
+	// besides the sync loop
+	cycleMu sync.Mutex
+	// inflight locks the paths of the blobs being processed
+	inflight inflight
 }
 
-// New creates a new Syncer instance
-func New(blobClient *blob.Client, store store.Store, cfg config.SyncConfig) *Syncer {
-	return &Syncer{
+// backfillLogInterval is how many blobs are processed between progress logs
+// during the backfill
+const backfillLogInterval = 1000
+
+// New creates a new Syncer instance. The broker and hook registry may be nil
+// if no one is interested in change events or no hooks are configured.
+func New(blobClient *blob.Client, store store.Store, cfg config.SyncConfig, broker *events.Broker, registry *hooks.Registry) *Syncer {
+	recorder := NewRecorder(store, registry)
+	recorder.groups = cfg.PatternGroups
+	recorder.ignoreSOPSMetadata = cfg.IgnoreSOPSMetadata
+
+	s := &Syncer{
 		blobClient: blobClient,
 		store:      store,
+		recorder:   recorder,
 		config:     cfg,
+		events:     broker,
+		clock:      clock.Real,
 	}
+	recorder.deleteSnapshot = s.deleteSnapshot
+	return s
+}
+
+// SetClock replaces the system clock, e.g. with a clock.Fake to simulate
+// sync cycles and retention expiry in tests
+func (s *Syncer) SetClock(c clock.Clock) {
+	s.clock = c
+	s.recorder.clock = c
+}
+
+// SetDecrypter sets the decrypter for encrypted files. Without one their
+// versions are recorded by hash only, except SOPS files when
+// sync.ignore_sops_metadata is set.
+func (s *Syncer) SetDecrypter(d *encryption.Decrypter) {
+	s.decrypter = d
+	s.recorder.decrypter = d
+}
+
+// SetNotified tells the syncer which changes were notified outside the vault,
+// so that coalescing doesn't delete versions that notifications link to
+func (s *Syncer) SetNotified(notified func(store.ChangeEvent) bool) {
+	s.recorder.notified = notified
 }
 
 // Start begins the sync loop
 func (s *Syncer) Start(ctx context.Context) {
+	s.detectLanguages()
+	s.loadBackfillState()
+
 	// Run initial sync immediately
 	s.sync(ctx)
 
-	ticker := time.NewTicker(s.config.Interval)
+	ticker := s.clock.NewTicker(s.config.Interval)
 	defer ticker.Stop()
+	retryTicker := s.clock.NewTicker(s.config.RetryInterval)
+	defer retryTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Syncer stopping...")
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			s.sync(ctx)
+		case <-retryTicker.C():
+			s.retryFailed(ctx)
 		}
 	}
 }
 
+// backfillCompletedKey is the sync state key recording when the backfill
+// completed, so that it isn't run again after a restart
+const backfillCompletedKey = "backfill_completed_at"
+
+// loadBackfillState skips the backfill if one completed before a restart
+func (s *Syncer) loadBackfillState() {
+	completed, err := s.store.GetSyncState(backfillCompletedKey)
+	if err != nil {
+		log.Printf("Error reading backfill state: %v", err)
+		return
+	}
+	if completed != "" {
+		log.Printf("Backfill completed at %s; starting with a sync cycle", completed)
+		s.backfilled = true
+	}
+}
+
 // sync performs a single sync cycle
 func (s *Syncer) sync(ctx context.Context) {
-	log.Println("Starting sync cycle...")
+	if s.Paused() {
+		log.Println("Syncing is paused, skipping cycle")
+		return
+	}
+	s.cycleMu.Lock()
+	defer s.cycleMu.Unlock()
+
+	phase := PhaseSync
+	if !s.backfilled {
+		phase = PhaseBackfill
+	}
+	metadataOnly := phase == PhaseBackfill && s.config.BackfillMetadataOnly
+
+	log.Printf("Starting %s cycle...", phase)
+	s.beginCycle(phase)
 
-	// List all blobs matching our patterns
-	blobs, err := s.blobClient.ListBlobs(ctx, s.config.Patterns)
+	// List all blobs matching our patterns and tracking rules
+	blobs, unlisted, err := s.listBlobs(ctx)
 	if err != nil {
 		log.Printf("Error listing blobs: %v", err)
+		s.endCycle(err)
 		return
 	}
 
 	log.Printf("Found %d blobs matching patterns", len(blobs))
+	s.refreshImmutability(ctx, blobs)
 
 	// Track which blob paths we've seen (for detecting deletions)
 	// Use FullPath (container/path) for unique identification
 	seenPaths := make(map[string]bool)
 
+	// The backfill records every file, so its writes are batched. Later
+	// cycles write each change as it is found, to publish it straight away.
+	batch := s.recorder.NewBatch(1)
+	if phase == PhaseBackfill {
+		batch = s.recorder.NewBatch(s.config.WriteBatchSize)
+	}
+
+	// Blobs that failed before have their errors cleared once they sync
+	failing := s.failingBlobs()
+
 	// Process each blob
-	for _, blobInfo := range blobs {
+	for i, blobInfo := range blobs {
 		seenPaths[blobInfo.FullPath] = true
 
-		if err := s.processBlob(ctx, blobInfo); err != nil {
+		if err := s.processBlob(ctx, batch, blobInfo, metadataOnly); err != nil {
 			log.Printf("Error processing blob %s: %v", blobInfo.FullPath, err)
+			s.recordSyncError(blobInfo.FullPath, err)
+			delete(failing, blobInfo.FullPath)
+		}
+
+		s.setProgress(len(blobs), i+1)
+		if phase == PhaseBackfill && (i+1)%backfillLogInterval == 0 {
+			log.Printf("Backfill progress: %d/%d blobs", i+1, len(blobs))
 		}
 	}
+	if err := batch.Flush(ctx); err != nil {
+		log.Printf("Error writing batch: %v", err)
+	} else {
+		s.clearSyncErrors(failing, seenPaths, unlisted)
+	}
 
 	// Check for deleted files
-	if err := s.checkDeleted(ctx, seenPaths); err != nil {
+	if err := s.checkDeleted(ctx, seenPaths, unlisted); err != nil {
 		log.Printf("Error checking for deleted files: %v", err)
 	}
 
+	if !s.config.DryRun && (s.config.PatternGroups.HasRetention() || s.config.HasQuota()) &&
+		s.clock.Now().Sub(s.lastPrune) >= pruneInterval {
+		if s.config.PatternGroups.HasRetention() {
+			if err := s.pruneVersions(); err != nil {
+				log.Printf("Error deleting versions past their retention: %v", err)
+			}
+		}
+		if s.config.HasQuota() {
+			if err := s.enforceQuotas(); err != nil {
+				log.Printf("Error enforcing storage quotas: %v", err)
+			}
+		}
+		s.lastPrune = s.clock.Now()
+	}
+
+	s.endCycle(nil)
+	// A dry run's backfill recorded nothing, so the real one is still to come
+	if !s.backfilled && !s.config.DryRun {
+		if err := s.store.SetSyncState(backfillCompletedKey, s.clock.Now().UTC().Format(time.RFC3339)); err != nil {
+			log.Printf("Error recording that the backfill completed: %v", err)
+		}
+	}
+	s.backfilled = true
+	s.events.Publish(events.Event{Type: events.EventSyncComplete})
+
 	log.Println("Sync cycle complete")
 }
 
-// processBlob handles a single blob, detecting if it's new or modified
-func (s *Syncer) processBlob(ctx context.Context, blobInfo blob.BlobInfo) error {
+// publishChange notifies subscribers that a new version was recorded
+func (s *Syncer) publishChange(blobPath string, version *store.Version) {
+	s.events.Publish(events.Event{
+		Type: events.EventChange,
+		Data: store.ChangeEvent{
+			VersionID:   version.ID,
+			FileID:      version.FileID,
+			BlobPath:    blobPath,
+			ChangeType:  version.ChangeType,
+			ContentHash: version.ContentHash,
+			CapturedAt:  version.CapturedAt,
+		},
+	})
+}
+
+// rejectCapture cleans up after a hook rejected captured content: the
+// snapshot taken of it is deleted, since no version refers to it, and
+// subscribers are notified
+func (s *Syncer) rejectCapture(ctx context.Context, c Capture, err error) {
+	var rejected *hooks.RejectedError
+	if !errors.As(err, &rejected) {
+		return
+	}
+
+	if c.SnapshotID != "" {
+		s.deleteSnapshot(ctx, c.BlobPath, c.SnapshotID)
+	}
+
+	s.events.Publish(events.Event{
+		Type: events.EventValidationFailed,
+		Data: events.ValidationFailure{
+			BlobPath:    c.BlobPath,
+			ContentHash: c.ContentHash,
+			Hook:        rejected.Hook,
+			Error:       rejected.Err.Error(),
+			DetectedAt:  s.clock.Now(),
+		},
+	})
+}
+
+// deleteSnapshot deletes a blob snapshot taken for content that wasn't
+// recorded
+func (s *Syncer) deleteSnapshot(ctx context.Context, blobPath, snapshotID string) {
+	if err := s.blobClient.DeleteSnapshot(ctx, blobPath, snapshotID); err != nil {
+		log.Printf("Error deleting snapshot %s of %s: %v", snapshotID, blobPath, err)
+	}
+}
+
+// processBlob handles a single blob, detecting if it's new or modified. With
+// metadataOnly set, new files are tracked without downloading their content.
+// Changes are recorded through batch.
+func (s *Syncer) processBlob(ctx context.Context, batch *Batch, blobInfo blob.BlobInfo, metadataOnly bool) error {
+	// A batched write may only be made after the lock is released. The store
+	// skips it if another entry point recorded the capture, or newer content,
+	// in the meantime.
+	defer s.inflight.lock(blobInfo.FullPath)()
+
 	// Check if we already have this file in the database (using FullPath)
 	existingFile, err := s.store.GetFile(blobInfo.FullPath)
 	if err != nil {
 		return err
 	}
 
+	// Archived files are no longer synced
+	if existingFile != nil && existingFile.IsArchived {
+		return nil
+	}
+
+	if s.config.DryRun {
+		return s.shadowBlob(ctx, blobInfo, existingFile)
+	}
+
+	if s.metadataOnly(blobInfo.FullPath) {
+		return s.trackMetadataChange(ctx, batch, blobInfo, existingFile)
+	}
+
 	// New file
 	if existingFile == nil {
-		return s.handleNewFile(ctx, blobInfo)
+		if metadataOnly {
+			return s.trackMetadata(ctx, batch, blobInfo, nil)
+		}
+		return s.handleNewFile(ctx, batch, blobInfo, nil)
 	}
 
 	// File was previously deleted but now exists again
 	if existingFile.IsDeleted {
 		log.Printf("File %s was deleted but now exists again", blobInfo.FullPath)
-		return s.handleNewFile(ctx, blobInfo)
+		return s.handleNewFile(ctx, batch, blobInfo, existingFile)
 	}
 
 	// Check if ETag changed (quick check before downloading)
@@ -105,153 +338,175 @@ func (s *Syncer) processBlob(ctx context.Context, blobInfo blob.BlobInfo) error
 	}
 
 	// ETag changed, need to download and check content
-	return s.handleModifiedFile(ctx, blobInfo, existingFile)
+	return s.handleModifiedFile(ctx, batch, blobInfo, existingFile)
 }
 
-// handleNewFile processes a newly discovered file
-func (s *Syncer) handleNewFile(ctx context.Context, blobInfo blob.BlobInfo) error {
+// handleNewFile processes a newly discovered file. deletedFile is the record of
+// a previously deleted file at the same path, if any.
+func (s *Syncer) handleNewFile(ctx context.Context, batch *Batch, blobInfo blob.BlobInfo, deletedFile *store.File) error {
 	log.Printf("New file detected: %s", blobInfo.FullPath)
 
-	// Download the content
-	blobContent, err := s.blobClient.GetBlob(ctx, blobInfo.StorageAccount, blobInfo.Container, blobInfo.Path)
-	if err != nil {
-		return err
-	}
-
-	// Create the file record using FullPath for unique identification
-	file := &store.File{
-		BlobPath:     blobInfo.FullPath,
-		ETag:         blobContent.ETag,
-		ContentHash:  blobContent.ContentHash,
-		LastModified: blobContent.LastModified,
-		IsDeleted:    false,
+	// Download the content, unless the file is captured lazily from its listing
+	c, listed := s.captureFromListing(ctx, blobInfo, deletedFile)
+	if !listed {
+		blobContent, err := s.download(ctx, blobInfo)
+		if err != nil {
+			return err
+		}
+		c = s.capture(ctx, blobContent, deletedFile)
 	}
 
-	if err := s.store.UpsertFile(file); err != nil {
-		return err
-	}
+	err := batch.Add(ctx, deletedFile, c, func(version *store.Version) {
+		if version == nil {
+			return
+		}
 
-	// Create the initial version
-	version := &store.Version{
-		FileID:           file.ID,
-		Content:          string(blobContent.Content),
-		ContentHash:      blobContent.ContentHash,
-		ChangeType:       store.ChangeTypeCreated,
-		CapturedAt:       time.Now(),
-		BlobETag:         blobContent.ETag,
-		BlobLastModified: blobContent.LastModified,
-	}
+		s.publishChange(blobInfo.FullPath, version)
 
-	if err := s.store.CreateVersion(version); err != nil {
-		return err
+		if version.ChangeType == store.ChangeTypeRecreated {
+			log.Printf("Recorded recreated file: %s (version %d, after deletion %d)", blobInfo.FullPath, version.ID, version.DeletedVersionID)
+			return
+		}
+		log.Printf("Recorded new file: %s (version %d)", blobInfo.FullPath, version.ID)
+	})
+	if err != nil {
+		s.rejectCapture(ctx, c, err)
 	}
-
-	log.Printf("Recorded new file: %s (version %d)", blobInfo.FullPath, version.ID)
-	return nil
+	return err
 }
 
 // handleModifiedFile processes a file that may have been modified
-func (s *Syncer) handleModifiedFile(ctx context.Context, blobInfo blob.BlobInfo, existingFile *store.File) error {
-	// Download the content to check if it actually changed
-	blobContent, err := s.blobClient.GetBlob(ctx, blobInfo.StorageAccount, blobInfo.Container, blobInfo.Path)
-	if err != nil {
-		return err
+func (s *Syncer) handleModifiedFile(ctx context.Context, batch *Batch, blobInfo blob.BlobInfo, existingFile *store.File) error {
+	// Download the content to check if it actually changed, unless the file
+	// is captured lazily from its listing
+	c, listed := s.captureFromListing(ctx, blobInfo, existingFile)
+	if !listed {
+		blobContent, err := s.download(ctx, blobInfo)
+		if err != nil {
+			return err
+		}
+		c = s.capture(ctx, blobContent, existingFile)
 	}
 
-	// Check if content actually changed (ETag might change without content changing)
-	if blobContent.ContentHash == existingFile.ContentHash {
-		// Content same, just update ETag
-		existingFile.ETag = blobContent.ETag
-		existingFile.LastModified = blobContent.LastModified
-		return s.store.UpsertFile(existingFile)
-	}
+	err := batch.Add(ctx, existingFile, c, func(version *store.Version) {
+		if version == nil {
+			return
+		}
 
-	log.Printf("File modified: %s", blobInfo.FullPath)
+		log.Printf("File modified: %s", blobInfo.FullPath)
 
-	// Content changed, record new version
-	version := &store.Version{
-		FileID:           existingFile.ID,
-		Content:          string(blobContent.Content),
-		ContentHash:      blobContent.ContentHash,
-		ChangeType:       store.ChangeTypeModified,
-		CapturedAt:       time.Now(),
-		BlobETag:         blobContent.ETag,
-		BlobLastModified: blobContent.LastModified,
-	}
+		s.publishChange(blobInfo.FullPath, version)
 
-	if err := s.store.CreateVersion(version); err != nil {
-		return err
-	}
-
-	// Update file record
-	existingFile.ETag = blobContent.ETag
-	existingFile.ContentHash = blobContent.ContentHash
-	existingFile.LastModified = blobContent.LastModified
-
-	if err := s.store.UpsertFile(existingFile); err != nil {
-		return err
+		log.Printf("Recorded modified file: %s (version %d)", blobInfo.FullPath, version.ID)
+	})
+	if err != nil {
+		s.rejectCapture(ctx, c, err)
 	}
-
-	log.Printf("Recorded modified file: %s (version %d)", blobInfo.FullPath, version.ID)
-	return nil
+	return err
 }
 
-// checkDeleted looks for files that are in our database but no longer in blob storage
-func (s *Syncer) checkDeleted(ctx context.Context, seenPaths map[string]bool) error {
+// checkDeleted looks for files that are in our database but no longer in blob
+// storage. Files of the unlisted storage accounts, and containers keyed by
+// account/container, are skipped, as are those of unregistered accounts while
+// discovery is pending.
+func (s *Syncer) checkDeleted(ctx context.Context, seenPaths map[string]bool, unlisted map[string]bool) error {
 	files, err := s.store.ListFiles()
 	if err != nil {
 		return err
 	}
+	pending := s.DiscoveryPending()
 
 	for _, file := range files {
-		// Skip already deleted files
-		if file.IsDeleted {
+		// Skip already deleted and archived files
+		if file.IsDeleted || file.IsArchived {
+			continue
+		}
+
+		// Files of storage accounts and containers that failed to list
+		// weren't seen either, nor those of accounts discovery may not have
+		// registered yet
+		if storageAccount, container, _, err := blob.ParseFullPath(file.BlobPath); err == nil &&
+			(unlisted[storageAccount] || unlisted[storageAccount+"/"+container] ||
+				(pending && !s.blobClient.HasStorageAccount(storageAccount))) {
 			continue
 		}
 
 		// If we didn't see this path in the current blob listing, it was deleted
 		if !seenPaths[file.BlobPath] {
-			log.Printf("File deleted: %s", file.BlobPath)
-
-			// Get the last version to record in the delete version
-			lastVersion, err := s.store.GetLatestVersion(file.ID)
-			if err != nil {
-				log.Printf("Error getting latest version for deleted file %s: %v", file.BlobPath, err)
+			if s.config.DryRun {
+				if err := s.shadowDeletion(&file.File); err != nil {
+					log.Printf("Error recording dry-run deletion of %s: %v", file.BlobPath, err)
+				}
 				continue
 			}
 
-			// Record deletion version
-			version := &store.Version{
-				FileID:      file.ID,
-				Content:     "", // Empty content for deleted files
-				ContentHash: "",
-				ChangeType:  store.ChangeTypeDeleted,
-				CapturedAt:  time.Now(),
-			}
+			s.recordDeletion(ctx, &file.File)
+		}
+	}
 
-			// Preserve the last known content hash
-			if lastVersion != nil {
-				version.ContentHash = lastVersion.ContentHash
-			}
+	return nil
+}
 
-			if err := s.store.CreateVersion(version); err != nil {
-				log.Printf("Error creating delete version for %s: %v", file.BlobPath, err)
-				continue
-			}
+// recordDeletion records the deletion of a file missing from the blob
+// listing, unless another entry point recorded it first
+func (s *Syncer) recordDeletion(ctx context.Context, file *store.File) {
+	current, unlock, err := s.lockFile(file)
+	if err != nil {
+		log.Printf("Error recording deletion of %s: %v", file.BlobPath, err)
+		return
+	}
+	defer unlock()
+	if current == nil || current.IsDeleted || current.IsArchived {
+		return
+	}
 
-			// Mark file as deleted
-			if err := s.store.MarkFileDeleted(file.BlobPath); err != nil {
-				log.Printf("Error marking file as deleted %s: %v", file.BlobPath, err)
-			}
+	log.Printf("File deleted: %s", file.BlobPath)
 
-			log.Printf("Recorded deleted file: %s (version %d)", file.BlobPath, version.ID)
+	version, err := s.recorder.RecordDeletion(ctx, current)
+	if err != nil {
+		log.Printf("Error recording deletion of %s: %v", file.BlobPath, err)
+		if version == nil {
+			return
 		}
 	}
 
-	return nil
+	s.publishChange(file.BlobPath, version)
+
+	log.Printf("Recorded deleted file: %s (version %d)", file.BlobPath, version.ID)
 }
 
 // SyncNow triggers an immediate sync (useful for testing or manual refresh)
 func (s *Syncer) SyncNow(ctx context.Context) {
 	s.sync(ctx)
 }
+
+// SetPaused pauses or resumes syncing. While paused, sync cycles and retries
+// are skipped; a cycle already running finishes.
+func (s *Syncer) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+// Paused reports whether syncing is paused
+func (s *Syncer) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// SetDiscoveryPending tells the syncer whether storage account discovery has
+// yet to succeed. Discovered accounts are only registered in memory, so while
+// it is pending the files of unregistered accounts aren't taken for deleted.
+func (s *Syncer) SetDiscoveryPending(pending bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discoveryPending = pending
+}
+
+// DiscoveryPending reports whether storage account discovery has yet to succeed
+func (s *Syncer) DiscoveryPending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.discoveryPending
+}
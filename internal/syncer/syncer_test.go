@@ -0,0 +1,51 @@
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+)
+
+func TestPendingDiscoveryKeepsFiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		pending     bool
+		wantDeleted bool
+	}{
+		{name: "discovery pending", pending: true, wantDeleted: false},
+		{name: "discovery succeeded", pending: false, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newTestStore(t)
+			client, err := blob.NewClient(config.AzureConfig{Discovery: []config.DiscoveryConfig{{}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := New(client, st, config.SyncConfig{}, nil, nil)
+			s.SetDiscoveryPending(tt.pending)
+
+			if _, err := s.recorder.RecordCapture(context.Background(), nil, Capture{
+				BlobPath: "discovered/configs/app.yaml",
+				Content:  []byte("key: value\n"),
+				ETag:     "etag-1",
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.checkDeleted(context.Background(), map[string]bool{}, map[string]bool{}); err != nil {
+				t.Fatal(err)
+			}
+			file, err := st.GetFile("discovered/configs/app.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file.IsDeleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", file.IsDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
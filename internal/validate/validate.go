@@ -0,0 +1,106 @@
+// Package validate checks configuration file content before it is written
+// to blob storage
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/toggle-vault/internal/diff"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue is a single validation problem
+type Issue struct {
+	// Line is the 1-based line of the problem, or 0 if unknown
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// yamlLinePattern extracts the line number from yaml.v3 error messages
+var yamlLinePattern = regexp.MustCompile(`line (\d+): (.*)`)
+
+// Check validates content according to the file's extension. YAML, JSON,
+// TOML, INI and XML files must parse; other files are not checked.
+func Check(path string, content []byte) []Issue {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return checkYAML(content)
+	case ".json":
+		return checkJSON(content)
+	case ".toml", ".ini", ".xml":
+		return checkKeys(path, content)
+	}
+	return nil
+}
+
+// checkYAML parses every document in content
+func checkYAML(content []byte) []Issue {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := dec.Decode(&node)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			issues := make([]Issue, 0, len(typeErr.Errors))
+			for _, msg := range typeErr.Errors {
+				issues = append(issues, yamlIssue(msg))
+			}
+			return issues
+		}
+		return []Issue{yamlIssue(err.Error())}
+	}
+}
+
+// yamlIssue converts a yaml.v3 error message into an Issue
+func yamlIssue(msg string) Issue {
+	msg = strings.TrimPrefix(msg, "yaml: ")
+	if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return Issue{Line: line, Message: m[2]}
+	}
+	return Issue{Message: msg}
+}
+
+// checkKeys parses content with the diff package's key-level parsers
+func checkKeys(path string, content []byte) []Issue {
+	_, err := diff.ParseKeys(path, string(content))
+	if err == nil {
+		return nil
+	}
+
+	var parseErr *diff.ParseError
+	if errors.As(err, &parseErr) {
+		return []Issue{{Line: parseErr.Line, Message: parseErr.Message}}
+	}
+	return []Issue{{Message: err.Error()}}
+}
+
+// checkJSON parses content as a single JSON value
+func checkJSON(content []byte) []Issue {
+	var v interface{}
+	err := json.Unmarshal(content, &v)
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := 1 + bytes.Count(content[:syntaxErr.Offset], []byte("\n"))
+		return []Issue{{Line: line, Message: syntaxErr.Error()}}
+	}
+	return []Issue{{Message: err.Error()}}
+}
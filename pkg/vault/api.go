@@ -0,0 +1,40 @@
+package vault
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/toggle-vault/internal/api"
+	"github.com/toggle-vault/internal/blob"
+	"github.com/toggle-vault/internal/config"
+)
+
+// BlobClient reads and writes blobs in one or more storage accounts
+type BlobClient = blob.Client
+
+// AzureConfig lists the storage accounts a BlobClient can access and how it
+// authenticates to them
+type AzureConfig = config.AzureConfig
+
+// StorageAccountConfig is a storage account in AzureConfig
+type StorageAccountConfig = config.StorageAccountConfig
+
+// AuthConfig holds the credentials for storage accounts
+type AuthConfig = config.AuthConfig
+
+// NewBlobClient creates a client for the storage accounts in cfg
+func NewBlobClient(cfg AzureConfig) (*BlobClient, error) {
+	return blob.NewClient(cfg)
+}
+
+// Routes returns toggle-vault's HTTP API over this Vault's database, for
+// mounting under a sub-path of an existing router:
+//
+//	r.With(myAuth).Mount("/toggle-vault", v.Routes(blobClient))
+//
+// Restores and edits made through the API are written to blob storage with
+// blobClient. No middleware is added, so authentication, logging and CORS
+// are up to the caller; callers are identified by the X-Toggle-Vault-User
+// header. Hooks added with AddHook don't run for versions recorded through
+// the API.
+func (v *Vault) Routes(blobClient *BlobClient) chi.Router {
+	return api.Routes(v.store, blobClient)
+}
@@ -0,0 +1,209 @@
+// Package vault exposes Toggle Vault's version tracking as an embeddable Go
+// library, so that other services can record and query the history of their
+// configuration files without running the HTTP server or the blob syncer.
+//
+// A minimal program looks like this:
+//
+//	v, err := vault.Open("./versions.db")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer v.Close()
+//
+//	// Record the current content of a file. Unchanged content is ignored.
+//	if _, err := v.TrackBlob("myaccount/toggles/app.yaml", content); err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	// Newest version first
+//	history, err := v.History("myaccount/toggles/app.yaml")
+//
+// Blob paths are free-form, but using the storageaccount/container/path
+// convention keeps them compatible with databases written by the server.
+//
+// Routes serves the server's HTTP API over a Vault, for mounting under an
+// existing router.
+package vault
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/toggle-vault/internal/diff"
+	"github.com/toggle-vault/internal/hooks"
+	"github.com/toggle-vault/internal/store"
+	"github.com/toggle-vault/internal/syncer"
+)
+
+// File is a tracked file
+type File = store.File
+
+// FileSummary is a tracked file with its version count and latest change
+type FileSummary = store.FileWithVersionCount
+
+// Version is a recorded version of a file
+type Version = store.Version
+
+// ChangeType is the kind of change a version records
+type ChangeType = store.ChangeType
+
+// DiffResult is a line-based comparison of two versions
+type DiffResult = diff.DiffResult
+
+// KeyChange is a setting changed between two versions, in DiffResult.Keys
+type KeyChange = diff.KeyChange
+
+// Hook is invoked before and after every version is stored
+type Hook = hooks.Hook
+
+// HookPayload is the version and path passed to hooks
+type HookPayload = hooks.Payload
+
+// Change types recorded on versions
+const (
+	ChangeTypeCreated    = store.ChangeTypeCreated
+	ChangeTypeModified   = store.ChangeTypeModified
+	ChangeTypeDeleted    = store.ChangeTypeDeleted
+	ChangeTypeRecreated  = store.ChangeTypeRecreated
+	ChangeTypeCheckpoint = store.ChangeTypeCheckpoint
+	ChangeTypeRestored   = store.ChangeTypeRestored
+	ChangeTypeRenamed    = store.ChangeTypeRenamed
+	ChangeTypeImported   = store.ChangeTypeImported
+)
+
+// RegisterChangeType adds a custom change type to record with
+// TrackOptions.ChangeType. Servers sharing the database list it in their
+// change_types setting.
+func RegisterChangeType(t ChangeType) {
+	store.RegisterChangeType(t)
+}
+
+// Vault records and queries the version history of files
+type Vault struct {
+	store    store.Store
+	hooks    *hooks.Registry
+	recorder *syncer.Recorder
+}
+
+// Open opens (creating if necessary) a version database at path.
+// The database format is shared with the toggle-vault server.
+func Open(path string) (*Vault, error) {
+	st, err := store.NewSQLiteStore(path)
+	if err != nil {
+		return nil, err
+	}
+	registry := hooks.Builtin()
+	return &Vault{
+		store:    st,
+		hooks:    registry,
+		recorder: syncer.NewRecorder(st, registry),
+	}, nil
+}
+
+// AddHook registers a hook that runs around every version recorded by this
+// Vault, in addition to any compiled-in hooks
+func (v *Vault) AddHook(h Hook) {
+	v.hooks.Add(h)
+}
+
+// Close closes the underlying database
+func (v *Vault) Close() error {
+	return v.store.Close()
+}
+
+// TrackOptions carries optional blob metadata stored alongside a version
+type TrackOptions struct {
+	ETag         string
+	LastModified time.Time
+	// ChangeType records the change as this type, such as ChangeTypeImported,
+	// instead of created or modified. Custom types must be registered first.
+	ChangeType ChangeType
+}
+
+// TrackBlob records content as the current state of blobPath. A new version
+// is created if the content differs from the last recorded version; otherwise
+// TrackBlob returns a nil version and no error.
+func (v *Vault) TrackBlob(blobPath string, content []byte) (*Version, error) {
+	return v.TrackBlobWithOptions(blobPath, content, TrackOptions{})
+}
+
+// TrackBlobWithOptions is like TrackBlob but also records blob metadata
+func (v *Vault) TrackBlobWithOptions(blobPath string, content []byte, opts TrackOptions) (*Version, error) {
+	if opts.ChangeType != "" && !opts.ChangeType.Known() {
+		return nil, fmt.Errorf("unknown change type %q", opts.ChangeType)
+	}
+
+	existing, err := v.store.GetFile(blobPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.recorder.RecordCapture(context.Background(), existing, syncer.Capture{
+		BlobPath:     blobPath,
+		Content:      content,
+		ETag:         opts.ETag,
+		LastModified: opts.LastModified,
+		ChangeType:   opts.ChangeType,
+	})
+}
+
+// TrackDeletion records that blobPath no longer exists. It returns a nil
+// version if the file is unknown or already deleted.
+func (v *Vault) TrackDeletion(blobPath string) (*Version, error) {
+	file, err := v.store.GetFile(blobPath)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || file.IsDeleted {
+		return nil, nil
+	}
+
+	return v.recorder.RecordDeletion(context.Background(), file)
+}
+
+// Files returns all tracked files
+func (v *Vault) Files() ([]FileSummary, error) {
+	return v.store.ListFiles()
+}
+
+// History returns all versions of blobPath, newest first
+func (v *Vault) History(blobPath string) ([]Version, error) {
+	return v.store.GetVersionsByFilePath(blobPath)
+}
+
+// Version returns a single version by ID, or nil if it does not exist
+func (v *Vault) Version(id int64) (*Version, error) {
+	return v.store.GetVersion(id)
+}
+
+// Diff compares two versions by ID
+func (v *Vault) Diff(oldID, newID int64) (*DiffResult, error) {
+	oldVersion, err := v.store.GetVersion(oldID)
+	if err != nil {
+		return nil, err
+	}
+	if oldVersion == nil {
+		return nil, fmt.Errorf("version %d not found", oldID)
+	}
+
+	newVersion, err := v.store.GetVersion(newID)
+	if err != nil {
+		return nil, err
+	}
+	if newVersion == nil {
+		return nil, fmt.Errorf("version %d not found", newID)
+	}
+
+	oldLabel := fmt.Sprintf("v%d", oldID)
+	newLabel := fmt.Sprintf("v%d", newID)
+
+	file, err := v.store.GetFileByID(newVersion.FileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || oldVersion.Truncated || newVersion.Truncated {
+		return diff.CompareVersions(oldVersion.Content, newVersion.Content, oldLabel, newLabel), nil
+	}
+	return diff.CompareFiles(file.BlobPath, oldVersion.Content, newVersion.Content, oldLabel, newLabel), nil
+}
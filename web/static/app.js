@@ -1,5 +1,8 @@
 // Toggle Vault - Web UI Application
 
+// Path the UI is served under, such as /toggle-vault behind a reverse proxy
+const BASE_PATH = new URL('.', document.currentScript.src).pathname.replace(/\/$/, '');
+
 class ToggleVault {
     constructor() {
         this.files = [];
@@ -10,30 +13,99 @@ class ToggleVault {
         this.diffMode = 'unified'; // 'unified' or 'split'
         this.compareMode = false; // Whether compare mode is active
         this.compareVersions = { from: null, to: null }; // Selected versions for comparison
+        this.searchResults = [];
+        this.activity = []; // Recent changes shown in the activity feed
+        this.changeStats = new Map(); // Diff stats of changes by version ID
+        this.eventSource = null;
+        this.user = ''; // Identity used for watches and the inbox
+        this.watches = [];
+        this.editor = null; // Edit session: { path, baseVersionId, original }
+        this.validateTimer = null;
+        this.editIssues = []; // Validation issues for the editor content
         
         this.initElements();
         this.initEventListeners();
-        this.loadFiles();
+        Promise.all([this.loadFiles(), this.loadChangeTypes()]).then(() => this.applySearchFromURL());
+        this.loadActivity();
+        this.connectEvents();
+        this.loadIdentity();
+        this.loadSyncStatus();
+        this.loadMaintenance();
+        this.loadProposalCount();
     }
     
     initElements() {
         // File tree
         this.fileTree = document.getElementById('file-tree');
         this.fileCount = document.getElementById('file-count');
+        this.fileStatusFilter = document.getElementById('file-status');
+        this.fileSort = document.getElementById('file-sort');
         this.searchInput = document.getElementById('search');
         this.refreshBtn = document.getElementById('refresh-btn');
+        this.liveStatus = document.getElementById('live-status');
+        this.syncProgress = document.getElementById('sync-progress');
+        this.staleBanner = document.getElementById('stale-banner');
+        this.maintenanceBanner = document.getElementById('maintenance-banner');
+        this.activityList = document.getElementById('activity-list');
+        
+        // Search filters
+        this.filterAccount = document.getElementById('filter-account');
+        this.filterContainer = document.getElementById('filter-container');
+        this.filterChangeType = document.getElementById('filter-change-type');
+        this.filterSince = document.getElementById('filter-since');
+        this.filterUntil = document.getElementById('filter-until');
+        this.filterLabels = document.getElementById('filter-labels');
+        this.searchBtn = document.getElementById('search-btn');
+        this.clearSearchBtn = document.getElementById('clear-search-btn');
         
         // Views
         this.welcomeView = document.getElementById('welcome-view');
         this.fileView = document.getElementById('file-view');
         this.diffView = document.getElementById('diff-view');
+        this.editorView = document.getElementById('editor-view');
+        this.searchView = document.getElementById('search-view');
+        this.appView = document.getElementById('app-view');
+        
+        // Application view elements
+        this.appsBtn = document.getElementById('apps-btn');
+        this.appTitle = document.getElementById('app-title');
+        this.appSelect = document.getElementById('app-select');
+        this.appDescription = document.getElementById('app-description');
+        this.appFiles = document.getElementById('app-files');
+        this.appActivity = document.getElementById('app-activity');
+        
+        // Search view elements
+        this.searchTitle = document.getElementById('search-title');
+        this.searchResultsList = document.getElementById('search-results');
+        this.copySearchLinkBtn = document.getElementById('copy-search-link');
         
         // File view elements
         this.filePath = document.getElementById('file-path');
         this.fileStatus = document.getElementById('file-status');
+        this.fileLabels = document.getElementById('file-labels');
+        this.fileOwners = document.getElementById('file-owners');
+        this.labelsBtn = document.getElementById('labels-btn');
         this.versionsList = document.getElementById('versions-list');
         this.versionDetail = document.getElementById('version-detail');
         
+        // Editor elements
+        this.editBtn = document.getElementById('edit-btn');
+        this.liveBtn = document.getElementById('live-btn');
+        this.undeleteBtn = document.getElementById('undelete-btn');
+        this.archiveBtn = document.getElementById('archive-btn');
+        this.syncFileBtn = document.getElementById('sync-file-btn');
+        this.evidenceBtn = document.getElementById('evidence-btn');
+        this.editorTitle = document.getElementById('editor-title');
+        this.editorStatus = document.getElementById('editor-status');
+        this.editorComment = document.getElementById('editor-comment');
+        this.editorGutter = document.getElementById('editor-gutter');
+        this.editorText = document.getElementById('editor-text');
+        this.editorIssues = document.getElementById('editor-issues');
+        this.editorDiff = document.getElementById('editor-diff');
+        this.editorPreviewBtn = document.getElementById('editor-preview');
+        this.editorSaveBtn = document.getElementById('editor-save');
+        this.editorCancelBtn = document.getElementById('editor-cancel');
+        
         // Diff view elements
         this.diffTitle = document.getElementById('diff-title');
         this.diffStats = document.getElementById('diff-stats');
@@ -50,16 +122,93 @@ class ToggleVault {
         this.runCompareBtn = document.getElementById('run-compare-btn');
         this.cancelCompareBtn = document.getElementById('cancel-compare-btn');
         
+        // Subscription elements
+        this.subscriptionsBtn = document.getElementById('subscriptions-btn');
+        this.subscriptionsModal = document.getElementById('subscriptions-modal');
+        this.subscriptionsList = document.getElementById('subscriptions-list');
+        this.subscriptionForm = document.getElementById('subscription-form');
+        this.subscriptionEmail = document.getElementById('subscription-email');
+        this.subscriptionPrefix = document.getElementById('subscription-prefix');
+        this.subscriptionMode = document.getElementById('subscription-mode');
+        this.subscriptionsCloseBtn = document.getElementById('subscriptions-close');
+        
+        // Tracking rule elements
+        this.rulesBtn = document.getElementById('rules-btn');
+        this.rulesModal = document.getElementById('rules-modal');
+        this.rulesList = document.getElementById('rules-list');
+        this.ruleForm = document.getElementById('rule-form');
+        this.ruleAccount = document.getElementById('rule-account');
+        this.ruleContainer = document.getElementById('rule-container');
+        this.rulePrefix = document.getElementById('rule-prefix');
+        this.rulePatterns = document.getElementById('rule-patterns');
+        this.rulesCloseBtn = document.getElementById('rules-close');
+        
+        // Deleted file elements
+        this.deletedBtn = document.getElementById('deleted-btn');
+        this.deletedModal = document.getElementById('deleted-modal');
+        this.deletedList = document.getElementById('deleted-list');
+        this.deletedCloseBtn = document.getElementById('deleted-close');
+        
+        // Watch and inbox elements
+        this.watchBtn = document.getElementById('watch-btn');
+        this.inboxBtn = document.getElementById('inbox-btn');
+        this.inboxCount = document.getElementById('inbox-count');
+        this.inboxModal = document.getElementById('inbox-modal');
+        this.inboxUser = document.getElementById('inbox-user');
+        this.inboxBody = document.getElementById('inbox-body');
+        this.inboxList = document.getElementById('inbox-list');
+        this.watchesList = document.getElementById('watches-list');
+        this.watchForm = document.getElementById('watch-form');
+        this.watchPrefix = document.getElementById('watch-prefix');
+        this.identityForm = document.getElementById('identity-form');
+        this.identityName = document.getElementById('identity-name');
+        this.inboxMarkReadBtn = document.getElementById('inbox-mark-read');
+        this.inboxCloseBtn = document.getElementById('inbox-close');
+        
+        // Approval elements
+        this.proposalsBtn = document.getElementById('proposals-btn');
+        this.proposalsCount = document.getElementById('proposals-count');
+        this.proposalsModal = document.getElementById('proposals-modal');
+        this.proposalsStatus = document.getElementById('proposals-status');
+        this.proposalsList = document.getElementById('proposals-list');
+        this.proposalDetail = document.getElementById('proposal-detail');
+        this.proposalsCloseBtn = document.getElementById('proposals-close');
+        
         // Modal elements
         this.restoreModal = document.getElementById('restore-modal');
         this.restoreMessage = document.getElementById('restore-message');
+        this.restoreComment = document.getElementById('restore-comment');
         this.restoreConfirmBtn = document.getElementById('restore-confirm');
         this.restoreCancelBtn = document.getElementById('restore-cancel');
+        this.mergeModal = document.getElementById('merge-modal');
+        this.mergeMessage = document.getElementById('merge-message');
+        this.mergeText = document.getElementById('merge-text');
+        this.mergeComment = document.getElementById('merge-comment');
+        this.mergeSaveBtn = document.getElementById('merge-save');
+        this.mergeCancelBtn = document.getElementById('merge-cancel');
     }
     
     initEventListeners() {
         // Search
         this.searchInput.addEventListener('input', () => this.filterFiles());
+        this.fileStatusFilter.addEventListener('change', () => this.renderFileTree());
+        this.fileSort.addEventListener('change', () => this.loadFiles());
+        this.searchInput.addEventListener('keydown', (e) => {
+            if (e.key === 'Enter') this.runSearch();
+        });
+        this.searchBtn.addEventListener('click', () => this.runSearch());
+        this.clearSearchBtn.addEventListener('click', () => this.clearSearch());
+        this.filterAccount.addEventListener('change', () => this.updateContainerOptions());
+        this.filterLabels.addEventListener('input', () => this.renderFileTree());
+        this.filterLabels.addEventListener('keydown', (e) => {
+            if (e.key === 'Enter') this.runSearch();
+        });
+        this.copySearchLinkBtn.addEventListener('click', () => this.copySearchLink());
+        window.addEventListener('popstate', () => this.applySearchFromURL());
+        
+        // Applications
+        this.appsBtn.addEventListener('click', () => this.openApp(this.appSelect.value));
+        this.appSelect.addEventListener('change', () => this.openApp(this.appSelect.value));
         
         // Refresh
         this.refreshBtn.addEventListener('click', () => this.loadFiles());
@@ -73,6 +222,83 @@ class ToggleVault {
         
         // Modal
         this.restoreCancelBtn.addEventListener('click', () => this.closeRestoreModal());
+        this.mergeCancelBtn.addEventListener('click', () => this.mergeModal.style.display = 'none');
+        
+        // Subscriptions
+        this.subscriptionsBtn.addEventListener('click', () => this.openSubscriptions());
+        this.subscriptionsCloseBtn.addEventListener('click', () => {
+            this.subscriptionsModal.style.display = 'none';
+        });
+        this.subscriptionForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.createSubscription();
+        });
+        
+        // Tracking rules
+        this.rulesBtn.addEventListener('click', () => this.openRules());
+        this.rulesCloseBtn.addEventListener('click', () => {
+            this.rulesModal.style.display = 'none';
+        });
+        this.ruleForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.createRule();
+        });
+        
+        // Deleted files
+        this.deletedBtn.addEventListener('click', () => this.openDeleted());
+        this.deletedCloseBtn.addEventListener('click', () => {
+            this.deletedModal.style.display = 'none';
+        });
+        
+        // Watches and inbox
+        this.watchBtn.addEventListener('click', () => this.toggleWatch());
+        this.inboxBtn.addEventListener('click', () => this.openInbox());
+        this.inboxCloseBtn.addEventListener('click', () => {
+            this.inboxModal.style.display = 'none';
+        });
+        this.inboxMarkReadBtn.addEventListener('click', () => this.markInboxRead());
+        this.watchForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.createWatch(this.watchPrefix.value, false);
+        });
+        this.identityForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            localStorage.setItem('toggleVault.apiKey', this.identityName.value.trim());
+            this.loadIdentity().then(() => this.openInbox());
+        });
+        
+        // Approvals
+        this.proposalsBtn.addEventListener('click', () => this.openProposals());
+        this.proposalsStatus.addEventListener('change', () => this.loadProposals());
+        this.proposalsCloseBtn.addEventListener('click', () => {
+            this.proposalsModal.style.display = 'none';
+        });
+        
+        // Editor
+        this.editBtn.addEventListener('click', () => this.openEditor());
+        this.liveBtn.addEventListener('click', () => this.openLiveFile());
+        this.labelsBtn.addEventListener('click', () => this.editLabels());
+        this.undeleteBtn.addEventListener('click', () => this.undeleteFile(this.selectedFile.blob_path));
+        this.archiveBtn.addEventListener('click', () => this.toggleArchived(this.selectedFile));
+        this.syncFileBtn.addEventListener('click', () => this.syncFile(this.selectedFile));
+        this.evidenceBtn.addEventListener('click', () => this.downloadEvidence());
+        this.editorCancelBtn.addEventListener('click', () => this.closeEditor());
+        this.editorPreviewBtn.addEventListener('click', () => this.previewEdit());
+        this.editorSaveBtn.addEventListener('click', () => this.saveEdit());
+        this.editorText.addEventListener('input', () => {
+            this.renderGutter();
+            this.scheduleValidation();
+        });
+        this.editorText.addEventListener('scroll', () => {
+            this.editorGutter.scrollTop = this.editorText.scrollTop;
+        });
+        this.editorText.addEventListener('keydown', (e) => {
+            // Indent with spaces rather than moving focus
+            if (e.key === 'Tab') {
+                e.preventDefault();
+                document.execCommand('insertText', false, '  ');
+            }
+        });
         
         // Compare mode
         this.compareModeBtn.addEventListener('click', () => this.toggleCompareMode());
@@ -90,15 +316,19 @@ class ToggleVault {
         }
     }
     
+    // loadFiles loads every file, whatever its status, since deleted and
+    // archived files are still opened from search results, activity and the
+    // deleted files list; the sidebar filters them with the status select
     async loadFiles() {
         this.fileTree.innerHTML = '<div class="loading">Loading files...</div>';
         
         try {
-            const response = await fetch('/api/files');
+            const response = await fetch(`${BASE_PATH}/api/v1/files?status=all&sort=${this.fileSort.value}`);
             if (!response.ok) throw new Error('Failed to load files');
             
             this.files = await response.json();
             this.renderFileTree();
+            this.updateAccountOptions();
         } catch (error) {
             console.error('Error loading files:', error);
             this.fileTree.innerHTML = '<div class="loading">Error loading files</div>';
@@ -107,8 +337,10 @@ class ToggleVault {
     
     renderFileTree() {
         const searchTerm = this.searchInput.value.toLowerCase();
+        const selectors = this.labelSelectors();
         const filteredFiles = this.files.filter(file => 
-            file.blob_path.toLowerCase().includes(searchTerm)
+            file.blob_path.toLowerCase().includes(searchTerm) && this.matchesLabels(file.labels || {}, selectors) &&
+            this.matchesStatus(file)
         );
         
         this.fileCount.textContent = filteredFiles.length;
@@ -119,7 +351,7 @@ class ToggleVault {
         }
         
         this.fileTree.innerHTML = filteredFiles.map(file => `
-            <div class="file-item ${file.is_deleted ? 'deleted' : ''} ${this.selectedFile?.id === file.id ? 'active' : ''}"
+            <div class="file-item ${file.is_deleted ? 'deleted' : ''} ${file.is_archived ? 'archived' : ''} ${this.selectedFile?.id === file.id ? 'active' : ''}"
                  data-path="${this.escapeHtml(file.blob_path)}"
                  data-id="${file.id}">
                 <svg class="file-icon" viewBox="0 0 16 16" fill="currentColor">
@@ -140,10 +372,463 @@ class ToggleVault {
         });
     }
     
+    // matchesStatus reports whether a file has the status selected in the
+    // sidebar, as the status parameter of /api/files does
+    matchesStatus(file) {
+        switch (this.fileStatusFilter.value) {
+            case 'deleted': return file.is_deleted;
+            case 'archived': return file.is_archived && !file.is_deleted;
+            case 'all': return true;
+            default: return !file.is_deleted && !file.is_archived;
+        }
+    }
+    
     filterFiles() {
         this.renderFileTree();
     }
     
+    // labelSelectors parses the label filter into key=value or bare key selectors
+    labelSelectors() {
+        return this.filterLabels.value.split(',').map(s => s.trim()).filter(s => s);
+    }
+    
+    matchesLabels(labels, selectors) {
+        return selectors.every(selector => {
+            const [key, ...rest] = selector.split('=');
+            if (!(key in labels)) return false;
+            return rest.length === 0 || labels[key] === rest.join('=');
+        });
+    }
+    
+    // Search methods
+    
+    // splitPath splits a blob path (storageaccount/container/path) into its parts
+    splitPath(blobPath) {
+        const [account, container, ...rest] = blobPath.split('/');
+        return { account, container, path: rest.join('/') };
+    }
+    
+    updateAccountOptions() {
+        const accounts = [...new Set(this.files.map(f => this.splitPath(f.blob_path).account))].sort();
+        const selected = this.filterAccount.value;
+        
+        this.filterAccount.innerHTML = '<option value="">All storage accounts</option>' +
+            accounts.map(a => `<option value="${this.escapeHtml(a)}">${this.escapeHtml(a)}</option>`).join('');
+        this.filterAccount.value = selected;
+        this.updateContainerOptions();
+    }
+    
+    // loadChangeTypes fills the change type filter with the built-in and
+    // custom change types the server knows
+    async loadChangeTypes() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/change-types`);
+            if (!response.ok) throw new Error('Failed to load change types');
+            
+            const types = await response.json();
+            const selected = this.filterChangeType.value;
+            this.filterChangeType.innerHTML = '<option value="">All change types</option>' +
+                types.map(t => `<option value="${this.escapeHtml(t)}">${this.escapeHtml(t.charAt(0).toUpperCase() + t.slice(1))}</option>`).join('');
+            this.filterChangeType.value = selected;
+        } catch (error) {
+            console.error('Error loading change types:', error);
+        }
+    }
+    
+    updateContainerOptions() {
+        const account = this.filterAccount.value;
+        const containers = [...new Set(this.files
+            .map(f => this.splitPath(f.blob_path))
+            .filter(p => !account || p.account === account)
+            .map(p => p.container))].sort();
+        const selected = this.filterContainer.value;
+        
+        this.filterContainer.innerHTML = '<option value="">All containers</option>' +
+            containers.map(c => `<option value="${this.escapeHtml(c)}">${this.escapeHtml(c)}</option>`).join('');
+        this.filterContainer.value = containers.includes(selected) ? selected : '';
+    }
+    
+    // getSearchParams builds the query string shared by the search API and the page URL
+    getSearchParams() {
+        const params = new URLSearchParams();
+        const fields = {
+            q: this.searchInput.value.trim(),
+            storage_account: this.filterAccount.value,
+            container: this.filterContainer.value,
+            change_type: this.filterChangeType.value,
+            since: this.filterSince.value,
+            until: this.filterUntil.value,
+        };
+        
+        for (const [key, value] of Object.entries(fields)) {
+            if (value) params.set(key, value);
+        }
+        for (const selector of this.labelSelectors()) {
+            params.append('label', selector);
+        }
+        return params;
+    }
+    
+    applySearchFromURL() {
+        const params = new URLSearchParams(window.location.search);
+        
+        if (params.has('app')) {
+            this.openApp(params.get('app'), false);
+            return;
+        }
+        
+        this.searchInput.value = params.get('q') || '';
+        this.filterAccount.value = params.get('storage_account') || '';
+        this.updateContainerOptions();
+        this.filterContainer.value = params.get('container') || '';
+        this.filterChangeType.value = params.get('change_type') || '';
+        this.filterSince.value = params.get('since') || '';
+        this.filterUntil.value = params.get('until') || '';
+        this.filterLabels.value = params.getAll('label').join(', ');
+        this.renderFileTree();
+        
+        if ([...params.keys()].length > 0) {
+            this.runSearch(false);
+        } else if (this.searchView.style.display !== 'none' || this.appView.style.display !== 'none') {
+            this.showWelcomeView();
+        }
+    }
+    
+    async runSearch(pushState = true) {
+        const params = this.getSearchParams();
+        const query = params.toString();
+        
+        if (pushState) {
+            const url = query ? `?${query}` : window.location.pathname;
+            history.pushState(null, '', url);
+        }
+        
+        this.showSearchView();
+        this.searchResultsList.innerHTML = '<div class="loading">Searching...</div>';
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/search?${query}`);
+            if (!response.ok) throw new Error('Failed to search changes');
+            
+            this.searchResults = await response.json();
+            this.renderSearchResults();
+        } catch (error) {
+            console.error('Error searching changes:', error);
+            this.searchResultsList.innerHTML = '<div class="loading">Error searching changes</div>';
+        }
+    }
+    
+    renderSearchResults() {
+        const count = this.searchResults.length;
+        this.searchTitle.textContent = `${count} change${count === 1 ? '' : 's'} found`;
+        
+        if (count === 0) {
+            this.searchResultsList.innerHTML = '<div class="loading">No matching changes</div>';
+            return;
+        }
+        
+        this.renderChangeList(this.searchResultsList, this.searchResults);
+    }
+    
+    // renderChangeList renders change events (search results or activity) into a container
+    renderChangeList(container, changes, newIds = new Set()) {
+        container.innerHTML = changes.map(change => `
+            <div class="search-result ${newIds.has(change.version_id) ? 'new' : ''}"
+                 data-path="${this.escapeHtml(change.blob_path)}" data-version-id="${change.version_id}">
+                <span class="version-type ${change.change_type}">${change.change_type}</span>
+                <span class="search-result-path" title="${this.escapeHtml(change.blob_path)}">${this.escapeHtml(change.blob_path)}</span>
+                <span class="version-id">v${change.version_id}</span>
+                <span class="change-stats"></span>
+                <span class="search-result-time">${this.formatDate(change.captured_at)}</span>
+            </div>
+        `).join('');
+        
+        container.querySelectorAll('.search-result').forEach(item => {
+            item.addEventListener('click', async () => {
+                const file = this.files.find(f => f.blob_path === item.dataset.path);
+                if (!file) return;
+                await this.selectFile(file);
+                this.selectVersion(parseInt(item.dataset.versionId));
+            });
+        });
+        
+        this.loadChangeStats(container, changes);
+    }
+    
+    async loadChangeStats(container, changes) {
+        // Ask for the stats of all changes not seen before in one request
+        const missing = changes.filter(c => !this.changeStats.has(c.version_id)).slice(0, 100);
+        if (missing.length > 0) {
+            try {
+                const response = await fetch(`${BASE_PATH}/api/v1/diffs`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ diffs: missing.map(c => ({ path: c.blob_path, to: c.version_id })) })
+                });
+                if (!response.ok) throw new Error('Failed to load change stats');
+                
+                const summaries = await response.json();
+                summaries.forEach(s => this.changeStats.set(s.to, s));
+            } catch (error) {
+                console.error('Error loading change stats:', error);
+                return;
+            }
+        }
+        
+        container.querySelectorAll('.search-result').forEach(item => {
+            const summary = this.changeStats.get(parseInt(item.dataset.versionId));
+            const el = item.querySelector('.change-stats');
+            if (!summary || !summary.stats || !el) return;
+            el.innerHTML = `<span class="added">+${summary.stats.lines_added}</span> <span class="removed">-${summary.stats.lines_removed}</span>`;
+        });
+    }
+    
+    // Application methods
+    
+    async openApp(name, pushState = true) {
+        this.showAppView();
+        this.appFiles.innerHTML = '<div class="loading">Loading application...</div>';
+        this.appActivity.innerHTML = '';
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/apps`);
+            if (!response.ok) throw new Error('Failed to load applications');
+            
+            const apps = await response.json();
+            if (apps.length === 0) {
+                this.appTitle.textContent = 'Applications';
+                this.appSelect.style.display = 'none';
+                this.appDescription.textContent = 'No applications are configured. Group related files under "applications" in the configuration.';
+                this.appFiles.innerHTML = '';
+                return;
+            }
+            
+            const app = apps.find(a => a.name === name) || apps[0];
+            this.appSelect.style.display = '';
+            this.appSelect.innerHTML = apps.map(a =>
+                `<option value="${this.escapeHtml(a.name)}">${this.escapeHtml(a.name)} (${a.file_count})</option>`
+            ).join('');
+            this.appSelect.value = app.name;
+            
+            if (pushState) {
+                history.pushState(null, '', `?app=${encodeURIComponent(app.name)}`);
+            }
+            
+            await this.loadAppActivity(app.name);
+        } catch (error) {
+            console.error('Error loading applications:', error);
+            this.appFiles.innerHTML = '<div class="loading">Error loading applications</div>';
+        }
+    }
+    
+    async loadAppActivity(name) {
+        const response = await fetch(`${BASE_PATH}/api/v1/apps/${encodeURIComponent(name)}/activity`);
+        if (!response.ok) throw new Error('Failed to load application activity');
+        
+        const activity = await response.json();
+        this.appTitle.textContent = activity.name;
+        this.appDescription.textContent = activity.description || activity.paths.join(', ');
+        
+        if (activity.files.length === 0) {
+            this.appFiles.innerHTML = '<div class="loading">No tracked files match this application yet</div>';
+        } else {
+            this.appFiles.innerHTML = activity.files.map(file => `
+                <div class="search-result" data-path="${this.escapeHtml(file.blob_path)}">
+                    <span class="version-type ${file.is_deleted ? 'deleted' : (file.latest_change_type || '')}">${file.is_deleted ? 'deleted' : (file.latest_change_type || 'active')}</span>
+                    <span class="search-result-path" title="${this.escapeHtml(file.blob_path)}">${this.escapeHtml(file.blob_path)}</span>
+                    <span class="search-result-time">${file.version_count} version${file.version_count === 1 ? '' : 's'} · ${this.formatDate(file.latest_change)}</span>
+                </div>
+            `).join('');
+            
+            this.appFiles.querySelectorAll('.search-result').forEach(item => {
+                item.addEventListener('click', () => {
+                    const file = this.files.find(f => f.blob_path === item.dataset.path);
+                    if (file) this.selectFile(file);
+                });
+            });
+        }
+        
+        if (activity.changes.length === 0) {
+            this.appActivity.innerHTML = '<div class="loading">No changes recorded</div>';
+        } else {
+            this.renderChangeList(this.appActivity, activity.changes);
+        }
+    }
+    
+    // Live update methods
+    
+    async loadActivity() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/search?limit=50`);
+            if (!response.ok) throw new Error('Failed to load activity');
+            
+            this.activity = await response.json();
+            this.renderActivity();
+        } catch (error) {
+            console.error('Error loading activity:', error);
+            this.activityList.innerHTML = '<div class="loading">Error loading activity</div>';
+        }
+    }
+    
+    renderActivity(newIds) {
+        if (this.activity.length === 0) {
+            this.activityList.innerHTML = '<div class="loading">No changes recorded yet</div>';
+            return;
+        }
+        this.renderChangeList(this.activityList, this.activity, newIds);
+    }
+    
+    connectEvents() {
+        if (!window.EventSource) return;
+        
+        // EventSource reconnects automatically after errors
+        this.eventSource = new EventSource(`${BASE_PATH}/api/v1/events`);
+        
+        this.eventSource.addEventListener('open', () => this.setLiveStatus(true));
+        this.eventSource.addEventListener('error', () => this.setLiveStatus(false));
+        this.eventSource.addEventListener('change', (e) => this.handleChangeEvent(JSON.parse(e.data)));
+        this.eventSource.addEventListener('sync_complete', () => this.loadSyncStatus());
+        this.eventSource.addEventListener('proposal', () => {
+            this.loadProposalCount();
+            if (this.proposalsModal.style.display !== 'none') this.loadProposals();
+        });
+    }
+    
+    // loadSyncStatus shows the progress of a running backfill, polling until it completes
+    async loadSyncStatus() {
+        clearTimeout(this.syncStatusTimer);
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/sync/status`);
+            if (!response.ok) throw new Error('Failed to load sync status');
+            
+            const status = await response.json();
+            this.renderStaleBanner(status.accounts || []);
+            
+            const backfilling = status.running && status.phase === 'backfill';
+            this.syncProgress.style.display = backfilling ? '' : 'none';
+            if (!backfilling) return;
+            
+            const percent = status.blobs_listed ? Math.floor(status.processed * 100 / status.blobs_listed) : 0;
+            let text = status.blobs_listed
+                ? `Backfill ${status.processed}/${status.blobs_listed} (${percent}%)`
+                : 'Backfill: listing blobs...';
+            if (status.eta) text += ` · ETA ${new Date(status.eta).toLocaleTimeString()}`;
+            this.syncProgress.textContent = text;
+            
+            this.syncStatusTimer = setTimeout(() => this.loadSyncStatus(), 5000);
+        } catch (error) {
+            console.error('Error loading sync status:', error);
+        }
+    }
+    
+    // loadMaintenance warns that changes are rejected while the vault is in
+    // maintenance mode
+    async loadMaintenance() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/maintenance`);
+            if (!response.ok) throw new Error('Failed to load maintenance mode');
+            
+            const state = await response.json();
+            this.maintenanceBanner.style.display = state.enabled ? '' : 'none';
+            if (!state.enabled) return;
+            
+            let text = 'The vault is in maintenance mode: history can be browsed, but syncing is paused and changes are rejected.';
+            if (state.reason) text += ` Reason: ${state.reason}`;
+            this.maintenanceBanner.textContent = text;
+        } catch (error) {
+            console.error('Error loading maintenance mode:', error);
+        }
+    }
+    
+    // renderStaleBanner warns that the history of storage accounts that
+    // haven't been listed successfully for a while may be out of date
+    renderStaleBanner(accounts) {
+        const stale = accounts.filter(a => a.status === 'stale');
+        this.staleBanner.style.display = stale.length ? '' : 'none';
+        if (!stale.length) return;
+        
+        const describe = (a) => {
+            let text = a.storage_account;
+            if (a.auth_failed) text += ' (access denied)';
+            else if (a.last_success_at) text += ` (last synced ${this.formatDate(a.last_success_at)})`;
+            return text;
+        };
+        this.staleBanner.textContent = `History may be out of date for ${stale.map(describe).join(', ')}: it has not been synced successfully recently.`;
+        this.staleBanner.title = stale.map(a => `${a.storage_account}: ${a.consecutive_failures} failed listings. ${a.last_error || ''}`).join('\n');
+    }
+    
+    setLiveStatus(connected) {
+        this.liveStatus.textContent = connected ? 'Live' : 'Offline';
+        this.liveStatus.title = connected ? 'Receiving live updates' : 'Live updates disconnected';
+        this.liveStatus.classList.toggle('connected', connected);
+    }
+    
+    async handleChangeEvent(change) {
+        this.activity = [change, ...this.activity].slice(0, 50);
+        this.renderActivity(new Set([change.version_id]));
+        
+        // Refresh the file list without the loading placeholder
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files?status=all&sort=${this.fileSort.value}`);
+            if (response.ok) {
+                this.files = await response.json();
+                this.renderFileTree();
+                this.updateAccountOptions();
+            }
+        } catch (error) {
+            console.error('Error refreshing files:', error);
+        }
+        
+        // Inbox items are delivered asynchronously, so give the server a moment
+        if (this.watches.some(w => this.watchMatches(w, change.blob_path))) {
+            setTimeout(() => this.loadInboxCount(), 1000);
+        }
+        
+        // Refresh the open file's history if it was the one that changed
+        if (this.selectedFile && this.selectedFile.blob_path === change.blob_path) {
+            const file = this.files.find(f => f.blob_path === change.blob_path);
+            if (file) this.selectedFile = file;
+            await this.refreshVersions();
+        }
+    }
+    
+    async refreshVersions() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions`);
+            if (!response.ok) throw new Error('Failed to load versions');
+            
+            this.versions = await response.json();
+            this.renderVersions();
+        } catch (error) {
+            console.error('Error refreshing versions:', error);
+        }
+    }
+    
+    clearSearch() {
+        this.searchInput.value = '';
+        this.filterAccount.value = '';
+        this.updateContainerOptions();
+        this.filterChangeType.value = '';
+        this.filterSince.value = '';
+        this.filterUntil.value = '';
+        this.filterLabels.value = '';
+        this.renderFileTree();
+        
+        history.pushState(null, '', window.location.pathname);
+        this.showWelcomeView();
+    }
+    
+    async copySearchLink() {
+        try {
+            await navigator.clipboard.writeText(window.location.href);
+            this.copySearchLinkBtn.textContent = 'Copied!';
+            setTimeout(() => { this.copySearchLinkBtn.textContent = 'Copy Link'; }, 1500);
+        } catch (error) {
+            console.error('Error copying link:', error);
+        }
+    }
+    
     async selectFile(file) {
         this.selectedFile = file;
         this.selectedVersion = null;
@@ -153,19 +838,86 @@ class ToggleVault {
         this.showFileView();
         
         this.filePath.textContent = file.blob_path;
-        this.fileStatus.textContent = file.is_deleted ? 'Deleted' : (file.latest_change_type || 'Active');
-        this.fileStatus.className = `status-badge ${file.latest_change_type || ''}`;
+        this.fileStatus.textContent = file.is_archived ? (file.archived_by ? `Archived by ${file.archived_by}` : 'Archived') : file.is_deleted ? 'Deleted' : (file.latest_change_type || 'Active');
+        this.fileStatus.className = `status-badge ${file.is_archived ? 'archived' : (file.latest_change_type || '')}`;
+        this.updateWatchButton();
+        this.editBtn.style.display = this.isEditable(file) ? '' : 'none';
+        this.liveBtn.style.display = file.is_deleted ? 'none' : '';
+        this.undeleteBtn.style.display = file.is_deleted && !file.read_only ? '' : 'none';
+        this.archiveBtn.textContent = file.is_archived ? 'Unarchive' : 'Archive';
+        this.syncFileBtn.style.display = file.is_archived ? 'none' : '';
+        this.renderFileLabels(file.labels || {});
+        this.fileOwners.textContent = file.owners ? `Owners: ${file.owners.join(', ')}` : '';
+        if (file.read_only) this.fileOwners.textContent += `${file.owners ? ' · ' : ''}Read-only: ${file.read_only}`;
         
         // Load versions
         await this.loadVersions(file.blob_path);
     }
     
+    describeGap(version) {
+        const deletion = this.versions.find(v => v.id === version.deleted_version_id);
+        if (!deletion) return `Recreated after deletion v${version.deleted_version_id}`;
+        
+        const minutes = Math.round((new Date(version.captured_at) - new Date(deletion.captured_at)) / 60000);
+        let gap = `${minutes} min`;
+        if (minutes >= 2 * 24 * 60) gap = `${Math.round(minutes / (24 * 60))} days`;
+        else if (minutes >= 120) gap = `${Math.round(minutes / 60)} hours`;
+        return `Recreated after deletion v${deletion.id}, absent for ${gap}`;
+    }
+    
+    renderFileLabels(labels) {
+        this.fileLabels.innerHTML = Object.entries(labels).sort().map(([key, value]) => `
+            <span class="label-chip">${this.escapeHtml(key)}=${this.escapeHtml(value)}</span>
+        `).join('');
+    }
+    
+    async editLabels() {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before changing labels so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        const path = this.selectedFile.blob_path;
+        
+        try {
+            const current = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(path)}/labels`).then(r => r.json());
+            const derived = Object.entries(current.derived).map(([k, v]) => `${k}=${v}`).join(', ');
+            const input = prompt(
+                `Labels for "${path}" as key=value, comma-separated.` + (derived ? `\nFrom the configured rules: ${derived}` : ''),
+                Object.entries(current.set).map(([k, v]) => `${k}=${v}`).join(', ')
+            );
+            if (input === null) return;
+            
+            const labels = {};
+            for (const pair of input.split(',').map(s => s.trim()).filter(s => s)) {
+                const [key, ...rest] = pair.split('=');
+                labels[key.trim()] = rest.join('=').trim();
+            }
+            
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(path)}/labels`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                body: JSON.stringify({ labels })
+            });
+            const result = await response.json();
+            if (!response.ok) throw new Error(result.message || 'Failed to set labels');
+            
+            this.selectedFile.labels = result.labels;
+            const file = this.files.find(f => f.blob_path === path);
+            if (file) file.labels = result.labels;
+            this.renderFileLabels(result.labels);
+        } catch (error) {
+            console.error('Error setting labels:', error);
+            alert('Failed to set labels: ' + error.message);
+        }
+    }
+    
     async loadVersions(path) {
         this.versionsList.innerHTML = '<div class="loading">Loading versions...</div>';
         this.versionDetail.innerHTML = '<p class="hint">Select a version to view its contents</p>';
         
         try {
-            const response = await fetch(`/api/files/${encodeURIComponent(path)}/versions`);
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(path)}/versions`);
             if (!response.ok) throw new Error('Failed to load versions');
             
             this.versions = await response.json();
@@ -189,14 +941,18 @@ class ToggleVault {
                     <span class="version-type ${version.change_type}">${version.change_type}</span>
                     <span class="version-id">v${version.id}</span>
                 </div>
-                <div class="version-time">${this.formatDate(version.captured_at)}</div>
+                <div class="version-time">${this.formatDate(version.captured_at)}${version.author ? ` by ${this.escapeHtml(version.author)}` : ''}</div>
+                ${version.deleted_version_id ? `<div class="version-gap">${this.describeGap(version)}</div>` : ''}
+                ${version.comment ? `<div class="version-comment" title="${this.escapeHtml(version.comment)}">${this.escapeHtml(version.comment)}</div>` : ''}
                 <div class="version-actions">
                     <button class="btn btn-sm btn-secondary view-btn" data-id="${version.id}">View</button>
                     ${index < this.versions.length - 1 ? 
                         `<button class="btn btn-sm btn-secondary diff-prev-btn" data-id="${version.id}" data-prev-id="${this.versions[index + 1].id}" title="Compare with previous">↔ Prev</button>` : 
                         ''}
                     ${version.change_type !== 'deleted' ? 
-                        `<button class="btn btn-sm btn-primary restore-btn" data-id="${version.id}">Restore</button>` : 
+                        (this.selectedFile?.read_only ?
+                            `<button class="btn btn-sm btn-primary" disabled title="Read-only: ${this.escapeHtml(this.selectedFile.read_only)}">Restore</button>` :
+                            `<button class="btn btn-sm btn-primary restore-btn" data-id="${version.id}">Restore</button>`) : 
                         ''}
                 </div>
             </div>
@@ -240,9 +996,25 @@ class ToggleVault {
     }
     
     async selectVersion(id) {
-        const version = this.versions.find(v => v.id === id);
+        let version = this.versions.find(v => v.id === id);
         if (!version) return;
         
+        // Versions recorded by hash only are fetched from blob storage on first view
+        let contentError = null;
+        if (version.content_pending) {
+            try {
+                const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions/${id}`);
+                const data = await response.json();
+                if (!response.ok) throw new Error(data.message || 'Failed to load content');
+                
+                version = data;
+                this.versions = this.versions.map(v => v.id === id ? data : v);
+            } catch (error) {
+                console.error('Error loading version content:', error);
+                contentError = error.message;
+            }
+        }
+        
         this.selectedVersion = version;
         
         // Update selected state
@@ -269,53 +1041,239 @@ class ToggleVault {
                     <span class="version-meta-label">Content Hash:</span>
                     <span style="font-family: monospace; font-size: 0.75rem;">${version.content_hash || 'N/A'}</span>
                 </div>
+                ${version.author ? `
+                <div class="version-meta-item">
+                    <span class="version-meta-label">Author:</span>
+                    <span>${this.escapeHtml(version.author)}</span>
+                </div>` : ''}
+                <div class="version-meta-item">
+                    <span class="version-meta-label">Comment:</span>
+                    <span>${this.escapeHtml(version.comment) || '<span class="hint">None</span>'}</span>
+                    <button class="btn btn-sm btn-secondary" id="edit-comment-btn">${version.comment ? 'Edit' : 'Add'}</button>
+                </div>
+                ${version.snapshot_id ? `
+                <div class="version-meta-item">
+                    <span class="version-meta-label">Snapshot:</span>
+                    <span style="font-family: monospace; font-size: 0.75rem;">${this.escapeHtml(version.snapshot_id)}</span>
+                </div>` : ''}
+                ${version.truncated ? `
+                <div class="version-meta-item">
+                    <span class="truncated-marker">Truncated: showing the first ${version.content.length} characters of ${version.size} bytes</span>
+                </div>` : ''}
             </div>
-            <div class="version-content">${this.escapeHtml(version.content) || '(empty)'}</div>
+            <div class="version-content">${contentError ? this.escapeHtml(contentError) : (this.escapeHtml(version.content) || '(empty)')}</div>
         `;
+        
+        document.getElementById('edit-comment-btn').addEventListener('click', () => this.editVersionComment(version));
+        this.loadImpacts(version);
+        if (!contentError && version.content) this.loadPreview(version);
     }
     
-    async showDiff(v1, v2) {
+    // Highlights the content of a version as the language the server detects
+    // from it, rather than guessing from the file name
+    async loadPreview(version) {
         try {
-            const response = await fetch(`/api/files/${encodeURIComponent(this.selectedFile.blob_path)}/diff/${v1}/${v2}`);
-            if (!response.ok) throw new Error('Failed to load diff');
-            
-            const diff = await response.json();
-            diff.v1 = v1;
-            diff.v2 = v2;
-            this.currentDiff = diff;
-            
-            // Switch to diff view
-            this.fileView.style.display = 'none';
-            this.diffView.style.display = 'flex';
-            
-            // Update title
-            this.diffTitle.textContent = `Comparing v${v1} → v${v2}`;
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions/${version.id}/preview?format=json`);
+            if (!response.ok) return;
+            const preview = await response.json();
+            const content = this.versionDetail.querySelector('.version-content');
+            if (!preview.language || !content || this.selectedVersion?.id !== version.id) return;
             
-            // Render stats
-            this.diffStats.innerHTML = `
-                <span class="diff-stat added">+${diff.stats.lines_added} added</span>
-                <span class="diff-stat removed">-${diff.stats.lines_removed} removed</span>
-                <span class="diff-stat changed">${diff.stats.lines_changed} changed</span>
-            `;
+            content.innerHTML = preview.lines.map(line => line.tokens.map(token => token.class
+                ? `<span class="tok-${token.class}">${this.escapeHtml(token.text)}</span>`
+                : this.escapeHtml(token.text)).join('')).join('\n');
+            this.versionDetail.querySelector('.version-meta').insertAdjacentHTML('beforeend', `
+                <div class="version-meta-item">
+                    <span class="version-meta-label">Language:</span>
+                    <span>${this.escapeHtml(preview.language)}</span>
+                </div>`);
+        } catch (error) {
+            console.error('Error loading preview:', error);
+        }
+    }
+    
+    async loadImpacts(version) {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions/${version.id}/impact`);
+            if (!response.ok) return;
+            const impacts = await response.json();
+            const meta = this.versionDetail.querySelector('.version-meta');
+            if (!impacts.length || !meta || this.selectedVersion?.id !== version.id) return;
             
-            this.renderDiff(diff);
+            const format = (value, unit) => value === null ? 'no data' : `${+value.toPrecision(4)}${unit || ''}`;
+            meta.insertAdjacentHTML('beforeend', impacts.map(i => `
+                <div class="version-meta-item">
+                    <span class="version-meta-label">${this.escapeHtml(i.metric)}:</span>
+                    <span>${i.error
+                        ? `<span class="hint" title="${this.escapeHtml(i.error)}">not measured</span>`
+                        : `${this.escapeHtml(format(i.before, i.unit))} → ${this.escapeHtml(format(i.after, i.unit))} <span class="hint">${this.escapeHtml(i.window)} after this change</span>`}</span>
+                </div>`).join(''));
         } catch (error) {
-            console.error('Error loading diff:', error);
-            this.diffContent.innerHTML = '<div class="loading">Error loading diff</div>';
+            console.error('Error loading impact:', error);
         }
     }
     
-    renderDiff(diff) {
-        if (!diff.has_changes) {
+    async editVersionComment(version) {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before changing comments so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        const comment = prompt(`Comment for v${version.id}:`, version.comment || '');
+        if (comment === null) return;
+        
+        try {
+            const response = await fetch(
+                `${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions/${version.id}/comment`,
+                {
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                    body: JSON.stringify({ comment })
+                }
+            );
+            const data = await response.json();
+            if (!response.ok) throw new Error(data.message || 'Failed to save comment');
+            
+            this.versions = this.versions.map(v => v.id === version.id ? { ...v, comment: data.comment } : v);
+            this.renderVersions();
+            await this.selectVersion(version.id);
+        } catch (error) {
+            console.error('Error saving comment:', error);
+            alert('Failed to save comment: ' + error.message);
+        }
+    }
+    
+    async openLiveFile() {
+        if (!this.selectedFile) return;
+        
+        // Open the window before awaiting so popup blockers allow it
+        const win = window.open('', '_blank');
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/live-url`, { headers: this.userHeaders() });
+            const data = await response.json();
+            if (!response.ok) throw new Error(data.message || 'Failed to create link');
+            
+            if (win) {
+                win.opener = null;
+                win.location = data.url;
+            } else {
+                window.open(data.url, '_blank', 'noopener');
+            }
+        } catch (error) {
+            if (win) win.close();
+            console.error('Error opening live file:', error);
+            alert('Failed to open live file: ' + error.message);
+        }
+    }
+    
+    async downloadEvidence() {
+        if (!this.selectedFile) return;
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/evidence`);
+            if (!response.ok) {
+                const data = await response.json();
+                throw new Error(data.message || 'Failed to build bundle');
+            }
+            
+            // Save under the name the server suggests
+            const disposition = response.headers.get('Content-Disposition') || '';
+            const match = disposition.match(/filename="([^"]+)"/);
+            const link = document.createElement('a');
+            link.href = URL.createObjectURL(await response.blob());
+            link.download = match ? match[1] : 'evidence.tar.gz';
+            link.click();
+            URL.revokeObjectURL(link.href);
+        } catch (error) {
+            console.error('Error downloading evidence bundle:', error);
+            alert('Failed to download evidence bundle: ' + error.message);
+        }
+    }
+    
+    async showDiff(v1, v2) {
+        try {
+            const url = `${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/diff/${v1}/${v2}`;
+            let response = await fetch(url);
+            // Large diffs are computed in the background; ask again until ready
+            while (response.status === 202) {
+                this.diffContent.innerHTML = '<div class="loading">Computing diff of large versions...</div>';
+                const wait = parseInt(response.headers.get('Retry-After'), 10) || 2;
+                await new Promise(resolve => setTimeout(resolve, wait * 1000));
+                response = await fetch(url);
+            }
+            if (response.status === 413) {
+                const data = await response.json();
+                this.fileView.style.display = 'none';
+                this.diffView.style.display = 'flex';
+                this.diffTitle.textContent = `Comparing v${v1} → v${v2}`;
+                this.diffStats.innerHTML = '';
+                this.diffContent.innerHTML = `<div class="loading">${this.escapeHtml(data.message)}<br>
+                    ${data.downloads.map((href, i) => `<a href="${href}">Download v${i === 0 ? v1 : v2}</a>`).join(' ')}</div>`;
+                return;
+            }
+            if (!response.ok) throw new Error('Failed to load diff');
+            
+            const diff = await response.json();
+            diff.v1 = v1;
+            diff.v2 = v2;
+            this.currentDiff = diff;
+            
+            // Switch to diff view
+            this.fileView.style.display = 'none';
+            this.diffView.style.display = 'flex';
+            
+            // Update title
+            this.diffTitle.textContent = `Comparing v${v1} → v${v2}`;
+            
+            // Render stats
+            this.diffStats.innerHTML = `
+                <span class="diff-stat added">+${diff.stats.lines_added} added</span>
+                <span class="diff-stat removed">-${diff.stats.lines_removed} removed</span>
+                <span class="diff-stat changed">${diff.stats.lines_changed} changed</span>
+                ${diff.truncated ? '<span class="truncated-marker">Compared excerpts only: file exceeds the size limit</span>' : ''}
+                ${diff.canonical ? '<span class="truncated-marker">Compared in canonical form: formatting is ignored</span>' : ''}
+            `;
+            
+            this.renderDiff(diff);
+        } catch (error) {
+            console.error('Error loading diff:', error);
+            this.diffContent.innerHTML = '<div class="loading">Error loading diff</div>';
+        }
+    }
+    
+    renderDiff(diff) {
+        if (!diff.has_changes) {
             this.diffContent.innerHTML = '<div class="loading">No changes between these versions</div>';
             return;
         }
         
-        if (this.diffMode === 'split') {
+        if (diff.encrypted) {
+            this.diffContent.innerHTML = '<div class="loading">Encrypted file: the ciphertext changed and is not compared line by line</div>';
+        } else if (this.diffMode === 'split') {
             this.renderSplitDiff(diff);
         } else {
             this.renderUnifiedDiff(diff);
         }
+        this.diffContent.insertAdjacentHTML('afterbegin', this.renderKeyChanges(diff.keys));
+    }
+    
+    // Settings changed between the versions, for formats diffed key by key
+    renderKeyChanges(keys) {
+        if (!keys || keys.length === 0) return '';
+        const rows = keys.map(change => {
+            // Values of encrypted files are left out
+            const hasValues = change.old_value !== undefined || change.new_value !== undefined;
+            const value = !hasValues ? ''
+                : change.type === 'added' ? this.escapeHtml(change.new_value)
+                : change.type === 'removed' ? this.escapeHtml(change.old_value)
+                : `${this.escapeHtml(change.old_value)} → ${this.escapeHtml(change.new_value)}`;
+            return `<tr class="key-change ${change.type}">
+                <td class="key-change-key">${this.escapeHtml(change.key)}</td>
+                <td>${change.type}</td>
+                <td class="key-change-value">${value}</td>
+            </tr>`;
+        }).join('');
+        return `<table class="key-changes">${rows}</table>`;
     }
     
     renderUnifiedDiff(diff) {
@@ -454,11 +1412,484 @@ class ToggleVault {
         this.showDiff(fromId, toId);
     }
     
+    // Subscription methods
+    async openSubscriptions() {
+        if (this.selectedFile && !this.subscriptionPrefix.value) {
+            this.subscriptionPrefix.value = this.selectedFile.blob_path;
+        }
+        this.subscriptionsModal.style.display = 'flex';
+        await this.loadSubscriptions();
+    }
+    
+    async loadSubscriptions() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/subscriptions`);
+            if (!response.ok) throw new Error('Failed to load subscriptions');
+            
+            const subs = await response.json();
+            if (subs.length === 0) {
+                this.subscriptionsList.innerHTML = '<div class="loading">No subscriptions yet</div>';
+                return;
+            }
+            
+            this.subscriptionsList.innerHTML = subs.map(sub => `
+                <div class="subscription-item">
+                    <span>${this.escapeHtml(sub.email)}</span>
+                    <span class="subscription-prefix">${this.escapeHtml(sub.path_prefix) || '(all files)'}</span>
+                    <span class="version-type">${sub.mode}</span>
+                    <button class="btn btn-sm btn-secondary unsubscribe-btn" data-id="${sub.id}">Remove</button>
+                </div>
+            `).join('');
+            
+            this.subscriptionsList.querySelectorAll('.unsubscribe-btn').forEach(btn => {
+                btn.addEventListener('click', () => this.deleteSubscription(parseInt(btn.dataset.id)));
+            });
+        } catch (error) {
+            console.error('Error loading subscriptions:', error);
+            this.subscriptionsList.innerHTML = '<div class="loading">Error loading subscriptions</div>';
+        }
+    }
+    
+    async createSubscription() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/subscriptions`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                body: JSON.stringify({
+                    email: this.subscriptionEmail.value,
+                    path_prefix: this.subscriptionPrefix.value,
+                    mode: this.subscriptionMode.value,
+                }),
+            });
+            if (!response.ok) {
+                const error = await response.json();
+                throw new Error(error.message || 'Failed to subscribe');
+            }
+            
+            this.subscriptionPrefix.value = '';
+            await this.loadSubscriptions();
+        } catch (error) {
+            console.error('Error creating subscription:', error);
+            alert('Failed to subscribe: ' + error.message);
+        }
+    }
+    
+    async deleteSubscription(id) {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/subscriptions/${id}`, { method: 'DELETE', headers: this.userHeaders() });
+            if (!response.ok) throw new Error('Failed to remove subscription');
+            await this.loadSubscriptions();
+        } catch (error) {
+            console.error('Error deleting subscription:', error);
+            alert('Failed to remove subscription: ' + error.message);
+        }
+    }
+    
+    // Tracking rule methods
+    async openRules() {
+        this.rulesModal.style.display = 'flex';
+        await this.loadRules();
+    }
+    
+    async loadRules() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/rules`);
+            if (!response.ok) throw new Error('Failed to load tracking rules');
+            
+            const rules = await response.json();
+            if (rules.length === 0) {
+                this.rulesList.innerHTML = '<div class="loading">No tracking rules yet</div>';
+                return;
+            }
+            
+            this.rulesList.innerHTML = rules.map(rule => `
+                <div class="subscription-item">
+                    <span>${this.escapeHtml(`${rule.storage_account}/${rule.container}/${rule.prefix}`)}</span>
+                    <span class="subscription-prefix">${this.escapeHtml((rule.patterns || []).join(', ')) || '(sync patterns)'}</span>
+                    <span class="version-type">${this.escapeHtml(rule.created_by || '')}</span>
+                    <button class="btn btn-sm btn-secondary delete-rule-btn" data-id="${rule.id}">Remove</button>
+                </div>
+            `).join('');
+            
+            this.rulesList.querySelectorAll('.delete-rule-btn').forEach(btn => {
+                btn.addEventListener('click', () => this.deleteRule(parseInt(btn.dataset.id)));
+            });
+        } catch (error) {
+            console.error('Error loading tracking rules:', error);
+            this.rulesList.innerHTML = '<div class="loading">Error loading tracking rules</div>';
+        }
+    }
+    
+    async createRule() {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before changing tracking rules so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/rules`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                body: JSON.stringify({
+                    storage_account: this.ruleAccount.value,
+                    container: this.ruleContainer.value,
+                    prefix: this.rulePrefix.value,
+                    patterns: this.rulePatterns.value.split(',').map(p => p.trim()).filter(p => p),
+                }),
+            });
+            if (!response.ok) {
+                const error = await response.json();
+                throw new Error(error.message || 'Failed to add tracking rule');
+            }
+            
+            this.ruleContainer.value = '';
+            this.rulePrefix.value = '';
+            this.rulePatterns.value = '';
+            await this.loadRules();
+        } catch (error) {
+            console.error('Error creating tracking rule:', error);
+            alert('Failed to add tracking rule: ' + error.message);
+        }
+    }
+    
+    async deleteRule(id) {
+        if (!confirm('Stop tracking these files? Files only this rule tracks are recorded as deleted on the next sync.')) return;
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/rules/${id}`, { method: 'DELETE', headers: this.userHeaders() });
+            if (!response.ok) throw new Error('Failed to remove tracking rule');
+            await this.loadRules();
+        } catch (error) {
+            console.error('Error deleting tracking rule:', error);
+            alert('Failed to remove tracking rule: ' + error.message);
+        }
+    }
+    
+    // Deleted file methods
+    async openDeleted() {
+        this.deletedModal.style.display = 'flex';
+        await this.loadDeleted();
+    }
+    
+    async loadDeleted() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/deleted`);
+            if (!response.ok) throw new Error('Failed to load deleted files');
+            
+            const files = await response.json();
+            if (files.length === 0) {
+                this.deletedList.innerHTML = '<div class="loading">No deleted files</div>';
+                return;
+            }
+            
+            this.deletedList.innerHTML = files.map(file => `
+                <div class="subscription-item">
+                    <span class="deleted-path" data-path="${this.escapeHtml(file.blob_path)}" title="${this.escapeHtml(file.blob_path)}">${this.escapeHtml(file.blob_path)}</span>
+                    <span class="version-type">${this.formatDate(file.deleted_at)}</span>
+                    <button class="btn btn-sm btn-secondary undelete-file-btn" data-path="${this.escapeHtml(file.blob_path)}" ${file.last_version_id ? '' : 'disabled'}>Restore</button>
+                </div>
+            `).join('');
+            
+            this.deletedList.querySelectorAll('.deleted-path').forEach(item => {
+                item.addEventListener('click', () => {
+                    const file = this.files.find(f => f.blob_path === item.dataset.path);
+                    if (!file) return;
+                    this.deletedModal.style.display = 'none';
+                    this.selectFile(file);
+                });
+            });
+            this.deletedList.querySelectorAll('.undelete-file-btn').forEach(btn => {
+                btn.addEventListener('click', () => this.undeleteFile(btn.dataset.path));
+            });
+        } catch (error) {
+            console.error('Error loading deleted files:', error);
+            this.deletedList.innerHTML = '<div class="loading">Error loading deleted files</div>';
+        }
+    }
+    
+    async undeleteFile(path) {
+        const comment = prompt(`Restore "${path}" from deletion? Add an optional comment:`, '');
+        if (comment === null) return;
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(path)}/undelete`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                body: JSON.stringify({ comment: comment.trim() })
+            });
+            
+            const result = await response.json();
+            if (!response.ok) throw new Error(result.message || 'Failed to restore file');
+            
+            if (response.status === 202) {
+                alert(result.pull_request_url
+                    ? `This file is reviewed on GitHub. The restore was submitted as ${result.pull_request_url}`
+                    : `This file needs approval. The restore was submitted as proposal #${result.id}.`);
+                return;
+            }
+            
+            await this.loadFiles();
+            if (this.deletedModal.style.display !== 'none') await this.loadDeleted();
+            if (this.selectedFile && this.selectedFile.blob_path === path) {
+                const file = this.files.find(f => f.blob_path === path);
+                if (file) await this.selectFile(file);
+            }
+        } catch (error) {
+            console.error('Error restoring deleted file:', error);
+            alert('Failed to restore file: ' + error.message);
+        }
+    }
+    
+    async toggleArchived(file) {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before archiving files so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        const action = file.is_archived ? 'unarchive' : 'archive';
+        if (!file.is_archived && !confirm(`Archive "${file.blob_path}"? Its history is kept, but it will no longer be synced.`)) return;
+        
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(file.blob_path)}/${action}`, {
+                method: 'POST',
+                headers: this.userHeaders()
+            });
+            
+            const result = await response.json();
+            if (!response.ok) throw new Error(result.message || `Failed to ${action} file`);
+            
+            await this.loadFiles();
+            const updated = this.files.find(f => f.blob_path === file.blob_path);
+            if (updated) await this.selectFile(updated);
+        } catch (error) {
+            console.error(`Error trying to ${action} file:`, error);
+            alert(`Failed to ${action} file: ` + error.message);
+        }
+    }
+    
+    async syncFile(file) {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before syncing files.');
+            this.openInbox();
+            return;
+        }
+        this.syncFileBtn.disabled = true;
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(file.blob_path)}/sync`, {
+                method: 'POST',
+                headers: this.userHeaders()
+            });
+            
+            const result = await response.json();
+            if (!response.ok) throw new Error(result.message || 'Failed to sync file');
+            
+            if (!result.changed) {
+                alert('No change since the last sync.');
+                return;
+            }
+            await this.loadFiles();
+            const updated = this.files.find(f => f.blob_path === file.blob_path);
+            if (updated) await this.selectFile(updated);
+        } catch (error) {
+            console.error('Error syncing file:', error);
+            alert('Failed to sync file: ' + error.message);
+        } finally {
+            this.syncFileBtn.disabled = false;
+        }
+    }
+    
+    // Watch and inbox methods
+    
+    // meFetch calls a per-user endpoint, identifying the user with the
+    // API key entered in the browser unless an SSO proxy already does so
+    meFetch(path, options = {}) {
+        const headers = { ...(options.headers || {}), ...this.userHeaders() };
+        return fetch(`${BASE_PATH}/api/v1/me${path}`, { ...options, headers });
+    }
+    
+    // userHeaders returns the API key header for the key entered in the browser
+    userHeaders() {
+        const apiKey = localStorage.getItem('toggleVault.apiKey');
+        return apiKey ? { 'X-API-Key': apiKey } : {};
+    }
+    
+    async loadIdentity() {
+        try {
+            const response = await this.meFetch('/');
+            // A revoked or mistyped key is asked for again
+            if (response.status === 401) localStorage.removeItem('toggleVault.apiKey');
+            if (!response.ok) throw new Error('Failed to load identity');
+            
+            const me = await response.json();
+            this.user = me.user || '';
+        } catch (error) {
+            console.error('Error loading identity:', error);
+            this.user = '';
+        }
+        
+        if (this.user) {
+            await Promise.all([this.loadWatches(), this.loadInboxCount()]);
+        }
+    }
+    
+    watchMatches(watch, blobPath) {
+        return watch.exact ? blobPath === watch.path : blobPath.startsWith(watch.path);
+    }
+    
+    async loadWatches() {
+        try {
+            const response = await this.meFetch('/watches');
+            if (!response.ok) throw new Error('Failed to load watches');
+            this.watches = await response.json();
+        } catch (error) {
+            console.error('Error loading watches:', error);
+            this.watches = [];
+        }
+        this.updateWatchButton();
+        this.renderWatches();
+    }
+    
+    updateWatchButton() {
+        const watch = this.selectedFile && this.watches.find(w => w.exact && w.path === this.selectedFile.blob_path);
+        this.watchBtn.textContent = watch ? 'Unwatch' : 'Watch';
+        this.watchBtn.classList.toggle('active', !!watch);
+    }
+    
+    async toggleWatch() {
+        if (!this.selectedFile) return;
+        if (!this.user) {
+            this.openInbox();
+            return;
+        }
+        
+        const watch = this.watches.find(w => w.exact && w.path === this.selectedFile.blob_path);
+        if (watch) {
+            await this.deleteWatch(watch.id);
+        } else {
+            await this.createWatch(this.selectedFile.blob_path, true);
+        }
+    }
+    
+    async createWatch(path, exact) {
+        try {
+            const response = await this.meFetch('/watches', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ path, exact }),
+            });
+            if (!response.ok) {
+                const error = await response.json();
+                throw new Error(error.message || 'Failed to watch');
+            }
+            
+            this.watchPrefix.value = '';
+            await this.loadWatches();
+        } catch (error) {
+            console.error('Error creating watch:', error);
+            alert('Failed to watch: ' + error.message);
+        }
+    }
+    
+    async deleteWatch(id) {
+        try {
+            const response = await this.meFetch(`/watches/${id}`, { method: 'DELETE' });
+            if (!response.ok) throw new Error('Failed to remove watch');
+            await this.loadWatches();
+        } catch (error) {
+            console.error('Error deleting watch:', error);
+            alert('Failed to remove watch: ' + error.message);
+        }
+    }
+    
+    renderWatches() {
+        if (this.watches.length === 0) {
+            this.watchesList.innerHTML = '<div class="loading">Not watching anything yet</div>';
+            return;
+        }
+        
+        this.watchesList.innerHTML = this.watches.map(watch => `
+            <div class="subscription-item">
+                <span class="subscription-prefix">${this.escapeHtml(watch.path) || '(all files)'}</span>
+                <span class="version-type">${watch.exact ? 'file' : 'prefix'}</span>
+                <button class="btn btn-sm btn-secondary unwatch-btn" data-id="${watch.id}">Remove</button>
+            </div>
+        `).join('');
+        
+        this.watchesList.querySelectorAll('.unwatch-btn').forEach(btn => {
+            btn.addEventListener('click', () => this.deleteWatch(parseInt(btn.dataset.id)));
+        });
+    }
+    
+    async loadInboxCount() {
+        try {
+            const response = await this.meFetch('/inbox?unread=true');
+            if (!response.ok) throw new Error('Failed to load inbox');
+            
+            const unread = await response.json();
+            this.inboxCount.textContent = unread.length;
+            this.inboxCount.style.display = unread.length > 0 ? 'inline-block' : 'none';
+        } catch (error) {
+            console.error('Error loading inbox:', error);
+        }
+    }
+    
+    async openInbox() {
+        this.inboxModal.style.display = 'flex';
+        
+        if (!this.user) {
+            this.inboxUser.textContent = '';
+            this.identityForm.style.display = 'flex';
+            this.inboxBody.style.display = 'none';
+            this.inboxMarkReadBtn.style.display = 'none';
+            return;
+        }
+        
+        this.inboxUser.textContent = this.user;
+        this.identityForm.style.display = 'none';
+        this.inboxBody.style.display = 'block';
+        this.inboxMarkReadBtn.style.display = '';
+        this.renderWatches();
+        
+        try {
+            const response = await this.meFetch('/inbox');
+            if (!response.ok) throw new Error('Failed to load inbox');
+            
+            const items = await response.json();
+            if (items.length === 0) {
+                this.inboxList.innerHTML = '<div class="loading">No changes to watched files</div>';
+                return;
+            }
+            
+            const unreadIds = new Set(items.filter(item => !item.read_at).map(item => item.version_id));
+            this.renderChangeList(this.inboxList, items, unreadIds);
+            this.inboxList.querySelectorAll('.search-result').forEach(item => {
+                item.addEventListener('click', () => {
+                    this.inboxModal.style.display = 'none';
+                });
+            });
+        } catch (error) {
+            console.error('Error loading inbox:', error);
+            this.inboxList.innerHTML = '<div class="loading">Error loading inbox</div>';
+        }
+    }
+    
+    async markInboxRead() {
+        try {
+            const response = await this.meFetch('/inbox/read', { method: 'POST' });
+            if (!response.ok) throw new Error('Failed to mark inbox read');
+            await Promise.all([this.loadInboxCount(), this.openInbox()]);
+        } catch (error) {
+            console.error('Error marking inbox read:', error);
+        }
+    }
+    
     showRestoreModal(versionId) {
         const version = this.versions.find(v => v.id === versionId);
         if (!version) return;
         
         this.restoreMessage.textContent = `Are you sure you want to restore "${this.selectedFile.blob_path}" to version ${versionId}? This will overwrite the current file in blob storage.`;
+        this.restoreComment.value = '';
         this.restoreModal.style.display = 'flex';
         
         // Set up confirm handler
@@ -472,29 +1903,452 @@ class ToggleVault {
     async restoreVersion(versionId) {
         try {
             const response = await fetch(
-                `/api/files/${encodeURIComponent(this.selectedFile.blob_path)}/restore/${versionId}`,
-                { method: 'POST' }
+                `${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/restore/${versionId}`,
+                {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                    body: JSON.stringify({ comment: this.restoreComment.value.trim() })
+                }
             );
             
-            if (!response.ok) throw new Error('Failed to restore version');
-            
             const result = await response.json();
+            if (response.status === 409 && result.merge) {
+                // The blob changed since the last sync; offer to merge
+                this.closeRestoreModal();
+                this.showMergeModal(versionId, result.merge, this.restoreComment.value.trim());
+                return;
+            }
+            if (!response.ok) throw new Error(result.message || 'Failed to restore version');
+            
             console.log('Restore result:', result);
             
             this.closeRestoreModal();
             
-            // Refresh files after a short delay to allow syncer to pick up the change
-            setTimeout(() => this.loadFiles(), 1000);
+            if (response.status === 202) {
+                alert(result.pull_request_url
+                    ? `This file is reviewed on GitHub. The restore was submitted as ${result.pull_request_url}`
+                    : `This file needs approval. The restore was submitted as proposal #${result.id}.`);
+                return;
+            }
+            
+            // The restore is recorded right away, with the comment
+            this.loadFiles();
+            await this.loadVersions(this.selectedFile.blob_path);
+            if (result.new_version) await this.selectVersion(result.new_version);
         } catch (error) {
             console.error('Error restoring version:', error);
             alert('Failed to restore version: ' + error.message);
         }
     }
     
+    showMergeModal(versionId, merge, comment) {
+        this.mergeMessage.textContent = merge.conflicts > 0
+            ? `"${this.selectedFile.blob_path}" was changed in blob storage since the last sync. The restore of version ${versionId} conflicts with that change in ${merge.conflicts} place(s); resolve the conflict markers below before saving.`
+            : `"${this.selectedFile.blob_path}" was changed in blob storage since the last sync. The restore of version ${versionId} was merged with that change; review the result before saving.`;
+        this.mergeText.value = merge.content;
+        this.mergeComment.value = comment;
+        this.mergeModal.style.display = 'flex';
+        this.mergeSaveBtn.onclick = () => this.saveMerge(versionId, merge.live_etag);
+    }
+    
+    async saveMerge(versionId, liveETag) {
+        if (!this.user) {
+            alert('Enter your API key in the inbox before saving a merge so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        if (!this.mergeComment.value.trim()) {
+            alert('A change comment is required');
+            this.mergeComment.focus();
+            return;
+        }
+        try {
+            const response = await fetch(
+                `${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/restore/${versionId}/merge`,
+                {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                    body: JSON.stringify({
+                        content: this.mergeText.value,
+                        live_etag: liveETag,
+                        comment: this.mergeComment.value.trim()
+                    })
+                }
+            );
+            
+            const result = await response.json();
+            if (!response.ok) {
+                const issues = (result.issues || []).map(i => i.line ? `line ${i.line}: ${i.message}` : i.message);
+                throw new Error(issues.length ? issues.join('\n') : (result.message || 'Failed to save merge'));
+            }
+            
+            this.mergeModal.style.display = 'none';
+            
+            if (response.status === 202) {
+                alert(result.pull_request_url
+                    ? `This file is reviewed on GitHub. The restore was submitted as ${result.pull_request_url}`
+                    : `This file needs approval. The restore was submitted as proposal #${result.id}.`);
+                return;
+            }
+            
+            this.loadFiles();
+            await this.loadVersions(this.selectedFile.blob_path);
+            if (result.new_version) await this.selectVersion(result.new_version);
+        } catch (error) {
+            console.error('Error saving merge:', error);
+            alert('Failed to save merge: ' + error.message);
+        }
+    }
+    
+    // Approval methods
+    
+    async loadProposalCount() {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/proposals?status=pending`);
+            if (!response.ok) throw new Error('Failed to load proposals');
+            
+            const pending = await response.json();
+            this.proposalsCount.textContent = pending.length;
+            this.proposalsCount.style.display = pending.length > 0 ? 'inline-block' : 'none';
+        } catch (error) {
+            console.error('Error loading proposals:', error);
+        }
+    }
+    
+    openProposals() {
+        this.proposalsModal.style.display = 'flex';
+        this.loadProposals();
+    }
+    
+    async loadProposals() {
+        this.proposalDetail.style.display = 'none';
+        this.proposalsList.innerHTML = '<div class="loading">Loading proposals...</div>';
+        
+        try {
+            const status = this.proposalsStatus.value;
+            const response = await fetch(`${BASE_PATH}/api/v1/proposals${status ? `?status=${status}` : ''}`);
+            if (!response.ok) throw new Error('Failed to load proposals');
+            
+            const proposals = await response.json();
+            if (proposals.length === 0) {
+                this.proposalsList.innerHTML = '<div class="loading">No proposals</div>';
+                return;
+            }
+            
+            this.proposalsList.innerHTML = proposals.map(p => `
+                <div class="search-result" data-id="${p.id}">
+                    <span class="proposal-status ${p.status}">${p.status}</span>
+                    <span class="search-result-path" title="${this.escapeHtml(p.blob_path)}">${this.escapeHtml(p.blob_path)}</span>
+                    <span class="search-result-time">#${p.id} ${p.kind} by ${this.escapeHtml(p.author)} · ${this.formatDate(p.created_at)}</span>
+                </div>
+            `).join('');
+            
+            this.proposalsList.querySelectorAll('.search-result').forEach(item => {
+                item.addEventListener('click', () => this.showProposal(parseInt(item.dataset.id)));
+            });
+        } catch (error) {
+            console.error('Error loading proposals:', error);
+            this.proposalsList.innerHTML = '<div class="loading">Error loading proposals</div>';
+        }
+    }
+    
+    async showProposal(id) {
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/proposals/${id}`);
+            const p = await response.json();
+            if (!response.ok) throw new Error(p.message || 'Failed to load proposal');
+            
+            const lines = p.diff.has_changes
+                ? p.diff.lines.filter(line => line.type !== 'context').map(line => `
+                    <div class="diff-line ${line.type}">
+                        <span class="diff-line-sign">${line.type === 'added' ? '+' : '-'}</span>
+                        <span class="diff-line-content">${this.escapeHtml(line.content)}</span>
+                    </div>`).join('')
+                : '<div class="loading">No changes against the current content</div>';
+            
+            const pending = p.status === 'pending';
+            const own = p.author === this.user;
+            this.proposalDetail.innerHTML = `
+                <h4>#${p.id}: ${p.kind}${p.restore_version_id ? ` of v${p.restore_version_id}` : ''} by ${this.escapeHtml(p.author)}</h4>
+                ${p.comment ? `<p class="proposal-comment">${this.escapeHtml(p.comment)}</p>` : ''}
+                ${p.reviewer ? `<p class="proposal-comment">${p.status} by ${this.escapeHtml(p.reviewer)}${p.review_comment ? `: ${this.escapeHtml(p.review_comment)}` : ''}</p>` : ''}
+                ${p.error ? `<p class="proposal-error">${this.escapeHtml(p.error)}</p>` : ''}
+                ${p.pull_request_url ? `<p class="proposal-comment">Reviewed in <a href="${this.escapeHtml(p.pull_request_url)}" target="_blank" rel="noopener">pull request #${p.pull_request_number}</a></p>` : ''}
+                <div class="proposal-diff diff-unified">${lines}</div>
+                ${pending ? `
+                <div class="subscription-form">
+                    <input type="text" id="review-comment" class="search-input" placeholder="Review comment (optional)">
+                    ${own
+                        ? '<button class="btn btn-secondary btn-sm" data-action="withdraw">Withdraw</button>'
+                        : p.pull_request_url ? '' : `<button class="btn btn-primary btn-sm" data-action="approve">Approve &amp; Apply</button>
+                           <button class="btn btn-danger btn-sm" data-action="reject">Reject</button>`}
+                </div>` : ''}
+            `;
+            this.proposalDetail.style.display = 'block';
+            
+            this.proposalDetail.querySelectorAll('[data-action]').forEach(btn => {
+                btn.addEventListener('click', () => this.reviewProposal(p, btn.dataset.action));
+            });
+        } catch (error) {
+            console.error('Error loading proposal:', error);
+            alert('Failed to load proposal: ' + error.message);
+        }
+    }
+    
+    async reviewProposal(proposal, action) {
+        if (!this.user) {
+            this.proposalsModal.style.display = 'none';
+            this.openInbox();
+            return;
+        }
+        
+        const comment = document.getElementById('review-comment').value.trim();
+        try {
+            const response = await fetch(`${BASE_PATH}/api/v1/proposals/${proposal.id}/${action}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+                body: JSON.stringify({ comment })
+            });
+            const result = await response.json();
+            if (!response.ok) throw new Error(result.message || `Failed to ${action} proposal`);
+            
+            if (result.status === 'failed') {
+                alert(`The proposal could not be applied: ${result.error}`);
+            }
+            
+            await this.loadProposals();
+            this.loadProposalCount();
+            if (this.selectedFile && this.selectedFile.blob_path === proposal.blob_path) {
+                await this.loadVersions(proposal.blob_path);
+            }
+        } catch (error) {
+            console.error('Error reviewing proposal:', error);
+            alert(error.message);
+        }
+    }
+    
+    // Editor methods
+    
+    isEditable(file) {
+        return !file.is_deleted && !file.read_only && /\.(ya?ml|json|toml|ini|xml)$/i.test(file.blob_path);
+    }
+    
+    async openEditor() {
+        if (!this.selectedFile) return;
+        if (!this.user) {
+            alert('Enter your API key in the inbox before editing so the change can be attributed to you.');
+            this.openInbox();
+            return;
+        }
+        
+        const latest = this.versions[0];
+        if (!latest) return;
+        
+        let content = latest.content;
+        if (latest.content_pending || latest.truncated) {
+            try {
+                const response = await fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.selectedFile.blob_path)}/versions/${latest.id}`);
+                const data = await response.json();
+                if (!response.ok) throw new Error(data.message || 'Failed to load content');
+                if (data.truncated) throw new Error('The current version exceeds the size limit and cannot be edited here');
+                content = data.content;
+            } catch (error) {
+                console.error('Error loading content for edit:', error);
+                alert('Cannot edit: ' + error.message);
+                return;
+            }
+        }
+        
+        this.editor = { path: this.selectedFile.blob_path, baseVersionId: latest.id, original: content };
+        this.editorTitle.textContent = `Editing ${this.selectedFile.blob_path} (from v${latest.id})`;
+        this.editorText.value = content;
+        this.editorComment.value = '';
+        this.editorDiff.style.display = 'none';
+        this.renderIssues([]);
+        this.renderGutter();
+        this.setEditorStatus('');
+        
+        this.fileView.style.display = 'none';
+        this.editorView.style.display = 'flex';
+        this.editorText.focus();
+    }
+    
+    closeEditor() {
+        if (this.editor && this.editorText.value !== this.editor.original &&
+            !confirm('Discard your changes?')) {
+            return;
+        }
+        clearTimeout(this.validateTimer);
+        this.editor = null;
+        this.editorView.style.display = 'none';
+        this.fileView.style.display = 'flex';
+    }
+    
+    // editRequest sends the editor content to the upload-through API
+    editRequest(body) {
+        return fetch(`${BASE_PATH}/api/v1/files/${encodeURIComponent(this.editor.path)}/content`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json', ...this.userHeaders() },
+            body: JSON.stringify({
+                content: this.editorText.value,
+                base_version_id: this.editor.baseVersionId,
+                ...body
+            })
+        });
+    }
+    
+    scheduleValidation() {
+        clearTimeout(this.validateTimer);
+        this.validateTimer = setTimeout(() => this.validateEdit(), 400);
+    }
+    
+    async validateEdit() {
+        if (!this.editor) return null;
+        try {
+            const response = await this.editRequest({ preview: true });
+            const data = await response.json();
+            if (!response.ok) throw new Error(data.message || 'Validation failed');
+            
+            this.renderIssues(data.issues);
+            return data;
+        } catch (error) {
+            console.error('Error validating edit:', error);
+            this.setEditorStatus(error.message, 'error');
+            return null;
+        }
+    }
+    
+    async previewEdit() {
+        const preview = await this.validateEdit();
+        if (!preview) return;
+        
+        if (!preview.diff.has_changes) {
+            this.editorDiff.innerHTML = '<div class="loading">No changes</div>';
+        } else {
+            const lines = preview.diff.lines.map(line => {
+                const sign = line.type === 'added' ? '+' : (line.type === 'removed' ? '-' : ' ');
+                return `<div class="diff-line ${line.type}">
+                    <span class="diff-line-sign">${sign}</span>
+                    <span class="diff-line-content">${this.escapeHtml(line.content)}</span>
+                </div>`;
+            }).join('');
+            this.editorDiff.innerHTML = `<div class="diff-unified">${lines}</div>`;
+        }
+        this.editorDiff.style.display = 'block';
+    }
+    
+    async saveEdit() {
+        if (!this.editor) return;
+        
+        const comment = this.editorComment.value.trim();
+        if (!comment) {
+            this.setEditorStatus('A change comment is required', 'error');
+            this.editorComment.focus();
+            return;
+        }
+        if (this.editorText.value === this.editor.original) {
+            this.setEditorStatus('No changes to save', 'error');
+            return;
+        }
+        
+        this.editorSaveBtn.disabled = true;
+        this.setEditorStatus('Saving...');
+        try {
+            const response = await this.editRequest({ comment });
+            const data = await response.json();
+            
+            if (response.status === 422) {
+                this.renderIssues(data.issues);
+                this.setEditorStatus('Fix the errors before saving', 'error');
+                return;
+            }
+            if (!response.ok) throw new Error(data.message || 'Failed to save');
+            
+            if (response.status === 202) {
+                alert(data.pull_request_url
+                    ? `This file is reviewed on GitHub. Your change was submitted as ${data.pull_request_url}`
+                    : `This file needs approval. Your change was submitted as proposal #${data.id}.`);
+            }
+            
+            const path = this.editor.path;
+            this.editor = null;
+            this.editorView.style.display = 'none';
+            this.fileView.style.display = 'flex';
+            
+            await this.loadVersions(path);
+            if (data.id) await this.selectVersion(data.id);
+            this.loadFiles();
+        } catch (error) {
+            console.error('Error saving edit:', error);
+            this.setEditorStatus(error.message, 'error');
+        } finally {
+            this.editorSaveBtn.disabled = false;
+        }
+    }
+    
+    renderGutter() {
+        const count = this.editorText.value.split('\n').length;
+        const errorLines = new Set(this.editIssues.map(i => i.line));
+        let html = '';
+        for (let n = 1; n <= count; n++) {
+            html += `<div class="${errorLines.has(n) ? 'error' : ''}">${n}</div>`;
+        }
+        this.editorGutter.innerHTML = html;
+        this.editorGutter.scrollTop = this.editorText.scrollTop;
+    }
+    
+    renderIssues(issues) {
+        this.editIssues = issues || [];
+        this.editorIssues.innerHTML = this.editIssues.map(issue => `
+            <div class="editor-issue">
+                ${issue.line ? `<span class="editor-issue-line">Line ${issue.line}</span>` : ''}
+                <span>${this.escapeHtml(issue.message)}</span>
+            </div>
+        `).join('');
+        this.setEditorStatus(this.editIssues.length ? `${this.editIssues.length} problem(s)` : 'Valid',
+            this.editIssues.length ? 'error' : 'ok');
+        this.renderGutter();
+    }
+    
+    setEditorStatus(text, kind = '') {
+        this.editorStatus.textContent = text;
+        this.editorStatus.className = `editor-status ${kind}`;
+    }
+    
     showFileView() {
         this.welcomeView.style.display = 'none';
+        this.searchView.style.display = 'none';
         this.fileView.style.display = 'flex';
         this.diffView.style.display = 'none';
+        this.editorView.style.display = 'none';
+        this.appView.style.display = 'none';
+    }
+    
+    showSearchView() {
+        this.welcomeView.style.display = 'none';
+        this.searchView.style.display = 'flex';
+        this.fileView.style.display = 'none';
+        this.diffView.style.display = 'none';
+        this.editorView.style.display = 'none';
+        this.appView.style.display = 'none';
+    }
+    
+    showWelcomeView() {
+        this.welcomeView.style.display = 'flex';
+        this.searchView.style.display = 'none';
+        this.fileView.style.display = 'none';
+        this.diffView.style.display = 'none';
+        this.editorView.style.display = 'none';
+        this.appView.style.display = 'none';
+    }
+    
+    showAppView() {
+        this.welcomeView.style.display = 'none';
+        this.searchView.style.display = 'none';
+        this.fileView.style.display = 'none';
+        this.diffView.style.display = 'none';
+        this.editorView.style.display = 'none';
+        this.appView.style.display = 'flex';
     }
     
     formatDate(dateString) {